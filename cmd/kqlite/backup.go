@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// runBackup writes a consistent copy of a local database to a file.
+func runBackup(ctx context.Context, args []string) error {
+	fs := newFlagSet("backup", "-data-dir PATH -db NAME -o FILE")
+	dataDir := fs.String("data-dir", "", "data directory")
+	name := fs.String("db", "", "database name")
+	output := fs.String("o", "", "backup output file")
+	pages := fs.Int("step-pages", 100, "pages copied per backup step, 0 copies all pages at once")
+	pause := fs.Duration("step-pause", 10*time.Millisecond, "pause between backup steps")
+	fs.Parse(args)
+
+	path, err := databasePath(*dataDir, *name)
+	if err != nil {
+		return err
+	} else if *output == "" {
+		return configErrorf("required: -o FILE")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	// The online backup copies pages in paced steps, so writers are neither
+	// blocked by the backup nor able to starve it.
+	opts := sqlite.BackupOptions{PagesPerStep: *pages, Pause: *pause}
+	if err := sqlite.Backup(ctx, path, *output, opts); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+
+	fmt.Printf("database %s backed up to %s\n", *name, *output)
+	return nil
+}
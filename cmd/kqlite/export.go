@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Export and import formats.
+const (
+	formatSQL    = "sql"
+	formatSQLite = "sqlite"
+)
+
+// runExport exports a local database as a SQL script, a SQLite file or a table as CSV.
+func runExport(ctx context.Context, args []string) error {
+	fs := newFlagSet("export", "-data-dir PATH -db NAME -format sql|sqlite|csv [flags]")
+	dataDir := fs.String("data-dir", "", "data directory")
+	name := fs.String("db", "", "database name")
+	format := fs.String("format", formatSQL, "export format (sql, sqlite, csv)")
+	table := fs.String("table", "", "table to export, required by the csv format")
+	output := fs.String("o", "", "output file, defaults to stdout for sql and csv formats")
+	fs.Parse(args)
+
+	path, err := databasePath(*dataDir, *name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open(sqlite.DriverName, path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *format == formatSQLite {
+		if *output == "" {
+			return configErrorf("required: -o FILE")
+		}
+		_, err := db.ExecContext(ctx, `VACUUM INTO ?`, *output)
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case formatSQL:
+		return sqlite.Dump(ctx, db, w)
+	case formatCSV:
+		if *table == "" {
+			return configErrorf("required: -table NAME")
+		}
+		return exportCSV(ctx, db, *table, w)
+	}
+	return fmt.Errorf("unknown export format %q", *format)
+}
+
+// Writes the table rows as CSV with a header line.
+func exportCSV(ctx context.Context, db *sql.DB, table string, w io.Writer) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s", sqlite.QuoteIdent(table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(cols); err != nil {
+		return err
+	}
+
+	values := make([]sql.NullString, len(cols))
+	refs := make([]interface{}, len(cols))
+	for i := range refs {
+		refs[i] = &values[i]
+	}
+	record := make([]string, len(cols))
+	for rows.Next() {
+		if err := rows.Scan(refs...); err != nil {
+			return err
+		}
+		for i, value := range values {
+			record[i] = value.String
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// runImport seeds a local database from a SQL script, a SQLite file or CSV rows.
+func runImport(ctx context.Context, args []string) error {
+	fs := newFlagSet("import", "-data-dir PATH -db NAME -format sql|sqlite|csv -i FILE [flags]")
+	dataDir := fs.String("data-dir", "", "data directory")
+	name := fs.String("db", "", "database name")
+	format := fs.String("format", formatSQL, "import format (sql, sqlite, csv)")
+	table := fs.String("table", "", "table to import into, required by the csv format")
+	input := fs.String("i", "", "input file")
+	fs.Parse(args)
+
+	path, err := databasePath(*dataDir, *name)
+	if err != nil {
+		return err
+	} else if *input == "" {
+		return configErrorf("required: -i FILE")
+	}
+
+	if *format == formatSQLite {
+		// A SQLite file import is a restore into a new database.
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("database %s already exists", *name)
+		}
+		return runRestore(ctx, []string{"-data-dir", *dataDir, "-db", *name, "-i", *input})
+	}
+
+	db, err := sql.Open(sqlite.DriverName, path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	f, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch *format {
+	case formatSQL:
+		script, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		return importSQL(ctx, db, string(script))
+	case formatCSV:
+		if *table == "" {
+			return configErrorf("required: -table NAME")
+		}
+		return importCSV(ctx, db, *table, f)
+	}
+	return fmt.Errorf("unknown import format %q", *format)
+}
+
+// Executes a SQL script in a single transaction. PostgreSQL scripts are
+// rewritten per statement and session settings are skipped, other scripts
+// are handed to SQLite as they are. COPY data blocks are not supported,
+// pg_dump scripts have to be created with --inserts.
+func importSQL(ctx context.Context, db *sql.DB, script string) error {
+	stmts, err := parser.ClassifyQuery(script)
+	if err != nil {
+		_, err := db.ExecContext(ctx, script)
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	for _, stmt := range stmts {
+		switch stmt.Command {
+		case parser.CmdSet, parser.CmdReset, parser.CmdBegin, parser.CmdStart, parser.CmdCommit, "COMMENT":
+			continue
+		case parser.CmdSelect:
+			// pg_dump emits SELECT pg_catalog.set_config(...) calls.
+			if strings.Contains(stmt.SQL, "set_config(") {
+				continue
+			}
+		case "COPY":
+			return fmt.Errorf("COPY is not supported, dump with INSERT statements instead")
+		}
+		if _, err := tx.ExecContext(ctx, parser.RewriteQuery(stmt.SQL)); err != nil {
+			return fmt.Errorf("statement %d: %w", count+1, err)
+		}
+		count++
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d statements\n", count)
+	return nil
+}
+
+// Inserts CSV rows into an existing table, the first line holds the column names.
+func importCSV(ctx context.Context, db *sql.DB, table string, r io.Reader) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("csv header: %w", err)
+	}
+
+	cols := make([]string, len(header))
+	params := make([]string, len(header))
+	for i, col := range header {
+		cols[i] = sqlite.QuoteIdent(col)
+		params[i] = "?"
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		sqlite.QuoteIdent(table), strings.Join(cols, ", "), strings.Join(params, ", ")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var count int
+	args := make([]interface{}, len(header))
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		for i := range record {
+			args[i] = record[i]
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("csv line %d: %w", count+2, err)
+		}
+		count++
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d rows into %s\n", count, table)
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Exit codes of the kqlite CLI, invalid flags exit with exitConfig as the flag package does.
+const (
+	exitFailure = 1
+	exitConfig  = 2
+)
+
+// Signals shutting down the server and the proxy. SIGTERM is sent by Kubernetes and
+// systemd, a process running as PID 1 of a container ignores it unless handled.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// Error of the flags or configuration of a command, rather than a runtime failure.
+type configError struct {
+	error
+}
+
+func configErrorf(format string, args ...interface{}) error {
+	return configError{fmt.Errorf(format, args...)}
+}
+
+// Process files and shutdown drain of long running commands.
+type lifecycle struct {
+	pidFile    string
+	readyFile  string
+	drainDelay time.Duration
+	handedOff  bool // The files belong to the process of an upgrade.
+}
+
+func addLifecycleFlags(fs *flag.FlagSet) *lifecycle {
+	l := &lifecycle{}
+	fs.StringVar(&l.pidFile, "pid-file", "", "file the process ID is written to, removed on exit")
+	fs.StringVar(&l.readyFile, "ready-file", "", "file written once clients are accepted, removed when shutting down")
+	fs.DurationVar(&l.drainDelay, "drain-delay", 0, "delay between a shutdown signal and closing connections, e.g. until load balancers drop the node")
+	return l
+}
+
+// Writes the PID file.
+func (l *lifecycle) start() error {
+	if l.pidFile == "" {
+		return nil
+	}
+	if err := os.WriteFile(l.pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return configErrorf("pid file: %w", err)
+	}
+	return nil
+}
+
+// Writes the ready file with the address clients connect to.
+func (l *lifecycle) ready(addr string) error {
+	if l.readyFile == "" {
+		return nil
+	}
+	if err := os.WriteFile(l.readyFile, []byte(addr+"\n"), 0644); err != nil {
+		return configErrorf("ready file: %w", err)
+	}
+	return nil
+}
+
+// Removes the ready file then waits for the drain delay, connections are still served
+// meanwhile. Another shutdown signal ends the delay.
+func (l *lifecycle) drain() {
+	removeFile(l.readyFile)
+	if l.drainDelay <= 0 {
+		return
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, shutdownSignals...)
+	defer signal.Stop(sig)
+	log.Printf("draining for %s", l.drainDelay)
+	select {
+	case <-time.After(l.drainDelay):
+	case <-sig:
+		log.Printf("drain interrupted")
+	}
+}
+
+// Leaves the ready and PID files to the process of an upgrade, which writes them again.
+func (l *lifecycle) handOff() {
+	l.handedOff = true
+}
+
+// Removes the ready and PID files, unless handed off.
+func (l *lifecycle) stop() {
+	if l.handedOff {
+		return
+	}
+	removeFile(l.readyFile)
+	removeFile(l.pidFile)
+}
+
+func removeFile(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("cannot remove %s: %s", path, err)
+	}
+}
@@ -2,55 +2,92 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
-
-	"github.com/kqlite/kqlite/pkg/server"
+	"path/filepath"
+	"strings"
 )
 
+// Subcommand of the kqlite CLI.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"serve", "run the database server (default)", runServe},
+	{"proxy", "relay clients to another kqlite server without local storage", runProxy},
+	{"shell", "interactive SQL shell on a local database", runShell},
+	{"backup", "write a consistent copy of a local database to a file", runBackup},
+	{"restore", "restore a local database from a backup file", runRestore},
+	{"export", "export a local database as SQL, SQLite file or CSV", runExport},
+	{"import", "import SQL, SQLite file or CSV into a local database", runImport},
+	{"status", "report the status of a running server", runStatus},
+	{"migrate", "apply SQL migration files to a database of a running server", runMigrate},
+}
+
 func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), shutdownSignals...)
 	defer stop()
 
-	if err := run(ctx); err != nil {
+	if err := run(ctx, os.Args[1:]); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		if errors.As(err, new(configError)) {
+			os.Exit(exitConfig)
+		}
+		os.Exit(exitFailure)
 	}
 }
 
-func run(ctx context.Context) error {
-	addr := flag.String("addr", ":5432", "postgres protocol bind address")
-	dataDir := flag.String("data-dir", "", "data directory")
-	flag.Parse()
-
-	if *dataDir == "" {
-		return fmt.Errorf("required: -data-dir PATH")
+func run(ctx context.Context, args []string) error {
+	// Without a subcommand run the server, keeps the flat flags working.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(ctx, args)
 	}
 
-	log.SetFlags(0)
-
-	s := server.NewServer()
-	s.Addr = *addr
-	s.DataDir = *dataDir
-	if err := s.Open(); err != nil {
-		return err
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(ctx, args[1:])
+		}
 	}
-	defer s.Close()
 
-	log.Printf("listening on %s", s.Addr)
+	if args[0] != "help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	}
+	printUsage()
+	return nil
+}
 
-	// Wait on signal before shutting down.
-	<-ctx.Done()
-	log.Printf("SIGINT received, shutting down")
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: kqlite <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'kqlite <command> -h' for help on a command.\n")
+}
 
-	// Perform clean shutdown.
-	if err := s.Close(); err != nil {
-		return err
+// Creates the flag set of a subcommand with a usage header.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: kqlite %s %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
 	}
-	log.Printf("kqlite shutdown complete")
+	return fs
+}
 
-	return nil
+// Returns the path of a database file in the data directory.
+func databasePath(dataDir, name string) (string, error) {
+	if dataDir == "" {
+		return "", configErrorf("required: -data-dir PATH")
+	} else if name == "" {
+		return "", configErrorf("required: -db NAME")
+	} else if strings.Contains(name, "..") {
+		return "", configErrorf("invalid database name")
+	}
+	return filepath.Join(dataDir, name), nil
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/kqlite/kqlite/pkg/migrate"
+)
+
+// runMigrate applies the SQL migration files of a directory to a database of a running server.
+func runMigrate(ctx context.Context, args []string) error {
+	fs := newFlagSet("migrate", "-db NAME -dir PATH [flags]")
+	addr := fs.String("addr", "127.0.0.1:5432", "postgres protocol address of the server")
+	name := fs.String("db", "", "database name")
+	dir := fs.String("dir", "", "directory of the migration files, applied in the order of their names")
+	timeout := fs.Duration("timeout", 5*time.Second, "connection timeout")
+	fs.Parse(args)
+
+	if *name == "" {
+		return configErrorf("required: -db NAME")
+	} else if *dir == "" {
+		return configErrorf("required: -dir PATH")
+	}
+
+	migrations, err := migrate.Load(os.DirFS(*dir))
+	if err != nil {
+		return err
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	conn, err := pgconn.Connect(connectCtx, fmt.Sprintf("postgres://%s/%s?sslmode=disable", *addr, *name))
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	versions, err := migrate.Apply(ctx, conn, migrations)
+	for _, version := range versions {
+		fmt.Printf("applied %s\n", version)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is up to date: %d migrations, %d applied now\n", *name, len(migrations), len(versions))
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/proxy"
+)
+
+// runProxy relays clients to an upstream server until the context is canceled.
+func runProxy(ctx context.Context, args []string) error {
+	fs := newFlagSet("proxy", "[flags]")
+	addr := fs.String("addr", ":5432", "postgres protocol bind address")
+	upstream := fs.String("upstream", "", "postgres protocol address of the kqlite server sessions are relayed to")
+	databases := fs.String("databases", "", "comma separated databases clients may connect to, all if empty")
+	maxConns := fs.Int("max-conns", 0, "limit of client connections, 0 for no limit")
+	dialTimeout := fs.Duration("dial-timeout", 5*time.Second, "timeout of connections to the upstream server")
+	lc := addLifecycleFlags(fs)
+	fs.Parse(args)
+
+	if *upstream == "" {
+		return configErrorf("required: -upstream ADDR")
+	}
+
+	log.SetFlags(0)
+	if err := lc.start(); err != nil {
+		return err
+	}
+	defer lc.stop()
+
+	p := proxy.NewProxy()
+	p.Addr = *addr
+	p.Upstream = *upstream
+	p.MaxConns = *maxConns
+	p.DialTimeout = *dialTimeout
+	for _, name := range strings.Split(*databases, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			p.Databases = append(p.Databases, name)
+		}
+	}
+	if err := p.Open(); err != nil {
+		return err
+	}
+	defer p.Close()
+
+	log.Printf("proxy listening on %s, upstream %s", p.ListenerAddr(), p.Upstream)
+	if err := lc.ready(p.ListenerAddr().String()); err != nil {
+		return err
+	}
+
+	// Wait on signal before shutting down.
+	<-ctx.Done()
+	log.Printf("shutdown signal received")
+	lc.drain()
+
+	if err := p.Close(); err != nil {
+		return err
+	}
+	log.Printf("kqlite proxy shutdown complete")
+	return nil
+}
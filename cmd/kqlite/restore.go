@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// runRestore replaces a local database with the contents of a backup file.
+// The server must not be serving the database during a restore.
+func runRestore(ctx context.Context, args []string) error {
+	fs := newFlagSet("restore", "-data-dir PATH -db NAME -i FILE [flags]")
+	dataDir := fs.String("data-dir", "", "data directory")
+	name := fs.String("db", "", "database name")
+	input := fs.String("i", "", "backup input file")
+	force := fs.Bool("force", false, "overwrite an existing database")
+	fs.Parse(args)
+
+	path, err := databasePath(*dataDir, *name)
+	if err != nil {
+		return err
+	} else if *input == "" {
+		return configErrorf("required: -i FILE")
+	}
+
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("database %s already exists, use -force to overwrite", *name)
+	}
+
+	if err := checkDatabaseFile(ctx, *input); err != nil {
+		return fmt.Errorf("invalid backup file: %w", err)
+	}
+
+	// Copy to a temporary file first, so a failed restore leaves the database untouched.
+	tmpPath := path + ".restore"
+	if err := copyFile(*input, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Stale WAL files of the replaced database would corrupt the restored one.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	fmt.Printf("database %s restored from %s\n", *name, *input)
+	return nil
+}
+
+// Verifies that the file is an intact SQLite database.
+func checkDatabaseFile(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	db, err := sql.Open(sqlite.DriverName, "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA quick_check`).Scan(&result); err != nil {
+		return err
+	} else if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/gateway"
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+// runServe runs the database server until the context is canceled.
+func runServe(ctx context.Context, args []string) error {
+	fs := newFlagSet("serve", "[flags]")
+	addr := fs.String("addr", ":5432", "postgres protocol bind address")
+	unixSocket := fs.String("unix-socket", "", "directory of the postgres protocol unix socket, named .s.PGSQL.<port>")
+	dataDir := fs.String("data-dir", "", "data directory")
+	maxStmts := fs.Int("max-prepared-stmts", 0, "limit of named prepared statements per connection, 0 for no limit")
+	var listeners listenerFlags
+	fs.Var(&listeners, "listen", "additional bind address as ADDR or ADDR=DB[,DB...] to restrict its databases, can be repeated")
+	journalModes := journalModeFlags{}
+	fs.Var(journalModes, "journal-mode", "journal mode of a database as DB=MODE, can be repeated")
+	defaultJournalMode := fs.String("default-journal-mode", "", "journal mode of databases without -journal-mode, unchanged if empty")
+	groupCommitDelay := fs.Duration("group-commit-delay", 0, "max delay to sync commits of concurrent sessions together, WAL mode databases only, 0 disables")
+	retryAttempts := fs.Int("retry-attempts", 0, "max attempts of statements failing on locks of other connections, 0 or 1 disables retries")
+	retryBackoff := fs.Duration("retry-backoff", 10*time.Millisecond, "delay before the first retry, doubled after each attempt")
+	maxReads := fs.Int("max-reads", 0, "max concurrent read statements across all databases, 0 for no limit")
+	maxReadsPerDB := fs.Int("max-reads-per-db", 0, "max concurrent read statements per database, 0 for no limit")
+	readQueueTimeout := fs.Duration("read-queue-timeout", 0, "max wait of read statements over the limits, 0 to wait until canceled")
+	maxResultRows := fs.Int64("max-result-rows", 0, "default row limit of responses, sessions change it with SET kqlite.max_rows, 0 for no limit")
+	parallelReads := fs.Int("parallel-reads", 0, "max read-only queries of a multi-statement query executed concurrently, 0 or 1 executes them one by one")
+	changeNotifications := fs.Bool("change-notifications", false, "notify channel table_<name> of the keys of the rows of a table changed by each transaction")
+	maintenanceInterval := fs.Duration("maintenance-interval", 0, "interval between checks of databases due for ANALYZE and incremental vacuum, 0 disables")
+	maintenanceChurn := fs.Int64("maintenance-churn", 0, "rows changed in a database since its last maintenance to be due, 0 for any change")
+	maintenancePause := fs.Int64("maintenance-pause-queries", 0, "skip maintenance checks after more queries than this since the previous check, 0 never skips")
+	quotas := quotaFlags{}
+	fs.Var(quotas, "quota", "size quota of a database file and its WAL as DB=BYTES, 0 lifts the default quota, can be repeated")
+	defaultQuota := fs.Int64("default-quota", 0, "size quota in bytes of databases without -quota, 0 for no limit")
+	quotaWarningRatio := fs.Float64("quota-warning-ratio", 0.9, "fraction of its quota a database reaches to log a warning, 0 disables warnings")
+	rateLimits := rateLimitFlags{}
+	fs.Var(rateLimits, "rate-limit", "max statements per second of a database as DB=RATE, 0 lifts the default rate limit, can be repeated")
+	defaultRateLimit := fs.Float64("default-rate-limit", 0, "max statements per second of databases without -rate-limit, 0 for no limit")
+	lockWaitWarning := fs.Duration("lock-wait-warning", 0, "log sessions waiting longer than this for a write or advisory lock, 0 disables")
+	planCacheSize := fs.Int("plan-cache-size", 1024, "max parse results of queries cached and shared by sessions, 0 disables the cache")
+	initSQLDir := fs.String("init-sql", "", "directory of the .sql scripts run in name order on file databases when they're created")
+	trace := fs.Bool("trace", false, "log the messages and statements of all connections, sessions change it with SET kqlite.trace")
+	httpAddr := fs.String("http-addr", "", "bind address of the HTTP/JSON query gateway, POST /db/{name}/query, disabled if empty")
+	lc := addLifecycleFlags(fs)
+	upgradeTimeout := fs.Duration("upgrade-timeout", 30*time.Second, "max wait of an upgrade started by SIGUSR2 for the new process to get ready, then for sessions to drain, new connections wait to be accepted until drained")
+	faultInjection := fs.Bool("fault-injection", false, "allow sessions to inject replication faults with kqlite_replication_fault(), for testing only")
+	fs.Parse(args)
+
+	if *dataDir == "" {
+		return configErrorf("required: -data-dir PATH")
+	} else if info, err := os.Stat(*dataDir); err != nil || !info.IsDir() {
+		return configErrorf("data directory %s does not exist", *dataDir)
+	}
+
+	log.SetFlags(0)
+	if err := lc.start(); err != nil {
+		return err
+	}
+	defer lc.stop()
+
+	s := server.NewServer()
+	s.Addr = *addr
+	s.UnixSocketDir = *unixSocket
+	s.Listeners = listeners
+	if err := activateListeners(s); err != nil {
+		return err
+	}
+	g := gateway.NewGateway()
+	g.Addr = *httpAddr
+	upgradeReady, err := inheritListeners(s, g)
+	if err != nil {
+		return err
+	}
+	if upgradeReady != nil {
+		// The replaced server holds the data directory until its sessions are drained.
+		s.DataDirLockTimeout = *upgradeTimeout + upgradeCloseDelay
+	}
+	s.JournalModes = journalModes
+	s.DefaultJournalMode = *defaultJournalMode
+	s.DataDir = *dataDir
+	s.MaxPreparedStmts = *maxStmts
+	s.GroupCommitDelay = *groupCommitDelay
+	s.RetryAttempts = *retryAttempts
+	s.RetryBackoff = *retryBackoff
+	s.MaxReads = *maxReads
+	s.MaxReadsPerDatabase = *maxReadsPerDB
+	s.ReadQueueTimeout = *readQueueTimeout
+	s.ParallelReads = *parallelReads
+	s.MaxResultRows = *maxResultRows
+	s.ChangeNotifications = *changeNotifications
+	s.MaintenanceInterval = *maintenanceInterval
+	s.MaintenanceChurn = *maintenanceChurn
+	s.MaintenancePauseQueries = *maintenancePause
+	s.DatabaseQuotas = quotas
+	s.DefaultDatabaseQuota = *defaultQuota
+	s.QuotaWarningRatio = *quotaWarningRatio
+	s.DatabaseRateLimits = rateLimits
+	s.DefaultDatabaseRateLimit = *defaultRateLimit
+	s.LockWaitWarning = *lockWaitWarning
+	s.InitSQLDir = *initSQLDir
+	s.PlanCacheSize = *planCacheSize
+	s.Trace = *trace
+	s.FaultInjection = *faultInjection
+	// The gateway and the notification of the replaced server come last before the
+	// server takes the data directory, once nothing else can fail.
+	s.BeforeLock = func() error {
+		if *httpAddr != "" {
+			g.Upstream = s.ListenerAddr().String()
+			if err := g.Open(); err != nil {
+				return err
+			}
+		}
+		return notifyUpgradeReady(upgradeReady)
+	}
+	if err := s.Open(); err != nil {
+		g.Close()
+		return err
+	}
+	defer s.Close()
+	defer g.Close()
+
+	addrs := s.ListenerAddrs()
+	log.Printf("listening on %s", addrs[0])
+	if path := s.UnixSocketPath(); path != "" {
+		log.Printf("listening on unix socket %s", path)
+	}
+	for _, addr := range addrs[len(addrs)-len(s.Listeners):] {
+		log.Printf("listening on %s", addr)
+	}
+	if *httpAddr != "" {
+		log.Printf("gateway listening on %s", g.ListenerAddr())
+	}
+	if err := lc.ready(s.ListenerAddr().String()); err != nil {
+		return err
+	}
+
+	// Wait on signal before shutting down, or for an upgrade to take over.
+	if waitShutdown(ctx, s, g, *upgradeTimeout) {
+		lc.handOff()
+		if err := g.Close(); err != nil {
+			return err
+		}
+		drainCtx, cancel := context.WithTimeout(ctx, *upgradeTimeout)
+		defer cancel()
+		if err := s.Shutdown(drainCtx); err != nil {
+			return err
+		}
+		log.Printf("kqlite upgrade complete")
+		return nil
+	}
+	log.Printf("shutdown signal received")
+	lc.drain()
+
+	// Perform clean shutdown.
+	if err := g.Close(); err != nil {
+		return err
+	}
+	if err := s.Close(); err != nil {
+		return err
+	}
+	log.Printf("kqlite shutdown complete")
+
+	return nil
+}
+
+// Serves the sockets passed by systemd socket activation, if any. The first socket is
+// served as -addr, the next ones as the -listen addresses in order, others serve all
+// databases. Activated sockets outlive restarts of the server.
+func activateListeners(s *server.Server) error {
+	activated, err := activationListeners()
+	if err != nil || len(activated) == 0 {
+		return err
+	}
+	s.Listener = activated[0]
+	for i, ln := range activated[1:] {
+		if i < len(s.Listeners) {
+			s.Listeners[i].Listener = ln
+		} else {
+			s.Listeners = append(s.Listeners, server.ListenerConfig{Addr: ln.Addr().String(), Listener: ln})
+		}
+	}
+	log.Printf("socket activation: %d listeners", len(activated))
+	return nil
+}
+
+// Repeatable -listen flag, ADDR or ADDR=DB[,DB...].
+type listenerFlags []server.ListenerConfig
+
+func (f *listenerFlags) String() string {
+	var values []string
+	for _, l := range *f {
+		values = append(values, l.Addr)
+	}
+	return strings.Join(values, " ")
+}
+
+func (f *listenerFlags) Set(value string) error {
+	addr, databases, restricted := strings.Cut(value, "=")
+	if addr == "" {
+		return fmt.Errorf("missing address")
+	}
+	config := server.ListenerConfig{Addr: addr}
+	if restricted {
+		for _, name := range strings.Split(databases, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config.Databases = append(config.Databases, name)
+			}
+		}
+		if len(config.Databases) == 0 {
+			return fmt.Errorf("missing databases of %s", addr)
+		}
+	}
+	*f = append(*f, config)
+	return nil
+}
+
+// Repeatable -journal-mode flag, DB=MODE.
+type journalModeFlags map[string]string
+
+func (f journalModeFlags) String() string {
+	var values []string
+	for name, mode := range f {
+		values = append(values, name+"="+mode)
+	}
+	return strings.Join(values, " ")
+}
+
+func (f journalModeFlags) Set(value string) error {
+	name, mode, ok := strings.Cut(value, "=")
+	if !ok || name == "" || mode == "" {
+		return fmt.Errorf("expected DB=MODE")
+	}
+	f[name] = strings.ToLower(mode)
+	return nil
+}
+
+// Repeatable -quota flag, DB=BYTES.
+type quotaFlags map[string]int64
+
+func (f quotaFlags) String() string {
+	var values []string
+	for name, quota := range f {
+		values = append(values, fmt.Sprintf("%s=%d", name, quota))
+	}
+	return strings.Join(values, " ")
+}
+
+func (f quotaFlags) Set(value string) error {
+	name, bytes, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected DB=BYTES")
+	}
+	quota, err := strconv.ParseInt(bytes, 10, 64)
+	if err != nil || quota < 0 {
+		return fmt.Errorf("expected DB=BYTES")
+	}
+	f[name] = quota
+	return nil
+}
+
+// Repeatable -rate-limit flag, DB=RATE.
+type rateLimitFlags map[string]float64
+
+func (f rateLimitFlags) String() string {
+	var values []string
+	for name, limit := range f {
+		values = append(values, fmt.Sprintf("%s=%g", name, limit))
+	}
+	return strings.Join(values, " ")
+}
+
+func (f rateLimitFlags) Set(value string) error {
+	name, rate, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected DB=RATE")
+	}
+	limit, err := strconv.ParseFloat(rate, 64)
+	if err != nil || limit < 0 {
+		return fmt.Errorf("expected DB=RATE")
+	}
+	f[name] = limit
+	return nil
+}
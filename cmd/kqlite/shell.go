@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/server"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Output formats supported by the shell.
+const (
+	formatAligned = "aligned"
+	formatCSV     = "csv"
+	formatJSON    = "json"
+)
+
+const shellHelp = `General
+  \q                     quit shell
+  \?                     show this help
+  \timing [on|off]       toggle timing of commands
+  \pset format FORMAT    set output format (aligned, csv, json)
+
+Informational
+  \d                     list tables, views and indexes
+  \dt                    list tables
+  \d NAME                describe table, view or index
+`
+
+type shell struct {
+	db     *sql.DB
+	lock   io.Closer // Lock of the data directory, held while the shell is open.
+	name   string
+	out    io.Writer
+	format string
+	timing bool
+}
+
+// runShell starts an interactive SQL shell on a local database, bypassing the network.
+func runShell(ctx context.Context, args []string) error {
+	fs := newFlagSet("shell", "-data-dir PATH -db NAME [flags]")
+	dataDir := fs.String("data-dir", "", "data directory")
+	name := fs.String("db", "", "database name")
+	format := fs.String("format", formatAligned, "output format (aligned, csv, json)")
+	fs.Parse(args)
+
+	sh, err := openShell(*dataDir, *name, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer sh.close()
+	if err := sh.setFormat(*format); err != nil {
+		return err
+	}
+	return sh.run(ctx, os.Stdin)
+}
+
+// Opens a shell on a database of the data directory. The shell holds the lock of the
+// data directory, it fails while a server has the database open.
+func openShell(dataDir, name string, out io.Writer) (*shell, error) {
+	path, err := databasePath(dataDir, name)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := server.LockDataDir(dataDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(sqlite.DriverName, path)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return &shell{db: db, lock: lock, name: name, out: out, format: formatAligned}, nil
+}
+
+// Closes the database and releases the lock of the data directory.
+func (sh *shell) close() error {
+	err := sh.db.Close()
+	if e := sh.lock.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Reads input line by line, executing meta commands immediately and
+// statements once terminated with a semicolon.
+func (sh *shell) run(ctx context.Context, in io.Reader) error {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	var query strings.Builder
+	for {
+		if query.Len() == 0 {
+			fmt.Fprintf(sh.out, "%s=> ", sh.name)
+		} else {
+			fmt.Fprintf(sh.out, "%s-> ", sh.name)
+		}
+
+		var line string
+		var ok bool
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(sh.out)
+			return nil
+		case line, ok = <-lines:
+			if !ok {
+				fmt.Fprintln(sh.out)
+				return nil
+			}
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if query.Len() == 0 && strings.HasPrefix(trimmed, `\`) {
+			if trimmed == `\q` {
+				return nil
+			}
+			if err := sh.metaCommand(ctx, trimmed); err != nil {
+				fmt.Fprintf(sh.out, "ERROR: %s\n", err)
+			}
+			continue
+		}
+
+		if query.Len() != 0 {
+			query.WriteString("\n")
+		}
+		query.WriteString(line)
+		if !strings.HasSuffix(trimmed, ";") {
+			continue
+		}
+
+		if err := sh.execQuery(ctx, query.String()); err != nil {
+			fmt.Fprintf(sh.out, "ERROR: %s\n", err)
+		}
+		query.Reset()
+	}
+}
+
+func (sh *shell) setFormat(format string) error {
+	switch format {
+	case formatAligned, formatCSV, formatJSON:
+		sh.format = format
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func (sh *shell) metaCommand(ctx context.Context, cmd string) error {
+	fields := strings.Fields(cmd)
+	switch fields[0] {
+	case `\?`:
+		fmt.Fprint(sh.out, shellHelp)
+	case `\timing`:
+		sh.timing = !sh.timing
+		if len(fields) > 1 {
+			sh.timing = fields[1] == "on"
+		}
+		if sh.timing {
+			fmt.Fprintln(sh.out, "Timing is on.")
+		} else {
+			fmt.Fprintln(sh.out, "Timing is off.")
+		}
+	case `\pset`:
+		if len(fields) != 3 || fields[1] != "format" {
+			return fmt.Errorf(`usage: \pset format FORMAT`)
+		}
+		if err := sh.setFormat(fields[2]); err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "Output format is %s.\n", sh.format)
+	case `\d`:
+		if len(fields) > 1 {
+			return sh.describe(ctx, fields[1])
+		}
+		return sh.query(ctx, `SELECT name AS "Name", type AS "Type", tbl_name AS "Table"
+			FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' ORDER BY type, name`)
+	case `\dt`:
+		return sh.query(ctx, `SELECT name AS "Name", type AS "Type"
+			FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	default:
+		return fmt.Errorf(`invalid command %s, try \? for help`, fields[0])
+	}
+	return nil
+}
+
+// Describes the columns of a table or view, or the columns of an index.
+func (sh *shell) describe(ctx context.Context, name string) error {
+	var kind string
+	err := sh.db.QueryRowContext(ctx, `SELECT type FROM sqlite_master WHERE name = ? COLLATE NOCASE`, name).Scan(&kind)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("did not find any relation named %q", name)
+	} else if err != nil {
+		return err
+	}
+	if kind == "index" {
+		return sh.query(ctx, `SELECT seqno AS "#", COALESCE(name, 'expression') AS "Column",
+			CASE desc WHEN 1 THEN 'desc' ELSE 'asc' END AS "Order"
+			FROM pragma_index_xinfo(?) WHERE key = 1`, name)
+	}
+	return sh.query(ctx, `SELECT cid AS "#", name AS "Column", type AS "Type",
+		CASE "notnull" WHEN 1 THEN 'not null' ELSE '' END AS "Nullable",
+		COALESCE(dflt_value, '') AS "Default"
+		FROM pragma_table_info(?)`, name)
+}
+
+// Executes all statements in the query, printing results in the current output format.
+func (sh *shell) execQuery(ctx context.Context, query string) error {
+	stmts, err := parser.ClassifyQuery(query)
+	if err != nil {
+		// Not a PostgreSQL statement, let SQLite handle it as it is.
+		stmts = []parser.StmtClass{{Command: parser.CmdSelect, ReturnsRows: true, SQL: query}}
+	}
+
+	for _, stmt := range stmts {
+		start := time.Now()
+		query := parser.RewriteQuery(stmt.SQL)
+		if stmt.ReturnsRows {
+			err = sh.query(ctx, query)
+		} else {
+			var result sql.Result
+			if result, err = sh.db.ExecContext(ctx, query); err == nil {
+				affected, _ := result.RowsAffected()
+				fmt.Fprintln(sh.out, stmt.Tag(affected))
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if sh.timing {
+			fmt.Fprintf(sh.out, "Time: %.3f ms\n", float64(time.Since(start).Microseconds())/1000)
+		}
+	}
+	return nil
+}
+
+func (sh *shell) query(ctx context.Context, query string, args ...interface{}) error {
+	rows, err := sh.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	var records [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		refs := make([]interface{}, len(cols))
+		for i := range refs {
+			refs[i] = &values[i]
+		}
+		if err := rows.Scan(refs...); err != nil {
+			return err
+		}
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		records = append(records, values)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	switch sh.format {
+	case formatCSV:
+		return printCSV(sh.out, cols, records)
+	case formatJSON:
+		return printJSON(sh.out, cols, records)
+	}
+	printAligned(sh.out, cols, records)
+	return nil
+}
+
+func formatValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func printAligned(w io.Writer, cols []string, records [][]interface{}) {
+	widths := make([]int, len(cols))
+	for i, col := range cols {
+		widths[i] = len(col)
+	}
+	for _, record := range records {
+		for i, value := range record {
+			widths[i] = max(widths[i], len(formatValue(value)))
+		}
+	}
+
+	header := make([]string, len(cols))
+	separator := make([]string, len(cols))
+	for i, col := range cols {
+		header[i] = fmt.Sprintf(" %-*s ", widths[i], col)
+		separator[i] = strings.Repeat("-", widths[i]+2)
+	}
+	fmt.Fprintln(w, strings.Join(header, "|"))
+	fmt.Fprintln(w, strings.Join(separator, "+"))
+
+	for _, record := range records {
+		line := make([]string, len(record))
+		for i, value := range record {
+			line[i] = fmt.Sprintf(" %-*s ", widths[i], formatValue(value))
+		}
+		fmt.Fprintln(w, strings.Join(line, "|"))
+	}
+
+	if len(records) == 1 {
+		fmt.Fprintln(w, "(1 row)")
+	} else {
+		fmt.Fprintf(w, "(%d rows)\n", len(records))
+	}
+}
+
+func printCSV(w io.Writer, cols []string, records [][]interface{}) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(cols); err != nil {
+		return err
+	}
+	for _, record := range records {
+		line := make([]string, len(record))
+		for i, value := range record {
+			line[i] = formatValue(value)
+		}
+		if err := cw.Write(line); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func printJSON(w io.Writer, cols []string, records [][]interface{}) error {
+	objects := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		object := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			object[col] = record[i]
+		}
+		objects = append(objects, object)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(objects)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Shell", func() {
+	ctx := context.Background()
+
+	It("Runs meta commands and queries read from its input", func() {
+		var out bytes.Buffer
+		sh, err := openShell(GinkgoT().TempDir(), "shell.db", &out)
+		Expect(err).NotTo(HaveOccurred())
+		defer sh.close()
+
+		Expect(sh.run(ctx, strings.NewReader(strings.Join([]string{
+			`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`,
+			`CREATE INDEX items_name ON items (name DESC);`,
+			`INSERT INTO items (name)`,
+			`VALUES ('one'), ('two');`,
+			`\dt`,
+			`\d items`,
+			`\d items_name`,
+			`SELECT name FROM items WHERE id = 2;`,
+			`\d missing`,
+		}, "\n")))).To(Succeed())
+
+		Expect(out.String()).To(Equal(`shell.db=> CREATE TABLE
+shell.db=> CREATE INDEX
+shell.db=> shell.db-> INSERT 0 2
+shell.db=>  Name  | Type  
+-------+-------
+ items | table 
+(1 row)
+shell.db=>  # | Column | Type    | Nullable | Default 
+---+--------+---------+----------+---------
+ 0 | id     | INTEGER |          |         
+ 1 | name   | TEXT    | not null |         
+(2 rows)
+shell.db=>  # | Column | Order 
+---+--------+-------
+ 0 | name   | desc  
+(1 row)
+shell.db=>  name 
+------
+ two  
+(1 row)
+shell.db=> ERROR: did not find any relation named "missing"
+shell.db=> 
+`))
+	})
+
+	It("Holds the lock of the data directory", func() {
+		dataDir := GinkgoT().TempDir()
+		sh, err := openShell(dataDir, "shell.db", &bytes.Buffer{})
+		Expect(err).NotTo(HaveOccurred())
+
+		s := server.NewServer()
+		s.Addr = "127.0.0.1:0"
+		s.DataDir = dataDir
+		Expect(s.Open()).To(MatchError(ContainSubstring("is locked by process")))
+
+		Expect(sh.close()).To(Succeed())
+		sh, err = openShell(dataDir, "shell.db", &bytes.Buffer{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sh.close()).To(Succeed())
+	})
+})
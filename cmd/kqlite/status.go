@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// runStatus connects to a running server and reports its status.
+func runStatus(ctx context.Context, args []string) error {
+	fs := newFlagSet("status", "[flags]")
+	addr := fs.String("addr", "127.0.0.1:5432", "postgres protocol address of the server")
+	name := fs.String("db", "kqlite", "database name used for the connection")
+	timeout := fs.Duration("timeout", 5*time.Second, "connection timeout")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := pgconn.Connect(ctx, fmt.Sprintf("postgres://%s/%s?sslmode=disable", *addr, *name))
+	if err != nil {
+		fmt.Printf("kqlite at %s: down\n", *addr)
+		return err
+	}
+	defer conn.Close(ctx)
+
+	if err := conn.Ping(ctx); err != nil {
+		fmt.Printf("kqlite at %s: down\n", *addr)
+		return err
+	}
+
+	fmt.Printf("kqlite at %s: up\n", *addr)
+	fmt.Printf("  server_version: %s\n", conn.ParameterStatus("server_version"))
+	fmt.Printf("  response time:  %s\n", time.Since(start).Round(time.Microsecond))
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/gateway"
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+// Signal starting the kqlite binary again to replace the server without closing its
+// listeners, e.g. once the binary is upgraded.
+var upgradeSignal = syscall.SIGUSR2
+
+// Environment of the process started by an upgrade: the number of listeners it inherits
+// from file descriptor 3 on, followed by the pipe it notifies once ready to take over.
+const upgradeFDsEnv = "KQLITE_UPGRADE_FDS"
+
+// Time the replaced server is given to close once its sessions are drained, on top of
+// the upgrade timeout the new server waits for the data directory.
+const upgradeCloseDelay = 10 * time.Second
+
+// Waits for a shutdown signal, upgrades are started meanwhile. Returns true once an
+// upgrade takes over, the server then drains its sessions.
+func waitShutdown(ctx context.Context, s *server.Server, g *gateway.Gateway, upgradeTimeout time.Duration) bool {
+	upgrades := make(chan os.Signal, 1)
+	signal.Notify(upgrades, upgradeSignal)
+	defer signal.Stop(upgrades)
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-upgrades:
+			log.Printf("upgrade signal received")
+			if err := startUpgrade(s, g, upgradeTimeout); err != nil {
+				log.Printf("upgrade failed, still serving: %s", err)
+				continue
+			}
+			return true
+		}
+	}
+}
+
+// Starts the kqlite binary with the arguments of the server and passes it the listeners
+// of the server and of the gateway, if open. Returns once the new process is ready to take
+// over, or an error if it exits or doesn't get ready within the timeout, the server keeps
+// serving then. The new process is ready once it's set up but for the data directory, it
+// accepts connections once the server drained its sessions and closed.
+func startUpgrade(s *server.Server, g *gateway.Gateway, timeout time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	files, err := s.ListenerFiles()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if g.ListenerAddr() != nil {
+		f, err := g.ListenerFile()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(os.Environ(), upgradeFDsEnv+"="+strconv.Itoa(len(files)))
+	err = cmd.Start()
+	w.Close()
+	// Starting the process puts the sockets in blocking mode, the server would block on
+	// its accepts and on closing its listeners.
+	for _, f := range files {
+		setNonblock(f)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The pipe is closed without a notification if the new process exits.
+	r.SetReadDeadline(time.Now().Add(timeout))
+	if _, err := io.ReadFull(r, make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("process %d not ready: %w", cmd.Process.Pid, err)
+	}
+	log.Printf("upgrade: process %d takes over", cmd.Process.Pid)
+	return nil
+}
+
+// Puts the file descriptor in non-blocking mode, without the blocking mode Fd sets.
+func setNonblock(f *os.File) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return
+	}
+	rc.Control(func(fd uintptr) {
+		syscall.SetNonblock(int(fd), true)
+	})
+}
+
+// Serves the listeners passed by the server an upgrade replaces, if started by one, in
+// the order of the server listeners: -addr, the Unix socket and the -listen addresses,
+// followed by the gateway listener if the gateway has an address. Returns the pipe to
+// notify the replaced server once ready to take over, nil otherwise.
+func inheritListeners(s *server.Server, g *gateway.Gateway) (*os.File, error) {
+	n, err := strconv.Atoi(os.Getenv(upgradeFDsEnv))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	os.Unsetenv(upgradeFDsEnv)
+
+	ready := os.NewFile(uintptr(listenFDsStart+n), "upgrade")
+	syscall.CloseOnExec(int(ready.Fd()))
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("upgrade listener %d", fd))
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			ready.Close()
+			return nil, configErrorf("upgrade: file descriptor %d: %w", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	if g.Addr != "" && len(listeners) > 1 {
+		g.Listener = listeners[len(listeners)-1]
+		listeners = listeners[:len(listeners)-1]
+	}
+	s.Listener = listeners[0]
+	listeners = listeners[1:]
+	if s.UnixSocketDir != "" && len(listeners) > 0 {
+		s.UnixListener = listeners[0]
+		listeners = listeners[1:]
+	}
+	// Listeners of socket activation beyond the -listen addresses serve all databases.
+	for i, ln := range listeners {
+		if i < len(s.Listeners) {
+			s.Listeners[i].Listener = ln
+		} else {
+			s.Listeners = append(s.Listeners, server.ListenerConfig{Addr: ln.Addr().String(), Listener: ln})
+		}
+	}
+	log.Printf("upgrade: %d listeners inherited", n)
+	return ready, nil
+}
+
+// Notifies the replaced server that the process is ready to take over, it stops
+// accepting connections and drains its sessions.
+func notifyUpgradeReady(ready *os.File) error {
+	if ready == nil {
+		return nil
+	}
+	defer ready.Close()
+	_, err := ready.Write([]byte{1})
+	return err
+}
@@ -0,0 +1,159 @@
+// Package kqlite embeds a kqlite server in Go applications.
+//
+// The server accepts PostgreSQL wire protocol connections and stores each
+// database as a SQLite file in the data directory:
+//
+//	srv, err := kqlite.New(kqlite.Options{Addr: "127.0.0.1:0"})
+//	if err != nil {
+//		return err
+//	}
+//	if err := srv.Start(); err != nil {
+//		return err
+//	}
+//	defer srv.Stop()
+//
+//	url, err := srv.ConnString("app.db")
+//	if err != nil {
+//		return err
+//	}
+//	conn, err := pgx.Connect(ctx, url)
+//
+// The SQLite files of databases can be opened in-process with RawDB, bypassing
+// the server.
+package kqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/server"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Options configure an embedded server.
+type Options struct {
+	// Bind address of the PostgreSQL wire protocol listener, "127.0.0.1:0"
+	// picks a free port on the loopback interface and is the default.
+	Addr string
+
+	// Directory that holds SQLite databases. If empty a temporary directory
+	// is created and removed when the server is stopped.
+	DataDir string
+
+	// Maximum number of named prepared statements per connection, 0 for no limit.
+	MaxPreparedStmts int
+
+	// Maximum delay to sync commits of concurrent sessions together, 0 disables group commit.
+	GroupCommitDelay time.Duration
+}
+
+// Server is an embedded kqlite server.
+type Server struct {
+	mu      sync.Mutex
+	srv     *server.Server
+	dataDir string
+	tempDir bool
+	dbs     map[string]*sql.DB
+}
+
+// New creates a server with the given options, it's not listening until started.
+func New(opts Options) (*Server, error) {
+	if opts.Addr == "" {
+		opts.Addr = "127.0.0.1:0"
+	}
+
+	s := &Server{dataDir: opts.DataDir, dbs: make(map[string]*sql.DB)}
+	if s.dataDir == "" {
+		dir, err := os.MkdirTemp("", "kqlite-")
+		if err != nil {
+			return nil, err
+		}
+		s.dataDir, s.tempDir = dir, true
+	}
+
+	s.srv = server.NewServer()
+	s.srv.Addr = opts.Addr
+	s.srv.DataDir = s.dataDir
+	s.srv.MaxPreparedStmts = opts.MaxPreparedStmts
+	s.srv.GroupCommitDelay = opts.GroupCommitDelay
+	return s, nil
+}
+
+// Start starts listening for client connections.
+func (s *Server) Start() error {
+	return s.srv.Open()
+}
+
+// Stop closes all client connections and in-process databases and stops the server.
+// A temporary data directory is removed along with its databases.
+func (s *Server) Stop() (err error) {
+	s.mu.Lock()
+	for name, db := range s.dbs {
+		if e := db.Close(); err == nil {
+			err = e
+		}
+		delete(s.dbs, name)
+	}
+	s.mu.Unlock()
+
+	if e := s.srv.Close(); err == nil {
+		err = e
+	}
+
+	if s.tempDir {
+		if e := os.RemoveAll(s.dataDir); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// ListenerAddr returns the address the server is listening on, nil if not started.
+func (s *Server) ListenerAddr() net.Addr {
+	return s.srv.ListenerAddr()
+}
+
+// DataDir returns the directory that holds the databases.
+func (s *Server) DataDir() string {
+	return s.dataDir
+}
+
+// ConnString returns a PostgreSQL connection URL for the named database, an error if
+// the server isn't started.
+func (s *Server) ConnString(name string) (string, error) {
+	addr := s.ListenerAddr()
+	if addr == nil {
+		return "", errors.New("server not started")
+	}
+	return fmt.Sprintf("postgres://%s/%s?sslmode=disable", addr, name), nil
+}
+
+// RawDB returns an in-process connection pool to the SQLite file of the named
+// database, the database is created if it doesn't exist. Connections of the pool
+// bypass the server: its journal mode, init scripts, quotas and group commit don't
+// apply to them. The pool is closed when the server is stopped.
+func (s *Server) RawDB(name string) (*sql.DB, error) {
+	if name == "" || strings.Contains(name, "..") || strings.ContainsRune(name, filepath.Separator) {
+		return nil, fmt.Errorf("invalid database name %q", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db, ok := s.dbs[name]; ok {
+		return db, nil
+	}
+	db, err := sql.Open(sqlite.DriverName, filepath.Join(s.dataDir, name))
+	if err != nil {
+		return nil, err
+	}
+	s.dbs[name] = db
+	return db, nil
+}
@@ -0,0 +1,48 @@
+package kqlite_test
+
+import (
+	"context"
+	"os"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite"
+)
+
+var _ = Describe("Embedded server", func() {
+	ctx := context.Background()
+
+	It("Serves databases over the wire protocol and in-process", func() {
+		srv, err := kqlite.New(kqlite.Options{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = srv.ConnString("embed.db")
+		Expect(err).To(MatchError("server not started"))
+		Expect(srv.Start()).To(Succeed())
+		Expect(srv.ListenerAddr()).NotTo(BeNil())
+
+		db, err := srv.RawDB("embed.db")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = db.ExecContext(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);
+			INSERT INTO items (name) VALUES ('local')`)
+		Expect(err).NotTo(HaveOccurred())
+
+		url, err := srv.ConnString("embed.db")
+		Expect(err).NotTo(HaveOccurred())
+		conn, err := pgx.Connect(ctx, url)
+		Expect(err).NotTo(HaveOccurred())
+		var name string
+		Expect(conn.QueryRow(ctx, `SELECT name FROM items WHERE id = 1`).Scan(&name)).To(Succeed())
+		Expect(name).To(Equal("local"))
+		Expect(conn.Close(ctx)).To(Succeed())
+
+		_, err = srv.RawDB("../other.db")
+		Expect(err).To(HaveOccurred())
+
+		dataDir := srv.DataDir()
+		Expect(srv.Stop()).To(Succeed())
+		_, err = os.Stat(dataDir)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
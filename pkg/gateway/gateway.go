@@ -0,0 +1,307 @@
+// Package gateway implements an HTTP/JSON query endpoint for clients that can't speak
+// the Postgres protocol, e.g. serverless functions. Each request executes a statement
+// in a session of an upstream kqlite server, opened with the credentials of the request,
+// so the server authenticates gateway clients as it does Postgres clients.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Maximum size of the body of query requests.
+const maxRequestSize = 1 << 20
+
+// User of the sessions of requests without credentials.
+const defaultUser = "kqlite"
+
+// Application name of the sessions of requests, listed in pg_stat_activity.
+const applicationName = "kqlite-gateway"
+
+type Gateway struct {
+	ln  net.Listener
+	srv *http.Server
+
+	// Bind address of the HTTP listener.
+	Addr string
+
+	// Pre-opened listener used rather than binding Addr, e.g. one passed by the server
+	// an upgrade replaces.
+	Listener net.Listener
+
+	// Address of the kqlite server statements are executed on.
+	Upstream string
+
+	// Databases clients may query, all databases if empty.
+	Databases []string
+
+	// Timeout of connections to the upstream server, 0 for no timeout.
+	DialTimeout time.Duration
+}
+
+func NewGateway() *Gateway {
+	return &Gateway{}
+}
+
+func (g *Gateway) Open() (err error) {
+	if g.Upstream == "" {
+		return fmt.Errorf("upstream address required")
+	}
+	if g.ln = g.Listener; g.ln == nil {
+		if g.ln, err = net.Listen("tcp", g.Addr); err != nil {
+			return err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /db/{name}/query", g.handleQuery)
+	g.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := g.srv.Serve(g.ln); !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("gateway error: %s", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops accepting requests and waits for the requests in progress.
+func (g *Gateway) Close() error {
+	if g.srv == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return g.srv.Shutdown(ctx)
+}
+
+// ListenerAddr returns the address the gateway listens to, nil if not open.
+func (g *Gateway) ListenerAddr() net.Addr {
+	if g.ln == nil {
+		return nil
+	}
+	return g.ln.Addr()
+}
+
+// ListenerFile returns a duplicate of the socket of the listener, to pass it to another
+// process, e.g. the server of an upgrade. The caller closes the file.
+func (g *Gateway) ListenerFile() (*os.File, error) {
+	ln, ok := g.ln.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("gateway listener has no file")
+	}
+	return ln.File()
+}
+
+// Body of query requests, parameters are sent to the server as text.
+type queryRequest struct {
+	SQL    string        `json:"sql"`
+	Params []interface{} `json:"params"`
+}
+
+// Body of query responses, values of rows are in the order of columns.
+type queryResponse struct {
+	Columns      []string        `json:"columns"`
+	Rows         [][]interface{} `json:"rows"`
+	Command      string          `json:"command"`
+	RowsAffected int64           `json:"rows_affected"`
+}
+
+// Body of error responses, code is the SQLSTATE of the error.
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Executes the statement of a request on the database of its path.
+func (g *Gateway) handleQuery(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if strings.Contains(name, "..") {
+		writeError(w, http.StatusBadRequest, pgerror.New(pgerror.InvalidCatalogName, "invalid database name"))
+		return
+	} else if len(g.Databases) > 0 && !slices.Contains(g.Databases, name) {
+		writeError(w, http.StatusForbidden, pgerror.Newf(pgerror.InvalidAuthorization, "database %q is not available on this gateway", name))
+		return
+	}
+
+	var req queryRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, pgerror.Newf(pgerror.ProtocolViolation, "invalid request body: %s", err))
+		return
+	} else if strings.TrimSpace(req.SQL) == "" {
+		writeError(w, http.StatusBadRequest, pgerror.New(pgerror.ProtocolViolation, "sql required"))
+		return
+	}
+	params, err := textParams(req.Params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	conn, err := g.connect(r.Context(), name, r)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	defer conn.Close(context.Background())
+
+	resp, err := execute(r.Context(), conn, req.SQL, params)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Opens a session of the database on the upstream server with the basic authentication
+// credentials of the request.
+func (g *Gateway) connect(ctx context.Context, name string, r *http.Request) (*pgconn.PgConn, error) {
+	config, err := pgconn.ParseConfig(fmt.Sprintf("postgres://%s/%s?sslmode=disable", g.Upstream, url.PathEscape(name)))
+	if err != nil {
+		return nil, err
+	}
+	config.User = defaultUser
+	if user, password, ok := r.BasicAuth(); ok {
+		config.User, config.Password = user, password
+	}
+	config.ConnectTimeout = g.DialTimeout
+	config.RuntimeParams["application_name"] = applicationName
+	return pgconn.ConnectConfig(ctx, config)
+}
+
+// Executes a statement with the extended protocol, rows are received as text and
+// converted to JSON values by the type of their column.
+func execute(ctx context.Context, conn *pgconn.PgConn, sql string, params [][]byte) (*queryResponse, error) {
+	rr := conn.ExecParams(ctx, sql, params, nil, nil, nil)
+	resp := &queryResponse{Columns: []string{}, Rows: [][]interface{}{}}
+	fields := rr.FieldDescriptions()
+	for _, f := range fields {
+		resp.Columns = append(resp.Columns, f.Name)
+	}
+	for rr.NextRow() {
+		row := make([]interface{}, len(fields))
+		for i, value := range rr.Values() {
+			row[i] = jsonValue(fields[i].DataTypeOID, value)
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	tag, err := rr.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Command = tag.String()
+	resp.RowsAffected = tag.RowsAffected()
+	return resp, nil
+}
+
+// Returns the text of the JSON parameters of a request, objects and arrays are sent as JSON.
+func textParams(values []interface{}) ([][]byte, error) {
+	params := make([][]byte, len(values))
+	for i, value := range values {
+		switch value := value.(type) {
+		case nil:
+		case string:
+			params[i] = []byte(value)
+		case json.Number:
+			params[i] = []byte(value.String())
+		case bool:
+			params[i] = []byte(fmt.Sprint(value))
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, pgerror.Newf(pgerror.InvalidParameterValue, "invalid parameter $%d: %s", i+1, err)
+			}
+			params[i] = data
+		}
+	}
+	return params, nil
+}
+
+// Returns the JSON value of the text of a column value of a type, numbers and booleans
+// are converted, JSON values are inlined, other types are strings.
+func jsonValue(oid uint32, text []byte) interface{} {
+	if text == nil {
+		return nil
+	}
+	switch oid {
+	case pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID, pgtype.OIDOID:
+		return json.Number(text)
+	case pgtype.Float4OID, pgtype.Float8OID, pgtype.NumericOID:
+		// NaN and infinities have no JSON number.
+		if json.Valid(text) {
+			return json.Number(text)
+		}
+	case pgtype.BoolOID:
+		return string(text) == "t" || string(text) == "true"
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		// Values are received in the buffer of the connection.
+		if json.Valid(text) {
+			return json.RawMessage(slices.Clone(text))
+		}
+	}
+	return string(text)
+}
+
+// Returns the HTTP status of an error: errors of the server are bad requests, except
+// for authentication failures and exhausted resources, connection failures are bad gateways.
+func errorStatus(err error) int {
+	var pgErr *pgconn.PgError
+	var e *pgerror.Error
+	switch {
+	case errors.As(err, &pgErr):
+		switch pgErr.Code[:2] {
+		case "28":
+			return http.StatusUnauthorized
+		case "53", "57":
+			return http.StatusServiceUnavailable
+		case "XX":
+			return http.StatusInternalServerError
+		}
+		return http.StatusBadRequest
+	case errors.As(err, &e):
+		return http.StatusBadRequest
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// Writes the error response of an error, with the SQLSTATE of errors of the server.
+func writeError(w http.ResponseWriter, status int, err error) {
+	var resp errorResponse
+	var pgErr *pgconn.PgError
+	var e *pgerror.Error
+	switch {
+	case errors.As(err, &pgErr):
+		resp.Error.Code, resp.Error.Message = pgErr.Code, pgErr.Message
+	case errors.As(err, &e):
+		resp.Error.Code, resp.Error.Message = e.Code, e.Message
+	default:
+		resp.Error.Code, resp.Error.Message = pgerror.ConnectionFailure, err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="kqlite"`)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
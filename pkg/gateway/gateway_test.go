@@ -0,0 +1,99 @@
+package gateway_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Gateway", Ordered, func() {
+	type response struct {
+		Columns      []string        `json:"columns"`
+		Rows         [][]interface{} `json:"rows"`
+		Command      string          `json:"command"`
+		RowsAffected int64           `json:"rows_affected"`
+		Error        *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	// Posts a query to the database, returns the status and the decoded response.
+	query := func(dbname, sql string, params ...interface{}) (int, response) {
+		body, err := json.Marshal(map[string]interface{}{"sql": sql, "params": params})
+		Expect(err).NotTo(HaveOccurred())
+		url := fmt.Sprintf("http://%s/db/%s/query", gw.ListenerAddr(), dbname)
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+		var r response
+		Expect(json.NewDecoder(resp.Body).Decode(&r)).To(Succeed())
+		return resp.StatusCode, r
+	}
+
+	It("Executes statements with parameters", func() {
+		status, r := query("gateway.db", `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE, price REAL, tags JSON)`)
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(r.Command).To(Equal("CREATE TABLE"))
+
+		status, r = query("gateway.db", `INSERT INTO items (name, price, tags) VALUES ($1, $2, $3), ($4, $5, $6)`,
+			"apple", 1.5, []string{"fruit"}, "pear", nil, nil)
+		Expect(status).To(Equal(http.StatusOK), "%+v", r.Error)
+		Expect(r.Command).To(Equal("INSERT 0 2"))
+		Expect(r.RowsAffected).To(Equal(int64(2)))
+
+		status, r = query("gateway.db", `SELECT id, name, price, tags FROM items WHERE id >= $1 ORDER BY id`, 1)
+		Expect(status).To(Equal(http.StatusOK), "%+v", r.Error)
+		Expect(r.Columns).To(Equal([]string{"id", "name", "price", "tags"}))
+		Expect(r.Rows).To(HaveLen(2))
+		Expect(r.Rows[0][:3]).To(Equal([]interface{}{float64(1), "apple", 1.5}))
+		Expect(r.Rows[1][:3]).To(Equal([]interface{}{float64(2), "pear", nil}))
+		Expect(r.Rows[0][3]).To(Equal(`["fruit"]`))
+		Expect(r.Rows[1][3]).To(BeNil())
+		Expect(r.Command).To(Equal("SELECT 2"))
+	})
+
+	It("Reports errors with their SQLSTATE", func() {
+		status, r := query("gateway.db", `SELECT * FROM missing`)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(r.Error.Code).To(Equal("42P01"))
+
+		status, r = query("gateway.db", `INSERT INTO items (name) VALUES ($1)`, "apple")
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(r.Error.Code).To(Equal("23505"))
+
+		status, r = query("gateway.db", ``)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(r.Error.Message).To(Equal("sql required"))
+
+		status, r = query("other.db", `SELECT 1`)
+		Expect(status).To(Equal(http.StatusForbidden))
+		Expect(r.Error.Code).To(Equal("28000"))
+	})
+
+	It("Opens sessions with the credentials of requests", func() {
+		body := bytes.NewReader([]byte(`{"sql": "SELECT current_user, application_name FROM pg_stat_activity WHERE pid = pg_backend_pid()"}`))
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/db/gateway.db/query", gw.ListenerAddr()), body)
+		Expect(err).NotTo(HaveOccurred())
+		req.SetBasicAuth("edge", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		var r response
+		Expect(json.NewDecoder(resp.Body).Decode(&r)).To(Succeed())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK), "%+v", r.Error)
+		Expect(r.Rows).To(Equal([][]interface{}{{"edge", "kqlite-gateway"}}))
+	})
+
+	It("Accepts queries with POST only", func() {
+		resp, err := http.Get(fmt.Sprintf("http://%s/db/gateway.db/query", gw.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
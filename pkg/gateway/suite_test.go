@@ -0,0 +1,39 @@
+package gateway_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/gateway"
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var (
+	upstream *server.Server
+	gw       *gateway.Gateway
+)
+
+func TestGateway(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gateway Suite")
+}
+
+var _ = BeforeSuite(func() {
+	upstream = server.NewServer()
+	upstream.Addr = "127.0.0.1:0"
+	upstream.DataDir = GinkgoT().TempDir()
+	Expect(upstream.Open()).To(Succeed())
+
+	gw = gateway.NewGateway()
+	gw.Addr = "127.0.0.1:0"
+	gw.Upstream = upstream.ListenerAddr().String()
+	gw.Databases = []string{"gateway.db"}
+	Expect(gw.Open()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	Expect(gw.Close()).To(Succeed())
+	Expect(upstream.Close()).To(Succeed())
+})
@@ -0,0 +1,144 @@
+// Package migrate applies ordered SQL migration files to a database of a kqlite server,
+// tracking the applied ones in a table of the database. Migrations are applied by the
+// server like any other transaction, so replication clients receive their changes.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+)
+
+// Table tracking the applied migrations of a database.
+const Table = "kqlite_migrations"
+
+// Key of the advisory lock held while migrations are applied, so runners connected to
+// the same server, directly or through proxies, apply them one at a time.
+const lockKey = 0x6b716c697465 // "kqlite"
+
+// Migration is a SQL migration file, its version is its name without the .sql extension.
+type Migration struct {
+	Version  string
+	SQL      string
+	Checksum string // SHA-256 of the SQL, hex encoded.
+}
+
+// Load returns the migrations of the .sql files of a directory, ordered by name.
+func Load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	migrations := make([]Migration, 0, len(names))
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		migrations = append(migrations, Migration{
+			Version:  strings.TrimSuffix(path.Base(name), ".sql"),
+			SQL:      string(data),
+			Checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+	return migrations, nil
+}
+
+// Apply applies the migrations not applied yet to the database of the connection, each
+// one in a transaction along with its record in the migrations table. Migrations applied
+// before are checked against their checksum, changing them is an error. Returns the
+// versions of the applied migrations.
+func Apply(ctx context.Context, conn *pgconn.PgConn, migrations []Migration) ([]string, error) {
+	if err := exec(ctx, conn, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		version    TEXT PRIMARY KEY,
+		checksum   TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`, Table)); err != nil {
+		return nil, err
+	}
+
+	if err := exec(ctx, conn, fmt.Sprintf(`SELECT pg_advisory_lock(%d)`, lockKey)); err != nil {
+		return nil, err
+	}
+	defer exec(context.Background(), conn, fmt.Sprintf(`SELECT pg_advisory_unlock(%d)`, lockKey))
+
+	applied, err := appliedChecksums(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, m := range migrations {
+		if checksum, ok := applied[m.Version]; ok {
+			if checksum != m.Checksum {
+				return versions, fmt.Errorf("migration %s was changed since it was applied", m.Version)
+			}
+			continue
+		}
+		if err := apply(ctx, conn, m); err != nil {
+			return versions, err
+		}
+		versions = append(versions, m.Version)
+	}
+	return versions, nil
+}
+
+// Applies a migration and records it in a transaction, rolled back if it fails.
+func apply(ctx context.Context, conn *pgconn.PgConn, m Migration) error {
+	// Statements that don't parse as PostgreSQL are left to the server.
+	stmts, _ := parser.ClassifyQuery(m.SQL)
+	for _, stmt := range stmts {
+		switch stmt.Command {
+		case parser.CmdBegin, parser.CmdStart, parser.CmdCommit, parser.CmdRollback:
+			return fmt.Errorf("migration %s: %s is not allowed, migrations are applied in a transaction", m.Version, stmt.Command)
+		}
+	}
+	if err := exec(ctx, conn, `BEGIN`); err != nil {
+		return err
+	}
+	if err := exec(ctx, conn, m.SQL); err != nil {
+		exec(context.Background(), conn, `ROLLBACK`)
+		return fmt.Errorf("migration %s: %w", m.Version, err)
+	}
+	record := fmt.Sprintf(`INSERT INTO %s (version, checksum, applied_at) VALUES ('%s', '%s', '%s')`, Table,
+		strings.ReplaceAll(m.Version, "'", "''"), m.Checksum, time.Now().UTC().Format(time.RFC3339))
+	if err := exec(ctx, conn, record); err != nil {
+		exec(context.Background(), conn, `ROLLBACK`)
+		return err
+	}
+	return exec(ctx, conn, `COMMIT`)
+}
+
+// Returns the checksums of the applied migrations by version.
+func appliedChecksums(ctx context.Context, conn *pgconn.PgConn) (map[string]string, error) {
+	results, err := conn.Exec(ctx, fmt.Sprintf(`SELECT version, checksum FROM %s`, Table)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	checksums := make(map[string]string)
+	for _, result := range results {
+		for _, row := range result.Rows {
+			checksums[string(row[0])] = string(row[1])
+		}
+	}
+	return checksums, nil
+}
+
+// Executes statements with the simple query protocol, discarding their results.
+func exec(ctx context.Context, conn *pgconn.PgConn, sql string) error {
+	_, err := conn.Exec(ctx, sql).ReadAll()
+	return err
+}
@@ -0,0 +1,107 @@
+package migrate_test
+
+import (
+	"context"
+	"fmt"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/migrate"
+)
+
+var _ = Describe("Migrations", func() {
+	ctx := context.Background()
+
+	connect := func(dbname string) *pgconn.PgConn {
+		conn, err := pgconn.Connect(ctx, fmt.Sprintf("postgres://%s/%s?sslmode=disable", srv.ListenerAddr(), dbname))
+		Expect(err).NotTo(HaveOccurred())
+		return conn
+	}
+
+	query := func(conn *pgconn.PgConn, sql string) [][]string {
+		results, err := conn.Exec(ctx, sql).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+		var rows [][]string
+		for _, row := range results[0].Rows {
+			var values []string
+			for _, value := range row {
+				values = append(values, string(value))
+			}
+			rows = append(rows, values)
+		}
+		return rows
+	}
+
+	It("Applies the migrations not applied yet in order", func() {
+		conn := connect("migrated.db")
+		defer conn.Close(ctx)
+
+		files := fstest.MapFS{
+			"002_seed.sql":  {Data: []byte(`INSERT INTO users (name) VALUES ('ada'), ('alan');`)},
+			"001_init.sql":  {Data: []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`)},
+			"README.md":     {Data: []byte(`Not a migration.`)},
+			"003_email.sql": {Data: []byte(`ALTER TABLE users ADD COLUMN email TEXT; CREATE INDEX users_email ON users (email);`)},
+			"archive/x.sql": {Data: []byte(`DROP TABLE users;`)},
+		}
+		migrations, err := migrate.Load(files)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrations).To(HaveLen(3))
+
+		versions, err := migrate.Apply(ctx, conn, migrations[:2])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(Equal([]string{"001_init", "002_seed"}))
+
+		versions, err = migrate.Apply(ctx, conn, migrations)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(Equal([]string{"003_email"}))
+		versions, err = migrate.Apply(ctx, conn, migrations)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(BeEmpty())
+
+		Expect(query(conn, `SELECT count(*) FROM users WHERE email IS NULL`)).To(Equal([][]string{{"2"}}))
+		Expect(query(conn, `SELECT version FROM kqlite_migrations ORDER BY version`)).To(Equal([][]string{
+			{"001_init"}, {"002_seed"}, {"003_email"},
+		}))
+	})
+
+	It("Rolls back failed migrations", func() {
+		conn := connect("failed.db")
+		defer conn.Close(ctx)
+
+		migrations, err := migrate.Load(fstest.MapFS{
+			"1_init.sql":   {Data: []byte(`CREATE TABLE items (id INTEGER PRIMARY KEY);`)},
+			"2_broken.sql": {Data: []byte(`CREATE TABLE tags (id INTEGER PRIMARY KEY); INSERT INTO missing VALUES (1);`)},
+			"3_next.sql":   {Data: []byte(`CREATE TABLE next (id INTEGER PRIMARY KEY);`)},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		versions, err := migrate.Apply(ctx, conn, migrations)
+		Expect(err).To(MatchError(ContainSubstring("migration 2_broken")))
+		Expect(versions).To(Equal([]string{"1_init"}))
+
+		Expect(query(conn, `SELECT name FROM sqlite_master WHERE type = 'table' AND name IN ('items', 'tags', 'next')`)).To(Equal([][]string{{"items"}}))
+		Expect(query(conn, `SELECT version FROM kqlite_migrations`)).To(Equal([][]string{{"1_init"}}))
+	})
+
+	It("Rejects changed and transactional migrations", func() {
+		conn := connect("changed.db")
+		defer conn.Close(ctx)
+
+		migrations, err := migrate.Load(fstest.MapFS{"1_init.sql": {Data: []byte(`CREATE TABLE items (id INTEGER PRIMARY KEY);`)}})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = migrate.Apply(ctx, conn, migrations)
+		Expect(err).NotTo(HaveOccurred())
+
+		changed, err := migrate.Load(fstest.MapFS{"1_init.sql": {Data: []byte(`CREATE TABLE items (id BIGINT PRIMARY KEY);`)}})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = migrate.Apply(ctx, conn, changed)
+		Expect(err).To(MatchError("migration 1_init was changed since it was applied"))
+
+		transactional, err := migrate.Load(fstest.MapFS{"2_tx.sql": {Data: []byte(`BEGIN; CREATE TABLE tags (id INTEGER); COMMIT;`)}})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = migrate.Apply(ctx, conn, transactional)
+		Expect(err).To(MatchError(ContainSubstring("BEGIN is not allowed")))
+	})
+})
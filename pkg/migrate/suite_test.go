@@ -0,0 +1,28 @@
+package migrate_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var srv *server.Server
+
+func TestMigrate(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Migrate Suite")
+}
+
+var _ = BeforeSuite(func() {
+	srv = server.NewServer()
+	srv.Addr = "127.0.0.1:0"
+	srv.DataDir = GinkgoT().TempDir()
+	Expect(srv.Open()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	Expect(srv.Close()).To(Succeed())
+})
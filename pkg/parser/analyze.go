@@ -0,0 +1,145 @@
+package parser
+
+import (
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// Analysis describes a single statement and its translation to SQLite.
+type Analysis struct {
+	StmtClass
+
+	// Columns referenced in the statement in order of appearance, without duplicates.
+	// Qualified column references are joined with a dot, e.g. "e.name".
+	Columns []string
+
+	// Columns the parameters are compared with or assigned to, in order of appearance.
+	// Parameters that can't be related to a column are omitted.
+	ParamColumns []string
+
+	// Statement rewritten for execution by SQLite.
+	Rewritten string
+}
+
+// Collects the column references of a statement tree.
+type columnWalker struct {
+	columns []string
+	seen    map[string]bool
+}
+
+func (walker *columnWalker) Visit(node *pg_query.Node) (v Visitor, err error) {
+	if n, ok := node.Node.(*pg_query.Node_ColumnRef); ok {
+		var names []string
+		for _, field := range n.ColumnRef.GetFields() {
+			if s, ok := field.GetNode().(*pg_query.Node_String_); ok {
+				names = append(names, s.String_.GetSval())
+			}
+		}
+		name := strings.Join(names, ".")
+		if name != "" && !walker.seen[name] {
+			walker.seen[name] = true
+			walker.columns = append(walker.columns, name)
+		}
+	}
+	return walker, nil
+}
+
+func (walker *columnWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// Analyze parses a SQL query string, can have multiple statements,
+// and analyzes each statement in it.
+func Analyze(sql string) ([]Analysis, error) {
+	var result []Analysis
+	if sql == "" {
+		return result, nil
+	}
+
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return result, parseError(err)
+	}
+
+	for _, raw := range tree.Stmts {
+		stmt := raw.GetStmt()
+		analysis := Analysis{StmtClass: Classify(stmt)}
+		analysis.SQL = stmtText(sql, raw)
+		analysis.Rewritten = RewriteQuery(analysis.SQL)
+
+		columns := &columnWalker{seen: make(map[string]bool)}
+		if err := Walk(columns, stmt); err != nil {
+			return result, err
+		}
+		analysis.Columns = columns.columns
+
+		params := &parserStmtWalker{}
+		if err := Walk(params, stmt); err != nil {
+			return result, err
+		}
+		analysis.ParamColumns = params.result.Args
+
+		result = append(result, analysis)
+	}
+	return result, nil
+}
+
+// ResultSource is the table the result columns of a single table SELECT are selected from.
+type ResultSource struct {
+	Schema string // Schema of the table, empty if not qualified.
+	Table  string
+
+	// Table column of each target of the select list, empty for expressions.
+	// "*" stands for all the columns of the table.
+	Columns []string
+}
+
+// SelectSource returns the source table of the result columns of a SELECT statement
+// from a single table, false for any other statement.
+func SelectSource(sql string) (ResultSource, bool) {
+	var source ResultSource
+	tree, err := pg_query.Parse(sql)
+	if err != nil || len(tree.Stmts) != 1 {
+		return source, false
+	}
+	sel := tree.Stmts[0].GetStmt().GetSelectStmt()
+	if sel == nil || sel.GetOp() != pg_query.SetOperation_SETOP_NONE || len(sel.GetFromClause()) != 1 {
+		return source, false
+	}
+	rangevar := sel.GetFromClause()[0].GetRangeVar()
+	if rangevar == nil {
+		return source, false
+	}
+	source.Schema = rangevar.GetSchemaname()
+	source.Table = rangevar.GetRelname()
+
+	// Qualified column references must name the table, or its alias if any.
+	qualifier := source.Table
+	if alias := rangevar.GetAlias(); alias != nil {
+		qualifier = alias.GetAliasname()
+	}
+
+	for _, target := range sel.GetTargetList() {
+		var column string
+		if ref := target.GetResTarget().GetVal().GetColumnRef(); ref != nil {
+			fields := ref.GetFields()
+			if len(fields) == 2 && fields[0].GetString_().GetSval() == qualifier {
+				fields = fields[1:]
+			}
+			if len(fields) == 1 {
+				if fields[0].GetAStar() != nil {
+					column = "*"
+				} else {
+					column = fields[0].GetString_().GetSval()
+				}
+			}
+		}
+		source.Columns = append(source.Columns, column)
+	}
+	return source, true
+}
+
+// Fingerprint returns an identifier of the statement that is the same for
+// statements only differing in constant values, comments or formatting.
+func Fingerprint(sql string) (string, error) {
+	return pg_query.Fingerprint(sql)
+}
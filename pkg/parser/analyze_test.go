@@ -0,0 +1,57 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Analyze tests", Ordered, func() {
+
+	It("Analyze statements", func() {
+		result, err := parser.Analyze(`SELECT e.name, salary FROM public.employees e WHERE e.dep_id = $1 AND salary > $2;
+			INSERT INTO employees (name, salary) VALUES ($1, $2)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(2))
+
+		Expect(result[0].Command).To(Equal(parser.CmdSelect))
+		Expect(result[0].ReadOnly).To(BeTrue())
+		Expect(result[0].Tables).To(Equal([]string{"employees"}))
+		Expect(result[0].Columns).To(Equal([]string{"e.name", "salary", "e.dep_id"}))
+		Expect(result[0].Params).To(Equal(2))
+		Expect(result[0].ParamColumns).To(Equal([]string{"dep_id", "salary"}))
+		Expect(result[0].Rewritten).To(ContainSubstring("FROM main.employees e"))
+
+		Expect(result[1].Command).To(Equal(parser.CmdInsert))
+		Expect(result[1].ReadOnly).To(BeFalse())
+		Expect(result[1].Columns).To(BeEmpty())
+		Expect(result[1].ParamColumns).To(Equal([]string{"name", "salary"}))
+		Expect(result[1].SQL).To(Equal(`INSERT INTO employees (name, salary) VALUES ($1, $2)`))
+	})
+
+	It("Analyze invalid statement", func() {
+		_, err := parser.Analyze(`SELEC 1`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Select source", func() {
+		source, ok := parser.SelectSource(`SELECT e.name, salary * 2, e.*, id AS key FROM public.employees e WHERE id > $1`)
+		Expect(ok).To(BeTrue())
+		Expect(source).To(Equal(parser.ResultSource{
+			Schema:  "public",
+			Table:   "employees",
+			Columns: []string{"name", "", "*", "id"},
+		}))
+
+		for _, sql := range []string{
+			`SELECT 1`,
+			`SELECT a.id FROM a JOIN b ON a.id = b.id`,
+			`SELECT id FROM a UNION SELECT id FROM b`,
+			`INSERT INTO a (id) VALUES (1) RETURNING id`,
+		} {
+			_, ok := parser.SelectSource(sql)
+			Expect(ok).To(BeFalse(), sql)
+		}
+	})
+})
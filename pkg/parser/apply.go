@@ -0,0 +1,209 @@
+package parser
+
+import (
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// An ApplyFunc is invoked by Apply for each node, with the cursor positioned at the node.
+type ApplyFunc func(c *Cursor) bool
+
+// A Cursor describes a node encountered by Apply, and changes it in its parent.
+type Cursor struct {
+	node   *pg_query.Node
+	parent protoreflect.Message // Nil for the root node.
+	field  protoreflect.FieldDescriptor
+	list   protoreflect.List // List of the field holding the node, nil for single nodes.
+	index  int               // Index of the node in list.
+	next   int               // Index of the next node of list to apply.
+	root   **pg_query.Node
+}
+
+// Node returns the current node, nil once deleted.
+func (c *Cursor) Node() *pg_query.Node { return c.node }
+
+// Parent returns the message holding the current node, e.g. a *pg_query.SelectStmt, nil
+// for the root node.
+func (c *Cursor) Parent() proto.Message {
+	if c.parent == nil {
+		return nil
+	}
+	return c.parent.Interface()
+}
+
+// Name returns the name of the field of the parent holding the current node, e.g.
+// "where_clause", empty for the root node.
+func (c *Cursor) Name() string {
+	if c.field == nil {
+		return ""
+	}
+	return string(c.field.Name())
+}
+
+// Index returns the index of the current node in the list of the parent holding it, -1
+// if the field of the parent holds a single node.
+func (c *Cursor) Index() int {
+	if c.list == nil {
+		return -1
+	}
+	return c.index
+}
+
+// Replace replaces the current node with n, the children of n are applied instead if
+// called from pre.
+func (c *Cursor) Replace(n *pg_query.Node) {
+	switch {
+	case n == nil:
+		c.Delete()
+		return
+	case c.list != nil:
+		c.list.Set(c.index, protoreflect.ValueOfMessage(n.ProtoReflect()))
+	case c.parent != nil:
+		c.parent.Set(c.field, protoreflect.ValueOfMessage(n.ProtoReflect()))
+	default:
+		*c.root = n
+	}
+	c.node = n
+}
+
+// Delete removes the current node from its parent, a deleted root leaves no statement.
+func (c *Cursor) Delete() {
+	if c.node == nil {
+		return
+	}
+	switch {
+	case c.list != nil:
+		for i := c.index; i < c.list.Len()-1; i++ {
+			c.list.Set(i, c.list.Get(i+1))
+		}
+		c.list.Truncate(c.list.Len() - 1)
+		c.next--
+	case c.parent != nil:
+		c.parent.Clear(c.field)
+	default:
+		*c.root = nil
+	}
+	c.node = nil
+}
+
+// InsertBefore inserts n before the current node in the list holding it, n is not applied.
+// It panics if the current node is not in a list.
+func (c *Cursor) InsertBefore(n *pg_query.Node) {
+	c.insert(c.index, n)
+	c.index++
+}
+
+// InsertAfter inserts n after the current node in the list holding it, n is not applied.
+// It panics if the current node is not in a list.
+func (c *Cursor) InsertAfter(n *pg_query.Node) {
+	c.insert(c.index+1, n)
+}
+
+func (c *Cursor) insert(i int, n *pg_query.Node) {
+	if c.list == nil {
+		panic("parser: node not in a list")
+	}
+	c.list.Append(protoreflect.ValueOfMessage(n.ProtoReflect()))
+	for j := c.list.Len() - 1; j > i; j-- {
+		c.list.Set(j, c.list.Get(j-1))
+	}
+	c.list.Set(i, protoreflect.ValueOfMessage(n.ProtoReflect()))
+	c.next++
+}
+
+// Apply traverses a statement tree recursively, calling pre for each node before its
+// children and post after them, either may be nil. Nodes are changed through the cursor.
+// If pre returns false the children of the node and post are skipped, if post returns
+// false the traversal stops. Messages the tree refers to directly rather than through
+// nodes, such as the relation of an INSERT statement, are traversed without calling pre
+// and post. Apply returns the root node, changed if it was replaced or deleted.
+func Apply(root *pg_query.Node, pre, post ApplyFunc) *pg_query.Node {
+	a := &applier{pre: pre, post: post}
+	a.apply(&Cursor{node: root, root: &root})
+	return root
+}
+
+// ApplyQuery applies pre and post to the statements of a SQL query string, as Apply does,
+// and returns the changed query. Statements whose root node is deleted are removed.
+func ApplyQuery(sql string, pre, post ApplyFunc) (string, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", parseError(err)
+	}
+	stmts := tree.Stmts[:0]
+	for _, raw := range tree.Stmts {
+		if raw.Stmt = Apply(raw.Stmt, pre, post); raw.Stmt != nil {
+			stmts = append(stmts, raw)
+		}
+	}
+	tree.Stmts = stmts
+	return pg_query.Deparse(tree)
+}
+
+var nodeDescriptor = (&pg_query.Node{}).ProtoReflect().Descriptor()
+
+type applier struct {
+	pre, post ApplyFunc
+	stopped   bool
+}
+
+// Applies the functions to the node of the cursor and its children.
+func (a *applier) apply(c *Cursor) {
+	if c.node == nil || c.node.Node == nil {
+		return
+	}
+	if a.pre != nil && !a.pre(c) {
+		return
+	}
+	if c.node == nil {
+		return
+	}
+	a.applyChildren(c.node)
+	if a.stopped || a.post == nil || c.node == nil {
+		return
+	}
+	if !a.post(c) {
+		a.stopped = true
+	}
+}
+
+// Applies the functions to the children of a node.
+func (a *applier) applyChildren(n *pg_query.Node) {
+	m := n.ProtoReflect()
+	field := m.WhichOneof(nodeDescriptor.Oneofs().ByName("node"))
+	if field == nil || field.Kind() != protoreflect.MessageKind {
+		return
+	}
+	a.applyMessage(m.Get(field).Message())
+}
+
+// Applies the functions to the nodes of the fields of a message.
+func (a *applier) applyMessage(m protoreflect.Message) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len() && !a.stopped; i++ {
+		field := fields.Get(i)
+		if field.Kind() != protoreflect.MessageKind || field.IsMap() || !m.Has(field) {
+			continue
+		}
+		isNode := field.Message().FullName() == nodeDescriptor.FullName()
+		switch {
+		case field.IsList() && isNode:
+			list := m.Mutable(field).List()
+			for j := 0; j < list.Len() && !a.stopped; {
+				c := &Cursor{node: list.Get(j).Message().Interface().(*pg_query.Node), parent: m, field: field, list: list, index: j, next: j + 1}
+				a.apply(c)
+				j = c.next
+			}
+		case field.IsList():
+			list := m.Get(field).List()
+			for j := 0; j < list.Len() && !a.stopped; j++ {
+				a.applyMessage(list.Get(j).Message())
+			}
+		case isNode:
+			a.apply(&Cursor{node: m.Get(field).Message().Interface().(*pg_query.Node), parent: m, field: field})
+		default:
+			a.applyMessage(m.Get(field).Message())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package parser_test
+
+import (
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Apply", func() {
+
+	It("Replace and delete nodes", func() {
+		sql, err := parser.ApplyQuery(`SELECT id, secret, pg_catalog.upper(name) FROM users WHERE secret IS NOT NULL`, func(c *parser.Cursor) bool {
+			switch {
+			case c.Name() == "where_clause":
+				c.Delete()
+			case c.Node().GetResTarget().GetVal().GetColumnRef() != nil:
+				fields := c.Node().GetResTarget().GetVal().GetColumnRef().GetFields()
+				if fields[0].GetString_().GetSval() == "secret" {
+					c.Delete()
+				}
+			case c.Node().GetFuncCall() != nil:
+				call := c.Node().GetFuncCall()
+				if len(call.Funcname) == 2 && call.Funcname[0].GetString_().GetSval() == "pg_catalog" {
+					call.Funcname = call.Funcname[1:]
+				}
+			}
+			return true
+		}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`SELECT id, upper(name) FROM users`))
+
+		sql, err = parser.ApplyQuery(`SELECT now(), 1`, nil, func(c *parser.Cursor) bool {
+			if call := c.Node().GetFuncCall(); call != nil && call.Funcname[0].GetString_().GetSval() == "now" {
+				c.Replace(pg_query.MakeFuncCallNode([]*pg_query.Node{pg_query.MakeStrNode("datetime")},
+					[]*pg_query.Node{pg_query.MakeAConstStrNode("now", -1)}, -1))
+			}
+			return true
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`SELECT datetime('now'), 1`))
+	})
+
+	It("Insert nodes in lists", func() {
+		sql, err := parser.ApplyQuery(`SELECT a, c FROM t`, func(c *parser.Cursor) bool {
+			if target := c.Node().GetResTarget(); target != nil {
+				switch target.GetVal().GetColumnRef().GetFields()[0].GetString_().GetSval() {
+				case "a":
+					c.InsertBefore(pg_query.MakeResTargetNodeWithVal(pg_query.MakeAConstIntNode(0, -1), -1))
+				case "c":
+					c.InsertAfter(pg_query.MakeResTargetNodeWithVal(pg_query.MakeColumnRefNode([]*pg_query.Node{pg_query.MakeStrNode("d")}, -1), -1))
+					c.InsertBefore(pg_query.MakeResTargetNodeWithVal(pg_query.MakeColumnRefNode([]*pg_query.Node{pg_query.MakeStrNode("b")}, -1), -1))
+				}
+			}
+			return true
+		}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`SELECT 0, a, b, c, d FROM t`))
+	})
+
+	It("Skip children and stop the traversal", func() {
+		var visited []string
+		stmt := func(sql string) *pg_query.Node {
+			tree, err := pg_query.Parse(sql)
+			Expect(err).NotTo(HaveOccurred())
+			return tree.Stmts[0].Stmt
+		}
+		name := func(c *parser.Cursor) string {
+			if ref := c.Node().GetColumnRef(); ref != nil {
+				return ref.Fields[0].GetString_().GetSval()
+			}
+			return ""
+		}
+		root := parser.Apply(stmt(`SELECT a, (SELECT b FROM u) FROM t WHERE c = 1 AND d = 2`), func(c *parser.Cursor) bool {
+			visited = append(visited, name(c))
+			return c.Node().GetSubLink() == nil
+		}, func(c *parser.Cursor) bool {
+			return name(c) != "c"
+		})
+		Expect(strings.Join(visited, "")).To(Equal("ac"))
+		Expect(root.GetSelectStmt()).NotTo(BeNil())
+
+		// The root node is replaced and deleted too.
+		root = parser.Apply(stmt(`SELECT 1`), func(c *parser.Cursor) bool {
+			Expect(c.Parent()).To(BeNil())
+			c.Replace(stmt(`SELECT 2`))
+			return false
+		}, nil)
+		Expect(pg_query.Deparse(&pg_query.ParseResult{Stmts: []*pg_query.RawStmt{{Stmt: root}}})).To(Equal(`SELECT 2`))
+		sql, err := parser.ApplyQuery(`SELECT 1; DELETE FROM t; SELECT 3`, func(c *parser.Cursor) bool {
+			if c.Node().GetDeleteStmt() != nil {
+				c.Delete()
+			}
+			return false
+		}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`SELECT 1; SELECT 3`))
+	})
+})
@@ -0,0 +1,540 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// Statement command classes, named after the PostgreSQL command tags.
+const (
+	CmdUnknown  = "UNKNOWN"
+	CmdSelect   = "SELECT"
+	CmdInsert   = "INSERT"
+	CmdUpdate   = "UPDATE"
+	CmdDelete   = "DELETE"
+	CmdMerge    = "MERGE"
+	CmdBegin    = "BEGIN"
+	CmdStart    = "START TRANSACTION"
+	CmdCommit   = "COMMIT"
+	CmdRollback = "ROLLBACK"
+	CmdSet      = "SET"
+	CmdReset    = "RESET"
+	CmdShow     = "SHOW"
+
+	CmdPrepare       = "PREPARE"
+	CmdExecute       = "EXECUTE"
+	CmdDeallocate    = "DEALLOCATE"
+	CmdDeallocateAll = "DEALLOCATE ALL"
+	CmdDiscardAll    = "DISCARD ALL"
+	CmdDiscardTemp   = "DISCARD TEMP"
+
+	CmdPrepareTransaction = "PREPARE TRANSACTION"
+	CmdCommitPrepared     = "COMMIT PREPARED"
+	CmdRollbackPrepared   = "ROLLBACK PREPARED"
+
+	CmdDeclareCursor = "DECLARE CURSOR"
+	CmdFetch         = "FETCH"
+	CmdMove          = "MOVE"
+	CmdCloseCursor   = "CLOSE CURSOR"
+	CmdListen        = "LISTEN"
+	CmdUnlisten      = "UNLISTEN"
+	CmdNotify        = "NOTIFY"
+)
+
+// StmtClass is the classification of a single SQL statement.
+type StmtClass struct {
+	Command     string   // Command class, matches the PostgreSQL command tag name.
+	Tables      []string // Tables targeted by the statement.
+	ReadOnly    bool     // Statement doesn't modify any database state.
+	ReturnsRows bool     // Statement produces a result set.
+	Params      int      // Number of parameters ($n) referenced in the statement.
+	Name        string   // Prepared statement or transaction, cursor, setting, server, extension or channel the statement refers to, if any.
+	Value       string   // Value assigned by SET, empty to reset the setting to its default. Access mode set by BEGIN. Payload of NOTIFY.
+	Isolation   string   // Isolation level set by BEGIN, SET TRANSACTION and SET SESSION CHARACTERISTICS, e.g. "serializable".
+	Local       bool     // SET LOCAL, the setting lasts until the end of the transaction.
+	FetchRows   int64    // Rows fetched or moved forward by FETCH and MOVE, -1 for ALL.
+	Scroll      bool     // FETCH or MOVE in any other direction than forward.
+	SQL         string   // Statement text, set only by ClassifyQuery.
+}
+
+// Tag returns the command tag reported to clients in CommandComplete.
+func (c StmtClass) Tag(rows int64) string {
+	switch c.Command {
+	case CmdInsert:
+		return fmt.Sprintf("INSERT 0 %d", rows)
+	case CmdSelect, CmdUpdate, CmdDelete, CmdMerge, "COPY", CmdFetch, CmdMove:
+		return fmt.Sprintf("%s %d", c.Command, rows)
+	}
+	return c.Command
+}
+
+// Collects all tables and parameters referenced in a statement tree.
+type classifyWalker struct {
+	tables []string
+	params int
+}
+
+func (walker *classifyWalker) Visit(node *pg_query.Node) (v Visitor, err error) {
+	switch n := node.Node.(type) {
+	case *pg_query.Node_RangeVar:
+		if relname := n.RangeVar.GetRelname(); relname != "" {
+			walker.tables = append(walker.tables, relname)
+		}
+	case *pg_query.Node_ParamRef:
+		walker.params = max(walker.params, int(n.ParamRef.GetNumber()))
+	}
+	return walker, nil
+}
+
+func (walker *classifyWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+func rangeVarTables(rangevars ...*pg_query.RangeVar) []string {
+	var tables []string
+	for _, rangevar := range rangevars {
+		if relname := rangevar.GetRelname(); relname != "" {
+			tables = append(tables, relname)
+		}
+	}
+	return tables
+}
+
+func walkTables(node *pg_query.Node) []string {
+	walker := &classifyWalker{}
+	if err := Walk(walker, node); err != nil {
+		return nil
+	}
+	return walker.tables
+}
+
+// Returns the object type name used in DDL command tags, e.g. "TABLE" for OBJECT_TABLE.
+func objectTypeName(objtype pg_query.ObjectType) string {
+	name := strings.TrimPrefix(objtype.String(), "OBJECT_")
+	switch objtype {
+	case pg_query.ObjectType_OBJECT_MATVIEW:
+		return "MATERIALIZED VIEW"
+	case pg_query.ObjectType_OBJECT_FDW:
+		return "FOREIGN DATA WRAPPER"
+	case pg_query.ObjectType_OBJECT_FOREIGN_SERVER:
+		return "SERVER"
+	}
+	return strings.ReplaceAll(name, "_", " ")
+}
+
+// Classify a single parsed statement into its command class, target tables,
+// read/write nature and whether it returns rows.
+func Classify(stmt *pg_query.Node) StmtClass {
+	class := StmtClass{Command: CmdUnknown}
+	if stmt == nil {
+		return class
+	}
+
+	walker := &classifyWalker{}
+	if err := Walk(walker, stmt); err == nil {
+		class.Params = walker.params
+	}
+
+	switch n := stmt.Node.(type) {
+	case *pg_query.Node_SelectStmt:
+		class.Command = CmdSelect
+		class.Tables = walker.tables
+		// SELECT INTO creates a new table.
+		class.ReadOnly = n.SelectStmt.GetIntoClause() == nil
+		class.ReturnsRows = class.ReadOnly
+	case *pg_query.Node_InsertStmt:
+		class.Command = CmdInsert
+		class.Tables = rangeVarTables(n.InsertStmt.GetRelation())
+		class.ReturnsRows = len(n.InsertStmt.GetReturningList()) != 0
+	case *pg_query.Node_UpdateStmt:
+		class.Command = CmdUpdate
+		class.Tables = rangeVarTables(n.UpdateStmt.GetRelation())
+		class.ReturnsRows = len(n.UpdateStmt.GetReturningList()) != 0
+	case *pg_query.Node_DeleteStmt:
+		class.Command = CmdDelete
+		class.Tables = rangeVarTables(n.DeleteStmt.GetRelation())
+		class.ReturnsRows = len(n.DeleteStmt.GetReturningList()) != 0
+	case *pg_query.Node_MergeStmt:
+		class.Command = CmdMerge
+		class.Tables = rangeVarTables(n.MergeStmt.GetRelation())
+	case *pg_query.Node_ExplainStmt:
+		class.Command = "EXPLAIN"
+		class.Tables = walkTables(n.ExplainStmt.GetQuery())
+		class.ReadOnly = true
+		class.ReturnsRows = true
+	case *pg_query.Node_TransactionStmt:
+		class.Command = transactionCommand(n.TransactionStmt.GetKind())
+		switch class.Command {
+		case CmdPrepareTransaction, CmdCommitPrepared, CmdRollbackPrepared:
+			// Prepared transactions are stored in the database.
+			class.Name = n.TransactionStmt.GetGid()
+		case CmdBegin, CmdStart:
+			if value, ok := transactionReadOnly(n.TransactionStmt.GetOptions()); ok {
+				class.Name, class.Value = "transaction_read_only", value
+			}
+			class.Isolation = transactionIsolation(n.TransactionStmt.GetOptions())
+			class.ReadOnly = true
+		default:
+			class.ReadOnly = true
+		}
+	case *pg_query.Node_VariableSetStmt:
+		class.Command = CmdSet
+		switch n.VariableSetStmt.GetKind() {
+		case pg_query.VariableSetKind_VAR_SET_VALUE:
+			class.Name = n.VariableSetStmt.GetName()
+			class.Value = settingValue(n.VariableSetStmt.GetArgs())
+			class.Local = n.VariableSetStmt.GetIsLocal()
+		case pg_query.VariableSetKind_VAR_SET_DEFAULT:
+			class.Name = n.VariableSetStmt.GetName()
+			class.Local = n.VariableSetStmt.GetIsLocal()
+		case pg_query.VariableSetKind_VAR_RESET:
+			class.Command = CmdReset
+			class.Name = n.VariableSetStmt.GetName()
+		case pg_query.VariableSetKind_VAR_RESET_ALL:
+			class.Command = CmdReset
+		case pg_query.VariableSetKind_VAR_SET_MULTI:
+			// Access modes and isolation levels of SET TRANSACTION and SET SESSION
+			// CHARACTERISTICS are settings, the isolation level is named when it's
+			// the only one set.
+			class.Isolation = transactionIsolation(n.VariableSetStmt.GetArgs())
+			if value, ok := transactionReadOnly(n.VariableSetStmt.GetArgs()); ok {
+				class.Name, class.Value = "transaction_read_only", value
+			} else if class.Isolation != "" {
+				class.Name, class.Value = "transaction_isolation", class.Isolation
+			}
+			if class.Name != "" && n.VariableSetStmt.GetName() == "SESSION CHARACTERISTICS" {
+				class.Name = "default_" + class.Name
+			}
+		}
+		class.ReadOnly = true
+	case *pg_query.Node_VariableShowStmt:
+		class.Command = CmdShow
+		class.ReadOnly = true
+		class.ReturnsRows = true
+	case *pg_query.Node_CreateStmt:
+		class.Command = "CREATE TABLE"
+		class.Tables = rangeVarTables(n.CreateStmt.GetRelation())
+	case *pg_query.Node_CreateTableAsStmt:
+		class.Command = "CREATE " + objectTypeName(n.CreateTableAsStmt.GetObjtype())
+		class.Tables = rangeVarTables(n.CreateTableAsStmt.GetInto().GetRel())
+	case *pg_query.Node_ViewStmt:
+		class.Command = "CREATE VIEW"
+		class.Tables = rangeVarTables(n.ViewStmt.GetView())
+	case *pg_query.Node_IndexStmt:
+		class.Command = "CREATE INDEX"
+		class.Tables = rangeVarTables(n.IndexStmt.GetRelation())
+	case *pg_query.Node_CreateTrigStmt:
+		class.Command = "CREATE TRIGGER"
+		class.Tables = rangeVarTables(n.CreateTrigStmt.GetRelation())
+	case *pg_query.Node_RuleStmt:
+		class.Command = "CREATE RULE"
+		class.Tables = rangeVarTables(n.RuleStmt.GetRelation())
+	case *pg_query.Node_CreateSeqStmt:
+		class.Command = "CREATE SEQUENCE"
+		class.Tables = rangeVarTables(n.CreateSeqStmt.GetSequence())
+	case *pg_query.Node_CreateForeignTableStmt:
+		class.Command = "CREATE FOREIGN TABLE"
+		class.Tables = rangeVarTables(n.CreateForeignTableStmt.GetBaseStmt().GetRelation())
+	case *pg_query.Node_CreateForeignServerStmt:
+		class.Command = "CREATE SERVER"
+		class.Name = n.CreateForeignServerStmt.GetServername()
+	case *pg_query.Node_CreateUserMappingStmt:
+		class.Command = "CREATE USER MAPPING"
+		class.Name = n.CreateUserMappingStmt.GetServername()
+	case *pg_query.Node_DropUserMappingStmt:
+		class.Command = "DROP USER MAPPING"
+		class.Name = n.DropUserMappingStmt.GetServername()
+	case *pg_query.Node_CreateExtensionStmt:
+		class.Command = "CREATE EXTENSION"
+		class.Name = n.CreateExtensionStmt.GetExtname()
+	case *pg_query.Node_CreateSchemaStmt:
+		class.Command = "CREATE SCHEMA"
+	case *pg_query.Node_CreatedbStmt:
+		class.Command = "CREATE DATABASE"
+	case *pg_query.Node_DropdbStmt:
+		class.Command = "DROP DATABASE"
+	case *pg_query.Node_CreateFunctionStmt:
+		class.Command = "CREATE FUNCTION"
+		if n.CreateFunctionStmt.GetIsProcedure() {
+			class.Command = "CREATE PROCEDURE"
+		}
+	case *pg_query.Node_DropStmt:
+		class.Command = "DROP " + objectTypeName(n.DropStmt.GetRemoveType())
+		switch n.DropStmt.GetRemoveType() {
+		case pg_query.ObjectType_OBJECT_TABLE, pg_query.ObjectType_OBJECT_VIEW,
+			pg_query.ObjectType_OBJECT_MATVIEW, pg_query.ObjectType_OBJECT_FOREIGN_TABLE:
+			class.Tables = dropObjectNames(n.DropStmt.GetObjects())
+		}
+	case *pg_query.Node_AlterTableStmt:
+		class.Command = "ALTER " + objectTypeName(n.AlterTableStmt.GetObjtype())
+		class.Tables = rangeVarTables(n.AlterTableStmt.GetRelation())
+	case *pg_query.Node_RenameStmt:
+		class.Command = "ALTER " + objectTypeName(n.RenameStmt.GetRenameType())
+		class.Tables = rangeVarTables(n.RenameStmt.GetRelation())
+	case *pg_query.Node_TruncateStmt:
+		class.Command = "TRUNCATE TABLE"
+		class.Tables = walker.tables
+	case *pg_query.Node_RefreshMatViewStmt:
+		class.Command = "REFRESH MATERIALIZED VIEW"
+		class.Tables = rangeVarTables(n.RefreshMatViewStmt.GetRelation())
+	case *pg_query.Node_CopyStmt:
+		class.Command = "COPY"
+		class.Tables = rangeVarTables(n.CopyStmt.GetRelation())
+		class.ReadOnly = !n.CopyStmt.GetIsFrom()
+	case *pg_query.Node_VacuumStmt:
+		class.Command = "VACUUM"
+		if !n.VacuumStmt.GetIsVacuumcmd() {
+			class.Command = "ANALYZE"
+		}
+		class.Tables = walker.tables
+	case *pg_query.Node_PrepareStmt:
+		class.Command = CmdPrepare
+		class.Name = n.PrepareStmt.GetName()
+		class.ReadOnly = true
+	case *pg_query.Node_ExecuteStmt:
+		class.Command = CmdExecute
+		class.Name = n.ExecuteStmt.GetName()
+	case *pg_query.Node_DeallocateStmt:
+		class.Command = CmdDeallocate
+		class.Name = n.DeallocateStmt.GetName()
+		if n.DeallocateStmt.GetName() == "" {
+			class.Command = CmdDeallocateAll
+		}
+		class.ReadOnly = true
+	case *pg_query.Node_DiscardStmt:
+		class.Command = "DISCARD " + strings.TrimPrefix(n.DiscardStmt.GetTarget().String(), "DISCARD_")
+		class.ReadOnly = true
+	case *pg_query.Node_DeclareCursorStmt:
+		class.Command = CmdDeclareCursor
+		class.Name = n.DeclareCursorStmt.GetPortalname()
+		class.Tables = walkTables(n.DeclareCursorStmt.GetQuery())
+		class.ReadOnly = true
+	case *pg_query.Node_FetchStmt:
+		class.Command = CmdFetch
+		class.Name = n.FetchStmt.GetPortalname()
+		class.FetchRows = n.FetchStmt.GetHowMany()
+		if class.FetchRows == math.MaxInt64 {
+			class.FetchRows = -1
+		}
+		class.Scroll = n.FetchStmt.GetDirection() != pg_query.FetchDirection_FETCH_FORWARD
+		if n.FetchStmt.GetIsmove() {
+			class.Command = CmdMove
+		}
+		class.ReadOnly = true
+		class.ReturnsRows = !n.FetchStmt.GetIsmove()
+	case *pg_query.Node_ClosePortalStmt:
+		class.Command = CmdCloseCursor
+		class.Name = n.ClosePortalStmt.GetPortalname()
+		class.ReadOnly = true
+	case *pg_query.Node_ListenStmt:
+		class.Command = CmdListen
+		class.Name = n.ListenStmt.GetConditionname()
+		class.ReadOnly = true
+	case *pg_query.Node_UnlistenStmt:
+		// Name is empty for UNLISTEN *.
+		class.Command = CmdUnlisten
+		class.Name = n.UnlistenStmt.GetConditionname()
+		class.ReadOnly = true
+	case *pg_query.Node_NotifyStmt:
+		class.Command = CmdNotify
+		class.Name = n.NotifyStmt.GetConditionname()
+		class.Value = n.NotifyStmt.GetPayload()
+		class.ReadOnly = true
+	case *pg_query.Node_LockStmt:
+		class.Command = "LOCK TABLE"
+		class.Tables = walker.tables
+		class.ReadOnly = true
+	case *pg_query.Node_CallStmt:
+		class.Command = "CALL"
+	case *pg_query.Node_DoStmt:
+		class.Command = "DO"
+	case *pg_query.Node_CheckPointStmt:
+		class.Command = "CHECKPOINT"
+	case *pg_query.Node_CommentStmt:
+		class.Command = "COMMENT"
+	case *pg_query.Node_GrantStmt:
+		class.Command = "GRANT"
+		if !n.GrantStmt.GetIsGrant() {
+			class.Command = "REVOKE"
+		}
+	case *pg_query.Node_CreateRoleStmt:
+		class.Command = "CREATE ROLE"
+	case *pg_query.Node_DropRoleStmt:
+		class.Command = "DROP ROLE"
+	}
+	return class
+}
+
+// Names of the dropped relations, the last element of each qualified name.
+func dropObjectNames(objects []*pg_query.Node) []string {
+	var names []string
+	for _, object := range objects {
+		items := object.GetList().GetItems()
+		if len(items) == 0 {
+			continue
+		}
+		if name := items[len(items)-1].GetString_().GetSval(); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// DropObjects returns the names of the objects dropped by a DROP statement, or the
+// server of a DROP USER MAPPING statement, and whether they may not exist. Cascade
+// tells whether the objects depending on them are dropped as well.
+func DropObjects(sql string) (names []string, missingOK, cascade bool, err error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, false, false, parseError(err)
+	}
+	if len(tree.Stmts) != 1 {
+		return nil, false, false, fmt.Errorf("not a single statement")
+	}
+	if drop := tree.Stmts[0].GetStmt().GetDropUserMappingStmt(); drop != nil {
+		return []string{drop.GetServername()}, drop.GetMissingOk(), false, nil
+	}
+	drop := tree.Stmts[0].GetStmt().GetDropStmt()
+	if drop == nil {
+		return nil, false, false, fmt.Errorf("not a DROP statement")
+	}
+	for _, object := range drop.GetObjects() {
+		// Objects outside of schemas, such as servers, have a single name.
+		if name := object.GetString_().GetSval(); name != "" {
+			names = append(names, name)
+		}
+	}
+	names = append(names, dropObjectNames(drop.GetObjects())...)
+	return names, drop.GetMissingOk(), drop.GetBehavior() == pg_query.DropBehavior_DROP_CASCADE, nil
+}
+
+// Returns the value of a setting assigned by SET, list values are separated by commas
+// as in SET DateStyle = ISO, MDY. Unquoted words are lowercase, as PostgreSQL parses them.
+func settingValue(args []*pg_query.Node) string {
+	values := make([]string, 0, len(args))
+	for _, arg := range args {
+		if cast := arg.GetTypeCast(); cast != nil {
+			arg = cast.GetArg()
+		}
+		c := arg.GetAConst()
+		switch {
+		case c.GetSval() != nil:
+			values = append(values, c.GetSval().GetSval())
+		case c.GetIval() != nil:
+			values = append(values, strconv.FormatInt(int64(c.GetIval().GetIval()), 10))
+		case c.GetFval() != nil:
+			values = append(values, c.GetFval().GetFval())
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// Returns the access mode set by the options of a transaction, on for READ ONLY and
+// off for READ WRITE. Returns false if the options don't set it.
+func transactionReadOnly(options []*pg_query.Node) (string, bool) {
+	for _, option := range options {
+		if def := option.GetDefElem(); def.GetDefname() == "transaction_read_only" {
+			if def.GetArg().GetAConst().GetIval().GetIval() != 0 {
+				return "on", true
+			}
+			return "off", true
+		}
+	}
+	return "", false
+}
+
+// Returns the isolation level set by the options of a transaction, empty if they don't set it.
+func transactionIsolation(options []*pg_query.Node) string {
+	for _, option := range options {
+		if def := option.GetDefElem(); def.GetDefname() == "transaction_isolation" {
+			return def.GetArg().GetAConst().GetSval().GetSval()
+		}
+	}
+	return ""
+}
+
+func transactionCommand(kind pg_query.TransactionStmtKind) string {
+	switch kind {
+	case pg_query.TransactionStmtKind_TRANS_STMT_BEGIN:
+		return CmdBegin
+	case pg_query.TransactionStmtKind_TRANS_STMT_START:
+		return CmdStart
+	case pg_query.TransactionStmtKind_TRANS_STMT_COMMIT:
+		return CmdCommit
+	case pg_query.TransactionStmtKind_TRANS_STMT_ROLLBACK, pg_query.TransactionStmtKind_TRANS_STMT_ROLLBACK_TO:
+		return CmdRollback
+	case pg_query.TransactionStmtKind_TRANS_STMT_SAVEPOINT:
+		return "SAVEPOINT"
+	case pg_query.TransactionStmtKind_TRANS_STMT_RELEASE:
+		return "RELEASE"
+	case pg_query.TransactionStmtKind_TRANS_STMT_PREPARE:
+		return CmdPrepareTransaction
+	case pg_query.TransactionStmtKind_TRANS_STMT_COMMIT_PREPARED:
+		return CmdCommitPrepared
+	case pg_query.TransactionStmtKind_TRANS_STMT_ROLLBACK_PREPARED:
+		return CmdRollbackPrepared
+	}
+	return CmdUnknown
+}
+
+// ClassifyQuery parses a SQL query string, can have multiple statements,
+// and classifies each statement in it.
+func ClassifyQuery(sql string) ([]StmtClass, error) {
+	var result []StmtClass
+	if sql == "" {
+		return result, nil
+	}
+
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return result, parseError(err)
+	}
+	return classifyTree(sql, tree), nil
+}
+
+// Classifies each statement of the parse tree of a SQL query string.
+func classifyTree(sql string, tree *pg_query.ParseResult) []StmtClass {
+	var result []StmtClass
+	for _, raw := range tree.Stmts {
+		class := Classify(raw.GetStmt())
+		class.SQL = stmtText(sql, raw)
+		result = append(result, class)
+	}
+	return result
+}
+
+// Extract the statement text from the original query string.
+func stmtText(sql string, raw *pg_query.RawStmt) string {
+	start := int(raw.GetStmtLocation())
+	end := len(sql)
+	if raw.GetStmtLen() != 0 {
+		end = start + int(raw.GetStmtLen())
+	}
+	if start < 0 || start > end || end > len(sql) {
+		return strings.TrimSpace(sql)
+	}
+	return strings.TrimSpace(sql[start:end])
+}
+
+// DeclareCursorQuery returns the query of a DECLARE CURSOR statement.
+func DeclareCursorQuery(sql string) (string, error) {
+	scan, err := pg_query.Scan(sql)
+	if err != nil {
+		return "", err
+	}
+
+	// The query follows the first FOR keyword after CURSOR, the cursor
+	// options in between can't contain it.
+	cursor := false
+	for _, token := range scan.GetTokens() {
+		switch {
+		case token.GetToken() == pg_query.Token_CURSOR:
+			cursor = true
+		case cursor && token.GetToken() == pg_query.Token_FOR:
+			return strings.TrimSpace(sql[token.GetEnd():]), nil
+		}
+	}
+	return "", fmt.Errorf("not a DECLARE CURSOR statement")
+}
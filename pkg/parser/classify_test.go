@@ -0,0 +1,240 @@
+package parser_test
+
+import (
+	"errors"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Classify tests", Ordered, func() {
+
+	It("Classify SELECT statement", func() {
+		result, err := parser.ClassifyQuery(`SELECT name FROM employees e JOIN departments d ON e.dep_id = d.id`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(1))
+		Expect(result[0].Command).To(Equal(parser.CmdSelect))
+		Expect(result[0].ReadOnly).To(BeTrue())
+		Expect(result[0].ReturnsRows).To(BeTrue())
+		Expect(result[0].Tables).To(Equal([]string{"employees", "departments"}))
+		Expect(result[0].Tag(3)).To(Equal("SELECT 3"))
+	})
+
+	It("Classify SELECT INTO statement", func() {
+		result, err := parser.ClassifyQuery(`SELECT * INTO backup FROM employees`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(1))
+		Expect(result[0].ReadOnly).To(BeFalse())
+		Expect(result[0].ReturnsRows).To(BeFalse())
+	})
+
+	It("Classify DML statements", func() {
+		result, err := parser.ClassifyQuery(`INSERT INTO kine(name) VALUES($1) RETURNING id;
+			UPDATE kine SET name = $1 WHERE id IN (SELECT id FROM old);
+			DELETE FROM kine WHERE id = 1`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(3))
+
+		Expect(result[0].Command).To(Equal(parser.CmdInsert))
+		Expect(result[0].ReadOnly).To(BeFalse())
+		Expect(result[0].ReturnsRows).To(BeTrue())
+		Expect(result[0].Tables).To(Equal([]string{"kine"}))
+		Expect(result[0].Tag(1)).To(Equal("INSERT 0 1"))
+		Expect(result[0].Params).To(Equal(1))
+		Expect(result[0].SQL).To(Equal(`INSERT INTO kine(name) VALUES($1) RETURNING id`))
+
+		Expect(result[1].Command).To(Equal(parser.CmdUpdate))
+		Expect(result[1].ReturnsRows).To(BeFalse())
+		Expect(result[1].Tables).To(Equal([]string{"kine"}))
+		Expect(result[1].Tag(2)).To(Equal("UPDATE 2"))
+
+		Expect(result[2].Command).To(Equal(parser.CmdDelete))
+		Expect(result[2].SQL).To(Equal(`DELETE FROM kine WHERE id = 1`))
+	})
+
+	It("Classify DDL statements", func() {
+		result, err := parser.ClassifyQuery(`CREATE TABLE kine (id INTEGER PRIMARY KEY);
+			CREATE INDEX kine_id ON kine (id);
+			DROP TABLE kine, other;
+			ALTER TABLE kine ADD COLUMN name TEXT`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(4))
+		Expect(result[0].Command).To(Equal("CREATE TABLE"))
+		Expect(result[1].Command).To(Equal("CREATE INDEX"))
+		Expect(result[2].Command).To(Equal("DROP TABLE"))
+		Expect(result[2].Tables).To(Equal([]string{"kine", "other"}))
+		Expect(result[3].Command).To(Equal("ALTER TABLE"))
+		for _, class := range result {
+			Expect(class.ReadOnly).To(BeFalse())
+			Expect(class.Tables).To(ContainElement("kine"))
+			Expect(class.Tag(0)).To(Equal(class.Command))
+		}
+	})
+
+	It("Classify foreign table statements", func() {
+		result, err := parser.ClassifyQuery(`CREATE EXTENSION postgres_fdw;
+			CREATE SERVER node2 FOREIGN DATA WRAPPER postgres_fdw;
+			CREATE USER MAPPING FOR public SERVER node2;
+			CREATE FOREIGN TABLE remote_items (id integer) SERVER node2;
+			DROP FOREIGN TABLE remote_items;
+			DROP SERVER node2`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(6))
+		Expect(result[0].Command).To(Equal("CREATE EXTENSION"))
+		Expect(result[0].Name).To(Equal("postgres_fdw"))
+		Expect(result[1].Command).To(Equal("CREATE SERVER"))
+		Expect(result[2].Command).To(Equal("CREATE USER MAPPING"))
+		Expect(result[2].Name).To(Equal("node2"))
+		Expect(result[3].Command).To(Equal("CREATE FOREIGN TABLE"))
+		Expect(result[3].Tables).To(Equal([]string{"remote_items"}))
+		Expect(result[4].Command).To(Equal("DROP FOREIGN TABLE"))
+		Expect(result[5].Command).To(Equal("DROP SERVER"))
+	})
+
+	It("Classify session and transaction statements", func() {
+		result, err := parser.ClassifyQuery(`BEGIN; SET search_path TO public; RESET ALL; SHOW server_version; ROLLBACK TO SAVEPOINT sp; COMMIT`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(6))
+		Expect(result[0].Command).To(Equal(parser.CmdBegin))
+		Expect(result[1].Command).To(Equal(parser.CmdSet))
+		Expect(result[2].Command).To(Equal(parser.CmdReset))
+		Expect(result[3].Command).To(Equal(parser.CmdShow))
+		Expect(result[3].ReturnsRows).To(BeTrue())
+		Expect(result[4].Command).To(Equal(parser.CmdRollback))
+		Expect(result[5].Command).To(Equal(parser.CmdCommit))
+		for _, class := range result {
+			Expect(class.ReadOnly).To(BeTrue())
+		}
+	})
+
+	It("Classify settings set for the transaction", func() {
+		result, err := parser.ClassifyQuery(`SET LOCAL search_path TO app; SET LOCAL TimeZone TO DEFAULT; SET search_path TO public`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(3))
+		Expect(result[0].Command).To(Equal(parser.CmdSet))
+		Expect(result[0].Tag(0)).To(Equal("SET"))
+		Expect(result[0].Name).To(Equal("search_path"))
+		Expect(result[0].Value).To(Equal("app"))
+		Expect(result[0].Local).To(BeTrue())
+		Expect(result[1].Name).To(Equal("timezone"))
+		Expect(result[1].Value).To(BeEmpty())
+		Expect(result[1].Local).To(BeTrue())
+		Expect(result[2].Local).To(BeFalse())
+	})
+
+	It("Classify isolation levels of transactions", func() {
+		result, err := parser.ClassifyQuery(`BEGIN ISOLATION LEVEL SERIALIZABLE, READ ONLY; START TRANSACTION;
+			SET TRANSACTION ISOLATION LEVEL READ COMMITTED; SET SESSION CHARACTERISTICS AS TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ WRITE`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var levels [][4]string
+		for _, class := range result {
+			levels = append(levels, [4]string{class.Command, class.Name, class.Value, class.Isolation})
+		}
+		Expect(levels).To(Equal([][4]string{
+			{parser.CmdBegin, "transaction_read_only", "on", "serializable"},
+			{parser.CmdStart, "", "", ""},
+			{parser.CmdSet, "transaction_isolation", "read committed", "read committed"},
+			{parser.CmdSet, "default_transaction_read_only", "off", "repeatable read"},
+		}))
+	})
+
+	It("Classify access modes of transactions", func() {
+		result, err := parser.ClassifyQuery(`BEGIN READ ONLY; START TRANSACTION ISOLATION LEVEL SERIALIZABLE, READ WRITE;
+			BEGIN ISOLATION LEVEL REPEATABLE READ; SET TRANSACTION READ ONLY; SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var modes [][3]string
+		for _, class := range result {
+			modes = append(modes, [3]string{class.Command, class.Name, class.Value})
+		}
+		Expect(modes).To(Equal([][3]string{
+			{parser.CmdBegin, "transaction_read_only", "on"},
+			{parser.CmdStart, "transaction_read_only", "off"},
+			{parser.CmdBegin, "", ""},
+			{parser.CmdSet, "transaction_read_only", "on"},
+			{parser.CmdSet, "default_transaction_read_only", "on"},
+		}))
+	})
+
+	It("Classify prepared transaction statements", func() {
+		result, err := parser.ClassifyQuery(`PREPARE TRANSACTION 'tx1'; COMMIT PREPARED 'tx1'; ROLLBACK PREPARED 'tx2'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(3))
+		for i, command := range []string{parser.CmdPrepareTransaction, parser.CmdCommitPrepared, parser.CmdRollbackPrepared} {
+			Expect(result[i].Command).To(Equal(command))
+			Expect(result[i].ReadOnly).To(BeFalse())
+		}
+		Expect(result[0].Name).To(Equal("tx1"))
+		Expect(result[2].Name).To(Equal("tx2"))
+	})
+
+	It("Classify settings", func() {
+		result, err := parser.ClassifyQuery(`SET TimeZone = 'UTC'; SET TIME ZONE 'Europe/Paris'; SET DateStyle = ISO, MDY;
+			SET extra_float_digits = 3; SET enable_seqscan = false; SET search_path TO DEFAULT; RESET DateStyle; RESET ALL`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(8))
+
+		var settings [][3]string
+		for _, class := range result {
+			settings = append(settings, [3]string{class.Command, class.Name, class.Value})
+		}
+		Expect(settings).To(Equal([][3]string{
+			{parser.CmdSet, "timezone", "UTC"},
+			{parser.CmdSet, "timezone", "Europe/Paris"},
+			{parser.CmdSet, "datestyle", "iso, mdy"},
+			{parser.CmdSet, "extra_float_digits", "3"},
+			{parser.CmdSet, "enable_seqscan", "false"},
+			{parser.CmdSet, "search_path", ""},
+			{parser.CmdReset, "datestyle", ""},
+			{parser.CmdReset, "", ""},
+		}))
+	})
+
+	It("Classify prepared statement and discard statements", func() {
+		result, err := parser.ClassifyQuery(`DEALLOCATE stmt1; DEALLOCATE ALL; DISCARD ALL; DISCARD TEMP`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(4))
+		Expect(result[0].Command).To(Equal(parser.CmdDeallocate))
+		Expect(result[0].Name).To(Equal("stmt1"))
+		Expect(result[1].Command).To(Equal(parser.CmdDeallocateAll))
+		Expect(result[2].Command).To(Equal(parser.CmdDiscardAll))
+		Expect(result[3].Command).To(Equal(parser.CmdDiscardTemp))
+	})
+
+	It("Classify cursor statements", func() {
+		result, err := parser.ClassifyQuery(`DECLARE c NO SCROLL CURSOR FOR SELECT id FROM kine; FETCH FORWARD 100 FROM c; FETCH ALL FROM c; MOVE PRIOR FROM c; CLOSE c`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(5))
+		Expect(result[0].Command).To(Equal(parser.CmdDeclareCursor))
+		Expect(result[0].Name).To(Equal("c"))
+		Expect(result[0].Tables).To(Equal([]string{"kine"}))
+		Expect(result[1].FetchRows).To(Equal(int64(100)))
+		Expect(result[1].ReturnsRows).To(BeTrue())
+		Expect(result[2].FetchRows).To(Equal(int64(-1)))
+		Expect(result[3].Command).To(Equal(parser.CmdMove))
+		Expect(result[3].Scroll).To(BeTrue())
+		Expect(result[4].Command).To(Equal(parser.CmdCloseCursor))
+
+		query, err := parser.DeclareCursorQuery(result[0].SQL)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(Equal("SELECT id FROM kine"))
+	})
+
+	It("Reports syntax errors with their position", func() {
+		_, err := parser.ClassifyQuery(`SELECT * FORM kine`)
+		var pgErr *pgerror.Error
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal(pgerror.SyntaxError))
+		Expect(pgErr.Message).To(Equal(`syntax error at or near "FORM"`))
+		Expect(pgErr.Position).To(Equal(int32(10)))
+
+		// Positions count characters, not bytes.
+		_, err = parser.ClassifyQuery(`SELECT 'é', * FORM kine`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Position).To(Equal(int32(15)))
+	})
+})
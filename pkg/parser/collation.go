@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Collations SQLite knows, and the PostgreSQL collations comparing bytes as SQLite's
+// binary collation does. The default collation of databases is C.
+var collations = map[string]string{
+	"binary": "binary", "nocase": "nocase", "rtrim": "rtrim",
+	"C": "binary", "POSIX": "binary", "ucs_basic": "binary", "default": "binary",
+}
+
+// Returns the SQLite collation of a qualified collation name.
+func sqliteCollation(names []*pg_query.Node) (string, error) {
+	var name string
+	if len(names) > 0 {
+		name = names[len(names)-1].GetString_().GetSval()
+	}
+	return collationName(name)
+}
+
+// Returns the SQLite collation of a collation name. Collations of locales, named after
+// libc locales as en_US or en_US.utf8, or after ICU locales as en-US-x-icu, are the
+// collations registered for their locale on SQLite connections, as are the names of
+// the registered collations.
+func collationName(name string) (string, error) {
+	if collation, ok := collations[name]; ok {
+		return collation, nil
+	} else if collation, ok := collations[strings.ToLower(name)]; ok && collation == strings.ToLower(name) {
+		return collation, nil
+	}
+
+	locale := strings.TrimSuffix(name, "-x-icu")
+	if i := strings.IndexByte(locale, '.'); i >= 0 {
+		// Encodings of libc locales, all databases are UTF-8.
+		locale = locale[:i]
+	}
+	if collation, ok := collations[locale]; ok {
+		return collation, nil
+	} else if name == "unicode" {
+		locale = "und"
+	}
+	if collation, ok := sqlite.Collation(strings.ReplaceAll(locale, "_", "-")); ok {
+		return collation, nil
+	}
+	return "", pgerror.Newf(pgerror.UndefinedObject, `collation %q for encoding "UTF8" does not exist`, name)
+}
+
+// Collations translates the COLLATE clauses of a statement, replacing PostgreSQL
+// collations by their SQLite equivalents in the statement text. Statements without
+// COLLATE clauses are returned as is.
+func Collations(sql string) (string, error) {
+	scan, err := pg_query.Scan(sql)
+	if err != nil {
+		return "", parseError(err)
+	}
+	tokens := scan.GetTokens()
+
+	// Clauses are replaced from the last one so the locations of the others remain.
+	for i := len(tokens) - 2; i >= 0; i-- {
+		if tokens[i].GetToken() != pg_query.Token_COLLATE {
+			continue
+		}
+		// Collation names may be qualified, the schema is ignored.
+		last := i + 1
+		for last+2 < len(tokens) && tokens[last+1].GetToken() == pg_query.Token_ASCII_46 {
+			last += 2
+		}
+		start, end := int(tokens[i+1].GetStart()), int(tokens[last].GetEnd())
+		collation, err := collationName(identifierText(sql[tokens[last].GetStart():end]))
+		if err != nil {
+			return "", err
+		}
+		sql = sql[:start] + quoteIdentifier(collation) + sql[end:]
+	}
+	return sql, nil
+}
+
+// Returns the name of an identifier of a statement text, unquoted or folded to lower case.
+func identifierText(text string) string {
+	if unquoted, ok := strings.CutPrefix(text, `"`); ok {
+		return strings.ReplaceAll(strings.TrimSuffix(unquoted, `"`), `""`, `"`)
+	}
+	return strings.ToLower(text)
+}
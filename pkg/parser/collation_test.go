@@ -0,0 +1,33 @@
+package parser_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+var _ = Describe("Collations", func() {
+
+	It("Translate collations of locales", func() {
+		sql, err := parser.Collations(`CREATE TABLE users (name text COLLATE "en_US.utf8", nick varchar(20) collate "de-DE-x-icu", code text COLLATE "C")`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`CREATE TABLE users (name text COLLATE "en-US", nick varchar(20) collate "de", code text COLLATE "binary")`))
+
+		sql, err = parser.Collations(`SELECT name FROM users WHERE name <> 'a COLLATE b' ORDER BY name COLLATE pg_catalog."unicode", nick COLLATE pg_catalog.default`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`SELECT name FROM users WHERE name <> 'a COLLATE b' ORDER BY name COLLATE "und", nick COLLATE "binary"`))
+	})
+
+	It("Translate collations of indexes", func() {
+		sql, _, err := parser.CreateIndex(`CREATE INDEX users_name ON users (name COLLATE "sv_SE", lower(nick) COLLATE "C")`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`CREATE INDEX "users_name" ON "users" ("name" COLLATE "sv", (lower(nick)) COLLATE "binary")`))
+	})
+
+	It("Reject unknown collations", func() {
+		_, err := parser.Collations(`SELECT name FROM users ORDER BY name COLLATE "xx_YY"`)
+		Expect(pgerror.Convert(err).Code).To(Equal(pgerror.UndefinedObject))
+	})
+})
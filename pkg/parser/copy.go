@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Formats of COPY data.
+const (
+	CopyText   = "text"
+	CopyCSV    = "csv"
+	CopyBinary = "binary"
+)
+
+// CopyFrom is a COPY FROM STDIN statement, loading the rows of the data sent by the client
+// into a table.
+type CopyFrom struct {
+	Schema    string
+	Table     string
+	Columns   []string // Columns of the data, empty for all columns of the table.
+	Format    string   // CopyText, CopyCSV or CopyBinary.
+	Delimiter byte     // Separates the columns of text and CSV rows.
+	Null      string   // Represents NULL values in text and CSV rows.
+	Header    bool     // The first row of CSV data is a header, it's skipped.
+	Quote     byte     // Quotes CSV values.
+	Escape    byte     // Escapes quotes in quoted CSV values.
+}
+
+// ParseCopyFrom returns the COPY FROM STDIN statement of sql. Copies from files and
+// programs, to clients, and of queries are not supported.
+func ParseCopyFrom(sql string) (*CopyFrom, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetCopyStmt() == nil {
+		return nil, fmt.Errorf("not a COPY statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetCopyStmt()
+	switch {
+	case !stmt.GetIsFrom():
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "COPY TO is not supported")
+	case stmt.GetFilename() != "" || stmt.GetIsProgram():
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "COPY FROM files and programs is not supported, use COPY FROM STDIN")
+	case stmt.GetWhereClause() != nil:
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "COPY FROM with WHERE is not supported")
+	}
+
+	from := &CopyFrom{
+		Schema: stmt.GetRelation().GetSchemaname(),
+		Table:  stmt.GetRelation().GetRelname(),
+		Format: CopyText,
+	}
+	for _, column := range stmt.GetAttlist() {
+		from.Columns = append(from.Columns, column.GetString_().GetSval())
+	}
+	options := make(map[string]*pg_query.DefElem)
+	for _, option := range stmt.GetOptions() {
+		def := option.GetDefElem()
+		options[def.GetDefname()] = def
+	}
+	if def, ok := options["format"]; ok {
+		from.Format = strings.ToLower(def.GetArg().GetString_().GetSval())
+	}
+	switch from.Format {
+	case CopyText:
+		from.Delimiter, from.Null = '\t', `\N`
+	case CopyCSV:
+		from.Delimiter, from.Quote, from.Escape = ',', '"', '"'
+	case CopyBinary:
+	default:
+		return nil, pgerror.Newf(pgerror.InvalidParameterValue, "COPY format %q not recognized", from.Format)
+	}
+
+	for name, def := range options {
+		value := def.GetArg().GetString_().GetSval()
+		switch name {
+		case "format", "encoding", "freeze":
+			continue
+		case "header":
+			from.Header = def.GetArg() == nil || def.GetArg().GetBoolean().GetBoolval() || isTrue(value)
+		case "null":
+			from.Null = value
+		case "delimiter", "quote", "escape":
+			if len(value) != 1 {
+				return nil, pgerror.Newf(pgerror.FeatureNotSupported, "COPY %s must be a single one-byte character", name)
+			}
+			switch name {
+			case "delimiter":
+				from.Delimiter = value[0]
+			case "quote":
+				from.Quote = value[0]
+			case "escape":
+				from.Escape = value[0]
+			}
+		default:
+			return nil, pgerror.Newf(pgerror.FeatureNotSupported, "COPY option %q is not supported", name)
+		}
+		if from.Format == CopyBinary {
+			return nil, pgerror.Newf(pgerror.SyntaxError, "cannot specify %s in BINARY mode", strings.ToUpper(name))
+		}
+		if from.Format == CopyText && (name == "header" || name == "quote" || name == "escape") {
+			return nil, pgerror.Newf(pgerror.FeatureNotSupported, "COPY %s available only in CSV mode", name)
+		}
+	}
+	return from, nil
+}
+
+// Reports whether a boolean option value is true, as PostgreSQL parses them.
+func isTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "on", "1", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("COPY", func() {
+
+	It("Parse COPY FROM STDIN with format options", func() {
+		from, err := parser.ParseCopyFrom(`COPY billing.items (id, name) FROM STDIN`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(from.Schema).To(Equal("billing"))
+		Expect(from.Table).To(Equal("items"))
+		Expect(from.Columns).To(Equal([]string{"id", "name"}))
+		Expect(from.Format).To(Equal(parser.CopyText))
+		Expect(from.Delimiter).To(Equal(byte('\t')))
+		Expect(from.Null).To(Equal(`\N`))
+
+		from, err = parser.ParseCopyFrom(`COPY items FROM STDIN (FORMAT csv, HEADER, DELIMITER ';', NULL 'none')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(from.Format).To(Equal(parser.CopyCSV))
+		Expect(from.Header).To(BeTrue())
+		Expect(from.Delimiter).To(Equal(byte(';')))
+		Expect(from.Null).To(Equal("none"))
+		Expect(from.Quote).To(Equal(byte('"')))
+
+		from, err = parser.ParseCopyFrom(`COPY items FROM STDIN WITH (FORMAT binary)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(from.Format).To(Equal(parser.CopyBinary))
+	})
+
+	It("Reject unsupported copies and options", func() {
+		_, err := parser.ParseCopyFrom(`COPY items TO STDOUT`)
+		Expect(err).To(MatchError(ContainSubstring("COPY TO is not supported")))
+		_, err = parser.ParseCopyFrom(`COPY items FROM '/tmp/items.csv'`)
+		Expect(err).To(MatchError(ContainSubstring("use COPY FROM STDIN")))
+		_, err = parser.ParseCopyFrom(`COPY items FROM STDIN (FORMAT text, HEADER)`)
+		Expect(err).To(MatchError(ContainSubstring("available only in CSV mode")))
+		_, err = parser.ParseCopyFrom(`COPY items FROM STDIN (FORMAT binary, NULL '')`)
+		Expect(err).To(MatchError(ContainSubstring("in BINARY mode")))
+		_, err = parser.ParseCopyFrom(`COPY items FROM STDIN (DELIMITER '||')`)
+		Expect(err).To(MatchError(ContainSubstring("single one-byte character")))
+	})
+})
@@ -0,0 +1,314 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// SQLite types of the casts of DDL expressions, by PostgreSQL type. The cast
+// type sets the affinity of the value, so temporal types are cast to text.
+var castTypes = map[string]string{
+	"int2": "integer", "int4": "integer", "int8": "integer", "integer": "integer", "bigint": "integer", "smallint": "integer",
+	"float4": "real", "float8": "real", "real": "real",
+	"numeric": "numeric", "decimal": "numeric",
+	"bool": "integer", "boolean": "integer",
+	"text": "text", "varchar": "text", "bpchar": "text", "char": "text", "name": "text", "uuid": "text",
+	"json": "text", "jsonb": "text", "date": "text", "time": "text", "timetz": "text",
+	"timestamp": "text", "timestamptz": "text", "interval": "text",
+	"bytea": "blob",
+}
+
+// Operator classes of indexes serving LIKE prefix searches, which SQLite indexes
+// serve without them.
+var patternOpClasses = []string{"text_pattern_ops", "varchar_pattern_ops", "bpchar_pattern_ops"}
+
+// Replaces casts and collations of expressions by their SQLite equivalents.
+type sqliteExprWalker struct {
+	casts []string // SQLite expressions of the replaced casts.
+}
+
+func (walker *sqliteExprWalker) Visit(node *pg_query.Node) (v Visitor, err error) {
+	switch n := node.GetNode().(type) {
+	case *pg_query.Node_TypeCast:
+		// Casts are replaced by placeholder columns, the argument is translated on its own.
+		typ, err := castType(n.TypeCast.GetTypeName())
+		if err != nil {
+			return nil, err
+		}
+		arg, err := sqliteExpr(n.TypeCast.GetArg())
+		if err != nil {
+			return nil, err
+		}
+		walker.casts = append(walker.casts, fmt.Sprintf("CAST(%s AS %s)", arg, typ))
+		node.Node = &pg_query.Node_ColumnRef{ColumnRef: &pg_query.ColumnRef{Fields: []*pg_query.Node{
+			pg_query.MakeStrNode(castPlaceholder(len(walker.casts) - 1)),
+		}}}
+		return nil, nil
+	case *pg_query.Node_CollateClause:
+		collation, err := sqliteCollation(n.CollateClause.GetCollname())
+		if err != nil {
+			return nil, err
+		}
+		n.CollateClause.Collname = []*pg_query.Node{pg_query.MakeStrNode(collation)}
+	}
+	return walker, nil
+}
+
+func (walker *sqliteExprWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// Returns the name of the column standing for a cast while expressions are translated.
+func castPlaceholder(i int) string {
+	return "kqlite_cast_" + strconv.Itoa(i) + "_"
+}
+
+// Returns the SQLite text of an expression, as deparsed by PostgreSQL except for
+// casts, whose :: syntax SQLite lacks, and collations. The expression is modified.
+func sqliteExpr(expr *pg_query.Node) (string, error) {
+	walker := &sqliteExprWalker{}
+	if err := Walk(walker, expr); err != nil {
+		return "", err
+	}
+	text, err := DeparseExpr(expr)
+	if err != nil {
+		return "", err
+	}
+	for i, cast := range walker.casts {
+		text = strings.Replace(text, castPlaceholder(i), cast, 1)
+	}
+	return text, nil
+}
+
+// Returns the SQLite type of a cast.
+func castType(typeName *pg_query.TypeName) (string, error) {
+	names := typeName.GetNames()
+	if len(names) == 0 {
+		return "", fmt.Errorf("cast without type")
+	}
+	name := names[len(names)-1].GetString_().GetSval()
+	typ, ok := castTypes[name]
+	if !ok || len(typeName.GetArrayBounds()) != 0 {
+		return "", pgerror.Newf(pgerror.FeatureNotSupported, "casts to type %s are not supported in table and index definitions", name)
+	}
+	return typ, nil
+}
+
+// GeneratedColumns translates the generated columns of a CREATE TABLE or ALTER TABLE
+// statement, other statements are returned as is. Generation expressions are translated
+// for SQLite. SQLite can't add stored columns to tables, so added columns are virtual,
+// computed when read with the same results. tsvector columns generated by to_tsvector
+// are virtual columns of the text of their documents. Identity columns are rejected.
+func GeneratedColumns(sql string) (string, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", parseError(err)
+	}
+	if len(tree.Stmts) != 1 {
+		return "", fmt.Errorf("not a single statement")
+	}
+
+	var columns []*pg_query.ColumnDef
+	stored := "STORED"
+	switch n := tree.Stmts[0].GetStmt().GetNode().(type) {
+	case *pg_query.Node_CreateStmt:
+		for _, elt := range n.CreateStmt.GetTableElts() {
+			if column := elt.GetColumnDef(); column != nil {
+				columns = append(columns, column)
+			}
+		}
+	case *pg_query.Node_AlterTableStmt:
+		for _, cmd := range n.AlterTableStmt.GetCmds() {
+			if column := cmd.GetAlterTableCmd().GetDef().GetColumnDef(); column != nil {
+				columns = append(columns, column)
+			}
+		}
+		stored = "VIRTUAL"
+	default:
+		return sql, nil
+	}
+
+	// Generation clauses are replaced in the statement text, from the last one so the
+	// locations of the others remain.
+	type replacement struct {
+		start, end int
+		text       string
+	}
+	var replacements []replacement
+	for _, column := range columns {
+		// tsvector columns are generated as the text of their documents, searched through text search indexes.
+		var document string
+		if names := column.GetTypeName().GetNames(); len(names) > 0 && names[len(names)-1].GetString_().GetSval() == "tsvector" {
+			if document, err = textSearchColumn(column); err != nil {
+				return "", err
+			}
+		}
+		for _, node := range column.GetConstraints() {
+			constraint := node.GetConstraint()
+			switch constraint.GetContype() {
+			case pg_query.ConstrType_CONSTR_IDENTITY:
+				e := pgerror.Newf(pgerror.FeatureNotSupported, "identity column %q is not supported", column.GetColname())
+				e.Hint = "Declare the column as INTEGER PRIMARY KEY, SQLite assigns its values."
+				return "", e
+			case pg_query.ConstrType_CONSTR_GENERATED:
+				start, end, err := generationClause(sql, int(constraint.GetLocation()))
+				if err != nil {
+					return "", err
+				}
+				text := fmt.Sprintf("GENERATED ALWAYS AS (%s) VIRTUAL", document)
+				if document == "" {
+					expr, err := sqliteExpr(constraint.GetRawExpr())
+					if err != nil {
+						return "", err
+					}
+					text = fmt.Sprintf("GENERATED ALWAYS AS (%s) %s", expr, stored)
+				}
+				replacements = append(replacements, replacement{start: start, end: end, text: text})
+			}
+		}
+	}
+	for i := len(replacements) - 1; i >= 0; i-- {
+		r := replacements[i]
+		sql = sql[:r.start] + r.text + sql[r.end:]
+	}
+	return sql, nil
+}
+
+// Returns the span of the GENERATED ALWAYS AS (...) STORED clause of a column,
+// starting at or after the location of its constraint.
+func generationClause(sql string, location int) (start, end int, err error) {
+	scan, err := pg_query.Scan(sql)
+	if err != nil {
+		return 0, 0, err
+	}
+	start, depth := -1, 0
+	for _, token := range scan.GetTokens() {
+		switch {
+		case int(token.GetStart()) < location:
+		case start < 0:
+			if token.GetToken() == pg_query.Token_GENERATED {
+				start = int(token.GetStart())
+			}
+		case token.GetToken() == pg_query.Token_ASCII_40:
+			depth++
+		case token.GetToken() == pg_query.Token_ASCII_41:
+			depth--
+		case depth == 0 && token.GetToken() == pg_query.Token_STORED:
+			return start, int(token.GetEnd()), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("generated column clause not found")
+}
+
+// CreateIndex translates a CREATE INDEX statement for SQLite, or returns the text search
+// index it creates, if it is a GIN or GiST index of documents. Expressions and partial
+// index predicates are translated, unnamed indexes are named as PostgreSQL names them.
+// Variants without SQLite equivalent are rejected: access methods other than B-trees,
+// operator classes, NULLS NOT DISTINCT and included columns of unique indexes. Included
+// columns of other indexes become trailing key columns, which cover the same queries.
+// CONCURRENTLY, NULLS FIRST/LAST, storage parameters and tablespaces only affect how
+// indexes are built and stored, and are ignored.
+func CreateIndex(sql string) (string, *TextSearchIndex, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetIndexStmt() == nil {
+		return "", nil, fmt.Errorf("not a CREATE INDEX statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetIndexStmt()
+	if index, err := textSearchIndex(stmt); err != nil || index != nil {
+		return "", index, err
+	}
+
+	if method := stmt.GetAccessMethod(); method != "btree" && method != "hash" {
+		e := pgerror.Newf(pgerror.FeatureNotSupported, "access method %q is not supported", method)
+		e.Hint = "SQLite indexes are B-trees, create the index without USING."
+		return "", nil, e
+	}
+	if stmt.GetNullsNotDistinct() {
+		return "", nil, pgerror.New(pgerror.FeatureNotSupported, "NULLS NOT DISTINCT is not supported")
+	}
+	params := stmt.GetIndexParams()
+	if including := stmt.GetIndexIncludingParams(); len(including) != 0 {
+		if stmt.GetUnique() {
+			return "", nil, pgerror.New(pgerror.FeatureNotSupported, "included columns of unique indexes are not supported")
+		}
+		params = append(params, including...)
+	}
+
+	var columns, names []string
+	for _, param := range params {
+		elem := param.GetIndexElem()
+		var column string
+		if expr := elem.GetExpr(); expr != nil {
+			text, err := sqliteExpr(expr)
+			if err != nil {
+				return "", nil, err
+			}
+			column = "(" + text + ")"
+			if fn := expr.GetFuncCall(); fn != nil {
+				funcname := fn.GetFuncname()
+				names = append(names, funcname[len(funcname)-1].GetString_().GetSval())
+			} else {
+				names = append(names, "expr")
+			}
+		} else {
+			column = quoteIdentifier(elem.GetName())
+			names = append(names, elem.GetName())
+		}
+
+		if opclass := elem.GetOpclass(); len(opclass) != 0 {
+			name := opclass[len(opclass)-1].GetString_().GetSval()
+			if !slices.Contains(patternOpClasses, name) {
+				return "", nil, pgerror.Newf(pgerror.FeatureNotSupported, "operator class %q is not supported", name)
+			}
+		}
+		if collation := elem.GetCollation(); len(collation) != 0 {
+			name, err := sqliteCollation(collation)
+			if err != nil {
+				return "", nil, err
+			}
+			column += " COLLATE " + quoteIdentifier(name)
+		}
+		switch elem.GetOrdering() {
+		case pg_query.SortByDir_SORTBY_ASC:
+			column += " ASC"
+		case pg_query.SortByDir_SORTBY_DESC:
+			column += " DESC"
+		}
+		columns = append(columns, column)
+	}
+
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if stmt.GetUnique() {
+		b.WriteString("UNIQUE ")
+	}
+	b.WriteString("INDEX ")
+	if stmt.GetIfNotExists() {
+		b.WriteString("IF NOT EXISTS ")
+	}
+	// SQLite qualifies the index rather than its table.
+	relation := stmt.GetRelation()
+	if schema := relation.GetSchemaname(); schema != "" && schema != "public" && schema != "main" {
+		b.WriteString(quoteIdentifier(schema) + ".")
+	}
+	name := stmt.GetIdxname()
+	if name == "" {
+		name = relation.GetRelname() + "_" + strings.Join(names, "_") + "_idx"
+	}
+	fmt.Fprintf(&b, "%s ON %s (%s)", quoteIdentifier(name), quoteIdentifier(relation.GetRelname()), strings.Join(columns, ", "))
+	if where := stmt.GetWhereClause(); where != nil {
+		text, err := sqliteExpr(where)
+		if err != nil {
+			return "", nil, err
+		}
+		b.WriteString(" WHERE " + text)
+	}
+	return b.String(), nil, nil
+}
@@ -0,0 +1,57 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DDL translation", func() {
+
+	It("Translate generated columns", func() {
+		sql, err := parser.GeneratedColumns(`CREATE TABLE items (id integer PRIMARY KEY, price numeric, qty int,
+			total numeric GENERATED ALWAYS AS (price * qty::numeric) STORED, label text GENERATED ALWAYS AS (upper(name) COLLATE "C") STORED)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`CREATE TABLE items (id integer PRIMARY KEY, price numeric, qty int,
+			total numeric GENERATED ALWAYS AS (price * CAST(qty AS numeric)) STORED, label text GENERATED ALWAYS AS (upper(name) COLLATE "binary") STORED)`))
+
+		sql, err = parser.GeneratedColumns(`ALTER TABLE items ADD COLUMN cents int GENERATED ALWAYS AS ((price * 100)::int) STORED`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`ALTER TABLE items ADD COLUMN cents int GENERATED ALWAYS AS (CAST(price * 100 AS integer)) VIRTUAL`))
+
+		sql, err = parser.GeneratedColumns(`CREATE TABLE t (id integer PRIMARY KEY)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`CREATE TABLE t (id integer PRIMARY KEY)`))
+	})
+
+	It("Reject identity columns and unsupported casts", func() {
+		_, err := parser.GeneratedColumns(`CREATE TABLE t (id int GENERATED ALWAYS AS IDENTITY)`)
+		Expect(pgerror.Convert(err).Code).To(Equal(pgerror.FeatureNotSupported))
+		_, err = parser.GeneratedColumns(`CREATE TABLE t (a text, v tsvector GENERATED ALWAYS AS (a::tsvector) STORED)`)
+		Expect(pgerror.Convert(err).Code).To(Equal(pgerror.FeatureNotSupported))
+	})
+
+	It("Translate partial and expression indexes", func() {
+		sql, _, err := parser.CreateIndex(`CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS users_email ON public.users USING btree (lower(email) text_pattern_ops) WHERE deleted_at IS NULL`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`CREATE UNIQUE INDEX IF NOT EXISTS "users_email" ON "users" ((lower(email))) WHERE deleted_at IS NULL`))
+
+		sql, _, err = parser.CreateIndex(`CREATE INDEX ON events (created_at DESC NULLS LAST, ((data->>'kind')::int)) INCLUDE (id)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`CREATE INDEX "events_created_at_expr_id_idx" ON "events" ("created_at" DESC, (CAST(data ->> 'kind' AS integer)), "id")`))
+	})
+
+	It("Reject index variants without SQLite equivalent", func() {
+		for _, sql := range []string{
+			`CREATE INDEX events_time ON events USING brin (created_at)`,
+			`CREATE UNIQUE INDEX users_email ON users (email) NULLS NOT DISTINCT`,
+			`CREATE UNIQUE INDEX users_email ON users (email) INCLUDE (name)`,
+			`CREATE INDEX users_name ON users (name varchar_ops)`,
+		} {
+			_, _, err := parser.CreateIndex(sql)
+			Expect(pgerror.Convert(err).Code).To(Equal(pgerror.FeatureNotSupported), sql)
+		}
+	})
+})
@@ -0,0 +1,28 @@
+package parser
+
+import (
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// Deparse returns the SQL text of statement nodes, such as statements changed by Apply,
+// separated by semicolons.
+func Deparse(stmts ...*pg_query.Node) (string, error) {
+	tree := &pg_query.ParseResult{Stmts: make([]*pg_query.RawStmt, len(stmts))}
+	for i, stmt := range stmts {
+		tree.Stmts[i] = &pg_query.RawStmt{Stmt: stmt}
+	}
+	return pg_query.Deparse(tree)
+}
+
+// DeparseExpr returns the SQL text of an expression node.
+func DeparseExpr(expr *pg_query.Node) (string, error) {
+	sql, err := Deparse(&pg_query.Node{Node: &pg_query.Node_SelectStmt{SelectStmt: &pg_query.SelectStmt{
+		TargetList: []*pg_query.Node{pg_query.MakeResTargetNodeWithVal(expr, -1)},
+	}}})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(sql, "SELECT "), nil
+}
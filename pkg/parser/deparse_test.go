@@ -0,0 +1,45 @@
+package parser_test
+
+import (
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deparse", func() {
+
+	It("Deparse statements and expressions changed by Apply", func() {
+		tree, err := pg_query.Parse(`SELECT name FROM items WHERE price > $2 AND stock < $1; DELETE FROM items WHERE id = $1`)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Inject a limit and number the parameters in order of appearance.
+		var stmts []*pg_query.Node
+		for _, raw := range tree.Stmts {
+			numbers := map[int32]int32{}
+			stmts = append(stmts, parser.Apply(raw.Stmt, func(c *parser.Cursor) bool {
+				if sel := c.Node().GetSelectStmt(); sel != nil && sel.LimitCount == nil {
+					sel.LimitCount = pg_query.MakeAConstIntNode(100, -1)
+					sel.LimitOption = pg_query.LimitOption_LIMIT_OPTION_COUNT
+				}
+				if param := c.Node().GetParamRef(); param != nil {
+					if _, ok := numbers[param.Number]; !ok {
+						numbers[param.Number] = int32(len(numbers) + 1)
+					}
+					param.Number = numbers[param.Number]
+				}
+				return true
+			}, nil))
+		}
+		sql, err := parser.Deparse(stmts...)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`SELECT name FROM items WHERE price > $1 AND stock < $2 LIMIT 100; DELETE FROM items WHERE id = $1`))
+
+		where := tree.Stmts[0].Stmt.GetSelectStmt().GetWhereClause()
+		expr, err := parser.DeparseExpr(where)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expr).To(Equal(`price > $1 AND stock < $2`))
+	})
+})
@@ -0,0 +1,316 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Hint of the DO blocks that can't be executed.
+const doHint = "DO blocks may only hold SQL statements, nested BEGIN ... END blocks and EXCEPTION WHEN ... THEN handlers."
+
+// DoBlock is a block of a DO statement, PL/pgSQL restricted to a list of SQL statements
+// with exception handlers.
+type DoBlock struct {
+	Stmts    []DoStmt
+	Handlers []DoHandler // Handlers of the errors of the statements, in order.
+}
+
+// DoStmt is a statement of a DO block, a SQL statement or a nested block.
+type DoStmt struct {
+	SQL   string
+	Block *DoBlock // Nested block, SQL is empty then.
+}
+
+// DoHandler is an exception handler of a DO block, the errors it handles roll back the
+// statements of the block and execute its statements instead.
+type DoHandler struct {
+	Conditions []string // SQLSTATE codes of the errors handled, or "others" for all errors.
+	Stmts      []DoStmt
+}
+
+// Handles reports whether the handler handles errors of a SQLSTATE code. Codes of error
+// classes, ending in 000, handle the errors of their class, as in PostgreSQL.
+func (h *DoHandler) Handles(code string) bool {
+	for _, condition := range h.Conditions {
+		switch {
+		case condition == "others":
+			if code != pgerror.QueryCanceled {
+				return true
+			}
+		case condition == code:
+			return true
+		case strings.HasSuffix(condition, "000") && strings.HasPrefix(code, condition[:2]):
+			return true
+		}
+	}
+	return false
+}
+
+// SQLSTATE codes of the condition names of exception handlers.
+var conditionCodes = map[string]string{
+	"feature_not_supported":          pgerror.FeatureNotSupported,
+	"invalid_parameter_value":        pgerror.InvalidParameterValue,
+	"division_by_zero":               "22012",
+	"integrity_constraint_violation": pgerror.IntegrityConstraintViolation,
+	"not_null_violation":             pgerror.NotNullViolation,
+	"foreign_key_violation":          pgerror.ForeignKeyViolation,
+	"unique_violation":               pgerror.UniqueViolation,
+	"check_violation":                pgerror.CheckViolation,
+	"invalid_schema_name":            pgerror.InvalidSchemaName,
+	"syntax_error":                   pgerror.SyntaxError,
+	"insufficient_privilege":         pgerror.InsufficientPrivilege,
+	"undefined_column":               pgerror.UndefinedColumn,
+	"undefined_function":             pgerror.UndefinedFunction,
+	"undefined_table":                pgerror.UndefinedTable,
+	"undefined_object":               pgerror.UndefinedObject,
+	"duplicate_column":               "42701",
+	"duplicate_database":             "42P04",
+	"duplicate_function":             pgerror.DuplicateFunction,
+	"duplicate_schema":               pgerror.DuplicateSchema,
+	"duplicate_table":                pgerror.DuplicateTable,
+	"duplicate_object":               pgerror.DuplicateObject,
+	"datatype_mismatch":              pgerror.DatatypeMismatch,
+	"wrong_object_type":              pgerror.WrongObjectType,
+	"invalid_function_definition":    pgerror.InvalidFunctionDefinition,
+	"object_in_use":                  pgerror.ObjectInUse,
+	"lock_not_available":             pgerror.LockNotAvailable,
+	"raise_exception":                "P0001",
+}
+
+// Names of the PL/pgSQL statements that DO blocks can't hold, by kind.
+var plpgsqlStmtNames = map[string]string{
+	"assign":       "assignments",
+	"dynexecute":   "EXECUTE",
+	"exit":         "EXIT and CONTINUE",
+	"foreach_a":    "FOREACH",
+	"fori":         "FOR",
+	"fors":         "FOR",
+	"forc":         "FOR",
+	"dynfors":      "FOR",
+	"getdiag":      "GET DIAGNOSTICS",
+	"return":       "RETURN",
+	"return_next":  "RETURN NEXT",
+	"return_query": "RETURN QUERY",
+}
+
+// ParseDo returns the block of a DO statement. Blocks in other languages than PL/pgSQL,
+// and blocks with variables or PL/pgSQL statements other than SQL statements, PERFORM and
+// nested blocks, are rejected.
+func ParseDo(sql string) (*DoBlock, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetDoStmt() == nil {
+		return nil, fmt.Errorf("not a DO statement")
+	}
+	language, body := "plpgsql", ""
+	for _, arg := range tree.Stmts[0].GetStmt().GetDoStmt().GetArgs() {
+		switch def := arg.GetDefElem(); def.GetDefname() {
+		case "language":
+			language = strings.ToLower(def.GetArg().GetString_().GetSval())
+		case "as":
+			body = def.GetArg().GetString_().GetSval()
+		}
+	}
+	if language != "plpgsql" {
+		e := pgerror.Newf(pgerror.FeatureNotSupported, "language %s is not supported in DO blocks", language)
+		e.Hint = doHint
+		return nil, e
+	}
+
+	// PL/pgSQL is parsed as the body of a function.
+	tag := "$kqlite_do$"
+	for strings.Contains(body, tag) {
+		tag = tag[:len(tag)-1] + "_$"
+	}
+	text, err := pg_query.ParsePlPgSqlToJSON("CREATE FUNCTION kqlite_do() RETURNS void LANGUAGE plpgsql AS " + tag + body + tag)
+	if err != nil {
+		// Positions are those of the wrapping function.
+		if e, ok := parseError(err).(*pgerror.Error); ok {
+			e.Position = 0
+			return nil, e
+		}
+		return nil, err
+	}
+	var functions []struct {
+		Function struct {
+			Datums []struct {
+				Var *struct {
+					Refname string `json:"refname"`
+				} `json:"PLpgSQL_var"`
+			} `json:"datums"`
+			Action plpgsqlStmt `json:"action"`
+		} `json:"PLpgSQL_function"`
+	}
+	if err := json.Unmarshal([]byte(text), &functions); err != nil {
+		return nil, err
+	}
+	if len(functions) != 1 {
+		return nil, fmt.Errorf("not a single PL/pgSQL block")
+	}
+	for _, datum := range functions[0].Function.Datums {
+		switch name := datum.Var; {
+		case name != nil && (name.Refname == "found" || name.Refname == "sqlstate" || name.Refname == "sqlerrm"):
+		default:
+			e := pgerror.New(pgerror.FeatureNotSupported, "variables are not supported in DO blocks")
+			e.Hint = doHint
+			return nil, e
+		}
+	}
+
+	// The function ends with an implicit RETURN, blocks with exception handlers are
+	// wrapped in a block of their own.
+	action := functions[0].Function.Action
+	if action.Block == nil {
+		return nil, fmt.Errorf("not a PL/pgSQL block")
+	}
+	if stmts := action.Block.Body; len(stmts) > 0 && stmts[len(stmts)-1].kind == "return" {
+		action.Block.Body = stmts[:len(stmts)-1]
+	}
+	block, err := action.Block.block()
+	if err != nil {
+		return nil, err
+	}
+	if len(block.Stmts) == 1 && block.Stmts[0].Block != nil && len(block.Handlers) == 0 {
+		return block.Stmts[0].Block, nil
+	}
+	return block, nil
+}
+
+// PL/pgSQL statement of the JSON output of the PL/pgSQL parser, by kind.
+type plpgsqlStmt struct {
+	kind string // Kind of the statement, e.g. "block" for PLpgSQL_stmt_block.
+
+	Block   *plpgsqlBlock `json:"PLpgSQL_stmt_block"`
+	ExecSQL *struct {
+		SQL  plpgsqlExpr `json:"sqlstmt"`
+		Into bool        `json:"into"`
+	} `json:"PLpgSQL_stmt_execsql"`
+	Perform *struct {
+		Expr plpgsqlExpr `json:"expr"`
+	} `json:"PLpgSQL_stmt_perform"`
+}
+
+type plpgsqlBlock struct {
+	Body       []plpgsqlStmt `json:"body"`
+	Exceptions *struct {
+		Block struct {
+			List []struct {
+				Exception struct {
+					Conditions []struct {
+						Condition struct {
+							Name string `json:"condname"`
+						} `json:"PLpgSQL_condition"`
+					} `json:"conditions"`
+					Action []plpgsqlStmt `json:"action"`
+				} `json:"PLpgSQL_exception"`
+			} `json:"exc_list"`
+		} `json:"PLpgSQL_exception_block"`
+	} `json:"exceptions"`
+}
+
+type plpgsqlExpr struct {
+	Expr struct {
+		Query string `json:"query"`
+	} `json:"PLpgSQL_expr"`
+}
+
+func (s *plpgsqlStmt) UnmarshalJSON(data []byte) error {
+	var kinds map[string]json.RawMessage
+	if err := json.Unmarshal(data, &kinds); err != nil {
+		return err
+	}
+	for kind := range kinds {
+		s.kind = strings.TrimPrefix(kind, "PLpgSQL_stmt_")
+	}
+	type stmt plpgsqlStmt
+	return json.Unmarshal(data, (*stmt)(s))
+}
+
+// Returns the DO block of a PL/pgSQL block.
+func (b *plpgsqlBlock) block() (*DoBlock, error) {
+	stmts, err := doStmts(b.Body)
+	if err != nil {
+		return nil, err
+	}
+	block := &DoBlock{Stmts: stmts}
+	if b.Exceptions == nil {
+		return block, nil
+	}
+	for _, item := range b.Exceptions.Block.List {
+		var handler DoHandler
+		for _, condition := range item.Exception.Conditions {
+			code, err := conditionCode(condition.Condition.Name)
+			if err != nil {
+				return nil, err
+			}
+			handler.Conditions = append(handler.Conditions, code)
+		}
+		if handler.Stmts, err = doStmts(item.Exception.Action); err != nil {
+			return nil, err
+		}
+		block.Handlers = append(block.Handlers, handler)
+	}
+	return block, nil
+}
+
+// Returns the statements of a DO block of PL/pgSQL statements.
+func doStmts(plpgsqlStmts []plpgsqlStmt) ([]DoStmt, error) {
+	var stmts []DoStmt
+	for _, stmt := range plpgsqlStmts {
+		var query string
+		switch {
+		case stmt.Block != nil:
+			block, err := stmt.Block.block()
+			if err != nil {
+				return nil, err
+			}
+			stmts = append(stmts, DoStmt{Block: block})
+			continue
+		case stmt.ExecSQL != nil && !stmt.ExecSQL.Into:
+			query = stmt.ExecSQL.SQL.Expr.Query
+		case stmt.Perform != nil:
+			query = stmt.Perform.Expr.Expr.Query
+		default:
+			name, ok := plpgsqlStmtNames[stmt.kind]
+			if !ok {
+				name = strings.ToUpper(stmt.kind)
+			}
+			if stmt.ExecSQL != nil {
+				name = "SELECT INTO"
+			}
+			e := pgerror.Newf(pgerror.FeatureNotSupported, "%s is not supported in DO blocks", name)
+			e.Hint = doHint
+			return nil, e
+		}
+		tree, err := pg_query.Parse(query)
+		if err != nil {
+			return nil, parseError(err)
+		}
+		for _, raw := range tree.Stmts {
+			if raw.GetStmt().GetTransactionStmt() != nil {
+				return nil, pgerror.New(pgerror.FeatureNotSupported, "transaction control is not supported in DO blocks")
+			}
+		}
+		stmts = append(stmts, DoStmt{SQL: query})
+	}
+	return stmts, nil
+}
+
+// Returns the SQLSTATE code of a condition of an exception handler, a condition name or
+// a SQLSTATE code.
+func conditionCode(name string) (string, error) {
+	if name == "others" || len(name) == 5 && strings.ToUpper(name) == name {
+		return name, nil
+	}
+	if code, ok := conditionCodes[name]; ok {
+		return code, nil
+	}
+	return "", pgerror.Newf(pgerror.UndefinedObject, "unrecognized exception condition %q", name)
+}
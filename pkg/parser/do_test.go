@@ -0,0 +1,54 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DO blocks", func() {
+
+	It("Parse statements and exception handlers", func() {
+		block, err := parser.ParseDo(`DO $$
+			BEGIN
+				CREATE TABLE tags (id int PRIMARY KEY);
+				PERFORM pg_notify('migrations', 'tags');
+				BEGIN
+					CREATE INDEX tags_id ON tags (id);
+				EXCEPTION WHEN duplicate_table OR SQLSTATE '42710' THEN
+					NULL;
+				END;
+			EXCEPTION WHEN integrity_constraint_violation THEN
+				DELETE FROM tags;
+			END $$`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(block.Stmts).To(HaveLen(3))
+		Expect(block.Stmts[0].SQL).To(Equal("CREATE TABLE tags (id int PRIMARY KEY)"))
+		Expect(block.Stmts[1].SQL).To(Equal("SELECT pg_notify('migrations', 'tags')"))
+
+		nested := block.Stmts[2].Block
+		Expect(nested.Stmts).To(HaveLen(1))
+		Expect(nested.Handlers).To(HaveLen(1))
+		Expect(nested.Handlers[0].Conditions).To(Equal([]string{"42P07", "42710"}))
+		Expect(nested.Handlers[0].Stmts).To(BeEmpty())
+		Expect(nested.Handlers[0].Handles("42P07")).To(BeTrue())
+		Expect(nested.Handlers[0].Handles("42P01")).To(BeFalse())
+
+		Expect(block.Handlers[0].Handles("23505")).To(BeTrue())
+		Expect(block.Handlers[0].Stmts[0].SQL).To(Equal("DELETE FROM tags"))
+	})
+
+	It("Reject PL/pgSQL control flow and variables", func() {
+		_, err := parser.ParseDo(`DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM tags) THEN CREATE TABLE tags (id int); END IF; END $$`)
+		Expect(err).To(MatchError(ContainSubstring("IF is not supported in DO blocks")))
+		_, err = parser.ParseDo(`DO $$ DECLARE n int; BEGIN SELECT count(*) INTO n FROM tags; END $$`)
+		Expect(err).To(MatchError(ContainSubstring("variables are not supported")))
+		_, err = parser.ParseDo(`DO $$ BEGIN RAISE NOTICE 'done'; END $$`)
+		Expect(err).To(MatchError(ContainSubstring("RAISE is not supported")))
+		_, err = parser.ParseDo(`DO $$ BEGIN CREATE TABLE t (a int); EXCEPTION WHEN no_such_condition THEN NULL; END $$`)
+		Expect(err).To(MatchError(ContainSubstring("unrecognized exception condition")))
+		_, err = parser.ParseDo(`DO LANGUAGE plperl $$ 1 $$`)
+		Expect(err).To(MatchError(ContainSubstring("language plperl is not supported")))
+	})
+})
@@ -0,0 +1,17 @@
+// Package parser translates PostgreSQL statements for execution by SQLite.
+//
+// It's usable independently of the kqlite server:
+//
+//   - Analyze parses a query string and describes each statement in it,
+//     its command, referenced tables, columns and parameters, whether it
+//     modifies the database and its SQLite translation.
+//   - ClassifyQuery and Classify provide the statement classification only.
+//   - RewriteQuery translates a query string to SQLite syntax through a
+//     pipeline of rewriters, RegisterRewriter adds custom ones to it.
+//   - Walk visits the nodes of statement trees, Apply and ApplyQuery replace,
+//     delete and insert nodes and deparse the changed statements. Deparse
+//     and DeparseExpr turn statement and expression nodes back into SQL.
+//
+// Statements are parsed with the PostgreSQL parser, so only PostgreSQL
+// syntax is accepted.
+package parser
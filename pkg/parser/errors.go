@@ -0,0 +1,23 @@
+package parser
+
+import (
+	"errors"
+
+	pgparser "github.com/pganalyze/pg_query_go/v5/parser"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Returns the PostgreSQL error of a query that failed to parse, positioned at the
+// offending token so clients can point at it.
+func parseError(err error) error {
+	var perr *pgparser.Error
+	if !errors.As(err, &perr) {
+		return err
+	}
+
+	e := pgerror.New(pgerror.SyntaxError, perr.Message)
+	e.Detail = perr.Context
+	e.Position = int32(perr.Cursorpos)
+	return e
+}
@@ -0,0 +1,275 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// ForeignServer is a server defined by CREATE SERVER, its options are the
+// connection parameters of the foreign database.
+type ForeignServer struct {
+	Name        string
+	Wrapper     string
+	Options     map[string]string
+	IfNotExists bool
+}
+
+// UserMapping holds the credentials of the connections to a foreign server,
+// defined by CREATE USER MAPPING.
+type UserMapping struct {
+	Server      string
+	Options     map[string]string
+	IfNotExists bool
+}
+
+// ForeignTable is a table defined by CREATE FOREIGN TABLE, its rows are those
+// of the remote table of the foreign server.
+type ForeignTable struct {
+	Name        string
+	Server      string
+	Schema      string // Remote schema set with the schema_name option, empty for the default.
+	Table       string // Remote table, the name of the foreign table unless set with the table_name option.
+	IfNotExists bool
+}
+
+// ForeignQuery is a statement on foreign tables executed by their server.
+type ForeignQuery struct {
+	Server string
+
+	// Statement executed by the server, the foreign tables replaced by their remote tables.
+	// For INSERT ... SELECT between local and foreign tables, the query of the inserted
+	// rows only, executed by the server if its tables are foreign and locally otherwise.
+	SQL string
+
+	// Set for INSERT ... SELECT between local and foreign tables, the statement inserting
+	// the rows up to its values, e.g. INSERT INTO "t" ("a", "b").
+	Into string
+
+	// The INSERT of Into is executed by the server, the query locally.
+	RemoteInto bool
+}
+
+// Options of foreign servers and user mappings, the connection parameters of libpq.
+var (
+	serverOptions       = []string{"host", "port", "dbname", "sslmode", "connect_timeout", "application_name"}
+	userMappingOptions  = []string{"user", "password"}
+	foreignTableOptions = []string{"schema_name", "table_name"}
+)
+
+// CreateForeignServer returns the server defined by a CREATE SERVER statement.
+func CreateForeignServer(sql string) (*ForeignServer, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetCreateForeignServerStmt() == nil {
+		return nil, fmt.Errorf("not a CREATE SERVER statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetCreateForeignServerStmt()
+	options, err := defOptions(stmt.GetOptions(), serverOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &ForeignServer{
+		Name:        stmt.GetServername(),
+		Wrapper:     stmt.GetFdwname(),
+		Options:     options,
+		IfNotExists: stmt.GetIfNotExists(),
+	}, nil
+}
+
+// CreateUserMapping returns the user mapping defined by a CREATE USER MAPPING statement.
+// Mappings apply to all users.
+func CreateUserMapping(sql string) (*UserMapping, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetCreateUserMappingStmt() == nil {
+		return nil, fmt.Errorf("not a CREATE USER MAPPING statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetCreateUserMappingStmt()
+	options, err := defOptions(stmt.GetOptions(), userMappingOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &UserMapping{Server: stmt.GetServername(), Options: options, IfNotExists: stmt.GetIfNotExists()}, nil
+}
+
+// CreateForeignTable returns the table defined by a CREATE FOREIGN TABLE statement.
+// Columns are those of the remote table, the declared columns are ignored.
+func CreateForeignTable(sql string) (*ForeignTable, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetCreateForeignTableStmt() == nil {
+		return nil, fmt.Errorf("not a CREATE FOREIGN TABLE statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetCreateForeignTableStmt()
+	options, err := defOptions(stmt.GetOptions(), foreignTableOptions)
+	if err != nil {
+		return nil, err
+	}
+	table := &ForeignTable{
+		Name:        stmt.GetBaseStmt().GetRelation().GetRelname(),
+		Server:      stmt.GetServername(),
+		Schema:      options["schema_name"],
+		Table:       options["table_name"],
+		IfNotExists: stmt.GetBaseStmt().GetIfNotExists(),
+	}
+	if table.Table == "" {
+		table.Table = table.Name
+	}
+	return table, nil
+}
+
+// Returns the options of a definition, only the given options are valid.
+func defOptions(defs []*pg_query.Node, valid []string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, def := range defs {
+		name := def.GetDefElem().GetDefname()
+		if !slices.Contains(valid, name) {
+			e := pgerror.Newf(pgerror.FDWInvalidOptionName, "invalid option %q", name)
+			e.Hint = "Valid options in this context are: " + strings.Join(valid, ", ")
+			return nil, e
+		}
+		options[name] = def.GetDefElem().GetArg().GetString_().GetSval()
+	}
+	return options, nil
+}
+
+// Collects the relations of a statement tree.
+type rangeVarWalker struct {
+	rangevars []*pg_query.RangeVar
+}
+
+func (walker *rangeVarWalker) Visit(node *pg_query.Node) (v Visitor, err error) {
+	if rangevar := node.GetRangeVar(); rangevar != nil {
+		walker.rangevars = append(walker.rangevars, rangevar)
+	}
+	return walker, nil
+}
+
+func (walker *rangeVarWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// Returns the relations of a statement tree, CTE names included.
+func rangeVars(node *pg_query.Node) []*pg_query.RangeVar {
+	walker := &rangeVarWalker{}
+	Walk(walker, node)
+	return walker.rangevars
+}
+
+// Replaces foreign tables by their remote table, the foreign table name
+// remains the alias of the remote table so column references still resolve.
+func remoteTables(rangevars []*pg_query.RangeVar, tables map[string]*ForeignTable) {
+	for _, rangevar := range rangevars {
+		table := tables[rangevar.GetRelname()]
+		if rangevar.GetAlias() == nil && table.Table != table.Name {
+			rangevar.Alias = &pg_query.Alias{Aliasname: table.Name}
+		}
+		rangevar.Schemaname, rangevar.Relname = table.Schema, table.Table
+	}
+}
+
+// RemoteQuery returns the statement executing a query on foreign tables, given the foreign
+// tables of the database by name, nil if the query doesn't refer to foreign tables.
+// SELECT and INSERT statements whose tables are all foreign tables of the same server
+// are executed by the server. INSERT ... SELECT statements copy rows from foreign
+// tables into a local table, or from local tables into a foreign table.
+func RemoteQuery(sql string, tables map[string]*ForeignTable) (*ForeignQuery, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 {
+		return nil, fmt.Errorf("not a single statement")
+	}
+	stmt := tree.Stmts[0].GetStmt()
+
+	// The relations modified by statements aren't nodes of the tree.
+	var target *pg_query.RangeVar
+	switch n := stmt.GetNode().(type) {
+	case *pg_query.Node_InsertStmt:
+		target = n.InsertStmt.GetRelation()
+	case *pg_query.Node_UpdateStmt:
+		target = n.UpdateStmt.GetRelation()
+	case *pg_query.Node_DeleteStmt:
+		target = n.DeleteStmt.GetRelation()
+	case *pg_query.Node_MergeStmt:
+		target = n.MergeStmt.GetRelation()
+	}
+	all := rangeVars(stmt)
+	if target != nil {
+		all = append(all, target)
+	}
+
+	var foreign, local []*pg_query.RangeVar
+	var server string
+	for _, rangevar := range all {
+		table, ok := tables[rangevar.GetRelname()]
+		if !ok || (rangevar.GetSchemaname() != "" && rangevar.GetSchemaname() != "public") {
+			local = append(local, rangevar)
+			continue
+		}
+		if server != "" && table.Server != server {
+			return nil, pgerror.New(pgerror.FeatureNotSupported, "queries on foreign tables of different servers are not supported")
+		}
+		server = table.Server
+		foreign = append(foreign, rangevar)
+	}
+	if len(foreign) == 0 {
+		return nil, nil
+	}
+
+	insert := stmt.GetInsertStmt()
+	if len(local) == 0 {
+		if stmt.GetSelectStmt() == nil && insert == nil {
+			return nil, pgerror.New(pgerror.FeatureNotSupported, "statements other than SELECT and INSERT on foreign tables are not supported")
+		}
+		remoteTables(foreign, tables)
+		text, err := Deparse(stmt)
+		if err != nil {
+			return nil, err
+		}
+		return &ForeignQuery{Server: server, SQL: text}, nil
+	}
+
+	// Rows are copied between local and foreign tables when the target is the only
+	// foreign table or the only local table.
+	mixed := pgerror.New(pgerror.FeatureNotSupported, "queries mixing foreign and local tables are not supported")
+	mixed.Hint = "Copy rows with INSERT ... SELECT between a foreign table and local tables."
+	sel := insert.GetSelectStmt().GetSelectStmt()
+	if insert == nil || sel == nil || len(sel.GetValuesLists()) != 0 || insert.GetWithClause() != nil ||
+		insert.GetOnConflictClause() != nil || len(insert.GetReturningList()) != 0 {
+		return nil, mixed
+	}
+	remoteInto := len(foreign) == 1 && foreign[0] == target
+	if !remoteInto && !(len(local) == 1 && local[0] == target) {
+		return nil, mixed
+	}
+
+	remoteTables(foreign, tables)
+	query, err := Deparse(insert.GetSelectStmt())
+	if err != nil {
+		return nil, err
+	}
+	into := "INSERT INTO "
+	if remoteInto && target.GetSchemaname() != "" {
+		into += quoteIdentifier(target.GetSchemaname()) + "."
+	}
+	into += quoteIdentifier(target.GetRelname())
+	var columns []string
+	for _, col := range insert.GetCols() {
+		columns = append(columns, quoteIdentifier(col.GetResTarget().GetName()))
+	}
+	if len(columns) > 0 {
+		into += " (" + strings.Join(columns, ", ") + ")"
+	}
+	return &ForeignQuery{Server: server, SQL: query, Into: into, RemoteInto: remoteInto}, nil
+}
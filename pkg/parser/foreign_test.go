@@ -0,0 +1,84 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Foreign tables", func() {
+
+	tables := map[string]*parser.ForeignTable{
+		"remote_items": {Name: "remote_items", Server: "node2", Table: "items"},
+		"remote_tags":  {Name: "remote_tags", Server: "node2", Schema: "app", Table: "remote_tags"},
+		"archive":      {Name: "archive", Server: "node3", Table: "archive"},
+	}
+
+	It("Define foreign servers and tables", func() {
+		server, err := parser.CreateForeignServer(`CREATE SERVER IF NOT EXISTS node2 FOREIGN DATA WRAPPER postgres_fdw
+			OPTIONS (host 'node2', port '5432', dbname 'app.db')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server).To(Equal(&parser.ForeignServer{Name: "node2", Wrapper: "postgres_fdw", IfNotExists: true,
+			Options: map[string]string{"host": "node2", "port": "5432", "dbname": "app.db"}}))
+
+		mapping, err := parser.CreateUserMapping(`CREATE USER MAPPING FOR public SERVER node2 OPTIONS (user 'app', password 'secret')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mapping.Server).To(Equal("node2"))
+		Expect(mapping.Options).To(HaveKeyWithValue("password", "secret"))
+
+		table, err := parser.CreateForeignTable(`CREATE FOREIGN TABLE remote_items (id integer, name text) SERVER node2`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(table).To(Equal(&parser.ForeignTable{Name: "remote_items", Server: "node2", Table: "remote_items"}))
+
+		_, err = parser.CreateForeignServer(`CREATE SERVER node2 FOREIGN DATA WRAPPER postgres_fdw OPTIONS (user 'app')`)
+		Expect(err).To(MatchError(ContainSubstring(`invalid option "user"`)))
+
+		names, missingOK, cascade, err := parser.DropObjects(`DROP SERVER IF EXISTS node2, node3 CASCADE`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"node2", "node3"}))
+		Expect(missingOK).To(BeTrue())
+		Expect(cascade).To(BeTrue())
+	})
+
+	It("Push queries on foreign tables down to their server", func() {
+		q, err := parser.RemoteQuery(`SELECT remote_items.name, t.tag FROM remote_items JOIN remote_tags t ON t.item = remote_items.id WHERE id = $1`, tables)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q).To(Equal(&parser.ForeignQuery{Server: "node2",
+			SQL: `SELECT remote_items.name, t.tag FROM items remote_items JOIN app.remote_tags t ON t.item = remote_items.id WHERE id = $1`}))
+
+		q, err = parser.RemoteQuery(`INSERT INTO remote_items (name) VALUES ('a')`, tables)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q.SQL).To(Equal(`INSERT INTO items AS remote_items (name) VALUES ('a')`))
+
+		q, err = parser.RemoteQuery(`SELECT * FROM items`, tables)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q).To(BeNil())
+	})
+
+	It("Copy rows between local and foreign tables", func() {
+		q, err := parser.RemoteQuery(`INSERT INTO items (id, name) SELECT id, name FROM remote_items WHERE id > 10`, tables)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q).To(Equal(&parser.ForeignQuery{Server: "node2", Into: `INSERT INTO "items" ("id", "name")`,
+			SQL: `SELECT id, name FROM items remote_items WHERE id > 10`}))
+
+		q, err = parser.RemoteQuery(`INSERT INTO archive SELECT * FROM items WHERE deleted`, tables)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q).To(Equal(&parser.ForeignQuery{Server: "node3", Into: `INSERT INTO "archive"`,
+			SQL: `SELECT * FROM items WHERE deleted`, RemoteInto: true}))
+	})
+
+	It("Reject other statements on foreign tables", func() {
+		for _, sql := range []string{
+			`UPDATE remote_items SET name = 'a'`,
+			`DELETE FROM remote_items`,
+			`SELECT * FROM remote_items JOIN items USING (id)`,
+			`SELECT * FROM remote_items JOIN archive USING (id)`,
+			`INSERT INTO items SELECT * FROM remote_items JOIN tags USING (id)`,
+			`INSERT INTO items SELECT * FROM remote_items RETURNING id`,
+		} {
+			_, err := parser.RemoteQuery(sql, tables)
+			Expect(err).To(MatchError(ContainSubstring("not supported")), sql)
+		}
+	})
+})
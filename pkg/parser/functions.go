@@ -0,0 +1,487 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Kinds of the functions created by CREATE FUNCTION and CREATE PROCEDURE.
+const (
+	FunctionKind  = "function"
+	ProcedureKind = "procedure"
+	TriggerKind   = "trigger"
+)
+
+// Maximum depth of functions calling functions, beyond it functions are assumed recursive.
+const maxInlineDepth = 16
+
+// Hint of the functions that can't be translated.
+const functionHint = "Write the function in LANGUAGE sql."
+
+// Function is a function or procedure in LANGUAGE sql. Calls of functions are inlined into
+// the statements calling them, procedures execute their statements on CALL, and trigger
+// functions are executed by the triggers of tables, see CreateTrigger.
+type Function struct {
+	Name       string
+	Kind       string
+	Params     []FunctionParam
+	Body       string // Statements of the function, a single query for functions.
+	ReturnsSet bool   // RETURNS SETOF or TABLE.
+	Replace    bool   // CREATE OR REPLACE.
+}
+
+// FunctionParam is an input parameter of a function.
+type FunctionParam struct {
+	Name    string `json:"name,omitempty"`    // Empty for parameters only referred to as $n.
+	Default string `json:"default,omitempty"` // Default expression, empty if the argument is required.
+}
+
+// CreateFunction returns the function or procedure created by a CREATE FUNCTION or CREATE
+// PROCEDURE statement. Functions hold a single SELECT statement, procedures and trigger
+// functions INSERT, UPDATE, DELETE and SELECT statements. Functions in other languages
+// than SQL, and OUT, INOUT and VARIADIC parameters of procedures, are rejected.
+func CreateFunction(sql string) (*Function, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetCreateFunctionStmt() == nil {
+		return nil, fmt.Errorf("not a CREATE FUNCTION statement")
+	}
+	create := tree.Stmts[0].GetStmt().GetCreateFunctionStmt()
+
+	fn := &Function{
+		Name:       qualifiedName(create.GetFuncname()),
+		Kind:       FunctionKind,
+		ReturnsSet: create.GetReturnType().GetSetof(),
+		Replace:    create.GetReplace(),
+	}
+	switch names := create.GetReturnType().GetNames(); {
+	case create.GetIsProcedure():
+		fn.Kind = ProcedureKind
+	case len(names) > 0 && names[len(names)-1].GetString_().GetSval() == "trigger":
+		fn.Kind = TriggerKind
+	}
+
+	language := "sql"
+	for _, option := range create.GetOptions() {
+		switch def := option.GetDefElem(); def.GetDefname() {
+		case "language":
+			language = strings.ToLower(def.GetArg().GetString_().GetSval())
+		case "as":
+			if items := def.GetArg().GetList().GetItems(); len(items) > 0 {
+				fn.Body = items[0].GetString_().GetSval()
+			}
+		}
+	}
+	if language != "sql" {
+		if language == "plpgsql" {
+			language = "PL/pgSQL"
+		}
+		e := pgerror.Newf(pgerror.FeatureNotSupported, "%s %q: language %s is not supported", fn.Kind, fn.Name, language)
+		e.Hint = functionHint
+		if fn.Kind == TriggerKind {
+			e.Hint = triggerFunctionHint
+		}
+		return nil, e
+	}
+	if body := create.GetSqlBody(); body != nil {
+		if fn.Body, err = sqlBody(body); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, param := range create.GetParameters() {
+		p := param.GetFunctionParameter()
+		switch p.GetMode() {
+		case pg_query.FunctionParameterMode_FUNC_PARAM_IN, pg_query.FunctionParameterMode_FUNC_PARAM_DEFAULT:
+		case pg_query.FunctionParameterMode_FUNC_PARAM_OUT, pg_query.FunctionParameterMode_FUNC_PARAM_TABLE:
+			// Output columns of functions are those of their query.
+			if fn.Kind == FunctionKind {
+				continue
+			}
+			return nil, pgerror.Newf(pgerror.FeatureNotSupported, "OUT parameters of procedures are not supported")
+		default:
+			return nil, pgerror.Newf(pgerror.FeatureNotSupported, "INOUT and VARIADIC parameters are not supported")
+		}
+		var def string
+		if p.GetDefexpr() != nil {
+			if def, err = DeparseExpr(p.GetDefexpr()); err != nil {
+				return nil, err
+			}
+		}
+		fn.Params = append(fn.Params, FunctionParam{Name: p.GetName(), Default: def})
+	}
+	if fn.Kind == TriggerKind && len(fn.Params) > 0 {
+		return nil, pgerror.New(pgerror.InvalidFunctionDefinition, "trigger functions cannot have declared arguments")
+	}
+
+	if _, err := fn.statements(); err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+// Returns the statements of a SQL-standard function body: RETURN expression, or the
+// statements of BEGIN ATOMIC ... END.
+func sqlBody(body *pg_query.Node) (string, error) {
+	if ret := body.GetReturnStmt(); ret != nil {
+		expr, err := DeparseExpr(ret.GetReturnval())
+		return "SELECT " + expr, err
+	}
+	var stmts []*pg_query.Node
+	for _, list := range body.GetList().GetItems() {
+		stmts = append(stmts, list.GetList().GetItems()...)
+	}
+	return Deparse(stmts...)
+}
+
+// Returns the parsed statements of the body of a function, checked for its kind.
+func (fn *Function) statements() ([]*pg_query.Node, error) {
+	if fn.Kind == TriggerKind {
+		if _, err := triggerStatements(fn.Body); err != nil {
+			return nil, err
+		}
+	}
+	tree, err := pg_query.Parse(fn.Body)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	var stmts []*pg_query.Node
+	for _, raw := range tree.Stmts {
+		stmts = append(stmts, raw.GetStmt())
+	}
+	switch {
+	case len(stmts) == 0:
+		return nil, pgerror.Newf(pgerror.InvalidFunctionDefinition, "%s %q has no statements", fn.Kind, fn.Name)
+	case fn.Kind == FunctionKind && (len(stmts) > 1 || stmts[0].GetSelectStmt() == nil):
+		e := pgerror.Newf(pgerror.FeatureNotSupported, "function %q: only functions of a single SELECT statement are supported", fn.Name)
+		e.Hint = "Use a procedure to execute other statements."
+		return nil, e
+	case fn.Kind == ProcedureKind:
+		for _, stmt := range stmts {
+			switch stmt.GetNode().(type) {
+			case *pg_query.Node_InsertStmt, *pg_query.Node_UpdateStmt, *pg_query.Node_DeleteStmt, *pg_query.Node_SelectStmt:
+			default:
+				return nil, pgerror.Newf(pgerror.FeatureNotSupported, "procedure %q: statements other than INSERT, UPDATE, DELETE and SELECT are not supported", fn.Name)
+			}
+		}
+	}
+	return stmts, nil
+}
+
+// Returns the statements of the body of a function called with args, its parameters
+// replaced by the arguments and the functions it calls inlined.
+func (fn *Function) expand(args []*pg_query.Node, functions map[string]*Function, depth int) ([]*pg_query.Node, error) {
+	if depth >= maxInlineDepth {
+		return nil, pgerror.Newf(pgerror.ProgramLimitExceeded, "function %q: calls nested too deeply", fn.Name)
+	}
+	bound, err := fn.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	stmts, err := fn.statements()
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range stmts {
+		if err := Walk(&paramWalker{fn: fn, args: bound}, stmt); err != nil {
+			return nil, err
+		}
+		if _, err := inline(stmt, functions, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return stmts, nil
+}
+
+// Returns the arguments of the parameters of a function for the arguments of a call, by
+// position or name, missing arguments are the default ones.
+func (fn *Function) bind(args []*pg_query.Node) ([]*pg_query.Node, error) {
+	bound := make([]*pg_query.Node, len(fn.Params))
+	for i, arg := range args {
+		if named := arg.GetNamedArgExpr(); named != nil {
+			found := false
+			for j, param := range fn.Params {
+				if param.Name == named.GetName() {
+					bound[j], found = named.GetArg(), true
+				}
+			}
+			if !found {
+				return nil, pgerror.Newf(pgerror.UndefinedFunction, "%s %s has no parameter %q", fn.Kind, fn.Name, named.GetName())
+			}
+			continue
+		}
+		if i >= len(bound) {
+			return nil, pgerror.Newf(pgerror.UndefinedFunction, "%s %s does not take %d arguments", fn.Kind, fn.Name, len(args))
+		}
+		bound[i] = arg
+	}
+	for i, param := range fn.Params {
+		if bound[i] != nil {
+			continue
+		}
+		if param.Default == "" {
+			return nil, pgerror.Newf(pgerror.UndefinedFunction, "%s %s does not take %d arguments", fn.Kind, fn.Name, len(args))
+		}
+		tree, err := pg_query.Parse("SELECT " + param.Default)
+		if err != nil {
+			return nil, parseError(err)
+		}
+		bound[i] = tree.Stmts[0].GetStmt().GetSelectStmt().GetTargetList()[0].GetResTarget().GetVal()
+	}
+	return bound, nil
+}
+
+// Replaces the parameters of a function by their arguments, parameters are referred to
+// by name, qualified or not by the function name, or as $n. Parameters take precedence
+// over columns of the same name.
+type paramWalker struct {
+	fn   *Function
+	args []*pg_query.Node
+}
+
+func (walker *paramWalker) Visit(node *pg_query.Node) (Visitor, error) {
+	i := -1
+	switch n := node.Node.(type) {
+	case *pg_query.Node_ParamRef:
+		i = int(n.ParamRef.GetNumber()) - 1
+	case *pg_query.Node_ColumnRef:
+		fields := n.ColumnRef.GetFields()
+		if len(fields) == 2 && fields[0].GetString_().GetSval() != walker.fn.Name {
+			break
+		} else if len(fields) > 2 {
+			break
+		}
+		name := fields[len(fields)-1].GetString_().GetSval()
+		for j, param := range walker.fn.Params {
+			if param.Name != "" && param.Name == name {
+				i = j
+			}
+		}
+	}
+	if i < 0 {
+		return walker, nil
+	} else if i >= len(walker.args) {
+		return nil, pgerror.Newf(pgerror.UndefinedParameter, "there is no parameter $%d", i+1)
+	}
+	node.Node = proto.Clone(walker.args[i]).(*pg_query.Node).Node
+	return nil, nil
+}
+
+func (walker *paramWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// InlineFunctions replaces the calls of SQL functions of a statement by their query, with
+// the arguments of the call as parameters. Functions of a single expression are replaced
+// by the expression, others by a subquery, or a derived table in FROM clauses. Statements
+// without calls are returned as is.
+func InlineFunctions(sql string, functions map[string]*Function) (string, error) {
+	if !mayCall(sql, functions) {
+		return sql, nil
+	}
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", parseError(err)
+	}
+	inlined := false
+	for _, raw := range tree.Stmts {
+		ok, err := inline(raw.GetStmt(), functions, 0)
+		if err != nil {
+			return "", err
+		}
+		inlined = inlined || ok
+	}
+	if !inlined {
+		return sql, nil
+	}
+	return pg_query.Deparse(tree)
+}
+
+// Reports whether a statement may call one of the functions.
+func mayCall(sql string, functions map[string]*Function) bool {
+	lower := strings.ToLower(sql)
+	for name, fn := range functions {
+		if fn.Kind == FunctionKind && strings.Contains(lower, strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Inlines the calls of functions of a node, reports whether there were any.
+func inline(node *pg_query.Node, functions map[string]*Function, depth int) (bool, error) {
+	walker := &inlineWalker{functions: functions, depth: depth}
+	err := Walk(walker, node)
+	return walker.inlined, err
+}
+
+type inlineWalker struct {
+	functions map[string]*Function
+	depth     int
+	inlined   bool
+}
+
+// Returns the function of a call, nil if it isn't a call of a SQL function.
+func (walker *inlineWalker) function(call *pg_query.FuncCall) *Function {
+	names := call.GetFuncname()
+	if call == nil || len(names) > 2 || len(names) == 2 && names[0].GetString_().GetSval() != "public" ||
+		call.GetOver() != nil || call.GetAggStar() || call.GetAggDistinct() {
+		return nil
+	}
+	fn := walker.functions[funcName(call)]
+	if fn == nil || fn.Kind != FunctionKind {
+		return nil
+	}
+	return fn
+}
+
+func (walker *inlineWalker) Visit(node *pg_query.Node) (Visitor, error) {
+	switch n := node.Node.(type) {
+	case *pg_query.Node_FuncCall:
+		fn := walker.function(n.FuncCall)
+		if fn == nil {
+			return walker, nil
+		}
+		stmts, err := fn.expand(n.FuncCall.GetArgs(), walker.functions, walker.depth)
+		if err != nil {
+			return nil, err
+		}
+		if expr := singleExpr(stmts[0].GetSelectStmt()); expr != nil && !fn.ReturnsSet {
+			node.Node = expr.Node
+		} else {
+			node.Node = &pg_query.Node_SubLink{SubLink: &pg_query.SubLink{
+				SubLinkType: pg_query.SubLinkType_EXPR_SUBLINK,
+				Subselect:   stmts[0],
+			}}
+		}
+		walker.inlined = true
+		return nil, nil
+	case *pg_query.Node_RangeFunction:
+		rf := n.RangeFunction
+		if len(rf.GetFunctions()) != 1 || rf.GetOrdinality() || rf.GetIsRowsfrom() {
+			return walker, nil
+		}
+		items := rf.GetFunctions()[0].GetList().GetItems()
+		if len(items) == 0 {
+			return walker, nil
+		}
+		call := items[0].GetFuncCall()
+		fn := walker.function(call)
+		if fn == nil {
+			return walker, nil
+		}
+		stmts, err := fn.expand(call.GetArgs(), walker.functions, walker.depth)
+		if err != nil {
+			return nil, err
+		}
+		// The column of functions of a single expression is named after the function.
+		if expr := singleExpr(stmts[0].GetSelectStmt()); expr != nil {
+			stmts[0].GetSelectStmt().GetTargetList()[0].GetResTarget().Name = fn.Name
+		}
+		alias := rf.GetAlias()
+		if alias == nil {
+			alias = &pg_query.Alias{Aliasname: fn.Name}
+		}
+		node.Node = &pg_query.Node_RangeSubselect{RangeSubselect: &pg_query.RangeSubselect{
+			Lateral:  rf.GetLateral(),
+			Subquery: stmts[0],
+			Alias:    alias,
+		}}
+		walker.inlined = true
+		return nil, nil
+	}
+	return walker, nil
+}
+
+func (walker *inlineWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// Returns the expression of a query of a single expression without FROM clause,
+// nil for other queries.
+func singleExpr(query *pg_query.SelectStmt) *pg_query.Node {
+	if query == nil || len(query.GetTargetList()) != 1 || len(query.GetFromClause()) > 0 ||
+		query.GetWhereClause() != nil || len(query.GetGroupClause()) > 0 || query.GetHavingClause() != nil ||
+		len(query.GetDistinctClause()) > 0 || len(query.GetSortClause()) > 0 || query.GetLimitCount() != nil ||
+		query.GetLimitOffset() != nil || len(query.GetValuesLists()) > 0 || query.GetWithClause() != nil ||
+		query.GetOp() != pg_query.SetOperation_SETOP_NONE {
+		return nil
+	}
+	target := query.GetTargetList()[0].GetResTarget()
+	if fields := target.GetVal().GetColumnRef().GetFields(); len(fields) > 0 && fields[len(fields)-1].GetAStar() != nil {
+		return nil
+	}
+	return target.GetVal()
+}
+
+// ProcedureStmt is a statement executed by a CALL statement.
+type ProcedureStmt struct {
+	SQL    string
+	Params []int // Numbers of the parameters of the CALL statement it binds, in order.
+}
+
+// ProcedureStatements returns the statements a CALL statement executes, the statements of
+// the procedure with its parameters replaced by the arguments of the call and the
+// functions they call inlined.
+func ProcedureStatements(sql string, functions map[string]*Function) ([]ProcedureStmt, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetCallStmt() == nil {
+		return nil, fmt.Errorf("not a CALL statement")
+	}
+	call := tree.Stmts[0].GetStmt().GetCallStmt().GetFunccall()
+	name := qualifiedName(call.GetFuncname())
+	fn := functions[name]
+	if fn == nil || fn.Kind != ProcedureKind {
+		return nil, pgerror.Newf(pgerror.UndefinedFunction, "procedure %s does not exist", name)
+	}
+	// Functions of the arguments are inlined as well.
+	for _, arg := range call.GetArgs() {
+		if _, err := inline(arg, functions, 0); err != nil {
+			return nil, err
+		}
+	}
+	stmts, err := fn.expand(call.GetArgs(), functions, 0)
+	if err != nil {
+		return nil, err
+	}
+	procStmts := make([]ProcedureStmt, len(stmts))
+	for i, stmt := range stmts {
+		text, err := Deparse(stmt)
+		if err != nil {
+			return nil, err
+		}
+		procStmts[i].SQL = text
+		if procStmts[i].Params, err = statementParams(text); err != nil {
+			return nil, err
+		}
+	}
+	return procStmts, nil
+}
+
+// Returns the numbers of the parameters of a statement in order of first appearance, the
+// order SQLite numbers them in.
+func statementParams(sql string) ([]int, error) {
+	scan, err := pg_query.Scan(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	var params []int
+	for _, token := range scan.GetTokens() {
+		if token.GetToken() != pg_query.Token_PARAM {
+			continue
+		}
+		n, err := strconv.Atoi(sql[token.GetStart()+1 : token.GetEnd()])
+		if err == nil && !slices.Contains(params, n) {
+			params = append(params, n)
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,78 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Functions", func() {
+	functions := map[string]*parser.Function{}
+	create := func(sql string) {
+		fn, err := parser.CreateFunction(sql)
+		Expect(err).NotTo(HaveOccurred())
+		functions[fn.Name] = fn
+	}
+
+	BeforeEach(func() {
+		create(`CREATE FUNCTION add(a int, b int DEFAULT 1) RETURNS int LANGUAGE sql AS 'SELECT a + b'`)
+		create(`CREATE FUNCTION twice(x int) RETURNS int RETURN add(x, x)`)
+		create(`CREATE FUNCTION public.active_users(since date) RETURNS SETOF users LANGUAGE sql AS $$
+			SELECT * FROM users WHERE last_seen >= $1 $$`)
+		create(`CREATE PROCEDURE archive(before date) LANGUAGE sql AS $$
+			INSERT INTO archive SELECT * FROM users WHERE last_seen < before;
+			DELETE FROM users WHERE last_seen < archive.before $$`)
+	})
+
+	It("Parse SQL functions and procedures", func() {
+		Expect(functions["add"].Kind).To(Equal(parser.FunctionKind))
+		Expect(functions["add"].Params).To(Equal([]parser.FunctionParam{{Name: "a"}, {Name: "b", Default: "1"}}))
+		Expect(functions["twice"].Body).To(Equal("SELECT add(x, x)"))
+		Expect(functions["active_users"].ReturnsSet).To(BeTrue())
+		Expect(functions["archive"].Kind).To(Equal(parser.ProcedureKind))
+	})
+
+	It("Inline calls of functions", func() {
+		text, err := parser.InlineFunctions(`SELECT add(price, 2), twice(qty), add(b => 3, a => qty) FROM items`, functions)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(text).To(Equal(`SELECT price + 2, qty + qty, qty + 3 FROM items`))
+
+		text, err = parser.InlineFunctions(`SELECT add(1) AS n`, functions)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(text).To(Equal(`SELECT 1 + 1 AS n`))
+
+		text, err = parser.InlineFunctions(`SELECT name FROM active_users('2024-01-01') u`, functions)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(text).To(Equal(`SELECT name FROM (SELECT * FROM users WHERE last_seen >= '2024-01-01') u`))
+
+		// Statements without calls are left as they are.
+		text, err = parser.InlineFunctions(`select  sum(price) from items`, functions)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(text).To(Equal(`select  sum(price) from items`))
+
+		_, err = parser.InlineFunctions(`SELECT add()`, functions)
+		Expect(err).To(MatchError(ContainSubstring("does not take 0 arguments")))
+	})
+
+	It("Expand procedures called by CALL", func() {
+		stmts, err := parser.ProcedureStatements(`CALL archive($1)`, functions)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stmts).To(Equal([]parser.ProcedureStmt{
+			{SQL: `INSERT INTO archive SELECT * FROM users WHERE last_seen < $1`, Params: []int{1}},
+			{SQL: `DELETE FROM users WHERE last_seen < $1`, Params: []int{1}},
+		}))
+
+		_, err = parser.ProcedureStatements(`CALL add(1, 2)`, functions)
+		Expect(err).To(MatchError(ContainSubstring("procedure add does not exist")))
+	})
+
+	It("Reject functions that can't be inlined", func() {
+		_, err := parser.CreateFunction(`CREATE FUNCTION bump() RETURNS void LANGUAGE plpgsql AS $$ BEGIN PERFORM 1; END $$`)
+		Expect(err).To(MatchError(ContainSubstring("PL/pgSQL is not supported")))
+		_, err = parser.CreateFunction(`CREATE FUNCTION purge() RETURNS void LANGUAGE sql AS 'DELETE FROM log'`)
+		Expect(err).To(MatchError(ContainSubstring("single SELECT statement")))
+		_, err = parser.CreateFunction(`CREATE PROCEDURE swap(INOUT a int) LANGUAGE sql AS 'SELECT a'`)
+		Expect(err).To(MatchError(ContainSubstring("not supported")))
+	})
+})
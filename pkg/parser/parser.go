@@ -113,9 +113,32 @@ func Parse(sql string) ([]ParserStmtResult, error) {
 
 	tree, err := pg_query.Parse(sql)
 	if err != nil {
-		return result, err
+		return result, parseError(err)
 	}
+	return parseTree(tree)
+}
+
+// ParseQuery classifies the statements of a SQL query string and extracts their params
+// and tables, as ClassifyQuery and Parse do, from a single parse of the query.
+func ParseQuery(sql string) ([]StmtClass, []ParserStmtResult, error) {
+	if sql == "" {
+		return nil, nil, nil
+	}
+
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, nil, parseError(err)
+	}
+	result, err := parseTree(tree)
+	if err != nil {
+		return nil, nil, err
+	}
+	return classifyTree(sql, tree), result, nil
+}
 
+// Extracts the params and tables of each statement of a parse tree.
+func parseTree(tree *pg_query.ParseResult) ([]ParserStmtResult, error) {
+	var result []ParserStmtResult
 	for _, raw := range tree.Stmts {
 		if st := raw.GetStmt(); st != nil {
 			walker := &parserStmtWalker{}
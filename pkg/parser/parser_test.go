@@ -1,6 +1,8 @@
 package parser_test
 
 import (
+	"testing"
+
 	"github.com/kqlite/kqlite/pkg/parser"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -146,4 +148,49 @@ var _ = Describe("Parser tests", Ordered, func() {
 		Expect(result[0].Tables[1]).To(Equal("addresslist"))
 		Expect(result[0].Args[0]).To(Equal("personid"))
 	})
+
+	It("Classify and parse queries in a single pass", func() {
+		sql := `SELECT kv.id, kv.value FROM kine AS kv WHERE kv.name = $1 AND kv.id > $2; DELETE FROM kine WHERE id = $3`
+		stmts, result, err := parser.ParseQuery(sql)
+		Expect(err).NotTo(HaveOccurred())
+
+		classes, err := parser.ClassifyQuery(sql)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stmts).To(Equal(classes))
+		parsed, err := parser.Parse(sql)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(parsed))
+		Expect(result[0].Args).To(Equal([]string{"name", "id"}))
+
+		_, _, err = parser.ParseQuery(`SELEC 1`)
+		Expect(err).To(MatchError(ContainSubstring("syntax error")))
+	})
 })
+
+// Statement prepared by kine on every watch.
+const benchQuery = `SELECT kv.id, kv.name, kv.created, kv.deleted, kv.create_revision, kv.prev_revision, kv.lease, kv.value, kv.old_value
+	FROM kine AS kv
+	JOIN (SELECT MAX(mkv.id) AS id FROM kine AS mkv WHERE mkv.name LIKE $1 AND mkv.id <= $2 GROUP BY mkv.name) AS maxkv ON maxkv.id = kv.id
+	WHERE kv.deleted = 0 OR $3
+	ORDER BY kv.id ASC LIMIT $4`
+
+func BenchmarkClassifyQueryAndParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parser.ClassifyQuery(benchQuery); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := parser.Parse(benchQuery); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseQuery(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := parser.ParseQuery(benchQuery); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Prepare is a PREPARE statement, creating a named prepared statement.
+type Prepare struct {
+	Name       string
+	Query      string   // Statement prepared, its parameters are $n placeholders.
+	ParamTypes []string // Names of the declared parameter types, e.g. "int4", "_text" for arrays.
+}
+
+// ParsePrepare returns the PREPARE statement of sql.
+func ParsePrepare(sql string) (*Prepare, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetPrepareStmt() == nil {
+		return nil, fmt.Errorf("not a PREPARE statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetPrepareStmt()
+
+	query, err := Deparse(stmt.GetQuery())
+	if err != nil {
+		return nil, err
+	}
+	prepare := &Prepare{Name: stmt.GetName(), Query: query}
+	for _, argtype := range stmt.GetArgtypes() {
+		typ := argtype.GetTypeName()
+		names := typ.GetNames()
+		var name string
+		if len(names) > 0 {
+			name = names[len(names)-1].GetString_().GetSval()
+		}
+		if len(typ.GetArrayBounds()) > 0 {
+			name = "_" + name
+		}
+		prepare.ParamTypes = append(prepare.ParamTypes, name)
+	}
+	return prepare, nil
+}
+
+// Execute is an EXECUTE statement, executing a named prepared statement.
+type Execute struct {
+	Name   string
+	Params []interface{} // Parameter values, nil for NULL.
+}
+
+// ParseExecute returns the EXECUTE statement of sql. Parameters must be constants, cast or not.
+func ParseExecute(sql string) (*Execute, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetExecuteStmt() == nil {
+		return nil, fmt.Errorf("not an EXECUTE statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetExecuteStmt()
+
+	execute := &Execute{Name: stmt.GetName()}
+	for i, param := range stmt.GetParams() {
+		value, err := constValue(param)
+		if err != nil {
+			return nil, fmt.Errorf("EXECUTE parameter $%d: %w", i+1, err)
+		}
+		execute.Params = append(execute.Params, value)
+	}
+	return execute, nil
+}
+
+// Returns the value of a constant expression, casts are left to the statement.
+func constValue(n *pg_query.Node) (interface{}, error) {
+	if cast := n.GetTypeCast(); cast != nil {
+		return constValue(cast.GetArg())
+	}
+	c := n.GetAConst()
+	if c == nil {
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "only constant parameters are supported")
+	}
+	if c.GetIsnull() {
+		return nil, nil
+	}
+	switch v := c.GetVal().(type) {
+	case *pg_query.A_Const_Ival:
+		return int64(v.Ival.GetIval()), nil
+	case *pg_query.A_Const_Fval:
+		// Integers out of the range of int4 are parsed as floats too.
+		if i, err := strconv.ParseInt(v.Fval.GetFval(), 10, 64); err == nil {
+			return i, nil
+		}
+		return strconv.ParseFloat(v.Fval.GetFval(), 64)
+	case *pg_query.A_Const_Boolval:
+		return v.Boolval.GetBoolval(), nil
+	case *pg_query.A_Const_Sval:
+		return v.Sval.GetSval(), nil
+	case *pg_query.A_Const_Bsval:
+		return v.Bsval.GetBsval(), nil
+	}
+	return nil, pgerror.New(pgerror.FeatureNotSupported, "only constant parameters are supported")
+}
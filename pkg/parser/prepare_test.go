@@ -0,0 +1,29 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PREPARE and EXECUTE", func() {
+
+	It("Parse PREPARE with parameter types", func() {
+		prepare, err := parser.ParsePrepare(`PREPARE find (integer, text[], varchar) AS SELECT * FROM items WHERE id = $1`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(prepare.Name).To(Equal("find"))
+		Expect(prepare.Query).To(Equal(`SELECT * FROM items WHERE id = $1`))
+		Expect(prepare.ParamTypes).To(Equal([]string{"int4", "_text", "varchar"}))
+	})
+
+	It("Parse EXECUTE with constant parameters", func() {
+		execute, err := parser.ParseExecute(`EXECUTE find(1, -2.5, 'one', true, NULL, '2024-01-01'::date, 9000000000)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(execute.Name).To(Equal("find"))
+		Expect(execute.Params).To(Equal([]interface{}{int64(1), -2.5, "one", true, nil, "2024-01-01", int64(9000000000)}))
+
+		_, err = parser.ParseExecute(`EXECUTE find(now())`)
+		Expect(err).To(MatchError(ContainSubstring("only constant parameters are supported")))
+	})
+})
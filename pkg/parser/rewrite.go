@@ -1,9 +1,16 @@
 package parser
 
 import (
+	"cmp"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 // Replace query argument stubs like '?' with $n
@@ -17,33 +24,288 @@ func replaceArgStubs(sql string) string {
 	})
 }
 
-// Basic query rewrite.
+// Rewriter is a stage of the rewrite pipeline of RewriteQuery.
+type Rewriter interface {
+	// Rewrite returns the rewritten query, done skips the stages left.
+	Rewrite(q string) (query string, done bool)
+}
+
+// RewriterFunc adapts a function to the Rewriter interface.
+type RewriterFunc func(q string) (string, bool)
+
+func (f RewriterFunc) Rewrite(q string) (string, bool) {
+	return f(q)
+}
+
+type namedRewriter struct {
+	name string
+	Rewriter
+}
+
+var (
+	rewritersMu sync.RWMutex
+	rewriters   = []namedRewriter{
+		{"ignored", RewriterFunc(rewriteIgnored)},
+		{"catalog", RewriterFunc(rewriteCatalog)},
+		{"functions", RewriterFunc(rewriteFunctions)},
+		{"dialect", RewriterFunc(rewriteDialect)},
+		{"show", RewriterFunc(rewriteShow)},
+		{"placeholders", RewriterFunc(rewritePlaceholders)},
+	}
+)
+
+// RegisterRewriter adds a rewriter to the end of the rewrite pipeline, or replaces the
+// rewriter registered with the same name in place. The built-in stages are "ignored",
+// "catalog", "functions", "dialect", "show" and "placeholders", in that order. Rewriters
+// should be registered before queries are served, the kqlite server caches rewritten
+// queries.
+func RegisterRewriter(name string, r Rewriter) {
+	rewritersMu.Lock()
+	defer rewritersMu.Unlock()
+
+	// The pipeline is copied, queries being rewritten keep the previous one.
+	stages := slices.Clone(rewriters)
+	if i := slices.IndexFunc(stages, func(stage namedRewriter) bool { return stage.name == name }); i >= 0 {
+		stages[i].Rewriter = r
+	} else {
+		stages = append(stages, namedRewriter{name, r})
+	}
+	rewriters = stages
+}
+
+// UnregisterRewriter removes a rewriter from the rewrite pipeline, built-in stages too.
+func UnregisterRewriter(name string) {
+	rewritersMu.Lock()
+	defer rewritersMu.Unlock()
+
+	rewriters = slices.DeleteFunc(slices.Clone(rewriters), func(stage namedRewriter) bool { return stage.name == name })
+}
+
+// Rewriters returns the names of the stages of the rewrite pipeline, in order.
+func Rewriters() []string {
+	rewritersMu.RLock()
+	defer rewritersMu.RUnlock()
+
+	names := make([]string, len(rewriters))
+	for i, r := range rewriters {
+		names[i] = r.name
+	}
+	return names
+}
+
+// RewriteQuery rewrites a query string for SQLite through the stages of the rewrite
+// pipeline.
 func RewriteQuery(q string) string {
+	rewritersMu.RLock()
+	stages := rewriters
+	rewritersMu.RUnlock()
+
+	for _, stage := range stages {
+		var done bool
+		if q, done = stage.Rewrite(q); done {
+			break
+		}
+	}
+	return q
+}
+
+// Queries answered by empty results.
+func rewriteIgnored(q string) (string, bool) {
 	// Ignore SET queries by rewriting them to empty resultsets.
 	if strings.HasPrefix(q, "SET ") {
-		return `SELECT 'SET'`
+		return `SELECT 'SET'`, true
 	}
 
 	// Ignore this god forsaken query for pulling keywords.
 	if strings.Contains(q, `select string_agg(word, ',') from pg_catalog.pg_get_keywords()`) {
-		return `SELECT '' AS "string_agg" WHERE 1 = 2`
+		return `SELECT '' AS "string_agg" WHERE 1 = 2`, true
 	}
+	return q, false
+}
+
+// Function mapping.
+func rewriteFunctions(q string) (string, bool) {
+	// Functions of the pg_catalog are registered without schema. Calls are located on
+	// the parse tree, before system information variables are rewritten.
+	q = unqualifyCatalogFunctions(q)
 
 	// Rewrite system information variables so they are functions so we can inject them.
 	// https://www.postgresql.org/docs/9.1/functions-info.html
-	q = systemFunctionRegex.ReplaceAllString(q, "$1()$2")
+	return systemFunctionRegex.ReplaceAllString(q, "$1()$2"), false
+}
+
+// Removes the pg_catalog schema qualifying function calls.
+func unqualifyCatalogFunctions(q string) string {
+	tree, tokens := parseForRewrite(q)
+	if tree == nil {
+		return q
+	}
+	var edits []textEdit
+	eachMessage(tree.ProtoReflect(), func(m proto.Message) {
+		call, ok := m.(*pg_query.FuncCall)
+		if !ok || len(call.GetFuncname()) != 2 || call.GetFuncname()[0].GetString_().GetSval() != "pg_catalog" {
+			return
+		}
+		// Calls of SQL syntax such as EXTRACT are located at their keyword, only
+		// qualified names are changed.
+		i := tokenAt(tokens, call.GetLocation())
+		if i < 0 || i+2 >= len(tokens) || tokens[i+1].GetToken() != pg_query.Token_ASCII_46 ||
+			identifierText(q[tokens[i].GetStart():tokens[i].GetEnd()]) != "pg_catalog" {
+			return
+		}
+		edits = append(edits, textEdit{int(tokens[i].GetStart()), int(tokens[i+2].GetStart()), ""})
+	})
+	return applyEdits(q, edits)
+}
 
+// Dialect translation.
+func rewriteDialect(q string) (string, bool) {
 	// Rewrite double-colon casting by simply removing it.
 	// https://www.postgresql.org/docs/7.3/sql-expressions.html#SQL-SYNTAX-TYPE-CASTS
-	q = castRegex.ReplaceAllString(q, "")
+	return castRegex.ReplaceAllString(q, ""), false
+}
 
+// Catalog rewrites. The stage runs before the other translations, their results
+// aren't PostgreSQL queries the parse tree of the query could be read from.
+func rewriteCatalog(q string) (string, bool) {
 	// Remove references to the pg_catalog.
 	// q = pgCatalogRegex.ReplaceAllString(q, "")
 
-	// Rewrite "SHOW" commands into function calls.
-	q = showRegex.ReplaceAllString(q, "SELECT show('$1')")
+	// Sessions and their locks are listed by the system schema.
+	q = rewriteSystemViews(q)
+
+	// The public schema is the main SQLite database.
+	return qualifyPublic(q), false
+}
+
+// Tables of the system schema listing the PostgreSQL system views of the same name.
+var systemViews = map[string]string{
+	"pg_stat_activity": "kqlite.stat_activity",
+	"pg_locks":         "kqlite.locks",
+}
+
+// Replaces the relations naming system views by the tables of the system schema listing
+// them, aliased with the name of the view for the columns it qualifies.
+func rewriteSystemViews(q string) string {
+	tree, tokens := parseForRewrite(q)
+	if tree == nil {
+		return q
+	}
+	var edits []textEdit
+	eachMessage(tree.ProtoReflect(), func(m proto.Message) {
+		rv, ok := m.(*pg_query.RangeVar)
+		if !ok || rv.GetCatalogname() != "" || (rv.GetSchemaname() != "" && rv.GetSchemaname() != "pg_catalog") {
+			return
+		}
+		table, ok := systemViews[rv.GetRelname()]
+		if !ok {
+			return
+		}
+		first, last := tokenAt(tokens, rv.GetLocation()), -1
+		if first >= 0 && rv.GetSchemaname() != "" {
+			last = first + 2
+		} else if first >= 0 {
+			last = first
+		}
+		if last < 0 || last >= len(tokens) {
+			return
+		}
+		if rv.GetAlias() == nil {
+			table += " AS " + rv.GetRelname()
+		}
+		edits = append(edits, textEdit{int(tokens[first].GetStart()), int(tokens[last].GetEnd()), table})
+	})
+	return applyEdits(q, edits)
+}
+
+// Replacement of the text of a query between two byte offsets.
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// Applies edits to the text of a query, edits don't overlap.
+func applyEdits(q string, edits []textEdit) string {
+	// Edits are applied from the last one so the offsets of the others remain.
+	slices.SortFunc(edits, func(a, b textEdit) int { return cmp.Compare(b.start, a.start) })
+	for _, e := range edits {
+		q = q[:e.start] + e.text + q[e.end:]
+	}
+	return q
+}
+
+// Parses a query for rewrites located by its parse tree, along with its tokens. Argument
+// stubs are parsed as constants of the same length so locations match the query text.
+// Returns a nil tree if the query doesn't parse.
+func parseForRewrite(q string) (*pg_query.ParseResult, []*pg_query.ScanToken) {
+	text := strings.ReplaceAll(q, "?", "0")
+	tree, err := pg_query.Parse(text)
+	if err != nil {
+		return nil, nil
+	}
+	scan, err := pg_query.Scan(text)
+	if err != nil {
+		return nil, nil
+	}
+	return tree, scan.GetTokens()
+}
+
+// Calls fn for each message of a parse tree, including the messages nodes refer to
+// directly such as the relations of INSERT statements.
+func eachMessage(m protoreflect.Message, fn func(proto.Message)) {
+	fn(m.Interface())
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Message() == nil || fd.IsMap():
+		case fd.IsList():
+			for i, list := 0, v.List(); i < list.Len(); i++ {
+				eachMessage(list.Get(i).Message(), fn)
+			}
+		default:
+			eachMessage(v.Message(), fn)
+		}
+		return true
+	})
+}
+
+// Returns the index of the token starting at a location of the query text, -1 if none does.
+func tokenAt(tokens []*pg_query.ScanToken, location int32) int {
+	i, found := slices.BinarySearchFunc(tokens, location, func(t *pg_query.ScanToken, location int32) int {
+		return cmp.Compare(t.GetStart(), location)
+	})
+	if !found {
+		return -1
+	}
+	return i
+}
+
+// Replaces the public schema qualifying names by the main SQLite database. Only
+// unquoted schema names are replaced, string literals, comments and quoted
+// identifiers are left as is. Queries that can't be scanned are returned as is.
+func qualifyPublic(q string) string {
+	scan, err := pg_query.Scan(q)
+	if err != nil {
+		return q
+	}
+	tokens := scan.GetTokens()
+
+	// Names are replaced from the last one so the locations of the others remain.
+	for i := len(tokens) - 2; i >= 0; i-- {
+		start, end := tokens[i].GetStart(), tokens[i].GetEnd()
+		if tokens[i+1].GetToken() == pg_query.Token_ASCII_46 && strings.EqualFold(q[start:end], "public") {
+			q = q[:start] + "main" + q[end:]
+		}
+	}
+	return q
+}
+
+// Rewrite "SHOW" commands into function calls.
+func rewriteShow(q string) (string, bool) {
+	return showRegex.ReplaceAllString(q, "SELECT show('$1')"), false
+}
 
-	return replaceArgStubs(q)
+func rewritePlaceholders(q string) (string, bool) {
+	return replaceArgStubs(q), false
 }
 
 var (
@@ -53,5 +315,5 @@ var (
 
 	pgCatalogRegex = regexp.MustCompile(`\bpg_catalog\.`)
 
-	showRegex = regexp.MustCompile(`^SHOW (\w+)`)
+	showRegex = regexp.MustCompile(`(?i)^SHOW (\w+(?:\.\w+)?)`)
 )
@@ -0,0 +1,70 @@
+package parser_test
+
+import (
+	"strings"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rewrite pipeline", Ordered, func() {
+
+	It("Rewrite queries through the built-in stages", func() {
+		Expect(parser.Rewriters()).To(Equal([]string{"ignored", "catalog", "functions", "dialect", "show", "placeholders"}))
+		Expect(parser.RewriteQuery(`SELECT current_user, pg_catalog.version(), 'items'::regclass FROM public.items WHERE id = ?`)).
+			To(Equal(`SELECT current_user(), version(), 'items' FROM main.items WHERE id = $1`))
+		Expect(parser.RewriteQuery(`SET search_path = app`)).To(Equal(`SELECT 'SET'`))
+		Expect(parser.RewriteQuery(`SHOW search_path`)).To(Equal(`SELECT show('search_path')`))
+	})
+
+	It("Qualify relations of the public schema outside of literals", func() {
+		Expect(parser.RewriteQuery(`INSERT INTO PUBLIC.items (name) VALUES ('visit public.example.com') -- public.items`)).
+			To(Equal(`INSERT INTO main.items (name) VALUES ('visit public.example.com') -- public.items`))
+		Expect(parser.RewriteQuery(`SELECT "public.items".id FROM public.items AS "public.items"`)).
+			To(Equal(`SELECT "public.items".id FROM main.items AS "public.items"`))
+	})
+
+	It("Rewrite relations naming system views", func() {
+		Expect(parser.RewriteQuery(`SELECT pg_stat_activity.pid, 'pg_stat_activity' FROM pg_catalog.pg_stat_activity /* pg_stat_activity */`)).
+			To(Equal(`SELECT pg_stat_activity.pid, 'pg_stat_activity' FROM kqlite.stat_activity AS pg_stat_activity /* pg_stat_activity */`))
+		Expect(parser.RewriteQuery(`SELECT a.pid FROM pg_stat_activity a WHERE a.query <> 'pg_stat_activity' AND a.pid = ?`)).
+			To(Equal(`SELECT a.pid FROM kqlite.stat_activity a WHERE a.query <> 'pg_stat_activity' AND a.pid = $1`))
+		Expect(parser.RewriteQuery(`SELECT 'pg_locks', l.mode FROM pg_locks l JOIN pg_catalog.pg_locks USING (pid)`)).
+			To(Equal(`SELECT 'pg_locks', l.mode FROM kqlite.locks l JOIN kqlite.locks AS pg_locks USING (pid)`))
+	})
+
+	It("Remove the pg_catalog schema of function calls", func() {
+		Expect(parser.RewriteQuery(`SELECT PG_CATALOG.upper('pg_catalog.foo(x)'), pg_catalog . lower(name) FROM items`)).
+			To(Equal(`SELECT upper('pg_catalog.foo(x)'), lower(name) FROM items`))
+		Expect(parser.RewriteQuery(`SELECT extract(year FROM now()) -- pg_catalog.now()`)).
+			To(Equal(`SELECT extract(year FROM now()) -- pg_catalog.now()`))
+	})
+
+	It("Run registered rewriters after the built-in stages", func() {
+		parser.RegisterRewriter("now", parser.RewriterFunc(func(q string) (string, bool) {
+			return strings.ReplaceAll(q, "now()", "datetime('now')"), false
+		}))
+		defer parser.UnregisterRewriter("now")
+
+		Expect(parser.Rewriters()).To(HaveLen(7))
+		Expect(parser.RewriteQuery(`SELECT now() FROM public.items`)).To(Equal(`SELECT datetime('now') FROM main.items`))
+
+		// Ignored queries skip the stages left.
+		Expect(parser.RewriteQuery(`SET TIME ZONE 'UTC'; SELECT now()`)).To(Equal(`SELECT 'SET'`))
+	})
+
+	It("Replace rewriters by name", func() {
+		upper := parser.RewriterFunc(func(q string) (string, bool) { return strings.ToUpper(q), false })
+		lower := parser.RewriterFunc(func(q string) (string, bool) { return strings.ToLower(q), false })
+		parser.RegisterRewriter("case", upper)
+		parser.RegisterRewriter("trim", parser.RewriterFunc(func(q string) (string, bool) { return strings.TrimSpace(q), false }))
+		parser.RegisterRewriter("case", lower)
+		defer parser.UnregisterRewriter("case")
+		defer parser.UnregisterRewriter("trim")
+
+		Expect(parser.Rewriters()[6:]).To(Equal([]string{"case", "trim"}))
+		Expect(parser.RewriteQuery(` SELECT 1 FROM Items `)).To(Equal(`select 1 from items`))
+	})
+})
@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// RuleTrigger translates a CREATE RULE statement into the SQLite trigger with the same
+// effect, view tells whether the relation of the rule is a view. DO INSTEAD rules of
+// views are INSTEAD OF triggers, those of tables run before the row is modified and
+// skip its modification. DO ALSO rules run after the row is modified.
+func RuleTrigger(sql string, view bool) (string, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetRuleStmt() == nil {
+		return "", fmt.Errorf("not a CREATE RULE statement")
+	}
+	rule := tree.Stmts[0].GetStmt().GetRuleStmt()
+
+	var event string
+	switch rule.GetEvent() {
+	case pg_query.CmdType_CMD_INSERT:
+		event = "INSERT"
+	case pg_query.CmdType_CMD_UPDATE:
+		event = "UPDATE"
+	case pg_query.CmdType_CMD_DELETE:
+		event = "DELETE"
+	default:
+		return "", pgerror.New(pgerror.FeatureNotSupported, "rules on SELECT are not supported, create a view instead")
+	}
+
+	timing := "AFTER"
+	switch {
+	case rule.GetInstead() && view:
+		timing = "INSTEAD OF"
+	case rule.GetInstead():
+		timing = "BEFORE"
+	case view:
+		return "", pgerror.New(pgerror.FeatureNotSupported, "DO ALSO rules on views are not supported, use DO INSTEAD")
+	}
+
+	var b strings.Builder
+	name := quoteIdentifier(rule.GetRulename())
+	if rule.GetReplace() {
+		fmt.Fprintf(&b, "DROP TRIGGER IF EXISTS %s; ", name)
+	}
+	// Views aren't updated automatically on events with rules.
+	if view {
+		fmt.Fprintf(&b, "DROP TRIGGER IF EXISTS %s; ", quoteIdentifier(ViewTriggerName(rule.GetRelation().GetRelname(), event)))
+	}
+	fmt.Fprintf(&b, "CREATE TRIGGER %s %s %s ON %s FOR EACH ROW", name, timing, event,
+		quoteIdentifier(rule.GetRelation().GetRelname()))
+	if where := rule.GetWhereClause(); where != nil {
+		cond, err := DeparseExpr(where)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, " WHEN %s", cond)
+	}
+
+	b.WriteString(" BEGIN ")
+	for _, action := range rule.GetActions() {
+		// Tables modified by trigger statements can't be qualified in SQLite.
+		for _, rangevar := range actionRelations(action) {
+			if rangevar.GetSchemaname() == "public" || rangevar.GetSchemaname() == "main" {
+				rangevar.Schemaname = ""
+			}
+		}
+		stmt, err := Deparse(action)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s; ", stmt)
+	}
+	// DO INSTEAD NOTHING has no action, SQLite triggers need one.
+	if (rule.GetInstead() && !view) || len(rule.GetActions()) == 0 {
+		b.WriteString("SELECT RAISE(IGNORE); ")
+	}
+	b.WriteString("END")
+	return b.String(), nil
+}
+
+// DropRuleTrigger translates a DROP RULE statement into the statement dropping
+// the trigger the rule was translated to.
+func DropRuleTrigger(sql string) (string, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetDropStmt().GetRemoveType() != pg_query.ObjectType_OBJECT_RULE {
+		return "", fmt.Errorf("not a DROP RULE statement")
+	}
+	drop := tree.Stmts[0].GetStmt().GetDropStmt()
+
+	var stmts []string
+	for _, object := range drop.GetObjects() {
+		// Rules are named by their relation and their name, the name comes last.
+		names := object.GetList().GetItems()
+		if len(names) == 0 {
+			continue
+		}
+		name := quoteIdentifier(names[len(names)-1].GetString_().GetSval())
+		if drop.GetMissingOk() {
+			stmts = append(stmts, "DROP TRIGGER IF EXISTS "+name)
+		} else {
+			stmts = append(stmts, "DROP TRIGGER "+name)
+		}
+	}
+	return strings.Join(stmts, "; "), nil
+}
+
+// Returns the relations modified by a rule action.
+func actionRelations(action *pg_query.Node) []*pg_query.RangeVar {
+	switch n := action.GetNode().(type) {
+	case *pg_query.Node_InsertStmt:
+		return []*pg_query.RangeVar{n.InsertStmt.GetRelation()}
+	case *pg_query.Node_UpdateStmt:
+		return []*pg_query.RangeVar{n.UpdateStmt.GetRelation()}
+	case *pg_query.Node_DeleteStmt:
+		return []*pg_query.RangeVar{n.DeleteStmt.GetRelation()}
+	}
+	return nil
+}
+
+// Quotes an identifier, as in SQL.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
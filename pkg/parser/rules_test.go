@@ -0,0 +1,48 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules", func() {
+
+	It("Translate DO INSTEAD rules of tables", func() {
+		trigger, err := parser.RuleTrigger(`CREATE RULE soft_delete AS ON DELETE TO public.items
+			DO INSTEAD UPDATE public.items SET deleted_at = now() WHERE id = OLD.id`, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trigger).To(Equal(`CREATE TRIGGER "soft_delete" BEFORE DELETE ON "items" FOR EACH ROW BEGIN ` +
+			`UPDATE items SET deleted_at = now() WHERE id = old.id; SELECT RAISE(IGNORE); END`))
+	})
+
+	It("Translate rules of views", func() {
+		trigger, err := parser.RuleTrigger(`CREATE OR REPLACE RULE locked AS ON UPDATE TO items WHERE OLD.locked DO INSTEAD NOTHING`, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trigger).To(Equal(`DROP TRIGGER IF EXISTS "locked"; DROP TRIGGER IF EXISTS "kqlite_view_items_update"; ` +
+			`CREATE TRIGGER "locked" INSTEAD OF UPDATE ON "items" FOR EACH ROW WHEN old.locked BEGIN SELECT RAISE(IGNORE); END`))
+
+		_, err = parser.RuleTrigger(`CREATE RULE log AS ON INSERT TO items DO ALSO INSERT INTO log (item) VALUES (NEW.id)`, true)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Translate DO ALSO rules", func() {
+		trigger, err := parser.RuleTrigger(`CREATE RULE log AS ON INSERT TO items
+			DO ALSO (INSERT INTO log (item) VALUES (NEW.id); DELETE FROM stale WHERE item = NEW.id)`, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trigger).To(Equal(`CREATE TRIGGER "log" AFTER INSERT ON "items" FOR EACH ROW BEGIN ` +
+			`INSERT INTO log (item) VALUES (new.id); DELETE FROM stale WHERE item = new.id; END`))
+	})
+
+	It("Reject rules on SELECT", func() {
+		_, err := parser.RuleTrigger(`CREATE RULE "_RETURN" AS ON SELECT TO items DO INSTEAD SELECT * FROM other`, false)
+		Expect(err).To(MatchError(ContainSubstring("not supported")))
+	})
+
+	It("Translate DROP RULE", func() {
+		drop, err := parser.DropRuleTrigger(`DROP RULE IF EXISTS soft_delete ON items`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(drop).To(Equal(`DROP TRIGGER IF EXISTS "soft_delete"`))
+	})
+})
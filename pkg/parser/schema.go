@@ -0,0 +1,162 @@
+package parser
+
+import (
+	"fmt"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// CreateSchema returns the name of the schema created by a CREATE SCHEMA statement, and
+// whether it may exist already. Schemas are named after their owner when they have no name.
+func CreateSchema(sql string) (name string, ifNotExists bool, err error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", false, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetCreateSchemaStmt() == nil {
+		return "", false, fmt.Errorf("not a CREATE SCHEMA statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetCreateSchemaStmt()
+	if len(stmt.GetSchemaElts()) > 0 {
+		return "", false, pgerror.New(pgerror.FeatureNotSupported, "CREATE SCHEMA with schema elements is not supported")
+	}
+	name = stmt.GetSchemaname()
+	if name == "" {
+		name = stmt.GetAuthrole().GetRolename()
+	}
+	return name, stmt.GetIfNotExists(), nil
+}
+
+// CreateDatabase returns the name of the database created by a CREATE DATABASE statement and
+// its template, empty without template or for TEMPLATE DEFAULT. Other options are ignored.
+func CreateDatabase(sql string) (name, template string, err error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", "", parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetCreatedbStmt() == nil {
+		return "", "", fmt.Errorf("not a CREATE DATABASE statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetCreatedbStmt()
+	for _, option := range stmt.GetOptions() {
+		if def := option.GetDefElem(); def.GetDefname() == "template" {
+			template = def.GetArg().GetString_().GetSval()
+		}
+	}
+	return stmt.GetDbname(), template, nil
+}
+
+// Collects the relations read by a statement and the names of its common table expressions.
+type relationWalker struct {
+	rangevars []*pg_query.RangeVar
+	ctes      map[string]bool
+}
+
+func (walker *relationWalker) Visit(node *pg_query.Node) (v Visitor, err error) {
+	switch n := node.Node.(type) {
+	case *pg_query.Node_RangeVar:
+		walker.rangevars = append(walker.rangevars, n.RangeVar)
+	case *pg_query.Node_CommonTableExpr:
+		walker.ctes[n.CommonTableExpr.GetCtename()] = true
+	}
+	return walker, nil
+}
+
+func (walker *relationWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// QualifyRelations qualifies the unqualified relations of a statement by the schema
+// returned by resolve, given the relation name and whether the statement creates it.
+// Relations are left unqualified when resolve returns an empty schema, the statement is
+// returned as it is if none was qualified.
+func QualifyRelations(sql string, resolve func(name string, create bool) (string, error)) (string, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", parseError(err)
+	}
+	if len(tree.Stmts) != 1 {
+		return sql, nil
+	}
+	stmt := tree.Stmts[0].GetStmt()
+
+	walker := &relationWalker{ctes: make(map[string]bool)}
+	if err := Walk(walker, stmt); err != nil {
+		return "", err
+	}
+	// The relations created or modified by statements aren't nodes of the tree.
+	var created *pg_query.RangeVar
+	targets := walker.rangevars
+	switch n := stmt.GetNode().(type) {
+	case *pg_query.Node_CreateStmt:
+		created = n.CreateStmt.GetRelation()
+	case *pg_query.Node_ViewStmt:
+		created = n.ViewStmt.GetView()
+	case *pg_query.Node_CreateTableAsStmt:
+		created = n.CreateTableAsStmt.GetInto().GetRel()
+	case *pg_query.Node_InsertStmt:
+		targets = append(targets, n.InsertStmt.GetRelation())
+	case *pg_query.Node_UpdateStmt:
+		targets = append(targets, n.UpdateStmt.GetRelation())
+	case *pg_query.Node_DeleteStmt:
+		targets = append(targets, n.DeleteStmt.GetRelation())
+	case *pg_query.Node_AlterTableStmt:
+		targets = append(targets, n.AlterTableStmt.GetRelation())
+	case *pg_query.Node_RenameStmt:
+		targets = append(targets, n.RenameStmt.GetRelation())
+	case *pg_query.Node_DropStmt:
+		switch n.DropStmt.GetRemoveType() {
+		case pg_query.ObjectType_OBJECT_TABLE, pg_query.ObjectType_OBJECT_VIEW:
+			return qualifyDropObjects(sql, tree, n.DropStmt, resolve)
+		}
+	}
+
+	qualified := false
+	qualify := func(rangevar *pg_query.RangeVar, create bool) error {
+		if rangevar == nil || rangevar.GetSchemaname() != "" || rangevar.GetRelpersistence() == "t" || walker.ctes[rangevar.GetRelname()] {
+			return nil
+		}
+		schema, err := resolve(rangevar.GetRelname(), create)
+		if err != nil || schema == "" {
+			return err
+		}
+		rangevar.Schemaname = schema
+		qualified = true
+		return nil
+	}
+	if err := qualify(created, true); err != nil {
+		return "", err
+	}
+	for _, rangevar := range targets {
+		if err := qualify(rangevar, false); err != nil {
+			return "", err
+		}
+	}
+	if !qualified {
+		return sql, nil
+	}
+	return pg_query.Deparse(tree)
+}
+
+// Qualifies the unqualified relations dropped by a DROP TABLE or DROP VIEW statement.
+func qualifyDropObjects(sql string, tree *pg_query.ParseResult, drop *pg_query.DropStmt, resolve func(name string, create bool) (string, error)) (string, error) {
+	qualified := false
+	for _, object := range drop.GetObjects() {
+		list := object.GetList()
+		if len(list.GetItems()) != 1 {
+			continue
+		}
+		schema, err := resolve(list.GetItems()[0].GetString_().GetSval(), false)
+		if err != nil {
+			return "", err
+		} else if schema == "" {
+			continue
+		}
+		list.Items = append([]*pg_query.Node{pg_query.MakeStrNode(schema)}, list.Items...)
+		qualified = true
+	}
+	if !qualified {
+		return sql, nil
+	}
+	return pg_query.Deparse(tree)
+}
@@ -0,0 +1,64 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schemas", func() {
+
+	// Relations of the sales schema, orders are created in it.
+	resolve := func(name string, create bool) (string, error) {
+		if create || name == "items" || name == "orders" {
+			return "sales", nil
+		}
+		return "", nil
+	}
+
+	It("Qualify unqualified relations", func() {
+		for sql, expected := range map[string]string{
+			`SELECT * FROM items JOIN tags ON tags.item = items.id`:   `SELECT * FROM sales.items JOIN tags ON tags.item = items.id`,
+			`SELECT * FROM public.items`:                              `SELECT * FROM public.items`,
+			`WITH items AS (SELECT 1) SELECT * FROM items`:            `WITH items AS (SELECT 1) SELECT * FROM items`,
+			`INSERT INTO orders (id) SELECT id FROM items`:            `INSERT INTO sales.orders (id) SELECT id FROM sales.items`,
+			`UPDATE items SET name = 'x'`:                             `UPDATE sales.items SET name = 'x'`,
+			`DELETE FROM items WHERE id IN (SELECT item FROM orders)`: `DELETE FROM sales.items WHERE id IN (SELECT item FROM sales.orders)`,
+			`CREATE TABLE invoices (id int)`:                          `CREATE TABLE sales.invoices (id int)`,
+			`CREATE TEMP TABLE scratch (id int)`:                      `CREATE TEMP TABLE scratch (id int)`,
+			`DROP TABLE items, tags`:                                  `DROP TABLE sales.items, tags`,
+			`SELECT * FROM tags`:                                      `SELECT * FROM tags`,
+		} {
+			text, err := parser.QualifyRelations(sql, resolve)
+			Expect(err).NotTo(HaveOccurred(), sql)
+			Expect(text).To(Equal(expected), sql)
+		}
+	})
+
+	It("Create schemas", func() {
+		name, ifNotExists, err := parser.CreateSchema(`CREATE SCHEMA IF NOT EXISTS sales`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("sales"))
+		Expect(ifNotExists).To(BeTrue())
+
+		name, _, err = parser.CreateSchema(`CREATE SCHEMA AUTHORIZATION alice`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("alice"))
+
+		_, _, err = parser.CreateSchema(`CREATE SCHEMA sales CREATE TABLE items (id int)`)
+		Expect(err).To(MatchError(ContainSubstring("schema elements")))
+	})
+
+	It("Create databases from templates", func() {
+		name, template, err := parser.CreateDatabase(`CREATE DATABASE "tenant1.db" WITH TEMPLATE = "tenant.db" ENCODING 'UTF8'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("tenant1.db"))
+		Expect(template).To(Equal("tenant.db"))
+
+		name, template, err = parser.CreateDatabase(`CREATE DATABASE "empty.db" TEMPLATE DEFAULT`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("empty.db"))
+		Expect(template).To(BeEmpty())
+	})
+})
@@ -0,0 +1,364 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Text search configuration of to_tsvector calls without one, and of indexes of tsvector columns.
+const defaultTextSearchConfig = "english"
+
+// FTS5 tokenizers of the text search configurations. Stemming is only available in English.
+var textSearchTokenizers = map[string]string{
+	"english": "porter unicode61",
+	"simple":  "unicode61",
+}
+
+// Functions parsing text search queries.
+var textSearchQueryFuncs = []string{"to_tsquery", "plainto_tsquery", "phraseto_tsquery", "websearch_to_tsquery"}
+
+// TextSearchIndex is a GIN or GiST index of the documents of a table for full text
+// search. Its documents are held by an FTS5 table, by rowid of the table rows.
+type TextSearchIndex struct {
+	Name     string // Index name, the FTS5 table is named by FTSTable.
+	Table    string
+	Document string // Text expression of the documents, a tsvector column or the text of to_tsvector.
+	Config   string // Text search configuration.
+	Column   string // tsvector column of the documents, empty for to_tsvector expressions.
+
+	IfNotExists bool
+}
+
+// FTSTable returns the name of the FTS5 table of the index.
+func (index *TextSearchIndex) FTSTable() string {
+	return "kqlite_fts_" + index.Name
+}
+
+// Returns the text expression and the configuration of the documents of a to_tsvector call.
+// Unset configurations are the default one.
+func toTsvector(fn *pg_query.FuncCall) (document *pg_query.Node, config string, err error) {
+	args := fn.GetArgs()
+	switch len(args) {
+	case 1:
+		return args[0], defaultTextSearchConfig, nil
+	case 2:
+		config = args[0].GetAConst().GetSval().GetSval()
+		if cast := args[0].GetTypeCast(); cast != nil {
+			config = cast.GetArg().GetAConst().GetSval().GetSval()
+		}
+		config = strings.TrimPrefix(config, "pg_catalog.")
+		if _, ok := textSearchTokenizers[config]; !ok {
+			return nil, "", pgerror.Newf(pgerror.FeatureNotSupported, "text search configuration %q is not supported", config)
+		}
+		return args[1], config, nil
+	}
+	return nil, "", pgerror.New(pgerror.FeatureNotSupported, "to_tsvector takes a document and an optional configuration")
+}
+
+// Returns the name of the function called, without schema.
+func funcName(fn *pg_query.FuncCall) string {
+	names := fn.GetFuncname()
+	if len(names) == 0 {
+		return ""
+	}
+	return names[len(names)-1].GetString_().GetSval()
+}
+
+// Unqualifies the column references of an expression, returns the qualifier of the
+// last qualified column.
+type unqualifyWalker struct {
+	qualifier string
+}
+
+func (walker *unqualifyWalker) Visit(node *pg_query.Node) (v Visitor, err error) {
+	if ref := node.GetColumnRef(); ref != nil && len(ref.GetFields()) > 1 {
+		fields := ref.GetFields()
+		walker.qualifier = fields[len(fields)-2].GetString_().GetSval()
+		ref.Fields = fields[len(fields)-1:]
+	}
+	return walker, nil
+}
+
+func (walker *unqualifyWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// Returns the text of a document expression without column qualifiers, and the qualifier.
+func documentText(document *pg_query.Node) (text, qualifier string, err error) {
+	walker := &unqualifyWalker{}
+	if err := Walk(walker, document); err != nil {
+		return "", "", err
+	}
+	text, err = DeparseExpr(document)
+	return text, walker.qualifier, err
+}
+
+// Returns the text search index created by a CREATE INDEX statement using the gin or gist
+// access method, on a to_tsvector expression or a tsvector column. Nil for other indexes.
+func textSearchIndex(stmt *pg_query.IndexStmt) (*TextSearchIndex, error) {
+	if method := stmt.GetAccessMethod(); method != "gin" && method != "gist" {
+		return nil, nil
+	}
+	params := stmt.GetIndexParams()
+	if len(params) != 1 || stmt.GetUnique() || stmt.GetWhereClause() != nil {
+		return nil, nil
+	}
+
+	elem := params[0].GetIndexElem()
+	index := &TextSearchIndex{
+		Name:        stmt.GetIdxname(),
+		Table:       stmt.GetRelation().GetRelname(),
+		Config:      defaultTextSearchConfig,
+		IfNotExists: stmt.GetIfNotExists(),
+	}
+	document := &pg_query.Node{Node: &pg_query.Node_ColumnRef{ColumnRef: &pg_query.ColumnRef{
+		Fields: []*pg_query.Node{pg_query.MakeStrNode(elem.GetName())},
+	}}}
+	if fn := elem.GetExpr().GetFuncCall(); fn != nil && funcName(fn) == "to_tsvector" {
+		var err error
+		if document, index.Config, err = toTsvector(fn); err != nil {
+			return nil, err
+		}
+	} else if elem.GetExpr() != nil {
+		return nil, nil
+	} else {
+		index.Column = elem.GetName()
+	}
+	var err error
+	if index.Document, _, err = documentText(document); err != nil {
+		return nil, err
+	}
+	if index.Name == "" {
+		index.Name = index.Table + "_" + elem.GetName() + "_idx"
+		if elem.GetExpr() != nil {
+			index.Name = index.Table + "_to_tsvector_idx"
+		}
+	}
+	return index, nil
+}
+
+// Translates a tsvector column generated by to_tsvector into the virtual column of the text of
+// its documents, returns the translated generation expression. Other tsvector columns can't be
+// computed by SQLite and are rejected.
+func textSearchColumn(column *pg_query.ColumnDef) (string, error) {
+	e := pgerror.Newf(pgerror.FeatureNotSupported, "tsvector column %q is not supported", column.GetColname())
+	e.Hint = "Generate the column with GENERATED ALWAYS AS (to_tsvector(...)) STORED and search it through a GIN index."
+	for _, node := range column.GetConstraints() {
+		constraint := node.GetConstraint()
+		if constraint.GetContype() != pg_query.ConstrType_CONSTR_GENERATED {
+			continue
+		}
+		fn := constraint.GetRawExpr().GetFuncCall()
+		if fn == nil || funcName(fn) != "to_tsvector" {
+			return "", e
+		}
+		document, _, err := toTsvector(fn)
+		if err != nil {
+			return "", err
+		}
+		return sqliteExpr(document)
+	}
+	return "", e
+}
+
+// Prefixes column references of trigger expressions with NEW.
+type newRowWalker struct{}
+
+func (walker newRowWalker) Visit(node *pg_query.Node) (v Visitor, err error) {
+	if ref := node.GetColumnRef(); ref != nil {
+		ref.Fields = append([]*pg_query.Node{pg_query.MakeStrNode("new")}, ref.GetFields()...)
+	}
+	return walker, nil
+}
+
+func (walker newRowWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// TextSearchTable returns the statements creating the FTS5 table of a text search index,
+// filled with the documents of the table, and the triggers keeping it in sync.
+func TextSearchTable(index *TextSearchIndex) ([]string, error) {
+	parse := func() (*pg_query.Node, error) {
+		tree, err := pg_query.Parse("SELECT " + index.Document)
+		if err != nil {
+			return nil, parseError(err)
+		}
+		return tree.Stmts[0].GetStmt().GetSelectStmt().GetTargetList()[0].GetResTarget().GetVal(), nil
+	}
+	document, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sqliteExpr(document)
+	if err != nil {
+		return nil, err
+	}
+	if document, err = parse(); err != nil {
+		return nil, err
+	}
+	if err := Walk(newRowWalker{}, document); err != nil {
+		return nil, err
+	}
+	row, err := sqliteExpr(document)
+	if err != nil {
+		return nil, err
+	}
+
+	fts, table := quoteIdentifier(index.FTSTable()), quoteIdentifier(index.Table)
+	insert := fmt.Sprintf("INSERT INTO %s (rowid, document) VALUES (NEW.rowid, %s)", fts, row)
+	remove := fmt.Sprintf("DELETE FROM %s WHERE rowid = OLD.rowid", fts)
+	trigger := func(event, body string) string {
+		return fmt.Sprintf("CREATE TRIGGER %s AFTER %s ON %s FOR EACH ROW BEGIN %s; END",
+			quoteIdentifier(index.FTSTable()+"_"+strings.ToLower(event)), event, table, body)
+	}
+	return []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(document, tokenize = '%s')", fts, textSearchTokenizers[index.Config]),
+		fmt.Sprintf("INSERT INTO %s (rowid, document) SELECT rowid, %s FROM %s", fts, rows, table),
+		trigger("INSERT", insert),
+		trigger("UPDATE", remove+"; "+insert),
+		trigger("DELETE", remove),
+	}, nil
+}
+
+// DropTextSearchTable returns the statements dropping the FTS5 table of a text search
+// index and its triggers.
+func DropTextSearchTable(index *TextSearchIndex) []string {
+	stmts := []string{"DROP TABLE IF EXISTS " + quoteIdentifier(index.FTSTable())}
+	for _, event := range []string{"insert", "update", "delete"} {
+		stmts = append(stmts, "DROP TRIGGER IF EXISTS "+quoteIdentifier(index.FTSTable()+"_"+event))
+	}
+	return stmts
+}
+
+// Replaces text search matches by the rowid lookups of the FTS5 tables of text search indexes.
+type textSearchWalker struct {
+	indexes []*TextSearchIndex
+	tables  map[string]string // Tables of the statement by qualifier, their alias or their name.
+	matches []string          // SQLite expressions of the replaced matches.
+}
+
+func (walker *textSearchWalker) Visit(node *pg_query.Node) (v Visitor, err error) {
+	expr := node.GetAExpr()
+	if expr == nil || expr.GetKind() != pg_query.A_Expr_Kind_AEXPR_OP || len(expr.GetName()) != 1 ||
+		expr.GetName()[0].GetString_().GetSval() != "@@" {
+		return walker, nil
+	}
+	vector, query := expr.GetLexpr(), expr.GetRexpr()
+	if fn := query.GetFuncCall(); query.GetColumnRef() != nil || (fn != nil && funcName(fn) == "to_tsvector") {
+		vector, query = query, vector
+	}
+
+	// Documents are matched against the indexes of the tables of the statement.
+	document, config := vector, ""
+	if fn := vector.GetFuncCall(); fn != nil && funcName(fn) == "to_tsvector" {
+		if document, config, err = toTsvector(fn); err != nil {
+			return nil, err
+		}
+	} else if vector.GetColumnRef() == nil {
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "text search matches other than of to_tsvector or tsvector columns are not supported")
+	}
+	text, qualifier, err := documentText(document)
+	if err != nil {
+		return nil, err
+	}
+	var index *TextSearchIndex
+	var table string // Qualifier of the table of the index.
+	for _, candidate := range walker.indexes {
+		if candidate.Document != text || (config != "" && candidate.Config != config) {
+			continue
+		}
+		for q, name := range walker.tables {
+			if name == candidate.Table && (qualifier == "" || qualifier == q) {
+				index, table = candidate, q
+			}
+		}
+	}
+	if index == nil {
+		e := pgerror.Newf(pgerror.FeatureNotSupported, "text search of %s requires a text search index", text)
+		e.Hint = "Create a GIN index on the searched tsvector column or to_tsvector expression."
+		return nil, e
+	}
+
+	kind := "to_tsquery"
+	if fn := query.GetFuncCall(); fn != nil && slices.Contains(textSearchQueryFuncs, funcName(fn)) {
+		kind = funcName(fn)
+		args := fn.GetArgs()
+		query = args[len(args)-1]
+	} else if cast := query.GetTypeCast(); cast != nil {
+		query = cast.GetArg()
+	}
+	arg, err := sqliteExpr(query)
+	if err != nil {
+		return nil, err
+	}
+
+	fts := quoteIdentifier(index.FTSTable())
+	walker.matches = append(walker.matches, fmt.Sprintf("%s.rowid IN (SELECT rowid FROM %s WHERE %s MATCH kqlite_tsquery('%s', %s))",
+		quoteIdentifier(table), fts, fts, kind, arg))
+	node.Node = &pg_query.Node_ColumnRef{ColumnRef: &pg_query.ColumnRef{Fields: []*pg_query.Node{
+		pg_query.MakeStrNode(matchPlaceholder(len(walker.matches) - 1)),
+	}}}
+	return nil, nil
+}
+
+func (walker *textSearchWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// Returns the name of the column standing for a text search match while statements are translated.
+func matchPlaceholder(i int) string {
+	return "kqlite_match_" + strconv.Itoa(i) + "_"
+}
+
+// TextSearchQuery translates the text search matches of a statement, tsvector @@ tsquery,
+// into lookups of the FTS5 tables of the given text search indexes. Documents are
+// matched by the index of the same expression or tsvector column, queries are
+// converted to FTS5 queries by the kqlite_tsquery SQLite function.
+func TextSearchQuery(sql string, indexes []*TextSearchIndex) (string, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", parseError(err)
+	}
+	if len(tree.Stmts) != 1 {
+		return "", fmt.Errorf("not a single statement")
+	}
+	stmt := tree.Stmts[0].GetStmt()
+
+	walker := &textSearchWalker{indexes: indexes, tables: make(map[string]string)}
+	rangevars := rangeVars(stmt)
+	switch n := stmt.GetNode().(type) {
+	case *pg_query.Node_UpdateStmt:
+		rangevars = append(rangevars, n.UpdateStmt.GetRelation())
+	case *pg_query.Node_DeleteStmt:
+		rangevars = append(rangevars, n.DeleteStmt.GetRelation())
+	}
+	for _, rangevar := range rangevars {
+		qualifier := rangevar.GetRelname()
+		if alias := rangevar.GetAlias(); alias != nil {
+			qualifier = alias.GetAliasname()
+		}
+		walker.tables[qualifier] = rangevar.GetRelname()
+	}
+	if err := Walk(walker, stmt); err != nil {
+		return "", err
+	}
+	if len(walker.matches) == 0 {
+		return sql, nil
+	}
+
+	casts := &sqliteExprWalker{}
+	if err := Walk(casts, stmt); err != nil {
+		return "", err
+	}
+	text, err := Deparse(stmt)
+	if err != nil {
+		return "", err
+	}
+	for i, cast := range casts.casts {
+		text = strings.Replace(text, castPlaceholder(i), cast, 1)
+	}
+	for i, match := range walker.matches {
+		text = strings.Replace(text, matchPlaceholder(i), match, 1)
+	}
+	return text, nil
+}
@@ -0,0 +1,65 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Text search", func() {
+
+	It("Create FTS5 tables for GIN indexes of documents", func() {
+		_, index, err := parser.CreateIndex(`CREATE INDEX docs_fts ON docs USING gin (to_tsvector('english'::regconfig, coalesce(title, '') || ' ' || body))`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index).To(Equal(&parser.TextSearchIndex{Name: "docs_fts", Table: "docs",
+			Document: "(COALESCE(title, '') || ' ') || body", Config: "english"}))
+
+		stmts, err := parser.TextSearchTable(index)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stmts).To(Equal([]string{
+			`CREATE VIRTUAL TABLE "kqlite_fts_docs_fts" USING fts5(document, tokenize = 'porter unicode61')`,
+			`INSERT INTO "kqlite_fts_docs_fts" (rowid, document) SELECT rowid, (COALESCE(title, '') || ' ') || body FROM "docs"`,
+			`CREATE TRIGGER "kqlite_fts_docs_fts_insert" AFTER INSERT ON "docs" FOR EACH ROW BEGIN ` +
+				`INSERT INTO "kqlite_fts_docs_fts" (rowid, document) VALUES (NEW.rowid, (COALESCE(new.title, '') || ' ') || new.body); END`,
+			`CREATE TRIGGER "kqlite_fts_docs_fts_update" AFTER UPDATE ON "docs" FOR EACH ROW BEGIN ` +
+				`DELETE FROM "kqlite_fts_docs_fts" WHERE rowid = OLD.rowid; ` +
+				`INSERT INTO "kqlite_fts_docs_fts" (rowid, document) VALUES (NEW.rowid, (COALESCE(new.title, '') || ' ') || new.body); END`,
+			`CREATE TRIGGER "kqlite_fts_docs_fts_delete" AFTER DELETE ON "docs" FOR EACH ROW BEGIN ` +
+				`DELETE FROM "kqlite_fts_docs_fts" WHERE rowid = OLD.rowid; END`,
+		}))
+
+		_, index, err = parser.CreateIndex(`CREATE INDEX ON docs USING gin (tsv)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(index).To(Equal(&parser.TextSearchIndex{Name: "docs_tsv_idx", Table: "docs", Document: "tsv", Config: "english", Column: "tsv"}))
+	})
+
+	It("Generate tsvector columns as the text of their documents", func() {
+		sql, err := parser.GeneratedColumns(`CREATE TABLE docs (body text, tsv tsvector GENERATED ALWAYS AS (to_tsvector('simple', body)) STORED)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`CREATE TABLE docs (body text, tsv tsvector GENERATED ALWAYS AS (body) VIRTUAL)`))
+
+		_, err = parser.GeneratedColumns(`CREATE TABLE docs (body text, tsv tsvector)`)
+		Expect(pgerror.Convert(err).Code).To(Equal(pgerror.FeatureNotSupported))
+	})
+
+	It("Translate matches into lookups of FTS5 tables", func() {
+		indexes := []*parser.TextSearchIndex{
+			{Name: "docs_fts", Table: "docs", Document: "body", Config: "english"},
+			{Name: "docs_tsv", Table: "docs", Document: "tsv", Config: "english"},
+		}
+		sql, err := parser.TextSearchQuery(`SELECT id FROM docs d WHERE to_tsvector(d.body) @@ plainto_tsquery('english', $1) ORDER BY id`, indexes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`SELECT id FROM docs d WHERE "d".rowid IN (SELECT rowid FROM "kqlite_fts_docs_fts" ` +
+			`WHERE "kqlite_fts_docs_fts" MATCH kqlite_tsquery('plainto_tsquery', $1)) ORDER BY id`))
+
+		sql, err = parser.TextSearchQuery(`DELETE FROM docs WHERE 'cat & !dog'::tsquery @@ tsv`, indexes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal(`DELETE FROM docs WHERE "docs".rowid IN (SELECT rowid FROM "kqlite_fts_docs_tsv" ` +
+			`WHERE "kqlite_fts_docs_tsv" MATCH kqlite_tsquery('to_tsquery', 'cat & !dog'))`))
+
+		_, err = parser.TextSearchQuery(`SELECT id FROM docs WHERE to_tsvector(title) @@ to_tsquery('cat')`, indexes)
+		Expect(pgerror.Convert(err).Code).To(Equal(pgerror.FeatureNotSupported))
+	})
+})
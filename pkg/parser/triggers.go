@@ -0,0 +1,227 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Timing and event bits of CREATE TRIGGER statements, as PostgreSQL defines them.
+const (
+	triggerBefore   = 1 << 1
+	triggerInsert   = 1 << 2
+	triggerDelete   = 1 << 3
+	triggerUpdate   = 1 << 4
+	triggerTruncate = 1 << 5
+	triggerInstead  = 1 << 6
+)
+
+// Hint of the trigger functions that can't be translated.
+const triggerFunctionHint = "Write the trigger function in LANGUAGE sql, its statements are executed for each row with NEW and OLD."
+
+// Trigger is a row-level trigger executing a trigger function, translated into SQLite
+// triggers executing the statements of the function.
+type Trigger struct {
+	Name     string
+	Table    string
+	Function string
+	Replace  bool // CREATE OR REPLACE.
+
+	timing  string   // BEFORE, AFTER or INSTEAD OF.
+	events  []string // INSERT, UPDATE and DELETE.
+	columns []string // Columns of UPDATE OF.
+	when    string   // Condition of the trigger, empty if none.
+}
+
+// CreateTrigger returns the trigger created by a CREATE TRIGGER statement. Statement-level,
+// TRUNCATE, constraint triggers and triggers with arguments or transition tables are rejected.
+func CreateTrigger(sql string) (*Trigger, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetCreateTrigStmt() == nil {
+		return nil, fmt.Errorf("not a CREATE TRIGGER statement")
+	}
+	create := tree.Stmts[0].GetStmt().GetCreateTrigStmt()
+
+	switch {
+	case !create.GetRow():
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "statement-level triggers are not supported, use FOR EACH ROW")
+	case create.GetEvents()&triggerTruncate != 0:
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "TRUNCATE triggers are not supported")
+	case create.GetIsconstraint():
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "constraint triggers are not supported")
+	case len(create.GetTransitionRels()) > 0:
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "transition tables of triggers are not supported")
+	case len(create.GetArgs()) > 0:
+		return nil, pgerror.New(pgerror.FeatureNotSupported, "arguments of trigger functions are not supported")
+	}
+
+	t := &Trigger{
+		Name:     create.GetTrigname(),
+		Table:    create.GetRelation().GetRelname(),
+		Function: qualifiedName(create.GetFuncname()),
+		Replace:  create.GetReplace(),
+		timing:   "AFTER",
+	}
+	switch timing := create.GetTiming(); {
+	case timing&triggerBefore != 0:
+		t.timing = "BEFORE"
+	case timing&triggerInstead != 0:
+		t.timing = "INSTEAD OF"
+	}
+	for _, event := range []struct {
+		bit  int32
+		name string
+	}{{triggerInsert, "INSERT"}, {triggerUpdate, "UPDATE"}, {triggerDelete, "DELETE"}} {
+		if create.GetEvents()&event.bit != 0 {
+			t.events = append(t.events, event.name)
+		}
+	}
+	for _, column := range create.GetColumns() {
+		t.columns = append(t.columns, quoteIdentifier(column.GetString_().GetSval()))
+	}
+	if where := create.GetWhenClause(); where != nil {
+		if t.when, err = DeparseExpr(where); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// SQLiteNames returns the names of the SQLite triggers a trigger may be translated into:
+// triggers on a single event keep their name, others have a trigger per event suffixed
+// by the event.
+func (t *Trigger) SQLiteNames() []string {
+	return triggerNames(t.Name)
+}
+
+func triggerNames(name string) []string {
+	return []string{name, name + "_insert", name + "_update", name + "_delete"}
+}
+
+// SQLite returns the statements creating the SQLite triggers of a trigger executing the
+// statements of the body of its function.
+func (t *Trigger) SQLite(body string) (string, error) {
+	stmts, err := triggerStatements(body)
+	if err != nil {
+		return "", err
+	}
+
+	var triggers []string
+	for _, event := range t.events {
+		name := t.Name
+		if len(t.events) > 1 {
+			name += "_" + strings.ToLower(event)
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "CREATE TRIGGER %s %s %s", quoteIdentifier(name), t.timing, event)
+		if event == "UPDATE" && len(t.columns) > 0 {
+			fmt.Fprintf(&b, " OF %s", strings.Join(t.columns, ", "))
+		}
+		fmt.Fprintf(&b, " ON %s FOR EACH ROW", quoteIdentifier(t.Table))
+		if t.when != "" {
+			fmt.Fprintf(&b, " WHEN %s", t.when)
+		}
+		b.WriteString(" BEGIN ")
+		for _, stmt := range stmts {
+			fmt.Fprintf(&b, "%s; ", stmt)
+		}
+		b.WriteString("END")
+		triggers = append(triggers, b.String())
+	}
+	return strings.Join(triggers, "; "), nil
+}
+
+// DropTrigger returns the trigger and the table of a DROP TRIGGER statement, and whether
+// it is dropped IF EXISTS. Candidates are the names of the SQLite triggers it may have
+// been translated into.
+func DropTrigger(sql string) (name, table string, candidates []string, missingOK bool, err error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", "", nil, false, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetDropStmt().GetRemoveType() != pg_query.ObjectType_OBJECT_TRIGGER {
+		return "", "", nil, false, fmt.Errorf("not a DROP TRIGGER statement")
+	}
+	drop := tree.Stmts[0].GetStmt().GetDropStmt()
+	if len(drop.GetObjects()) != 1 {
+		return "", "", nil, false, fmt.Errorf("not a single trigger")
+	}
+	// Triggers are named by their table and their name, the name comes last.
+	names := drop.GetObjects()[0].GetList().GetItems()
+	if len(names) < 2 {
+		return "", "", nil, false, fmt.Errorf("trigger without table")
+	}
+	name = names[len(names)-1].GetString_().GetSval()
+	table = names[len(names)-2].GetString_().GetSval()
+	return name, table, triggerNames(name), drop.GetMissingOk(), nil
+}
+
+// DropFunctions returns the names of the functions or procedures of a DROP FUNCTION or
+// DROP PROCEDURE statement, and whether they are dropped IF EXISTS.
+func DropFunctions(sql string) (names []string, missingOK bool, err error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, false, parseError(err)
+	}
+	drop := tree.Stmts[0].GetStmt().GetDropStmt()
+	if len(tree.Stmts) != 1 || drop.GetRemoveType() != pg_query.ObjectType_OBJECT_FUNCTION && drop.GetRemoveType() != pg_query.ObjectType_OBJECT_PROCEDURE {
+		return nil, false, fmt.Errorf("not a DROP FUNCTION statement")
+	}
+	for _, object := range drop.GetObjects() {
+		names = append(names, qualifiedName(object.GetObjectWithArgs().GetObjname()))
+	}
+	return names, drop.GetMissingOk(), nil
+}
+
+// Returns the statements of the body of a trigger function translated for SQLite
+// triggers, whose statements can't qualify the tables they modify.
+func triggerStatements(body string) ([]string, error) {
+	tree, err := pg_query.Parse(body)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) == 0 {
+		return nil, pgerror.New(pgerror.InvalidFunctionDefinition, "trigger function has no statements")
+	}
+	var stmts []string
+	for _, raw := range tree.Stmts {
+		stmt := raw.GetStmt()
+		switch stmt.GetNode().(type) {
+		case *pg_query.Node_InsertStmt, *pg_query.Node_UpdateStmt, *pg_query.Node_DeleteStmt:
+			for _, rangevar := range actionRelations(stmt) {
+				if rangevar.GetSchemaname() == "public" || rangevar.GetSchemaname() == "main" {
+					rangevar.Schemaname = ""
+				}
+			}
+		case *pg_query.Node_SelectStmt:
+		default:
+			e := pgerror.New(pgerror.FeatureNotSupported, "statements of trigger functions other than INSERT, UPDATE, DELETE and SELECT are not supported")
+			e.Hint = triggerFunctionHint
+			return nil, e
+		}
+		text, err := Deparse(stmt)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, text)
+	}
+	return stmts, nil
+}
+
+// Returns the name of a function, without the public schema.
+func qualifiedName(names []*pg_query.Node) string {
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, name.GetString_().GetSval())
+	}
+	if len(parts) == 2 && parts[0] == "public" {
+		parts = parts[1:]
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,60 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Triggers", func() {
+
+	It("Translate triggers executing SQL functions", func() {
+		fn, err := parser.CreateFunction(`CREATE OR REPLACE FUNCTION public.log_change() RETURNS trigger LANGUAGE sql AS $$
+			INSERT INTO public.log (item, name) VALUES (NEW.id, NEW.name); DELETE FROM stale WHERE item = NEW.id $$`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fn.Name).To(Equal("log_change"))
+		Expect(fn.Kind).To(Equal(parser.TriggerKind))
+		Expect(fn.Replace).To(BeTrue())
+
+		trigger, err := parser.CreateTrigger(`CREATE TRIGGER log AFTER INSERT OR UPDATE OF name ON items
+			FOR EACH ROW WHEN (NEW.name IS NOT NULL) EXECUTE FUNCTION log_change()`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(trigger.Table).To(Equal("items"))
+		Expect(trigger.Function).To(Equal("log_change"))
+		text, err := trigger.SQLite(fn.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(text).To(Equal(`CREATE TRIGGER "log_insert" AFTER INSERT ON "items" FOR EACH ROW WHEN new.name IS NOT NULL BEGIN ` +
+			`INSERT INTO log (item, name) VALUES (new.id, new.name); DELETE FROM stale WHERE item = new.id; END; ` +
+			`CREATE TRIGGER "log_update" AFTER UPDATE OF "name" ON "items" FOR EACH ROW WHEN new.name IS NOT NULL BEGIN ` +
+			`INSERT INTO log (item, name) VALUES (new.id, new.name); DELETE FROM stale WHERE item = new.id; END`))
+	})
+
+	It("Reject PL/pgSQL trigger functions", func() {
+		_, err := parser.CreateFunction(`CREATE FUNCTION stamp() RETURNS trigger LANGUAGE plpgsql AS $$
+			BEGIN NEW.updated_at := now(); RETURN NEW; END $$`)
+		Expect(err).To(MatchError(ContainSubstring("PL/pgSQL is not supported")))
+
+		_, err = parser.CreateFunction(`CREATE FUNCTION purge() RETURNS trigger LANGUAGE sql AS 'TRUNCATE log'`)
+		Expect(err).To(MatchError(ContainSubstring("not supported")))
+	})
+
+	It("Reject statement-level triggers", func() {
+		_, err := parser.CreateTrigger(`CREATE TRIGGER log AFTER INSERT ON items FOR EACH STATEMENT EXECUTE FUNCTION log_change()`)
+		Expect(err).To(MatchError(ContainSubstring("statement-level triggers are not supported")))
+	})
+
+	It("Parse DROP TRIGGER and DROP FUNCTION", func() {
+		name, table, candidates, missingOK, err := parser.DropTrigger(`DROP TRIGGER IF EXISTS log ON public.items`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal("log"))
+		Expect(table).To(Equal("items"))
+		Expect(candidates).To(ContainElements("log", "log_insert"))
+		Expect(missingOK).To(BeTrue())
+
+		names, missingOK, err := parser.DropFunctions(`DROP FUNCTION log_change(), public.other`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"log_change", "other"}))
+		Expect(missingOK).To(BeFalse())
+	})
+})
@@ -0,0 +1,318 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// UpdatableView is a view PostgreSQL updates automatically, its rows are the
+// rows of a single table and its columns are columns of the table.
+type UpdatableView struct {
+	Name  string
+	Table string
+
+	// Table column of each column of the view, "*" stands for all the columns
+	// of the table and empty for expressions, which can't be updated.
+	Columns []string
+
+	// Name of each column of the view, empty for the name of its table column.
+	Names []string
+
+	// Column list of the view, its names replace the names of the columns.
+	Aliases []string
+}
+
+// TableColumn is a column of the table of an updatable view.
+type TableColumn struct {
+	Name    string
+	Default string // Default value expression, empty if none.
+	Key     bool   // Part of the primary key.
+}
+
+// CreateView translates a CREATE VIEW statement for SQLite, which doesn't replace views,
+// so replaced views are dropped first. The returned view is nil unless PostgreSQL
+// would update the view automatically.
+func CreateView(sql string) (string, *UpdatableView, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].GetStmt().GetViewStmt() == nil {
+		return "", nil, fmt.Errorf("not a CREATE VIEW statement")
+	}
+	stmt := tree.Stmts[0].GetStmt().GetViewStmt()
+	if stmt.GetWithCheckOption() != pg_query.ViewCheckOption_NO_CHECK_OPTION {
+		return "", nil, pgerror.New(pgerror.FeatureNotSupported, "WITH CHECK OPTION is not supported")
+	}
+
+	text := sql
+	if stmt.GetReplace() {
+		stmt.Replace = false
+		if text, err = pg_query.Deparse(tree); err != nil {
+			return "", nil, err
+		}
+		text = fmt.Sprintf("DROP VIEW IF EXISTS %s; %s", quoteIdentifier(stmt.GetView().GetRelname()), text)
+	}
+
+	view := &UpdatableView{Name: stmt.GetView().GetRelname()}
+	for _, alias := range stmt.GetAliases() {
+		view.Aliases = append(view.Aliases, alias.GetString_().GetSval())
+	}
+
+	// Views of a single table, without grouping, distinct rows or limits, are updatable.
+	sel := stmt.GetQuery().GetSelectStmt()
+	if sel == nil || sel.GetOp() != pg_query.SetOperation_SETOP_NONE || len(sel.GetFromClause()) != 1 ||
+		sel.GetWithClause() != nil || len(sel.GetDistinctClause()) != 0 || len(sel.GetGroupClause()) != 0 ||
+		sel.GetHavingClause() != nil || sel.GetLimitCount() != nil || sel.GetLimitOffset() != nil ||
+		len(sel.GetWindowClause()) != 0 {
+		return text, nil, nil
+	}
+	rangevar := sel.GetFromClause()[0].GetRangeVar()
+	if rangevar == nil || (rangevar.GetSchemaname() != "" && rangevar.GetSchemaname() != "public" && rangevar.GetSchemaname() != "main") {
+		return text, nil, nil
+	}
+	view.Table = rangevar.GetRelname()
+
+	qualifier := view.Table
+	if alias := rangevar.GetAlias(); alias != nil {
+		qualifier = alias.GetAliasname()
+	}
+	for _, target := range sel.GetTargetList() {
+		res := target.GetResTarget()
+		var column string
+		if ref := res.GetVal().GetColumnRef(); ref != nil {
+			fields := ref.GetFields()
+			if len(fields) == 2 && fields[0].GetString_().GetSval() == qualifier {
+				fields = fields[1:]
+			}
+			if len(fields) == 1 {
+				if fields[0].GetAStar() != nil {
+					column = "*"
+				} else {
+					column = fields[0].GetString_().GetSval()
+				}
+			}
+		} else if containsFuncCall(res.GetVal()) {
+			// Functions may be aggregates or window functions, which make views read-only.
+			return text, nil, nil
+		}
+		view.Columns = append(view.Columns, column)
+		view.Names = append(view.Names, res.GetName())
+	}
+	return text, view, nil
+}
+
+// Finds function calls in a statement tree.
+type funcCallWalker struct {
+	found bool
+}
+
+func (walker *funcCallWalker) Visit(node *pg_query.Node) (v Visitor, err error) {
+	if node.GetFuncCall() != nil {
+		walker.found = true
+	}
+	return walker, nil
+}
+
+func (walker *funcCallWalker) VisitEnd(node *pg_query.Node) error { return nil }
+
+// Reports whether an expression calls functions.
+func containsFuncCall(node *pg_query.Node) bool {
+	walker := &funcCallWalker{}
+	Walk(walker, node)
+	return walker.found
+}
+
+// ViewTriggerName returns the name of the trigger updating a view on an event,
+// "INSERT", "UPDATE" or "DELETE".
+func ViewTriggerName(view, event string) string {
+	return "kqlite_view_" + view + "_" + strings.ToLower(event)
+}
+
+// ViewTriggers returns the INSTEAD OF triggers applying inserts, updates and deletes
+// of the view to its table, with the given columns. Rows of the table are identified
+// by their primary key if the view has all its columns, by all the columns of the
+// view otherwise. Columns missing from inserts take the default of their table column.
+func ViewTriggers(view *UpdatableView, columns []TableColumn) []string {
+	type viewColumn struct {
+		name string
+		TableColumn
+	}
+	byName := make(map[string]TableColumn)
+	for _, column := range columns {
+		byName[column.Name] = column
+	}
+	var viewColumns []viewColumn
+	var position int
+	for i, name := range view.Columns {
+		var targets []TableColumn
+		if name == "*" {
+			targets = columns
+		} else if column, ok := byName[name]; ok {
+			targets = []TableColumn{column}
+		} else {
+			// Expressions take a position but can't be updated.
+			position++
+			continue
+		}
+		for _, column := range targets {
+			vc := viewColumn{name: column.Name, TableColumn: column}
+			if view.Names[i] != "" && name != "*" {
+				vc.name = view.Names[i]
+			}
+			if position < len(view.Aliases) {
+				vc.name = view.Aliases[position]
+			}
+			viewColumns = append(viewColumns, vc)
+			position++
+		}
+	}
+	if len(viewColumns) == 0 {
+		return nil
+	}
+
+	var keys []viewColumn
+	tableKeys := 0
+	for _, column := range columns {
+		if column.Key {
+			tableKeys++
+		}
+	}
+	for _, column := range viewColumns {
+		if column.Key {
+			keys = append(keys, column)
+		}
+	}
+	if tableKeys == 0 || len(keys) != tableKeys {
+		keys = viewColumns
+	}
+	var match []string
+	for _, key := range keys {
+		match = append(match, fmt.Sprintf("%s IS OLD.%s", quoteIdentifier(key.Name), quoteIdentifier(key.name)))
+	}
+
+	var names, values, sets []string
+	for _, column := range viewColumns {
+		value := "NEW." + quoteIdentifier(column.name)
+		names = append(names, quoteIdentifier(column.Name))
+		sets = append(sets, fmt.Sprintf("%s = %s", quoteIdentifier(column.Name), value))
+		if column.Default != "" {
+			value = fmt.Sprintf("coalesce(%s, %s)", value, column.Default)
+		}
+		values = append(values, value)
+	}
+
+	trigger := func(event, body string) string {
+		return fmt.Sprintf("CREATE TRIGGER %s INSTEAD OF %s ON %s FOR EACH ROW BEGIN %s; END",
+			quoteIdentifier(ViewTriggerName(view.Name, event)), event, quoteIdentifier(view.Name), body)
+	}
+	table := quoteIdentifier(view.Table)
+	where := strings.Join(match, " AND ")
+	return []string{
+		trigger("INSERT", fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), strings.Join(values, ", "))),
+		trigger("UPDATE", fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(sets, ", "), where)),
+		trigger("DELETE", fmt.Sprintf("DELETE FROM %s WHERE %s", table, where)),
+	}
+}
+
+// MaterializedView is a materialized view created by CREATE MATERIALIZED VIEW
+// or refreshed by REFRESH MATERIALIZED VIEW.
+type MaterializedView struct {
+	Name        string
+	Query       string // Query of the view, set on creation only.
+	WithData    bool   // Rows are computed, otherwise the view is left unpopulated.
+	IfNotExists bool
+	Concurrent  bool // Refreshed without locking out reads of the view.
+}
+
+// MaterializedViewStmt returns the materialized view of a CREATE MATERIALIZED VIEW
+// or REFRESH MATERIALIZED VIEW statement. Column names of the view are set by
+// the query, as a common table expression if listed by the statement.
+func MaterializedViewStmt(sql string) (*MaterializedView, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if len(tree.Stmts) != 1 {
+		return nil, fmt.Errorf("not a single statement")
+	}
+
+	stmt := tree.Stmts[0].GetStmt()
+	if refresh := stmt.GetRefreshMatViewStmt(); refresh != nil {
+		if refresh.GetConcurrent() && refresh.GetSkipData() {
+			return nil, pgerror.New(pgerror.SyntaxError, "REFRESH options CONCURRENTLY and WITH NO DATA cannot be used together")
+		}
+		return &MaterializedView{
+			Name:       refresh.GetRelation().GetRelname(),
+			WithData:   !refresh.GetSkipData(),
+			Concurrent: refresh.GetConcurrent(),
+		}, nil
+	}
+
+	create := stmt.GetCreateTableAsStmt()
+	if create.GetObjtype() != pg_query.ObjectType_OBJECT_MATVIEW {
+		return nil, fmt.Errorf("not a CREATE or REFRESH MATERIALIZED VIEW statement")
+	}
+	query, err := Deparse(create.GetQuery())
+	if err != nil {
+		return nil, err
+	}
+	into := create.GetInto()
+	if columns := into.GetColNames(); len(columns) > 0 {
+		names := make([]string, len(columns))
+		for i, column := range columns {
+			names[i] = quoteIdentifier(column.GetString_().GetSval())
+		}
+		query = fmt.Sprintf("WITH q (%s) AS (%s) SELECT * FROM q", strings.Join(names, ", "), query)
+	}
+	return &MaterializedView{
+		Name:        into.GetRel().GetRelname(),
+		Query:       query,
+		WithData:    !into.GetSkipData(),
+		IfNotExists: create.GetIfNotExists(),
+	}, nil
+}
+
+// ViewRowsQuery returns the query counting the rows an UPDATE or DELETE statement
+// matches in its target relation, false for other statements. Changes made through
+// the INSTEAD OF triggers of views aren't counted by SQLite, the rows of the view
+// are counted instead.
+func ViewRowsQuery(sql string) (string, bool, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return "", false, err
+	}
+	if len(tree.Stmts) != 1 {
+		return "", false, nil
+	}
+	var from []*pg_query.Node
+	var where *pg_query.Node
+	switch n := tree.Stmts[0].GetStmt().GetNode().(type) {
+	case *pg_query.Node_UpdateStmt:
+		from = append([]*pg_query.Node{{Node: &pg_query.Node_RangeVar{RangeVar: n.UpdateStmt.GetRelation()}}}, n.UpdateStmt.GetFromClause()...)
+		where = n.UpdateStmt.GetWhereClause()
+	case *pg_query.Node_DeleteStmt:
+		from = append([]*pg_query.Node{{Node: &pg_query.Node_RangeVar{RangeVar: n.DeleteStmt.GetRelation()}}}, n.DeleteStmt.GetUsingClause()...)
+		where = n.DeleteStmt.GetWhereClause()
+	default:
+		return "", false, nil
+	}
+	if where.GetCurrentOfExpr() != nil {
+		return "", false, nil
+	}
+	count := &pg_query.Node{Node: &pg_query.Node_FuncCall{FuncCall: &pg_query.FuncCall{
+		Funcname: []*pg_query.Node{pg_query.MakeStrNode("count")},
+		AggStar:  true,
+		Location: -1,
+	}}}
+	text, err := Deparse(&pg_query.Node{Node: &pg_query.Node_SelectStmt{SelectStmt: &pg_query.SelectStmt{
+		TargetList:  []*pg_query.Node{pg_query.MakeResTargetNodeWithVal(count, -1)},
+		FromClause:  from,
+		WhereClause: where,
+	}}})
+	return text, err == nil, err
+}
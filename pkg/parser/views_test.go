@@ -0,0 +1,83 @@
+package parser_test
+
+import (
+	"github.com/kqlite/kqlite/pkg/parser"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Views", func() {
+
+	columns := []parser.TableColumn{
+		{Name: "id", Key: true},
+		{Name: "name"},
+		{Name: "status", Default: "'new'"},
+	}
+
+	It("Generate triggers of simple views", func() {
+		text, view, err := parser.CreateView(`CREATE VIEW open_items AS SELECT id, name AS title, status FROM public.items AS i WHERE i.status <> 'closed'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(text).To(HavePrefix("CREATE VIEW open_items"))
+		Expect(view).To(Equal(&parser.UpdatableView{Name: "open_items", Table: "items",
+			Columns: []string{"id", "name", "status"}, Names: []string{"", "title", ""}}))
+
+		Expect(parser.ViewTriggers(view, columns)).To(Equal([]string{
+			`CREATE TRIGGER "kqlite_view_open_items_insert" INSTEAD OF INSERT ON "open_items" FOR EACH ROW BEGIN ` +
+				`INSERT INTO "items" ("id", "name", "status") VALUES (NEW."id", NEW."title", coalesce(NEW."status", 'new')); END`,
+			`CREATE TRIGGER "kqlite_view_open_items_update" INSTEAD OF UPDATE ON "open_items" FOR EACH ROW BEGIN ` +
+				`UPDATE "items" SET "id" = NEW."id", "name" = NEW."title", "status" = NEW."status" WHERE "id" IS OLD."id"; END`,
+			`CREATE TRIGGER "kqlite_view_open_items_delete" INSTEAD OF DELETE ON "open_items" FOR EACH ROW BEGIN ` +
+				`DELETE FROM "items" WHERE "id" IS OLD."id"; END`,
+		}))
+	})
+
+	It("Identify rows by all columns of views without the primary key", func() {
+		_, view, err := parser.CreateView(`CREATE VIEW names (n) AS SELECT name, upper FROM items`)
+		Expect(err).NotTo(HaveOccurred())
+		triggers := parser.ViewTriggers(view, columns)
+		Expect(triggers[2]).To(HaveSuffix(`DELETE FROM "items" WHERE "name" IS OLD."n"; END`))
+
+		_, view, err = parser.CreateView(`CREATE VIEW all_items AS SELECT * FROM items`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parser.ViewTriggers(view, columns)[2]).To(HaveSuffix(`WHERE "id" IS OLD."id"; END`))
+	})
+
+	It("Replace views", func() {
+		text, _, err := parser.CreateView(`CREATE OR REPLACE VIEW v AS SELECT id FROM items`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(text).To(Equal(`DROP VIEW IF EXISTS "v"; CREATE VIEW v AS SELECT id FROM items`))
+	})
+
+	It("Leave other views read-only", func() {
+		for _, sql := range []string{
+			`CREATE VIEW v AS SELECT DISTINCT name FROM items`,
+			`CREATE VIEW v AS SELECT status, count(*) FROM items GROUP BY status`,
+			`CREATE VIEW v AS SELECT max(id) FROM items`,
+			`CREATE VIEW v AS SELECT i.id FROM items i JOIN tags t ON t.item = i.id`,
+			`CREATE VIEW v AS SELECT id FROM items LIMIT 10`,
+			`CREATE VIEW v AS SELECT id FROM items UNION SELECT id FROM others`,
+		} {
+			_, view, err := parser.CreateView(sql)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(view).To(BeNil(), sql)
+		}
+
+		_, _, err := parser.CreateView(`CREATE VIEW v AS SELECT id FROM items WHERE id > 0 WITH CHECK OPTION`)
+		Expect(err).To(MatchError(ContainSubstring("not supported")))
+	})
+
+	It("Parse materialized view statements", func() {
+		view, err := parser.MaterializedViewStmt(`CREATE MATERIALIZED VIEW IF NOT EXISTS totals (name, total) AS SELECT name, sum(qty) FROM items GROUP BY name WITH NO DATA`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view).To(Equal(&parser.MaterializedView{Name: "totals", IfNotExists: true,
+			Query: `WITH q ("name", "total") AS (SELECT name, sum(qty) FROM items GROUP BY name) SELECT * FROM q`}))
+
+		view, err = parser.MaterializedViewStmt(`REFRESH MATERIALIZED VIEW CONCURRENTLY totals`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(view).To(Equal(&parser.MaterializedView{Name: "totals", WithData: true, Concurrent: true}))
+
+		_, err = parser.MaterializedViewStmt(`REFRESH MATERIALIZED VIEW CONCURRENTLY totals WITH NO DATA`)
+		Expect(err).To(MatchError(ContainSubstring("cannot be used together")))
+	})
+})
@@ -0,0 +1,267 @@
+// Package proxy implements a kqlite node without local storage, it accepts
+// Postgres clients and relays their sessions to an upstream kqlite server.
+// The proxy only terminates the startup of sessions, then it's a plain one to one
+// relay: statements aren't classified and all sessions go to the same upstream
+// server, there are no replicas to route them to. Session state such as advisory
+// locks is held by the upstream server and shared by the sessions of all its proxies.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+type Proxy struct {
+	mu    sync.Mutex
+	ln    net.Listener
+	conns map[net.Conn]struct{} // Client connections.
+
+	g      errgroup.Group
+	ctx    context.Context
+	cancel func()
+
+	connsTotal    atomic.Int64 // Number of accepted connections.
+	connsRejected atomic.Int64 // Number of connections rejected at startup.
+
+	// Bind address to listen to Postgres wire protocol.
+	Addr string
+
+	// Address of the kqlite server sessions are relayed to.
+	Upstream string
+
+	// Databases clients may connect to, all databases if empty.
+	Databases []string
+
+	// Maximum number of client connections, 0 for no limit.
+	MaxConns int
+
+	// Timeout of connections to the upstream server, 0 for no timeout.
+	DialTimeout time.Duration
+}
+
+func NewProxy() *Proxy {
+	p := &Proxy{conns: make(map[net.Conn]struct{})}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p
+}
+
+func (p *Proxy) Open() (err error) {
+	if p.Upstream == "" {
+		return fmt.Errorf("upstream address required")
+	}
+	if p.ln, err = net.Listen("tcp", p.Addr); err != nil {
+		return err
+	}
+	p.g.Go(func() error {
+		if err := p.serve(); p.ctx.Err() == nil {
+			return err // return error unless context canceled
+		}
+		return nil
+	})
+	return nil
+}
+
+// Close stops accepting clients and closes client connections along with their upstream sessions.
+func (p *Proxy) Close() (err error) {
+	p.cancel()
+	if p.ln != nil {
+		if e := p.ln.Close(); err == nil {
+			err = e
+		}
+	}
+
+	p.mu.Lock()
+	for conn := range p.conns {
+		if e := conn.Close(); err == nil {
+			err = e
+		}
+	}
+	p.mu.Unlock()
+
+	if e := p.g.Wait(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// ListenerAddr returns the address the proxy listens to, nil if not open.
+func (p *Proxy) ListenerAddr() net.Addr {
+	if p.ln == nil {
+		return nil
+	}
+	return p.ln.Addr()
+}
+
+// Stats returns the number of active, accepted and rejected client connections.
+func (p *Proxy) Stats() (active, total, rejected int64) {
+	p.mu.Lock()
+	active = int64(len(p.conns))
+	p.mu.Unlock()
+	return active, p.connsTotal.Load(), p.connsRejected.Load()
+}
+
+func (p *Proxy) serve() error {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		p.mu.Lock()
+		p.conns[conn] = struct{}{}
+		p.mu.Unlock()
+		p.connsTotal.Add(1)
+
+		p.g.Go(func() error {
+			defer p.closeConn(conn)
+
+			if err := p.serveConn(conn); err != nil && p.ctx.Err() == nil {
+				log.Printf("proxy connection error, closing: %s", err)
+			}
+			return nil
+		})
+	}
+}
+
+func (p *Proxy) closeConn(conn net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+	conn.Close()
+}
+
+// Terminates the startup of a client session, then relays it to the upstream server.
+func (p *Proxy) serveConn(conn net.Conn) error {
+	backend := pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn)
+	msg, err := p.receiveStartupMessage(conn, backend)
+	if err != nil || msg == nil {
+		return err
+	}
+
+	name := msg.Parameters["database"]
+	if e := p.checkStartup(name); e != nil {
+		p.connsRejected.Add(1)
+		log.Printf("proxy rejected %s: %s", conn.RemoteAddr(), e.Message)
+		buf, _ := e.Response().Encode(nil)
+		_, err := conn.Write(buf)
+		return err
+	}
+
+	dialer := net.Dialer{Timeout: p.DialTimeout}
+	upstream, err := dialer.DialContext(p.ctx, "tcp", p.Upstream)
+	if err != nil {
+		buf, _ := pgerror.Fatal(pgerror.ConnectionFailure, fmt.Sprintf("upstream: %s", err)).Response().Encode(nil)
+		conn.Write(buf)
+		return fmt.Errorf("upstream: %w", err)
+	}
+	defer upstream.Close()
+
+	// The client waits for the authentication response, nothing follows the startup message yet.
+	buf, _ := msg.Encode(nil)
+	if _, err := upstream.Write(buf); err != nil {
+		return fmt.Errorf("upstream startup: %w", err)
+	}
+
+	log.Printf("proxy session started: %s -> %s/%s", conn.RemoteAddr(), p.Upstream, name)
+	start := time.Now()
+	sent, received, err := relay(conn, upstream)
+	log.Printf("proxy session ended: %s, %d bytes sent, %d bytes received in %s",
+		conn.RemoteAddr(), sent, received, time.Since(start).Round(time.Millisecond))
+	return err
+}
+
+// Receives the startup message of a client, SSL requests are declined. Cancel requests
+// are forwarded to the upstream server, nil is returned then.
+func (p *Proxy) receiveStartupMessage(conn net.Conn, backend *pgproto3.Backend) (*pgproto3.StartupMessage, error) {
+	for {
+		msg, err := backend.ReceiveStartupMessage()
+		if err != nil {
+			return nil, fmt.Errorf("receive startup message: %w", err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.StartupMessage:
+			return msg, nil
+		case *pgproto3.SSLRequest:
+			if _, err := conn.Write([]byte("N")); err != nil {
+				return nil, err
+			}
+		case *pgproto3.CancelRequest:
+			return nil, p.forwardCancelRequest(msg)
+		default:
+			return nil, fmt.Errorf("unexpected startup message: %#v", msg)
+		}
+	}
+}
+
+// Forwards a cancel request on a connection of its own to the upstream server, which
+// cancels the query of the session with the key it sent the client on startup.
+func (p *Proxy) forwardCancelRequest(msg *pgproto3.CancelRequest) error {
+	dialer := net.Dialer{Timeout: p.DialTimeout}
+	upstream, err := dialer.DialContext(p.ctx, "tcp", p.Upstream)
+	if err != nil {
+		return fmt.Errorf("upstream cancel request: %w", err)
+	}
+	defer upstream.Close()
+
+	buf, _ := msg.Encode(nil)
+	if _, err := upstream.Write(buf); err != nil {
+		return fmt.Errorf("upstream cancel request: %w", err)
+	}
+	return nil
+}
+
+// Returns the error the client is rejected with, nil if the session may start.
+func (p *Proxy) checkStartup(name string) *pgerror.Error {
+	if name == "" {
+		return pgerror.Fatal(pgerror.ProtocolViolation, "database required")
+	} else if strings.Contains(name, "..") {
+		return pgerror.Fatal(pgerror.InvalidCatalogName, "invalid database name")
+	} else if len(p.Databases) > 0 && !slices.Contains(p.Databases, name) {
+		return pgerror.Fatal(pgerror.InvalidAuthorization, fmt.Sprintf("database %q is not available on this proxy", name))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.MaxConns > 0 && len(p.conns) > p.MaxConns {
+		return pgerror.Fatal(pgerror.TooManyConnections, "too many connections")
+	}
+	return nil
+}
+
+// Copies traffic between the client and the upstream server until either side closes,
+// the messages relayed aren't read.
+func relay(client, upstream net.Conn) (sent, received int64, err error) {
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		received, err = io.Copy(client, upstream)
+		client.Close()
+		done <- err
+	}()
+
+	sent, err = io.Copy(upstream, client)
+	upstream.Close()
+	if e := <-done; err == nil {
+		err = e
+	}
+	// Either side closing ends the relay, closing the other connection.
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return sent, received, err
+}
@@ -0,0 +1,144 @@
+package proxy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/proxy"
+)
+
+var _ = Describe("Proxy", func() {
+	ctx := context.Background()
+
+	connect := func(dbname string) (*pgx.Conn, error) {
+		return pgx.Connect(ctx, fmt.Sprintf("postgres://%s/%s?sslmode=disable", prx.ListenerAddr(), dbname))
+	}
+
+	It("Relays sessions to the upstream server", func() {
+		conn, err := connect("proxied.db")
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(ctx)
+
+		_, err = conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO items (name) VALUES ($1)`, "relayed")
+		Expect(err).NotTo(HaveOccurred())
+
+		var name string
+		Expect(conn.QueryRow(ctx, `SELECT name FROM items WHERE id = $1`, 1).Scan(&name)).To(Succeed())
+		Expect(name).To(Equal("relayed"))
+
+		// The database is stored by the upstream server.
+		var database string
+		Expect(conn.QueryRow(ctx, `SELECT name FROM kqlite.databases WHERE name = 'proxied.db'`).Scan(&database)).To(Succeed())
+		Expect(database).To(Equal("proxied.db"))
+	})
+
+	It("Shares the advisory locks of sessions relayed by other proxies", func() {
+		other := proxy.NewProxy()
+		other.Addr = "127.0.0.1:0"
+		other.Upstream = upstream.ListenerAddr().String()
+		Expect(other.Open()).To(Succeed())
+		defer other.Close()
+
+		conn, err := connect("proxied.db")
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(ctx)
+		remote, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/proxied.db?sslmode=disable", other.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		defer remote.Close(ctx)
+
+		_, err = conn.Exec(ctx, `SELECT pg_advisory_lock(1001)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Locks are held by the upstream server, whichever proxy relays the sessions.
+		var locked string
+		Expect(remote.QueryRow(ctx, `SELECT pg_try_advisory_lock(1001)`).Scan(&locked)).To(Succeed())
+		Expect(locked).To(Equal("f"))
+
+		_, err = conn.Exec(ctx, `SELECT pg_advisory_unlock(1001)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remote.QueryRow(ctx, `SELECT pg_try_advisory_lock(1001)`).Scan(&locked)).To(Succeed())
+		Expect(locked).To(Equal("t"))
+	})
+
+	It("Forwards cancel requests to the upstream server", func() {
+		conn, err := connect("proxied.db")
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(ctx)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.Exec(ctx, `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT count(*) FROM n`)
+			done <- err
+		}()
+		Consistently(done, 200*time.Millisecond).ShouldNot(Receive())
+
+		// The cancel request is sent to the proxy, on a connection of its own.
+		Expect(conn.PgConn().CancelRequest(ctx)).To(Succeed())
+		Eventually(done, 5*time.Second).Should(Receive(&err))
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("57014"))
+
+		// The session goes on.
+		_, err = conn.Exec(ctx, `SELECT 1`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Rejects databases that aren't allowed", func() {
+		_, err := connect("other.db")
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Severity).To(Equal("FATAL"))
+		Expect(pgErr.Code).To(Equal("28000"))
+	})
+
+	It("Limits the number of client connections", func() {
+		var conns []*pgx.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close(ctx)
+			}
+		}()
+		// Connections of previous specs are released asynchronously.
+		Eventually(func() int64 {
+			active, _, _ := prx.Stats()
+			return active
+		}).Should(BeZero())
+
+		for range prx.MaxConns {
+			conn, err := connect("limited.db")
+			Expect(err).NotTo(HaveOccurred())
+			conns = append(conns, conn)
+		}
+
+		_, err := connect("limited.db")
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("53300"))
+
+		_, _, rejected := prx.Stats()
+		Expect(rejected).To(BeNumerically(">=", 1))
+	})
+
+	It("Reports unreachable upstream servers", func() {
+		unreachable := proxy.NewProxy()
+		unreachable.Addr = "127.0.0.1:0"
+		unreachable.Upstream = "127.0.0.1:1"
+		Expect(unreachable.Open()).To(Succeed())
+		defer unreachable.Close()
+
+		_, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/proxied.db?sslmode=disable", unreachable.ListenerAddr()))
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("08006"))
+	})
+})
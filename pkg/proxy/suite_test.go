@@ -0,0 +1,40 @@
+package proxy_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/proxy"
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var (
+	upstream *server.Server
+	prx      *proxy.Proxy
+)
+
+func TestProxy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Proxy Suite")
+}
+
+var _ = BeforeSuite(func() {
+	upstream = server.NewServer()
+	upstream.Addr = "127.0.0.1:0"
+	upstream.DataDir = GinkgoT().TempDir()
+	Expect(upstream.Open()).To(Succeed())
+
+	prx = proxy.NewProxy()
+	prx.Addr = "127.0.0.1:0"
+	prx.Upstream = upstream.ListenerAddr().String()
+	prx.Databases = []string{"proxied.db", "limited.db"}
+	prx.MaxConns = 2
+	Expect(prx.Open()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	Expect(prx.Close()).To(Succeed())
+	Expect(upstream.Close()).To(Succeed())
+})
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sysdb"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Resource usage of a database, and the statements its rate limit allows.
+type databaseAccount struct {
+	statements  int64
+	rowsRead    int64
+	rowsWritten int64
+	execTime    time.Duration
+	rateLimited int64
+
+	tokens   float64   // Statements allowed before the rate limit applies, up to one second of them.
+	refilled time.Time // Last time tokens were added.
+}
+
+// accounting tracks the resource usage of databases since the server started. Sessions
+// count the rows they read and write, they're added to the usage of their database after
+// each statement.
+type accounting struct {
+	mu           sync.Mutex
+	databases    map[string]*databaseAccount // By database name.
+	applications map[string]*databaseAccount // By application_name of the sessions, without rate limits.
+}
+
+func newAccounting() *accounting {
+	return &accounting{
+		databases:    make(map[string]*databaseAccount),
+		applications: make(map[string]*databaseAccount),
+	}
+}
+
+// Returns the account of a database, a.mu must be held.
+func (a *accounting) account(name string) *databaseAccount {
+	account, ok := a.databases[name]
+	if !ok {
+		account = &databaseAccount{}
+		a.databases[name] = account
+	}
+	return account
+}
+
+// Adds statements of a session that executed for elapsed to the usage of its database
+// and of its application, along with the rows it read and wrote since its previous
+// statements.
+func (a *accounting) record(c *Conn, statements int64, elapsed time.Duration) {
+	if c.name == "" {
+		return
+	}
+	app := c.application()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rowsRead, rowsWritten := c.rowsRead.Swap(0), c.rowsWritten.Swap(0)
+	appAccount, ok := a.applications[app]
+	if !ok {
+		appAccount = &databaseAccount{}
+		a.applications[app] = appAccount
+	}
+	for _, account := range []*databaseAccount{a.account(c.name), appAccount} {
+		account.statements += statements
+		account.rowsRead += rowsRead
+		account.rowsWritten += rowsWritten
+		account.execTime += elapsed
+	}
+}
+
+// Reports whether the rate limit of a database allows a statement, with a token bucket
+// refilled at limit tokens per second, holding up to a second of tokens.
+func (a *accounting) allow(name string, limit float64, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	account := a.account(name)
+	burst := max(limit, 1)
+	if account.refilled.IsZero() {
+		account.tokens = burst
+	} else {
+		account.tokens = min(burst, account.tokens+now.Sub(account.refilled).Seconds()*limit)
+	}
+	account.refilled = now
+	if account.tokens < 1 {
+		account.rateLimited++
+		return false
+	}
+	account.tokens--
+	return true
+}
+
+// Returns the usage of the databases by name.
+func (a *accounting) snapshot() map[string]databaseAccount {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	usage := make(map[string]databaseAccount, len(a.databases))
+	for name, account := range a.databases {
+		usage[name] = *account
+	}
+	return usage
+}
+
+// Returns the resource usage of the applications of sessions, by application name.
+func (a *accounting) applicationUsage() []sysdb.ApplicationUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	usage := make([]sysdb.ApplicationUsage, 0, len(a.applications))
+	for name, account := range a.applications {
+		usage = append(usage, sysdb.ApplicationUsage{
+			ApplicationName: name,
+			Statements:      account.statements,
+			RowsRead:        account.rowsRead,
+			RowsWritten:     account.rowsWritten,
+			ExecTime:        account.execTime,
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].ApplicationName < usage[j].ApplicationName })
+	return usage
+}
+
+// Returns the rate limit of a database in statements per second, 0 for no limit.
+func (s *Server) rateLimit(name string) float64 {
+	if limit, ok := s.DatabaseRateLimits[name]; ok {
+		return limit
+	}
+	return s.DefaultDatabaseRateLimit
+}
+
+// Checks the rate limit of the connection database before a statement. Statements ending
+// transactions are never limited, sessions can always end them.
+func (s *Server) checkRateLimit(c *Conn, stmt parser.StmtClass) error {
+	limit := s.rateLimit(c.name)
+	if limit <= 0 {
+		return nil
+	}
+	switch stmt.Command {
+	case parser.CmdCommit, parser.CmdRollback:
+		return nil
+	}
+	if s.accounts.allow(c.name, limit, time.Now()) {
+		return nil
+	}
+	s.rateLimited.Add(1)
+	e := pgerror.Newf(pgerror.InsufficientResources, "database %s is over its rate limit of %g statements per second", c.name, limit)
+	e.Hint = "Retry the statement later."
+	return e
+}
+
+// Returns the resource usage of the databases accounted for and of the databases of the
+// data directory.
+func (s *Server) databaseUsage(databases []sysdb.Database) []sysdb.DatabaseUsage {
+	accounts := s.accounts.snapshot()
+	sizes := make(map[string]int64)
+	for _, d := range databases {
+		sizes[d.Name] = d.SizeBytes + d.WALSizeBytes
+		if _, ok := accounts[d.Name]; !ok {
+			accounts[d.Name] = databaseAccount{}
+		}
+	}
+
+	usage := make([]sysdb.DatabaseUsage, 0, len(accounts))
+	for name, account := range accounts {
+		usage = append(usage, sysdb.DatabaseUsage{
+			Database:    name,
+			Statements:  account.statements,
+			RowsRead:    account.rowsRead,
+			RowsWritten: account.rowsWritten,
+			ExecTime:    account.execTime,
+			RateLimited: account.rateLimited,
+			RateLimit:   max(s.rateLimit(name), 0),
+			SizeBytes:   sizes[name],
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Database < usage[j].Database })
+	return usage
+}
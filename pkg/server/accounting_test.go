@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Database accounting", Ordered, func() {
+	var accounted *server.Server
+	ctx := context.Background()
+
+	// Usage of a database, as name:value pairs of kqlite.stat_databases columns.
+	usage := func(conn *pgx.Conn, database string) map[string]int64 {
+		var statements, rowsRead, rowsWritten, rateLimited, sizeBytes int64
+		var execTime, rateLimit float64
+		Expect(conn.QueryRow(ctx, `SELECT statements, rows_read, rows_written, exec_time, rate_limited, rate_limit, size_bytes
+			FROM kqlite.stat_databases WHERE database = $1`, pgx.QueryExecModeSimpleProtocol, database).Scan(
+			&statements, &rowsRead, &rowsWritten, &execTime, &rateLimited, &rateLimit, &sizeBytes)).To(Succeed())
+		Expect(execTime).To(BeNumerically(">", 0))
+		return map[string]int64{
+			"statements":   statements,
+			"rows_read":    rowsRead,
+			"rows_written": rowsWritten,
+			"rate_limited": rateLimited,
+			"rate_limit":   int64(rateLimit),
+			"size_bytes":   sizeBytes,
+		}
+	}
+
+	BeforeAll(func() {
+		accounted = openServer(func(s *server.Server) {
+			s.DatabaseRateLimits = map[string]float64{"limited.db": 2}
+		})
+	})
+
+	It("Accounts for the statements and rows of databases", func() {
+		conn := connectTo(ctx, accounted, "tenant.db")
+		defer conn.Close(ctx)
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);
+			INSERT INTO items (name) VALUES ('a'), ('b'), ('c')`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `UPDATE items SET name = 'd' WHERE id = $1`, 1)
+		Expect(err).NotTo(HaveOccurred())
+		rows, err := conn.Query(ctx, `SELECT name FROM items`)
+		Expect(err).NotTo(HaveOccurred())
+		names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(HaveLen(3))
+
+		u := usage(conn, "tenant.db")
+		Expect(u["statements"]).To(Equal(int64(4)))
+		Expect(u["rows_read"]).To(Equal(int64(3)))
+		Expect(u["rows_written"]).To(Equal(int64(4)))
+		Expect(u["rate_limited"]).To(BeZero())
+		Expect(u["rate_limit"]).To(BeZero())
+		Expect(u["size_bytes"]).To(BeNumerically(">", 0))
+	})
+
+	It("Rejects statements over the rate limit of databases", func() {
+		conn := connectTo(ctx, accounted, "limited.db")
+		defer conn.Close(ctx)
+		observer := connectTo(ctx, accounted, "tenant.db")
+		defer observer.Close(ctx)
+
+		_, err := conn.Exec(ctx, `BEGIN`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SELECT 1`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `SELECT 2`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue(), fmt.Sprint(err))
+		Expect(pgErr.Code).To(Equal("53000"))
+		Expect(pgErr.Message).To(Equal("database limited.db is over its rate limit of 2 statements per second"))
+
+		// Transactions can still be ended.
+		_, err = conn.Exec(ctx, `ROLLBACK`)
+		Expect(err).NotTo(HaveOccurred())
+
+		u := usage(observer, "limited.db")
+		Expect(u["statements"]).To(Equal(int64(3)))
+		Expect(u["rate_limited"]).To(Equal(int64(1)))
+		Expect(u["rate_limit"]).To(Equal(int64(2)))
+
+		var rejected int64
+		Expect(observer.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = 'rate_limited_statements'`,
+			pgx.QueryExecModeSimpleProtocol).Scan(&rejected)).To(Succeed())
+		Expect(rejected).To(Equal(int64(1)))
+	})
+})
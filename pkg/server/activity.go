@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/sysdb"
+)
+
+// Session states, as in pg_stat_activity.state.
+const (
+	stateActive            = "active"
+	stateIdle              = "idle"
+	stateIdleInTransaction = "idle in transaction"
+)
+
+// Ends the connections of cancel requests, which expect no reply.
+var errCancelRequest = errors.New("cancel request")
+
+// Activity of a session, read by the system schema refreshes of other sessions.
+type activity struct {
+	mu sync.Mutex
+
+	user        string // User of the startup message.
+	application string // application_name setting.
+	state       string
+	query       string // Current query, the last one of idle sessions.
+
+	queryStart  time.Time
+	xactStart   time.Time // Zero outside of transaction blocks.
+	stateChange time.Time
+
+	writeLockStart time.Time // Time the transaction block took the write lock of the database, zero if it doesn't hold it.
+	writeStart     time.Time // Start of the current write statement, zero if none.
+
+	ctx    context.Context    // Context of the current query, nil between queries.
+	cancel context.CancelFunc // Cancels the current query, nil between queries.
+}
+
+// Records the user of the session from its startup parameters.
+func (c *Conn) setClient(startup map[string]string) {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	c.activity.user = startup["user"]
+}
+
+// Records the application_name setting of the session.
+func (c *Conn) setApplication(name string) {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	c.activity.application = name
+}
+
+// Returns the application_name setting of the session, read by other sessions.
+func (c *Conn) application() string {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	return c.activity.application
+}
+
+// Records the end of the startup of the session, idle until its first query.
+func (c *Conn) endStartup() {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	c.activity.state = stateIdle
+	c.activity.stateChange = time.Now()
+}
+
+// Reports whether the session is idle outside of transaction blocks, so ending it
+// interrupts no query or transaction.
+func (c *Conn) idle() bool {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	return c.activity.state == stateIdle
+}
+
+// Records the start of a query of the session, returns the context of its execution,
+// canceled by cancelQuery.
+func (c *Conn) startQuery(ctx context.Context, query string) context.Context {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	ctx, c.activity.cancel = context.WithCancel(ctx)
+	c.activity.ctx = ctx
+	now := time.Now()
+	c.activity.state = stateActive
+	c.activity.query = query
+	c.activity.queryStart = now
+	c.activity.stateChange = now
+	if c.xact == nil {
+		c.activity.xactStart = now
+	}
+	return ctx
+}
+
+// Records the end of the current query of the session.
+func (c *Conn) endQuery() {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	if c.activity.cancel != nil {
+		c.activity.cancel()
+		c.activity.cancel = nil
+	}
+	c.activity.ctx = nil
+	c.activity.state = stateIdle
+	if c.xact != nil {
+		c.activity.state = stateIdleInTransaction
+	} else {
+		c.activity.xactStart = time.Time{}
+		c.activity.writeLockStart = time.Time{}
+	}
+	c.activity.stateChange = time.Now()
+}
+
+// Returns the session information of the connection, for the session information functions.
+func (c *Conn) sessionInfo() sqlite.SessionInfo {
+	// Clients parse the PostgreSQL version at the start of the version string.
+	libVersion, _, _ := sqlite3.Version()
+	info := sqlite.SessionInfo{
+		Database: c.name,
+		User:     c.activity.user,
+		Version:  fmt.Sprintf("PostgreSQL %s on kqlite, SQLite %s", ServerVersion, libVersion),
+		PID:      c.pid,
+	}
+	info.ClientAddr, info.ClientPort = splitAddr(c.RemoteAddr())
+	info.ServerAddr, info.ServerPort = splitAddr(c.LocalAddr())
+	return info
+}
+
+// Returns the host and port of a TCP address, an empty host for unix socket addresses.
+func splitAddr(addr net.Addr) (string, int) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "", -1
+	}
+	n, _ := strconv.Atoi(port)
+	return host, n
+}
+
+// Returns the context of the current query of the session, for the functions waiting on
+// behalf of the query.
+func (c *Conn) queryContext() context.Context {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	if c.activity.ctx == nil {
+		return context.Background()
+	}
+	return c.activity.ctx
+}
+
+// Cancels the current query of the session, SQLite interrupts its statement.
+func (c *Conn) cancelQuery() {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	if c.activity.cancel != nil {
+		c.activity.cancel()
+	}
+}
+
+// Returns the pg_stat_activity row of the session.
+func (c *Conn) activityRow() sysdb.Activity {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	a := sysdb.Activity{
+		PID:             c.pid,
+		Database:        c.name,
+		User:            c.activity.user,
+		ApplicationName: c.activity.application,
+		BackendStart:    c.started,
+		XactStart:       c.activity.xactStart,
+		QueryStart:      c.activity.queryStart,
+		StateChange:     c.activity.stateChange,
+		State:           c.activity.state,
+		Query:           c.activity.query,
+
+		PreparedStmts:        c.stmtCount.Load(),
+		PreparedStmtsBytes:   c.stmtBytes.Load(),
+		PreparedStmtsEvicted: c.stmtEvictions.Load(),
+	}
+	// Unix socket clients have no address, as in PostgreSQL.
+	a.ClientAddr, a.ClientPort = splitAddr(c.RemoteAddr())
+	if a.State == "" {
+		// Still starting up.
+		a.State = stateIdle
+	}
+	return a
+}
+
+// Returns the session of a process ID, nil if there's none.
+func (s *Server) backend(pid int64) *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		if int64(c.pid) == pid {
+			return c
+		}
+	}
+	return nil
+}
+
+// Cancels the current query of the session of a process ID, returns false if there's none.
+func (s *Server) cancelBackend(pid int64) bool {
+	c := s.backend(pid)
+	if c == nil {
+		return false
+	}
+	c.cancelQuery()
+	return true
+}
+
+// Terminates the session of a process ID, returns false if there's none. Its current query
+// is canceled and its pending read of the next message interrupted, which ends the session.
+func (s *Server) terminateBackend(pid int64) bool {
+	c := s.backend(pid)
+	if c == nil {
+		return false
+	}
+	c.terminated.Store(true)
+	c.cancelQuery()
+	c.SetReadDeadline(time.Now())
+	return true
+}
+
+// Handles a cancel request of a client, sent on a connection of its own. The current query
+// of the session is canceled if the secret key matches, the client gets no reply either way.
+func (s *Server) handleCancelRequestMessage(c *Conn, msg *pgproto3.CancelRequest) {
+	c.tracef("[recv] %#v", msg)
+	if target := s.backend(int64(msg.ProcessID)); target != nil && target.secretKey == msg.SecretKey {
+		target.cancelQuery()
+	}
+}
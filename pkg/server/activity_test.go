@@ -0,0 +1,176 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// Query counting rows forever.
+const runawayQuery = `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT count(*) FROM n`
+
+var _ = Describe("Session activity", Ordered, func() {
+	var conn, other *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "activity.db")
+		other = connect(ctx, "activity.db")
+		_, err := other.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Reports session information", func() {
+		var database, catalog, user, version, pid, clientAddr, clientPort string
+		Expect(conn.QueryRow(ctx, `SELECT current_database(), current_catalog, current_user, pg_catalog.version(),
+			pg_backend_pid(), inet_client_addr(), inet_client_port()`).Scan(&database, &catalog, &user, &version, &pid, &clientAddr, &clientPort)).To(Succeed())
+		Expect(database).To(Equal("activity.db"))
+		Expect(catalog).To(Equal("activity.db"))
+		Expect(user).To(Equal(conn.Config().User))
+		Expect(version).To(HavePrefix("PostgreSQL 13."))
+		Expect(clientAddr + ":" + clientPort).To(Equal(conn.PgConn().Conn().LocalAddr().String()))
+
+		var activityPID string
+		Expect(conn.QueryRow(ctx, `SELECT pid FROM pg_stat_activity WHERE pid = pg_backend_pid()`).Scan(&activityPID)).To(Succeed())
+		Expect(activityPID).To(Equal(pid))
+
+		// Literals naming the view are left as is.
+		var literal string
+		Expect(conn.QueryRow(ctx, `SELECT 'pg_stat_activity' FROM pg_stat_activity WHERE pid = pg_backend_pid()`).Scan(&literal)).To(Succeed())
+		Expect(literal).To(Equal("pg_stat_activity"))
+	})
+
+	It("Lists sessions in pg_stat_activity", func() {
+		_, err := other.Exec(ctx, `BEGIN`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = other.Exec(ctx, `INSERT INTO items (id) VALUES (1)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var pid string
+		var state, query string
+		var xactStart *string
+		addr := other.PgConn().Conn().LocalAddr().String()
+		err = conn.QueryRow(ctx, `SELECT pid, state, query, xact_start FROM pg_catalog.pg_stat_activity
+			WHERE datname = 'activity.db' AND client_addr || ':' || client_port = $1`, addr).Scan(&pid, &state, &query, &xactStart)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pid).NotTo(BeEmpty())
+		Expect(state).To(Equal("idle in transaction"))
+		Expect(query).To(ContainSubstring("INSERT INTO items"))
+		Expect(xactStart).NotTo(BeNil())
+
+		// The querying session is active.
+		err = conn.QueryRow(ctx, `SELECT state FROM pg_stat_activity WHERE query LIKE '%SELECT state%'`).Scan(&state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state).To(Equal("active"))
+
+		_, err = other.Exec(ctx, `COMMIT`)
+		Expect(err).NotTo(HaveOccurred())
+		err = conn.QueryRow(ctx, `SELECT state FROM pg_stat_activity WHERE pid = $1`, pid).Scan(&state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(state).To(Equal("idle"))
+	})
+
+	It("Tracks the application of sessions", func() {
+		app, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/activity.db?sslmode=disable&application_name=billing", srv.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		defer app.Close(ctx)
+		Expect(app.PgConn().ParameterStatus("application_name")).To(Equal("billing"))
+
+		applicationName := func() string {
+			var name string
+			Expect(conn.QueryRow(ctx, `SELECT application_name FROM pg_stat_activity WHERE pid = $1`,
+				fmt.Sprint(app.PgConn().PID())).Scan(&name)).To(Succeed())
+			return name
+		}
+		Expect(applicationName()).To(Equal("billing"))
+
+		_, err = app.Exec(ctx, `SET application_name = 'reports'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(app.PgConn().ParameterStatus("application_name")).To(Equal("reports"))
+		Expect(applicationName()).To(Equal("reports"))
+		_, err = app.Exec(ctx, `SELECT * FROM items`)
+		Expect(err).NotTo(HaveOccurred())
+
+		// The usage of applications is accounted for by name.
+		var statements int64
+		var execTime float64
+		Expect(conn.QueryRow(ctx, `SELECT statements, exec_time FROM kqlite.stat_applications WHERE application_name = 'reports'`,
+			pgx.QueryExecModeSimpleProtocol).Scan(&statements, &execTime)).To(Succeed())
+		Expect(statements).To(BeNumerically(">=", 1))
+		Expect(execTime).To(BeNumerically(">", 0))
+
+		_, err = app.Exec(ctx, `RESET application_name`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(applicationName()).To(Equal("billing"))
+	})
+
+	It("Cancels queries with pg_cancel_backend", func() {
+		var pid string
+		addr := other.PgConn().Conn().LocalAddr().String()
+		Expect(conn.QueryRow(ctx, `SELECT pid FROM pg_stat_activity WHERE client_addr || ':' || client_port = $1`, addr).Scan(&pid)).To(Succeed())
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := other.Exec(ctx, runawayQuery)
+			done <- err
+		}()
+		Eventually(func() string {
+			var state string
+			conn.QueryRow(ctx, `SELECT state FROM pg_stat_activity WHERE pid = $1`, pid).Scan(&state)
+			return state
+		}).Should(Equal("active"))
+
+		var canceled string
+		Expect(conn.QueryRow(ctx, `SELECT pg_cancel_backend($1)`, pid).Scan(&canceled)).To(Succeed())
+		Expect(canceled).To(Equal("t"))
+
+		var pgErr *pgconn.PgError
+		Eventually(done).Should(Receive(BeAssignableToTypeOf(pgErr)))
+		_, err := other.Exec(ctx, `SELECT 1`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Cancels queries on cancel requests", func() {
+		// pgx sends a cancel request when the context of a query is done, and closes the connection.
+		canceled := connect(ctx, "activity.db")
+		timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err := canceled.Exec(timeout, runawayQuery)
+		Expect(err).To(HaveOccurred())
+		Expect(canceled.IsClosed()).To(BeTrue())
+
+		Eventually(func() (string, error) {
+			var n string
+			err := conn.QueryRow(ctx, `SELECT count(*) FROM pg_stat_activity WHERE query LIKE 'WITH RECURSIVE%' AND state = 'active'`).Scan(&n)
+			return n, err
+		}).Should(Equal("0"))
+	})
+
+	It("Terminates sessions with pg_terminate_backend", func() {
+		var pid string
+		addr := other.PgConn().Conn().LocalAddr().String()
+		Expect(conn.QueryRow(ctx, `SELECT pid FROM pg_stat_activity WHERE client_addr || ':' || client_port = $1`, addr).Scan(&pid)).To(Succeed())
+
+		// Expressions are sent as text.
+		var terminated string
+		Expect(conn.QueryRow(ctx, `SELECT pg_terminate_backend($1)`, pid).Scan(&terminated)).To(Succeed())
+		Expect(terminated).To(Equal("t"))
+		_, err := other.Exec(ctx, `SELECT 1`)
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("57P01"))
+
+		Expect(conn.QueryRow(ctx, `SELECT pg_terminate_backend(0)`).Scan(&terminated)).To(Succeed())
+		Expect(terminated).To(Equal("f"))
+	})
+})
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/sysdb"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Advisory lock of a database.
+type advisoryKey struct {
+	database string
+	key      sqlite.AdvisoryKey
+}
+
+// Levels and modes of the holds of advisory locks.
+const (
+	sessionLevel = 0
+	xactLevel    = 1
+
+	exclusiveMode = 0
+	sharedMode    = 1
+)
+
+// Holds of an advisory lock by session, counted by level and mode as locks are reentrant.
+type advisoryLock struct {
+	holds    map[*Conn]*[2][2]int
+	released chan struct{} // Closed when holds are released, wakes the waiting sessions.
+}
+
+// Returns the sessions holding a lock that conflict with a session acquiring it: exclusive
+// holds conflict with all holds, holds of the session itself never conflict.
+func (l *advisoryLock) blockers(c *Conn, shared bool) []*Conn {
+	var conns []*Conn
+	for holder, counts := range l.holds {
+		if holder == c {
+			continue
+		}
+		exclusive := counts[sessionLevel][exclusiveMode] + counts[xactLevel][exclusiveMode]
+		if exclusive > 0 || !shared {
+			conns = append(conns, holder)
+		}
+	}
+	return conns
+}
+
+// Advisory locks of the databases, held by their sessions. Sessions waiting for locks held
+// by sessions waiting for theirs are deadlocked, the last one to wait fails.
+type advisoryLocks struct {
+	mu    sync.Mutex
+	locks map[advisoryKey]*advisoryLock
+	held  map[*Conn]map[advisoryKey]struct{} // Locks held by session.
+	waits map[*Conn]advisoryWait             // Locks sessions wait for.
+}
+
+type advisoryWait struct {
+	key    advisoryKey
+	shared bool
+	since  time.Time
+}
+
+func newAdvisoryLocks() *advisoryLocks {
+	return &advisoryLocks{
+		locks: make(map[advisoryKey]*advisoryLock),
+		held:  make(map[*Conn]map[advisoryKey]struct{}),
+		waits: make(map[*Conn]advisoryWait),
+	}
+}
+
+// Acquires a lock for a session, waiting for the sessions holding it if wait is set until
+// ctx is done. Returns false if the lock is held by other sessions and wait isn't set.
+func (a *advisoryLocks) lock(ctx context.Context, c *Conn, key advisoryKey, shared, xact, wait bool) (bool, error) {
+	var since time.Time
+	for {
+		a.mu.Lock()
+		l := a.locks[key]
+		if l == nil {
+			l = &advisoryLock{holds: make(map[*Conn]*[2][2]int)}
+			a.locks[key] = l
+		}
+		blockers := l.blockers(c, shared)
+		if len(blockers) == 0 {
+			a.grant(l, c, key, shared, xact)
+			a.mu.Unlock()
+			return true, nil
+		}
+		if !wait {
+			a.mu.Unlock()
+			return false, nil
+		}
+		if a.deadlocked(c, blockers) {
+			a.mu.Unlock()
+			return false, pgerror.New(pgerror.DeadlockDetected, "deadlock detected")
+		}
+		if since.IsZero() {
+			since = time.Now()
+		}
+		a.waits[c] = advisoryWait{key: key, shared: shared, since: since}
+		if l.released == nil {
+			l.released = make(chan struct{})
+		}
+		released := l.released
+		a.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+		}
+		a.mu.Lock()
+		delete(a.waits, c)
+		a.mu.Unlock()
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+	}
+}
+
+func (a *advisoryLocks) grant(l *advisoryLock, c *Conn, key advisoryKey, shared, xact bool) {
+	counts := l.holds[c]
+	if counts == nil {
+		counts = new([2][2]int)
+		l.holds[c] = counts
+	}
+	level, mode := sessionLevel, exclusiveMode
+	if xact {
+		level = xactLevel
+	}
+	if shared {
+		mode = sharedMode
+	}
+	counts[level][mode]++
+	if a.held[c] == nil {
+		a.held[c] = make(map[advisoryKey]struct{})
+	}
+	a.held[c][key] = struct{}{}
+}
+
+// Reports whether a session waiting for the sessions blocking it would wait for itself.
+func (a *advisoryLocks) deadlocked(c *Conn, blockers []*Conn) bool {
+	seen := make(map[*Conn]bool)
+	for len(blockers) > 0 {
+		b := blockers[len(blockers)-1]
+		blockers = blockers[:len(blockers)-1]
+		if b == c {
+			return true
+		} else if seen[b] {
+			continue
+		}
+		seen[b] = true
+		if w, ok := a.waits[b]; ok && a.locks[w.key] != nil {
+			blockers = append(blockers, a.locks[w.key].blockers(b, w.shared)...)
+		}
+	}
+	return false
+}
+
+// Releases a hold of a lock of a session at session level, returns false if it had none.
+func (a *advisoryLocks) unlock(c *Conn, key advisoryKey, shared bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l := a.locks[key]
+	if l == nil || l.holds[c] == nil {
+		return false
+	}
+	mode := exclusiveMode
+	if shared {
+		mode = sharedMode
+	}
+	if l.holds[c][sessionLevel][mode] == 0 {
+		return false
+	}
+	l.holds[c][sessionLevel][mode]--
+	a.released(l, c, key)
+	return true
+}
+
+// Releases the holds of the locks of a session at a level, at all levels if all is set.
+func (a *advisoryLocks) release(c *Conn, level int, all bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key := range a.held[c] {
+		l := a.locks[key]
+		for i := range l.holds[c] {
+			if i == level || all {
+				l.holds[c][i] = [2]int{}
+			}
+		}
+		a.released(l, c, key)
+	}
+}
+
+// Wakes the sessions waiting for a lock after a session released holds, and forgets the
+// locks no longer held.
+func (a *advisoryLocks) released(l *advisoryLock, c *Conn, key advisoryKey) {
+	if l.released != nil {
+		close(l.released)
+		l.released = nil
+	}
+	if *l.holds[c] == [2][2]int{} {
+		delete(l.holds, c)
+		delete(a.held[c], key)
+		if len(a.held[c]) == 0 {
+			delete(a.held, c)
+		}
+	}
+	if len(l.holds) == 0 {
+		delete(a.locks, key)
+	}
+}
+
+// Returns the advisory locks held and awaited by the sessions, as pg_locks lists them.
+func (a *advisoryLocks) snapshot() []sysdb.Lock {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var locks []sysdb.Lock
+	for key, l := range a.locks {
+		for c, counts := range l.holds {
+			for mode, name := range []string{exclusiveLock, shareLock} {
+				if counts[sessionLevel][mode]+counts[xactLevel][mode] > 0 {
+					locks = append(locks, advisoryLockRow(key, c, name, true))
+				}
+			}
+		}
+	}
+	for c, w := range a.waits {
+		mode := exclusiveLock
+		if w.shared {
+			mode = shareLock
+		}
+		l := advisoryLockRow(w.key, c, mode, false)
+		l.WaitStart = w.since
+		locks = append(locks, l)
+	}
+	return locks
+}
+
+// Returns the pg_locks row of an advisory lock of a session, bigint keys are split in
+// their high and low 32 bits as PostgreSQL splits them.
+func advisoryLockRow(key advisoryKey, c *Conn, mode string, granted bool) sysdb.Lock {
+	l := sysdb.Lock{
+		Type:     "advisory",
+		Database: key.database,
+		ClassID:  uint32(uint64(key.key.ID) >> 32),
+		ObjID:    uint32(key.key.ID),
+		ObjSubID: 1,
+		PID:      c.pid,
+		Mode:     mode,
+		Granted:  granted,
+	}
+	if key.key.Pair {
+		l.ObjSubID = 2
+	}
+	return l
+}
+
+// Releases the advisory locks of the transaction of a session once it ended.
+func (s *Server) releaseXactLocks(c *Conn) {
+	if c.xact == nil {
+		s.advisory.release(c, xactLevel, false)
+	}
+}
+
+// Advisory locks of a session, acquired by the advisory lock functions of its connections.
+type sessionLocks struct {
+	s *Server
+	c *Conn
+}
+
+func (l sessionLocks) Lock(key sqlite.AdvisoryKey, shared, xact, wait bool) (bool, error) {
+	return l.s.advisory.lock(l.c.queryContext(), l.c, advisoryKey{l.c.name, key}, shared, xact, wait)
+}
+
+func (l sessionLocks) Unlock(key sqlite.AdvisoryKey, shared bool) bool {
+	return l.s.advisory.unlock(l.c, advisoryKey{l.c.name, key}, shared)
+}
+
+func (l sessionLocks) UnlockAll() {
+	l.s.advisory.release(l.c, sessionLevel, false)
+}
@@ -0,0 +1,129 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Advisory locks", Ordered, func() {
+	var conn, other *pgx.Conn
+	ctx := context.Background()
+
+	query := func(conn *pgx.Conn, sql string, args ...any) string {
+		var s string
+		Expect(conn.QueryRow(ctx, sql, args...).Scan(&s)).To(Succeed())
+		return s
+	}
+
+	exec := func(conn *pgx.Conn, sql string) {
+		_, err := conn.Exec(ctx, sql)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeAll(func() {
+		conn = connect(ctx, "advisory.db")
+		other = connect(ctx, "advisory.db")
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+		Expect(other.Close(ctx)).To(Succeed())
+	})
+
+	It("Locks keys of a database until unlocked", func() {
+		exec(conn, `SELECT pg_advisory_lock(42)`)
+		Expect(query(conn, `SELECT pg_try_advisory_lock(42)`)).To(Equal("t"))
+		Expect(query(other, `SELECT pg_try_advisory_lock($1)`, 42)).To(Equal("f"))
+		Expect(query(other, `SELECT pg_try_advisory_lock_shared(42)`)).To(Equal("f"))
+
+		// Pairs of keys and other databases don't conflict.
+		Expect(query(other, `SELECT pg_try_advisory_lock(0, 42)`)).To(Equal("t"))
+		elsewhere := connect(ctx, "advisory_other.db")
+		Expect(query(elsewhere, `SELECT pg_try_advisory_lock(42)`)).To(Equal("t"))
+		Expect(elsewhere.Close(ctx)).To(Succeed())
+
+		// Locks are reentrant.
+		Expect(query(conn, `SELECT pg_advisory_unlock(42)`)).To(Equal("t"))
+		Expect(query(other, `SELECT pg_try_advisory_lock(42)`)).To(Equal("f"))
+		Expect(query(conn, `SELECT pg_advisory_unlock(42)`)).To(Equal("t"))
+		Expect(query(conn, `SELECT pg_advisory_unlock(42)`)).To(Equal("f"))
+		Expect(query(other, `SELECT pg_try_advisory_lock(42)`)).To(Equal("t"))
+		exec(other, `SELECT pg_advisory_unlock_all()`)
+		Expect(query(conn, `SELECT pg_try_advisory_lock(0, 42)`)).To(Equal("t"))
+		exec(conn, `SELECT pg_advisory_unlock_all()`)
+
+		Expect(query(conn, `SELECT pg_try_advisory_lock_shared(7)`)).To(Equal("t"))
+		Expect(query(other, `SELECT pg_try_advisory_lock_shared(7)`)).To(Equal("t"))
+		Expect(query(other, `SELECT pg_try_advisory_lock(7)`)).To(Equal("f"))
+		Expect(query(conn, `SELECT pg_advisory_unlock_shared(7)`)).To(Equal("t"))
+		Expect(query(other, `SELECT pg_try_advisory_lock(7)`)).To(Equal("t"))
+		exec(other, `SELECT pg_advisory_unlock_all()`)
+	})
+
+	It("Waits for the sessions holding locks", func() {
+		exec(conn, `SELECT pg_advisory_lock(1)`)
+		done := make(chan error, 1)
+		go func() {
+			_, err := other.Exec(ctx, `SELECT pg_advisory_lock(1)`)
+			done <- err
+		}()
+		Consistently(done, "100ms").ShouldNot(Receive())
+		Expect(query(conn, `SELECT pg_advisory_unlock(1)`)).To(Equal("t"))
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(query(conn, `SELECT pg_try_advisory_lock(1)`)).To(Equal("f"))
+
+		// Sessions waiting for each other are deadlocked.
+		exec(conn, `SELECT pg_advisory_lock(2)`)
+		go func() {
+			_, err := conn.Exec(ctx, `SELECT pg_advisory_lock(1)`)
+			done <- err
+		}()
+		Consistently(done, "100ms").ShouldNot(Receive())
+		var pgErr *pgconn.PgError
+		_, err := other.Exec(ctx, `SELECT pg_advisory_lock(2)`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("40P01"))
+		exec(other, `SELECT pg_advisory_unlock_all()`)
+		Eventually(done).Should(Receive(BeNil()))
+		exec(conn, `SELECT pg_advisory_unlock_all()`)
+	})
+
+	It("Releases transaction locks when transactions end", func() {
+		tx, err := conn.Begin(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(3)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query(other, `SELECT pg_try_advisory_xact_lock(3)`)).To(Equal("f"))
+		// Transaction locks can't be unlocked.
+		Expect(query(conn, `SELECT pg_advisory_unlock(3)`)).To(Equal("f"))
+		Expect(tx.Commit(ctx)).To(Succeed())
+
+		Expect(query(other, `SELECT pg_try_advisory_xact_lock(3)`)).To(Equal("t"))
+		Expect(query(conn, `SELECT pg_try_advisory_xact_lock_shared(3)`)).To(Equal("t"))
+	})
+
+	It("Releases the locks of closed sessions", func() {
+		closed := connect(ctx, "advisory.db")
+		exec(closed, `SELECT pg_advisory_lock(4)`)
+		Expect(query(conn, `SELECT pg_try_advisory_lock(4)`)).To(Equal("f"))
+		Expect(closed.Close(ctx)).To(Succeed())
+		Eventually(func() string { return query(conn, `SELECT pg_try_advisory_lock(4)`) }).Should(Equal("t"))
+		exec(conn, `SELECT pg_advisory_unlock_all()`)
+	})
+
+	It("Releases the locks of sessions discarded with DISCARD ALL", func() {
+		pooled := connect(ctx, "advisory.db")
+		defer pooled.Close(ctx)
+		exec(pooled, `SELECT pg_advisory_lock(5)`)
+		Expect(query(conn, `SELECT pg_try_advisory_lock(5)`)).To(Equal("f"))
+		_, err := pooled.PgConn().Exec(ctx, `DISCARD ALL`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query(conn, `SELECT pg_try_advisory_lock(5)`)).To(Equal("t"))
+		exec(conn, `SELECT pg_advisory_unlock_all()`)
+	})
+})
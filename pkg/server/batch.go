@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Reports whether the statement is executed in the transaction of the batch. Writes of the
+// extended protocol are executed in a transaction until Sync, the implicit transaction of
+// PostgreSQL: JDBC batches send many Bind and Execute messages of the same prepared
+// statement before Sync, they're committed together.
+func batchable(stmt parser.StmtClass) bool {
+	switch stmt.Command {
+	case parser.CmdInsert, parser.CmdUpdate, parser.CmdDelete:
+		return true
+	}
+	return false
+}
+
+// Starts the transaction of the batch before its first write outside of transaction blocks,
+// it's committed on Sync so the batch is applied as a whole. The transaction is deferred,
+// BEGIN IMMEDIATE would lock the attached system schema too.
+func (s *Server) beginBatch(ctx context.Context, c *Conn, stmt parser.StmtClass) error {
+	if c.batch || !batchable(stmt) {
+		return nil
+	}
+	if inTx, err := sqlite.InTransaction(c.conn); err != nil || inTx {
+		return err
+	}
+	if _, err := c.conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	c.batch = true
+	return nil
+}
+
+// Ends the transaction of the batch, committed unless a statement of the batch failed.
+// Other statements end the batch as well, they're executed outside of its transaction.
+func (s *Server) endBatch(ctx context.Context, c *Conn) error {
+	if !c.batch {
+		return nil
+	}
+	c.batch = false
+
+	if c.failed {
+		_, err := c.conn.ExecContext(ctx, "ROLLBACK")
+		if err == nil {
+			err = c.trackStmt(ctx, parser.StmtClass{Command: parser.CmdRollback, ReadOnly: true}, "", nil)
+		}
+		return err
+	}
+	commit := parser.StmtClass{Command: parser.CmdCommit, ReadOnly: true}
+	if err := s.retry(ctx, c, commit, func() error {
+		_, err := c.conn.ExecContext(ctx, "COMMIT")
+		return err
+	}); err != nil {
+		c.conn.ExecContext(ctx, "ROLLBACK")
+		c.trackStmt(ctx, commit, "", nil)
+		return err
+	}
+	if err := c.trackStmt(ctx, commit, "", nil); err != nil {
+		return err
+	}
+	if err := c.syncCommit(commit); err != nil {
+		return err
+	}
+	return s.publishChanges(ctx, c, commit)
+}
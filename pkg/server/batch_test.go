@@ -0,0 +1,81 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batches", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	// Expressions are sent as text.
+	count := func() string {
+		var n string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM entries`).Scan(&n)).To(Succeed())
+		return n
+	}
+
+	BeforeAll(func() {
+		conn = connect(ctx, "batches.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE entries (id INTEGER PRIMARY KEY, name TEXT NOT NULL)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Executes the statements of a batch with their own command tags", func() {
+		batch := &pgx.Batch{}
+		for i := 1; i <= 100; i++ {
+			batch.Queue(`INSERT INTO entries (id, name) VALUES ($1, $2)`, i, "entry")
+		}
+		batch.Queue(`UPDATE entries SET name = 'updated' WHERE id <= $1`, 10)
+		batch.Queue(`SELECT count(*) FROM entries`)
+
+		results := conn.SendBatch(ctx, batch)
+		for i := 1; i <= 100; i++ {
+			tag, err := results.Exec()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tag.String()).To(Equal("INSERT 0 1"))
+		}
+		tag, err := results.Exec()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("UPDATE 10"))
+		var n string
+		Expect(results.QueryRow().Scan(&n)).To(Succeed())
+		Expect(n).To(Equal("100"))
+		Expect(results.Close()).To(Succeed())
+
+		Expect(count()).To(Equal("100"))
+	})
+
+	It("Rolls back batches as a whole", func() {
+		batch := &pgx.Batch{}
+		batch.Queue(`INSERT INTO entries (id, name) VALUES ($1, $2)`, 101, "entry")
+		batch.Queue(`INSERT INTO entries (id, name) VALUES ($1, $2)`, 102, nil)
+		batch.Queue(`INSERT INTO entries (id, name) VALUES ($1, $2)`, 103, "entry")
+		Expect(conn.SendBatch(ctx, batch).Close()).To(MatchError(ContainSubstring("NOT NULL")))
+
+		Expect(count()).To(Equal("100"))
+	})
+
+	It("Leaves the session idle once the batch is committed", func() {
+		_, err := conn.Exec(ctx, `INSERT INTO entries (id, name) VALUES ($1, $2)`, 104, "entry")
+		Expect(err).NotTo(HaveOccurred())
+
+		var state string
+		Expect(conn.QueryRow(ctx, `SELECT state FROM pg_stat_activity WHERE pid = pg_backend_pid()`).Scan(&state)).To(Succeed())
+		Expect(state).To(Equal("active"))
+
+		other := connect(ctx, "batches.db")
+		defer other.Close(ctx)
+		Expect(other.QueryRow(ctx, `SELECT state FROM pg_stat_activity WHERE pid = $1`, fmt.Sprint(conn.PgConn().PID())).Scan(&state)).To(Succeed())
+		Expect(state).To(Equal("idle"))
+	})
+})
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Row changes of sessions are captured by temporary triggers into a temporary
+// table of the session, so changes are rolled back along with their transaction.
+// Committed changes are published to the replication slots of the database.
+const (
+	changesTable   = "kqlite_changes"
+	triggerPrefix  = "kqlite_cdc_"
+	changesColumns = "seq INTEGER PRIMARY KEY, kind TEXT, tbl TEXT, columns TEXT, types TEXT, keys TEXT, old TEXT, new TEXT"
+)
+
+// Change of a row in the wal2json format.
+type rowChange struct {
+	Kind         string        `json:"kind"`
+	Schema       string        `json:"schema"`
+	Table        string        `json:"table"`
+	ColumnNames  []string      `json:"columnnames,omitempty"`
+	ColumnTypes  []string      `json:"columntypes,omitempty"`
+	ColumnValues []interface{} `json:"columnvalues,omitempty"`
+	OldKeys      *oldKeys      `json:"oldkeys,omitempty"`
+}
+
+// Replica identity of a changed row, its primary key or all its columns
+// if the table has no primary key.
+type oldKeys struct {
+	KeyNames  []string      `json:"keynames"`
+	KeyTypes  []string      `json:"keytypes"`
+	KeyValues []interface{} `json:"keyvalues"`
+}
+
+// Committed transaction of a database.
+type feedChange struct {
+	lsn  uint64
+	data []byte // wal2json message of the transaction.
+}
+
+// changeFeed holds the replication slots of the server and the changes
+// they haven't confirmed yet.
+type changeFeed struct {
+	mu       sync.Mutex
+	systemID string // Identifies the server to replication clients.
+	lsn      uint64 // Position of the last published transaction.
+	slots    map[string]*replicationSlot
+
+	faults replicationFaults // Faults injected in the streaming of slots.
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{
+		systemID: strconv.FormatInt(time.Now().UnixNano(), 10),
+		// Positions start away from 0/0, which clients use for the slot position.
+		lsn:   0x1000000,
+		slots: make(map[string]*replicationSlot),
+	}
+}
+
+// Reports whether changes of the database are consumed by replication slots.
+func (f *changeFeed) active(database string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, slot := range f.slots {
+		if slot.database == database {
+			return true
+		}
+	}
+	return false
+}
+
+// Publishes a committed transaction of the database to its replication slots.
+func (f *changeFeed) publish(database string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lsn++
+	for _, slot := range f.slots {
+		if slot.database != database {
+			continue
+		}
+		slot.changes = append(slot.changes, feedChange{lsn: f.lsn, data: data})
+		select {
+		case slot.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Keeps the capture triggers of the session connection in line with the tables of the
+// database. Triggers are created while the database has replication slots and recreated
+// after schema changes, outside of transactions only so they are never rolled back.
+func (s *Server) captureChanges(ctx context.Context, c *Conn) error {
+	active := s.cdc.active(c.name)
+	s.mu.Lock()
+	version := s.schemaVersions[c.name]
+	s.mu.Unlock()
+	if active == c.capturing && (!active || version == c.captureVersion) {
+		return nil
+	}
+	if inTx, err := sqlite.InTransaction(c.conn); err != nil || inTx {
+		return err
+	}
+
+	if err := c.stopCapture(ctx); err != nil {
+		return fmt.Errorf("drop capture triggers: %w", err)
+	}
+	if !active {
+		return nil
+	}
+	if err := createCaptureTriggers(ctx, c); err != nil {
+		return fmt.Errorf("create capture triggers: %w", err)
+	}
+	c.capturing, c.captureVersion = true, version
+	return nil
+}
+
+// Publishes the changes captured on the session connection once their transaction is committed,
+// and sends the notifications of the session.
+func (s *Server) publishChanges(ctx context.Context, c *Conn, stmt parser.StmtClass) error {
+	s.publishNotifications(c, stmt)
+	s.publishFunctions(c)
+	if !c.capturing || (stmt.ReadOnly && stmt.Command != parser.CmdCommit) {
+		return nil
+	}
+	if inTx, err := sqlite.InTransaction(c.conn); err != nil || inTx {
+		return err
+	}
+
+	rows, err := c.conn.QueryContext(ctx, `SELECT kind, tbl, columns, types, keys, old, new FROM temp.`+changesTable+` ORDER BY seq`)
+	if err != nil {
+		return err
+	}
+	var changes []rowChange
+	for rows.Next() {
+		var kind, table, columns, types, keys string
+		var old, new *string
+		if err := rows.Scan(&kind, &table, &columns, &types, &keys, &old, &new); err != nil {
+			rows.Close()
+			return err
+		}
+		change, err := decodeChange(kind, table, columns, types, keys, old, new)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		changes = append(changes, change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if _, err := c.conn.ExecContext(ctx, `DELETE FROM temp.`+changesTable); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(struct {
+		Change []rowChange `json:"change"`
+	}{changes})
+	if err != nil {
+		return err
+	}
+	s.cdc.publish(c.name, data)
+	return nil
+}
+
+// Returns a captured change in the wal2json format.
+func decodeChange(kind, table, columns, types, keys string, old, new *string) (rowChange, error) {
+	change := rowChange{Kind: kind, Schema: "public", Table: table}
+	var names, typeNames, keyNames []string
+	for _, field := range []struct {
+		text  string
+		value *[]string
+	}{{columns, &names}, {types, &typeNames}, {keys, &keyNames}} {
+		if err := json.Unmarshal([]byte(field.text), field.value); err != nil {
+			return change, err
+		}
+	}
+
+	if new != nil {
+		change.ColumnNames, change.ColumnTypes = names, typeNames
+		if err := json.Unmarshal([]byte(*new), &change.ColumnValues); err != nil {
+			return change, err
+		}
+	}
+	if old != nil {
+		var values []interface{}
+		if err := json.Unmarshal([]byte(*old), &values); err != nil {
+			return change, err
+		}
+		change.OldKeys = &oldKeys{}
+		for i, name := range names {
+			for _, key := range keyNames {
+				if key == name && i < len(values) {
+					change.OldKeys.KeyNames = append(change.OldKeys.KeyNames, name)
+					change.OldKeys.KeyTypes = append(change.OldKeys.KeyTypes, typeNames[i])
+					change.OldKeys.KeyValues = append(change.OldKeys.KeyValues, values[i])
+				}
+			}
+		}
+	}
+	return change, nil
+}
+
+// Drops the capture triggers of the session connection.
+func (c *Conn) stopCapture(ctx context.Context) error {
+	c.capturing = false
+	rows, err := c.conn.QueryContext(ctx, `SELECT name FROM temp.sqlite_master WHERE type = 'trigger' AND name LIKE ?`,
+		triggerPrefix+"%")
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := c.conn.ExecContext(ctx, "DROP TRIGGER temp."+sqlite.QuoteIdent(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Creates the triggers capturing the row changes of all tables of the database.
+func createCaptureTriggers(ctx context.Context, c *Conn) error {
+	if _, err := c.conn.ExecContext(ctx, `CREATE TEMP TABLE IF NOT EXISTS `+changesTable+` (`+changesColumns+`)`); err != nil {
+		return err
+	}
+
+	rows, err := c.conn.QueryContext(ctx, `SELECT m.name, p.name, p.type, p.pk
+		FROM main.sqlite_master AS m JOIN pragma_table_info(m.name) AS p
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+		ORDER BY m.name, p.cid`)
+	if err != nil {
+		return err
+	}
+	type table struct {
+		columns, types, keys []string
+	}
+	var tables []string
+	byName := make(map[string]*table)
+	for rows.Next() {
+		var name, column, typ string
+		var pk int
+		if err := rows.Scan(&name, &column, &typ, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		t, ok := byName[name]
+		if !ok {
+			t = &table{}
+			byName[name] = t
+			tables = append(tables, name)
+		}
+		if typ == "" {
+			typ = "text"
+		}
+		t.columns = append(t.columns, column)
+		t.types = append(t.types, strings.ToLower(typ))
+		if pk > 0 {
+			t.keys = append(t.keys, column)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range tables {
+		t := byName[name]
+		keys := t.keys
+		if len(keys) == 0 {
+			keys = t.columns
+		}
+		columns, types, keyNames := jsonLiteral(t.columns), jsonLiteral(t.types), jsonLiteral(keys)
+		for _, trigger := range []struct {
+			event    string
+			old, new string
+		}{
+			{"INSERT", "NULL", rowValues("NEW", t.columns)},
+			{"UPDATE", rowValues("OLD", t.columns), rowValues("NEW", t.columns)},
+			{"DELETE", rowValues("OLD", t.columns), "NULL"},
+		} {
+			stmt := fmt.Sprintf(`CREATE TEMP TRIGGER %s AFTER %s ON main.%s BEGIN
+				INSERT INTO %s (kind, tbl, columns, types, keys, old, new) VALUES (%s, %s, %s, %s, %s, %s, %s);
+				END`,
+				sqlite.QuoteIdent(triggerPrefix+strings.ToLower(trigger.event)+"_"+name), trigger.event, sqlite.QuoteIdent(name),
+				changesTable, sqlite.QuoteLiteral(strings.ToLower(trigger.event)), sqlite.QuoteLiteral(name),
+				columns, types, keyNames, trigger.old, trigger.new)
+			if _, err := c.conn.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the expression of the values of a trigger row as a JSON array,
+// blobs are encoded as bytea hex strings.
+func rowValues(row string, columns []string) string {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		ref := row + "." + sqlite.QuoteIdent(column)
+		values[i] = fmt.Sprintf(`CASE typeof(%s) WHEN 'blob' THEN '\x' || lower(hex(%s)) ELSE %s END`, ref, ref, ref)
+	}
+	return "json_array(" + strings.Join(values, ", ") + ")"
+}
+
+// Returns the SQL string literal of the JSON encoding of values.
+func jsonLiteral(values []string) string {
+	data, _ := json.Marshal(values)
+	return sqlite.QuoteLiteral(string(data))
+}
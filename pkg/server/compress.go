@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Payloads of replication streams smaller than this are sent uncompressed, compressing
+// them saves little.
+const compressMinSize = 256
+
+// Options of START_REPLICATION, e.g. (compression 'zstd', "pretty-print" '1').
+var replicationOptionRegex = regexp.MustCompile(`"?([\w.-]+)"?\s+'([^']*)'`)
+
+// Compresses the payloads of XLogData messages of a replication stream, negotiated by the
+// client with the compression option of START_REPLICATION. Each payload is compressed on
+// its own, as a zstd frame or a snappy framed stream. Clients tell compressed payloads from
+// uncompressed ones, smaller than compressMinSize, by the magic number of the format.
+type compressor interface {
+	compress(dst, src []byte) []byte
+}
+
+// Returns the compressor of a codec, nil for no compression.
+func newCompressor(codec string) (compressor, error) {
+	switch strings.ToLower(codec) {
+	case "", "none":
+		return nil, nil
+	case "zstd":
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zstdCompressor{enc}, nil
+	case "snappy":
+		c := &snappyCompressor{}
+		c.w = snappy.NewBufferedWriter(&c.buf)
+		return c, nil
+	}
+	e := pgerror.Newf(pgerror.FeatureNotSupported, "compression %q is not supported", codec)
+	e.Hint = "Available values: none, snappy, zstd."
+	return nil, e
+}
+
+// Returns the value of a START_REPLICATION option, empty if it isn't given.
+func replicationOption(options, name string) string {
+	for _, m := range replicationOptionRegex.FindAllStringSubmatch(options, -1) {
+		if strings.EqualFold(m[1], name) {
+			return m[2]
+		}
+	}
+	return ""
+}
+
+type zstdCompressor struct {
+	enc *zstd.Encoder
+}
+
+func (c zstdCompressor) compress(dst, src []byte) []byte {
+	return c.enc.EncodeAll(src, dst)
+}
+
+type snappyCompressor struct {
+	buf bytes.Buffer
+	w   *snappy.Writer
+}
+
+func (c *snappyCompressor) compress(dst, src []byte) []byte {
+	c.buf.Reset()
+	c.w.Reset(&c.buf)
+	c.w.Write(src)
+	c.w.Flush()
+	return append(dst, c.buf.Bytes()...)
+}
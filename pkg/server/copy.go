@@ -0,0 +1,508 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// WAL size over which databases are checkpointed once a copy committed, so bulk loads don't
+// leave their rows in the WAL until the next automatic checkpoint.
+const copyCheckpointBytes = 64 << 20
+
+// Signature of the header of binary COPY data.
+var copyBinarySignature = []byte("PGCOPY\n\377\r\n\000")
+
+// Executes COPY FROM STDIN, the response is appended to buf. Returns false if the statement
+// is not a COPY statement. Rows are streamed from the CopyData messages of the client into
+// a single prepared INSERT statement, in a savepoint so that all rows are copied or none.
+func (s *Server) execCopyStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, buf []byte) ([]byte, bool, error) {
+	if stmt.Command != "COPY" {
+		return buf, false, nil
+	}
+	from, err := parser.ParseCopyFrom(stmt.SQL)
+	if err != nil {
+		return buf, true, err
+	}
+	table := sqlite.QuoteIdent(from.Table)
+	if from.Schema != "" && !strings.EqualFold(from.Schema, publicSchema) {
+		table = sqlite.QuoteIdent(from.Schema) + "." + table
+	}
+	columns, oids, err := copyColumns(ctx, c, table, from.Columns)
+	if err != nil {
+		return buf, true, err
+	}
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = sqlite.QuoteIdent(column)
+	}
+	insert, err := c.conn.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table,
+		strings.Join(quoted, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")))
+	if err != nil {
+		return buf, true, err
+	}
+	defer insert.Close()
+
+	// The results of the previous statements of the query are sent before the copy starts.
+	format := TextFormat
+	if from.Format == parser.CopyBinary {
+		format = BinaryFormat
+	}
+	codes := make([]uint16, len(columns))
+	for i := range codes {
+		codes[i] = uint16(format)
+	}
+	buf, _ = (&pgproto3.CopyInResponse{OverallFormat: byte(format), ColumnFormatCodes: codes}).Encode(buf)
+	if _, err := c.Write(buf); err != nil {
+		return buf[:0], true, err
+	}
+	buf = buf[:0]
+
+	r := &copyInReader{c: c}
+	var count int64
+	err = c.atomically(ctx, func() error {
+		dec, err := newCopyDecoder(bufio.NewReaderSize(r, 64<<10), from, oids)
+		if err != nil {
+			return err
+		}
+		for {
+			values, err := dec.next()
+			if err == io.EOF {
+				return nil
+			} else if err == nil {
+				_, err = insert.ExecContext(ctx, values...)
+			}
+			if err != nil {
+				e := *pgerror.Convert(err)
+				e.Where = fmt.Sprintf("COPY %s, line %d", from.Table, dec.line())
+				return &e
+			}
+			count++
+		}
+	})
+	// Data left after an error or the end-of-data marker is discarded.
+	if e := r.drain(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return buf, true, err
+	}
+
+	s.maint.record(c, stmt, count)
+	c.rowsWritten.Add(count)
+	s.checkpointCopy(ctx, c)
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(count))}).Encode(buf)
+	return buf, true, nil
+}
+
+// Returns the names and type OIDs of the columns of a table copied into, all its columns
+// if none are listed.
+func copyColumns(ctx context.Context, c *Conn, table string, columns []string) ([]string, []uint32, error) {
+	list := "*"
+	if len(columns) > 0 {
+		quoted := make([]string, len(columns))
+		for i, column := range columns {
+			quoted[i] = sqlite.QuoteIdent(column)
+		}
+		list = strings.Join(quoted, ", ")
+	}
+	rows, err := c.conn.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s LIMIT 0", list, table))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+	oids := make([]uint32, len(types))
+	for i, field := range toRowDescription(types).Fields {
+		oids[i] = field.DataTypeOID
+	}
+	return names, oids, nil
+}
+
+// Checkpoints the database of a session after a copy committed once its WAL grew over
+// copyCheckpointBytes. Passive checkpoints don't wait for the readers of the database.
+func (s *Server) checkpointCopy(ctx context.Context, c *Conn) {
+	if c.xact != nil || c.dsn != s.databasePath(c.name) {
+		return
+	}
+	if info, err := os.Stat(c.dsn + "-wal"); err != nil || info.Size() < copyCheckpointBytes {
+		return
+	}
+	if _, err := c.conn.ExecContext(ctx, "PRAGMA wal_checkpoint(PASSIVE)"); err != nil {
+		c.tracef("copy checkpoint: %s", err)
+	}
+}
+
+// copyInReader reads the COPY data of the CopyData messages of a client until CopyDone.
+type copyInReader struct {
+	c    *Conn
+	data []byte // Data of the last message not read yet, valid until the next message.
+	done bool
+}
+
+func (r *copyInReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		msg, err := r.c.backend.Receive()
+		if err != nil {
+			r.done = true
+			return 0, err
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			r.data = msg.Data
+		case *pgproto3.CopyDone:
+			r.done = true
+		case *pgproto3.CopyFail:
+			r.done = true
+			return 0, pgerror.Newf(pgerror.QueryCanceled, "COPY from stdin failed: %s", msg.Message)
+		case *pgproto3.Flush, *pgproto3.Sync:
+			// Ignored in copy-in mode.
+		default:
+			r.done = true
+			return 0, pgerror.Newf(pgerror.ProtocolViolation, "unexpected message type %T during COPY from stdin", msg)
+		}
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Discards the data up to the end of the copy, returns the failure of the client if any.
+func (r *copyInReader) drain() error {
+	for !r.done {
+		r.data = nil
+		if _, err := r.Read(nil); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDecoder decodes the rows of COPY data into statement arguments.
+type copyDecoder interface {
+	next() ([]interface{}, error) // Returns io.EOF after the last row.
+	line() int64                  // Line of the last row, for error messages.
+}
+
+func newCopyDecoder(r *bufio.Reader, from *parser.CopyFrom, oids []uint32) (copyDecoder, error) {
+	switch from.Format {
+	case parser.CopyBinary:
+		return newBinaryCopyDecoder(r, oids)
+	case parser.CopyCSV:
+		dec := &csvCopyDecoder{r: r, from: from, columns: len(oids)}
+		if from.Header {
+			if _, err := dec.next(); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+		return dec, nil
+	}
+	return &textCopyDecoder{r: r, from: from, columns: len(oids)}, nil
+}
+
+// Decodes rows of the text format: lines of values separated by the delimiter, with
+// backslash escapes.
+type textCopyDecoder struct {
+	r       *bufio.Reader
+	from    *parser.CopyFrom
+	columns int
+	lines   int64
+}
+
+func (d *textCopyDecoder) line() int64 { return d.lines }
+
+func (d *textCopyDecoder) next() ([]interface{}, error) {
+	line, err := d.r.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return nil, io.EOF
+	} else if err != nil && err != io.EOF {
+		return nil, err
+	}
+	d.lines++
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	if string(line) == `\.` {
+		return nil, io.EOF
+	}
+
+	values := make([]interface{}, 0, d.columns)
+	for _, field := range splitTextRow(line, d.from.Delimiter) {
+		if string(field) == d.from.Null {
+			values = append(values, nil)
+		} else {
+			values = append(values, unescapeText(field))
+		}
+	}
+	return values, checkCopyColumns(len(values), d.columns)
+}
+
+// Splits a row of the text format at the delimiters that aren't escaped.
+func splitTextRow(line []byte, delimiter byte) [][]byte {
+	var fields [][]byte
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case delimiter:
+			fields = append(fields, line[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, line[start:])
+}
+
+// Returns the value of a field of the text format, with its backslash escapes replaced.
+func unescapeText(field []byte) string {
+	if bytes.IndexByte(field, '\\') < 0 {
+		return string(field)
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' || i+1 == len(field) {
+			b.WriteByte(field[i])
+			continue
+		}
+		i++
+		switch c := field[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			// One or two hex digits.
+			n, digits := 0, 0
+			for digits < 2 && i+1 < len(field) && isHexDigit(field[i+1]) {
+				i++
+				n = n*16 + hexValue(field[i])
+				digits++
+			}
+			if digits == 0 {
+				b.WriteByte('x')
+			} else {
+				b.WriteByte(byte(n))
+			}
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// One to three octal digits.
+			n := int(c - '0')
+			for digits := 1; digits < 3 && i+1 < len(field) && field[i+1] >= '0' && field[i+1] <= '7'; digits++ {
+				i++
+				n = n*8 + int(field[i]-'0')
+			}
+			b.WriteByte(byte(n))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= 'a':
+		return int(c-'a') + 10
+	case c >= 'A':
+		return int(c-'A') + 10
+	}
+	return int(c - '0')
+}
+
+// Decodes rows of the CSV format. Unquoted values matching the NULL string are NULL, quoted
+// values may hold delimiters and line breaks.
+type csvCopyDecoder struct {
+	r       *bufio.Reader
+	from    *parser.CopyFrom
+	columns int
+	lines   int64
+}
+
+func (d *csvCopyDecoder) line() int64 { return d.lines }
+
+func (d *csvCopyDecoder) next() ([]interface{}, error) {
+	var values []interface{}
+	var field []byte
+	quoted, inQuotes, started := false, false, false
+	endField := func() {
+		if !quoted && string(field) == d.from.Null {
+			values = append(values, nil)
+		} else {
+			values = append(values, string(field))
+		}
+		field, quoted = field[:0], false
+	}
+	d.lines++
+	for {
+		b, err := d.r.ReadByte()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, pgerror.New(pgerror.BadCopyFileFormat, "unterminated CSV quoted field")
+			} else if !started {
+				return nil, io.EOF
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		started = true
+
+		if inQuotes {
+			switch {
+			case b == d.from.Escape && d.from.Escape != d.from.Quote:
+				if next, err := d.r.Peek(1); err == nil && (next[0] == d.from.Quote || next[0] == d.from.Escape) {
+					d.r.ReadByte()
+					b = next[0]
+				}
+				field = append(field, b)
+			case b == d.from.Quote:
+				if next, err := d.r.Peek(1); err == nil && next[0] == d.from.Quote && d.from.Escape == d.from.Quote {
+					d.r.ReadByte()
+					field = append(field, b)
+				} else {
+					inQuotes = false
+				}
+			default:
+				if b == '\n' {
+					d.lines++
+				}
+				field = append(field, b)
+			}
+			continue
+		}
+
+		switch b {
+		case d.from.Quote:
+			inQuotes, quoted = true, true
+		case d.from.Delimiter:
+			endField()
+		case '\r':
+			if next, err := d.r.Peek(1); err == nil && next[0] == '\n' {
+				continue
+			}
+			field = append(field, b)
+		case '\n':
+			goto end
+		default:
+			field = append(field, b)
+		}
+	}
+end:
+	if len(values) == 0 && !quoted && string(field) == `\.` {
+		return nil, io.EOF
+	}
+	endField()
+	return values, checkCopyColumns(len(values), d.columns)
+}
+
+// Decodes rows of the binary format: the values of each row are in the binary format of
+// their column type, prefixed by their length.
+type binaryCopyDecoder struct {
+	r    *bufio.Reader
+	oids []uint32
+	rows int64
+}
+
+func newBinaryCopyDecoder(r *bufio.Reader, oids []uint32) (*binaryCopyDecoder, error) {
+	header := make([]byte, len(copyBinarySignature)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, copyReadError(err)
+	} else if !bytes.Equal(header[:len(copyBinarySignature)], copyBinarySignature) {
+		return nil, pgerror.New(pgerror.BadCopyFileFormat, "COPY file signature not recognized")
+	}
+	extension := binary.BigEndian.Uint32(header[len(copyBinarySignature)+4:])
+	if _, err := r.Discard(int(extension)); err != nil {
+		return nil, copyReadError(err)
+	}
+	return &binaryCopyDecoder{r: r, oids: oids}, nil
+}
+
+func (d *binaryCopyDecoder) line() int64 { return d.rows }
+
+func (d *binaryCopyDecoder) next() ([]interface{}, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:2]); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, copyReadError(err)
+	}
+	count := int16(binary.BigEndian.Uint16(size[:2]))
+	if count == -1 {
+		return nil, io.EOF
+	}
+	d.rows++
+	if err := checkCopyColumns(int(count), len(d.oids)); err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, count)
+	for i := range values {
+		if _, err := io.ReadFull(d.r, size[:]); err != nil {
+			return nil, copyReadError(err)
+		}
+		n := int32(binary.BigEndian.Uint32(size[:]))
+		if n < 0 {
+			continue
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(d.r, data); err != nil {
+			return nil, copyReadError(err)
+		}
+		value, err := decodeParam(data, d.oids[i], BinaryFormat)
+		if err != nil {
+			return nil, pgerror.Newf(pgerror.BadCopyFileFormat, "invalid binary value of column %d: %s", i+1, err)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Returns the error of a read of binary COPY data, failures of the client are returned
+// as is.
+func copyReadError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return pgerror.New(pgerror.BadCopyFileFormat, "unexpected EOF in COPY data")
+	}
+	return err
+}
+
+// Checks the number of values of a row of COPY data.
+func checkCopyColumns(values, columns int) error {
+	switch {
+	case values > columns:
+		return pgerror.New(pgerror.BadCopyFileFormat, "extra data after last expected column")
+	case values < columns:
+		return pgerror.Newf(pgerror.BadCopyFileFormat, "missing data for column %d", values+1)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("COPY", func() {
+	ctx := context.Background()
+
+	exec := func(conn *pgx.Conn, stmts ...string) {
+		for _, stmt := range stmts {
+			_, err := conn.Exec(ctx, stmt)
+			Expect(err).NotTo(HaveOccurred(), stmt)
+		}
+	}
+	rows := func(conn *pgx.Conn, sql string) []string {
+		rows, err := conn.Query(ctx, sql)
+		Expect(err).NotTo(HaveOccurred())
+		values, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		Expect(err).NotTo(HaveOccurred())
+		return values
+	}
+
+	It("Loads rows of pgx CopyFrom", func() {
+		conn := connect(ctx, "copy.db")
+		defer conn.Close(ctx)
+		exec(conn, `CREATE TABLE items (id int PRIMARY KEY, name text, price real)`)
+
+		n, err := conn.CopyFrom(ctx, pgx.Identifier{"items"}, []string{"id", "name", "price"}, pgx.CopyFromRows([][]any{
+			{1, "apple", 1.5},
+			{2, nil, 2.25},
+			{3, "tab\there", nil},
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(BeEquivalentTo(3))
+		Expect(rows(conn, `SELECT id || ':' || coalesce(name, 'null') || ':' || coalesce(price, 'null') FROM items ORDER BY id`)).To(Equal([]string{
+			"1:apple:1.5", "2:null:2.25", "3:tab\there:null",
+		}))
+
+		// Bulk loads copy all rows in a single statement.
+		const count = 100000
+		n, err = conn.CopyFrom(ctx, pgx.Identifier{"items"}, []string{"id", "name"}, pgx.CopyFromSlice(count, func(i int) ([]any, error) {
+			return []any{i + 10, fmt.Sprintf("item %d", i)}, nil
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(BeEquivalentTo(count))
+		Expect(rows(conn, `SELECT count(*) FROM items`)).To(Equal([]string{fmt.Sprint(count + 3)}))
+	})
+
+	It("Loads text and CSV data", func() {
+		conn := connect(ctx, "copy.db")
+		defer conn.Close(ctx)
+		exec(conn, `CREATE TABLE notes (id int, body text)`)
+
+		tag, err := conn.PgConn().CopyFrom(ctx, strings.NewReader("1\tfirst\\nline\n2\t\\N\n\\.\n"), `COPY notes FROM STDIN`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("COPY 2"))
+
+		csv := "id,body\n3,\"quoted, with \"\"quotes\"\"\"\n4,\n5,\"\"\n"
+		tag, err = conn.PgConn().CopyFrom(ctx, strings.NewReader(csv), `COPY notes (id, body) FROM STDIN (FORMAT csv, HEADER)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("COPY 3"))
+
+		Expect(rows(conn, `SELECT id || '=' || coalesce(body, 'null') FROM notes ORDER BY id`)).To(Equal([]string{
+			"1=first\nline", "2=null", `3=quoted, with "quotes"`, "4=null", "5=",
+		}))
+	})
+
+	It("Copies no rows when a row fails", func() {
+		conn := connect(ctx, "copy.db")
+		defer conn.Close(ctx)
+		exec(conn, `CREATE TABLE codes (code text PRIMARY KEY)`)
+
+		_, err := conn.PgConn().CopyFrom(ctx, strings.NewReader("a\nb\na\nc\n"), `COPY codes FROM STDIN`)
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue(), fmt.Sprint(err))
+		Expect(pgErr.Code).To(Equal("23505"))
+		Expect(pgErr.Where).To(Equal("COPY codes, line 3"))
+
+		_, err = conn.PgConn().CopyFrom(ctx, strings.NewReader("d\te\n"), `COPY codes FROM STDIN`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue(), fmt.Sprint(err))
+		Expect(pgErr.Code).To(Equal("22P04"))
+
+		Expect(rows(conn, `SELECT count(*) FROM codes`)).To(Equal([]string{"0"}))
+
+		// The session is usable after failed copies.
+		exec(conn, `INSERT INTO codes VALUES ('z')`)
+		Expect(rows(conn, `SELECT code FROM codes`)).To(Equal([]string{"z"}))
+	})
+})
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Cursor declared with DECLARE CURSOR, rows are read from SQLite as they're fetched.
+type cursor struct {
+	name  string
+	rows  *sql.Rows
+	desc  *pgproto3.RowDescription
+	dates []bool // Result columns of DATE type.
+
+	pending bool // The current row of rows wasn't fetched yet.
+}
+
+// Advances to the next row of the cursor.
+func (cur *cursor) next() bool {
+	if cur.pending {
+		cur.pending = false
+		return true
+	}
+	return cur.rows.Next()
+}
+
+// Executes cursor statements, the response is appended to buf. The portal is
+// set when executed with the extended query protocol and nil for simple queries.
+// Returns false if the statement is not a cursor statement.
+func (s *Server) execCursorStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, p *portal, buf []byte) ([]byte, bool, error) {
+	var count int64
+	switch stmt.Command {
+	case parser.CmdCommit, parser.CmdRollback:
+		// Cursors don't outlive their transaction, the statement itself is executed by SQLite.
+		c.closeCursors()
+		return buf, false, nil
+	case parser.CmdDeclareCursor:
+		var args []interface{}
+		if p != nil {
+			args = p.args
+		}
+		if err := c.declareCursor(ctx, stmt, args); err != nil {
+			return buf, true, err
+		}
+	case parser.CmdFetch, parser.CmdMove:
+		if stmt.Scroll {
+			return buf, true, fmt.Errorf("cursor can only scan forward")
+		}
+		cur, ok := c.cursors[stmt.Name]
+		if !ok {
+			return buf, true, fmt.Errorf("cursor %q does not exist", stmt.Name)
+		}
+
+		desc := cur.desc
+		if p != nil {
+			desc = withResultFormats(desc, p.resultFormats)
+		} else if stmt.Command == parser.CmdFetch {
+			buf, _ = desc.Encode(buf)
+		}
+
+		var err error
+		if buf, count, err = cur.fetch(c, buf, desc.Fields, stmt.FetchRows, stmt.Command == parser.CmdMove); err != nil {
+			return buf, true, err
+		}
+	case parser.CmdCloseCursor:
+		if stmt.Name == "" {
+			c.closeCursors()
+		} else if _, ok := c.cursors[stmt.Name]; !ok {
+			return buf, true, fmt.Errorf("cursor %q does not exist", stmt.Name)
+		} else {
+			c.closeCursor(stmt.Name)
+		}
+	default:
+		return buf, false, nil
+	}
+
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(count))}).Encode(buf)
+	return buf, true, nil
+}
+
+// Opens a cursor on the query of a DECLARE CURSOR statement.
+func (c *Conn) declareCursor(ctx context.Context, stmt parser.StmtClass, args []interface{}) error {
+	if _, exists := c.cursors[stmt.Name]; exists {
+		return fmt.Errorf("cursor %q already exists", stmt.Name)
+	}
+
+	// Cursors are closed at the end of the transaction, as WITHOUT HOLD cursors are.
+	if inTx, err := sqlite.InTransaction(c.conn); err != nil {
+		return err
+	} else if !inTx {
+		return fmt.Errorf("DECLARE CURSOR can only be used in transaction blocks")
+	}
+
+	query, err := parser.DeclareCursorQuery(stmt.SQL)
+	if err != nil {
+		return err
+	}
+	// The rows outlive the DECLARE statement, its cancellation doesn't close them.
+	rows, err := c.conn.QueryContext(context.WithoutCancel(ctx), parser.RewriteQuery(query), args...)
+	if err != nil {
+		return err
+	}
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return fmt.Errorf("column types: %w", err)
+	}
+
+	c.cursors[stmt.Name] = &cursor{name: stmt.Name, rows: rows, desc: toRowDescription(cols), dates: dateColumns(cols)}
+	return nil
+}
+
+// Closes a cursor and releases its rows.
+func (c *Conn) closeCursor(name string) {
+	if cur, ok := c.cursors[name]; ok {
+		cur.rows.Close()
+		delete(c.cursors, name)
+	}
+}
+
+// Closes all cursors of the connection.
+func (c *Conn) closeCursors() {
+	for name := range c.cursors {
+		c.closeCursor(name)
+	}
+}
+
+// Fetches up to count rows from the cursor, all remaining rows if count is negative.
+// Rows are encoded to buf in the formats of fields, with times in the time zone of the
+// session, or skipped when moving. Fetched rows are subject to the row limit of the
+// session, rows over the limit are left for the next fetch when truncated.
+func (cur *cursor) fetch(c *Conn, buf []byte, fields []pgproto3.FieldDescription, count int64, move bool) ([]byte, int64, error) {
+	var n int64
+	var enc *rowEncoder
+	if !move {
+		enc = newRowEncoder(fields, cur.dates, c.location)
+		defer func() { c.rowsRead.Add(n) }()
+	}
+	for (count < 0 || n < count) && cur.next() {
+		if !move {
+			if truncated, err := c.rowLimitReached(n); err != nil || truncated {
+				// The row is left for the next fetch.
+				cur.pending = true
+				if err == nil {
+					buf = c.appendTruncated(buf)
+				}
+				return buf, n, err
+			}
+			var err error
+			if buf, err = enc.appendRow(buf, cur.rows); err != nil {
+				return buf, n, err
+			}
+		}
+		n++
+	}
+	if err := cur.rows.Err(); err != nil {
+		return buf, n, fmt.Errorf("rows: %w", err)
+	}
+	return buf, n, nil
+}
@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cursors", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	// Executes a query with the simple protocol, as psql does.
+	simpleQuery := func(sql string) []*pgconn.Result {
+		results, err := conn.PgConn().Exec(ctx, sql).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+		return results
+	}
+
+	BeforeAll(func() {
+		conn = connect(ctx, "cursor.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 250)
+			INSERT INTO items (id, name) SELECT i, 'item' || i FROM n`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Fetches results in chunks like psql with FETCH_COUNT", func() {
+		simpleQuery(`BEGIN`)
+		simpleQuery("DECLARE _psql_cursor NO SCROLL CURSOR FOR\nSELECT id, name FROM items ORDER BY id")
+
+		var ids []string
+		for {
+			results := simpleQuery(`FETCH FORWARD 100 FROM _psql_cursor`)
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].FieldDescriptions).To(HaveLen(2))
+			for _, row := range results[0].Rows {
+				ids = append(ids, string(row[0]))
+			}
+			if len(results[0].Rows) < 100 {
+				Expect(results[0].CommandTag.String()).To(Equal("FETCH 50"))
+				break
+			}
+		}
+		Expect(ids).To(HaveLen(250))
+		Expect(ids[0]).To(Equal("1"))
+		Expect(ids[249]).To(Equal("250"))
+
+		simpleQuery(`CLOSE _psql_cursor`)
+		simpleQuery(`COMMIT`)
+	})
+
+	It("Moves and fetches remaining rows", func() {
+		results := simpleQuery(`BEGIN;
+			DECLARE c CURSOR FOR SELECT id FROM items WHERE id > 200 ORDER BY id;
+			MOVE 10 FROM c;
+			FETCH ALL FROM c;
+			COMMIT`)
+		Expect(results).To(HaveLen(5))
+		Expect(results[2].CommandTag.String()).To(Equal("MOVE 10"))
+		Expect(results[3].CommandTag.String()).To(Equal("FETCH 40"))
+		Expect(string(results[3].Rows[0][0])).To(Equal("211"))
+
+		// The cursor was closed at the end of the transaction.
+		_, err := conn.PgConn().Exec(ctx, `FETCH NEXT FROM c`).ReadAll()
+		Expect(err).To(MatchError(ContainSubstring(`cursor "c" does not exist`)))
+	})
+
+	It("Rejects cursors outside of transaction blocks and backward scans", func() {
+		_, err := conn.PgConn().Exec(ctx, `DECLARE c CURSOR FOR SELECT id FROM items`).ReadAll()
+		Expect(err).To(MatchError(ContainSubstring("transaction blocks")))
+
+		_, err = conn.PgConn().Exec(ctx, `BEGIN; DECLARE c CURSOR FOR SELECT id FROM items; FETCH PRIOR FROM c`).ReadAll()
+		Expect(err).To(MatchError(ContainSubstring("cursor can only scan forward")))
+		simpleQuery(`ROLLBACK`)
+	})
+
+	It("Fetches with the extended protocol", func() {
+		tx, err := conn.Begin(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer tx.Rollback(ctx)
+
+		_, err = tx.Exec(ctx, `DECLARE ext CURSOR FOR SELECT id, name FROM items WHERE id <= $1 ORDER BY id`, 5)
+		Expect(err).NotTo(HaveOccurred())
+
+		var names []string
+		rows, err := tx.Query(ctx, `FETCH 3 FROM ext`)
+		Expect(err).NotTo(HaveOccurred())
+		for rows.Next() {
+			var id int64
+			var name string
+			Expect(rows.Scan(&id, &name)).To(Succeed())
+			names = append(names, name)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"item1", "item2", "item3"}))
+	})
+})
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Executes CREATE DATABASE, the response is appended to buf. Returns false if the statement
+// is not a CREATE DATABASE statement.
+func (s *Server) execDatabaseStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, buf []byte) ([]byte, bool, error) {
+	if stmt.Command != "CREATE DATABASE" {
+		return buf, false, nil
+	}
+	if c.xact != nil {
+		return buf, true, pgerror.New(pgerror.ActiveSQLTransaction, "CREATE DATABASE cannot run inside a transaction block")
+	}
+	name, template, err := parser.CreateDatabase(stmt.SQL)
+	if err == nil {
+		err = s.createDatabase(ctx, c, name, template)
+	}
+	if err != nil {
+		return buf, true, err
+	}
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(0))}).Encode(buf)
+	return buf, true, nil
+}
+
+// Creates a database file, a copy of the template database and its schemas if any. Databases
+// are created empty without template or from template0 and template1, they run the init
+// scripts when first opened then.
+func (s *Server) createDatabase(ctx context.Context, c *Conn, name, template string) error {
+	switch template {
+	case "template0", "template1":
+		template = ""
+	}
+	for _, db := range []string{name, template} {
+		if db == "" {
+			continue
+		} else if strings.Contains(db, "..") || strings.ContainsAny(db, `/\`) {
+			return pgerror.Newf(pgerror.InvalidCatalogName, "invalid database name %q", db)
+		} else if c.listener != nil && !c.listener.allows(db) {
+			return pgerror.Newf(pgerror.InsufficientPrivilege, "database %q is not available on this listener", db)
+		}
+	}
+	path := s.databasePath(name)
+	if _, err := os.Stat(path); err == nil {
+		return pgerror.Newf(pgerror.DuplicateDatabase, "database %q already exists", name)
+	}
+
+	if template == "" {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			return pgerror.Newf(pgerror.DuplicateDatabase, "database %q already exists", name)
+		} else if err != nil {
+			return err
+		}
+		f.Close()
+	} else if !isDatabaseFile(s.databasePath(template)) {
+		return pgerror.Newf(pgerror.InvalidCatalogName, "template database %q does not exist", template)
+	} else if err := s.copyDatabase(ctx, template, name); err != nil {
+		return fmt.Errorf("copy template database %q: %w", template, err)
+	}
+
+	// The database is listed by the system schema once created.
+	if err := s.refreshSysDB(ctx); err != nil {
+		log.Printf("system schema refresh: %s", err)
+	}
+	return nil
+}
+
+// Copies a database and its schemas with the online backup API, the copies hold the changes
+// committed to the WAL of the template too, as if it was checkpointed. Sessions of the
+// template keep writing while it's copied. The copies are removed if one fails.
+func (s *Server) copyDatabase(ctx context.Context, template, name string) (err error) {
+	defer func() {
+		if err != nil {
+			os.RemoveAll(s.schemaDir(name))
+		}
+	}()
+	if err := sqlite.Backup(ctx, s.databasePath(template), s.databasePath(name), sqlite.BackupOptions{}); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(s.databasePath(name))
+		}
+	}()
+
+	entries, err := os.ReadDir(s.schemaDir(template))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		schema := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasSuffix(schema, "-wal") ||
+			strings.HasSuffix(schema, "-shm") || strings.HasSuffix(schema, "-journal") {
+			continue
+		}
+		if err := os.MkdirAll(s.schemaDir(name), 0o755); err != nil {
+			return err
+		}
+		src, dst := filepath.Join(s.schemaDir(template), schema), filepath.Join(s.schemaDir(name), schema)
+		if err := sqlite.Backup(ctx, src, dst, sqlite.BackupOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
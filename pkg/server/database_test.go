@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Databases", func() {
+	ctx := context.Background()
+
+	query := func(conn *pgx.Conn, sql string) string {
+		var value string
+		Expect(conn.QueryRow(ctx, sql).Scan(&value)).To(Succeed(), sql)
+		return value
+	}
+	exec := func(conn *pgx.Conn, stmts ...string) {
+		for _, stmt := range stmts {
+			_, err := conn.Exec(ctx, stmt)
+			Expect(err).NotTo(HaveOccurred(), stmt)
+		}
+	}
+	pgError := func(err error) *pgconn.PgError {
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue(), fmt.Sprint(err))
+		return pgErr
+	}
+
+	It("Creates databases from templates", func() {
+		tmpl := connect(ctx, "tenant.db")
+		defer tmpl.Close(ctx)
+		exec(tmpl,
+			`PRAGMA journal_mode = WAL`,
+			`CREATE SCHEMA billing`,
+			`CREATE TABLE plans (id INTEGER PRIMARY KEY, name TEXT)`,
+			`INSERT INTO plans (name) VALUES ('free'), ('pro')`,
+			`CREATE TABLE billing.invoices (id INTEGER PRIMARY KEY, total INTEGER)`,
+			`INSERT INTO billing.invoices (total) VALUES (42)`,
+		)
+
+		// Changes still in the WAL of the template are copied.
+		exec(tmpl, `CREATE DATABASE "tenant1.db" TEMPLATE "tenant.db"`)
+		exec(tmpl, `INSERT INTO plans (name) VALUES ('enterprise')`)
+
+		conn := connect(ctx, "tenant1.db")
+		defer conn.Close(ctx)
+		Expect(query(conn, `SELECT group_concat(name, ',') FROM plans`)).To(Equal("free,pro"))
+		Expect(query(conn, `SELECT total FROM billing.invoices`)).To(Equal("42"))
+		Expect(query(conn, `SELECT count(*) FROM kqlite.databases WHERE name = 'tenant1.db'`)).To(Equal("1"))
+
+		_, err := conn.Exec(ctx, `CREATE DATABASE "tenant1.db" TEMPLATE "tenant.db"`)
+		Expect(pgError(err).Code).To(Equal("42P04"))
+		_, err = conn.Exec(ctx, `CREATE DATABASE "tenant2.db" TEMPLATE "missing.db"`)
+		Expect(pgError(err).Code).To(Equal("3D000"))
+	})
+
+	It("Creates empty databases without templates", func() {
+		conn := connect(ctx, "creator.db")
+		defer conn.Close(ctx)
+		exec(conn, `CREATE DATABASE "empty.db" TEMPLATE template0`)
+
+		empty := connect(ctx, "empty.db")
+		defer empty.Close(ctx)
+		Expect(query(empty, `SELECT count(*) FROM sqlite_master`)).To(Equal("0"))
+
+		tx, err := conn.Begin(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer tx.Rollback(ctx)
+		_, err = tx.Exec(ctx, `CREATE DATABASE "other.db"`)
+		Expect(pgError(err).Code).To(Equal("25001"))
+	})
+})
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Lock file of the data directory, holds the process ID of the server holding the lock.
+// It's not an SQLite file so it isn't listed as a database.
+const dataDirLockName = ".kqlite.lock"
+
+// Interval between attempts to take the lock of the data directory held by another server.
+const dataDirLockInterval = 50 * time.Millisecond
+
+// Takes the lock of the data directory, waiting up to DataDirLockTimeout for the server
+// holding it to close.
+func (s *Server) lockDataDir() (err error) {
+	s.dataDirLock, err = LockDataDir(s.DataDir, s.DataDirLockTimeout)
+	return err
+}
+
+// LockDataDir takes the lock of a data directory servers hold while they're open, waiting up
+// to timeout for the process holding it to release it. Tools opening the databases of the
+// directory without a server hold it too. The lock is released by closing the returned file,
+// or when the process exits, even if killed.
+func LockDataDir(dir string, timeout time.Duration) (*os.File, error) {
+	path := filepath.Join(dir, dataDirLockName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		} else if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("lock data directory: %w", err)
+		} else if time.Now().After(deadline) {
+			pid, _ := os.ReadFile(path)
+			f.Close()
+			return nil, fmt.Errorf("data directory %s is locked by process %s", dir, strings.TrimSpace(string(pid)))
+		}
+		time.Sleep(dataDirLockInterval)
+	}
+
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Releases the lock of the data directory, the lock file is left for the next server.
+func (s *Server) unlockDataDir() error {
+	if s.dataDirLock == nil {
+		return nil
+	}
+	err := s.dataDirLock.Close()
+	s.dataDirLock = nil
+	return err
+}
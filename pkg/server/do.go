@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Executes a DO statement, its statements are executed atomically. The response is
+// appended to buf. Returns false if the statement is not a DO statement.
+func (s *Server) execDoStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, buf []byte) ([]byte, bool, error) {
+	if stmt.Command != "DO" {
+		return buf, false, nil
+	}
+	block, err := parser.ParseDo(stmt.SQL)
+	if err != nil {
+		return buf, true, err
+	}
+	if err := c.atomically(ctx, func() error { return s.execDoBlock(ctx, c, block) }); err != nil {
+		return buf, true, err
+	}
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(0))}).Encode(buf)
+	return buf, true, nil
+}
+
+// Executes the statements of a block of a DO statement. Errors handled by the exception
+// handlers of the block roll back its statements and execute those of the handler.
+func (s *Server) execDoBlock(ctx context.Context, c *Conn, block *parser.DoBlock) error {
+	if len(block.Handlers) == 0 {
+		return s.execDoStmts(ctx, c, block.Stmts)
+	}
+	err := c.atomically(ctx, func() error { return s.execDoStmts(ctx, c, block.Stmts) })
+	if err == nil {
+		return nil
+	}
+	code := pgerror.Convert(err).Code
+	for _, handler := range block.Handlers {
+		if handler.Handles(code) {
+			return s.execDoStmts(ctx, c, handler.Stmts)
+		}
+	}
+	return err
+}
+
+// Executes statements of a DO statement as statements of the session, their results
+// are discarded.
+func (s *Server) execDoStmts(ctx context.Context, c *Conn, stmts []parser.DoStmt) error {
+	for _, stmt := range stmts {
+		if stmt.Block != nil {
+			if err := s.execDoBlock(ctx, c, stmt.Block); err != nil {
+				return err
+			}
+			continue
+		}
+		classes, err := parser.ClassifyQuery(stmt.SQL)
+		if err != nil {
+			return err
+		}
+		for _, class := range classes {
+			if _, err := s.execStmt(ctx, c, class, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
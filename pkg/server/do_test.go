@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DO blocks", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	count := func(query string) string {
+		var n string
+		Expect(conn.QueryRow(ctx, query).Scan(&n)).To(Succeed())
+		return n
+	}
+
+	BeforeAll(func() {
+		conn = connect(ctx, "do.db")
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Executes the statements of DO blocks atomically", func() {
+		_, err := conn.Exec(ctx, `DO $$ BEGIN
+			CREATE TABLE posts (id integer PRIMARY KEY, title text NOT NULL);
+			INSERT INTO posts VALUES (1, 'first');
+		END $$`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count(`SELECT count(*) FROM posts`)).To(Equal("1"))
+
+		_, err = conn.Exec(ctx, `DO $$ BEGIN
+			INSERT INTO posts VALUES (2, 'second');
+			INSERT INTO posts VALUES (3, NULL);
+		END $$`)
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("23502"))
+		Expect(count(`SELECT count(*) FROM posts`)).To(Equal("1"))
+	})
+
+	It("Ignores the errors handled by exception handlers", func() {
+		// As migration tools create objects that may exist.
+		migration := `DO $$ BEGIN
+			CREATE TABLE authors (id integer PRIMARY KEY, name text);
+		EXCEPTION WHEN duplicate_table THEN null;
+		END $$`
+		_, err := conn.Exec(ctx, migration)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, migration)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Handled errors roll back the statements of their block.
+		_, err = conn.Exec(ctx, `DO $$ BEGIN
+			INSERT INTO posts VALUES (4, 'fourth');
+			BEGIN
+				INSERT INTO posts VALUES (5, 'fifth');
+				INSERT INTO posts VALUES (1, 'duplicate');
+			EXCEPTION WHEN unique_violation THEN
+				INSERT INTO posts VALUES (6, 'handled');
+			END;
+		END $$`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count(`SELECT group_concat(id, ',') FROM posts`)).To(Equal("1,4,6"))
+
+		_, err = conn.Exec(ctx, `DO $$ BEGIN
+			INSERT INTO posts VALUES (1, 'duplicate');
+		EXCEPTION WHEN not_null_violation THEN null;
+		END $$`)
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("23505"))
+	})
+
+	It("Rejects PL/pgSQL control flow", func() {
+		_, err := conn.Exec(ctx, `DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM posts) THEN DELETE FROM authors; END IF;
+		END $$`)
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+		Expect(pgErr.Message).To(ContainSubstring("IF is not supported in DO blocks"))
+		Expect(pgErr.Hint).NotTo(BeEmpty())
+	})
+})
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Wire protocol format codes.
+const (
+	TextFormat   int16 = 0
+	BinaryFormat int16 = 1
+)
+
+// Type registry used for encoding and decoding values in binary format.
+var connInfo = pgtype.NewConnInfo()
+
+// formatCode returns the format code of the column or parameter at index idx.
+// Per protocol spec the list of codes can be empty (all text), contain a single
+// code applied to all columns or exactly one code per column.
+func formatCode(codes []int16, idx int) int16 {
+	switch len(codes) {
+	case 0:
+		return TextFormat
+	case 1:
+		return codes[0]
+	}
+	return codes[idx]
+}
+
+// Validates that the number of format codes matches the spec for count columns or parameters.
+func checkFormatCodes(codes []int16, count int) error {
+	if len(codes) > 1 && len(codes) != count {
+		return fmt.Errorf("got %d format codes for %d columns or parameters", len(codes), count)
+	}
+	for _, code := range codes {
+		if code != TextFormat && code != BinaryFormat {
+			return fmt.Errorf("unsupported format code: %d", code)
+		}
+	}
+	return nil
+}
+
+func toRowDescription(cols []*sql.ColumnType) *pgproto3.RowDescription {
+	var desc pgproto3.RowDescription
+	for _, col := range cols {
+		var typeOID uint32
+		dbType := col.DatabaseTypeName()
+		if pgColType, exists := sqlite.Typemap()[dbType]; exists {
+			typeOID = pgColType
+		} else {
+			typeOID = pgtype.TextOID
+		}
+
+		typeSize, ok := col.Length()
+		if !ok {
+			typeSize = -1
+		}
+
+		desc.Fields = append(desc.Fields, pgproto3.FieldDescription{
+			Name:                 []byte(col.Name()),
+			TableOID:             0,
+			TableAttributeNumber: 0,
+			DataTypeOID:          typeOID,
+			DataTypeSize:         int16(typeSize),
+			TypeModifier:         -1,
+			Format:               TextFormat,
+		})
+	}
+	return &desc
+}
+
+// Returns a copy of the row description with the result format codes applied.
+func withResultFormats(desc *pgproto3.RowDescription, codes []int16) *pgproto3.RowDescription {
+	result := pgproto3.RowDescription{Fields: make([]pgproto3.FieldDescription, len(desc.Fields))}
+	for i, field := range desc.Fields {
+		field.Format = formatCode(codes, i)
+		result.Fields[i] = field
+	}
+	return &result
+}
+
+// Reports which result columns are declared as DATE, their values are rendered
+// as dates rather than timestamps.
+func dateColumns(cols []*sql.ColumnType) []bool {
+	dates := make([]bool, len(cols))
+	for i, col := range cols {
+		dates[i] = strings.EqualFold(col.DatabaseTypeName(), "DATE")
+	}
+	return dates
+}
+
+// Response buffers are pooled, buffers grown over maxPooledBuffer by large results aren't kept.
+const maxPooledBuffer = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+// Returns an empty response buffer of the pool.
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+// Returns a response buffer to the pool once it's written, buf holds its last contents.
+func putBuffer(b *[]byte, buf []byte) {
+	if cap(buf) > maxPooledBuffer {
+		return
+	}
+	*b = buf[:0]
+	bufferPool.Put(b)
+}
+
+// Scans the rows of a result and encodes them in the formats of fields. Times are rendered
+// in the time zone loc, except those of the columns flagged in dates. The scan destinations,
+// values and text of a row are reused by the next one, rows are appended to the response
+// buffer as they're encoded.
+type rowEncoder struct {
+	fields []pgproto3.FieldDescription
+	dates  []bool
+	loc    *time.Location
+
+	refs   []interface{}
+	values []interface{}
+	row    pgproto3.DataRow
+	text   []byte // Text format values of the row.
+	ends   []int  // End offsets in text of the values, -1 for values not in text.
+}
+
+func newRowEncoder(fields []pgproto3.FieldDescription, dates []bool, loc *time.Location) *rowEncoder {
+	e := &rowEncoder{
+		fields: fields,
+		dates:  dates,
+		loc:    loc,
+		refs:   make([]interface{}, len(fields)),
+		values: make([]interface{}, len(fields)),
+		row:    pgproto3.DataRow{Values: make([][]byte, len(fields))},
+		ends:   make([]int, len(fields)),
+	}
+	for i := range e.refs {
+		e.refs[i] = &e.values[i]
+	}
+	return e
+}
+
+// Scans the current row and appends its DataRow message to buf.
+func (e *rowEncoder) appendRow(buf []byte, rows *sql.Rows) ([]byte, error) {
+	// Scan from SQLite database.
+	if err := rows.Scan(e.refs...); err != nil {
+		return buf, fmt.Errorf("scan: %w", err)
+	}
+
+	// Values in text are sliced once it's complete, appending may move it.
+	e.text = e.text[:0]
+	for i, value := range e.values {
+		if t, ok := value.(time.Time); ok {
+			if i < len(e.dates) && e.dates[i] {
+				value = t.Format("2006-01-02")
+			} else {
+				value = sqlite.FormatTimestamp(t, e.loc)
+			}
+		}
+		var err error
+		if e.row.Values[i], e.text, err = appendValue(e.text, value, e.fields[i]); err != nil {
+			return buf, fmt.Errorf("column %q: %w", e.fields[i].Name, err)
+		}
+		e.ends[i] = -1
+		if e.row.Values[i] == nil && value != nil {
+			e.ends[i] = len(e.text)
+		}
+	}
+	start := 0
+	for i, end := range e.ends {
+		if end >= 0 {
+			e.row.Values[i] = e.text[start:end]
+			start = end
+		}
+	}
+	buf, _ = e.row.Encode(buf)
+	return buf, nil
+}
+
+// Encodes a single SQLite value according to the field type and format. Text values are
+// appended to text, their encoding is nil then and the new text is returned.
+func appendValue(text []byte, value interface{}, field pgproto3.FieldDescription) ([]byte, []byte, error) {
+	if value == nil {
+		return nil, text, nil
+	}
+	if field.Format != TextFormat && !textual(field) {
+		if appended, ok := appendBinary(text, value, field.DataTypeOID); ok {
+			return nil, appended, nil
+		}
+		encoded, err := encodeValue(value, field)
+		return encoded, text, err
+	}
+	switch v := value.(type) {
+	case []byte:
+		return v, text, nil
+	case string:
+		return nil, append(text, v...), nil
+	case int64:
+		return nil, strconv.AppendInt(text, v, 10), nil
+	case float64:
+		return nil, strconv.AppendFloat(text, v, 'g', -1, 64), nil
+	case bool:
+		return nil, strconv.AppendBool(text, v), nil
+	}
+	encoded, err := encodeValue(value, field)
+	return encoded, text, err
+}
+
+// Appends the binary format of the common numeric values, false for other values, which
+// are encoded by the type registry.
+func appendBinary(text []byte, value interface{}, oid uint32) ([]byte, bool) {
+	switch v := value.(type) {
+	case int64:
+		switch {
+		case oid == pgtype.Int8OID:
+			return binary.BigEndian.AppendUint64(text, uint64(v)), true
+		case oid == pgtype.Int4OID && v >= math.MinInt32 && v <= math.MaxInt32:
+			return binary.BigEndian.AppendUint32(text, uint32(v)), true
+		case oid == pgtype.Int2OID && v >= math.MinInt16 && v <= math.MaxInt16:
+			return binary.BigEndian.AppendUint16(text, uint16(v)), true
+		case oid == pgtype.Float8OID:
+			return binary.BigEndian.AppendUint64(text, math.Float64bits(float64(v))), true
+		}
+	case float64:
+		if oid == pgtype.Float8OID {
+			return binary.BigEndian.AppendUint64(text, math.Float64bits(v)), true
+		}
+	case bool:
+		if oid == pgtype.BoolOID && v {
+			return append(text, 1), true
+		} else if oid == pgtype.BoolOID {
+			return append(text, 0), true
+		}
+	}
+	return text, false
+}
+
+// Reports whether the binary format of the field type is the same as the text format.
+func textual(field pgproto3.FieldDescription) bool {
+	return field.DataTypeOID == pgtype.TextOID || field.DataTypeOID == pgtype.VarcharOID ||
+		field.DataTypeOID == pgtype.BPCharOID || field.DataTypeOID == pgtype.NameOID
+}
+
+// Encodes a single SQLite value according to the field type and format.
+func encodeValue(value interface{}, field pgproto3.FieldDescription) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	// Binary format of textual types is the same as the text format.
+	if field.Format == TextFormat || textual(field) {
+		switch v := value.(type) {
+		case []byte:
+			return v, nil
+		case time.Time:
+			return []byte(v.Format("2006-01-02 15:04:05.999999999Z07:00")), nil
+		}
+		return []byte(fmt.Sprint(value)), nil
+	}
+
+	dt, ok := connInfo.DataTypeForOID(field.DataTypeOID)
+	if !ok {
+		return nil, fmt.Errorf("no binary encoding for type OID %d", field.DataTypeOID)
+	}
+	pgvalue := pgtype.NewValue(dt.Value)
+	if err := pgvalue.Set(value); err != nil {
+		return nil, err
+	}
+	encoder, ok := pgvalue.(pgtype.BinaryEncoder)
+	if !ok {
+		return nil, fmt.Errorf("no binary encoding for type %s", dt.Name)
+	}
+	buf, err := encoder.EncodeBinary(connInfo, nil)
+	if err != nil {
+		return nil, err
+	}
+	if buf == nil {
+		buf = []byte{}
+	}
+	return buf, nil
+}
+
+// Decodes a bind parameter into a value usable as SQLite statement argument.
+func decodeParam(param []byte, oid uint32, format int16) (interface{}, error) {
+	if param == nil {
+		return nil, nil
+	}
+	if format == TextFormat {
+		return string(param), nil
+	}
+
+	dt, ok := connInfo.DataTypeForOID(oid)
+	if !ok {
+		return param, nil
+	}
+	pgvalue := pgtype.NewValue(dt.Value)
+	decoder, ok := pgvalue.(pgtype.BinaryDecoder)
+	if !ok {
+		return param, nil
+	}
+	if err := decoder.DecodeBinary(connInfo, param); err != nil {
+		return nil, err
+	}
+	return pgvalue.Get(), nil
+}
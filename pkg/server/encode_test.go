@@ -0,0 +1,90 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+// Read benchmarks of row encoding, allocations are counted on both sides of the connection.
+//
+//	go test ./pkg/server -run '^$' -bench QueryRows -benchmem
+//
+// Before pooling response buffers and reusing the scanned values of rows:
+//
+//	BenchmarkSimpleQueryRows     	     187	   5865529 ns/op	  671408 B/op	   17023 allocs/op
+//	BenchmarkExtendedQueryRows   	     213	   6037714 ns/op	  749363 B/op	   17839 allocs/op
+//
+// After:
+//
+//	BenchmarkSimpleQueryRows     	     264	   4220062 ns/op	  213375 B/op	    6022 allocs/op
+//	BenchmarkExtendedQueryRows   	     350	   3635423 ns/op	  201924 B/op	    5825 allocs/op
+
+// Rows of the benchmark query.
+const benchRows = 1000
+
+// Starts a server with a table of benchRows rows and connects to it.
+func benchConn(b *testing.B) *pgx.Conn {
+	b.Helper()
+	ctx := context.Background()
+
+	srv := server.NewServer()
+	srv.Addr = "127.0.0.1:0"
+	srv.DataDir = b.TempDir()
+	if err := srv.Open(); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { srv.Close() })
+
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/bench.db?sslmode=disable", srv.ListenerAddr()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close(ctx) })
+
+	for _, sql := range []string{
+		`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, price REAL, stock INTEGER)`,
+		fmt.Sprintf(`WITH RECURSIVE n (value) AS (SELECT 1 UNION ALL SELECT value + 1 FROM n WHERE value < %d)
+			INSERT INTO items SELECT value, 'item ' || value, value * 1.5, value %% 7 FROM n`, benchRows),
+	} {
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return conn
+}
+
+func benchmarkQueryRows(b *testing.B, mode pgx.QueryExecMode) {
+	ctx := context.Background()
+	conn := benchConn(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rows, err := conn.Query(ctx, `SELECT id, name, price, stock FROM items`, mode)
+		if err != nil {
+			b.Fatal(err)
+		}
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		if err := rows.Err(); err != nil {
+			b.Fatal(err)
+		} else if n != benchRows {
+			b.Fatalf("got %d rows", n)
+		}
+	}
+}
+
+func BenchmarkSimpleQueryRows(b *testing.B) {
+	benchmarkQueryRows(b, pgx.QueryExecModeSimpleProtocol)
+}
+
+func BenchmarkExtendedQueryRows(b *testing.B) {
+	benchmarkQueryRows(b, pgx.QueryExecModeCacheStatement)
+}
@@ -0,0 +1,605 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Describe and Close message object types.
+const (
+	objectStatement = 'S'
+	objectPortal    = 'P'
+)
+
+// Prepared statement created with a Parse message.
+type preparedStmt struct {
+	name        string
+	query       string // Rewritten query executed by SQLite.
+	class       parser.StmtClass
+	paramOIDs   []uint32
+	fingerprint string                   // Statement fingerprint, set for statements with tracked usage.
+	desc        *pgproto3.RowDescription // Result columns, nil when statement returns no rows.
+	dates       []bool                   // Result columns of DATE type.
+	lastUsed    time.Time                // Last time the statement was parsed or bound.
+	remote      *parser.ForeignQuery     // Statement on foreign tables, nil for local statements.
+	source      string                   // Query of the Parse message.
+	searchPath  string                   // Search path the relations of the query were resolved through.
+	functionGen int64                    // Function changes of the server the calls of the query were inlined as of.
+	handle      *sql.Stmt                // SQLite statement of the query, nil until executed.
+}
+
+// Portal created with a Bind message, a prepared statement with bound parameters ready for execution.
+type portal struct {
+	name          string
+	stmt          *preparedStmt
+	args          []interface{}
+	resultFormats []int16
+
+	// Parameters of statements on foreign tables, passed as is to the foreign server.
+	params       [][]byte
+	paramFormats []int16
+
+	// Rows of the query suspended by the row limit of Execute, nil until executed with a limit.
+	cur *cursor
+
+	// Releases the read slots held by the suspended rows, nil if none.
+	release func()
+}
+
+// Closes the rows of a suspended portal and releases their read slots.
+func (p *portal) close() {
+	if p.cur != nil {
+		p.cur.rows.Close()
+		p.cur = nil
+	}
+	if p.release != nil {
+		p.release()
+		p.release = nil
+	}
+}
+
+// Closes a portal of the connection, if it exists.
+func (c *Conn) closePortal(name string) {
+	if p, ok := c.portals[name]; ok {
+		p.close()
+		delete(c.portals, name)
+	}
+}
+
+// Closes all portals of the connection.
+func (c *Conn) closePortals() {
+	for _, p := range c.portals {
+		p.close()
+	}
+	clear(c.portals)
+}
+
+// Returns the row description with the result formats of the portal, nil when no rows are returned.
+func (p *portal) rowDescription() *pgproto3.RowDescription {
+	if p.stmt.desc == nil {
+		return nil
+	}
+	return withResultFormats(p.stmt.desc, p.resultFormats)
+}
+
+// handleExtendedMessage handles the extended query protocol messages.
+// Returned errors are reported to the client and all messages are discarded until Sync.
+func (s *Server) handleExtendedMessage(ctx context.Context, c *Conn, msg pgproto3.FrontendMessage) error {
+	switch msg := msg.(type) {
+	case *pgproto3.Parse:
+		return s.handleParseMessage(ctx, c, msg)
+	case *pgproto3.Bind:
+		return s.handleBindMessage(ctx, c, msg)
+	case *pgproto3.Describe:
+		return s.handleDescribeMessage(ctx, c, msg)
+	case *pgproto3.Execute:
+		p, ok := c.portals[msg.Portal]
+		if !ok {
+			return s.handleExecuteMessage(ctx, c, msg)
+		}
+		start := time.Now()
+		err := s.handleExecuteMessage(c.startQuery(ctx, p.stmt.query), c, msg)
+		c.endQuery()
+		c.traceStmt(p.stmt.query, start, err)
+		class := p.stmt.class
+		class.SQL = p.stmt.source
+		return unsupportedStmt(class, err)
+	case *pgproto3.Close:
+		return s.handleCloseMessage(ctx, c, msg)
+	}
+	return fmt.Errorf("unexpected message type: %#v", msg)
+}
+
+func (s *Server) handleParseMessage(ctx context.Context, c *Conn, msg *pgproto3.Parse) error {
+	if err := s.prepareStmt(ctx, c, msg.Name, msg.Query, msg.ParameterOIDs); err != nil {
+		return err
+	}
+	return writeMessages(c, &pgproto3.ParseComplete{})
+}
+
+// Prepares a statement of the session, as by a Parse message or a PREPARE statement.
+// Parameter types not given by paramOIDs are looked up.
+func (s *Server) prepareStmt(ctx context.Context, c *Conn, name, source string, paramOIDs []uint32) (err error) {
+	// Relations are resolved through the search path when the statement is parsed.
+	text, err := s.qualifyRelations(ctx, c, source)
+	if err != nil {
+		return err
+	}
+	if text, err = s.inlineFunctions(ctx, c, text); err != nil {
+		return err
+	}
+
+	// Rewrite system-information queries so they're tolerable by SQLite.
+	query := s.rewriteQuery(c, text)
+
+	if source != query {
+		c.tracef("query rewrite: %s", query)
+	}
+	// The statements are classified and their params extracted from a single parse.
+	stmts, result, err := s.parseQuery(c, query)
+	if err != nil {
+		// System functions rewritten into calls aren't PostgreSQL syntax, e.g. current_user(),
+		// the query as sent by the client is parsed then. Errors are positioned in it too.
+		if stmts, result, err = s.parseQuery(c, text); err != nil {
+			return err
+		}
+	}
+	if len(stmts) > 1 {
+		return fmt.Errorf("cannot insert multiple commands into a prepared statement")
+	} else if len(stmts) == 1 {
+		// Errors are reported with the statement as sent by the client.
+		class := stmts[0]
+		class.SQL = source
+		defer func() { err = unsupportedStmt(class, err) }()
+	}
+
+	stmt := &preparedStmt{name: name, query: query, source: source, searchPath: c.searchPathKey(), functionGen: c.functionGen}
+	if len(stmts) == 1 {
+		stmt.class = stmts[0]
+		if q, err := s.remoteQuery(c, stmt.class); err != nil {
+			return err
+		} else if q != nil {
+			if err := s.prepareRemote(ctx, c, stmt, q, paramOIDs); err != nil {
+				return err
+			}
+			return c.addStmt(stmt)
+		}
+		translated, err := translateDDL(ctx, c, stmt.class)
+		if err != nil {
+			return err
+		} else if translated != stmt.class.SQL {
+			stmt.query = s.rewriteQuery(c, translated)
+		}
+	}
+	if trackUsage(stmt.class) {
+		stmt.fingerprint = s.fingerprint(c, source)
+	}
+
+	// Lookup the types of the query params if any.
+	var colTypes []uint32
+	for idx := range result {
+		types, err := sqlite.LookupTypeInfo(ctx, c.conn, result[idx].Args, result[idx].Tables)
+		if err != nil {
+			return err
+		}
+		colTypes = append(colTypes, types...)
+	}
+
+	// Looked up types can be matched to parameters only if all of them were resolved.
+	if len(colTypes) != stmt.class.Params {
+		colTypes = nil
+	}
+
+	// Types specified by the client take precedence over the looked up ones,
+	// unresolved types are left unspecified for the client to choose.
+	stmt.paramOIDs = make([]uint32, stmt.class.Params)
+	for i := range stmt.paramOIDs {
+		switch {
+		case i < len(paramOIDs) && paramOIDs[i] != 0:
+			stmt.paramOIDs[i] = paramOIDs[i]
+		case i < len(colTypes):
+			stmt.paramOIDs[i] = colTypes[i]
+		}
+	}
+
+	// Lookup result columns, rows are not fetched so the statement isn't actually executed.
+	if stmt.class.Command == parser.CmdFetch {
+		// Fetched columns are those of the cursor.
+		cur, ok := c.cursors[stmt.class.Name]
+		if !ok {
+			return fmt.Errorf("cursor %q does not exist", stmt.class.Name)
+		}
+		stmt.desc = cur.desc
+		stmt.dates = cur.dates
+	} else if stmt.class.ReturnsRows {
+		rows, err := c.conn.QueryContext(ctx, stmt.query, make([]interface{}, stmt.class.Params)...)
+		if err != nil {
+			return err
+		}
+		cols, err := rows.ColumnTypes()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("column types: %w", err)
+		}
+		stmt.desc = toRowDescription(cols)
+		stmt.dates = dateColumns(cols)
+		if stmt.class.Command == parser.CmdSelect {
+			s.setFieldOrigins(c, source, stmt.desc)
+		}
+	}
+
+	return c.addStmt(stmt)
+}
+
+func (s *Server) handleBindMessage(ctx context.Context, c *Conn, msg *pgproto3.Bind) error {
+	stmt, ok := c.getStmt(msg.PreparedStatement)
+	if !ok {
+		return pgerror.Newf(pgerror.InvalidSQLStatementName, "prepared statement %q does not exist", msg.PreparedStatement)
+	}
+
+	if len(msg.Parameters) != len(stmt.paramOIDs) {
+		return fmt.Errorf("bind message supplies %d parameters, but prepared statement %q requires %d",
+			len(msg.Parameters), msg.PreparedStatement, len(stmt.paramOIDs))
+	}
+	if err := checkFormatCodes(msg.ParameterFormatCodes, len(msg.Parameters)); err != nil {
+		return fmt.Errorf("bind parameters: %w", err)
+	}
+	if stmt.desc != nil {
+		if err := checkFormatCodes(msg.ResultFormatCodes, len(stmt.desc.Fields)); err != nil {
+			return fmt.Errorf("bind results: %w", err)
+		}
+	}
+
+	if stmt.remote != nil {
+		// Parameters are decoded in a buffer reused by the next message.
+		params := make([][]byte, len(msg.Parameters))
+		for i, param := range msg.Parameters {
+			if param != nil {
+				params[i] = append([]byte{}, param...)
+			}
+		}
+		c.closePortal(msg.DestinationPortal)
+		c.portals[msg.DestinationPortal] = &portal{
+			name:          msg.DestinationPortal,
+			stmt:          stmt,
+			params:        params,
+			paramFormats:  msg.ParameterFormatCodes,
+			resultFormats: msg.ResultFormatCodes,
+		}
+		return writeMessages(c, &pgproto3.BindComplete{})
+	}
+
+	args := make([]interface{}, len(msg.Parameters))
+	for i, param := range msg.Parameters {
+		arg, err := decodeParam(param, stmt.paramOIDs[i], formatCode(msg.ParameterFormatCodes, i))
+		if err != nil {
+			return fmt.Errorf("bind parameter $%d: %w", i+1, err)
+		}
+		args[i] = arg
+	}
+
+	c.closePortal(msg.DestinationPortal)
+	c.portals[msg.DestinationPortal] = &portal{
+		name:          msg.DestinationPortal,
+		stmt:          stmt,
+		args:          args,
+		resultFormats: msg.ResultFormatCodes,
+	}
+	return writeMessages(c, &pgproto3.BindComplete{})
+}
+
+func (s *Server) handleDescribeMessage(ctx context.Context, c *Conn, msg *pgproto3.Describe) error {
+	var msgs []pgproto3.Message
+	var desc *pgproto3.RowDescription
+
+	switch msg.ObjectType {
+	case objectStatement:
+		stmt, ok := c.getStmt(msg.Name)
+		if !ok {
+			return pgerror.Newf(pgerror.InvalidSQLStatementName, "prepared statement %q does not exist", msg.Name)
+		}
+		msgs = append(msgs, &pgproto3.ParameterDescription{ParameterOIDs: stmt.paramOIDs})
+		desc = stmt.desc
+	case objectPortal:
+		p, ok := c.portals[msg.Name]
+		if !ok {
+			return fmt.Errorf("portal %q does not exist", msg.Name)
+		}
+		desc = p.rowDescription()
+	default:
+		return fmt.Errorf("invalid describe object type: %q", msg.ObjectType)
+	}
+
+	if desc != nil {
+		msgs = append(msgs, desc)
+	} else {
+		msgs = append(msgs, &pgproto3.NoData{})
+	}
+	return writeMessages(c, msgs...)
+}
+
+func (s *Server) handleExecuteMessage(ctx context.Context, c *Conn, msg *pgproto3.Execute) error {
+	p, ok := c.portals[msg.Portal]
+	if !ok {
+		return fmt.Errorf("portal %q does not exist", msg.Portal)
+	}
+
+	s.queriesTotal.Add(1)
+	stmt := p.stmt
+	if stmt.class.Command == "" {
+		return writeMessages(c, &pgproto3.EmptyQueryResponse{})
+	}
+	start := time.Now()
+	if p.cur != nil {
+		defer func() { s.accounts.record(c, 0, time.Since(start)) }()
+		return c.fetchPortal(p, msg.MaxRows)
+	}
+	if err := s.checkRateLimit(c, stmt.class); err != nil {
+		return err
+	}
+	defer func() { s.accounts.record(c, 1, time.Since(start)) }()
+	// Cached statements are parsed once, the system schema is refreshed on each execution.
+	s.refreshSysDBFor(ctx, stmt.query)
+	if err := s.requalifyStmt(ctx, c, stmt); err != nil {
+		return err
+	}
+	if !batchable(stmt.class) {
+		if err := s.endBatch(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	if ok, err := s.execSessionStmt(ctx, c, stmt.class); err != nil {
+		return err
+	} else if ok {
+		if err := c.syncCommit(stmt.class); err != nil {
+			return err
+		}
+		if err := s.publishChanges(ctx, c, stmt.class); err != nil {
+			return err
+		}
+		return writeMessages(c, &pgproto3.CommandComplete{CommandTag: []byte(stmt.class.Tag(0))})
+	}
+
+	if err := c.checkReadOnly(stmt.class); err != nil {
+		return err
+	}
+	if buf, ok, err := s.execCursorStmt(ctx, c, stmt.class, p, nil); err != nil {
+		return err
+	} else if ok {
+		_, err = c.Write(buf)
+		return err
+	}
+	if stmt.remote != nil {
+		buf, err := s.execRemotePortal(ctx, c, p)
+		if err == nil {
+			err = s.publishChanges(ctx, c, stmt.class)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = c.Write(buf)
+		return err
+	}
+	if buf, ok, err := s.execForeignStmt(ctx, c, stmt.class, nil); err != nil {
+		return err
+	} else if ok {
+		_, err = c.Write(buf)
+		return err
+	}
+	if buf, ok, err := s.execSchemaStmt(ctx, c, stmt.class, nil); err != nil {
+		return err
+	} else if ok {
+		_, err = c.Write(buf)
+		return err
+	}
+	if buf, ok, err := s.execDatabaseStmt(ctx, c, stmt.class, nil); err != nil {
+		return err
+	} else if ok {
+		_, err = c.Write(buf)
+		return err
+	}
+
+	if err := s.checkQuota(c, stmt.class); err != nil {
+		return err
+	}
+	if err := s.captureChanges(ctx, c); err != nil {
+		return err
+	}
+	if err := s.beginBatch(ctx, c, stmt.class); err != nil {
+		return err
+	}
+	if buf, ok, err := s.execMatViewStmt(ctx, c, stmt.class, nil); err != nil {
+		return err
+	} else if ok {
+		if err := c.syncCommit(stmt.class); err != nil {
+			return err
+		}
+		_, err = c.Write(buf)
+		return err
+	}
+	if buf, ok, err := s.execFunctionStmt(ctx, c, stmt.class, p.args, nil); err != nil {
+		return err
+	} else if ok {
+		if err := c.syncCommit(stmt.class); err != nil {
+			return err
+		}
+		_, err = c.Write(buf)
+		return err
+	}
+	if buf, ok, err := s.execDoStmt(ctx, c, stmt.class, nil); err != nil {
+		return err
+	} else if ok {
+		if err := c.syncCommit(stmt.class); err != nil {
+			return err
+		}
+		_, err = c.Write(buf)
+		return err
+	}
+	s.usage.record(ctx, c, stmt.class, stmt.fingerprint, stmt.query, p.args)
+
+	if !stmt.class.ReturnsRows {
+		viewRows, isView, err := s.viewRowsAffected(ctx, c, stmt.class, p.args)
+		if err != nil {
+			return err
+		}
+		var result sql.Result
+		if err := s.retry(ctx, c, stmt.class, func() (err error) {
+			result, err = c.execPrepared(ctx, stmt, p.args)
+			return err
+		}); err != nil {
+			return err
+		}
+		if changesSchema(stmt.class) {
+			s.registerRelations(ctx, c)
+			s.plans.invalidate(c.name)
+		}
+		if err := c.trackStmt(ctx, stmt.class, stmt.query, p.args); err != nil {
+			return err
+		}
+		if err := c.syncCommit(stmt.class); err != nil {
+			return err
+		}
+		if err := s.publishChanges(ctx, c, stmt.class); err != nil {
+			return err
+		}
+		affected, _ := result.RowsAffected()
+		if isView {
+			affected = viewRows
+		}
+		s.maint.record(c, stmt.class, affected)
+		c.rowsWritten.Add(affected)
+		return writeMessages(c, &pgproto3.CommandComplete{CommandTag: []byte(stmt.class.Tag(affected))})
+	}
+
+	release, err := s.acquireRead(ctx, c, stmt.class)
+	if err != nil {
+		return err
+	}
+	if msg.MaxRows > 0 && stmt.class.ReadOnly {
+		return s.openPortal(ctx, c, p, msg.MaxRows, release)
+	}
+	defer release()
+
+	// Rows are buffered until the query completes, so retries start over with an empty buffer.
+	b := getBuffer()
+	buf := *b
+	defer func() { putBuffer(b, buf) }()
+	var count int64
+	if err := s.retry(ctx, c, stmt.class, func() (err error) {
+		buf, count, err = p.queryRows(ctx, c, buf[:0])
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := c.trackStmt(ctx, stmt.class, stmt.query, p.args); err != nil {
+		return err
+	}
+	if err := c.syncCommit(stmt.class); err != nil {
+		return err
+	}
+	if err := s.publishChanges(ctx, c, stmt.class); err != nil {
+		return err
+	}
+
+	// Mark command complete.
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.class.Tag(count))}).Encode(buf)
+	_, err = c.Write(buf)
+	return err
+}
+
+// Executes the query of a portal returning up to maxRows rows. The portal is suspended once
+// it returned as many, the next Execute messages return the next rows. Queries that change
+// the database return all their rows. The portal holds the read slots of the query until
+// it's closed, release frees them.
+func (s *Server) openPortal(ctx context.Context, c *Conn, p *portal, maxRows uint32, release func()) error {
+	var rows *sql.Rows
+	if err := s.retry(ctx, c, p.stmt.class, func() (err error) {
+		// The rows outlive the Execute message, its cancellation doesn't close them.
+		rows, err = c.queryPrepared(context.WithoutCancel(ctx), p.stmt, p.args)
+		return err
+	}); err != nil {
+		release()
+		return err
+	}
+	p.cur = &cursor{name: p.name, rows: rows, desc: p.rowDescription(), dates: p.stmt.dates}
+	p.release = release
+	return c.fetchPortal(p, maxRows)
+}
+
+// Returns the next rows of a suspended portal, up to maxRows or all if 0.
+func (c *Conn) fetchPortal(p *portal, maxRows uint32) error {
+	count := int64(maxRows)
+	if maxRows == 0 {
+		count = -1
+	}
+	b := getBuffer()
+	buf, n, err := p.cur.fetch(c, *b, p.cur.desc.Fields, count, false)
+	defer func() { putBuffer(b, buf) }()
+	if err != nil {
+		p.close()
+		return err
+	}
+	if n == count {
+		buf, _ = (&pgproto3.PortalSuspended{}).Encode(buf)
+	} else {
+		p.close()
+		buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(p.stmt.class.Tag(n))}).Encode(buf)
+	}
+	_, err = c.Write(buf)
+	return err
+}
+
+// queryRows executes the portal's query and appends its rows to buf, encoded in the formats
+// requested by the portal, along with their count.
+func (p *portal) queryRows(ctx context.Context, c *Conn, buf []byte) ([]byte, int64, error) {
+	rows, err := c.queryPrepared(ctx, p.stmt, p.args)
+	if err != nil {
+		return buf, 0, err
+	}
+	defer rows.Close()
+
+	var count int64
+	var truncated bool
+	desc := p.rowDescription()
+	enc := newRowEncoder(desc.Fields, p.stmt.dates, c.location)
+	for rows.Next() {
+		if truncated, err = c.rowLimitReached(count); err != nil {
+			return buf, 0, err
+		} else if truncated {
+			break
+		}
+		if buf, err = enc.appendRow(buf, rows); err != nil {
+			return buf, 0, err
+		}
+		count++
+	}
+	c.rowsRead.Add(count)
+	if err := rows.Err(); err != nil {
+		return buf, 0, fmt.Errorf("rows: %w", err)
+	}
+	if truncated {
+		buf = c.appendTruncated(buf)
+	}
+	return buf, count, nil
+}
+
+func (s *Server) handleCloseMessage(ctx context.Context, c *Conn, msg *pgproto3.Close) error {
+	switch msg.ObjectType {
+	case objectStatement:
+		c.closeStmt(msg.Name)
+	case objectPortal:
+		c.closePortal(msg.Name)
+	default:
+		return fmt.Errorf("invalid close object type: %q", msg.ObjectType)
+	}
+	return writeMessages(c, &pgproto3.CloseComplete{})
+}
@@ -0,0 +1,160 @@
+package server_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Extended query protocol", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "extended.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE cars (id INTEGER PRIMARY KEY, brand TEXT, price REAL)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO cars (id, brand, price) VALUES (1, 'skoda', 9.5), (2, 'volvo', 20)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Query with parameters", func() {
+		var brand string
+		var price float64
+		err := conn.QueryRow(ctx, `SELECT brand, price FROM cars WHERE id = $1`, 2).Scan(&brand, &price)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(brand).To(Equal("volvo"))
+		Expect(price).To(Equal(20.0))
+	})
+
+	It("Result format codes per column", func() {
+		result := conn.PgConn().ExecParams(ctx, `SELECT id, brand FROM cars WHERE id = 1`,
+			nil, nil, nil, []int16{pgtype.BinaryFormatCode, pgtype.TextFormatCode}).Read()
+		Expect(result.Err).NotTo(HaveOccurred())
+		Expect(result.FieldDescriptions[0].Format).To(Equal(int16(pgtype.BinaryFormatCode)))
+		Expect(result.FieldDescriptions[1].Format).To(Equal(int16(pgtype.TextFormatCode)))
+		Expect(result.Rows).To(HaveLen(1))
+		Expect(binary.BigEndian.Uint64(result.Rows[0][0])).To(Equal(uint64(1)))
+		Expect(string(result.Rows[0][1])).To(Equal("skoda"))
+	})
+
+	It("Single result format code applied to all columns", func() {
+		result := conn.PgConn().ExecParams(ctx, `SELECT id, price FROM cars WHERE id = 2`,
+			nil, nil, nil, []int16{pgtype.BinaryFormatCode}).Read()
+		Expect(result.Err).NotTo(HaveOccurred())
+		Expect(result.Rows).To(HaveLen(1))
+		Expect(result.Rows[0][0]).To(HaveLen(8))
+		Expect(binary.BigEndian.Uint64(result.Rows[0][0])).To(Equal(uint64(2)))
+		Expect(result.Rows[0][1]).To(HaveLen(8))
+	})
+
+	It("No result format codes defaults to text", func() {
+		result := conn.PgConn().ExecParams(ctx, `SELECT id, brand FROM cars ORDER BY id`,
+			nil, nil, nil, nil).Read()
+		Expect(result.Err).NotTo(HaveOccurred())
+		Expect(result.Rows).To(HaveLen(2))
+		Expect(string(result.Rows[1][0])).To(Equal("2"))
+		Expect(string(result.Rows[1][1])).To(Equal("volvo"))
+		Expect(result.CommandTag.String()).To(Equal("SELECT 2"))
+	})
+
+	It("Mismatched number of result format codes", func() {
+		result := conn.PgConn().ExecParams(ctx, `SELECT id, brand, price FROM cars`,
+			nil, nil, nil, []int16{pgtype.BinaryFormatCode, pgtype.TextFormatCode}).Read()
+		Expect(result.Err).To(HaveOccurred())
+	})
+
+	It("Execute write statement", func() {
+		tag, err := conn.Exec(ctx, `UPDATE cars SET price = $1 WHERE brand = $2`, 11.5, "skoda")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("UPDATE 1"))
+	})
+
+	It("Reports constraint violations with their SQLSTATE", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `INSERT INTO cars (id, brand) VALUES ($1, $2)`, 1, "audi")
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Severity).To(Equal("ERROR"))
+		Expect(pgErr.Code).To(Equal("23505"))
+		Expect(pgErr.TableName).To(Equal("cars"))
+		Expect(pgErr.ConstraintName).To(Equal("cars_pkey"))
+
+		// The session is usable after the error.
+		var count string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM cars`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal("2"))
+	})
+
+	It("Reports syntax errors with their position", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `SELECT * FORM cars WHERE id = $1`, 1)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42601"))
+		Expect(pgErr.Position).To(Equal(int32(10)))
+
+		// The position is the one in the query sent, before any rewrite.
+		_, err = conn.Exec(ctx, `SELECT pg_catalog.version(), * FORM cars WHERE id = $1`, 1)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Position).To(Equal(int32(32)))
+
+		// Simple query protocol.
+		_, err = conn.Exec(ctx, `SELECT * FORM cars`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42601"))
+		Expect(pgErr.Position).To(Equal(int32(10)))
+	})
+
+	// JDBC requests generated keys by appending RETURNING * to the INSERT, prepares it with the
+	// types of the bound Java values, sends some parameters in binary and requests binary
+	// results for the types it decodes in binary.
+	It("Returns generated keys of inserts prepared as JDBC does", func() {
+		_, err := conn.Exec(ctx, `CREATE TABLE orders (id INTEGER PRIMARY KEY, qty INTEGER, note TEXT, data BLOB, total REAL, status TEXT DEFAULT 'new')`)
+		Expect(err).NotTo(HaveOccurred())
+
+		pgConn := conn.PgConn()
+		desc, err := pgConn.Prepare(ctx, "S_1", `INSERT INTO orders (qty, note, data, total) VALUES ($1, $2, $3, $4) RETURNING *`,
+			[]uint32{pgtype.Int4OID, pgtype.VarcharOID, pgtype.ByteaOID, pgtype.Float4OID})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(desc.ParamOIDs).To(Equal([]uint32{pgtype.Int4OID, pgtype.VarcharOID, pgtype.ByteaOID, pgtype.Float4OID}))
+		var types []uint32
+		for _, field := range desc.Fields {
+			types = append(types, field.DataTypeOID)
+		}
+		Expect(types).To(Equal([]uint32{pgtype.Int8OID, pgtype.Int8OID, pgtype.TextOID, pgtype.ByteaOID, pgtype.Float8OID, pgtype.TextOID}))
+
+		total := make([]byte, 4)
+		binary.BigEndian.PutUint32(total, math.Float32bits(2.5))
+		for i := 1; i <= 2; i++ {
+			qty := binary.BigEndian.AppendUint32(nil, uint32(i))
+			result := pgConn.ExecPrepared(ctx, "S_1", [][]byte{qty, []byte("héllo"), {9, 8}, total},
+				[]int16{1, 0, 1, 1}, []int16{1, 1, 0, 1, 1, 0}).Read()
+			Expect(result.Err).NotTo(HaveOccurred())
+			Expect(result.CommandTag.String()).To(Equal("INSERT 0 1"))
+			Expect(result.Rows).To(HaveLen(1))
+
+			row := result.Rows[0]
+			Expect(binary.BigEndian.Uint64(row[0])).To(Equal(uint64(i)))
+			Expect(binary.BigEndian.Uint64(row[1])).To(Equal(uint64(i)))
+			Expect(string(row[2])).To(Equal("héllo"))
+			Expect(row[3]).To(Equal([]byte{9, 8}))
+			Expect(math.Float64frombits(binary.BigEndian.Uint64(row[4]))).To(Equal(2.5))
+			Expect(string(row[5])).To(Equal("new"))
+		}
+
+		// Describing the statement doesn't execute the insert.
+		var count string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM orders`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal("2"))
+	})
+})
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Faults of the streaming of replication slots, injected to test how replication clients
+// recover. Faults are injected by InjectReplicationFault, and by kqlite_replication_fault()
+// when FaultInjection is set.
+type replicationFaults struct {
+	drop     atomic.Int64 // Number of the next streamed transactions to drop.
+	ackDelay atomic.Int64 // Delay of the standby status updates of clients, in nanoseconds.
+}
+
+// Reports whether a streamed transaction is dropped, counting it against the dropped transactions.
+func (f *replicationFaults) dropChange() bool {
+	for {
+		n := f.drop.Load()
+		if n <= 0 {
+			return false
+		}
+		if f.drop.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
+
+// InjectReplicationFault injects a fault in the streaming of logical replication slots:
+//   - "drop" drops the next n transactions streamed, they're skipped by the current streams
+//     but stay in their slots until confirmed, so they're streamed again after a restart.
+//   - "delay_ack" delays the positions confirmed by clients by n milliseconds, 0 for no delay.
+//   - "sever" closes the connections streaming slots, as a network failure would.
+//   - "reset" clears the dropped transactions and the delay of confirmations.
+//
+// Returns the number of transactions to drop, the delay in milliseconds, or the number
+// of closed connections, after the fault is injected.
+func (s *Server) InjectReplicationFault(fault string, n int64) (int64, error) {
+	faults := &s.cdc.faults
+	if n < 0 && (fault == "drop" || fault == "delay_ack") {
+		return 0, pgerror.Newf(pgerror.InvalidParameterValue, "invalid value %d of replication fault %q", n, fault)
+	}
+	switch fault {
+	case "drop":
+		return faults.drop.Add(n), nil
+	case "delay_ack":
+		faults.ackDelay.Store(int64(time.Duration(n) * time.Millisecond))
+		return n, nil
+	case "sever":
+		return int64(s.cdc.severStreams()), nil
+	case "reset":
+		faults.drop.Store(0)
+		faults.ackDelay.Store(0)
+		return 0, nil
+	}
+	return 0, pgerror.Newf(pgerror.InvalidParameterValue, "unknown replication fault %q", fault)
+}
+
+// Closes the connections streaming replication slots, returns their number.
+func (f *changeFeed) severStreams() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var n int
+	for _, slot := range f.slots {
+		if slot.streaming != nil {
+			slot.streaming.Conn.Close()
+			n++
+		}
+	}
+	return n
+}
+
+// Confirms a position of a slot, after the acknowledgement delay if one is injected.
+func (f *changeFeed) acknowledge(slot *replicationSlot, lsn uint64) {
+	if delay := time.Duration(f.faults.ackDelay.Load()); delay > 0 {
+		time.AfterFunc(delay, func() { f.confirm(slot, lsn) })
+		return
+	}
+	f.confirm(slot, lsn)
+}
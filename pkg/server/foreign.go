@@ -0,0 +1,563 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Foreign-data wrapper of foreign servers, other PostgreSQL or kqlite servers.
+const fdwName = "postgres_fdw"
+
+// Number of rows inserted by each statement when copying rows into a foreign table.
+const foreignBatchRows = 500
+
+// foreignCatalog holds the foreign servers and foreign tables of databases.
+// Definitions are kept in memory, they don't outlive the server.
+type foreignCatalog struct {
+	mu      sync.Mutex
+	servers map[string]map[string]*foreignServer       // Servers by database, then by name.
+	tables  map[string]map[string]*parser.ForeignTable // Foreign tables by database, then by name.
+}
+
+// Foreign server and the options of its user mapping, nil if none.
+type foreignServer struct {
+	*parser.ForeignServer
+	mapping map[string]string
+}
+
+func newForeignCatalog() *foreignCatalog {
+	return &foreignCatalog{
+		servers: make(map[string]map[string]*foreignServer),
+		tables:  make(map[string]map[string]*parser.ForeignTable),
+	}
+}
+
+// Returns the connection string of a foreign server, in the keyword/value format of libpq.
+func (f *foreignServer) connString() string {
+	var params []string
+	for _, options := range []map[string]string{f.Options, f.mapping} {
+		for name, value := range options {
+			value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+			params = append(params, fmt.Sprintf("%s='%s'", name, value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, " ")
+}
+
+// Returns a foreign server of a database and its connection string.
+func (f *foreignCatalog) server(database, name string) (*foreignServer, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	server, ok := f.servers[database][name]
+	if !ok {
+		return nil, "", pgerror.Newf(pgerror.UndefinedObject, "server %q does not exist", name)
+	}
+	return server, server.connString(), nil
+}
+
+// Returns the foreign tables of a database by name.
+func (f *foreignCatalog) foreignTables(database string) map[string]*parser.ForeignTable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.tables[database])
+}
+
+func (f *foreignCatalog) createServer(database string, def *parser.ForeignServer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if def.Wrapper != fdwName {
+		return pgerror.Newf(pgerror.UndefinedObject, "foreign-data wrapper %q does not exist", def.Wrapper)
+	}
+	if _, ok := f.servers[database][def.Name]; ok {
+		if def.IfNotExists {
+			return nil
+		}
+		return pgerror.Newf(pgerror.DuplicateObject, "server %q already exists", def.Name)
+	}
+	if f.servers[database] == nil {
+		f.servers[database] = make(map[string]*foreignServer)
+	}
+	f.servers[database][def.Name] = &foreignServer{ForeignServer: def}
+	return nil
+}
+
+func (f *foreignCatalog) createUserMapping(database string, def *parser.UserMapping) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	server, ok := f.servers[database][def.Server]
+	switch {
+	case !ok:
+		return pgerror.Newf(pgerror.UndefinedObject, "server %q does not exist", def.Server)
+	case server.mapping != nil && def.IfNotExists:
+		return nil
+	case server.mapping != nil:
+		return pgerror.Newf(pgerror.DuplicateObject, "user mapping already exists for server %q", def.Server)
+	}
+	// Connections of sessions to the server are made with the credentials they were made with.
+	f.servers[database][def.Server] = &foreignServer{ForeignServer: server.ForeignServer, mapping: def.Options}
+	return nil
+}
+
+func (f *foreignCatalog) createTable(database string, def *parser.ForeignTable) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.servers[database][def.Server]; !ok {
+		return pgerror.Newf(pgerror.UndefinedObject, "server %q does not exist", def.Server)
+	}
+	if _, ok := f.tables[database][def.Name]; ok {
+		if def.IfNotExists {
+			return nil
+		}
+		return pgerror.Newf(pgerror.DuplicateTable, "relation %q already exists", def.Name)
+	}
+	if f.tables[database] == nil {
+		f.tables[database] = make(map[string]*parser.ForeignTable)
+	}
+	f.tables[database][def.Name] = def
+	return nil
+}
+
+// Drops foreign servers, their foreign tables are dropped as well with cascade.
+func (f *foreignCatalog) dropServers(database string, names []string, missingOK, cascade bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, name := range names {
+		if _, ok := f.servers[database][name]; !ok && !missingOK {
+			return pgerror.Newf(pgerror.UndefinedObject, "server %q does not exist", name)
+		}
+		for _, table := range f.tables[database] {
+			if table.Server == name && !cascade {
+				e := pgerror.Newf(pgerror.DependentObjectsStillExist, "cannot drop server %q because other objects depend on it", name)
+				e.Hint = "Use DROP ... CASCADE to drop the dependent objects too."
+				return e
+			}
+		}
+	}
+	for _, name := range names {
+		delete(f.servers[database], name)
+		for tableName, table := range f.tables[database] {
+			if table.Server == name {
+				delete(f.tables[database], tableName)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *foreignCatalog) dropTables(database string, names []string, missingOK bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, name := range names {
+		if _, ok := f.tables[database][name]; !ok && !missingOK {
+			return pgerror.Newf(pgerror.UndefinedTable, "foreign table %q does not exist", name)
+		}
+	}
+	for _, name := range names {
+		delete(f.tables[database], name)
+	}
+	return nil
+}
+
+func (f *foreignCatalog) dropUserMapping(database, name string, missingOK bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	server, ok := f.servers[database][name]
+	switch {
+	case !ok:
+		return pgerror.Newf(pgerror.UndefinedObject, "server %q does not exist", name)
+	case server.mapping == nil && missingOK:
+		return nil
+	case server.mapping == nil:
+		return pgerror.Newf(pgerror.UndefinedObject, "user mapping for server %q does not exist", name)
+	}
+	f.servers[database][name] = &foreignServer{ForeignServer: server.ForeignServer}
+	return nil
+}
+
+// Executes the statements defining foreign servers and tables and the statements on
+// foreign tables, the response is appended to buf. Returns false for other statements.
+func (s *Server) execForeignStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, buf []byte) ([]byte, bool, error) {
+	var err error
+	switch stmt.Command {
+	case "CREATE EXTENSION":
+		// The foreign-data wrapper is built in, other extensions are left to SQLite.
+		if stmt.Name != fdwName {
+			return buf, false, nil
+		}
+	case "CREATE SERVER":
+		var def *parser.ForeignServer
+		if def, err = parser.CreateForeignServer(stmt.SQL); err == nil {
+			err = s.foreign.createServer(c.name, def)
+		}
+	case "CREATE USER MAPPING":
+		var def *parser.UserMapping
+		if def, err = parser.CreateUserMapping(stmt.SQL); err == nil {
+			err = s.foreign.createUserMapping(c.name, def)
+		}
+	case "CREATE FOREIGN TABLE":
+		var def *parser.ForeignTable
+		if def, err = parser.CreateForeignTable(stmt.SQL); err == nil {
+			var exists bool
+			if exists, err = localRelation(ctx, c, def.Name); err == nil && exists {
+				err = pgerror.Newf(pgerror.DuplicateTable, "relation %q already exists", def.Name)
+			} else if err == nil {
+				err = s.foreign.createTable(c.name, def)
+			}
+		}
+	case "DROP SERVER", "DROP FOREIGN TABLE", "DROP USER MAPPING":
+		var names []string
+		var missingOK, cascade bool
+		if names, missingOK, cascade, err = parser.DropObjects(stmt.SQL); err != nil {
+			break
+		}
+		switch stmt.Command {
+		case "DROP SERVER":
+			err = s.foreign.dropServers(c.name, names, missingOK, cascade)
+		case "DROP FOREIGN TABLE":
+			err = s.foreign.dropTables(c.name, names, missingOK)
+		default:
+			err = s.foreign.dropUserMapping(c.name, names[0], missingOK)
+		}
+	default:
+		q, err := s.remoteQuery(c, stmt)
+		if err != nil || q == nil {
+			return buf, err != nil, err
+		}
+		buf, err = s.execRemoteQuery(ctx, c, stmt, q, buf)
+		return buf, true, err
+	}
+	if err != nil {
+		return buf, true, err
+	}
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(0))}).Encode(buf)
+	return buf, true, nil
+}
+
+// Returns the statement executing a statement on foreign tables, nil if the
+// statement doesn't refer to foreign tables.
+func (s *Server) remoteQuery(c *Conn, stmt parser.StmtClass) (*parser.ForeignQuery, error) {
+	tables := s.foreign.foreignTables(c.name)
+	if len(tables) == 0 || stmt.Command == parser.CmdUnknown {
+		return nil, nil
+	}
+	q, err := parser.RemoteQuery(stmt.SQL, tables)
+	if err != nil && pgerror.Convert(err).Code == pgerror.SyntaxError {
+		// Statements in SQLite syntax only refer to local tables.
+		return nil, nil
+	}
+	return q, err
+}
+
+// Reports whether a table or view of the name exists in the connection database.
+func localRelation(ctx context.Context, c *Conn, name string) (bool, error) {
+	var count int
+	err := c.conn.QueryRowContext(ctx, `SELECT count(*) FROM main.sqlite_master WHERE name = ? COLLATE NOCASE AND type IN ('table', 'view')`,
+		name).Scan(&count)
+	return count > 0, err
+}
+
+// Returns the session connection to a foreign server of the database, connecting on first use.
+func (s *Server) foreignConn(ctx context.Context, c *Conn, name string) (*pgconn.PgConn, error) {
+	server, connString, err := s.foreign.server(c.name, name)
+	if err != nil {
+		return nil, err
+	}
+	if conn := c.foreignConns[server]; conn != nil && !conn.IsClosed() {
+		return conn, nil
+	}
+
+	conn, err := pgconn.Connect(ctx, connString)
+	if err != nil {
+		e := pgerror.Newf(pgerror.FDWUnableToEstablishConn, "could not connect to server %q", name)
+		e.Detail = err.Error()
+		return nil, e
+	}
+	if c.foreignConns == nil {
+		c.foreignConns = make(map[*foreignServer]*pgconn.PgConn)
+	}
+	c.foreignConns[server] = conn
+	return conn, nil
+}
+
+// Closes the connections of the session to foreign servers.
+func (c *Conn) closeForeignConns() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for server, conn := range c.foreignConns {
+		conn.Close(ctx)
+		delete(c.foreignConns, server)
+	}
+}
+
+// Executes a statement on foreign tables, the response is appended to buf.
+func (s *Server) execRemoteQuery(ctx context.Context, c *Conn, stmt parser.StmtClass, q *parser.ForeignQuery, buf []byte) ([]byte, error) {
+	conn, err := s.foreignConn(ctx, c, q.Server)
+	if err != nil {
+		return buf, err
+	}
+
+	var count int64
+	switch {
+	case q.Into == "":
+		result := conn.Exec(ctx, q.SQL)
+		for result.NextResult() {
+			rr := result.ResultReader()
+			if fields := rr.FieldDescriptions(); len(fields) > 0 {
+				buf, _ = remoteRowDescription(fields).Encode(buf)
+			}
+			for rr.NextRow() {
+				buf, _ = (&pgproto3.DataRow{Values: rr.Values()}).Encode(buf)
+			}
+			tag, err := rr.Close()
+			if err != nil {
+				result.Close()
+				return buf, remoteError(err)
+			}
+			buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(tag.String())}).Encode(buf)
+		}
+		return buf, remoteError(result.Close())
+	case q.RemoteInto:
+		count, err = copyToForeign(ctx, c, conn, q)
+	default:
+		if err := s.checkQuota(c, stmt); err != nil {
+			return buf, err
+		}
+		if err := s.captureChanges(ctx, c); err != nil {
+			return buf, err
+		}
+		count, err = copyFromForeign(ctx, c, conn, q)
+		s.maint.record(c, stmt, count)
+		c.rowsWritten.Add(count)
+	}
+	if err != nil {
+		return buf, err
+	}
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(count))}).Encode(buf)
+	return buf, nil
+}
+
+// Inserts the rows of a query on foreign tables into a local table, all rows or none.
+func copyFromForeign(ctx context.Context, c *Conn, conn *pgconn.PgConn, q *parser.ForeignQuery) (count int64, err error) {
+	err = c.atomically(ctx, func() error {
+		rr := conn.ExecParams(ctx, q.SQL, nil, nil, nil, nil)
+		var insert *sql.Stmt
+		for rr.NextRow() {
+			values := rr.Values()
+			if insert == nil {
+				placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+				if insert, err = c.conn.PrepareContext(ctx, parser.RewriteQuery(q.Into+" VALUES ("+placeholders+")")); err != nil {
+					rr.Close()
+					return err
+				}
+				defer insert.Close()
+			}
+			args := make([]interface{}, len(values))
+			for i, value := range values {
+				if value != nil {
+					args[i] = string(value)
+				}
+			}
+			if _, err := insert.ExecContext(ctx, args...); err != nil {
+				rr.Close()
+				return err
+			}
+			count++
+		}
+		_, err := rr.Close()
+		return remoteError(err)
+	})
+	return count, err
+}
+
+// Inserts the rows of a query on local tables into a foreign table, all rows or none.
+func copyToForeign(ctx context.Context, c *Conn, conn *pgconn.PgConn, q *parser.ForeignQuery) (count int64, err error) {
+	rows, err := c.conn.QueryContext(ctx, parser.RewriteQuery(q.SQL))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := conn.Exec(ctx, "BEGIN").ReadAll(); err != nil {
+		return 0, remoteError(err)
+	}
+	defer func() {
+		end := "COMMIT"
+		if err != nil {
+			end = "ROLLBACK"
+		}
+		if _, e := conn.Exec(ctx, end).ReadAll(); err == nil {
+			err = remoteError(e)
+		}
+	}()
+
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		tag, err := conn.Exec(ctx, q.Into+" VALUES "+strings.Join(batch, ", ")).ReadAll()
+		if err != nil {
+			return remoteError(err)
+		}
+		count += tag[0].CommandTag.RowsAffected()
+		batch = batch[:0]
+		return nil
+	}
+
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	literals := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return count, err
+		}
+		for i, value := range values {
+			literals[i] = sqlLiteral(value)
+		}
+		batch = append(batch, "("+strings.Join(literals, ", ")+")")
+		if len(batch) == foreignBatchRows {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	return count, flush()
+}
+
+// Returns the SQL literal of a value scanned from SQLite. Values other than NULL
+// are string literals, PostgreSQL converts them to the type of their column.
+func sqlLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return `'\x` + hex.EncodeToString(v) + `'`
+	case int64:
+		return "'" + strconv.FormatInt(v, 10) + "'"
+	case float64:
+		return "'" + strconv.FormatFloat(v, 'g', -1, 64) + "'"
+	case time.Time:
+		return "'" + v.Format(time.RFC3339Nano) + "'"
+	}
+	return sqlite.QuoteLiteral(fmt.Sprint(value))
+}
+
+// Returns the row description of the fields of a foreign server result.
+func remoteRowDescription(fields []pgconn.FieldDescription) *pgproto3.RowDescription {
+	desc := &pgproto3.RowDescription{Fields: make([]pgproto3.FieldDescription, len(fields))}
+	for i, field := range fields {
+		desc.Fields[i] = pgproto3.FieldDescription{
+			Name:         []byte(field.Name),
+			DataTypeOID:  field.DataTypeOID,
+			DataTypeSize: field.DataTypeSize,
+			TypeModifier: field.TypeModifier,
+			Format:       field.Format,
+		}
+	}
+	return desc
+}
+
+// Returns the error of a foreign server as reported by the server, other errors as is.
+func remoteError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	return &pgerror.Error{
+		Severity:       pgerror.SeverityError,
+		Code:           pgErr.Code,
+		Message:        pgErr.Message,
+		Detail:         pgErr.Detail,
+		Hint:           pgErr.Hint,
+		TableName:      pgErr.TableName,
+		ColumnName:     pgErr.ColumnName,
+		ConstraintName: pgErr.ConstraintName,
+	}
+}
+
+// Prepares a statement on foreign tables with the foreign server, which describes its
+// parameters and results. Rows copied between local and foreign tables can't be
+// described, so such statements can't have parameters.
+func (s *Server) prepareRemote(ctx context.Context, c *Conn, stmt *preparedStmt, q *parser.ForeignQuery, paramOIDs []uint32) error {
+	stmt.remote = q
+	if q.Into != "" {
+		if stmt.class.Params > 0 {
+			return pgerror.New(pgerror.FeatureNotSupported, "parameters of INSERT ... SELECT between local and foreign tables are not supported")
+		}
+		stmt.paramOIDs = nil
+		return nil
+	}
+
+	conn, err := s.foreignConn(ctx, c, q.Server)
+	if err != nil {
+		return err
+	}
+	desc, err := conn.Prepare(ctx, "", q.SQL, paramOIDs)
+	if err != nil {
+		return remoteError(err)
+	}
+	stmt.paramOIDs = desc.ParamOIDs
+	if len(desc.Fields) > 0 {
+		stmt.desc = remoteRowDescription(desc.Fields)
+	}
+	return nil
+}
+
+// Executes a portal of a statement on foreign tables, parameters and results
+// are passed as is in the formats of the client.
+func (s *Server) execRemotePortal(ctx context.Context, c *Conn, p *portal) ([]byte, error) {
+	q := p.stmt.remote
+	if q.Into != "" {
+		return s.execRemoteQuery(ctx, c, p.stmt.class, q, nil)
+	}
+
+	conn, err := s.foreignConn(ctx, c, q.Server)
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	rr := conn.ExecParams(ctx, q.SQL, p.params, p.stmt.paramOIDs, p.paramFormats, p.resultFormats)
+	for rr.NextRow() {
+		buf, _ = (&pgproto3.DataRow{Values: rr.Values()}).Encode(buf)
+	}
+	tag, err := rr.Close()
+	if err != nil {
+		return buf, remoteError(err)
+	}
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(tag.String())}).Encode(buf)
+	return buf, nil
+}
@@ -0,0 +1,114 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Foreign tables", Ordered, func() {
+	var conn, remote *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		// The foreign server is another database of the test server.
+		remote = connect(ctx, "fdw_remote.db")
+		_, err := remote.Exec(ctx, `CREATE TABLE products (id INTEGER PRIMARY KEY, name TEXT, price REAL)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = remote.Exec(ctx, `INSERT INTO products (name, price) VALUES ('apple', 1.5), ('plum', 3)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		conn = connect(ctx, "fdw.db")
+		host, port, err := net.SplitHostPort(srv.ListenerAddr().String())
+		Expect(err).NotTo(HaveOccurred())
+		for _, stmt := range []string{
+			`CREATE EXTENSION IF NOT EXISTS postgres_fdw`,
+			fmt.Sprintf(`CREATE SERVER remote FOREIGN DATA WRAPPER postgres_fdw
+				OPTIONS (host '%s', port '%s', dbname 'fdw_remote.db', sslmode 'disable')`, host, port),
+			`CREATE USER MAPPING FOR CURRENT_USER SERVER remote OPTIONS (user 'kqlite')`,
+			`CREATE FOREIGN TABLE remote_products (id integer, name text, price real) SERVER remote OPTIONS (table_name 'products')`,
+		} {
+			_, err := conn.Exec(ctx, stmt)
+			Expect(err).NotTo(HaveOccurred(), stmt)
+		}
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+		Expect(remote.Close(ctx)).To(Succeed())
+	})
+
+	count := func(c *pgx.Conn, query string) string {
+		var n string
+		Expect(c.QueryRow(ctx, query, pgx.QueryExecModeSimpleProtocol).Scan(&n)).To(Succeed())
+		return n
+	}
+
+	It("Pushes queries down to the foreign server", func() {
+		var names []string
+		rows, err := conn.Query(ctx, `SELECT remote_products.name FROM remote_products WHERE price > $1 ORDER BY id`, 1)
+		Expect(err).NotTo(HaveOccurred())
+		for rows.Next() {
+			var name string
+			Expect(rows.Scan(&name)).To(Succeed())
+			names = append(names, name)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"apple", "plum"}))
+
+		tag, err := conn.Exec(ctx, `INSERT INTO remote_products (name, price) VALUES ('pear', 2.5)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("INSERT 0 1"))
+		Expect(count(remote, `SELECT count(*) FROM products`)).To(Equal("3"))
+		Expect(count(conn, `SELECT max(price) FROM remote_products`)).To(Equal("3"))
+	})
+
+	It("Copies rows between local and foreign tables", func() {
+		_, err := conn.Exec(ctx, `CREATE TABLE products (id INTEGER PRIMARY KEY, name TEXT, price REAL)`)
+		Expect(err).NotTo(HaveOccurred())
+		tag, err := conn.Exec(ctx, `INSERT INTO products SELECT id, name, price FROM remote_products`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("INSERT 0 3"))
+		Expect(count(conn, `SELECT sum(price) FROM products`)).To(Equal("7"))
+
+		tag, err = conn.Exec(ctx, `INSERT INTO remote_products (name, price) SELECT name || ' copy', price FROM products WHERE id = 1`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("INSERT 0 1"))
+		Expect(count(remote, `SELECT name FROM products WHERE id = 4`)).To(Equal("apple copy"))
+	})
+
+	It("Rejects unsupported statements on foreign tables", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `UPDATE remote_products SET price = 0`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+
+		_, err = conn.Exec(ctx, `SELECT * FROM products JOIN remote_products USING (id)`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+
+		_, err = conn.Exec(ctx, `CREATE SERVER other FOREIGN DATA WRAPPER postgres_fdw OPTIONS (password 'secret')`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("HV00D"))
+	})
+
+	It("Drops foreign servers along with their tables", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `DROP SERVER remote`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("2BP01"))
+
+		_, err = conn.Exec(ctx, `DROP SERVER remote CASCADE`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SELECT * FROM remote_products`)
+		Expect(err).To(HaveOccurred())
+		_, err = conn.Exec(ctx, `DROP FOREIGN TABLE IF EXISTS remote_products`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
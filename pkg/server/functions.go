@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sysdb"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Functions and procedures in LANGUAGE sql are stored in a catalog table of the database.
+// Calls of functions are inlined into the statements of the sessions, CALL executes the
+// statements of procedures, and triggers the statements of trigger functions.
+const (
+	functionsTable   = "kqlite_functions"
+	functionsColumns = "name TEXT PRIMARY KEY, kind TEXT NOT NULL, params TEXT NOT NULL, body TEXT NOT NULL, retset INTEGER NOT NULL"
+)
+
+// Executes the statements creating and dropping functions and procedures, and CALL, the
+// response is appended to buf. Returns false if the statement is none of them. args are
+// the parameters of CALL statements of the extended protocol.
+func (s *Server) execFunctionStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, args []interface{}, buf []byte) ([]byte, bool, error) {
+	var exec func() error
+	switch stmt.Command {
+	case "CREATE FUNCTION", "CREATE PROCEDURE":
+		fn, err := parser.CreateFunction(stmt.SQL)
+		if err != nil {
+			return buf, true, err
+		}
+		exec = func() error { return createFunction(ctx, c, fn) }
+	case "DROP FUNCTION":
+		exec = func() error { return dropFunctions(ctx, c, stmt.SQL, parser.FunctionKind) }
+	case "DROP PROCEDURE":
+		exec = func() error { return dropFunctions(ctx, c, stmt.SQL, parser.ProcedureKind) }
+	case "CALL":
+		exec = func() error { return s.call(ctx, c, stmt, args) }
+	default:
+		return buf, false, nil
+	}
+	if err := s.syncFunctions(ctx, c); err != nil {
+		return buf, true, err
+	}
+	if err := c.atomically(ctx, exec); err != nil {
+		return buf, true, err
+	}
+	if stmt.Command != "CALL" {
+		if err := s.functionsChanged(ctx, c); err != nil {
+			return buf, true, err
+		}
+	}
+	if err := c.trackStmt(ctx, stmt, "", nil); err != nil {
+		return buf, true, err
+	}
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(0))}).Encode(buf)
+	return buf, true, nil
+}
+
+// Executes the statements of the procedure of a CALL statement.
+func (s *Server) call(ctx context.Context, c *Conn, stmt parser.StmtClass, args []interface{}) error {
+	stmts, err := parser.ProcedureStatements(stmt.SQL, c.functions)
+	if err != nil {
+		return err
+	}
+	for _, procStmt := range stmts {
+		text, err := s.qualifyRelations(ctx, c, procStmt.SQL)
+		if err != nil {
+			return err
+		}
+		var params []interface{}
+		for _, n := range procStmt.Params {
+			if n > len(args) {
+				return pgerror.Newf(pgerror.UndefinedParameter, "there is no parameter $%d", n)
+			}
+			params = append(params, args[n-1])
+		}
+		if _, err := c.conn.ExecContext(ctx, parser.RewriteQuery(text), params...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a function of the connection database, nil if it doesn't exist.
+func lookupFunction(ctx context.Context, c *Conn, name string) (*parser.Function, error) {
+	if ok, err := catalogExists(ctx, c.conn, functionsTable); err != nil || !ok {
+		return nil, err
+	}
+	fn := &parser.Function{Name: name}
+	var params string
+	err := c.conn.QueryRowContext(ctx, `SELECT kind, params, body, retset FROM main.`+functionsTable+` WHERE name = ?`, name).
+		Scan(&fn.Kind, &params, &fn.Body, &fn.ReturnsSet)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return fn, json.Unmarshal([]byte(params), &fn.Params)
+}
+
+// Stores a function, replacing the function of the same name if fn replaces it.
+func createFunction(ctx context.Context, c *Conn, fn *parser.Function) error {
+	if existing, err := lookupFunction(ctx, c, fn.Name); err != nil {
+		return err
+	} else if existing != nil && !fn.Replace {
+		return pgerror.Newf(pgerror.DuplicateFunction, "%s %s() already exists", fn.Kind, fn.Name)
+	} else if existing != nil && (existing.Kind == parser.ProcedureKind) != (fn.Kind == parser.ProcedureKind) {
+		return pgerror.Newf(pgerror.WrongObjectType, "cannot change routine kind of %s", fn.Name)
+	}
+	params, err := json.Marshal(fn.Params)
+	if err != nil {
+		return err
+	}
+	if _, err := c.conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS main.`+functionsTable+` (`+functionsColumns+`)`); err != nil {
+		return err
+	}
+	_, err = c.conn.ExecContext(ctx, `INSERT OR REPLACE INTO main.`+functionsTable+` (name, kind, params, body, retset) VALUES (?, ?, ?, ?, ?)`,
+		fn.Name, fn.Kind, string(params), fn.Body, fn.ReturnsSet)
+	return err
+}
+
+// Drops the functions or procedures of a DROP FUNCTION or DROP PROCEDURE statement, kind
+// is the kind of routine the statement drops. Trigger functions are functions too.
+func dropFunctions(ctx context.Context, c *Conn, sql string, kind string) error {
+	names, missingOK, err := parser.DropFunctions(sql)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fn, err := lookupFunction(ctx, c, name)
+		if err != nil {
+			return err
+		} else if fn == nil {
+			if missingOK {
+				continue
+			}
+			return pgerror.Newf(pgerror.UndefinedFunction, "%s %s() does not exist", kind, name)
+		} else if (fn.Kind == parser.ProcedureKind) != (kind == parser.ProcedureKind) {
+			return pgerror.Newf(pgerror.WrongObjectType, "%s() is not a %s", name, kind)
+		}
+		if _, err := c.conn.ExecContext(ctx, `DELETE FROM main.`+functionsTable+` WHERE name = ?`, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads the functions of the database of the session.
+func (s *Server) loadFunctions(ctx context.Context, c *Conn) error {
+	gen := s.functionGen.Load()
+	functions := make(map[string]*parser.Function)
+	if ok, err := catalogExists(ctx, c.conn, functionsTable); err != nil {
+		return err
+	} else if ok {
+		rows, err := c.conn.QueryContext(ctx, `SELECT name, kind, params, body, retset FROM main.`+functionsTable)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			fn := &parser.Function{}
+			var params string
+			if err := rows.Scan(&fn.Name, &fn.Kind, &params, &fn.Body, &fn.ReturnsSet); err != nil {
+				return err
+			}
+			if err := json.Unmarshal([]byte(params), &fn.Params); err != nil {
+				return err
+			}
+			functions[fn.Name] = fn
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	c.functions, c.functionGen = functions, gen
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(functions) == 0 {
+		delete(s.functions, c.name)
+	} else {
+		s.functions[c.name] = functions
+	}
+	return nil
+}
+
+// Loads the functions of the database again when sessions changed them since the last
+// statement.
+func (s *Server) syncFunctions(ctx context.Context, c *Conn) error {
+	if c.functionGen == s.functionGen.Load() {
+		return nil
+	}
+	return s.loadFunctions(ctx, c)
+}
+
+// Signals the sessions that the session changed the functions of its database. Functions
+// changed in transaction blocks are signaled again once the transaction ends, see
+// publishFunctions.
+func (s *Server) functionsChanged(ctx context.Context, c *Conn) error {
+	s.functionGen.Add(1)
+	if c.xact != nil {
+		c.functionsChanged = true
+	}
+	return s.loadFunctions(ctx, c)
+}
+
+// Signals the functions changed by the transaction block that ended, committed or not.
+func (s *Server) publishFunctions(c *Conn) {
+	if c.xact == nil && c.functionsChanged {
+		c.functionsChanged = false
+		s.functionGen.Add(1)
+	}
+}
+
+// Inlines the calls of the functions of the database of the session into a statement.
+// Statements PostgreSQL can't parse are left to SQLite.
+func (s *Server) inlineFunctions(ctx context.Context, c *Conn, sql string) (string, error) {
+	if err := s.syncFunctions(ctx, c); err != nil {
+		return "", err
+	}
+	if len(c.functions) == 0 {
+		return sql, nil
+	}
+	text, err := parser.InlineFunctions(sql, c.functions)
+	if err != nil {
+		var e *pgerror.Error
+		if errors.As(err, &e) && e.Code == pgerror.SyntaxError {
+			return sql, nil
+		}
+		return "", err
+	}
+	if text != sql {
+		c.tracef("function inlining: %s", text)
+	}
+	return text, nil
+}
+
+// Returns the functions of the databases, as last loaded by their sessions.
+func (s *Server) functionsSnapshot() []sysdb.Function {
+	s.mu.Lock()
+	databases := maps.Clone(s.functions)
+	s.mu.Unlock()
+
+	var functions []sysdb.Function
+	for database, fns := range databases {
+		for _, fn := range fns {
+			params, _ := json.Marshal(fn.Params)
+			functions = append(functions, sysdb.Function{
+				Database: database,
+				Name:     fn.Name,
+				Kind:     fn.Kind,
+				Params:   string(params),
+				Body:     fn.Body,
+			})
+		}
+	}
+	return functions
+}
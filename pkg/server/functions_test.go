@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SQL functions and procedures", Ordered, func() {
+	var conn, other *pgx.Conn
+	ctx := context.Background()
+
+	exec := func(sql string, args ...any) {
+		_, err := conn.Exec(ctx, sql, args...)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	query := func(conn *pgx.Conn, sql string, args ...any) string {
+		var s string
+		Expect(conn.QueryRow(ctx, sql, args...).Scan(&s)).To(Succeed())
+		return s
+	}
+
+	BeforeAll(func() {
+		conn = connect(ctx, "functions.db")
+		other = connect(ctx, "functions.db")
+		exec(`CREATE TABLE accounts (id INTEGER PRIMARY KEY, owner TEXT, balance INTEGER CHECK (balance >= 0), closed INTEGER DEFAULT 0)`)
+		exec(`INSERT INTO accounts (id, owner, balance) VALUES (1, 'ann', 100), (2, 'bob', 20), (3, 'cy', 0)`)
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+		Expect(other.Close(ctx)).To(Succeed())
+	})
+
+	It("Inlines calls of SQL functions", func() {
+		exec(`CREATE FUNCTION with_fee(amount int, fee int DEFAULT 5) RETURNS int LANGUAGE sql IMMUTABLE AS 'SELECT amount - fee'`)
+		exec(`CREATE FUNCTION open_accounts(min_balance int) RETURNS SETOF accounts LANGUAGE sql
+			BEGIN ATOMIC SELECT * FROM accounts WHERE closed = 0 AND balance >= min_balance; END`)
+
+		Expect(query(conn, `SELECT with_fee(balance) FROM accounts WHERE id = 1`)).To(Equal("95"))
+		Expect(query(conn, `SELECT with_fee(balance, fee => 10) FROM accounts WHERE id = $1`, 2)).To(Equal("10"))
+		Expect(query(conn, `SELECT group_concat(owner, ',') FROM open_accounts($1)`, 20)).To(Equal("ann,bob"))
+
+		// Other sessions of the database call them too, CREATE OR REPLACE takes effect at once.
+		Expect(query(other, `SELECT with_fee(10)`)).To(Equal("5"))
+		exec(`CREATE OR REPLACE FUNCTION with_fee(amount int, fee int DEFAULT 1) RETURNS int LANGUAGE sql AS 'SELECT amount - fee'`)
+		Expect(query(other, `SELECT with_fee(10)`)).To(Equal("9"))
+
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `CREATE FUNCTION with_fee(amount int) RETURNS int LANGUAGE sql AS 'SELECT amount'`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42723"))
+		_, err = conn.Exec(ctx, `CREATE FUNCTION bump() RETURNS int LANGUAGE plpgsql AS $$ BEGIN RETURN 1; END $$`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+	})
+
+	It("Executes SQL procedures with CALL", func() {
+		exec(`CREATE PROCEDURE transfer(src int, dst int, amount int) LANGUAGE sql AS $$
+			UPDATE accounts SET balance = balance - amount WHERE id = src;
+			UPDATE accounts SET balance = balance + with_fee(amount) WHERE id = dst $$`)
+		exec(`CALL transfer(1, 3, 50)`)
+		exec(`CALL transfer($1, $2, amount => $3)`, 2, 3, 11)
+		Expect(query(conn, `SELECT group_concat(balance, ',') FROM accounts`)).To(Equal("50,9,59"))
+
+		// Procedures are atomic, the second statement fails here.
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `CALL transfer(1, 3, -100)`)
+		Expect(err).To(HaveOccurred())
+		Expect(query(conn, `SELECT group_concat(balance, ',') FROM accounts`)).To(Equal("50,9,59"))
+		_, err = conn.Exec(ctx, `CALL with_fee(1)`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42883"))
+
+		Expect(query(conn, `SELECT group_concat(name || ':' || kind, ',') FROM (SELECT * FROM kqlite.functions WHERE database = 'functions.db' ORDER BY name)`)).
+			To(Equal("open_accounts:function,transfer:procedure,with_fee:function"))
+	})
+
+	It("Drops functions and procedures", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `DROP FUNCTION transfer`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42809"))
+
+		exec(`BEGIN`)
+		exec(`DROP PROCEDURE transfer`)
+		exec(`ROLLBACK`)
+		exec(`CALL transfer(3, 1, 9)`)
+
+		exec(`DROP PROCEDURE transfer(int, int, int)`)
+		exec(`DROP FUNCTION with_fee, open_accounts`)
+		exec(`DROP FUNCTION IF EXISTS with_fee`)
+		_, err = other.Exec(ctx, `SELECT with_fee(1)`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42883"))
+		_, err = conn.Exec(ctx, `CALL transfer(1, 2, 3)`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42883"))
+	})
+})
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Group commit batches the WAL syncs of commits from concurrent sessions.
+//
+// Connections of a WAL mode database run with synchronous=NORMAL, so SQLite
+// doesn't sync the WAL on commit. Instead a commit is acknowledged to the
+// client only after the WAL file was synced, which is done once for all
+// commits collected within the group commit delay.
+type groupCommitter struct {
+	path  string // WAL file path.
+	delay time.Duration
+
+	mu      sync.Mutex
+	pending []chan error
+
+	syncs   *atomic.Int64 // Number of WAL syncs.
+	commits *atomic.Int64 // Number of commits synced.
+}
+
+// Waits until all changes committed so far are synced to disk.
+func (g *groupCommitter) sync() error {
+	done := make(chan error, 1)
+
+	g.mu.Lock()
+	g.pending = append(g.pending, done)
+	if len(g.pending) == 1 {
+		// First commit of the group, sync once the delay has passed.
+		time.AfterFunc(g.delay, g.flush)
+	}
+	g.mu.Unlock()
+
+	return <-done
+}
+
+// Syncs the WAL file and releases all pending commits.
+func (g *groupCommitter) flush() {
+	g.mu.Lock()
+	pending := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+
+	err := syncFile(g.path)
+	g.syncs.Add(1)
+	g.commits.Add(int64(len(pending)))
+	for _, done := range pending {
+		done <- err
+	}
+}
+
+// Syncs a file to disk, a missing file has nothing to sync.
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Enables group commit on the connection if the database is in WAL mode.
+func (s *Server) enableGroupCommit(ctx context.Context, c *Conn, path string) error {
+	var mode string
+	if err := c.conn.QueryRowContext(ctx, `PRAGMA journal_mode`).Scan(&mode); err != nil {
+		return err
+	} else if mode != "wal" {
+		return nil
+	}
+	if _, err := c.conn.ExecContext(ctx, `PRAGMA synchronous = NORMAL`); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.committers[path]
+	if !ok {
+		g = &groupCommitter{
+			path:    path + "-wal",
+			delay:   s.GroupCommitDelay,
+			syncs:   &s.groupCommitSyncs,
+			commits: &s.groupCommits,
+		}
+		s.committers[path] = g
+	}
+	c.committer = g
+	return nil
+}
+
+// Waits until the changes committed by the statement are synced to disk when
+// group commit is enabled. Changes are committed by writes outside of
+// transaction blocks and by COMMIT.
+func (c *Conn) syncCommit(stmt parser.StmtClass) error {
+	if c.committer == nil || (stmt.ReadOnly && stmt.Command != parser.CmdCommit) {
+		return nil
+	}
+	if inTx, err := sqlite.InTransaction(c.conn); err != nil || inTx {
+		return err
+	}
+	return c.committer.sync()
+}
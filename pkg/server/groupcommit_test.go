@@ -0,0 +1,72 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Group commit", Ordered, func() {
+	var grouped *server.Server
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		grouped = openServer(func(s *server.Server) {
+			s.GroupCommitDelay = 5 * time.Millisecond
+		})
+
+		conn := connectTo(ctx, grouped, "grouped.db")
+		_, err := conn.Exec(ctx, `PRAGMA journal_mode = WAL`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, session INTEGER)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Syncs commits of concurrent sessions together", func() {
+		const sessions, writes = 8, 10
+
+		var wg sync.WaitGroup
+		errs := make(chan error, sessions)
+		for i := 0; i < sessions; i++ {
+			wg.Add(1)
+			go func(session int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				conn := connectTo(ctx, grouped, "grouped.db")
+				defer conn.Close(ctx)
+				for j := 0; j < writes; j++ {
+					if _, err := conn.Exec(ctx, `INSERT INTO items (session) VALUES ($1)`, session); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		conn := connectTo(ctx, grouped, "grouped.db")
+		defer conn.Close(ctx)
+
+		var count string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM items`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal(fmt.Sprint(sessions * writes)))
+
+		var syncs, commits int64
+		Expect(conn.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = 'group_commit_syncs'`).Scan(&syncs)).To(Succeed())
+		Expect(conn.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = 'group_commits'`).Scan(&commits)).To(Succeed())
+		Expect(commits).To(BeNumerically(">=", sessions*writes))
+		Expect(syncs).To(BeNumerically("<", commits))
+	})
+})
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/migrate"
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sysdb"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Loads the scripts of InitSQLDir. Scripts run in a single transaction block, they can't
+// start or end transactions.
+func (s *Server) loadInitScripts() error {
+	if s.InitSQLDir == "" {
+		return nil
+	}
+	scripts, err := migrate.Load(os.DirFS(s.InitSQLDir))
+	if err != nil {
+		return fmt.Errorf("init scripts: %w", err)
+	}
+	for _, script := range scripts {
+		// Statements that don't parse as PostgreSQL are left to SQLite.
+		stmts, _ := parser.ClassifyQuery(script.SQL)
+		for _, stmt := range stmts {
+			switch stmt.Command {
+			case parser.CmdBegin, parser.CmdStart, parser.CmdCommit, parser.CmdRollback:
+				return fmt.Errorf("init script %s: %s is not allowed, init scripts run in a transaction", script.Version, stmt.Command)
+			}
+		}
+	}
+	s.initScripts = scripts
+	return nil
+}
+
+// Reports whether the database file doesn't exist yet, empty files are new databases.
+func isNewDatabase(path string) bool {
+	info, err := os.Stat(path)
+	return os.IsNotExist(err) || (err == nil && info.Size() == 0)
+}
+
+// Runs the init scripts on the database created by a session, in a transaction block of
+// the session so they're executed as its queries are. The database is removed if a script
+// fails, so the next session creating it runs them again.
+func (s *Server) runInitScripts(ctx context.Context, c *Conn) error {
+	if err := s.execInitQuery(ctx, c, `BEGIN READ WRITE`); err != nil {
+		return s.discardDatabase(c, err)
+	}
+	for _, script := range s.initScripts {
+		if err := s.execInitQuery(ctx, c, script.SQL); err != nil {
+			s.execInitQuery(ctx, c, `ROLLBACK`)
+			return s.discardDatabase(c, fmt.Errorf("init script %s: %w", script.Version, err))
+		}
+	}
+	if err := s.execInitQuery(ctx, c, `COMMIT`); err != nil {
+		return s.discardDatabase(c, err)
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	for _, script := range s.initScripts {
+		s.initApplied = append(s.initApplied, sysdb.InitScript{
+			Database:  c.name,
+			Script:    script.Version,
+			Checksum:  script.Checksum,
+			AppliedAt: now,
+		})
+	}
+	s.mu.Unlock()
+	log.Printf("database %q initialized by %d init scripts", c.name, len(s.initScripts))
+	return nil
+}
+
+// Executes a query of init scripts as a simple query of the session, discarding its results.
+func (s *Server) execInitQuery(ctx context.Context, c *Conn, query string) error {
+	ctx = c.startQuery(ctx, query)
+	defer c.endQuery()
+	defer s.releaseXactLocks(c)
+
+	stmts, parseErr := parser.ClassifyQuery(query)
+	if parseErr != nil {
+		// Not a PostgreSQL statement, let SQLite handle it as it is.
+		stmts = []parser.StmtClass{{Command: parser.CmdSelect, ReturnsRows: true, SQL: query}}
+	}
+	var buf []byte
+	for _, stmt := range stmts {
+		var err error
+		if buf, err = s.execStmt(ctx, c, stmt, buf[:0]); err == nil {
+			err = c.syncCommit(stmt)
+		}
+		if err == nil {
+			err = s.publishChanges(ctx, c, stmt)
+		}
+		if err != nil {
+			if parseErr != nil && pgerror.Convert(err).Code == pgerror.SyntaxError {
+				err = parseErr
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Closes the database of a session that failed to initialize it and removes its files.
+func (s *Server) discardDatabase(c *Conn, err error) error {
+	c.conn.Close()
+	c.db.Close()
+	c.conn, c.db = nil, nil
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if e := os.Remove(c.dsn + suffix); e != nil && !os.IsNotExist(e) {
+			log.Printf("remove database %q: %s", c.name, e)
+		}
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Init scripts", func() {
+	ctx := context.Background()
+
+	// Opens a server running the scripts on the databases it creates.
+	openScripted := func(scripts map[string]string) *server.Server {
+		dir := GinkgoT().TempDir()
+		for name, sql := range scripts {
+			Expect(os.WriteFile(filepath.Join(dir, name), []byte(sql), 0o644)).To(Succeed())
+		}
+		return openServer(func(s *server.Server) { s.InitSQLDir = dir })
+	}
+
+	connect := func(s *server.Server, dbname string) (*pgx.Conn, error) {
+		return pgx.Connect(ctx, fmt.Sprintf("postgres://%s/%s?sslmode=disable", s.ListenerAddr(), dbname))
+	}
+
+	It("Runs the scripts once when databases are created", func() {
+		s := openScripted(map[string]string{
+			"01_schema.sql": `CREATE TABLE items (id SERIAL PRIMARY KEY, name VARCHAR(20) NOT NULL)`,
+			"02_seed.sql":   `INSERT INTO items (name) VALUES ('apple'), ('pear')`,
+			"README.md":     `Not a script.`,
+		})
+
+		conn, err := connect(s, "shop.db")
+		Expect(err).NotTo(HaveOccurred())
+		// Expressions are sent as text.
+		var count string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM items`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal("2"))
+		_, err = conn.Exec(ctx, `DELETE FROM items`)
+		Expect(err).NotTo(HaveOccurred())
+
+		rows, err := conn.Query(ctx, `SELECT database || ':' || script FROM kqlite.init_scripts ORDER BY script`,
+			pgx.QueryExecModeSimpleProtocol)
+		Expect(err).NotTo(HaveOccurred())
+		scripts, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scripts).To(Equal([]string{"shop.db:01_schema", "shop.db:02_seed"}))
+		Expect(conn.Close(ctx)).To(Succeed())
+
+		// Sessions of existing databases don't run them again.
+		conn, err = connect(s, "shop.db")
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(ctx)
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM items`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal("0"))
+	})
+
+	It("Removes databases whose scripts fail", func() {
+		s := openScripted(map[string]string{
+			"01_schema.sql": `CREATE TABLE items (id INTEGER PRIMARY KEY)`,
+			"02_seed.sql":   `INSERT INTO missing VALUES (1)`,
+		})
+
+		_, err := connect(s, "broken.db")
+		Expect(err).To(MatchError(ContainSubstring("init script 02_seed: no such table: missing")))
+		Expect(filepath.Join(s.DataDir, "broken.db")).NotTo(BeAnExistingFile())
+	})
+
+	It("Rejects scripts ending transactions", func() {
+		s := server.NewServer()
+		s.Addr = "127.0.0.1:0"
+		s.DataDir = GinkgoT().TempDir()
+		s.InitSQLDir = GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(s.InitSQLDir, "01.sql"), []byte(`CREATE TABLE t (id INT); COMMIT`), 0o644)).To(Succeed())
+		Expect(s.Open()).To(MatchError("init script 01: COMMIT is not allowed, init scripts run in a transaction"))
+	})
+})
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Journal modes of SQLite databases.
+var journalModes = []string{"delete", "truncate", "persist", "memory", "wal", "off"}
+
+// Validates the journal mode configuration.
+func (s *Server) checkJournalModes() error {
+	if s.DefaultJournalMode != "" && !slices.Contains(journalModes, s.DefaultJournalMode) {
+		return fmt.Errorf("invalid default journal mode %q", s.DefaultJournalMode)
+	}
+	for name, mode := range s.JournalModes {
+		if !slices.Contains(journalModes, mode) {
+			return fmt.Errorf("invalid journal mode %q of database %s", mode, name)
+		}
+	}
+	return nil
+}
+
+// Returns the journal mode configured for the database, empty if none is.
+func (s *Server) journalMode(name string) string {
+	if mode, ok := s.JournalModes[name]; ok {
+		return mode
+	}
+	return s.DefaultJournalMode
+}
+
+// Applies the journal mode configured for the database to the session connection.
+// The WAL mode is persistent, other modes apply to the connection only.
+func (s *Server) applyJournalMode(ctx context.Context, c *Conn) error {
+	mode := s.journalMode(c.name)
+	if mode == "" {
+		return nil
+	}
+
+	var applied string
+	if err := c.conn.QueryRowContext(ctx, "PRAGMA journal_mode = "+mode).Scan(&applied); err != nil {
+		return fmt.Errorf("journal mode: %w", err)
+	} else if !strings.EqualFold(applied, mode) {
+		return fmt.Errorf("cannot change journal mode of database %s to %s", c.name, mode)
+	}
+	return nil
+}
+
+// Returns the journal mode of a database file, the configured mode if any,
+// otherwise the mode found in the file header.
+func (s *Server) fileJournalMode(name, path string) string {
+	if mode := s.journalMode(name); mode != "" {
+		return mode
+	}
+	if isWALFile(path) {
+		return "wal"
+	}
+	return "delete"
+}
+
+// Returns whether the database file is in WAL mode, the file format versions
+// in its header are 2 in WAL mode and 1 in the other journal modes.
+func isWALFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	versions := make([]byte, 2)
+	if _, err := f.ReadAt(versions, 18); err != nil {
+		return false
+	}
+	return versions[0] == 2 && versions[1] == 2
+}
@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Journal modes", func() {
+	ctx := context.Background()
+
+	It("Applies the journal mode configured per database", func() {
+		pinned := openServer(func(s *server.Server) {
+			s.JournalModes = map[string]string{"wal.db": "wal"}
+			s.DefaultJournalMode = "truncate"
+		})
+
+		for _, name := range []string{"wal.db", "other.db"} {
+			conn := connectTo(ctx, pinned, name)
+			defer conn.Close(ctx)
+
+			_, err := conn.Exec(ctx, `CREATE TABLE t (id INTEGER PRIMARY KEY)`)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		// The file format versions in the header are 2 only in WAL mode.
+		header := func(name string) []byte {
+			data, err := os.ReadFile(filepath.Join(pinned.DataDir, name))
+			Expect(err).NotTo(HaveOccurred())
+			return data[18:20]
+		}
+		Expect(header("wal.db")).To(Equal([]byte{2, 2}))
+		Expect(header("other.db")).To(Equal([]byte{1, 1}))
+
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/wal.db?sslmode=disable", pinned.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(ctx)
+
+		modes := map[string]string{}
+		rows, err := conn.Query(ctx, `SELECT name, journal_mode FROM kqlite.databases`)
+		Expect(err).NotTo(HaveOccurred())
+		for rows.Next() {
+			var name, mode string
+			Expect(rows.Scan(&name, &mode)).To(Succeed())
+			modes[name] = mode
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(modes).To(Equal(map[string]string{"wal.db": "wal", "other.db": "truncate"}))
+	})
+
+	It("Rejects invalid journal modes", func() {
+		invalid := server.NewServer()
+		invalid.Addr = "127.0.0.1:0"
+		invalid.DataDir = GinkgoT().TempDir()
+		invalid.JournalModes = map[string]string{"db": "fast"}
+		Expect(invalid.Open()).To(MatchError(ContainSubstring(`invalid journal mode "fast"`)))
+	})
+})
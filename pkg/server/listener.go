@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync/atomic"
+)
+
+// ListenerConfig configures an additional listener of the server.
+type ListenerConfig struct {
+	// TCP bind address.
+	Addr string
+
+	// Databases clients of the listener may connect to, all databases if empty.
+	Databases []string
+
+	// Pre-opened listener used rather than binding Addr, e.g. a socket passed by
+	// systemd socket activation.
+	Listener net.Listener
+}
+
+// Listener accepting client connections, restricted to a set of databases.
+type listener struct {
+	net.Listener
+	databases []string    // Allowed databases, all if empty.
+	closed    atomic.Bool // Closed by closeListeners.
+}
+
+// Returns whether clients of the listener may connect to the database.
+func (ln *listener) allows(name string) bool {
+	return len(ln.databases) == 0 || slices.Contains(ln.databases, name)
+}
+
+// Opens the TCP listener of Addr, the Unix socket and additional listeners.
+// Listeners opened before a failure are closed.
+func (s *Server) listen() error {
+	ln, err := listenTCP(s.Addr, s.Listener)
+	if err != nil {
+		return err
+	}
+	s.ln = &listener{Listener: ln}
+	s.listeners = []*listener{s.ln}
+
+	if s.UnixListener != nil {
+		s.unixLn = &listener{Listener: s.UnixListener}
+		s.listeners = append(s.listeners, s.unixLn)
+	} else if s.UnixSocketDir != "" {
+		ln, err := listenUnix(unixSocketPath(s.UnixSocketDir, s.ln.Addr()))
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.unixLn = &listener{Listener: ln}
+		s.listeners = append(s.listeners, s.unixLn)
+	}
+
+	for _, config := range s.Listeners {
+		ln, err := listenTCP(config.Addr, config.Listener)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.listeners = append(s.listeners, &listener{Listener: ln, databases: config.Databases})
+	}
+	return nil
+}
+
+// Removes the socket file of a pre-opened Unix socket listener when the server closes, as
+// for the sockets it creates. Called once the server holds the data directory, the socket
+// is still served by the server it replaces until then.
+func (s *Server) ownUnixListener() {
+	if ln, ok := s.UnixListener.(*net.UnixListener); ok {
+		ln.SetUnlinkOnClose(true)
+	}
+}
+
+// Returns the pre-opened listener if not nil, or a TCP listener of the address.
+func listenTCP(addr string, ln net.Listener) (net.Listener, error) {
+	if ln != nil {
+		return ln, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Closes all listeners, the Unix socket file is removed. Listeners are closed once.
+func (s *Server) closeListeners() (err error) {
+	for _, ln := range s.listeners {
+		if !ln.closed.CompareAndSwap(false, true) {
+			continue
+		}
+		if e := ln.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// ListenerFiles returns duplicates of the sockets of all listeners, in the order of
+// ListenerAddrs, to pass them to another process, e.g. the server of an upgrade. They
+// keep accepting connections once the server closes, the Unix socket file is no longer
+// removed. The caller closes the files.
+func (s *Server) ListenerFiles() ([]*os.File, error) {
+	var files []*os.File
+	for _, ln := range s.listeners {
+		var f *os.File
+		var err error
+		switch l := ln.Listener.(type) {
+		case *net.TCPListener:
+			f, err = l.File()
+		case *net.UnixListener:
+			l.SetUnlinkOnClose(false)
+			f, err = l.File()
+		default:
+			err = fmt.Errorf("listener %s has no file", ln.Addr())
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// ListenerAddr returns the address the server is listening on, nil if not open.
+func (s *Server) ListenerAddr() net.Addr {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// ListenerAddrs returns the addresses of all listeners, in order of Addr,
+// the Unix socket and the additional listeners.
+func (s *Server) ListenerAddrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, ln := range s.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+// UnixSocketPath returns the path of the Unix socket, empty if not listening on one.
+func (s *Server) UnixSocketPath() string {
+	if s.unixLn == nil {
+		return ""
+	}
+	return s.unixLn.Addr().String()
+}
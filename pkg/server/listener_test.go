@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Listeners", func() {
+	ctx := context.Background()
+
+	It("Restricts databases per listener", func() {
+		multi := openServer(func(s *server.Server) {
+			s.Listeners = []server.ListenerConfig{{Addr: "127.0.0.1:0", Databases: []string{"public.db"}}}
+		})
+
+		addrs := multi.ListenerAddrs()
+		Expect(addrs).To(HaveLen(2))
+		Expect(addrs[0]).To(Equal(multi.ListenerAddr()))
+
+		// The restricted listener only serves its databases.
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/public.db?sslmode=disable", addrs[1]))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Close(ctx)).To(Succeed())
+
+		_, err = pgx.Connect(ctx, fmt.Sprintf("postgres://%s/private.db?sslmode=disable", addrs[1]))
+		Expect(err).To(MatchError(ContainSubstring(`database "private.db" is not available on this listener`)))
+
+		// The main listener serves all databases.
+		conn, err = pgx.Connect(ctx, fmt.Sprintf("postgres://%s/private.db?sslmode=disable", addrs[0]))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Serves pre-opened listeners", func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		restricted, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		activated := openServer(func(s *server.Server) {
+			s.Addr = "127.0.0.1:1"
+			s.Listener = ln
+			s.Listeners = []server.ListenerConfig{{Addr: "127.0.0.1:1", Databases: []string{"public.db"}, Listener: restricted}}
+		})
+		Expect(activated.ListenerAddrs()).To(Equal([]net.Addr{ln.Addr(), restricted.Addr()}))
+
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/private.db?sslmode=disable", ln.Addr()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Close(ctx)).To(Succeed())
+		_, err = pgx.Connect(ctx, fmt.Sprintf("postgres://%s/private.db?sslmode=disable", restricted.Addr()))
+		Expect(err).To(MatchError(ContainSubstring(`database "private.db" is not available on this listener`)))
+	})
+})
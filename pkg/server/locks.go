@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/sysdb"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Modes of locks, as in pg_locks.
+const (
+	exclusiveLock = "ExclusiveLock"
+	shareLock     = "ShareLock"
+)
+
+// Records that the transaction block of the session holds the write lock of its database,
+// SQLite doesn't report which connection holds it.
+func (c *Conn) holdWriteLock() {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	if c.activity.writeLockStart.IsZero() {
+		c.activity.writeLockStart = time.Now()
+	}
+}
+
+// Records that the session released the write lock of its database.
+func (c *Conn) releaseWriteLock() {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	c.activity.writeLockStart = time.Time{}
+}
+
+// Records the start of a write statement of the session, it waits for the write lock of
+// the database while other sessions hold it. Returns the function recording its end.
+func (c *Conn) startWrite() func() {
+	c.activity.mu.Lock()
+	defer c.activity.mu.Unlock()
+
+	c.activity.writeStart = time.Now()
+	return func() {
+		c.activity.mu.Lock()
+		defer c.activity.mu.Unlock()
+
+		c.activity.writeStart = time.Time{}
+	}
+}
+
+// Returns the locks held and awaited by the sessions, as pg_locks lists them. Sessions hold
+// the write lock of their database from the first write of their transaction block, or from
+// the start of SERIALIZABLE ones, until it ends. Without such a session, the earliest write
+// statement outside of transaction blocks holds it while it executes. Other write statements
+// wait for it.
+func (s *Server) locks() []sysdb.Lock {
+	type writer struct {
+		pid                   int32
+		lockStart, writeStart time.Time
+	}
+	writers := make(map[string][]writer)
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.activity.mu.Lock()
+		w := writer{conn.pid, conn.activity.writeLockStart, conn.activity.writeStart}
+		conn.activity.mu.Unlock()
+		if conn.name != "" && (!w.lockStart.IsZero() || !w.writeStart.IsZero()) {
+			writers[conn.name] = append(writers[conn.name], w)
+		}
+	}
+	s.mu.Unlock()
+
+	var locks []sysdb.Lock
+	for database, writers := range writers {
+		held := false
+		for _, w := range writers {
+			held = held || !w.lockStart.IsZero()
+		}
+		earliest := -1
+		for i, w := range writers {
+			if held {
+				break
+			} else if earliest < 0 || w.writeStart.Before(writers[earliest].writeStart) {
+				earliest = i
+			}
+		}
+		for i, w := range writers {
+			l := sysdb.Lock{Type: "database", Database: database, PID: w.pid, Mode: exclusiveLock}
+			if l.Granted = !w.lockStart.IsZero() || i == earliest; !l.Granted {
+				l.WaitStart = w.writeStart
+			}
+			locks = append(locks, l)
+		}
+	}
+	return append(locks, s.advisory.snapshot()...)
+}
+
+// Reports whether a lock awaited by a session conflicts with a lock of another session:
+// exclusive locks conflict with all locks of the same object.
+func lockConflicts(waiting, held sysdb.Lock) bool {
+	return waiting.PID != held.PID && waiting.Type == held.Type && waiting.Database == held.Database &&
+		waiting.ClassID == held.ClassID && waiting.ObjID == held.ObjID && waiting.ObjSubID == held.ObjSubID &&
+		(waiting.Mode == exclusiveLock || held.Mode == exclusiveLock)
+}
+
+// Returns the process IDs of the sessions holding a lock awaited by a session.
+func blockingPIDs(waiting sysdb.Lock, locks []sysdb.Lock) []int32 {
+	var pids []int32
+	for _, l := range locks {
+		if l.Granted && lockConflicts(waiting, l) {
+			pids = append(pids, l.PID)
+		}
+	}
+	return pids
+}
+
+// Returns the description of a lock in messages.
+func lockName(l sysdb.Lock) string {
+	if l.Type == "advisory" {
+		return fmt.Sprintf("advisory lock [%d,%d,%d] of database %q", l.ClassID, l.ObjID, l.ObjSubID, l.Database)
+	}
+	return fmt.Sprintf("write lock of database %q", l.Database)
+}
+
+// Logs the sessions waiting for locks longer than LockWaitWarning, once per wait.
+func (s *Server) watchLockWaits() error {
+	ticker := time.NewTicker(max(s.LockWaitWarning/2, time.Millisecond))
+	defer ticker.Stop()
+
+	type wait struct {
+		pid  int32
+		lock string
+	}
+	warned := make(map[wait]time.Time)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		locks := s.locks()
+		waits := make(map[wait]time.Time)
+		for _, l := range locks {
+			if l.Granted {
+				continue
+			}
+			w := wait{l.PID, lockName(l)}
+			waits[w] = l.WaitStart
+			if warned[w] == l.WaitStart || time.Since(l.WaitStart) < s.LockWaitWarning {
+				continue
+			}
+			warned[w] = l.WaitStart
+			s.lockWaitWarnings.Add(1)
+			log.Printf("process %d has been waiting %s for the %s, held by processes %v",
+				l.PID, time.Since(l.WaitStart).Round(time.Millisecond), w.lock, blockingPIDs(l, locks))
+		}
+		// Waits that ended are forgotten.
+		for w := range warned {
+			if _, ok := waits[w]; !ok {
+				delete(warned, w)
+			}
+		}
+	}
+}
+
+// Adds the sessions holding the write lock of the database to the error of a statement of
+// a session that failed waiting for it.
+func (s *Server) lockWaitError(c *Conn, err error) error {
+	e := pgerror.Convert(err)
+	if e.Code != pgerror.LockNotAvailable {
+		return err
+	}
+	waiting := sysdb.Lock{Type: "database", Database: c.name, PID: c.pid, Mode: exclusiveLock}
+	pids := blockingPIDs(waiting, s.locks())
+	if len(pids) == 0 {
+		return err
+	}
+	e.Detail = fmt.Sprintf("Process %d holds the write lock of database %q.", pids[0], c.name)
+	e.Hint = "See pg_locks for the sessions holding and waiting for locks."
+	return e
+}
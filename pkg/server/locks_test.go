@@ -0,0 +1,120 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Lock diagnostics", Ordered, func() {
+	var watched *server.Server
+	var holder, waiter, observer *pgx.Conn
+	ctx := context.Background()
+
+	// Locks of pg_locks, as locktype:pid:mode:granted.
+	locks := func() []string {
+		rows, err := observer.Query(ctx, `SELECT locktype || ':' || pid || ':' || mode || ':' || granted FROM pg_locks
+			WHERE datname = 'locks.db' ORDER BY locktype, granted DESC`, pgx.QueryExecModeSimpleProtocol)
+		Expect(err).NotTo(HaveOccurred())
+		locks, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		Expect(err).NotTo(HaveOccurred())
+		return locks
+	}
+
+	BeforeAll(func() {
+		watched = openServer(func(s *server.Server) {
+			s.LockWaitWarning = 50 * time.Millisecond
+		})
+
+		holder, waiter, observer = connectTo(ctx, watched, "locks.db"), connectTo(ctx, watched, "locks.db"), connectTo(ctx, watched, "locks.db")
+		_, err := holder.Exec(ctx, `PRAGMA journal_mode = WAL`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = holder.Exec(ctx, `CREATE TABLE jobs (id INTEGER PRIMARY KEY, state TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		for _, conn := range []*pgx.Conn{holder, waiter, observer} {
+			conn.Close(ctx)
+		}
+	})
+
+	It("Lists the sessions holding and waiting for the write lock", func() {
+		holderPID, waiterPID := holder.PgConn().PID(), waiter.PgConn().PID()
+		Expect(locks()).To(BeEmpty())
+
+		_, err := holder.Exec(ctx, `BEGIN; INSERT INTO jobs (state) VALUES ('queued')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(locks()).To(Equal([]string{fmt.Sprintf("database:%d:ExclusiveLock:1", holderPID)}))
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := waiter.Exec(ctx, `UPDATE jobs SET state = 'done'`)
+			done <- err
+		}()
+		Eventually(locks).Should(Equal([]string{
+			fmt.Sprintf("database:%d:ExclusiveLock:1", holderPID),
+			fmt.Sprintf("database:%d:ExclusiveLock:0", waiterPID),
+		}))
+		var waitStart string
+		Expect(observer.QueryRow(ctx, `SELECT waitstart FROM pg_locks WHERE NOT granted`).Scan(&waitStart)).To(Succeed())
+		Expect(waitStart).NotTo(BeEmpty())
+		// Literals naming the view are left as is.
+		var literal string
+		Expect(observer.QueryRow(ctx, `SELECT 'pg_locks' FROM pg_locks WHERE NOT granted`).Scan(&literal)).To(Succeed())
+		Expect(literal).To(Equal("pg_locks"))
+
+		// The wait is logged once it exceeds the warning threshold.
+		Eventually(func() int64 {
+			var warnings int64
+			Expect(observer.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = 'lock_wait_warnings'`,
+				pgx.QueryExecModeSimpleProtocol).Scan(&warnings)).To(Succeed())
+			return warnings
+		}).Should(Equal(int64(1)))
+
+		_, err = holder.Exec(ctx, `COMMIT`)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(locks()).To(BeEmpty())
+	})
+
+	It("Reports the session holding the write lock to sessions failing to take it", func() {
+		_, err := holder.Exec(ctx, `BEGIN ISOLATION LEVEL SERIALIZABLE`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = waiter.Exec(ctx, `PRAGMA busy_timeout = 10`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var pgErr *pgconn.PgError
+		_, err = waiter.Exec(ctx, `INSERT INTO jobs (state) VALUES ('queued')`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("55P03"))
+		Expect(pgErr.Detail).To(Equal(fmt.Sprintf(`Process %d holds the write lock of database "locks.db".`, holder.PgConn().PID())))
+
+		_, err = holder.Exec(ctx, `ROLLBACK`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Lists advisory locks with their keys", func() {
+		_, err := holder.Exec(ctx, `SELECT pg_advisory_lock(42), pg_advisory_lock_shared(1, 2)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		rows, err := observer.Query(ctx, `SELECT classid || ',' || objid || ',' || objsubid || ':' || mode FROM pg_locks
+			WHERE locktype = 'advisory' ORDER BY objsubid`, pgx.QueryExecModeSimpleProtocol)
+		Expect(err).NotTo(HaveOccurred())
+		keys, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(Equal([]string{"0,42,1:ExclusiveLock", "1,2,2:ShareLock"}))
+
+		_, err = holder.Exec(ctx, `SELECT pg_advisory_unlock_all()`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(locks()).To(BeEmpty())
+	})
+})
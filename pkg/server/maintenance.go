@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Rows of a database changed since its last maintenance.
+type churn struct {
+	name string // Database name.
+	rows int64
+}
+
+// maintainer tracks the churn of databases to schedule their maintenance.
+type maintainer struct {
+	mu    sync.Mutex
+	churn map[string]*churn // By database DSN.
+}
+
+func newMaintainer() *maintainer {
+	return &maintainer{churn: make(map[string]*churn)}
+}
+
+// Records the rows changed by a statement on the connection. VACUUM and ANALYZE
+// statements maintain the database manually, its churn starts over.
+func (m *maintainer) record(c *Conn, stmt parser.StmtClass, affected int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch stmt.Command {
+	case "VACUUM", "ANALYZE":
+		delete(m.churn, c.dsn)
+		return
+	}
+	if affected <= 0 {
+		return
+	}
+	ch, ok := m.churn[c.dsn]
+	if !ok {
+		ch = &churn{name: c.name}
+		m.churn[c.dsn] = ch
+	}
+	ch.rows += affected
+}
+
+// Removes and returns the databases with at least threshold changed rows, by DSN.
+func (m *maintainer) due(threshold int64) map[string]*churn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := make(map[string]*churn)
+	for dsn, ch := range m.churn {
+		if ch.rows >= threshold {
+			due[dsn] = ch
+			delete(m.churn, dsn)
+		}
+	}
+	return due
+}
+
+// Adds back the churn of a database that couldn't be maintained.
+func (m *maintainer) restore(dsn string, ch *churn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cur, ok := m.churn[dsn]; ok {
+		cur.rows += ch.rows
+	} else {
+		m.churn[dsn] = ch
+	}
+}
+
+// Maintains databases every MaintenanceInterval until the server is closed.
+// Checks are skipped while the server is busy, databases not maintained keep their churn.
+func (s *Server) runMaintenance() error {
+	ticker := time.NewTicker(s.MaintenanceInterval)
+	defer ticker.Stop()
+
+	queries := s.queriesTotal.Load()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		last := queries
+		queries = s.queriesTotal.Load()
+		if s.MaintenancePauseQueries > 0 && queries-last > s.MaintenancePauseQueries {
+			s.maintenancePauses.Add(1)
+			continue
+		}
+		s.maintainDatabases(s.ctx)
+	}
+}
+
+// Maintains the databases due for maintenance.
+func (s *Server) maintainDatabases(ctx context.Context) {
+	for dsn, ch := range s.maint.due(max(s.MaintenanceChurn, 1)) {
+		if err := maintainDatabase(ctx, dsn); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("maintenance of database %s: %s", ch.name, err)
+			}
+			s.maint.restore(dsn, ch)
+			continue
+		}
+		s.maintenanceRuns.Add(1)
+	}
+}
+
+// Updates the query planner statistics of a database and releases its free pages,
+// free pages are only released by databases in incremental auto-vacuum mode.
+func maintainDatabase(ctx context.Context, dsn string) error {
+	db, err := sql.Open(sqlite.DriverName, dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.ExecContext(ctx, `ANALYZE; PRAGMA incremental_vacuum`)
+	return err
+}
@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Maintenance", Ordered, func() {
+	var maintained *server.Server
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		maintained = openServer(func(s *server.Server) {
+			s.MaintenanceInterval = 10 * time.Millisecond
+			s.MaintenanceChurn = 10
+			s.MaintenancePauseQueries = 5
+		})
+		conn = connectTo(ctx, maintained, "maintenance.db")
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	// The simple protocol refreshes the statistics on each query, prepared statements only when parsed.
+	stat := func(name string) int64 {
+		var value int64
+		Expect(conn.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = $1`,
+			pgx.QueryExecModeSimpleProtocol, name).Scan(&value)).To(Succeed())
+		return value
+	}
+	analyzed := func() bool {
+		var count string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM sqlite_master WHERE name = 'sqlite_stat1'`).Scan(&count)).To(Succeed())
+		return count == "1"
+	}
+
+	It("Analyzes databases once enough rows changed", func() {
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO items (name) VALUES ('a'), ('b'), ('c')`)
+		Expect(err).NotTo(HaveOccurred())
+		Consistently(analyzed, 100*time.Millisecond).Should(BeFalse())
+
+		_, err = conn.Exec(ctx, `INSERT INTO items (name) SELECT name FROM items; INSERT INTO items (name) SELECT name FROM items`)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(analyzed).Should(BeTrue())
+		Expect(stat("maintenance_runs")).To(Equal(int64(1)))
+	})
+
+	It("Starts churn over after manual maintenance", func() {
+		_, err := conn.Exec(ctx, `DELETE FROM items WHERE id > 6`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `VACUUM`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `DELETE FROM items WHERE id > 3`)
+		Expect(err).NotTo(HaveOccurred())
+		Consistently(func() int64 { return stat("maintenance_runs") }, 100*time.Millisecond).Should(Equal(int64(1)))
+	})
+
+	It("Pauses under load", func() {
+		Eventually(func() int64 {
+			for i := 0; i < 100; i++ {
+				_, err := conn.Exec(ctx, `SELECT 1`)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			return stat("maintenance_pauses")
+		}).Should(BeNumerically(">", 0))
+	})
+})
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Materialized views are tables holding the rows of their query. Their definitions are
+// stored in a catalog table of the database, so they are refreshed after restarts.
+const (
+	matViewsTable   = "kqlite_matviews"
+	matViewsColumns = "name TEXT PRIMARY KEY, definition TEXT NOT NULL, populated INTEGER NOT NULL"
+
+	// Prefix of the shadow tables of concurrent refreshes.
+	refreshPrefix = "kqlite_refresh_"
+)
+
+// Materialized view of a database.
+type matView struct {
+	definition string // Query of the view.
+	populated  bool   // Rows were computed, unset if created or refreshed WITH NO DATA.
+}
+
+// Executes the statements creating, refreshing and dropping materialized views, the response
+// is appended to buf. Returns false if the statement is not a materialized view statement.
+func (s *Server) execMatViewStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, buf []byte) ([]byte, bool, error) {
+	// Created views are tagged with their row count as CREATE TABLE AS statements are.
+	tag := stmt.Tag(0)
+	var exec func(ctx context.Context, c *Conn, sql string) error
+	switch stmt.Command {
+	case "CREATE MATERIALIZED VIEW":
+		exec = func(ctx context.Context, c *Conn, sql string) error {
+			rows, err := createMatView(ctx, c, sql)
+			tag = fmt.Sprintf("SELECT %d", rows)
+			return err
+		}
+	case "REFRESH MATERIALIZED VIEW":
+		exec = refreshMatView
+	case "DROP MATERIALIZED VIEW":
+		exec = dropMatViews
+	default:
+		return buf, false, nil
+	}
+	if err := c.atomically(ctx, func() error { return exec(ctx, c, stmt.SQL) }); err != nil {
+		return buf, true, err
+	}
+	if err := c.trackStmt(ctx, stmt, "", nil); err != nil {
+		return buf, true, err
+	}
+	s.registerRelations(ctx, c)
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(tag)}).Encode(buf)
+	return buf, true, nil
+}
+
+// Returns the materialized views of a database by name.
+func materializedViews(ctx context.Context, conn *sql.Conn) (map[string]matView, error) {
+	if ok, err := catalogExists(ctx, conn, matViewsTable); err != nil || !ok {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT name, definition, populated FROM main.`+matViewsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	views := make(map[string]matView)
+	for rows.Next() {
+		var name string
+		var view matView
+		if err := rows.Scan(&name, &view.definition, &view.populated); err != nil {
+			return nil, err
+		}
+		views[name] = view
+	}
+	return views, rows.Err()
+}
+
+// Returns a materialized view of the connection database.
+func lookupMatView(ctx context.Context, c *Conn, name string) (matView, error) {
+	views, err := materializedViews(ctx, c.conn)
+	if err != nil {
+		return matView{}, err
+	}
+	view, ok := views[name]
+	if !ok {
+		return view, pgerror.Newf(pgerror.UndefinedTable, "materialized view %q does not exist", name)
+	}
+	return view, nil
+}
+
+// Returns the query of the rows of a materialized view, executed by SQLite.
+func matViewQuery(definition string) string {
+	return parser.RewriteQuery("SELECT * FROM (" + definition + ")")
+}
+
+// Creates the table of a materialized view and stores its definition, returns its row count.
+func createMatView(ctx context.Context, c *Conn, sql string) (rows int64, err error) {
+	view, err := parser.MaterializedViewStmt(sql)
+	if err != nil {
+		return 0, err
+	}
+	if exists, err := localRelation(ctx, c, view.Name); err != nil {
+		return 0, err
+	} else if exists && view.IfNotExists {
+		return 0, nil
+	} else if exists {
+		return 0, pgerror.Newf(pgerror.DuplicateTable, "relation %q already exists", view.Name)
+	}
+
+	query := matViewQuery(view.Query)
+	if !view.WithData {
+		query += " LIMIT 0"
+	}
+	table := "main." + sqlite.QuoteIdent(view.Name)
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS main.` + matViewsTable + ` (` + matViewsColumns + `)`,
+		`CREATE TABLE ` + table + ` AS ` + query,
+	} {
+		if _, err := c.conn.ExecContext(ctx, stmt); err != nil {
+			return 0, err
+		}
+	}
+	if _, err = c.conn.ExecContext(ctx, `INSERT INTO main.`+matViewsTable+` (name, definition, populated) VALUES (?, ?, ?)`,
+		view.Name, view.Query, view.WithData); err != nil {
+		return 0, err
+	}
+	err = c.conn.QueryRowContext(ctx, `SELECT count(*) FROM `+table).Scan(&rows)
+	return rows, err
+}
+
+// Replaces the rows of a materialized view by the rows of its query. Concurrent refreshes
+// build the rows in a shadow table that replaces the view table, so reads of the view
+// aren't blocked meanwhile. As in PostgreSQL they require a unique index on the view.
+func refreshMatView(ctx context.Context, c *Conn, sql string) error {
+	refresh, err := parser.MaterializedViewStmt(sql)
+	if err != nil {
+		return err
+	}
+	view, err := lookupMatView(ctx, c, refresh.Name)
+	if err != nil {
+		return err
+	}
+	table := "main." + sqlite.QuoteIdent(refresh.Name)
+
+	var stmts []string
+	switch {
+	case !refresh.Concurrent:
+		stmts = append(stmts, `DELETE FROM `+table)
+		if refresh.WithData {
+			stmts = append(stmts, `INSERT INTO `+table+` `+matViewQuery(view.definition))
+		}
+	case !view.populated:
+		return pgerror.New(pgerror.FeatureNotSupported, "CONCURRENTLY cannot be used when the materialized view is not populated")
+	default:
+		var unique int
+		if err := c.conn.QueryRowContext(ctx, `SELECT count(*) FROM pragma_index_list(?) WHERE "unique" AND NOT partial`,
+			refresh.Name).Scan(&unique); err != nil {
+			return err
+		} else if unique == 0 {
+			e := pgerror.Newf(pgerror.ObjectNotInPrerequisiteState, "cannot refresh materialized view %q concurrently", refresh.Name)
+			e.Hint = "Create a unique index with no WHERE clause on one or more columns of the materialized view."
+			return e
+		}
+		indexes, err := tableIndexes(ctx, c, refresh.Name)
+		if err != nil {
+			return err
+		}
+
+		// Views and triggers referring to the view aren't renamed along with the shadow table.
+		if _, err := c.conn.ExecContext(ctx, `PRAGMA legacy_alter_table = ON`); err != nil {
+			return err
+		}
+		defer c.conn.ExecContext(ctx, `PRAGMA legacy_alter_table = OFF`)
+
+		shadow := "main." + sqlite.QuoteIdent(refreshPrefix+refresh.Name)
+		stmts = append(stmts,
+			`DROP TABLE IF EXISTS `+shadow,
+			`CREATE TABLE `+shadow+` AS `+matViewQuery(view.definition),
+			`DROP TABLE `+table,
+			`ALTER TABLE `+shadow+` RENAME TO `+sqlite.QuoteIdent(refresh.Name))
+		stmts = append(stmts, indexes...)
+	}
+	for _, stmt := range stmts {
+		if _, err := c.conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	_, err = c.conn.ExecContext(ctx, `UPDATE main.`+matViewsTable+` SET populated = ? WHERE name = ?`, refresh.WithData, refresh.Name)
+	return err
+}
+
+// Returns the statements creating the indexes of a table, automatic indexes excluded.
+func tableIndexes(ctx context.Context, c *Conn, table string) ([]string, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT sql FROM main.sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var indexes []string
+	for rows.Next() {
+		var index string
+		if err := rows.Scan(&index); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, rows.Err()
+}
+
+// Drops materialized views along with their definitions.
+func dropMatViews(ctx context.Context, c *Conn, sql string) error {
+	names, missingOK, _, err := parser.DropObjects(sql)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := lookupMatView(ctx, c, name); err != nil {
+			if missingOK && pgerror.Convert(err).Code == pgerror.UndefinedTable {
+				continue
+			}
+			return err
+		}
+		if _, err := c.conn.ExecContext(ctx, `DROP TABLE main.`+sqlite.QuoteIdent(name)); err != nil {
+			return err
+		}
+		if _, err := c.conn.ExecContext(ctx, `DELETE FROM main.`+matViewsTable+` WHERE name = ?`, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
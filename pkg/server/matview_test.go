@@ -0,0 +1,116 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Materialized views", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "matview.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE sales (id INTEGER PRIMARY KEY, region TEXT, amount REAL)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO sales (region, amount) VALUES ('north', 10), ('north', 5), ('south', 7)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	query := func(sql string) string {
+		var s string
+		Expect(conn.QueryRow(ctx, sql).Scan(&s)).To(Succeed())
+		return s
+	}
+	insertSale := func() {
+		_, err := conn.Exec(ctx, `INSERT INTO sales (region, amount) VALUES ('south', 3)`)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("Keeps the rows of the query until refreshed", func() {
+		tag, err := conn.Exec(ctx, `CREATE MATERIALIZED VIEW totals (region, total) AS
+			SELECT region, sum(amount) FROM sales GROUP BY region`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("SELECT 2"))
+		Expect(query(`SELECT total FROM totals WHERE region = 'south'`)).To(Equal("7"))
+
+		insertSale()
+		Expect(query(`SELECT total FROM totals WHERE region = 'south'`)).To(Equal("7"))
+		tag, err = conn.Exec(ctx, `REFRESH MATERIALIZED VIEW totals`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("REFRESH MATERIALIZED VIEW"))
+		Expect(query(`SELECT total FROM totals WHERE region = 'south'`)).To(Equal("10"))
+
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `CREATE MATERIALIZED VIEW totals AS SELECT 1`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42P07"))
+		_, err = conn.Exec(ctx, `CREATE MATERIALIZED VIEW IF NOT EXISTS totals AS SELECT 1`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Refreshes concurrently views with a unique index", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY totals`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("55000"))
+
+		_, err = conn.Exec(ctx, `CREATE UNIQUE INDEX totals_region ON totals (region)`)
+		Expect(err).NotTo(HaveOccurred())
+		insertSale()
+		_, err = conn.Exec(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY totals`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query(`SELECT total FROM totals WHERE region = 'south'`)).To(Equal("13"))
+		Expect(query(`SELECT count(*) FROM sqlite_master WHERE name = 'totals_region'`)).To(Equal("1"))
+	})
+
+	It("Leaves views created WITH NO DATA unpopulated", func() {
+		_, err := conn.Exec(ctx, `CREATE MATERIALIZED VIEW regions AS SELECT DISTINCT region FROM sales WITH NO DATA`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query(`SELECT count(*) FROM regions`)).To(Equal("0"))
+
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY regions`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+
+		_, err = conn.Exec(ctx, `REFRESH MATERIALIZED VIEW regions`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query(`SELECT count(*) FROM regions`)).To(Equal("2"))
+	})
+
+	It("Lists materialized views in the system schema", func() {
+		Expect(query(`SELECT relkind FROM kqlite.relations WHERE database = 'matview.db' AND relname = 'totals'`)).To(Equal("m"))
+		Expect(query(`SELECT count(*) FROM kqlite.relations WHERE database = 'matview.db' AND relname LIKE 'kqlite%'`)).To(Equal("0"))
+
+		var definition string
+		var populated int
+		Expect(conn.QueryRow(ctx, `SELECT definition, ispopulated FROM kqlite.matviews
+			WHERE database = 'matview.db' AND matviewname = 'regions'`).Scan(&definition, &populated)).To(Succeed())
+		Expect(definition).To(Equal("SELECT DISTINCT region FROM sales"))
+		Expect(populated).To(Equal(1))
+	})
+
+	It("Drops materialized views", func() {
+		_, err := conn.Exec(ctx, `DROP MATERIALIZED VIEW totals, regions`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SELECT * FROM totals`)
+		Expect(err).To(HaveOccurred())
+
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `REFRESH MATERIALIZED VIEW totals`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42P01"))
+		_, err = conn.Exec(ctx, `DROP MATERIALIZED VIEW IF EXISTS totals`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// MemoryDatabase is the name of the in-memory database, any other database is
+// kept in memory as well when the "mode" startup parameter is set to "memory".
+const MemoryDatabase = ":memory:"
+
+// Number of servers of the process, shared-cache in-memory databases are global
+// to the process and their names are prefixed by the number of their server.
+var memoryServers atomic.Int64
+
+// Returns whether the startup parameters request an in-memory database.
+func isMemoryDatabase(params map[string]string) bool {
+	return getParameter(params, "database") == MemoryDatabase || getParameter(params, "mode") == "memory"
+}
+
+// Returns the DSN of the shared-cache in-memory database for name. The database
+// is created on first use and kept until the server is closed, it never touches the data directory.
+func (s *Server) openMemoryDB(ctx context.Context, name string) (string, error) {
+	dsn := fmt.Sprintf("file:%s%s?mode=memory&cache=shared", s.memPrefix, url.PathEscape(name))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.memDBs[name]; ok {
+		return dsn, nil
+	}
+
+	// A single connection that is never closed holds the in-memory database.
+	db, err := sql.Open(sqlite.DriverName, dsn)
+	if err != nil {
+		return "", err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return "", err
+	}
+	s.memDBs[name] = db
+	return dsn, nil
+}
+
+// Releases all in-memory databases, their contents are lost.
+func (s *Server) closeMemoryDBs() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, db := range s.memDBs {
+		if e := db.Close(); err == nil {
+			err = e
+		}
+		delete(s.memDBs, name)
+	}
+	return err
+}
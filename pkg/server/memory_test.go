@@ -0,0 +1,68 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("In-memory databases", func() {
+	ctx := context.Background()
+
+	connectMemory := func(dbname string) *pgx.Conn {
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/%s?sslmode=disable&mode=memory", srv.ListenerAddr(), dbname))
+		Expect(err).NotTo(HaveOccurred())
+		return conn
+	}
+
+	It("Shares an in-memory database by name without touching the data directory", func() {
+		conn := connectMemory("ephemeral.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO items (name) VALUES ('memory')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.Close(ctx)).To(Succeed())
+
+		// The database outlives its connections.
+		other := connectMemory("ephemeral.db")
+		defer other.Close(ctx)
+		var name string
+		Expect(other.QueryRow(ctx, `SELECT name FROM items`).Scan(&name)).To(Succeed())
+		Expect(name).To(Equal("memory"))
+
+		_, err = os.Stat(filepath.Join(srv.DataDir, "ephemeral.db"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		// Databases of other names are separate.
+		separate := connectMemory("separate.db")
+		defer separate.Close(ctx)
+		_, err = separate.Exec(ctx, `SELECT name FROM items`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Keeps the in-memory databases of each server", func() {
+		conn := connectMemory("shared.db")
+		defer conn.Close(ctx)
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Servers of the same process don't share databases of the same name.
+		other := openServer(nil)
+		otherConn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/shared.db?sslmode=disable&mode=memory", other.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		defer otherConn.Close(ctx)
+		_, err = otherConn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = otherConn.Exec(ctx, `INSERT INTO items VALUES (1)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var count string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM items`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal("0"))
+	})
+})
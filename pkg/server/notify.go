@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Maximum size of notification payloads, as in PostgreSQL.
+const maxNotifyPayload = 8000
+
+// Maximum size of the notifications queued for a listening session, further notifications
+// are dropped until the client reads them.
+const maxQueuedNotifications = 16 << 20
+
+// Keys of the rows changed by a transaction reported in change notifications per table,
+// notifications of larger transactions are truncated.
+const maxNotifyKeys = 500
+
+// Prefix of the channels of change notifications, followed by the lowercase table name.
+const changeChannelPrefix = "table_"
+
+// Sessions listening to notification channels, by database and channel. Notifications
+// are delivered to the sessions of the database of the notifying session.
+type notifier struct {
+	mu        sync.Mutex
+	listeners map[string]map[string]map[*Conn]struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{listeners: make(map[string]map[string]map[*Conn]struct{})}
+}
+
+// Registers a session as a listener of a channel.
+func (n *notifier) listen(c *Conn, channel string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	channels := n.listeners[c.name]
+	if channels == nil {
+		channels = make(map[string]map[*Conn]struct{})
+		n.listeners[c.name] = channels
+	}
+	if channels[channel] == nil {
+		channels[channel] = make(map[*Conn]struct{})
+	}
+	channels[channel][c] = struct{}{}
+	if c.mailbox == nil {
+		c.mailbox = newMailbox()
+		go c.mailbox.deliver(c)
+	}
+}
+
+// Unregisters a session as a listener of a channel, of all channels if channel is empty.
+func (n *notifier) unlisten(c *Conn, channel string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	channels := n.listeners[c.name]
+	for name, conns := range channels {
+		if channel != "" && name != channel {
+			continue
+		}
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(channels, name)
+		}
+	}
+	if len(channels) == 0 {
+		delete(n.listeners, c.name)
+	}
+}
+
+// Unregisters a closed session and stops the delivery of its notifications.
+func (n *notifier) remove(c *Conn) {
+	n.unlisten(c, "")
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if c.mailbox != nil {
+		c.mailbox.stop()
+	}
+}
+
+// Sends a notification to the sessions of a database listening to its channel.
+func (n *notifier) publish(database string, msg *pgproto3.NotificationResponse) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	conns := n.listeners[database][msg.Channel]
+	if len(conns) == 0 {
+		return
+	}
+	buf, _ := msg.Encode(nil)
+	for c := range conns {
+		c.mailbox.push(buf)
+	}
+}
+
+// Notifications of a listening session waiting to be written to the client. They're written
+// by a goroutine of their own, so slow clients don't hold up the sessions notifying them.
+type mailbox struct {
+	mu     sync.Mutex
+	buf    []byte        // Encoded notifications.
+	signal chan struct{} // Signals queued notifications.
+	done   chan struct{} // Closed when the session ends.
+	once   sync.Once
+}
+
+func newMailbox() *mailbox {
+	return &mailbox{signal: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+// Queues an encoded notification.
+func (m *mailbox) push(msg []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.buf)+len(msg) > maxQueuedNotifications {
+		log.Printf("notification queue full, dropping notification")
+		return
+	}
+	m.buf = append(m.buf, msg...)
+	select {
+	case m.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Writes the queued notifications to the client until the session ends.
+func (m *mailbox) deliver(c *Conn) {
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.signal:
+		}
+		m.mu.Lock()
+		buf := m.buf
+		m.buf = nil
+		m.mu.Unlock()
+		if _, err := c.Write(buf); err != nil {
+			return
+		}
+	}
+}
+
+func (m *mailbox) stop() {
+	m.once.Do(func() { close(m.done) })
+}
+
+// Executes LISTEN, UNLISTEN and NOTIFY. Returns false if the statement is not one of them.
+func (s *Server) execNotifyStmt(c *Conn, stmt parser.StmtClass) (bool, error) {
+	switch stmt.Command {
+	case parser.CmdListen:
+		s.notifier.listen(c, stmt.Name)
+	case parser.CmdUnlisten:
+		s.notifier.unlisten(c, stmt.Name)
+	case parser.CmdNotify:
+		return true, s.notify(c, stmt.Name, stmt.Value)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// Sends a notification from a session. Notifications of transaction blocks are sent when
+// the transaction commits, and discarded if it rolls back.
+func (s *Server) notify(c *Conn, channel, payload string) error {
+	if channel == "" {
+		return pgerror.New(pgerror.InvalidParameterValue, "channel name cannot be empty")
+	}
+	if len(payload) >= maxNotifyPayload {
+		return pgerror.New(pgerror.InvalidParameterValue, "payload string too long")
+	}
+	msg := &pgproto3.NotificationResponse{PID: uint32(c.pid), Channel: channel, Payload: payload}
+	if c.xact == nil {
+		s.notifier.publish(c.name, msg)
+		return nil
+	}
+	c.notifyMu.Lock()
+	c.notifications = append(c.notifications, msg)
+	c.notifyMu.Unlock()
+	return nil
+}
+
+// Sends the notifications of the transaction block that ended with a statement, unless it
+// rolled back, and the change notifications of the transactions committed since the
+// previous statement.
+func (s *Server) publishNotifications(c *Conn, stmt parser.StmtClass) {
+	if c.xact == nil {
+		c.notifyMu.Lock()
+		msgs := c.notifications
+		c.notifications = nil
+		c.notifyMu.Unlock()
+		if stmt.Command != parser.CmdRollback && stmt.Command != parser.CmdPrepareTransaction {
+			for _, msg := range msgs {
+				s.notifier.publish(c.name, msg)
+			}
+		}
+	}
+
+	for _, changes := range c.committedChanges {
+		s.notifier.publish(c.name, changes.notification(c.pid))
+	}
+	c.committedChanges = nil
+}
+
+// Keys of the rows of a table changed by a transaction, the payload of change notifications.
+type tableChanges struct {
+	Table     string  `json:"table"`
+	Insert    []int64 `json:"insert,omitempty"`
+	Update    []int64 `json:"update,omitempty"`
+	Delete    []int64 `json:"delete,omitempty"`
+	Truncated bool    `json:"truncated,omitempty"` // Keys are left out, the transaction changed too many rows.
+}
+
+// Records a row change reported by the update hook of the session connection.
+func (c *Conn) recordChange(op, table string, rowid int64) {
+	lower := strings.ToLower(table)
+	if strings.HasPrefix(lower, "sqlite_") || strings.HasPrefix(lower, "kqlite_") {
+		return
+	}
+	if c.changes == nil {
+		c.changes = make(map[string]*tableChanges)
+	}
+	changes := c.changes[table]
+	if changes == nil {
+		changes = &tableChanges{Table: table}
+		c.changes[table] = changes
+	}
+	if changes.Truncated {
+		return
+	}
+	if len(changes.Insert)+len(changes.Update)+len(changes.Delete) >= maxNotifyKeys {
+		changes.Insert, changes.Update, changes.Delete = nil, nil, nil
+		changes.Truncated = true
+		return
+	}
+	switch op {
+	case sqlite.OpInsert:
+		changes.Insert = append(changes.Insert, rowid)
+	case sqlite.OpUpdate:
+		changes.Update = append(changes.Update, rowid)
+	case sqlite.OpDelete:
+		changes.Delete = append(changes.Delete, rowid)
+	}
+}
+
+// Keeps the row changes of a committed transaction for publishChanges.
+func (c *Conn) commitChanges() {
+	for _, changes := range c.changes {
+		c.committedChanges = append(c.committedChanges, changes)
+	}
+	c.changes = nil
+}
+
+// Forgets the row changes of a rolled back transaction.
+func (c *Conn) rollbackChanges() {
+	c.changes = nil
+}
+
+// Returns the change notification of a table, its keys sorted and without duplicates.
+func (t *tableChanges) notification(pid int32) *pgproto3.NotificationResponse {
+	for _, keys := range []*[]int64{&t.Insert, &t.Update, &t.Delete} {
+		slices.Sort(*keys)
+		*keys = slices.Compact(*keys)
+	}
+	payload, _ := json.Marshal(t)
+	if len(payload) >= maxNotifyPayload {
+		payload, _ = json.Marshal(tableChanges{Table: t.Table, Truncated: true})
+	}
+	return &pgproto3.NotificationResponse{
+		PID:     uint32(pid),
+		Channel: changeChannelPrefix + strings.ToLower(t.Table),
+		Payload: string(payload),
+	}
+}
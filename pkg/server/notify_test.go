@@ -0,0 +1,120 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Notifications", Ordered, func() {
+	var notifying *server.Server
+	var conn, listener *pgx.Conn
+	ctx := context.Background()
+
+	// Returns the next notification of the listening session, nil if none arrives shortly.
+	next := func() *pgconn.Notification {
+		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		n, err := listener.WaitForNotification(ctx)
+		if err != nil {
+			return nil
+		}
+		return n
+	}
+
+	exec := func(sql string) {
+		_, err := conn.Exec(ctx, sql)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeAll(func() {
+		notifying = openServer(func(s *server.Server) {
+			s.ChangeNotifications = true
+		})
+		conn, listener = connectTo(ctx, notifying, "notify.db"), connectTo(ctx, notifying, "notify.db")
+		exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`)
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+		Expect(listener.Close(ctx)).To(Succeed())
+	})
+
+	It("Delivers notifications to the listening sessions", func() {
+		_, err := listener.Exec(ctx, `LISTEN jobs`)
+		Expect(err).NotTo(HaveOccurred())
+
+		exec(`NOTIFY jobs, 'first'`)
+		exec(`SELECT pg_notify('jobs', 'second')`)
+		exec(`NOTIFY other, 'ignored'`)
+		for _, payload := range []string{"first", "second"} {
+			n := next()
+			Expect(n).NotTo(BeNil())
+			Expect(n.Channel).To(Equal("jobs"))
+			Expect(n.Payload).To(Equal(payload))
+			Expect(n.PID).To(Equal(conn.PgConn().PID()))
+		}
+		Expect(next()).To(BeNil())
+
+		_, err = listener.Exec(ctx, `UNLISTEN *`)
+		Expect(err).NotTo(HaveOccurred())
+		exec(`NOTIFY jobs, 'unheard'`)
+		Expect(next()).To(BeNil())
+	})
+
+	It("Sends the notifications of transaction blocks once committed", func() {
+		_, err := listener.Exec(ctx, `LISTEN jobs`)
+		Expect(err).NotTo(HaveOccurred())
+
+		exec(`BEGIN`)
+		exec(`NOTIFY jobs, 'rolled back'`)
+		exec(`ROLLBACK`)
+		exec(`BEGIN`)
+		exec(`NOTIFY jobs, 'committed'`)
+		Expect(next()).To(BeNil())
+		exec(`COMMIT`)
+		n := next()
+		Expect(n).NotTo(BeNil())
+		Expect(n.Payload).To(Equal("committed"))
+		Expect(next()).To(BeNil())
+
+		_, err = conn.Exec(ctx, `NOTIFY jobs, '`+strings.Repeat("x", 8000)+`'`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Notifies the keys of the rows changed by transactions", func() {
+		_, err := listener.Exec(ctx, `LISTEN table_items`)
+		Expect(err).NotTo(HaveOccurred())
+
+		exec(`INSERT INTO items VALUES (1, 'one'), (2, 'two')`)
+		n := next()
+		Expect(n).NotTo(BeNil())
+		Expect(n.Channel).To(Equal("table_items"))
+		Expect(n.Payload).To(MatchJSON(`{"table": "items", "insert": [1, 2]}`))
+
+		exec(`BEGIN`)
+		exec(`UPDATE items SET name = 'uno' WHERE id = 1`)
+		exec(`DELETE FROM items WHERE id = 2`)
+		exec(`COMMIT`)
+		n = next()
+		Expect(n).NotTo(BeNil())
+		Expect(n.Payload).To(MatchJSON(`{"table": "items", "update": [1], "delete": [2]}`))
+
+		exec(`BEGIN`)
+		exec(`DELETE FROM items`)
+		exec(`ROLLBACK`)
+		Expect(next()).To(BeNil())
+
+		exec(`INSERT INTO items SELECT value, 'many' FROM (WITH RECURSIVE s(value) AS (SELECT 10 UNION ALL SELECT value + 1 FROM s WHERE value < 1000) SELECT value FROM s)`)
+		n = next()
+		Expect(n).NotTo(BeNil())
+		Expect(n.Payload).To(MatchJSON(`{"table": "items", "truncated": true}`))
+	})
+})
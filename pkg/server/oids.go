@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sysdb"
+)
+
+// First OID of user objects, lower OIDs are those of builtin objects as in PostgreSQL.
+const firstUserOID = 16384
+
+// Schema of the relations of the main SQLite database, as clients name it.
+const publicSchema = "public"
+
+// Relation kinds, as in pg_class.relkind.
+var relationKinds = map[string]string{"table": "r", "view": "v", "index": "i", "matview": "m"}
+
+// Relation identifier.
+type relationKey struct {
+	database string
+	schema   string
+	name     string
+}
+
+type relation struct {
+	oid     uint32
+	kind    string
+	columns []string // Column names in attribute number order.
+
+	// Query and state of materialized views.
+	definition string
+	populated  bool
+}
+
+// oidRegistry assigns OIDs to the relations of databases. OIDs are derived from
+// the database, schema and relation name so they are stable across restarts and
+// drivers can cache them, a collision moves the relation to the next free OID.
+type oidRegistry struct {
+	mu        sync.Mutex
+	relations map[relationKey]*relation
+	keys      map[uint32]relationKey // Relations by OID.
+}
+
+func newOIDRegistry() *oidRegistry {
+	return &oidRegistry{
+		relations: make(map[relationKey]*relation),
+		keys:      make(map[uint32]relationKey),
+	}
+}
+
+// Returns the OID of a relation in the user OID range, hashed from its identifier.
+func relationOID(key relationKey) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key.database + "\x00" + key.schema + "\x00" + key.name))
+	return firstUserOID + h.Sum32()%(math.MaxUint32-firstUserOID+1)
+}
+
+// Returns the OID of a relation, assigning it if the relation isn't registered yet.
+func (r *oidRegistry) lookup(database, schema, name string) uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.assign(relationKey{database: database, schema: schema, name: name}, relation{}).oid
+}
+
+// Returns the OID and the columns of a registered relation, names are matched
+// case insensitively when no relation has the exact name.
+func (r *oidRegistry) attributes(database, schema, name string) (oid uint32, columns []string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rel, ok := r.relations[relationKey{database: database, schema: schema, name: name}]; ok {
+		return rel.oid, rel.columns, true
+	}
+	for key, rel := range r.relations {
+		if key.database == database && key.schema == schema && strings.EqualFold(key.name, name) {
+			return rel.oid, rel.columns, true
+		}
+	}
+	return 0, nil, false
+}
+
+// Returns the name of a relation of the public schema of a database by OID.
+func (r *oidRegistry) name(database string, oid uint32) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[oid]
+	if !ok || key.database != database || key.schema != publicSchema {
+		return "", false
+	}
+	return key.name, true
+}
+
+// Registers a relation, the kind and columns of info replace the registered ones if set.
+// r.mu must be held.
+func (r *oidRegistry) assign(key relationKey, info relation) *relation {
+	if rel, ok := r.relations[key]; ok {
+		if info.kind != "" {
+			rel.kind = info.kind
+			rel.columns = info.columns
+			rel.definition, rel.populated = info.definition, info.populated
+		}
+		return rel
+	}
+
+	oid := relationOID(key)
+	for {
+		if _, taken := r.keys[oid]; !taken {
+			break
+		}
+		if oid++; oid == 0 {
+			oid = firstUserOID
+		}
+	}
+	rel := &relation{oid: oid, kind: info.kind, columns: info.columns, definition: info.definition, populated: info.populated}
+	r.relations[key] = rel
+	r.keys[oid] = key
+	return rel
+}
+
+// Replaces the relations of a database schema, by relation name.
+func (r *oidRegistry) setRelations(database, schema string, relations map[string]relation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, rel := range r.relations {
+		if _, ok := relations[key.name]; key.database == database && key.schema == schema && !ok {
+			delete(r.relations, key)
+			delete(r.keys, rel.oid)
+		}
+	}
+	for name, info := range relations {
+		r.assign(relationKey{database: database, schema: schema, name: name}, info)
+	}
+}
+
+// Returns the registered relations, ordered by OID.
+func (r *oidRegistry) snapshot() []sysdb.Relation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	relations := make([]sysdb.Relation, 0, len(r.relations))
+	for key, rel := range r.relations {
+		relations = append(relations, sysdb.Relation{
+			OID:      rel.oid,
+			Database: key.database,
+			Schema:   key.schema,
+			Name:     key.name,
+			Kind:     rel.kind,
+		})
+	}
+	sort.Slice(relations, func(i, j int) bool { return relations[i].OID < relations[j].OID })
+	return relations
+}
+
+// Returns the registered materialized views, ordered by database and name.
+func (r *oidRegistry) matViews() []sysdb.MatView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var views []sysdb.MatView
+	for key, rel := range r.relations {
+		if rel.kind == relationKinds["matview"] {
+			views = append(views, sysdb.MatView{
+				Database:   key.database,
+				Schema:     key.schema,
+				Name:       key.name,
+				Populated:  rel.populated,
+				Definition: rel.definition,
+			})
+		}
+	}
+	sort.Slice(views, func(i, j int) bool {
+		if views[i].Database != views[j].Database {
+			return views[i].Database < views[j].Database
+		}
+		return views[i].Name < views[j].Name
+	})
+	return views
+}
+
+// Returns whether the statement may create, drop or rename relations.
+func changesSchema(stmt parser.StmtClass) bool {
+	for _, prefix := range []string{"CREATE ", "DROP ", "ALTER "} {
+		if strings.HasPrefix(stmt.Command, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registers the relations of the connection's database, along with their columns,
+// and the schema version of the database.
+func (s *Server) registerRelations(ctx context.Context, c *Conn) {
+	var version int64
+	if err := c.conn.QueryRowContext(ctx, `PRAGMA main.schema_version`).Scan(&version); err != nil {
+		log.Printf("schema version: %s", err)
+	} else {
+		s.mu.Lock()
+		s.schemaVersions[c.name] = version
+		s.mu.Unlock()
+	}
+
+	rows, err := c.conn.QueryContext(ctx, `SELECT m.type, m.name, c.name FROM main.sqlite_master AS m
+		LEFT JOIN pragma_table_info(m.name) AS c
+		WHERE m.type IN ('table', 'view', 'index') AND m.name NOT LIKE 'sqlite\_%' ESCAPE '\'
+			AND m.name NOT LIKE 'kqlite\_%' ESCAPE '\'
+		ORDER BY m.name, c.cid`)
+	if err != nil {
+		log.Printf("register relations: %s", err)
+		return
+	}
+	defer rows.Close()
+	views, err := materializedViews(ctx, c.conn)
+	if err != nil {
+		log.Printf("register relations: %s", err)
+		return
+	}
+
+	relations := make(map[string]relation)
+	for rows.Next() {
+		var kind, name string
+		var column sql.NullString
+		if err := rows.Scan(&kind, &name, &column); err != nil {
+			log.Printf("register relations: %s", err)
+			return
+		}
+		rel := relations[name]
+		rel.kind = relationKinds[kind]
+		if view, ok := views[name]; ok {
+			rel.kind = relationKinds["matview"]
+			rel.definition, rel.populated = view.definition, view.populated
+		}
+		if column.Valid {
+			rel.columns = append(rel.columns, column.String)
+		}
+		relations[name] = rel
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("register relations: %s", err)
+		return
+	}
+	s.oids.setRelations(c.name, publicSchema, relations)
+}
+
+// Sets the table OIDs and attribute numbers of the fields selected from a single table
+// of the main database. Other fields, such as computed ones, are left unset.
+func (s *Server) setFieldOrigins(c *Conn, query string, desc *pgproto3.RowDescription) {
+	source, ok := parser.SelectSource(query)
+	if !ok || (source.Schema != "" && source.Schema != publicSchema && source.Schema != "main") {
+		return
+	}
+	oid, columns, ok := s.oids.attributes(c.name, publicSchema, source.Table)
+	if !ok {
+		return
+	}
+
+	var fields []string
+	for _, column := range source.Columns {
+		if column == "*" {
+			fields = append(fields, columns...)
+		} else {
+			fields = append(fields, column)
+		}
+	}
+	if len(fields) != len(desc.Fields) {
+		return
+	}
+	for i, field := range fields {
+		for j, column := range columns {
+			if field != "" && strings.EqualFold(field, column) {
+				desc.Fields[i].TableOID = oid
+				desc.Fields[i].TableAttributeNumber = uint16(j + 1)
+				break
+			}
+		}
+	}
+}
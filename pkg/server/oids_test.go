@@ -0,0 +1,108 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Relation OIDs", func() {
+	ctx := context.Background()
+
+	// Returns the OIDs of the relations of a database by name.
+	relations := func(conn *pgx.Conn, database string) map[string]uint32 {
+		rows, err := conn.Query(ctx, `SELECT relname, relkind, oid FROM kqlite.relations WHERE database = $1 AND nspname = 'public'`, database)
+		Expect(err).NotTo(HaveOccurred())
+		oids := make(map[string]uint32)
+		for rows.Next() {
+			var name, kind string
+			var oid int64
+			Expect(rows.Scan(&name, &kind, &oid)).To(Succeed())
+			oids[name+"/"+kind] = uint32(oid)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		return oids
+	}
+
+	It("Assigns OIDs that are stable across restarts", func() {
+		dataDir := GinkgoT().TempDir()
+		open := func() (*server.Server, *pgx.Conn) {
+			s := server.NewServer()
+			s.Addr = "127.0.0.1:0"
+			s.DataDir = dataDir
+			Expect(s.Open()).To(Succeed())
+			conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/oids.db?sslmode=disable", s.ListenerAddr()))
+			Expect(err).NotTo(HaveOccurred())
+			return s, conn
+		}
+
+		first, conn := open()
+		for _, query := range []string{
+			`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+			`CREATE INDEX items_name ON items (name)`,
+			`CREATE VIEW named_items AS SELECT name FROM items`,
+			`CREATE TABLE dropped (id INTEGER PRIMARY KEY)`,
+			`DROP TABLE dropped`,
+		} {
+			_, err := conn.Exec(ctx, query)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		oids := relations(conn, "oids.db")
+		Expect(oids).To(HaveLen(3))
+		Expect(oids).To(HaveKey("items/r"))
+		Expect(oids).To(HaveKey("items_name/i"))
+		Expect(oids).To(HaveKey("named_items/v"))
+		for _, oid := range oids {
+			Expect(oid).To(BeNumerically(">=", 16384))
+		}
+		Expect(conn.Close(ctx)).To(Succeed())
+		Expect(first.Close()).To(Succeed())
+
+		second, conn := open()
+		defer func() { Expect(second.Close()).To(Succeed()) }()
+		defer conn.Close(ctx)
+		Expect(relations(conn, "oids.db")).To(Equal(oids))
+	})
+
+	It("Reports the table OID and attribute number of selected columns", func() {
+		conn := connect(ctx, "origins.db")
+		defer conn.Close(ctx)
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, price REAL)`)
+		Expect(err).NotTo(HaveOccurred())
+		oid := relations(conn, "origins.db")["items/r"]
+		Expect(oid).NotTo(BeZero())
+
+		// Returns the table OID and attribute number of each result column, in both protocols.
+		origins := func(query string) [][2]uint32 {
+			var result [][2]uint32
+			for _, mode := range []pgx.QueryExecMode{pgx.QueryExecModeSimpleProtocol, pgx.QueryExecModeCacheStatement} {
+				rows, err := conn.Query(ctx, query, mode)
+				Expect(err).NotTo(HaveOccurred())
+				var fields [][2]uint32
+				for _, field := range rows.FieldDescriptions() {
+					fields = append(fields, [2]uint32{field.TableOID, uint32(field.TableAttributeNumber)})
+				}
+				rows.Close()
+				Expect(rows.Err()).NotTo(HaveOccurred())
+				if result != nil {
+					Expect(fields).To(Equal(result), "%s", query)
+				}
+				result = fields
+			}
+			return result
+		}
+
+		Expect(origins(`SELECT * FROM items`)).To(Equal([][2]uint32{{oid, 1}, {oid, 2}, {oid, 3}}))
+		Expect(origins(`SELECT i.price, name AS label, price * 2 FROM public.items i`)).To(Equal([][2]uint32{{oid, 3}, {oid, 2}, {0, 0}}))
+		Expect(origins(`SELECT count(*) FROM items`)).To(Equal([][2]uint32{{0, 0}}))
+
+		_, err = conn.Exec(ctx, `ALTER TABLE items ADD COLUMN stock INTEGER`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(origins(`SELECT stock FROM items`)).To(Equal([][2]uint32{{oid, 4}}))
+	})
+})
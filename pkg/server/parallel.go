@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Result of a query executing on a read connection.
+type readResult struct {
+	done chan struct{}
+	buf  []byte // Encoded row description, rows and command completion.
+	err  error
+}
+
+// Waits for the query to complete and appends its response to buf.
+func (r *readResult) append(buf []byte) ([]byte, error) {
+	<-r.done
+	return append(buf, r.buf...), r.err
+}
+
+// Reports whether the session can execute queries on read connections: the database is a
+// file, the session is outside of transaction blocks and schemas aren't attached.
+func (s *Server) parallelSession(ctx context.Context, c *Conn) bool {
+	if c.dsn != s.databasePath(c.name) || c.batch {
+		return false
+	}
+	if err := s.syncSchemas(ctx, c); err != nil || len(c.schemas) != 0 {
+		return false
+	}
+	inTx, err := sqlite.InTransaction(c.conn)
+	return err == nil && !inTx
+}
+
+// Reports whether a statement is a read-only query of local relations.
+func (s *Server) parallelRead(c *Conn, stmt parser.StmtClass) bool {
+	if stmt.Command != parser.CmdSelect || !stmt.ReadOnly || !stmt.ReturnsRows {
+		return false
+	}
+	q, err := s.remoteQuery(c, stmt)
+	return err == nil && q == nil
+}
+
+// Reports whether a statement refers to temporary relations of the session.
+func readsTemp(stmt parser.StmtClass, temp []string) bool {
+	for _, table := range stmt.Tables {
+		if slices.Contains(temp, strings.ToLower(table)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the lowercase names of the temporary relations of the session.
+func tempRelations(ctx context.Context, c *Conn) ([]string, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT lower(name) FROM temp.sqlite_master WHERE type IN ('table', 'view')`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// Returns the read connection i of the session, opened on first use.
+func (s *Server) readConn(ctx context.Context, c *Conn, i int) (*sql.Conn, error) {
+	for len(c.readConns) <= i {
+		conn, err := c.db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.initConn(ctx, c, conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		c.readConns = append(c.readConns, conn)
+	}
+	return c.readConns[i], nil
+}
+
+// Starts the leading queries of stmts on read connections of the session when at least two
+// of them can execute concurrently, their results are returned in statement order. Nothing
+// is started otherwise, the statements are executed one by one on the session connection.
+// The returned function cancels the queries still executing and waits for them.
+func (s *Server) startReads(ctx context.Context, c *Conn, stmts []parser.StmtClass) ([]*readResult, func()) {
+	if s.ParallelReads < 2 || s.rateLimit(c.name) > 0 {
+		return nil, func() {}
+	}
+	n := 0
+	for n < len(stmts) && n < s.ParallelReads && s.parallelRead(c, stmts[n]) {
+		n++
+	}
+	if n < 2 || !s.parallelSession(ctx, c) {
+		return nil, func() {}
+	}
+	temp, err := tempRelations(ctx, c)
+	if err != nil {
+		return nil, func() {}
+	}
+	for i, stmt := range stmts[:n] {
+		if readsTemp(stmt, temp) {
+			n = i
+			break
+		}
+	}
+	if n < 2 {
+		return nil, func() {}
+	}
+
+	// Queries are translated on the session connection, failures are reported when the
+	// statements are executed one by one.
+	queries := make([]string, n)
+	conns := make([]*sql.Conn, n)
+	for i, stmt := range stmts[:n] {
+		if stmt.SQL, err = s.inlineFunctions(ctx, c, stmt.SQL); err != nil {
+			return nil, func() {}
+		}
+		text, err := translateDDL(ctx, c, stmt)
+		if err != nil {
+			return nil, func() {}
+		}
+		queries[i] = s.rewriteQuery(c, text)
+		if conns[i], err = s.readConn(ctx, c, i); err != nil {
+			return nil, func() {}
+		}
+	}
+	for i, stmt := range stmts[:n] {
+		if trackUsage(stmt) {
+			s.usage.record(ctx, c, stmt, s.fingerprint(c, stmt.SQL), queries[i], nil)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	results := make([]*readResult, n)
+	for i, stmt := range stmts[:n] {
+		r := &readResult{done: make(chan struct{})}
+		results[i] = r
+		wg.Add(1)
+		go func(conn *sql.Conn, stmt parser.StmtClass, query string) {
+			defer wg.Done()
+			defer close(r.done)
+			release, err := s.acquireRead(ctx, c, stmt)
+			if err != nil {
+				r.err = err
+				return
+			}
+			defer release()
+			r.err = s.retry(ctx, c, stmt, func() (err error) {
+				r.buf, err = s.queryRows(ctx, c, conn, stmt, query, r.buf[:0])
+				return err
+			})
+		}(conns[i], stmt, queries[i])
+	}
+	return results, func() {
+		cancel()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,72 @@
+package server_test
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Parallel reads", Ordered, func() {
+	var parallel *server.Server
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	// Returns the values of the first column of each result of a multi-statement query.
+	results := func(sql string) ([][]string, error) {
+		rs, err := conn.PgConn().Exec(ctx, sql).ReadAll()
+		var values [][]string
+		for _, r := range rs {
+			var column []string
+			for _, row := range r.Rows {
+				column = append(column, string(row[0]))
+			}
+			values = append(values, column)
+		}
+		return values, err
+	}
+
+	BeforeAll(func() {
+		parallel = openServer(func(s *server.Server) {
+			s.ParallelReads = 4
+		})
+		conn = connectTo(ctx, parallel, "parallel.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);
+			INSERT INTO items VALUES (1, 'one'), (2, 'two'), (3, 'three')`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Returns the results of the queries in statement order", func() {
+		values, err := results(`SELECT name FROM items WHERE id = 1; SELECT name FROM items WHERE id > 1 ORDER BY id;
+			SELECT count(*) FROM items; SELECT name FROM items WHERE id = 3; SELECT name FROM items WHERE id = 2`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal([][]string{{"one"}, {"two", "three"}, {"3"}, {"three"}, {"two"}}))
+	})
+
+	It("Sees the changes of the previous statements of the query", func() {
+		values, err := results(`SET TimeZone = 'UTC'; SELECT current_setting('TimeZone'); SELECT count(*) FROM items;
+			INSERT INTO items VALUES (4, 'four'); SELECT count(*) FROM items; SELECT name FROM items WHERE id = 4`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal([][]string{nil, {"UTC"}, {"3"}, nil, {"4"}, {"four"}}))
+	})
+
+	It("Reads temporary relations on the session connection", func() {
+		values, err := results(`CREATE TEMP TABLE scratch (id INTEGER); INSERT INTO scratch VALUES (1);
+			SELECT count(*) FROM scratch; SELECT count(*) FROM items`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal([][]string{nil, nil, {"1"}, {"4"}}))
+	})
+
+	It("Stops at the first failing query", func() {
+		values, err := results(`SELECT count(*) FROM items; SELECT * FROM missing; SELECT count(*) FROM items`)
+		Expect(err).To(MatchError(ContainSubstring("missing")))
+		Expect(values).To(Equal([][]string{{"4"}}))
+	})
+})
@@ -0,0 +1,429 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Time zones don't depend on the host zoneinfo database.
+	"unicode"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Settings reported to clients with ParameterStatus messages on startup and
+// whenever they change, with their defaults.
+var reportedParams = []struct {
+	name     string
+	value    string
+	readOnly bool
+}{
+	{"server_version", ServerVersion, true},
+	{"server_encoding", "UTF8", true},
+	{"client_encoding", "UTF8", false},
+	{"application_name", "", false},
+	{"DateStyle", "ISO, MDY", false},
+	{"integer_datetimes", "on", true},
+	{"standard_conforming_strings", "on", false},
+	{"TimeZone", "UTC", false},
+	{"default_transaction_read_only", "off", false},
+	{"in_hot_standby", "off", true},
+}
+
+// Settings that aren't reported to clients but may be given as startup parameters, with their defaults.
+var startupParams = map[string]string{
+	"search_path":                   `"$user", public`,
+	"default_transaction_isolation": "read committed",
+	maxRowsParam:                    "0",
+	maxRowsActionParam:              "error",
+}
+
+// Isolation levels of transactions, by name.
+var isolationLevels = []string{"serializable", "repeatable read", "read committed", "read uncommitted"}
+
+// Returns the reported setting of the same name, setting names are case insensitive.
+func reportedParam(name string) (canonical string, readOnly, ok bool) {
+	for _, p := range reportedParams {
+		if strings.EqualFold(p.name, name) {
+			return p.name, p.readOnly, true
+		}
+	}
+	return "", false, false
+}
+
+// Initializes the session settings with their defaults, overridden by the defaults of
+// the server and then by the settings given as startup parameters.
+func (c *Conn) initParams(startup, defaults map[string]string) error {
+	c.params = make(map[string]string)
+	c.defaults = make(map[string]string)
+	c.location = time.UTC
+	for _, p := range reportedParams {
+		c.params[strings.ToLower(p.name)] = p.value
+	}
+	for name, value := range startupParams {
+		c.params[name] = value
+	}
+	for name, value := range defaults {
+		if err := c.setParam(name, value); err != nil {
+			return err
+		}
+	}
+	if err := checkTargetSessionAttrs(startup["target_session_attrs"]); err != nil {
+		return err
+	}
+	// Settings of the options parameter come first, other startup parameters override
+	// them as in PostgreSQL.
+	options, err := startupOptions(startup["options"])
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		if err := c.setParam(option[0], option[1]); err != nil {
+			return err
+		}
+	}
+	for name, value := range startup {
+		_, readOnly, ok := reportedParam(name)
+		if _, settable := startupParams[strings.ToLower(name)]; !settable && (!ok || readOnly) {
+			continue
+		}
+		if err := c.setParam(name, value); err != nil {
+			return err
+		}
+	}
+	c.paramChanges = nil
+	c.params["transaction_read_only"] = c.params["default_transaction_read_only"]
+	c.params["transaction_isolation"] = c.params["default_transaction_isolation"]
+	c.params[traceParam] = onOff(c.trace.Load())
+
+	for name, value := range c.params {
+		c.defaults[name] = value
+	}
+	return nil
+}
+
+// Returns the defaults of the session settings configured on the server.
+func (s *Server) sessionDefaults() map[string]string {
+	return map[string]string{maxRowsParam: strconv.FormatInt(max(s.MaxResultRows, 0), 10)}
+}
+
+// Sets a session setting, changes of reported settings are reported to the client
+// before the next ReadyForQuery.
+func (c *Conn) setParam(name, value string) error {
+	canonical, readOnly, reported := reportedParam(name)
+	if readOnly {
+		return pgerror.Newf(pgerror.CantChangeRuntimeParam, "parameter %q cannot be changed", canonical)
+	}
+
+	key := strings.ToLower(name)
+	switch key {
+	case "client_encoding":
+		switch strings.ToUpper(strings.ReplaceAll(value, "-", "")) {
+		case "UTF8", "UNICODE":
+			value = "UTF8"
+		default:
+			return pgerror.Newf(pgerror.FeatureNotSupported, "client encoding %q is not supported, only UTF8 is", value)
+		}
+	case "application_name":
+		value = applicationName(value)
+		c.setApplication(value)
+	case "datestyle":
+		var err error
+		if value, err = dateStyle(c.params[key], value); err != nil {
+			return err
+		}
+	case "timezone":
+		loc, err := timeZone(value)
+		if err != nil {
+			return err
+		}
+		c.location = loc
+	case "standard_conforming_strings":
+		if !isOn(value) {
+			return pgerror.New(pgerror.FeatureNotSupported, "non-standard conforming strings are not supported")
+		}
+		value = "on"
+	case "transaction_read_only", "default_transaction_read_only":
+		value = onOff(isOn(value))
+		if key == "transaction_read_only" && value == "off" && isOn(c.params[key]) && c.xact != nil && c.xact.queried {
+			return pgerror.New(pgerror.ActiveSQLTransaction, "transaction read-write mode must be set before any query")
+		}
+	case "transaction_isolation", "default_transaction_isolation":
+		value = strings.ToLower(value)
+		if !slices.Contains(isolationLevels, value) {
+			e := pgerror.Newf(pgerror.InvalidParameterValue, "invalid value for parameter %q: %q", key, value)
+			e.Hint = "Available values: " + strings.Join(isolationLevels, ", ") + "."
+			return e
+		}
+		if key == "transaction_isolation" && value != c.params[key] && c.xact != nil && c.xact.queried {
+			return pgerror.New(pgerror.ActiveSQLTransaction, "SET TRANSACTION ISOLATION LEVEL must be called before any query")
+		}
+	case traceParam:
+		value = onOff(isOn(value))
+		c.trace.Store(isOn(value))
+	case maxRowsParam:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return pgerror.Newf(pgerror.InvalidParameterValue, "invalid value for parameter %q: %q", maxRowsParam, value)
+		}
+		c.maxRows = n
+	case maxRowsActionParam:
+		switch value = strings.ToLower(value); value {
+		case "error", "truncate":
+			c.truncateRows = value == "truncate"
+		default:
+			e := pgerror.Newf(pgerror.InvalidParameterValue, "invalid value for parameter %q: %q", maxRowsActionParam, value)
+			e.Hint = "Available values: error, truncate."
+			return e
+		}
+	}
+
+	if reported && c.params[key] != value {
+		c.paramChanges = append(c.paramChanges, canonical)
+	}
+	c.params[key] = value
+	return nil
+}
+
+// Returns the current value of a session setting, empty if it isn't set.
+func (c *Conn) param(name string) string {
+	key := strings.ToLower(name)
+	// Other sessions trace the connection with kqlite_trace().
+	if key == traceParam {
+		return onOff(c.trace.Load())
+	}
+	return c.params[key]
+}
+
+// Resets a session setting to its default.
+func (c *Conn) resetParam(name string) {
+	key := strings.ToLower(name)
+	if _, readOnly, _ := reportedParam(name); readOnly {
+		return
+	}
+	if value, ok := c.defaults[key]; ok {
+		c.setParam(key, value)
+	} else {
+		delete(c.params, key)
+	}
+}
+
+// Resets all session settings to their defaults.
+func (c *Conn) resetParams() {
+	for name := range c.params {
+		c.resetParam(name)
+	}
+}
+
+// Setting changed in a transaction block, with its value before the transaction and the
+// value the session keeps once the transaction commits, nil if the setting wasn't set.
+type savedParam struct {
+	before  *string
+	session *string
+}
+
+// Records the value of a setting before SET, SET LOCAL or RESET changes it in a
+// transaction block. Outside of transaction blocks nothing is recorded.
+func (c *Conn) saveParam(name string) {
+	key := strings.ToLower(name)
+	if c.xact == nil || c.xact.params[key] != nil {
+		return
+	}
+	if c.xact.params == nil {
+		c.xact.params = make(map[string]*savedParam)
+	}
+	value := c.paramValue(key)
+	c.xact.params[key] = &savedParam{before: value, session: value}
+}
+
+// Records the value of a setting changed by SET or RESET in a transaction block as the
+// value of the session once the transaction commits, unlike those of SET LOCAL.
+func (c *Conn) keepParam(name string) {
+	key := strings.ToLower(name)
+	if c.xact != nil && c.xact.params[key] != nil {
+		c.xact.params[key].session = c.paramValue(key)
+	}
+}
+
+// Restores the settings changed in a transaction block as it ends: the values of the
+// session once committed, the values before the transaction once rolled back.
+func (c *Conn) restoreParams(xact *transaction, commit bool) {
+	for key, p := range xact.params {
+		value := p.before
+		if commit {
+			value = p.session
+		}
+		if value == nil {
+			delete(c.params, key)
+		} else {
+			c.setParam(key, *value)
+		}
+	}
+}
+
+// Returns the value of a session setting, nil if it isn't set.
+func (c *Conn) paramValue(key string) *string {
+	if value, ok := c.params[key]; ok {
+		return &value
+	}
+	return nil
+}
+
+// Returns the ParameterStatus messages of all reported settings.
+func (c *Conn) paramStatus() []pgproto3.Message {
+	msgs := make([]pgproto3.Message, 0, len(reportedParams))
+	for _, p := range reportedParams {
+		msgs = append(msgs, &pgproto3.ParameterStatus{Name: p.name, Value: c.params[strings.ToLower(p.name)]})
+	}
+	return msgs
+}
+
+// Appends the ParameterStatus messages of the reported settings changed since the last call.
+func (c *Conn) appendParamChanges(buf []byte) []byte {
+	for _, name := range c.paramChanges {
+		buf, _ = (&pgproto3.ParameterStatus{Name: name, Value: c.params[strings.ToLower(name)]}).Encode(buf)
+	}
+	c.paramChanges = nil
+	return buf
+}
+
+// Checks the target_session_attrs startup parameter of multi-host connection strings,
+// which libpq and pgx check on their own but other clients pass to the server.
+// The server is always a writable primary, sessions requiring a read-only server
+// or a standby are refused so clients try the next host.
+func checkTargetSessionAttrs(value string) error {
+	switch value {
+	case "", "any", "read-write", "primary", "prefer-standby":
+		return nil
+	case "read-only", "standby":
+		return pgerror.Newf(pgerror.ConnectionRejected, "server is a read-write primary, target_session_attrs=%s requires a standby", value)
+	}
+	return pgerror.Newf(pgerror.InvalidParameterValue, "invalid value for parameter \"target_session_attrs\": %q", value)
+}
+
+// Returns the settings of the options startup parameter, in order. Options are
+// command-line switches separated by spaces, backslashes escape spaces in values.
+// Only settings are supported, as "-c name=value", "-cname=value" or "--name=value".
+func startupOptions(value string) ([][2]string, error) {
+	var args []string
+	var arg strings.Builder
+	escaped, inArg := false, false
+	for _, r := range value {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inArg = true, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	var settings [][2]string
+	for i := 0; i < len(args); i++ {
+		var setting string
+		switch arg := args[i]; {
+		case arg == "-c" && i+1 < len(args):
+			i++
+			setting = args[i]
+		case strings.HasPrefix(arg, "--"):
+			// Dashes of long option names stand for underscores.
+			name, value, _ := strings.Cut(arg[2:], "=")
+			setting = strings.ReplaceAll(name, "-", "_") + "=" + value
+		case strings.HasPrefix(arg, "-c") && len(arg) > 2:
+			setting = arg[2:]
+		default:
+			return nil, pgerror.Newf(pgerror.SyntaxError, "invalid command-line argument for server process: %s", arg)
+		}
+		name, value, ok := strings.Cut(setting, "=")
+		if !ok || name == "" {
+			return nil, pgerror.Newf(pgerror.SyntaxError, "-c %s requires a value", setting)
+		}
+		settings = append(settings, [2]string{name, value})
+	}
+	return settings, nil
+}
+
+// Returns an application name as PostgreSQL keeps it, truncated to 63 bytes with
+// characters other than printable ASCII replaced by question marks.
+func applicationName(value string) string {
+	name := []byte(value)
+	for i, b := range name {
+		if b < 32 || b > 126 {
+			name[i] = '?'
+		}
+	}
+	return string(name[:min(len(name), 63)])
+}
+
+// Returns the DateStyle after setting value, which may change the output
+// format, the field order or both.
+func dateStyle(current, value string) (string, error) {
+	format, order, _ := strings.Cut(current, ", ")
+	for _, part := range strings.Split(value, ",") {
+		switch part = strings.ToUpper(strings.TrimSpace(part)); part {
+		case "ISO", "SQL", "POSTGRES", "GERMAN":
+			format = part
+		case "DMY", "MDY", "YMD":
+			order = part
+		case "EURO", "EUROPEAN":
+			order = "DMY"
+		case "US", "NONEURO", "NONEUROPEAN":
+			order = "MDY"
+		default:
+			return "", pgerror.Newf(pgerror.InvalidParameterValue, "invalid value for parameter \"DateStyle\": %q", value)
+		}
+	}
+	if format != "ISO" {
+		return "", pgerror.Newf(pgerror.FeatureNotSupported, "date style %s is not supported, only ISO is", format)
+	}
+	return fmt.Sprintf("%s, %s", format, order), nil
+}
+
+// Returns the location of a TimeZone setting, a time zone name or an offset from UTC in hours.
+func timeZone(value string) (*time.Location, error) {
+	if hours, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.FixedZone(value, int(hours*3600)), nil
+	}
+	if strings.EqualFold(value, "UTC") || strings.EqualFold(value, "GMT") {
+		return time.UTC, nil
+	}
+	// The host time zone is never used, PostgreSQL has no "Local" time zone either.
+	if value != "Local" {
+		if loc, err := time.LoadLocation(value); err == nil {
+			return loc, nil
+		}
+	}
+	return nil, pgerror.Newf(pgerror.InvalidParameterValue, "invalid value for parameter \"TimeZone\": %q", value)
+}
+
+// Reports whether a boolean setting value is true.
+func isOn(value string) bool {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// Returns the value of a boolean setting as PostgreSQL shows it.
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,260 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parameter status", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "params.db")
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Reports settings on startup", func() {
+		pgConn := conn.PgConn()
+		Expect(pgConn.ParameterStatus("client_encoding")).To(Equal("UTF8"))
+		Expect(pgConn.ParameterStatus("server_encoding")).To(Equal("UTF8"))
+		Expect(pgConn.ParameterStatus("DateStyle")).To(Equal("ISO, MDY"))
+		Expect(pgConn.ParameterStatus("integer_datetimes")).To(Equal("on"))
+		Expect(pgConn.ParameterStatus("standard_conforming_strings")).To(Equal("on"))
+		Expect(pgConn.ParameterStatus("TimeZone")).To(Equal("UTC"))
+		Expect(pgConn.ParameterStatus("server_version")).NotTo(BeEmpty())
+		Expect(pgConn.ParameterStatus("in_hot_standby")).To(Equal("off"))
+		Expect(pgConn.ParameterStatus("default_transaction_read_only")).To(Equal("off"))
+	})
+
+	It("Shows session settings", func() {
+		_, err := conn.Exec(ctx, `SET TIME ZONE 'Europe/Paris'`)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Exec(ctx, `RESET TIME ZONE`)
+
+		var timezone, readOnly, setting, recovery string
+		Expect(conn.QueryRow(ctx, `SHOW TimeZone`, pgx.QueryExecModeSimpleProtocol).Scan(&timezone)).To(Succeed())
+		Expect(timezone).To(Equal("Europe/Paris"))
+		Expect(conn.QueryRow(ctx, `show transaction_read_only`).Scan(&readOnly)).To(Succeed())
+		Expect(readOnly).To(Equal("off"))
+		Expect(conn.QueryRow(ctx, `SELECT current_setting('datestyle')`).Scan(&setting)).To(Succeed())
+		Expect(setting).To(Equal("ISO, MDY"))
+		Expect(conn.QueryRow(ctx, `SELECT pg_is_in_recovery()`).Scan(&recovery)).To(Succeed())
+		Expect(recovery).To(Equal("f"))
+	})
+
+	It("Routes sessions by target_session_attrs", func() {
+		// Multi-host connection strings skip hosts that don't match.
+		url := fmt.Sprintf("postgres://127.0.0.1:1,%s/params.db?sslmode=disable&target_session_attrs=", srv.ListenerAddr())
+		primary, err := pgx.Connect(ctx, url+"read-write")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(primary.Close(ctx)).To(Succeed())
+
+		_, err = pgx.Connect(ctx, url+"read-only")
+		Expect(err).To(HaveOccurred())
+
+		// Clients passing the parameter to the server are refused by it.
+		config, err := pgconn.ParseConfig(fmt.Sprintf("postgres://%s/params.db?sslmode=disable", srv.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		config.RuntimeParams["target_session_attrs"] = "standby"
+		_, err = pgconn.ConnectConfig(ctx, config)
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("08004"))
+
+		config.RuntimeParams["target_session_attrs"] = "primary"
+		other, err := pgconn.ConnectConfig(ctx, config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Close(ctx)).To(Succeed())
+	})
+
+	It("Reports settings changed with SET and RESET", func() {
+		pgConn := conn.PgConn()
+		_, err := conn.Exec(ctx, `SET TIME ZONE 'Europe/Paris'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pgConn.ParameterStatus("TimeZone")).To(Equal("Europe/Paris"))
+
+		_, err = conn.Exec(ctx, `SET datestyle = dmy`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pgConn.ParameterStatus("DateStyle")).To(Equal("ISO, DMY"))
+
+		_, err = conn.Exec(ctx, `RESET timezone`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pgConn.ParameterStatus("TimeZone")).To(Equal("UTC"))
+
+		_, err = conn.Exec(ctx, `RESET ALL`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pgConn.ParameterStatus("DateStyle")).To(Equal("ISO, MDY"))
+	})
+
+	It("Reverts SET LOCAL settings as transactions end", func() {
+		pgConn := conn.PgConn()
+		for _, end := range []string{"COMMIT", "ROLLBACK"} {
+			_, err := conn.Exec(ctx, `BEGIN`)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = conn.Exec(ctx, `SET LOCAL TIME ZONE 'Europe/Paris'`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pgConn.ParameterStatus("TimeZone")).To(Equal("Europe/Paris"))
+			var timezone string
+			Expect(conn.QueryRow(ctx, `SHOW TimeZone`).Scan(&timezone)).To(Succeed())
+			Expect(timezone).To(Equal("Europe/Paris"))
+			_, err = conn.Exec(ctx, end)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pgConn.ParameterStatus("TimeZone")).To(Equal("UTC"))
+		}
+
+		// SET LOCAL has no effect outside of transaction blocks.
+		_, err := conn.Exec(ctx, `SET LOCAL datestyle = dmy`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pgConn.ParameterStatus("DateStyle")).To(Equal("ISO, MDY"))
+	})
+
+	It("Reverts settings of rolled back transactions", func() {
+		pgConn := conn.PgConn()
+		_, err := conn.Exec(ctx, `BEGIN`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SET datestyle = dmy`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SET TIME ZONE 'Europe/Paris'`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `ROLLBACK`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pgConn.ParameterStatus("DateStyle")).To(Equal("ISO, MDY"))
+		Expect(pgConn.ParameterStatus("TimeZone")).To(Equal("UTC"))
+
+		// Committed settings are kept, over the SET LOCAL values of the transaction.
+		_, err = conn.Exec(ctx, `BEGIN`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SET datestyle = dmy`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SET LOCAL datestyle = ymd`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pgConn.ParameterStatus("DateStyle")).To(Equal("ISO, YMD"))
+		_, err = conn.Exec(ctx, `COMMIT`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pgConn.ParameterStatus("DateStyle")).To(Equal("ISO, DMY"))
+
+		_, err = conn.Exec(ctx, `RESET datestyle`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Rejects unsupported settings", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `SET client_encoding = 'LATIN1'`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+
+		_, err = conn.Exec(ctx, `SET server_version = '1'`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("55P02"))
+
+		_, err = conn.Exec(ctx, `SET client_encoding = 'utf-8'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.PgConn().ParameterStatus("client_encoding")).To(Equal("UTF8"))
+	})
+
+	It("Renders timestamps in the session time zone", func() {
+		_, err := conn.Exec(ctx, `CREATE TABLE events (at TIMESTAMP, day DATE)`)
+		Expect(err).NotTo(HaveOccurred())
+		// Daylight saving time starts at 01:00 UTC in Europe/Paris on 2024-03-31.
+		_, err = conn.Exec(ctx, `INSERT INTO events (at, day) VALUES ('2024-03-31 00:30:00', '2024-03-31'), ('2024-03-31 01:30:00.25', '2024-03-31')`)
+		Expect(err).NotTo(HaveOccurred())
+
+		rendered := func() []string {
+			var values []string
+			for _, mode := range []pgx.QueryExecMode{pgx.QueryExecModeSimpleProtocol, pgx.QueryExecModeCacheStatement} {
+				rows, err := conn.Query(ctx, `SELECT at, day FROM events ORDER BY at`, mode)
+				Expect(err).NotTo(HaveOccurred())
+				for rows.Next() {
+					var at, day string
+					Expect(rows.Scan(&at, &day)).To(Succeed())
+					values = append(values, at+" "+day)
+				}
+				Expect(rows.Err()).NotTo(HaveOccurred())
+			}
+			return values
+		}
+
+		Expect(rendered()).To(Equal([]string{
+			"2024-03-31 00:30:00+00 2024-03-31", "2024-03-31 01:30:00.25+00 2024-03-31",
+			"2024-03-31 00:30:00+00 2024-03-31", "2024-03-31 01:30:00.25+00 2024-03-31",
+		}))
+
+		_, err = conn.Exec(ctx, `SET TIME ZONE 'Europe/Paris'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered()).To(Equal([]string{
+			"2024-03-31 01:30:00+01 2024-03-31", "2024-03-31 03:30:00.25+02 2024-03-31",
+			"2024-03-31 01:30:00+01 2024-03-31", "2024-03-31 03:30:00.25+02 2024-03-31",
+		}))
+
+		_, err = conn.Exec(ctx, `SET TIME ZONE 'Asia/Kolkata'`)
+		Expect(err).NotTo(HaveOccurred())
+		var now string
+		Expect(conn.QueryRow(ctx, `SELECT now()`).Scan(&now)).To(Succeed())
+		Expect(now).To(HaveSuffix("+05:30"))
+
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `SET TIME ZONE 'Mars/Olympus_Mons'`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("22023"))
+
+		_, err = conn.Exec(ctx, `RESET TIME ZONE`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Applies settings given on startup", func() {
+		other, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/params.db?sslmode=disable&timezone=Asia/Tokyo", srv.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		defer other.Close(ctx)
+		Expect(other.PgConn().ParameterStatus("TimeZone")).To(Equal("Asia/Tokyo"))
+
+		_, err = other.Exec(ctx, `SET TIME ZONE 'UTC'; RESET TIME ZONE`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.PgConn().ParameterStatus("TimeZone")).To(Equal("Asia/Tokyo"))
+
+		_, err = pgx.Connect(ctx, fmt.Sprintf("postgres://%s/params.db?sslmode=disable&client_encoding=SQL_ASCII", srv.ListenerAddr()))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Applies settings of the options startup parameter", func() {
+		config, err := pgx.ParseConfig(fmt.Sprintf("postgres://%s/params.db?sslmode=disable", srv.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		config.RuntimeParams["options"] = `-c statement_timeout=5s -csearch_path=app,\ public --datestyle=dmy`
+		other, err := pgx.ConnectConfig(ctx, config)
+		Expect(err).NotTo(HaveOccurred())
+		defer other.Close(ctx)
+		Expect(other.PgConn().ParameterStatus("DateStyle")).To(Equal("ISO, DMY"))
+
+		var timeout, searchPath string
+		Expect(other.QueryRow(ctx, `SHOW statement_timeout`, pgx.QueryExecModeSimpleProtocol).Scan(&timeout)).To(Succeed())
+		Expect(timeout).To(Equal("5s"))
+		Expect(other.QueryRow(ctx, `SELECT current_setting('search_path')`).Scan(&searchPath)).To(Succeed())
+		Expect(searchPath).To(Equal("app, public"))
+
+		// Startup parameters take precedence over the options.
+		config.RuntimeParams["options"] = "-c TimeZone=Asia/Tokyo -c DateStyle=dmy"
+		config.RuntimeParams["timezone"] = "Europe/Paris"
+		other, err = pgx.ConnectConfig(ctx, config)
+		Expect(err).NotTo(HaveOccurred())
+		defer other.Close(ctx)
+		Expect(other.PgConn().ParameterStatus("TimeZone")).To(Equal("Europe/Paris"))
+		Expect(other.PgConn().ParameterStatus("DateStyle")).To(Equal("ISO, DMY"))
+
+		var pgErr *pgconn.PgError
+		for _, options := range []string{"-B 1000", "-c statement_timeout", "-c server_version=1"} {
+			config.RuntimeParams["options"] = options
+			_, err = pgx.ConnectConfig(ctx, config)
+			Expect(errors.As(err, &pgErr)).To(BeTrue(), options)
+		}
+		Expect(pgErr.Code).To(Equal("55P02"))
+	})
+})
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+)
+
+// Results of parsing queries cached by the plan cache.
+const (
+	planClassify    = iota // Statements of parser.ClassifyQuery.
+	planRewrite            // Text of parser.RewriteQuery.
+	planParse              // Statements, arguments and tables of parser.ParseQuery.
+	planFingerprint        // Fingerprint of parser.Fingerprint.
+)
+
+// Cached parse result, keyed by the query text rather than its fingerprint as computing
+// the fingerprint parses the query.
+type planKey struct {
+	database string
+	kind     int
+	query    string
+}
+
+// Results of parser.ParseQuery.
+type parsedQuery struct {
+	stmts  []parser.StmtClass
+	result []parser.ParserStmtResult
+}
+
+type planEntry struct {
+	key   planKey
+	value interface{}
+}
+
+// planCache caches the results of parsing queries with pg_query, shared by the sessions of
+// a database. Parse results only depend on the query text, entries of a database are still
+// dropped by its DDL so that statements of dropped and replaced relations don't linger.
+type planCache struct {
+	mu      sync.Mutex
+	entries map[planKey]*list.Element
+	lru     list.List // Entries, most recently used first.
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func newPlanCache() *planCache {
+	return &planCache{entries: make(map[planKey]*list.Element)}
+}
+
+// Returns a cached result and marks it as used.
+func (p *planCache) get(key planKey) (interface{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[key]
+	if !ok {
+		p.misses.Add(1)
+		return nil, false
+	}
+	p.hits.Add(1)
+	p.lru.MoveToFront(e)
+	return e.Value.(*planEntry).value, true
+}
+
+// Adds a result to the cache, the least recently used ones are evicted over size entries.
+func (p *planCache) put(key planKey, value interface{}, size int) {
+	if size <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.entries[key]; ok {
+		e.Value.(*planEntry).value = value
+		p.lru.MoveToFront(e)
+		return
+	}
+	p.entries[key] = p.lru.PushFront(&planEntry{key: key, value: value})
+	for p.lru.Len() > size {
+		p.remove(p.lru.Back())
+	}
+}
+
+// Drops the cached results of a database.
+func (p *planCache) invalidate(database string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for e := p.lru.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*planEntry).key.database == database {
+			p.remove(e)
+		}
+		e = next
+	}
+}
+
+// Removes an entry, p.mu must be held.
+func (p *planCache) remove(e *list.Element) {
+	delete(p.entries, e.Value.(*planEntry).key)
+	p.lru.Remove(e)
+}
+
+// Number of cached results.
+func (p *planCache) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// Classifies the statements of a query of the session database through the plan cache.
+// The statements are copies, callers may change them.
+func (s *Server) classifyQuery(c *Conn, sql string) ([]parser.StmtClass, error) {
+	key := planKey{c.name, planClassify, sql}
+	if stmts, ok := s.plans.get(key); ok {
+		return slices.Clone(stmts.([]parser.StmtClass)), nil
+	}
+	stmts, err := parser.ClassifyQuery(sql)
+	if err != nil {
+		return nil, err
+	}
+	s.plans.put(key, stmts, s.PlanCacheSize)
+	return slices.Clone(stmts), nil
+}
+
+// Rewrites a query of the session database for SQLite through the plan cache.
+func (s *Server) rewriteQuery(c *Conn, sql string) string {
+	key := planKey{c.name, planRewrite, sql}
+	if query, ok := s.plans.get(key); ok {
+		return query.(string)
+	}
+	query := parser.RewriteQuery(sql)
+	s.plans.put(key, query, s.PlanCacheSize)
+	return query
+}
+
+// Classifies the statements of a query of the session database and parses their arguments
+// and tables through the plan cache. The statements are copies, callers may change them,
+// the arguments and tables are shared.
+func (s *Server) parseQuery(c *Conn, sql string) ([]parser.StmtClass, []parser.ParserStmtResult, error) {
+	key := planKey{c.name, planParse, sql}
+	if parsed, ok := s.plans.get(key); ok {
+		parsed := parsed.(parsedQuery)
+		return slices.Clone(parsed.stmts), parsed.result, nil
+	}
+	stmts, result, err := parser.ParseQuery(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.plans.put(key, parsedQuery{stmts, result}, s.PlanCacheSize)
+	return slices.Clone(stmts), result, nil
+}
+
+// Returns the fingerprint of a statement of the session database through the plan cache,
+// empty if it can't be parsed.
+func (s *Server) fingerprint(c *Conn, sql string) string {
+	key := planKey{c.name, planFingerprint, sql}
+	if fingerprint, ok := s.plans.get(key); ok {
+		return fingerprint.(string)
+	}
+	fingerprint, err := parser.Fingerprint(sql)
+	if err != nil {
+		return ""
+	}
+	s.plans.put(key, fingerprint, s.PlanCacheSize)
+	return fingerprint
+}
@@ -0,0 +1,54 @@
+package server_test
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Plan cache", func() {
+	ctx := context.Background()
+
+	It("Shares parse results between sessions until DDL", func() {
+		conn, other, observer := connect(ctx, "plans.db"), connect(ctx, "plans.db"), connect(ctx, "postgres")
+		defer conn.Close(ctx)
+		defer other.Close(ctx)
+		defer observer.Close(ctx)
+
+		misses := func() int64 {
+			var value int64
+			Expect(observer.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = 'plan_cache_misses'`,
+				pgx.QueryExecModeSimpleProtocol).Scan(&value)).To(Succeed())
+			return value
+		}
+		// Statements prepared by pgx aren't parsed again, queries are sent as they are.
+		names := func(conn *pgx.Conn) []string {
+			rows, err := conn.Query(ctx, `SELECT name FROM items WHERE id > 0 ORDER BY id`, pgx.QueryExecModeSimpleProtocol)
+			Expect(err).NotTo(HaveOccurred())
+			values, err := pgx.CollectRows(rows, pgx.RowTo[string])
+			Expect(err).NotTo(HaveOccurred())
+			return values
+		}
+
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO items VALUES (1, 'a')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names(conn)).To(Equal([]string{"a"}))
+
+		// Statistics are refreshed before the query reading them is parsed.
+		misses()
+		before := misses()
+		Expect(names(other)).To(Equal([]string{"a"}))
+		Expect(misses()).To(Equal(before))
+
+		_, err = conn.Exec(ctx, `ALTER TABLE items RENAME COLUMN name TO label`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `ALTER TABLE items ADD COLUMN name TEXT DEFAULT 'b'`)
+		Expect(err).NotTo(HaveOccurred())
+		misses()
+		before = misses()
+		Expect(names(other)).To(Equal([]string{"b"}))
+		Expect(misses()).To(BeNumerically(">", before))
+	})
+})
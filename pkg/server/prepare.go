@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Executes the PREPARE and EXECUTE statements of simple queries with the prepared
+// statements of the extended protocol. DEALLOCATE is a session statement.
+func (s *Server) execPreparedStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, buf []byte) ([]byte, bool, error) {
+	switch stmt.Command {
+	case parser.CmdPrepare:
+		prepare, err := parser.ParsePrepare(stmt.SQL)
+		if err != nil {
+			return buf, true, err
+		}
+		// Unknown type names are left for the parameter types to be looked up.
+		oids := make([]uint32, len(prepare.ParamTypes))
+		for i, name := range prepare.ParamTypes {
+			if dt, ok := connInfo.DataTypeForName(name); ok {
+				oids[i] = dt.OID
+			}
+		}
+		if err := s.prepareStmt(ctx, c, prepare.Name, prepare.Query, oids); err != nil {
+			return buf, true, err
+		}
+		buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(0))}).Encode(buf)
+		return buf, true, nil
+
+	case parser.CmdExecute:
+		execute, err := parser.ParseExecute(stmt.SQL)
+		if err != nil {
+			return buf, true, err
+		}
+		prepared, ok := c.getStmt(execute.Name)
+		if !ok {
+			return buf, true, pgerror.Newf(pgerror.InvalidSQLStatementName, "prepared statement %q does not exist", execute.Name)
+		}
+		if len(execute.Params) != len(prepared.paramOIDs) {
+			return buf, true, fmt.Errorf("wrong number of parameters for prepared statement %q: expected %d parameters but got %d",
+				execute.Name, len(prepared.paramOIDs), len(execute.Params))
+		}
+		// Portals of simple queries are unnamed, the results are sent in text format.
+		p := &portal{stmt: prepared, args: execute.Params}
+		if prepared.remote != nil {
+			p.params = textParams(execute.Params)
+		}
+		if prepared.desc != nil {
+			buf, _ = prepared.desc.Encode(buf)
+		}
+		// The results of the portal are written to the connection, after those of the
+		// previous statements.
+		if _, err := c.Write(buf); err != nil {
+			return buf[:0], true, err
+		}
+		c.closePortal(p.name)
+		c.portals[p.name] = p
+		defer c.closePortal(p.name)
+		err = s.handleExecuteMessage(ctx, c, &pgproto3.Execute{Portal: p.name})
+
+		// No Sync ends the batch of a write, it ends with the statement.
+		c.failed = err != nil
+		if batchErr := s.endBatch(ctx, c); err == nil {
+			err = batchErr
+		}
+		c.failed = false
+		return buf[:0], true, err
+	}
+	return buf, false, nil
+}
+
+// Encodes parameter values in text format, for statements on foreign tables.
+func textParams(values []interface{}) [][]byte {
+	params := make([][]byte, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+		case bool:
+			params[i] = []byte(strconv.FormatBool(v))
+		default:
+			params[i] = []byte(fmt.Sprint(v))
+		}
+	}
+	return params
+}
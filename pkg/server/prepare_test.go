@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PREPARE and EXECUTE", func() {
+	ctx := context.Background()
+
+	It("Execute statements prepared with SQL", func() {
+		conn := connect(ctx, "prepare.db")
+		defer conn.Close(ctx)
+		simple := pgx.QueryExecModeSimpleProtocol
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id int PRIMARY KEY, name text)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		tag, err := conn.Exec(ctx, `PREPARE add_item (int, text) AS INSERT INTO items VALUES ($1, $2)`, simple)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("PREPARE"))
+		for _, sql := range []string{`EXECUTE add_item(1, 'one')`, `EXECUTE add_item(2, 'two')`} {
+			tag, err = conn.Exec(ctx, sql, simple)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tag.String()).To(Equal("INSERT 0 1"))
+		}
+
+		_, err = conn.Exec(ctx, `PREPARE get_item AS SELECT name FROM items WHERE id = $1`, simple)
+		Expect(err).NotTo(HaveOccurred())
+		var name string
+		Expect(conn.QueryRow(ctx, `EXECUTE get_item(2)`, simple).Scan(&name)).To(Succeed())
+		Expect(name).To(Equal("two"))
+
+		// Statements are executed in order with the others of the query.
+		results, err := conn.PgConn().Exec(ctx, `SELECT 'first'; EXECUTE get_item(1::int); SELECT 'last'`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, result := range results {
+			Expect(result.Rows).To(HaveLen(1))
+			names = append(names, string(result.Rows[0][0]))
+		}
+		Expect(names).To(Equal([]string{"first", "one", "last"}))
+
+		_, err = conn.Exec(ctx, `EXECUTE get_item(1, 2)`, simple)
+		Expect(err).To(MatchError(ContainSubstring(`wrong number of parameters for prepared statement "get_item"`)))
+		_, err = conn.Exec(ctx, `PREPARE get_item AS SELECT 1`, simple)
+		Expect(err).To(MatchError(ContainSubstring(`prepared statement "get_item" already exists`)))
+
+		_, err = conn.Exec(ctx, `DEALLOCATE get_item`, simple)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `EXECUTE get_item(1)`, simple)
+		Expect(err).To(MatchError(ContainSubstring(`prepared statement "get_item" does not exist`)))
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("26000"))
+
+		// Bind messages of statements that don't exist fail the same way.
+		err = conn.PgConn().ExecPrepared(ctx, "get_item", [][]byte{[]byte("1")}, nil, nil).Read().Err
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("26000"))
+	})
+})
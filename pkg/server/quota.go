@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Returns the size quota of a database, 0 if its size isn't limited.
+func (s *Server) databaseQuota(name string) int64 {
+	if quota, ok := s.DatabaseQuotas[name]; ok {
+		return quota
+	}
+	return s.DefaultDatabaseQuota
+}
+
+// Returns the size of a database file and its WAL.
+func databaseSize(path string) int64 {
+	var size int64
+	for _, name := range []string{path, path + "-wal"} {
+		if info, err := os.Stat(name); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// Reports whether a statement may grow the database. Statements deleting data
+// are allowed over the quota, so space can be freed.
+func growsDatabase(stmt parser.StmtClass) bool {
+	switch stmt.Command {
+	case parser.CmdInsert, parser.CmdUpdate, parser.CmdMerge, "COPY", "REFRESH MATERIALIZED VIEW":
+		return true
+	case parser.CmdSelect:
+		// SELECT INTO creates a table.
+		return !stmt.ReadOnly
+	}
+	return strings.HasPrefix(stmt.Command, "CREATE ") || strings.HasPrefix(stmt.Command, "ALTER ")
+}
+
+// Checks the size quota of the connection database before a statement that may grow it.
+// Crossing QuotaWarningRatio of the quota is logged once, until the size falls below it again.
+func (s *Server) checkQuota(c *Conn, stmt parser.StmtClass) error {
+	quota := s.databaseQuota(c.name)
+	// In-memory databases have no file to measure.
+	if quota <= 0 || c.dsn != s.databasePath(c.name) || !growsDatabase(stmt) {
+		return nil
+	}
+
+	size := databaseSize(c.dsn)
+	warn := s.QuotaWarningRatio > 0 && float64(size) >= s.QuotaWarningRatio*float64(quota)
+	s.mu.Lock()
+	warned := s.quotaWarned[c.name]
+	if warn != warned {
+		s.quotaWarned[c.name] = warn
+	}
+	s.mu.Unlock()
+	if warn && !warned {
+		s.quotaWarnings.Add(1)
+		log.Printf("database %s uses %d of its %d bytes quota", c.name, size, quota)
+	}
+
+	if size >= quota {
+		s.quotaRejections.Add(1)
+		return pgerror.Newf(pgerror.DiskFull, "database %s is over its size quota of %d bytes", c.name, quota)
+	}
+	return nil
+}
+
+// Returns the size of a database of the data directory by name, for pg_database_size.
+// In-memory databases have no file to measure. Errors of SQL functions are SQLite errors,
+// their SQLSTATE code is derived from the message.
+func (s *Server) databaseSizeByName(name string) (int64, error) {
+	path := s.databasePath(name)
+	if strings.Contains(name, "..") || !isDatabaseFile(path) {
+		return 0, fmt.Errorf("database %q does not exist", name)
+	}
+	return databaseSize(path), nil
+}
+
+// Returns the path of a database file in the data directory.
+func (s *Server) databasePath(name string) string {
+	return filepath.Join(s.DataDir, name)
+}
@@ -0,0 +1,86 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Database quotas", Ordered, func() {
+	var limited *server.Server
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		limited = openServer(func(s *server.Server) {
+			s.DefaultDatabaseQuota = 64 * 1024
+			s.DatabaseQuotas = map[string]int64{"unlimited.db": 0}
+			s.QuotaWarningRatio = 0.5
+		})
+	})
+
+	It("Rejects writes over the quota", func() {
+		conn := connectTo(ctx, limited, "quota.db")
+		defer conn.Close(ctx)
+
+		stat := func(name string) int64 {
+			var value int64
+			Expect(conn.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = $1`,
+				pgx.QueryExecModeSimpleProtocol, name).Scan(&value)).To(Succeed())
+			return value
+		}
+
+		_, err := conn.Exec(ctx, `CREATE TABLE blobs (data BLOB)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO blobs VALUES (randomblob(40000))`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stat("quota_warnings")).To(BeZero())
+
+		// Over the warning ratio, under the quota.
+		_, err = conn.Exec(ctx, `INSERT INTO blobs VALUES (randomblob(40000))`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stat("quota_warnings")).To(Equal(int64(1)))
+
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `INSERT INTO blobs VALUES (randomblob(40000))`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("53100"))
+		_, err = conn.Exec(ctx, `CREATE TABLE other (id INTEGER)`, pgx.QueryExecModeSimpleProtocol)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("53100"))
+		Expect(stat("quota_rejections")).To(Equal(int64(2)))
+
+		// Reads and deletes are allowed, so space can be freed.
+		var count string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM blobs`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal("2"))
+		_, err = conn.Exec(ctx, `DELETE FROM blobs`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `VACUUM`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO blobs VALUES (randomblob(40000))`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var quota int64
+		Expect(conn.QueryRow(ctx, `SELECT quota_bytes FROM kqlite.databases WHERE name = 'quota.db'`,
+			pgx.QueryExecModeSimpleProtocol).Scan(&quota)).To(Succeed())
+		Expect(quota).To(Equal(int64(64 * 1024)))
+	})
+
+	It("Lifts the default quota of databases with no quota", func() {
+		conn := connectTo(ctx, limited, "unlimited.db")
+		defer conn.Close(ctx)
+
+		_, err := conn.Exec(ctx, `CREATE TABLE blobs (data BLOB)`)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			_, err = conn.Exec(ctx, `INSERT INTO blobs VALUES (randomblob(40000))`)
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+})
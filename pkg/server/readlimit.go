@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Returns the read slots of a database, nil if reads of databases aren't limited.
+func (s *Server) databaseReadSlots(name string) chan struct{} {
+	if s.MaxReadsPerDatabase <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slots, ok := s.dbReadSlots[name]
+	if !ok {
+		slots = make(chan struct{}, s.MaxReadsPerDatabase)
+		s.dbReadSlots[name] = slots
+	}
+	return slots
+}
+
+// Waits for the statement to be allowed to read concurrently with other read statements,
+// up to ReadQueueTimeout. Only read-only statements returning rows are limited.
+// The returned function releases the slots the statement holds.
+func (s *Server) acquireRead(ctx context.Context, c *Conn, stmt parser.StmtClass) (release func(), err error) {
+	if !stmt.ReadOnly || !stmt.ReturnsRows {
+		return func() {}, nil
+	}
+
+	var timeout <-chan time.Time
+	if s.ReadQueueTimeout > 0 {
+		timer := time.NewTimer(s.ReadQueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// Database slots are acquired first, so statements waiting for their database
+	// don't hold global slots other databases could use.
+	var held []chan struct{}
+	free := func() {
+		for _, slots := range held {
+			<-slots
+		}
+	}
+	for _, slots := range []chan struct{}{s.databaseReadSlots(c.name), s.readSlots} {
+		if slots == nil {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			held = append(held, slots)
+			continue
+		default:
+		}
+
+		s.readsQueued.Add(1)
+		select {
+		case slots <- struct{}{}:
+			held = append(held, slots)
+		case <-timeout:
+			free()
+			s.readQueueTimeouts.Add(1)
+			return nil, pgerror.Newf(pgerror.InsufficientResources,
+				"too many concurrent reads, timed out after %s waiting for a read slot", s.ReadQueueTimeout)
+		case <-ctx.Done():
+			free()
+			return nil, ctx.Err()
+		}
+	}
+
+	s.readsActive.Add(1)
+	return func() {
+		free()
+		s.readsActive.Add(-1)
+	}, nil
+}
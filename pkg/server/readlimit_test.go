@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Read limits", Ordered, func() {
+	var limited *server.Server
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		limited = openServer(func(s *server.Server) {
+			s.MaxReadsPerDatabase = 1
+			s.ReadQueueTimeout = 20 * time.Millisecond
+		})
+	})
+
+	It("Times out reads waiting for a slot of their database", func() {
+		slow := connectTo(ctx, limited, "reads.db")
+		defer slow.Close(ctx)
+		queued := connectTo(ctx, limited, "reads.db")
+		defer queued.Close(ctx)
+		// Statistics are read from another database, so they don't wait for a slot.
+		stats := connectTo(ctx, limited, "stats.db")
+		defer stats.Close(ctx)
+
+		// The simple protocol refreshes the statistics on each query, prepared statements only when parsed.
+		stat := func(name string) int64 {
+			var value int64
+			Expect(stats.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = $1`,
+				pgx.QueryExecModeSimpleProtocol, name).Scan(&value)).To(Succeed())
+			return value
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			var count string
+			Expect(slow.QueryRow(ctx, `WITH RECURSIVE r(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM r WHERE i < 5000000)
+				SELECT count(*) FROM r`).Scan(&count)).To(Succeed())
+			Expect(count).To(Equal("5000000"))
+		}()
+		Eventually(func() int64 { return stat("reads_active") }).Should(Equal(int64(1)))
+
+		var pgErr *pgconn.PgError
+		_, err := queued.Exec(ctx, `SELECT 1`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("53000"))
+
+		Eventually(done, 30*time.Second).Should(BeClosed())
+		_, err = queued.Exec(ctx, `SELECT 1`)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(stat("read_queue_timeouts")).To(Equal(int64(1)))
+		Expect(stat("reads_queued")).To(BeNumerically(">=", 1))
+	})
+
+	It("Holds read slots while portals are suspended", func() {
+		paging := connectTo(ctx, limited, "portals.db")
+		defer paging.Close(ctx)
+		queued := connectTo(ctx, limited, "portals.db")
+		defer queued.Close(ctx)
+
+		frontend := paging.PgConn().Frontend()
+		frontend.Send(&pgproto3.Parse{Query: `SELECT 1 UNION ALL SELECT 2`})
+		frontend.Send(&pgproto3.Bind{})
+		frontend.Send(&pgproto3.Execute{MaxRows: 1})
+		frontend.Send(&pgproto3.Flush{})
+		Expect(frontend.Flush()).To(Succeed())
+		for {
+			msg, err := paging.PgConn().ReceiveMessage(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			if _, ok := msg.(*pgproto3.PortalSuspended); ok {
+				break
+			}
+		}
+
+		var pgErr *pgconn.PgError
+		_, err := queued.Exec(ctx, `SELECT 1`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("53000"))
+
+		// Sync closes the portal of the implicit transaction and frees its slot.
+		frontend.Send(&pgproto3.Sync{})
+		Expect(frontend.Flush()).To(Succeed())
+		for {
+			msg, err := paging.PgConn().ReceiveMessage(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+				break
+			}
+		}
+		_, err = queued.Exec(ctx, `SELECT 1`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Output plugin of logical replication slots, transactions are streamed as
+// messages in the format of the wal2json plugin.
+const outputPlugin = "wal2json"
+
+// Interval between keepalive messages of streaming replication.
+const replicationKeepalive = 10 * time.Second
+
+// Commands of replication connections, connections started with the "replication"
+// startup parameter set to "database".
+var (
+	identifySystemRegex   = regexp.MustCompile(`(?i)^IDENTIFY_SYSTEM$`)
+	createSlotRegex       = regexp.MustCompile(`(?i)^CREATE_REPLICATION_SLOT\s+"?(\w+)"?\s+(TEMPORARY\s+)?(LOGICAL|PHYSICAL)\b\s*"?(\w*)"?`)
+	dropSlotRegex         = regexp.MustCompile(`(?i)^DROP_REPLICATION_SLOT\s+"?(\w+)"?(\s+WAIT)?$`)
+	startReplicationRegex = regexp.MustCompile(`(?i)^START_REPLICATION\s+SLOT\s+"?(\w+)"?\s+LOGICAL\s+([0-9A-F]+/[0-9A-F]+)(?:\s*\((.*)\))?`)
+)
+
+// PostgreSQL epoch of replication message timestamps.
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// replicationSlot is a logical replication slot of a database, it holds the committed
+// transactions of the database until the client consuming the slot confirms them.
+// Slots are kept in memory, they don't outlive the server.
+type replicationSlot struct {
+	name      string
+	database  string
+	owner     *Conn         // Connection of a temporary slot, the slot is dropped along with it.
+	streaming *Conn         // Connection streaming the slot, nil if none.
+	confirmed uint64        // Position confirmed by the client.
+	changes   []feedChange  // Transactions after the confirmed position.
+	notify    chan struct{} // Signaled when transactions are published.
+}
+
+// Creates a replication slot of the database, temporary slots are owned by the connection.
+// Returns the position from which transactions are available to the slot.
+func (f *changeFeed) createSlot(name, database string, owner *Conn) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.slots[name]; ok {
+		return 0, pgerror.Newf(pgerror.DuplicateObject, "replication slot %q already exists", name)
+	}
+	f.slots[name] = &replicationSlot{
+		name:      name,
+		database:  database,
+		owner:     owner,
+		confirmed: f.lsn,
+		notify:    make(chan struct{}, 1),
+	}
+	return f.lsn, nil
+}
+
+// Drops a replication slot that isn't streaming.
+func (f *changeFeed) dropSlot(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	slot, ok := f.slots[name]
+	if !ok {
+		return pgerror.Newf(pgerror.UndefinedObject, "replication slot %q does not exist", name)
+	} else if slot.streaming != nil {
+		return pgerror.Newf(pgerror.ObjectInUse, "replication slot %q is active", name)
+	}
+	delete(f.slots, name)
+	return nil
+}
+
+// Starts streaming a replication slot of the connection database to the connection.
+func (f *changeFeed) startStreaming(name string, c *Conn) (*replicationSlot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	slot, ok := f.slots[name]
+	switch {
+	case !ok:
+		return nil, pgerror.Newf(pgerror.UndefinedObject, "replication slot %q does not exist", name)
+	case slot.database != c.name:
+		return nil, pgerror.Newf(pgerror.ObjectNotInPrerequisiteState, "replication slot %q was not created in this database", name)
+	case slot.streaming != nil:
+		return nil, pgerror.Newf(pgerror.ObjectInUse, "replication slot %q is active", name)
+	}
+	slot.streaming = c
+	return slot, nil
+}
+
+// Stops streaming a replication slot.
+func (f *changeFeed) stopStreaming(slot *replicationSlot) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	slot.streaming = nil
+}
+
+// Returns the transactions of the slot after a position.
+func (f *changeFeed) pending(slot *replicationSlot, after uint64) []feedChange {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var changes []feedChange
+	for _, change := range slot.changes {
+		if change.lsn > after {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// Releases the transactions of the slot up to a position confirmed by the client.
+func (f *changeFeed) confirm(slot *replicationSlot, lsn uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if lsn <= slot.confirmed {
+		return
+	}
+	slot.confirmed = lsn
+	i := 0
+	for i < len(slot.changes) && slot.changes[i].lsn <= lsn {
+		i++
+	}
+	slot.changes = slot.changes[i:]
+}
+
+// Drops the temporary slots of a closed connection.
+func (f *changeFeed) releaseSlots(c *Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for name, slot := range f.slots {
+		if slot.owner == c {
+			delete(f.slots, name)
+		} else if slot.streaming == c {
+			slot.streaming = nil
+		}
+	}
+}
+
+// Executes a command of a replication connection, reports whether the query is one.
+// Other queries of replication connections are executed as usual.
+func (s *Server) handleReplicationCommand(ctx context.Context, c *Conn, query string) (bool, error) {
+	query = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+
+	var msgs []pgproto3.Message
+	var err error
+	switch {
+	case identifySystemRegex.MatchString(query):
+		s.cdc.mu.Lock()
+		lsn := s.cdc.lsn
+		s.cdc.mu.Unlock()
+		msgs = append(textRows([]string{"systemid", "timeline", "xlogpos", "dbname"},
+			[]string{s.cdc.systemID, "1", formatLSN(lsn), c.name}),
+			&pgproto3.CommandComplete{CommandTag: []byte("IDENTIFY_SYSTEM")})
+
+	case createSlotRegex.MatchString(query):
+		m := createSlotRegex.FindStringSubmatch(query)
+		var owner *Conn
+		if m[2] != "" {
+			owner = c
+		}
+		switch {
+		case !strings.EqualFold(m[3], "LOGICAL"):
+			err = pgerror.New(pgerror.FeatureNotSupported, "physical replication is not supported")
+		case m[4] != outputPlugin:
+			err = pgerror.Newf(pgerror.FeatureNotSupported, "output plugin %q is not supported, use %s", m[4], outputPlugin)
+		default:
+			var lsn uint64
+			if lsn, err = s.cdc.createSlot(m[1], c.name, owner); err == nil {
+				msgs = append(textRows([]string{"slot_name", "consistent_point", "snapshot_name", "output_plugin"},
+					[]string{m[1], formatLSN(lsn), "", outputPlugin}),
+					&pgproto3.CommandComplete{CommandTag: []byte("CREATE_REPLICATION_SLOT")})
+			}
+		}
+
+	case dropSlotRegex.MatchString(query):
+		if err = s.cdc.dropSlot(dropSlotRegex.FindStringSubmatch(query)[1]); err == nil {
+			msgs = append(msgs, &pgproto3.CommandComplete{CommandTag: []byte("DROP_REPLICATION_SLOT")})
+		}
+
+	case startReplicationRegex.MatchString(query):
+		m := startReplicationRegex.FindStringSubmatch(query)
+		var comp compressor
+		var slot *replicationSlot
+		if comp, err = newCompressor(replicationOption(m[3], "compression")); err != nil {
+			break
+		}
+		if slot, err = s.cdc.startStreaming(m[1], c); err == nil {
+			defer s.cdc.stopStreaming(slot)
+			start, _ := parseLSN(m[2])
+			return true, s.streamSlot(ctx, c, slot, start, comp)
+		}
+
+	default:
+		return false, nil
+	}
+
+	if err != nil {
+		msgs = append(msgs[:0], pgerror.Response(err))
+	}
+	return true, writeMessages(c, append(msgs, &pgproto3.ReadyForQuery{TxStatus: 'I'})...)
+}
+
+// Streams the transactions of a replication slot after a position, or after the
+// confirmed position of the slot if later, until the client ends the copy. Transactions
+// are compressed by comp, unless nil.
+func (s *Server) streamSlot(ctx context.Context, c *Conn, slot *replicationSlot, start uint64, comp compressor) error {
+	if err := writeMessages(c, &pgproto3.CopyBothResponse{}); err != nil {
+		return err
+	}
+
+	// Standby status updates of the client confirm positions, CopyDone ends streaming.
+	done := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := c.backend.Receive()
+			if err != nil {
+				done <- err
+				return
+			}
+			switch msg := msg.(type) {
+			case *pgproto3.CopyData:
+				if len(msg.Data) >= 17 && msg.Data[0] == 'r' {
+					s.cdc.acknowledge(slot, binary.BigEndian.Uint64(msg.Data[9:17]))
+				}
+			case *pgproto3.CopyDone:
+				done <- nil
+				return
+			case *pgproto3.CopyFail:
+				done <- fmt.Errorf("replication copy failed: %s", msg.Message)
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(replicationKeepalive)
+	defer keepalive.Stop()
+
+	s.cdc.mu.Lock()
+	sent := max(start, slot.confirmed)
+	s.cdc.mu.Unlock()
+	for {
+		var buf []byte
+		for _, change := range s.cdc.pending(slot, sent) {
+			if s.cdc.faults.dropChange() {
+				sent = change.lsn
+				continue
+			}
+			data := xlogData(change, comp)
+			s.replicationBytes.Add(int64(len(change.data)))
+			s.replicationSentBytes.Add(int64(len(data) - xlogHeaderSize))
+			buf, _ = (&pgproto3.CopyData{Data: data}).Encode(buf)
+			sent = change.lsn
+		}
+		if len(buf) > 0 {
+			if _, err := c.Write(buf); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-slot.notify:
+		case <-keepalive.C:
+			if err := writeMessages(c, &pgproto3.CopyData{Data: keepaliveData(sent)}); err != nil {
+				return err
+			}
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+			return writeMessages(c,
+				&pgproto3.CopyDone{},
+				&pgproto3.CommandComplete{CommandTag: []byte("START_STREAMING")},
+				&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Size of the header of XLogData messages, before the transaction.
+const xlogHeaderSize = 25
+
+// Returns the XLogData message of a transaction, compressed by comp unless nil.
+func xlogData(change feedChange, comp compressor) []byte {
+	data := make([]byte, xlogHeaderSize, xlogHeaderSize+len(change.data))
+	data[0] = 'w'
+	binary.BigEndian.PutUint64(data[1:], change.lsn)
+	binary.BigEndian.PutUint64(data[9:], change.lsn)
+	binary.BigEndian.PutUint64(data[17:], uint64(time.Since(postgresEpoch).Microseconds()))
+	if comp != nil && len(change.data) >= compressMinSize {
+		return comp.compress(data, change.data)
+	}
+	return append(data, change.data...)
+}
+
+// Returns the primary keepalive message of a position.
+func keepaliveData(lsn uint64) []byte {
+	data := make([]byte, 18)
+	data[0] = 'k'
+	binary.BigEndian.PutUint64(data[1:], lsn)
+	binary.BigEndian.PutUint64(data[9:], uint64(time.Since(postgresEpoch).Microseconds()))
+	return data
+}
+
+// Returns the messages of a single row of text columns.
+func textRows(names, values []string) []pgproto3.Message {
+	var desc pgproto3.RowDescription
+	row := &pgproto3.DataRow{}
+	for i, name := range names {
+		desc.Fields = append(desc.Fields, pgproto3.FieldDescription{
+			Name:         []byte(name),
+			DataTypeOID:  pgtype.TextOID,
+			DataTypeSize: -1,
+			TypeModifier: -1,
+			Format:       TextFormat,
+		})
+		row.Values = append(row.Values, []byte(values[i]))
+	}
+	return []pgproto3.Message{&desc, row}
+}
+
+// Formats a position as PostgreSQL formats WAL positions, e.g. "0/1000001".
+func formatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", lsn>>32, uint32(lsn))
+}
+
+// Parses a position formatted by formatLSN.
+func parseLSN(text string) (uint64, error) {
+	hi, lo, ok := strings.Cut(text, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid position %q", text)
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, err
+	}
+	return h<<32 | l, nil
+}
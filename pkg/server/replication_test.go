@@ -0,0 +1,328 @@
+package server_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logical replication", Ordered, func() {
+	var repl *pgconn.PgConn
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	type change struct {
+		Kind         string        `json:"kind"`
+		Table        string        `json:"table"`
+		ColumnNames  []string      `json:"columnnames"`
+		ColumnValues []interface{} `json:"columnvalues"`
+		OldKeys      *struct {
+			KeyNames  []string      `json:"keynames"`
+			KeyValues []interface{} `json:"keyvalues"`
+		} `json:"oldkeys"`
+	}
+
+	// Returns the changes of the next transaction streamed to the replication connection.
+	receive := func() []change {
+		for {
+			msg, err := repl.ReceiveMessage(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			data, ok := msg.(*pgproto3.CopyData)
+			Expect(ok).To(BeTrue(), "unexpected message %#v", msg)
+			if data.Data[0] != 'w' {
+				continue
+			}
+			var tx struct {
+				Change []change `json:"change"`
+			}
+			Expect(json.Unmarshal(data.Data[25:], &tx)).To(Succeed())
+			return tx.Change
+		}
+	}
+
+	BeforeAll(func() {
+		config, err := pgconn.ParseConfig(fmt.Sprintf("postgres://%s/cdc.db?sslmode=disable", srv.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		config.RuntimeParams["replication"] = "database"
+		repl, err = pgconn.ConnectConfig(ctx, config)
+		Expect(err).NotTo(HaveOccurred())
+
+		conn = connect(ctx, "cdc.db")
+		_, err = conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, data BLOB)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+		Expect(repl.Close(ctx)).To(Succeed())
+	})
+
+	It("Identifies the system", func() {
+		results, err := repl.Exec(ctx, `IDENTIFY_SYSTEM`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results[0].Rows).To(HaveLen(1))
+		Expect(string(results[0].Rows[0][3])).To(Equal("cdc.db"))
+	})
+
+	It("Streams committed row changes of the slot database", func() {
+		_, err := repl.Exec(ctx, `CREATE_REPLICATION_SLOT items_slot TEMPORARY LOGICAL wal2json`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = conn.Exec(ctx, `INSERT INTO items (name, data) VALUES ('apple', x'cafe'), ('pear', NULL)`)
+		Expect(err).NotTo(HaveOccurred())
+		tx, err := conn.Begin(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tx.Exec(ctx, `UPDATE items SET name = 'green apple' WHERE id = 1`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tx.Exec(ctx, `DELETE FROM items WHERE id = 2`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tx.Commit(ctx)).To(Succeed())
+		tx, err = conn.Begin(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tx.Exec(ctx, `INSERT INTO items (name) VALUES ('rolled back')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tx.Rollback(ctx)).To(Succeed())
+
+		repl.Frontend().Send(&pgproto3.Query{String: `START_REPLICATION SLOT items_slot LOGICAL 0/0`})
+		Expect(repl.Frontend().Flush()).To(Succeed())
+		msg, err := repl.ReceiveMessage(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(msg).To(BeAssignableToTypeOf(&pgproto3.CopyBothResponse{}))
+
+		inserts := receive()
+		Expect(inserts).To(HaveLen(2))
+		Expect(inserts[0].Kind).To(Equal("insert"))
+		Expect(inserts[0].Table).To(Equal("items"))
+		Expect(inserts[0].ColumnNames).To(Equal([]string{"id", "name", "data"}))
+		Expect(inserts[0].ColumnValues).To(Equal([]interface{}{float64(1), "apple", `\xcafe`}))
+		Expect(inserts[1].ColumnValues).To(Equal([]interface{}{float64(2), "pear", nil}))
+
+		changes := receive()
+		Expect(changes).To(HaveLen(2))
+		Expect(changes[0].Kind).To(Equal("update"))
+		Expect(changes[0].ColumnValues[1]).To(Equal("green apple"))
+		Expect(changes[0].OldKeys.KeyNames).To(Equal([]string{"id"}))
+		Expect(changes[0].OldKeys.KeyValues).To(Equal([]interface{}{float64(1)}))
+		Expect(changes[1].Kind).To(Equal("delete"))
+		Expect(changes[1].ColumnNames).To(BeEmpty())
+		Expect(changes[1].OldKeys.KeyValues).To(Equal([]interface{}{float64(2)}))
+
+		// Changes of tables created while streaming are captured as well.
+		_, err = conn.Exec(ctx, `CREATE TABLE tags (name TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO tags VALUES ('fruit')`)
+		Expect(err).NotTo(HaveOccurred())
+		tags := receive()
+		Expect(tags).To(HaveLen(1))
+		Expect(tags[0].Table).To(Equal("tags"))
+
+		// Confirm the streamed position and stop streaming.
+		status := make([]byte, 34)
+		status[0] = 'r'
+		binary.BigEndian.PutUint64(status[9:], ^uint64(0)>>1)
+		repl.Frontend().Send(&pgproto3.CopyData{Data: status})
+		repl.Frontend().Send(&pgproto3.CopyDone{})
+		Expect(repl.Frontend().Flush()).To(Succeed())
+		for {
+			msg, err := repl.ReceiveMessage(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+				break
+			}
+		}
+	})
+
+	It("Compresses streamed transactions when negotiated", func() {
+		_, err := repl.Exec(ctx, `CREATE_REPLICATION_SLOT zstd_slot TEMPORARY LOGICAL wal2json`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+		var pgErr *pgconn.PgError
+		_, err = repl.Exec(ctx, `START_REPLICATION SLOT zstd_slot LOGICAL 0/0 (compression 'lz4')`).ReadAll()
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+
+		repl.Frontend().Send(&pgproto3.Query{String: `START_REPLICATION SLOT zstd_slot LOGICAL 0/0 (compression 'zstd')`})
+		Expect(repl.Frontend().Flush()).To(Succeed())
+		msg, err := repl.ReceiveMessage(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(msg).To(BeAssignableToTypeOf(&pgproto3.CopyBothResponse{}))
+
+		name := strings.Repeat("compressible ", 100)
+		_, err = conn.Exec(ctx, `INSERT INTO items (name) VALUES ($1)`, name)
+		Expect(err).NotTo(HaveOccurred())
+		for {
+			msg, err := repl.ReceiveMessage(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			data, ok := msg.(*pgproto3.CopyData)
+			Expect(ok).To(BeTrue(), "unexpected message %#v", msg)
+			if data.Data[0] != 'w' {
+				continue
+			}
+			Expect(len(data.Data)).To(BeNumerically("<", len(name)))
+			dec, err := zstd.NewReader(nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer dec.Close()
+			payload, err := dec.DecodeAll(data.Data[25:], nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(payload)).To(ContainSubstring(name))
+			break
+		}
+
+		repl.Frontend().Send(&pgproto3.CopyDone{})
+		Expect(repl.Frontend().Flush()).To(Succeed())
+		for {
+			msg, err := repl.ReceiveMessage(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+				break
+			}
+		}
+		_, err = repl.Exec(ctx, `DROP_REPLICATION_SLOT zstd_slot`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Recovers from faults injected in replication streams", func() {
+		_, err := repl.Exec(ctx, `CREATE_REPLICATION_SLOT faults_slot LOGICAL wal2json`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			Expect(conn.QueryRow(ctx, `SELECT kqlite_replication_fault('reset', 0)`).Scan(new(string))).To(Succeed())
+			Eventually(func() error {
+				_, err := repl.Exec(ctx, `DROP_REPLICATION_SLOT faults_slot`).ReadAll()
+				return err
+			}).Should(Succeed())
+		}()
+
+		// Starts streaming the slot on a new replication connection, once the severed
+		// connection streaming it is closed.
+		stream := func() *pgconn.PgConn {
+			config, err := pgconn.ParseConfig(fmt.Sprintf("postgres://%s/cdc.db?sslmode=disable", srv.ListenerAddr()))
+			Expect(err).NotTo(HaveOccurred())
+			config.RuntimeParams["replication"] = "database"
+			var c *pgconn.PgConn
+			Eventually(func() error {
+				if c, err = pgconn.ConnectConfig(ctx, config); err != nil {
+					return err
+				}
+				c.Frontend().Send(&pgproto3.Query{String: `START_REPLICATION SLOT faults_slot LOGICAL 0/0`})
+				Expect(c.Frontend().Flush()).To(Succeed())
+				msg, err := c.ReceiveMessage(ctx)
+				if err == nil {
+					if e, ok := msg.(*pgproto3.ErrorResponse); ok {
+						err = errors.New(e.Message)
+					}
+				}
+				if err != nil {
+					c.Close(ctx)
+				}
+				return err
+			}).Should(Succeed())
+			return c
+		}
+		// Returns the names inserted by the next transaction and its position.
+		inserted := func(c *pgconn.PgConn) (string, uint64) {
+			for {
+				msg, err := c.ReceiveMessage(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				data, ok := msg.(*pgproto3.CopyData)
+				Expect(ok).To(BeTrue(), "unexpected message %#v", msg)
+				if data.Data[0] != 'w' {
+					continue
+				}
+				var tx struct {
+					Change []change `json:"change"`
+				}
+				Expect(json.Unmarshal(data.Data[25:], &tx)).To(Succeed())
+				Expect(tx.Change).To(HaveLen(1))
+				return tx.Change[0].ColumnValues[1].(string), binary.BigEndian.Uint64(data.Data[1:9])
+			}
+		}
+		// Expressions are sent as text.
+		fault := func(kind string, n int64) string {
+			var result string
+			Expect(conn.QueryRow(ctx, `SELECT kqlite_replication_fault($1, $2)`, kind, n).Scan(&result)).To(Succeed())
+			return result
+		}
+		insert := func(name string) {
+			_, err := conn.Exec(ctx, `INSERT INTO items (name) VALUES ($1)`, name)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		// Dropped transactions are streamed again once the client restarts streaming.
+		c := stream()
+		Expect(fault("drop", 1)).To(Equal("1"))
+		insert("dropped")
+		insert("kept")
+		name, _ := inserted(c)
+		Expect(name).To(Equal("kept"))
+		Expect(fault("sever", 0)).To(Equal("1"))
+		_, err = c.ReceiveMessage(ctx)
+		Expect(err).To(HaveOccurred())
+		c.Close(ctx)
+
+		c = stream()
+		name, _ = inserted(c)
+		Expect(name).To(Equal("dropped"))
+		name, lsn := inserted(c)
+		Expect(name).To(Equal("kept"))
+
+		// Delayed confirmations aren't applied before the stream is severed, the confirmed
+		// transactions are streamed again, but not once the confirmation is applied.
+		Expect(fault("delay_ack", 200)).To(Equal("200"))
+		status := make([]byte, 34)
+		status[0] = 'r'
+		binary.BigEndian.PutUint64(status[9:], lsn)
+		c.Frontend().Send(&pgproto3.CopyData{Data: status})
+		Expect(c.Frontend().Flush()).To(Succeed())
+		Expect(fault("sever", 0)).To(Equal("1"))
+		c.Close(ctx)
+
+		c = stream()
+		name, _ = inserted(c)
+		Expect(name).To(Equal("dropped"))
+		Expect(fault("sever", 0)).To(Equal("1"))
+		c.Close(ctx)
+
+		time.Sleep(300 * time.Millisecond)
+		insert("after")
+		c = stream()
+		name, _ = inserted(c)
+		Expect(name).To(Equal("after"))
+		Expect(fault("sever", 0)).To(Equal("1"))
+		c.Close(ctx)
+
+		var pgErr *pgconn.PgError
+		err = conn.QueryRow(ctx, `SELECT kqlite_replication_fault('partition', 0)`).Scan(new(string))
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+	})
+
+	It("Drops replication slots", func() {
+		_, err := repl.Exec(ctx, `DROP_REPLICATION_SLOT items_slot`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+
+		var pgErr *pgconn.PgError
+		_, err = repl.Exec(ctx, `DROP_REPLICATION_SLOT items_slot`).ReadAll()
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42704"))
+
+		_, err = repl.Exec(ctx, `CREATE_REPLICATION_SLOT other_slot LOGICAL pgoutput`).ReadAll()
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+
+		// Writes after the last slot is dropped aren't captured anymore.
+		_, err = conn.Exec(ctx, `INSERT INTO items (name) VALUES ('plum')`)
+		Expect(err).NotTo(HaveOccurred())
+		var triggers string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM temp.sqlite_master WHERE type = 'trigger'`).Scan(&triggers)).To(Succeed())
+		Expect(triggers).To(Equal("0"))
+	})
+})
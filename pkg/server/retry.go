@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Executes fn and executes it again with an exponential backoff while the statement
+// fails on locks held by other connections, up to RetryAttempts attempts. Write statements
+// are listed in pg_locks as waiting for the write lock of the database meanwhile.
+// Only statements that are safe to execute again are retried: read-only statements,
+// and statements outside of transaction blocks that are rolled back as a whole on failure.
+func (s *Server) retry(ctx context.Context, c *Conn, stmt parser.StmtClass, fn func() error) error {
+	if !stmt.ReadOnly {
+		defer c.startWrite()()
+	}
+	backoff := s.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= s.RetryAttempts || !sqlite.IsBusy(err) || !c.retryable(stmt) {
+			if err != nil && attempt > 1 {
+				s.retriesFailed.Add(1)
+			}
+			if err != nil && sqlite.IsBusy(err) {
+				return s.lockWaitError(c, err)
+			}
+			return err
+		}
+
+		s.retries.Add(1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Reports whether the statement can be executed again after a failure. The first statement
+// of a batch is, its transaction holds no changes yet.
+func (c *Conn) retryable(stmt parser.StmtClass) bool {
+	if stmt.ReadOnly {
+		return true
+	}
+	inTx, err := sqlite.InTransaction(c.conn)
+	return err == nil && (!inTx || c.batch && c.xact == nil)
+}
@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Statement retries", Ordered, func() {
+	var retrying *server.Server
+	ctx := context.Background()
+
+	// Shared in-memory databases have a single writer, writes of other connections
+	// fail with SQLITE_LOCKED without waiting until the transaction completes.
+	connectLocked := func(s *server.Server) *pgx.Conn {
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/locked-%p.db?sslmode=disable&mode=memory", s.ListenerAddr(), s))
+		Expect(err).NotTo(HaveOccurred())
+		return conn
+	}
+
+	lock := func(s *server.Server) *pgx.Conn {
+		writer := connectLocked(s)
+		_, err := writer.Exec(ctx, `CREATE TABLE IF NOT EXISTS items (id INTEGER PRIMARY KEY)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = writer.Exec(ctx, `BEGIN`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = writer.Exec(ctx, `INSERT INTO items DEFAULT VALUES`)
+		Expect(err).NotTo(HaveOccurred())
+		return writer
+	}
+
+	BeforeAll(func() {
+		retrying = openServer(func(s *server.Server) {
+			s.RetryAttempts = 10
+			s.RetryBackoff = 5 * time.Millisecond
+		})
+	})
+
+	It("Retries statements outside of transaction blocks until the lock is released", func() {
+		writer := lock(retrying)
+		defer writer.Close(ctx)
+
+		reader := connectLocked(retrying)
+		defer reader.Close(ctx)
+
+		committed := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(committed)
+			time.Sleep(50 * time.Millisecond)
+			_, err := writer.Exec(ctx, `COMMIT`)
+			Expect(err).NotTo(HaveOccurred())
+		}()
+
+		_, err := reader.Exec(ctx, `INSERT INTO items DEFAULT VALUES`)
+		Expect(err).NotTo(HaveOccurred())
+		<-committed
+
+		var count string
+		Expect(reader.QueryRow(ctx, `SELECT count(*) FROM items`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal("2"))
+
+		var retries int64
+		Expect(reader.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = 'statement_retries'`).Scan(&retries)).To(Succeed())
+		Expect(retries).To(BeNumerically(">", 0))
+	})
+
+	It("Doesn't retry writes within transaction blocks", func() {
+		writer := lock(retrying)
+		defer writer.Close(ctx)
+
+		reader := connectLocked(retrying)
+		defer reader.Close(ctx)
+		_, err := reader.Exec(ctx, `BEGIN`)
+		Expect(err).NotTo(HaveOccurred())
+
+		start := time.Now()
+		_, err = reader.Exec(ctx, `DELETE FROM items`)
+		Expect(err).To(MatchError(ContainSubstring("locked")))
+		Expect(time.Since(start)).To(BeNumerically("<", retrying.RetryBackoff))
+	})
+
+	It("Fails without retries", func() {
+		writer := lock(srv)
+		defer writer.Close(ctx)
+
+		reader := connectLocked(srv)
+		defer reader.Close(ctx)
+		_, err := reader.Exec(ctx, `INSERT INTO items DEFAULT VALUES`)
+		Expect(err).To(MatchError(ContainSubstring("locked")))
+	})
+})
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Settings of the row limit of results: kqlite.max_rows is the maximum number of rows a
+// response holds, 0 for no limit. Results over the limit fail, or are truncated when
+// kqlite.max_rows_action is truncate. Clients page through larger results with cursors
+// or the row limit of Execute messages.
+const (
+	maxRowsParam       = "kqlite.max_rows"
+	maxRowsActionParam = "kqlite.max_rows_action"
+)
+
+// Hint of the responses over the row limit.
+const rowLimitHint = "Fetch the rows in pages with a cursor or the row limit of Execute messages, or raise " + maxRowsParam + "."
+
+// Reports whether a response of count rows, with more rows to read, reached the row limit
+// of the session. Fails unless results over the limit are truncated.
+func (c *Conn) rowLimitReached(count int64) (bool, error) {
+	if c.maxRows <= 0 || count < c.maxRows {
+		return false, nil
+	}
+	if c.truncateRows {
+		return true, nil
+	}
+	e := pgerror.Newf(pgerror.ProgramLimitExceeded, "result exceeds the limit of %d rows", c.maxRows)
+	e.Hint = rowLimitHint
+	return true, e
+}
+
+// Appends the warning of a response truncated by the row limit.
+func (c *Conn) appendTruncated(buf []byte) []byte {
+	e := pgerror.Newf(pgerror.Warning, "result truncated to %d rows", c.maxRows)
+	e.Severity = pgerror.SeverityWarning
+	e.Hint = rowLimitHint
+	buf, _ = e.Notice().Encode(buf)
+	return buf
+}
@@ -0,0 +1,170 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Row limits", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	// Returns the number of rows of a query.
+	count := func(sql string) (int, error) {
+		rows, err := conn.Query(ctx, sql)
+		if err != nil {
+			return 0, err
+		}
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		return n, rows.Err()
+	}
+
+	BeforeAll(func() {
+		conn = connect(ctx, "rowlimit.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY);
+			INSERT INTO items VALUES (1), (2), (3), (4), (5)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Suspends portals at the row limit of Execute", func() {
+		frontend := conn.PgConn().Frontend()
+		frontend.Send(&pgproto3.Parse{Query: `SELECT id FROM items ORDER BY id`})
+		frontend.Send(&pgproto3.Bind{})
+		for i := 0; i < 3; i++ {
+			frontend.Send(&pgproto3.Execute{MaxRows: 2})
+		}
+		frontend.Send(&pgproto3.Sync{})
+		Expect(frontend.Flush()).To(Succeed())
+
+		var received []string
+		for {
+			msg, err := conn.PgConn().ReceiveMessage(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			switch msg := msg.(type) {
+			case *pgproto3.DataRow:
+				received = append(received, string(msg.Values[0]))
+			case *pgproto3.PortalSuspended:
+				received = append(received, "suspended")
+			case *pgproto3.CommandComplete:
+				received = append(received, string(msg.CommandTag))
+			}
+			if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+				break
+			}
+		}
+		Expect(received).To(Equal([]string{"1", "2", "suspended", "3", "4", "suspended", "5", "SELECT 1"}))
+	})
+
+	It("Keeps portals of transaction blocks across Sync", func() {
+		// Returns the rows and the completion of the messages up to ReadyForQuery.
+		receive := func() []string {
+			var received []string
+			for {
+				msg, err := conn.PgConn().ReceiveMessage(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				switch msg := msg.(type) {
+				case *pgproto3.DataRow:
+					received = append(received, string(msg.Values[0]))
+				case *pgproto3.PortalSuspended:
+					received = append(received, "suspended")
+				case *pgproto3.CommandComplete:
+					received = append(received, string(msg.CommandTag))
+				case *pgproto3.ErrorResponse:
+					received = append(received, msg.Message)
+				case *pgproto3.ReadyForQuery:
+					return received
+				}
+			}
+		}
+
+		_, err := conn.Exec(ctx, `BEGIN`)
+		Expect(err).NotTo(HaveOccurred())
+		frontend := conn.PgConn().Frontend()
+		frontend.Send(&pgproto3.Parse{Query: `SELECT id FROM items ORDER BY id`})
+		frontend.Send(&pgproto3.Bind{DestinationPortal: "page"})
+		frontend.Send(&pgproto3.Execute{Portal: "page", MaxRows: 2})
+		frontend.Send(&pgproto3.Sync{})
+		Expect(frontend.Flush()).To(Succeed())
+		Expect(receive()).To(Equal([]string{"1", "2", "suspended"}))
+
+		// Fetches the next page, as JDBC does with a fetch size.
+		frontend.Send(&pgproto3.Execute{Portal: "page", MaxRows: 2})
+		frontend.Send(&pgproto3.Sync{})
+		Expect(frontend.Flush()).To(Succeed())
+		Expect(receive()).To(Equal([]string{"3", "4", "suspended"}))
+
+		// The portal ends with the transaction block.
+		_, err = conn.Exec(ctx, `COMMIT`)
+		Expect(err).NotTo(HaveOccurred())
+		frontend.Send(&pgproto3.Execute{Portal: "page", MaxRows: 2})
+		frontend.Send(&pgproto3.Sync{})
+		Expect(frontend.Flush()).To(Succeed())
+		Expect(receive()).To(Equal([]string{`portal "page" does not exist`}))
+	})
+
+	It("Fails on results over the row limit of the session", func() {
+		_, err := conn.Exec(ctx, `SET kqlite.max_rows = 3`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var pgErr *pgconn.PgError
+		_, err = count(`SELECT id FROM items`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue(), fmt.Sprint(err))
+		Expect(pgErr.Code).To(Equal("54000"))
+		Expect(count(`SELECT id FROM items WHERE id <= 3`)).To(Equal(3))
+
+		_, err = conn.Exec(ctx, `SET kqlite.max_rows = -1`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Truncates results over the row limit of the session", func() {
+		_, err := conn.Exec(ctx, `SET kqlite.max_rows_action = truncate`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count(`SELECT id FROM items`)).To(Equal(3))
+
+		// Rows over the limit are left for the next fetch of cursors.
+		tx, err := conn.Begin(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer tx.Rollback(ctx)
+		_, err = tx.Exec(ctx, `DECLARE items_cursor CURSOR FOR SELECT id FROM items ORDER BY id`)
+		Expect(err).NotTo(HaveOccurred())
+		var ids []string
+		for _, expected := range []int{3, 2} {
+			rows, err := tx.Query(ctx, `FETCH ALL FROM items_cursor`)
+			Expect(err).NotTo(HaveOccurred())
+			n := 0
+			for rows.Next() {
+				var id string
+				Expect(rows.Scan(&id)).To(Succeed())
+				ids = append(ids, id)
+				n++
+			}
+			Expect(rows.Err()).NotTo(HaveOccurred())
+			Expect(n).To(Equal(expected))
+		}
+		Expect(ids).To(Equal([]string{"1", "2", "3", "4", "5"}))
+	})
+
+	It("Takes the row limit from the startup parameters", func() {
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/rowlimit.db?sslmode=disable&kqlite.max_rows=2", srv.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(ctx)
+
+		var limit string
+		Expect(conn.QueryRow(ctx, `SHOW kqlite.max_rows`).Scan(&limit)).To(Succeed())
+		Expect(limit).To(Equal("2"))
+	})
+})
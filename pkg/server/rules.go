@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Translates the DDL statements SQLite can't execute as they are. Rules are translated
+// to triggers, and so are updates of the views PostgreSQL updates automatically.
+// Generated columns and indexes are translated, their variants without SQLite
+// equivalent are rejected. GIN and GiST indexes of documents are FTS5 tables,
+// and text search matches of other statements are lookups of these tables.
+// Collations of all statements are replaced by the collations of their locale.
+// PostgreSQL triggers execute functions and are rejected, so migrations
+// relying on them fail rather than silently losing their effect.
+// Other statements are returned as is.
+func translateDDL(ctx context.Context, c *Conn, stmt parser.StmtClass) (string, error) {
+	if strings.Contains(strings.ToUpper(stmt.SQL), "COLLATE") {
+		text, err := parser.Collations(stmt.SQL)
+		if err != nil {
+			return "", err
+		}
+		stmt.SQL = text
+	}
+	switch stmt.Command {
+	case "CREATE RULE":
+		var kind string
+		err := c.conn.QueryRowContext(ctx, `SELECT type FROM main.sqlite_master WHERE name = ? COLLATE NOCASE AND type IN ('table', 'view')`,
+			stmt.Tables[0]).Scan(&kind)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", pgerror.Newf(pgerror.UndefinedTable, "relation %q does not exist", stmt.Tables[0])
+		} else if err != nil {
+			return "", err
+		}
+		return parser.RuleTrigger(stmt.SQL, kind == "view")
+	case "CREATE VIEW":
+		text, view, err := parser.CreateView(stmt.SQL)
+		if err != nil || view == nil {
+			return text, err
+		}
+		columns, err := tableColumns(ctx, c, view.Table)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(append([]string{text}, parser.ViewTriggers(view, columns)...), "; "), nil
+	case "DROP RULE":
+		return parser.DropRuleTrigger(stmt.SQL)
+	case "CREATE TABLE", "ALTER TABLE":
+		return parser.GeneratedColumns(stmt.SQL)
+	case "CREATE INDEX":
+		text, index, err := parser.CreateIndex(stmt.SQL)
+		if err != nil || index == nil {
+			return text, err
+		}
+		return createTextSearchIndex(ctx, c, index)
+	case "DROP INDEX", "DROP TABLE":
+		return dropTextSearchIndexes(ctx, c, stmt)
+	case "CREATE TRIGGER":
+		return createTrigger(ctx, c, stmt)
+	case "DROP TRIGGER":
+		return dropTrigger(ctx, c, stmt)
+	}
+	return translateTextSearch(ctx, c, stmt)
+}
+
+// Returns the columns of a table of the connection database, none if it doesn't exist.
+func tableColumns(ctx context.Context, c *Conn, table string) ([]parser.TableColumn, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT name, coalesce(dflt_value, ''), pk FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []parser.TableColumn
+	for rows.Next() {
+		var column parser.TableColumn
+		var pk int
+		if err := rows.Scan(&column.Name, &column.Default, &pk); err != nil {
+			return nil, err
+		}
+		column.Key = pk > 0
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// Counts the rows of a view an UPDATE or DELETE statement changes through the triggers
+// updating the view, SQLite doesn't count the changes of triggers in the rows affected.
+// Returns false if the statement doesn't target a view with these triggers.
+func (s *Server) viewRowsAffected(ctx context.Context, c *Conn, stmt parser.StmtClass, args []interface{}) (int64, bool, error) {
+	if (stmt.Command != parser.CmdUpdate && stmt.Command != parser.CmdDelete) || len(stmt.Tables) == 0 {
+		return 0, false, nil
+	}
+	var found int
+	err := c.conn.QueryRowContext(ctx, `SELECT count(*) FROM main.sqlite_master WHERE type = 'trigger' AND name = ?`,
+		parser.ViewTriggerName(stmt.Tables[0], stmt.Command)).Scan(&found)
+	if err != nil || found == 0 {
+		return 0, false, err
+	}
+	query, ok, err := parser.ViewRowsQuery(stmt.SQL)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	var rows int64
+	if err := c.conn.QueryRowContext(ctx, s.rewriteQuery(c, query), args...).Scan(&rows); err != nil {
+		return 0, false, err
+	}
+	return rows, true, nil
+}
@@ -0,0 +1,209 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules and triggers", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "rules.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, deleted_at TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO items (id, name) VALUES (1, 'a'), (2, 'b'), (3, 'c')`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	count := func(query string) string {
+		var n string
+		Expect(conn.QueryRow(ctx, query).Scan(&n)).To(Succeed())
+		return n
+	}
+
+	It("Soft deletes rows with a DO INSTEAD rule", func() {
+		_, err := conn.Exec(ctx, `CREATE RULE soft_delete AS ON DELETE TO items
+			DO INSTEAD UPDATE items SET deleted_at = now() WHERE id = OLD.id`)
+		Expect(err).NotTo(HaveOccurred())
+
+		tag, err := conn.Exec(ctx, `DELETE FROM items WHERE id = $1`, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("DELETE 0"))
+		Expect(count(`SELECT count(*) FROM items`)).To(Equal("3"))
+		Expect(count(`SELECT count(*) FROM items WHERE deleted_at IS NOT NULL`)).To(Equal("1"))
+
+		_, err = conn.Exec(ctx, `DROP RULE soft_delete ON items`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `DELETE FROM items WHERE id = 1`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count(`SELECT count(*) FROM items`)).To(Equal("2"))
+	})
+
+	It("Deletes through views with a DO INSTEAD rule", func() {
+		_, err := conn.Exec(ctx, `CREATE VIEW live_items AS SELECT id, name FROM items WHERE deleted_at IS NULL`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `CREATE RULE live_delete AS ON DELETE TO live_items
+			DO INSTEAD UPDATE items SET deleted_at = 'now' WHERE id = OLD.id`)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Rows changed by rules aren't counted, as by PostgreSQL.
+		tag, err := conn.Exec(ctx, `DELETE FROM live_items WHERE id = 2`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.RowsAffected()).To(Equal(int64(0)))
+		Expect(count(`SELECT count(*) FROM live_items`)).To(Equal("1"))
+		Expect(count(`SELECT count(*) FROM items`)).To(Equal("2"))
+	})
+
+	It("Updates simple views", func() {
+		_, err := conn.Exec(ctx, `CREATE TABLE stock (sku TEXT PRIMARY KEY, quantity INTEGER DEFAULT 0, note TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `CREATE VIEW in_stock (code, qty) AS SELECT sku, quantity FROM stock WHERE quantity > 0`)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = conn.Exec(ctx, `INSERT INTO in_stock (code, qty) VALUES ('a', 5), ('b', 2)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO in_stock (code) VALUES ('c')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count(`SELECT quantity FROM stock WHERE sku = 'c'`)).To(Equal("0"))
+
+		tag, err := conn.Exec(ctx, `UPDATE in_stock SET qty = qty + 1 WHERE code = $1`, "a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.RowsAffected()).To(Equal(int64(1)))
+		Expect(count(`SELECT quantity FROM stock WHERE sku = 'a'`)).To(Equal("6"))
+		// Rows of the table out of the view aren't changed nor counted.
+		tag, err = conn.Exec(ctx, `UPDATE in_stock SET qty = qty WHERE qty >= 0`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.RowsAffected()).To(Equal(int64(2)))
+		tag, err = conn.Exec(ctx, `DELETE FROM in_stock WHERE code = 'b'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.RowsAffected()).To(Equal(int64(1)))
+		Expect(count(`SELECT count(*) FROM stock`)).To(Equal("2"))
+
+		// Views are replaced along with their triggers.
+		_, err = conn.Exec(ctx, `CREATE OR REPLACE VIEW in_stock AS SELECT * FROM stock WHERE quantity > 0`)
+		Expect(err).NotTo(HaveOccurred())
+		tag, err = conn.Exec(ctx, `UPDATE in_stock SET note = 'restocked' WHERE sku = 'a'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("UPDATE 1"))
+		Expect(count(`SELECT note FROM stock WHERE sku = 'a'`)).To(Equal("restocked"))
+
+		// Views of aggregates aren't updatable.
+		_, err = conn.Exec(ctx, `CREATE VIEW totals AS SELECT count(*) AS n FROM stock`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `DELETE FROM totals`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Rejects triggers executing undefined functions and rules on SELECT", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `CREATE TRIGGER audit BEFORE DELETE ON items FOR EACH ROW EXECUTE FUNCTION audit_delete()`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42883"))
+
+		_, err = conn.Exec(ctx, `CREATE RULE "_RETURN" AS ON SELECT TO items DO INSTEAD SELECT 1`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+
+		// SQLite triggers are executed as they are.
+		_, err = conn.Exec(ctx, `CREATE TRIGGER stamp AFTER INSERT ON items BEGIN UPDATE items SET name = upper(NEW.name) WHERE id = NEW.id; END`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Executes triggers of SQL trigger functions", func() {
+		_, err := conn.Exec(ctx, `CREATE TABLE audit (item INTEGER, name TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `CREATE FUNCTION audit_name() RETURNS trigger LANGUAGE sql AS $$
+			INSERT INTO audit (item, name) VALUES (NEW.id, NEW.name) $$`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `CREATE TRIGGER audit_names AFTER INSERT OR UPDATE OF name ON items
+			FOR EACH ROW EXECUTE FUNCTION audit_name()`)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = conn.Exec(ctx, `INSERT INTO items (id, name) VALUES (10, 'x')`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `UPDATE items SET name = 'y' WHERE id = $1`, 10)
+		Expect(err).NotTo(HaveOccurred())
+		// The stamp trigger uppercases inserted names, the update is audited too.
+		Expect(count(`SELECT group_concat(name, ',') FROM audit WHERE item = 10`)).To(Equal("x,X,y"))
+
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `CREATE FUNCTION audit_name() RETURNS trigger LANGUAGE sql AS 'SELECT 1'`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42723"))
+		_, err = conn.Exec(ctx, `CREATE FUNCTION stamp() RETURNS trigger LANGUAGE plpgsql AS $$ BEGIN RETURN NEW; END $$`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+		_, err = conn.Exec(ctx, `CREATE TRIGGER audit_names AFTER DELETE ON items FOR EACH ROW EXECUTE FUNCTION audit_name()`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42710"))
+
+		_, err = conn.Exec(ctx, `DROP TRIGGER audit_names ON items`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `DROP TRIGGER IF EXISTS audit_names ON items`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `DROP FUNCTION audit_name()`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `UPDATE items SET name = 'z' WHERE id = 10`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count(`SELECT count(*) FROM audit`)).To(Equal("3"))
+		Expect(count(`SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'audit_names%'`)).To(Equal("0"))
+	})
+
+	It("Translates generated columns and partial indexes", func() {
+		_, err := conn.Exec(ctx, `CREATE TABLE orders (id integer PRIMARY KEY, price numeric, qty int,
+			total numeric GENERATED ALWAYS AS (price * qty::numeric) STORED, status text)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `ALTER TABLE orders ADD COLUMN cents int GENERATED ALWAYS AS ((total * 100)::int) STORED`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO orders (price, qty, status) VALUES (2.5, 4, 'open'), (1, 1, 'open')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count(`SELECT cents FROM orders WHERE id = 1`)).To(Equal("1000"))
+
+		_, err = conn.Exec(ctx, `CREATE UNIQUE INDEX CONCURRENTLY ON public.orders (lower(status)) WHERE status <> 'closed' AND id = 1`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count(`SELECT count(*) FROM sqlite_master WHERE name = 'orders_lower_idx'`)).To(Equal("1"))
+
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `CREATE INDEX orders_status ON orders USING gist (status)`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+		_, err = conn.Exec(ctx, `CREATE TABLE tickets (id int GENERATED ALWAYS AS IDENTITY)`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+	})
+
+	It("Translates collations", func() {
+		_, err := conn.Exec(ctx, `CREATE TABLE cities (name text COLLATE "sv_SE.utf8", code text COLLATE "C")`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO cities VALUES ('Zürich', 'b'), ('Örebro', 'A'), ('Aachen', 'a')`)
+		Expect(err).NotTo(HaveOccurred())
+
+		names := func(query string, args ...any) []string {
+			rows, err := conn.Query(ctx, query, args...)
+			Expect(err).NotTo(HaveOccurred())
+			names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+			Expect(err).NotTo(HaveOccurred())
+			return names
+		}
+		Expect(names(`SELECT name FROM cities ORDER BY name`)).To(Equal([]string{"Aachen", "Zürich", "Örebro"}))
+		Expect(names(`SELECT name FROM cities ORDER BY name COLLATE "de-x-icu"`)).To(Equal([]string{"Aachen", "Örebro", "Zürich"}))
+		Expect(names(`SELECT code FROM cities ORDER BY code`)).To(Equal([]string{"A", "a", "b"}))
+		Expect(names(`SELECT code FROM cities WHERE code = $1 COLLATE "en_US" ORDER BY code COLLATE pg_catalog."default"`, "A")).To(Equal([]string{"A"}))
+
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `SELECT name FROM cities ORDER BY name COLLATE "xx_YY"`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42704"))
+	})
+})
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Schemas are SQLite databases attached to the sessions of their database, their files are
+// kept in a directory next to the database file, named after it.
+const schemaDirSuffix = ".schemas"
+
+// Returns the directory of the schema files of a database.
+func (s *Server) schemaDir(name string) string {
+	return s.databasePath(name) + schemaDirSuffix
+}
+
+// Reports whether the schema name is taken by the main, temporary, system or PostgreSQL schemas.
+func builtinSchema(name string) bool {
+	switch strings.ToLower(name) {
+	case "main", "temp", sqlite.SystemSchema, publicSchema, "pg_catalog", "information_schema":
+		return true
+	}
+	return false
+}
+
+// Attaches the schemas of the database to the session and detaches the dropped ones,
+// in-memory databases have none.
+func (s *Server) attachSchemas(ctx context.Context, c *Conn) error {
+	gen := s.schemaGen.Load()
+	if c.dsn != s.databasePath(c.name) {
+		c.schemaGen = gen
+		return nil
+	}
+	entries, err := os.ReadDir(s.schemaDir(c.name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasSuffix(name, "-wal") ||
+			strings.HasSuffix(name, "-shm") || strings.HasSuffix(name, "-journal") {
+			continue
+		}
+		names = append(names, name)
+	}
+	for _, name := range c.schemas {
+		if !slices.Contains(names, name) {
+			if err := sqlite.DetachSchema(ctx, c.conn, name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range names {
+		if !slices.Contains(c.schemas, name) {
+			if err := sqlite.AttachSchema(ctx, c.conn, name, filepath.Join(s.schemaDir(c.name), name)); err != nil {
+				return err
+			}
+		}
+	}
+	c.schemas, c.schemaGen = names, gen
+	return nil
+}
+
+// Attaches the schemas created and detaches the schemas dropped by other sessions since the
+// last statement. SQLite can't attach databases in transactions, schemas are synced after them.
+func (s *Server) syncSchemas(ctx context.Context, c *Conn) error {
+	if c.schemaGen == s.schemaGen.Load() {
+		return nil
+	}
+	if inTx, err := sqlite.InTransaction(c.conn); err != nil || inTx {
+		return err
+	}
+	return s.attachSchemas(ctx, c)
+}
+
+// Returns the schema of the session named name, case insensitive, false if there's none.
+func (c *Conn) schema(name string) (string, bool) {
+	for _, schema := range c.schemas {
+		if strings.EqualFold(schema, name) {
+			return schema, true
+		}
+	}
+	return "", false
+}
+
+// Returns the schemas of the search_path setting of the session that exist, in order.
+// "$user" stands for the schema named after the session user.
+func (c *Conn) searchPath() []string {
+	var path []string
+	for _, name := range strings.Split(c.param("search_path"), ",") {
+		name = strings.TrimSpace(name)
+		if name == `"$user"` || name == "$user" {
+			name = c.activity.user
+		} else if unquoted := strings.Trim(name, `"`); unquoted != name {
+			name = unquoted
+		} else {
+			name = strings.ToLower(name)
+		}
+		if strings.EqualFold(name, publicSchema) {
+			path = append(path, publicSchema)
+		} else if schema, ok := c.schema(name); ok {
+			path = append(path, schema)
+		}
+	}
+	return path
+}
+
+// Qualifies the unqualified relations of a statement by the schema of the search path of the
+// session they're found in, or created in. Relations of the public schema, the main database,
+// and temporary relations are left unqualified, as SQLite searches them first.
+func (s *Server) qualifyRelations(ctx context.Context, c *Conn, sql string) (string, error) {
+	if err := s.syncSchemas(ctx, c); err != nil {
+		return "", err
+	}
+	if len(c.schemas) == 0 {
+		return sql, nil
+	}
+
+	path := c.searchPath()
+	resolve := func(name string, create bool) (string, error) {
+		if create {
+			if len(path) == 0 {
+				return "", pgerror.New(pgerror.InvalidSchemaName, "no schema has been selected to create in")
+			} else if path[0] == publicSchema {
+				return "", nil
+			}
+			return path[0], nil
+		}
+		if ok, err := schemaRelation(ctx, c, "temp", name); err != nil || ok {
+			return "", err
+		}
+		for _, schema := range path {
+			database := schema
+			if schema == publicSchema {
+				database = "main"
+			}
+			if ok, err := schemaRelation(ctx, c, database, name); err != nil {
+				return "", err
+			} else if ok && schema == publicSchema {
+				return "", nil
+			} else if ok {
+				return schema, nil
+			}
+		}
+		// SQLite finds relations in all the attached databases, those of schemas off the
+		// search path are hidden by qualifying them with the first schema of the path,
+		// the relation doesn't exist then as in PostgreSQL.
+		hidden := []string{"main"}
+		if slices.Contains(path, publicSchema) {
+			hidden = nil
+		}
+		for _, schema := range c.schemas {
+			if !slices.Contains(path, schema) {
+				hidden = append(hidden, schema)
+			}
+		}
+		for _, database := range hidden {
+			if ok, err := schemaRelation(ctx, c, database, name); err != nil {
+				return "", err
+			} else if !ok {
+				continue
+			}
+			switch {
+			case len(path) == 0:
+				return "temp", nil
+			case path[0] == publicSchema:
+				return "main", nil
+			}
+			return path[0], nil
+		}
+		return "", nil
+	}
+	text, err := parser.QualifyRelations(sql, resolve)
+	if err != nil {
+		// Statements PostgreSQL can't parse are left to SQLite.
+		var e *pgerror.Error
+		if errors.As(err, &e) && e.Code == pgerror.SyntaxError {
+			return sql, nil
+		}
+		return "", err
+	}
+	if text != sql {
+		c.tracef("schema qualification: %s", text)
+	}
+	return text, nil
+}
+
+// Returns the search path relations are resolved through, with the schemas of the session.
+// Empty without schemas, relations are never qualified then.
+func (c *Conn) searchPathKey() string {
+	if len(c.schemas) == 0 {
+		return ""
+	}
+	return c.param("search_path") + "; " + strings.Join(c.schemas, ", ")
+}
+
+// Resolves the relations of a prepared statement again when the search path changed since
+// it was parsed, and inlines its function calls again when functions changed, as
+// PostgreSQL plans cached statements again.
+func (s *Server) requalifyStmt(ctx context.Context, c *Conn, stmt *preparedStmt) error {
+	if err := s.syncSchemas(ctx, c); err != nil {
+		return err
+	}
+	if err := s.syncFunctions(ctx, c); err != nil {
+		return err
+	}
+	if stmt.remote != nil || stmt.searchPath == c.searchPathKey() && stmt.functionGen == c.functionGen {
+		return nil
+	}
+	switch stmt.class.Command {
+	case parser.CmdSelect, parser.CmdInsert, parser.CmdUpdate, parser.CmdDelete:
+	default:
+		return nil
+	}
+	text, err := s.qualifyRelations(ctx, c, stmt.source)
+	if err != nil {
+		return err
+	}
+	if text, err = s.inlineFunctions(ctx, c, text); err != nil {
+		return err
+	}
+	stmt.closeHandle()
+	stmt.query = parser.RewriteQuery(text)
+	stmt.searchPath, stmt.functionGen = c.searchPathKey(), c.functionGen
+	return nil
+}
+
+// Reports whether a table or view of the attached database exists.
+func schemaRelation(ctx context.Context, c *Conn, database, name string) (bool, error) {
+	var count int
+	err := c.conn.QueryRowContext(ctx, `SELECT count(*) FROM `+sqlite.QuoteIdent(database)+`.sqlite_master WHERE name = ? COLLATE NOCASE AND type IN ('table', 'view')`,
+		name).Scan(&count)
+	return count > 0, err
+}
+
+// Executes the statements creating and dropping schemas, the response is appended to buf.
+// Returns false if the statement is not a schema statement.
+func (s *Server) execSchemaStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, buf []byte) ([]byte, bool, error) {
+	var err error
+	switch stmt.Command {
+	case "CREATE SCHEMA":
+		var name string
+		var ifNotExists bool
+		if name, ifNotExists, err = parser.CreateSchema(stmt.SQL); err == nil {
+			err = s.createSchema(ctx, c, name, ifNotExists)
+		}
+	case "DROP SCHEMA":
+		var names []string
+		var missingOK, cascade bool
+		if names, missingOK, cascade, err = parser.DropObjects(stmt.SQL); err != nil {
+			break
+		}
+		for _, name := range names {
+			if err = s.dropSchema(ctx, c, name, missingOK, cascade); err != nil {
+				break
+			}
+		}
+	default:
+		return buf, false, nil
+	}
+	if err != nil {
+		return buf, true, err
+	}
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(0))}).Encode(buf)
+	return buf, true, nil
+}
+
+// Creates a schema of the session database and attaches it to the session.
+func (s *Server) createSchema(ctx context.Context, c *Conn, name string, ifNotExists bool) error {
+	if err := s.syncSchemas(ctx, c); err != nil {
+		return err
+	}
+	if _, ok := c.schema(name); ok || builtinSchema(name) {
+		if ifNotExists {
+			return nil
+		}
+		return pgerror.Newf(pgerror.DuplicateSchema, "schema %q already exists", name)
+	}
+	switch {
+	case strings.HasPrefix(strings.ToLower(name), "pg_"):
+		e := pgerror.Newf(pgerror.ReservedName, "unacceptable schema name %q", name)
+		e.Detail = `The prefix "pg_" is reserved for system schemas.`
+		return e
+	case strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, "."):
+		return pgerror.Newf(pgerror.InvalidSchemaName, "invalid schema name %q", name)
+	case c.dsn != s.databasePath(c.name):
+		return pgerror.New(pgerror.FeatureNotSupported, "schemas of in-memory databases are not supported")
+	}
+
+	// An empty file is a new database.
+	if err := os.MkdirAll(s.schemaDir(c.name), 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(s.schemaDir(c.name), name)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := sqlite.AttachSchema(ctx, c.conn, name, path); err != nil {
+		os.Remove(path)
+		return err
+	}
+	c.schemas = append(c.schemas, name)
+	s.schemaGen.Add(1)
+	return nil
+}
+
+// Drops a schema of the session database, which must be empty unless cascade is set.
+// Other sessions detach it before their next statement.
+func (s *Server) dropSchema(ctx context.Context, c *Conn, name string, missingOK, cascade bool) error {
+	if err := s.syncSchemas(ctx, c); err != nil {
+		return err
+	}
+	schema, ok := c.schema(name)
+	switch {
+	case builtinSchema(name):
+		return pgerror.Newf(pgerror.DependentObjectsStillExist, "cannot drop schema %s because it is required by the database system", name)
+	case !ok && missingOK:
+		return nil
+	case !ok:
+		return pgerror.Newf(pgerror.InvalidSchemaName, "schema %q does not exist", name)
+	}
+	if !cascade {
+		var objects int
+		if err := c.conn.QueryRowContext(ctx, `SELECT count(*) FROM `+sqlite.QuoteIdent(schema)+`.sqlite_master`).Scan(&objects); err != nil {
+			return err
+		} else if objects > 0 {
+			e := pgerror.Newf(pgerror.DependentObjectsStillExist, "cannot drop schema %s because other objects depend on it", schema)
+			e.Hint = "Use DROP ... CASCADE to drop the dependent objects too."
+			return e
+		}
+	}
+
+	if err := sqlite.DetachSchema(ctx, c.conn, schema); err != nil {
+		return err
+	}
+	path := filepath.Join(s.schemaDir(c.name), schema)
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	c.schemas = slices.DeleteFunc(c.schemas, func(name string) bool { return name == schema })
+	s.schemaGen.Add(1)
+	return nil
+}
@@ -0,0 +1,149 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schemas", Ordered, func() {
+	var conn, other *pgx.Conn
+	ctx := context.Background()
+
+	query := func(conn *pgx.Conn, sql string) string {
+		var value string
+		Expect(conn.QueryRow(ctx, sql).Scan(&value)).To(Succeed(), sql)
+		return value
+	}
+	exec := func(conn *pgx.Conn, stmts ...string) {
+		for _, stmt := range stmts {
+			_, err := conn.Exec(ctx, stmt)
+			Expect(err).NotTo(HaveOccurred(), stmt)
+		}
+	}
+	pgError := func(err error) *pgconn.PgError {
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue(), fmt.Sprint(err))
+		return pgErr
+	}
+
+	BeforeAll(func() {
+		conn = connect(ctx, "schemas.db")
+		other = connect(ctx, "schemas.db")
+		exec(conn,
+			`CREATE SCHEMA sales`,
+			`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+			`INSERT INTO items VALUES (1, 'public item')`,
+		)
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+		Expect(other.Close(ctx)).To(Succeed())
+	})
+
+	It("Resolves schema-qualified relations", func() {
+		exec(conn,
+			`CREATE TABLE sales.items (id INTEGER PRIMARY KEY, name TEXT)`,
+			`INSERT INTO sales.items VALUES (1, 'sales item')`,
+		)
+		Expect(query(conn, `SELECT name FROM sales.items`)).To(Equal("sales item"))
+		Expect(query(conn, `SELECT name FROM public.items`)).To(Equal("public item"))
+		Expect(query(conn, `SELECT name FROM items`)).To(Equal("public item"))
+	})
+
+	It("Keeps literals naming the public schema", func() {
+		exec(conn, `INSERT INTO public.items VALUES (2, 'visit public.example.com')`)
+		Expect(query(conn, `SELECT name FROM public.items WHERE id = 2`)).To(Equal("visit public.example.com"))
+		Expect(query(conn, `SELECT 'public.items'`)).To(Equal("public.items"))
+		exec(conn, `DELETE FROM public.items WHERE id = 2`)
+	})
+
+	It("Attaches the schemas created by other sessions", func() {
+		Expect(query(other, `SELECT name FROM sales.items`)).To(Equal("sales item"))
+		exec(other, `CREATE SCHEMA archive`)
+		exec(conn, `CREATE TABLE archive.items (id INTEGER PRIMARY KEY)`)
+	})
+
+	It("Resolves unqualified relations through the search path", func() {
+		exec(conn, `SET search_path TO sales, public`)
+		DeferCleanup(func() { exec(conn, `RESET search_path`) })
+
+		Expect(query(conn, `SELECT name FROM items`)).To(Equal("sales item"))
+		Expect(query(conn, `SELECT current_schema()`)).To(Equal("sales"))
+		Expect(query(conn, `SELECT current_schemas(false)`)).To(Equal("{sales,public}"))
+
+		// Relations are created in the first schema of the path.
+		exec(conn,
+			`CREATE TABLE orders (id INTEGER PRIMARY KEY, item INTEGER)`,
+			`INSERT INTO orders VALUES (1, 1)`,
+			`UPDATE items SET name = 'updated' WHERE id = 1`,
+		)
+		Expect(query(conn, `SELECT count(*) FROM sales.orders`)).To(Equal("1"))
+		Expect(query(conn, `SELECT name FROM sales.items`)).To(Equal("updated"))
+		Expect(query(conn, `SELECT name FROM public.items`)).To(Equal("public item"))
+
+		// Relations missing from the first schema are found in the next ones.
+		exec(conn, `CREATE TABLE public.customers (id INTEGER PRIMARY KEY)`)
+		Expect(query(conn, `SELECT count(*) FROM customers`)).To(Equal("0"))
+
+		// Common table expressions aren't relations.
+		Expect(query(conn, `WITH items AS (SELECT 'cte' AS name) SELECT name FROM items`)).To(Equal("cte"))
+
+		var name string
+		Expect(conn.QueryRow(ctx, `SELECT name FROM items WHERE id = $1`, 1).Scan(&name)).To(Succeed())
+		Expect(name).To(Equal("updated"))
+	})
+
+	It("Doesn't resolve relations of schemas off the search path", func() {
+		exec(conn, `CREATE TABLE archive.ledger (id INTEGER PRIMARY KEY)`)
+
+		_, err := conn.Exec(ctx, `SELECT * FROM ledger`)
+		Expect(pgError(err).Code).To(Equal("42P01"))
+		_, err = conn.Exec(ctx, `INSERT INTO ledger VALUES (1)`)
+		Expect(pgError(err).Code).To(Equal("42P01"))
+		exec(conn, `DROP TABLE IF EXISTS ledger`)
+
+		exec(conn, `SET search_path TO archive`)
+		DeferCleanup(func() { exec(conn, `RESET search_path`) })
+		Expect(query(conn, `SELECT count(*) FROM ledger`)).To(Equal("0"))
+		// The public schema is off the path too.
+		_, err = conn.Exec(ctx, `SELECT * FROM customers`)
+		Expect(pgError(err).Code).To(Equal("42P01"))
+	})
+
+	It("Takes the search path from the startup parameters", func() {
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/schemas.db?sslmode=disable&search_path=sales", srv.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(ctx)
+
+		Expect(query(conn, `SHOW search_path`)).To(Equal("sales"))
+		Expect(query(conn, `SELECT name FROM items`)).To(Equal("updated"))
+	})
+
+	It("Rejects existing and reserved schema names", func() {
+		_, err := conn.Exec(ctx, `CREATE SCHEMA sales`)
+		Expect(pgError(err).Code).To(Equal("42P06"))
+		_, err = conn.Exec(ctx, `CREATE SCHEMA public`)
+		Expect(pgError(err).Code).To(Equal("42P06"))
+		_, err = conn.Exec(ctx, `CREATE SCHEMA pg_mine`)
+		Expect(pgError(err).Code).To(Equal("42939"))
+		exec(conn, `CREATE SCHEMA IF NOT EXISTS sales`)
+	})
+
+	It("Drops schemas", func() {
+		_, err := conn.Exec(ctx, `DROP SCHEMA archive`)
+		Expect(pgError(err).Code).To(Equal("2BP01"))
+		exec(conn, `DROP SCHEMA archive CASCADE`, `DROP SCHEMA IF EXISTS archive`)
+
+		_, err = conn.Exec(ctx, `DROP SCHEMA archive`)
+		Expect(pgError(err).Code).To(Equal("3F000"))
+		_, err = other.Exec(ctx, `SELECT * FROM archive.items`)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -6,18 +6,23 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgproto3/v2"
-	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v5/pgconn"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/kqlite/kqlite/pkg/migrate"
 	"github.com/kqlite/kqlite/pkg/parser"
 	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/sysdb"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
 )
 
 // Postgres settings.
@@ -26,30 +31,291 @@ const (
 )
 
 type Server struct {
-	mu    sync.Mutex
-	ln    net.Listener
-	conns map[*Conn]struct{}
+	mu        sync.Mutex
+	ln        *listener   // TCP listener of Addr.
+	unixLn    *listener   // Unix socket listener, nil if disabled.
+	listeners []*listener // All listeners.
+	conns     map[*Conn]struct{}
+	memDBs    map[string]*sql.DB // In-memory databases by name.
+	memPrefix string             // Prefix of the names of in-memory databases, unique per server.
+
+	dataDirLock  *os.File    // Lock file of DataDir, held while the server is open.
+	shuttingDown atomic.Bool // Shutdown closed the listeners, their accept errors aren't reported.
+
+	schemaVersions map[string]int64           // SQLite schema versions of opened databases, by name.
+	committers     map[string]*groupCommitter // Group committers by database path.
+	usage          *usageTracker              // Table and index usage statistics.
+	oids           *oidRegistry               // OIDs of the relations of databases.
 
 	g      errgroup.Group
 	ctx    context.Context
 	cancel func()
 
+	sysdb        *sysdb.SysDB // kqlite system schema.
+	connsTotal   atomic.Int64 // Number of accepted connections.
+	queriesTotal atomic.Int64 // Number of executed queries.
+
+	groupCommitSyncs atomic.Int64 // Number of WAL syncs by group commit.
+	groupCommits     atomic.Int64 // Number of commits synced by group commit.
+
+	retries       atomic.Int64 // Number of statement retries.
+	retriesFailed atomic.Int64 // Number of retried statements that failed.
+
+	readSlots         chan struct{}            // Read slots of all databases, nil for no limit.
+	dbReadSlots       map[string]chan struct{} // Read slots by database name.
+	readsActive       atomic.Int64             // Number of read statements holding read slots.
+	readsQueued       atomic.Int64             // Number of read statements that waited for a slot.
+	readQueueTimeouts atomic.Int64             // Number of read statements that timed out waiting.
+
+	maint             *maintainer  // Churn of databases since their maintenance.
+	maintenanceRuns   atomic.Int64 // Number of scheduled maintenances of databases.
+	maintenancePauses atomic.Int64 // Number of maintenance checks skipped under load.
+
+	quotaWarned     map[string]bool // Databases over the quota warning ratio, by name.
+	quotaWarnings   atomic.Int64    // Number of times databases crossed the quota warning ratio.
+	quotaRejections atomic.Int64    // Number of statements rejected over the quota.
+
+	cdc      *changeFeed     // Replication slots and the changes they stream.
+	foreign  *foreignCatalog // Foreign servers and foreign tables of databases.
+	notifier *notifier       // Sessions listening to notification channels.
+	advisory *advisoryLocks  // Advisory locks of databases held by sessions.
+
+	lockWaitWarnings atomic.Int64 // Number of lock waits logged over LockWaitWarning.
+
+	unsupported atomic.Int64 // Number of statements failing on unsupported features.
+
+	accounts    *accounting  // Resource usage of databases.
+	rateLimited atomic.Int64 // Number of statements rejected over the rate limits of their database.
+
+	plans *planCache // Parse results of queries shared by the sessions of databases.
+
+	initScripts []migrate.Migration // Scripts of InitSQLDir, loaded when the server opens.
+	initMu      sync.Mutex          // Serializes the creation of databases running init scripts.
+	initApplied []sysdb.InitScript  // Init scripts applied since the server opened, guarded by mu.
+
+	replicationBytes     atomic.Int64 // Bytes of the transactions streamed to replication clients.
+	replicationSentBytes atomic.Int64 // Bytes of those transactions as sent, once compressed.
+
+	schemaGen atomic.Int64 // Number of schemas created or dropped, sessions sync their schemas when it changes.
+
+	functions   map[string]map[string]*parser.Function // SQL functions of databases by name, as last loaded by their sessions.
+	functionGen atomic.Int64                           // Number of function changes, sessions load their functions again when it changes.
+
 	// Bind address to listen to Postgres wire protocol.
 	Addr string
 
+	// Pre-opened listener used rather than binding Addr, e.g. a socket passed by
+	// systemd socket activation. It's closed along with the other listeners.
+	Listener net.Listener
+
+	// Directory of the Unix socket to listen to Postgres wire protocol,
+	// named ".s.PGSQL.<port>" after the TCP port. Empty disables the Unix socket.
+	UnixSocketDir string
+
+	// Pre-opened Unix socket listener used rather than creating the socket of
+	// UnixSocketDir, e.g. one passed by the server an upgrade replaces.
+	UnixListener net.Listener
+
+	// Additional listeners, each one restricted to its own set of databases.
+	Listeners []ListenerConfig
+
+	// Journal mode applied to databases when opened by a client, e.g. "wal" or
+	// "delete", per database name. Databases not listed use the default mode,
+	// if empty their journal mode is left unchanged.
+	JournalModes       map[string]string
+	DefaultJournalMode string
+
 	// Directory that holds SQLite databases.
 	DataDir string
+
+	// Maximum wait for the lock of DataDir held by another server, e.g. the server an
+	// upgrade replaces while it drains its sessions, 0 fails at once. A single server
+	// opens the databases of a data directory so only one process writes to them.
+	DataDirLockTimeout time.Duration
+
+	// Called by Open once the listeners and the system schema are set up, right before it
+	// takes the lock of DataDir, Open fails if it does. Connections are accepted once the
+	// lock is taken, e.g. the server of an upgrade lets the server it replaces drain its
+	// sessions and release the lock here, once nothing else can fail but the wait.
+	BeforeLock func() error
+
+	// Maximum number of named prepared statements per connection, 0 for no limit.
+	// The least recently used statements are evicted once the limit is reached.
+	MaxPreparedStmts int
+
+	// Maximum delay of a commit to be synced together with the commits of
+	// concurrent sessions, 0 disables group commit. Applies to WAL mode databases.
+	GroupCommitDelay time.Duration
+
+	// Maximum number of attempts of statements failing with SQLITE_BUSY or
+	// SQLITE_LOCKED, 0 or 1 disables retries. The delay between attempts starts
+	// at RetryBackoff and doubles after each attempt.
+	RetryAttempts int
+	RetryBackoff  time.Duration
+
+	// Maximum number of read-only statements returning rows that execute concurrently,
+	// across all databases and per database, 0 for no limit. Statements over the limits
+	// wait for a slot up to ReadQueueTimeout, 0 to wait until the client cancels.
+	MaxReads            int
+	MaxReadsPerDatabase int
+	ReadQueueTimeout    time.Duration
+
+	// Interval between checks of the maintenance scheduler, 0 disables scheduled maintenance.
+	// Databases with at least MaintenanceChurn rows changed since their last maintenance
+	// are analyzed and incrementally vacuumed, databases with any change if 0. Checks are
+	// skipped while more than MaintenancePauseQueries queries were executed since the
+	// previous check, 0 never skips them.
+	MaintenanceInterval     time.Duration
+	MaintenanceChurn        int64
+	MaintenancePauseQueries int64
+
+	// Size quota of the database file and its WAL in bytes, per database name, 0 for no
+	// limit. Databases not listed use the default quota. Statements that may grow a
+	// database over its quota fail with disk_full, a warning is logged once a database
+	// reaches QuotaWarningRatio of its quota, 0 disables warnings.
+	DatabaseQuotas       map[string]int64
+	DefaultDatabaseQuota int64
+	QuotaWarningRatio    float64
+
+	// Maximum rate of statements per second of a database, per database name, 0 for no
+	// limit. Databases not listed use the default rate limit. Statements over the limit
+	// fail, statements ending transactions are never limited. Multi-statement queries of
+	// limited databases are executed one by one. The resource usage of databases is listed
+	// in kqlite.stat_databases.
+	DatabaseRateLimits       map[string]float64
+	DefaultDatabaseRateLimit float64
+
+	// Maximum number of read-only queries of a multi-statement simple query executed
+	// concurrently on read connections of the session, 0 or 1 executes them one by one.
+	// Only queries outside of transaction blocks that don't refer to temporary relations
+	// or schemas of file databases run concurrently, responses keep the statement order.
+	ParallelReads int
+
+	// Default of the kqlite.max_rows setting of sessions, the maximum number of rows of
+	// a response, 0 for no limit. Larger results fail, or are truncated when sessions set
+	// kqlite.max_rows_action to truncate.
+	MaxResultRows int64
+
+	// Notify the channel table_<name> of the rows of a table changed by each committed
+	// transaction, with a JSON payload of their keys, e.g. {"table":"items","insert":[4],
+	// "delete":[2]}. Keys are left out and truncated is set for transactions changing
+	// too many rows. Changes of WITHOUT ROWID tables aren't notified.
+	ChangeNotifications bool
+
+	// Log a warning for the sessions waiting longer than LockWaitWarning for the write
+	// lock of a database or for an advisory lock, with the sessions holding it, 0 disables
+	// the warnings. Held and awaited locks are listed in pg_locks.
+	LockWaitWarning time.Duration
+
+	// Directory of the SQL scripts run on file databases when they're created, in the
+	// order of their names, e.g. to create their schema and seed data. Scripts run in a
+	// single transaction, the database is removed if one fails. Applied scripts are listed
+	// in kqlite.init_scripts.
+	InitSQLDir string
+
+	// Maximum number of parse results of queries cached and shared by the sessions of their
+	// database, 0 disables the cache. The least recently used results are evicted once
+	// the limit is reached, the results of a database are dropped by its DDL.
+	PlanCacheSize int
+
+	// Trace all connections, logging the messages they receive and send and the
+	// statements they execute. Sessions change it with the kqlite.trace setting.
+	Trace bool
+
+	// Allow sessions to inject faults in the streaming of replication slots with
+	// kqlite_replication_fault(), to test the recovery of replication clients.
+	FaultInjection bool
 }
 
 type Conn struct {
 	net.Conn
 	backend *pgproto3.Backend
-	db      *sql.DB // sqlite database
+	db      *sql.DB   // sqlite database
+	conn    *sql.Conn // dedicated session connection, all statements are executed on it
+	name    string    // database name
+	dsn     string    // sqlite database DSN
+
+	stmts   map[string]*preparedStmt // Prepared statements by name.
+	portals map[string]*portal       // Portals by name.
+	cursors map[string]*cursor       // Open cursors by name.
+	failed  bool                     // Extended query failed, discard messages until Sync.
+	batch   bool                     // Writes are executed in a transaction until Sync.
+
+	params       map[string]string // Session settings by lowercase name.
+	location     *time.Location    // Session time zone, set by the TimeZone setting.
+	defaults     map[string]string // Settings RESET restores, by lowercase name.
+	paramChanges []string          // Reported settings changed since the last ReadyForQuery.
+
+	maxStmts      int          // Limit of named prepared statements.
+	stmtCount     atomic.Int64 // Number of prepared statements.
+	stmtBytes     atomic.Int64 // Approximate memory used by prepared statements.
+	stmtEvictions atomic.Int64 // Number of evicted prepared statements.
+
+	committer *groupCommitter // Group committer of the database, nil if disabled.
+	listener  *listener       // Listener that accepted the connection.
+
+	replication    bool  // Logical replication connection, accepts replication commands.
+	capturing      bool  // Row changes are captured for replication slots.
+	captureVersion int64 // Schema version of the database the capture triggers were created for.
+
+	foreignConns map[*foreignServer]*pgconn.PgConn // Connections to foreign servers.
+
+	xact      *transaction // Statements of the transaction block, nil outside of transaction blocks.
+	queryOnly bool         // The query_only pragma is set, in read-only transaction blocks.
+
+	trace atomic.Bool // Messages and statements are logged, set by other sessions too.
+
+	pid       int32     // Process ID of the session in pg_stat_activity, unique in the server.
+	secretKey uint32    // Key of the cancel requests of the session.
+	started   time.Time // Time the client connected.
+	activity  activity  // Current query and state of the session.
+
+	terminated atomic.Bool // The session was terminated by pg_terminate_backend.
+
+	readConns []*sql.Conn // Read connections of parallel queries, opened on demand.
+
+	rowsRead    atomic.Int64 // Rows returned since the last statement accounted for, by read connections too.
+	rowsWritten atomic.Int64 // Rows changed since the last statement accounted for.
+
+	maxRows      int64 // Row limit of responses, 0 for no limit.
+	truncateRows bool  // Responses over the row limit are truncated rather than failing.
+
+	mailbox          *mailbox                         // Notifications to deliver, nil until the session listens.
+	notifyMu         sync.Mutex                       // Guards notifications, queued by pg_notify() of read connections too.
+	notifications    []*pgproto3.NotificationResponse // Notifications of the transaction block, sent at commit.
+	changes          map[string]*tableChanges         // Rows changed by the current transaction by table, with ChangeNotifications.
+	committedChanges []*tableChanges                  // Rows changed by the transactions committed since the last statement.
+
+	writeMu sync.Mutex // Serializes writes to the client, notifications are written concurrently.
+
+	schemas   []string // Schemas of the database attached to the session.
+	schemaGen int64    // Schema changes of the server the attached schemas are synced with.
+
+	functions        map[string]*parser.Function // SQL functions of the database by name.
+	functionGen      int64                       // Function changes of the server the functions are synced with.
+	functionsChanged bool                        // The transaction block changed functions.
 }
 
 func NewServer() *Server {
 	s := &Server{
-		conns: make(map[*Conn]struct{}),
+		conns:     make(map[*Conn]struct{}),
+		memDBs:    make(map[string]*sql.DB),
+		memPrefix: fmt.Sprintf("kqlite-memory-%d-", memoryServers.Add(1)),
+
+		schemaVersions: make(map[string]int64),
+		committers:     make(map[string]*groupCommitter),
+		dbReadSlots:    make(map[string]chan struct{}),
+		usage:          newUsageTracker(),
+		oids:           newOIDRegistry(),
+		maint:          newMaintainer(),
+		quotaWarned:    make(map[string]bool),
+		cdc:            newChangeFeed(),
+		foreign:        newForeignCatalog(),
+		notifier:       newNotifier(),
+		advisory:       newAdvisoryLocks(),
+		accounts:       newAccounting(),
+		plans:          newPlanCache(),
+		functions:      make(map[string]map[string]*parser.Function),
 	}
 	s.ctx, s.cancel = context.WithCancel(context.Background())
 	return s
@@ -60,28 +326,63 @@ func (s *Server) Open() (err error) {
 	if _, err := os.Stat(s.DataDir); err != nil {
 		return err
 	}
+	if err := s.checkJournalModes(); err != nil {
+		return err
+	}
+	if err := s.loadInitScripts(); err != nil {
+		return err
+	}
 
-	s.ln, err = net.Listen("tcp", s.Addr)
-	if err != nil {
+	if s.MaxReads > 0 {
+		s.readSlots = make(chan struct{}, s.MaxReads)
+	}
+
+	if err := s.listen(); err != nil {
+		return err
+	}
+
+	if err := s.openSysDB(); err != nil {
+		s.closeListeners()
 		return err
 	}
 
-	s.g.Go(func() error {
-		if err := s.serve(); s.ctx.Err() != nil {
-			return err // return error unless context canceled
+	// Databases are opened once the lock is taken, connections wait to be accepted meanwhile.
+	if s.BeforeLock != nil {
+		if err := s.BeforeLock(); err != nil {
+			s.Close()
+			return err
 		}
-		return nil
-	})
+	}
+	if err := s.lockDataDir(); err != nil {
+		s.Close()
+		return err
+	}
+	s.ownUnixListener()
+
+	if s.MaintenanceInterval > 0 {
+		s.g.Go(s.runMaintenance)
+	}
+	if s.LockWaitWarning > 0 {
+		s.g.Go(s.watchLockWaits)
+	}
+
+	for _, ln := range s.listeners {
+		s.g.Go(func() error {
+			if err := s.serve(ln); s.ctx.Err() == nil && !s.shuttingDown.Load() {
+				return err // return error unless context canceled or shutting down
+			}
+			return nil
+		})
+	}
 	return nil
 }
 
 func (s *Server) Close() (err error) {
-	if s.ln != nil {
-		if e := s.ln.Close(); err == nil {
-			err = e
-		}
-	}
+	// Cancel first so the listener close isn't reported as an error.
 	s.cancel()
+	if e := s.closeListeners(); err == nil {
+		err = e
+	}
 
 	// Track and close all open connections.
 	if e := s.CloseClientConnections(); err == nil {
@@ -89,8 +390,25 @@ func (s *Server) Close() (err error) {
 	}
 
 	if err := s.g.Wait(); err != nil {
+		s.unlockDataDir()
 		return err
 	}
+
+	if e := s.closeMemoryDBs(); err == nil {
+		err = e
+	}
+
+	if s.sysdb != nil {
+		if e := s.sysdb.Close(); err == nil {
+			err = e
+		}
+		s.sysdb = nil
+	}
+
+	// Databases are closed, another server may open them.
+	if e := s.unlockDataDir(); err == nil {
+		err = e
+	}
 	return err
 }
 
@@ -111,21 +429,33 @@ func (s *Server) CloseClientConnections() (err error) {
 }
 
 // CloseClientConnection disconnects a Postgres connections.
-func (s *Server) CloseClientConnection(conn *Conn) (err error) {
+func (s *Server) CloseClientConnection(conn *Conn) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	delete(s.conns, conn)
-	return conn.Close()
+	err := conn.Close()
+	s.mu.Unlock()
+	s.cdc.releaseSlots(conn)
+	s.notifier.remove(conn)
+	s.advisory.release(conn, sessionLevel, true)
+
+	if e := s.refreshSysDB(s.ctx); err == nil && s.ctx.Err() == nil {
+		err = e
+	}
+	return err
 }
 
-func (s *Server) serve() error {
+func (s *Server) serve(ln *listener) error {
 	for {
-		c, err := s.ln.Accept()
+		c, err := ln.Accept()
 		if err != nil {
 			return err
 		}
 		conn := newConn(c)
+		conn.listener = ln
+		conn.maxStmts = s.MaxPreparedStmts
+		conn.trace.Store(s.Trace)
+		conn.pid = int32(s.connsTotal.Add(1))
+		conn.secretKey = rand.Uint32()
 
 		// Track live connections.
 		s.mu.Lock()
@@ -138,41 +468,76 @@ func (s *Server) serve() error {
 			defer s.CloseClientConnection(conn)
 
 			if err := s.serveConn(s.ctx, conn); err != nil && s.ctx.Err() == nil {
-				log.Printf("connection error, closing: %s", err)
+				log.Printf("connection error, closing: [%s] %s", conn.logPrefix(), err)
 				return nil
 			}
 
-			log.Printf("connection closed: %s", conn.RemoteAddr())
+			log.Printf("connection closed: %s", conn.logPrefix())
 			return nil
 		})
 	}
 }
 
 func (s *Server) serveConn(ctx context.Context, c *Conn) error {
-	if err := s.serveConnStartup(ctx, c); err != nil {
+	if err := s.serveConnStartup(ctx, c); err == errCancelRequest {
+		return nil
+	} else if err != nil {
 		return fmt.Errorf("startup: %w", err)
 	}
+	c.endStartup()
 
 	for {
 		msg, err := c.backend.Receive()
-		if err != nil {
+		if c.terminated.Load() {
+			return writeMessages(c, pgerror.Fatal(pgerror.AdminShutdown, "terminating connection due to administrator command").Response())
+		} else if err != nil {
 			return fmt.Errorf("receive message: %w", err)
 		}
 
-		log.Printf("[recv] %#v", msg)
+		c.tracef("[recv] %#v", msg)
 
 		switch msg := msg.(type) {
 		case *pgproto3.Query:
-			if err := s.handleQueryMessage(ctx, c, msg); err != nil {
+			err := s.handleQueryMessage(c.startQuery(ctx, msg.String), c, msg)
+			c.endQuery()
+			if err != nil {
 				return fmt.Errorf("query message: %w", err)
 			}
 
-		case *pgproto3.Parse:
-			if err := s.handleParseMessage(ctx, c, msg); err != nil {
-				return fmt.Errorf("parse message: %w", err)
+		case *pgproto3.Parse, *pgproto3.Bind, *pgproto3.Describe, *pgproto3.Execute, *pgproto3.Close:
+			if c.failed {
+				continue
+			}
+			if err := s.handleExtendedMessage(ctx, c, msg); err != nil {
+				c.failed = true
+				if err := writeMessages(c, s.errorResponse(err)); err != nil {
+					return fmt.Errorf("extended query message: %w", err)
+				}
+			}
+
+		case *pgproto3.Sync:
+			var buf []byte
+			if c.batch {
+				if err := s.endBatch(ctx, c); err != nil {
+					buf, _ = s.errorResponse(err).Encode(buf)
+				}
+				// The session is idle again once the transaction of the batch ends.
+				c.endQuery()
+			}
+			// Portals live until the end of their transaction, the implicit transaction
+			// ends on Sync, transaction blocks at COMMIT or ROLLBACK.
+			if c.xact == nil {
+				c.closePortals()
+			}
+			s.releaseXactLocks(c)
+			c.failed = false
+			buf = c.appendParamChanges(buf)
+			buf, _ = (&pgproto3.ReadyForQuery{TxStatus: c.txStatus()}).Encode(buf)
+			if _, err := c.Write(buf); err != nil {
+				return fmt.Errorf("sync message: %w", err)
 			}
 
-		case *pgproto3.Sync: // ignore
+		case *pgproto3.Flush: // replies are not buffered
 			continue
 
 		case *pgproto3.Terminate:
@@ -201,36 +566,166 @@ func (s *Server) serveConnStartup(ctx context.Context, c *Conn) error {
 			return fmt.Errorf("ssl request message: %w", err)
 		}
 		return nil
+	case *pgproto3.CancelRequest:
+		s.handleCancelRequestMessage(c, msg)
+		return errCancelRequest
 	default:
 		return fmt.Errorf("unexpected startup message: %#v", msg)
 	}
 }
 
 func (s *Server) handleStartupMessage(ctx context.Context, c *Conn, msg *pgproto3.StartupMessage) (err error) {
-	log.Printf("received startup message: %#v", msg)
+	c.tracef("[recv] %#v", msg)
 
 	// Validate
 	name := getParameter(msg.Parameters, "database")
 	if name == "" {
-		return writeMessages(c, &pgproto3.ErrorResponse{Message: "database required"})
+		return writeMessages(c, pgerror.Fatal(pgerror.ProtocolViolation, "database required").Response())
 	} else if strings.Contains(name, "..") {
-		return writeMessages(c, &pgproto3.ErrorResponse{Message: "invalid database name"})
+		return writeMessages(c, pgerror.Fatal(pgerror.InvalidCatalogName, "invalid database name").Response())
+	} else if c.listener != nil && !c.listener.allows(name) {
+		return writeMessages(c, pgerror.Fatal(pgerror.InvalidAuthorization, fmt.Sprintf("database %q is not available on this listener", name)).Response())
+	}
+	switch replication := getParameter(msg.Parameters, "replication"); {
+	case replication == "database":
+		c.replication = true
+	case replication != "" && isOn(replication):
+		return writeMessages(c, pgerror.Fatal(pgerror.FeatureNotSupported, "physical replication is not supported").Response())
+	}
+	if err := c.initParams(msg.Parameters, s.sessionDefaults()); err != nil {
+		return writeMessages(c, startupError(err))
+	}
+	c.setClient(msg.Parameters)
+
+	dsn := s.databasePath(name)
+	memory := isMemoryDatabase(msg.Parameters)
+	if memory {
+		if dsn, err = s.openMemoryDB(ctx, name); err != nil {
+			return writeMessages(c, startupError(err))
+		}
 	}
 
 	// Open SQL database & attach to the connection.
-	if c.db, err = sql.Open(sqlite.DriverName, filepath.Join(s.DataDir, name)); err != nil {
+	c.dsn = dsn
+	if c.db, err = sql.Open(sqlite.DriverName, dsn); err != nil {
 		return err
 	}
 
-	return writeMessages(c,
-		&pgproto3.AuthenticationOk{},
-		&pgproto3.ParameterStatus{Name: "server_version", Value: ServerVersion},
-		&pgproto3.ReadyForQuery{TxStatus: 'I'},
-	)
+	// The name is read by system schema refreshes of other connections.
+	s.mu.Lock()
+	c.name = name
+	s.mu.Unlock()
+
+	// Each client gets a single dedicated SQLite connection, so session state
+	// such as TEMP tables, open transactions and cursors is scoped to the client.
+	// Read connections of parallel queries of file databases are opened on demand.
+	if memory {
+		c.db.SetMaxOpenConns(1)
+	} else {
+		c.db.SetMaxOpenConns(1 + max(s.ParallelReads, 0))
+	}
+	c.db.SetConnMaxLifetime(0)
+	c.db.SetConnMaxIdleTime(0)
+
+	// Sessions creating a database run the init scripts before other sessions connect.
+	created := false
+	if len(s.initScripts) > 0 && !memory {
+		s.initMu.Lock()
+		if created = isNewDatabase(dsn); created {
+			defer s.initMu.Unlock()
+		} else {
+			s.initMu.Unlock()
+		}
+	}
+
+	// Connecting creates the database file if it doesn't exist yet.
+	if c.conn, err = c.db.Conn(ctx); err != nil {
+		return writeMessages(c, startupError(err))
+	}
+	if err := s.initConn(ctx, c, c.conn); err != nil {
+		return writeMessages(c, startupError(err))
+	}
+	if s.ChangeNotifications {
+		if err := sqlite.RegisterChangeHooks(c.conn, c.recordChange, c.commitChanges, c.rollbackChanges); err != nil {
+			return writeMessages(c, startupError(err))
+		}
+	}
+	if err := s.attachSchemas(ctx, c); err != nil {
+		return writeMessages(c, startupError(err))
+	}
+	if err := s.loadFunctions(ctx, c); err != nil {
+		return writeMessages(c, startupError(err))
+	}
+	if !memory {
+		if err := s.applyJournalMode(ctx, c); err != nil {
+			return writeMessages(c, startupError(err))
+		}
+	}
+	if s.GroupCommitDelay > 0 && !memory {
+		if err := s.enableGroupCommit(ctx, c, dsn); err != nil {
+			return writeMessages(c, startupError(err))
+		}
+	}
+	s.registerRelations(ctx, c)
+	if created {
+		if err := s.runInitScripts(ctx, c); err != nil {
+			return writeMessages(c, startupError(err))
+		}
+	}
+	if err := s.refreshSysDB(ctx); err != nil {
+		log.Printf("system schema refresh: %s", err)
+	}
+
+	msgs := []pgproto3.Message{&pgproto3.AuthenticationOk{}}
+	msgs = append(msgs, c.paramStatus()...)
+	msgs = append(msgs, &pgproto3.BackendKeyData{ProcessID: uint32(c.pid), SecretKey: c.secretKey})
+	return writeMessages(c, append(msgs, &pgproto3.ReadyForQuery{TxStatus: 'I'})...)
+}
+
+// Registers the session functions on a connection of the session and attaches the system schema.
+func (s *Server) initConn(ctx context.Context, c *Conn, conn *sql.Conn) error {
+	if err := sqlite.RegisterTimeFuncs(conn, func() *time.Location { return c.location }); err != nil {
+		return err
+	}
+	if err := sqlite.RegisterSettingFuncs(conn, c.param); err != nil {
+		return err
+	}
+	if err := sqlite.RegisterSessionFuncs(conn, c.sessionInfo()); err != nil {
+		return err
+	}
+	if err := sqlite.RegisterTraceFunc(conn, s.TraceConnections); err != nil {
+		return err
+	}
+	if s.FaultInjection {
+		if err := sqlite.RegisterFaultFunc(conn, s.InjectReplicationFault); err != nil {
+			return err
+		}
+	}
+	if err := sqlite.RegisterBackendFuncs(conn, s.cancelBackend, s.terminateBackend); err != nil {
+		return err
+	}
+	relationName := func(oid int64) (string, bool) { return s.oids.name(c.name, uint32(oid)) }
+	if err := sqlite.RegisterSizeFuncs(conn, s.databaseSizeByName, relationName); err != nil {
+		return err
+	}
+	if err := sqlite.RegisterSchemaFuncs(conn, c.searchPath); err != nil {
+		return err
+	}
+	notify := func(channel, payload string) error { return s.notify(c, channel, payload) }
+	if err := sqlite.RegisterNotifyFunc(conn, notify); err != nil {
+		return err
+	}
+	if err := sqlite.RegisterAdvisoryLockFuncs(conn, sessionLocks{s, c}); err != nil {
+		return err
+	}
+	if s.sysdb != nil {
+		return sqlite.AttachSystemSchema(ctx, conn, s.sysdb.DSN())
+	}
+	return nil
 }
 
 func (s *Server) handleSSLRequestMessage(ctx context.Context, c *Conn, msg *pgproto3.SSLRequest) error {
-	log.Printf("received ssl request message: %#v", msg)
+	c.tracef("[recv] %#v", msg)
 	if _, err := c.Write([]byte("N")); err != nil {
 		return err
 	}
@@ -238,215 +733,241 @@ func (s *Server) handleSSLRequestMessage(ctx context.Context, c *Conn, msg *pgpr
 }
 
 func (s *Server) handleQueryMessage(ctx context.Context, c *Conn, msg *pgproto3.Query) error {
-	log.Printf("received query: %q", msg.String)
+	s.queriesTotal.Add(1)
 
 	// Respond to ping queries.
 	if strings.HasPrefix(msg.String, "--") && strings.HasSuffix(msg.String, "ping") {
 		writeMessages(c,
 			&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")},
-			&pgproto3.ReadyForQuery{TxStatus: 'I'})
+			&pgproto3.ReadyForQuery{TxStatus: c.txStatus()})
 		return nil
 	}
 
-	// Execute query against database.
-	rows, err := c.db.QueryContext(ctx, msg.String)
-	if err != nil {
-		return writeMessages(c,
-			&pgproto3.ErrorResponse{Message: err.Error()},
-			&pgproto3.ReadyForQuery{TxStatus: 'I'},
-		)
+	if c.replication {
+		if ok, err := s.handleReplicationCommand(ctx, c, msg.String); ok {
+			return err
+		}
 	}
-	defer rows.Close()
 
-	// Encode column header.
-	cols, err := rows.ColumnTypes()
-	if err != nil {
-		return fmt.Errorf("column types: %w", err)
+	s.refreshSysDBFor(ctx, msg.String)
+
+	stmts, parseErr := s.classifyQuery(c, msg.String)
+	if parseErr != nil {
+		// Not a PostgreSQL statement, let SQLite handle it as it is.
+		stmts = []parser.StmtClass{{Command: parser.CmdSelect, ReturnsRows: true, SQL: msg.String}}
+	}
+	if len(stmts) == 0 {
+		return writeMessages(c,
+			&pgproto3.EmptyQueryResponse{},
+			&pgproto3.ReadyForQuery{TxStatus: c.txStatus()},
+		)
 	}
-	buf, _ := toRowDescription(cols).Encode(nil)
 
-	// Iterate over each row and encode it to the wire protocol.
-	for rows.Next() {
-		row, err := scanRow(rows, cols)
+	// Execute each statement against database.
+	b := getBuffer()
+	buf := *b
+	defer func() { putBuffer(b, buf) }()
+	var err error
+	var reads []*readResult
+	stopReads := func() {}
+	defer func() { stopReads() }()
+	for i, stmt := range stmts {
+		start := time.Now()
+		if err = s.checkRateLimit(c, stmt); err != nil {
+			buf, _ = s.errorResponse(err).Encode(buf)
+			break
+		}
+		if len(reads) == 0 {
+			stopReads()
+			reads, stopReads = s.startReads(ctx, c, stmts[i:])
+		}
+		if len(reads) > 0 {
+			buf, err = reads[0].append(buf)
+			reads = reads[1:]
+		} else if buf, err = s.execStmt(ctx, c, stmt, buf); err == nil {
+			err = c.syncCommit(stmt)
+		}
+		if err == nil {
+			err = s.publishChanges(ctx, c, stmt)
+		}
+		c.traceStmt(stmt.SQL, start, err)
+		s.accounts.record(c, 1, time.Since(start))
 		if err != nil {
-			return fmt.Errorf("scan: %w", err)
+			// Neither PostgreSQL nor SQLite syntax, the PostgreSQL error locates the offending token.
+			if parseErr != nil && pgerror.Convert(err).Code == pgerror.SyntaxError {
+				err = parseErr
+			} else if parseErr == nil {
+				err = unsupportedStmt(stmt, err)
+			}
+			buf, _ = s.errorResponse(err).Encode(buf)
+			break
 		}
-		buf, _ = row.Encode(buf)
-	}
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("rows: %w", err)
 	}
 
-	// Mark command complete and ready for next query.
-	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}).Encode(buf)
-	buf, _ = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
-
+	// Mark ready for next query, the implicit transaction of the query ended.
+	s.releaseXactLocks(c)
+	buf = c.appendParamChanges(buf)
+	buf, _ = (&pgproto3.ReadyForQuery{TxStatus: c.txStatus()}).Encode(buf)
 	_, err = c.Write(buf)
 	return err
 }
 
-func toRowDescription(cols []*sql.ColumnType) *pgproto3.RowDescription {
-	var desc pgproto3.RowDescription
-	for _, col := range cols {
-		var typeOID uint32
-		dbType := col.DatabaseTypeName()
-		if pgColType, exists := sqlite.Typemap()[dbType]; exists {
-			typeOID = pgColType
-		} else {
-			typeOID = pgtype.TextOID
-		}
-
-		typeSize, ok := col.Length()
-		if !ok {
-			typeSize = -1
-		}
-
-		desc.Fields = append(desc.Fields, pgproto3.FieldDescription{
-			Name:                 []byte(col.Name()),
-			TableOID:             0,
-			TableAttributeNumber: 0,
-			DataTypeOID:          typeOID,
-			DataTypeSize:         int16(typeSize),
-			TypeModifier:         -1,
-			Format:               0,
-		})
+// execStmt executes a single classified statement and appends the encoded results to buf.
+func (s *Server) execStmt(ctx context.Context, c *Conn, stmt parser.StmtClass, buf []byte) ([]byte, error) {
+	if ok, err := s.execSessionStmt(ctx, c, stmt); err != nil {
+		return buf, err
+	} else if ok {
+		buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(0))}).Encode(buf)
+		return buf, nil
 	}
-	return &desc
-}
-
-func scanRow(rows *sql.Rows, cols []*sql.ColumnType) (*pgproto3.DataRow, error) {
-	refs := make([]interface{}, len(cols))
-	values := make([]interface{}, len(cols))
-	for i := range refs {
-		refs[i] = &values[i]
+	// Executed statements are checked once prepared, as by Execute messages.
+	if buf, ok, err := s.execPreparedStmt(ctx, c, stmt, buf); err != nil || ok {
+		return buf, err
 	}
-
-	// Scan from SQLite database.
-	if err := rows.Scan(refs...); err != nil {
-		return nil, fmt.Errorf("scan: %w", err)
+	if err := c.checkReadOnly(stmt); err != nil {
+		return buf, err
 	}
-
-	// Convert to TEXT values to return over Postgres wire protocol.
-	row := pgproto3.DataRow{Values: make([][]byte, len(values))}
-	for i := range values {
-		row.Values[i] = []byte(fmt.Sprint(values[i]))
+	var err error
+	if stmt.SQL, err = s.qualifyRelations(ctx, c, stmt.SQL); err != nil {
+		return buf, err
 	}
-	return &row, nil
-}
-
-func (s *Server) handleParseMessage(ctx context.Context, c *Conn, pmsg *pgproto3.Parse) error {
-	// Rewrite system-information queries so they're tolerable by SQLite.
-	query := parser.RewriteQuery(pmsg.Query)
-
-	if pmsg.Query != query {
-		log.Printf("query rewrite: %s", query)
+	if stmt.SQL, err = s.inlineFunctions(ctx, c, stmt.SQL); err != nil {
+		return buf, err
 	}
-
-	result, err := parser.Parse(query)
-	if err != nil {
-		return err
+	if buf, ok, err := s.execCursorStmt(ctx, c, stmt, nil, buf); err != nil || ok {
+		return buf, err
 	}
-	// Extract query params if any
-	var paramTypes []uint32
-	for idx := range result {
-		colTypes, err := sqlite.LookupTypeInfo(ctx, c.db, result[idx].Args, result[idx].Tables)
-		if err != nil {
-			return err
-		}
-		paramTypes = append(paramTypes, colTypes...)
+	if buf, ok, err := s.execForeignStmt(ctx, c, stmt, buf); err != nil || ok {
+		return buf, err
+	}
+	if buf, ok, err := s.execSchemaStmt(ctx, c, stmt, buf); err != nil || ok {
+		return buf, err
+	}
+	if buf, ok, err := s.execDatabaseStmt(ctx, c, stmt, buf); err != nil || ok {
+		return buf, err
 	}
 
-	// Prepare the query.
-	stmt, err := c.db.PrepareContext(ctx, pmsg.Query)
+	if err := s.checkQuota(c, stmt); err != nil {
+		return buf, err
+	}
+	if err := s.captureChanges(ctx, c); err != nil {
+		return buf, err
+	}
+	if buf, ok, err := s.execMatViewStmt(ctx, c, stmt, buf); err != nil || ok {
+		return buf, err
+	}
+	if buf, ok, err := s.execFunctionStmt(ctx, c, stmt, nil, buf); err != nil || ok {
+		return buf, err
+	}
+	if buf, ok, err := s.execDoStmt(ctx, c, stmt, buf); err != nil || ok {
+		return buf, err
+	}
+	if buf, ok, err := s.execCopyStmt(ctx, c, stmt, buf); err != nil || ok {
+		return buf, err
+	}
+	text, err := translateDDL(ctx, c, stmt)
 	if err != nil {
-		return fmt.Errorf("prepare: %w", err)
+		return buf, err
+	}
+	query := s.rewriteQuery(c, text)
+	if trackUsage(stmt) || changesPlans(stmt) {
+		s.usage.record(ctx, c, stmt, s.fingerprint(c, stmt.SQL), query, nil)
 	}
 
-	var rows *sql.Rows
-	var cols []*sql.ColumnType
-	var binds []interface{}
-	exec := func() (err error) {
-		if rows != nil {
-			return nil
+	if !stmt.ReturnsRows {
+		viewRows, isView, err := s.viewRowsAffected(ctx, c, stmt, nil)
+		if err != nil {
+			return buf, err
 		}
-		if rows, err = stmt.QueryContext(ctx, binds...); err != nil {
-			return fmt.Errorf("query: %w", err)
+		var result sql.Result
+		if err := s.retry(ctx, c, stmt, func() (err error) {
+			result, err = c.conn.ExecContext(ctx, query)
+			return err
+		}); err != nil {
+			return buf, err
 		}
-		if cols, err = rows.ColumnTypes(); err != nil {
-			return fmt.Errorf("column types: %w", err)
+		if changesSchema(stmt) {
+			s.registerRelations(ctx, c)
+			s.plans.invalidate(c.name)
 		}
-		return nil
-	}
-
-	// LOOP:
-	var msgState pgproto3.Describe
-	for {
-		msg, err := c.backend.Receive()
-		if err != nil {
-			return fmt.Errorf("receive message during parse: %w", err)
+		if err := c.trackStmt(ctx, stmt, query, nil); err != nil {
+			return buf, err
 		}
+		affected, _ := result.RowsAffected()
+		if isView {
+			affected = viewRows
+		}
+		s.maint.record(c, stmt, affected)
+		c.rowsWritten.Add(affected)
+		buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(affected))}).Encode(buf)
+		return buf, nil
+	}
 
-		log.Printf("[recv(p)] %#v", msg)
-
-		switch msg := msg.(type) {
-		case *pgproto3.Bind:
-			binds = make([]interface{}, len(msg.Parameters))
-			for i := range msg.Parameters {
-				binds[i] = string(msg.Parameters[i])
-			}
-		case *pgproto3.Describe:
-			msgState = *msg
-			break
-
-		case *pgproto3.Execute:
-			// Bind received, create Row description.
-			if msgState.ObjectType == 0x50 && len(binds) != 0 {
-				if err := exec(); err != nil {
-					return fmt.Errorf("exec: %w", err)
-				}
-				buf, _ := toRowDescription(cols).Encode(nil)
-				if _, err := c.Write(buf); err != nil {
-					return err
-				}
-			}
+	release, err := s.acquireRead(ctx, c, stmt)
+	if err != nil {
+		return buf, err
+	}
+	defer release()
 
-			// TODO: Send pgproto3.ParseComplete?
-			var buf []byte
-			for rows.Next() {
-				row, err := scanRow(rows, cols)
-				if err != nil {
-					return fmt.Errorf("scan: %w", err)
-				}
-				buf, _ = row.Encode(buf)
-			}
-			if err := rows.Err(); err != nil {
-				return fmt.Errorf("rows: %w", err)
-			}
+	// Rows are buffered until the query completes, so retries start over from the same buffer.
+	start := len(buf)
+	err = s.retry(ctx, c, stmt, func() (err error) {
+		buf, err = s.queryRows(ctx, c, c.conn, stmt, query, buf[:start])
+		return err
+	})
+	if err != nil {
+		return buf, err
+	}
+	return buf, c.trackStmt(ctx, stmt, query, nil)
+}
 
-			// Mark command complete and ready for next query.
-			buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}).Encode(buf)
-			buf, _ = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(buf)
-			_, err := c.Write(buf)
-			msgState = pgproto3.Describe{}
+// queryRows executes a query returning rows on a connection of the session and appends the
+// encoded row description, rows and command completion to buf.
+func (s *Server) queryRows(ctx context.Context, c *Conn, conn *sql.Conn, stmt parser.StmtClass, query string, buf []byte) ([]byte, error) {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return buf, err
+	}
+	defer rows.Close()
 
-			if rows != nil {
-				rows.Close()
-			}
-			return err
+	// Encode column header.
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return buf, fmt.Errorf("column types: %w", err)
+	}
+	desc := toRowDescription(cols)
+	dates := dateColumns(cols)
+	if stmt.Command == parser.CmdSelect {
+		s.setFieldOrigins(c, stmt.SQL, desc)
+	}
+	buf, _ = desc.Encode(buf)
 
-		case *pgproto3.Sync:
-			if (msgState != pgproto3.Describe{}) && (msgState.ObjectType == 0x53) {
-				writeMessages(c,
-					&pgproto3.ParseComplete{},
-					&pgproto3.ParameterDescription{ParameterOIDs: paramTypes},
-					//desc,
-					&pgproto3.ReadyForQuery{TxStatus: 'I'})
-			}
+	// Iterate over each row and encode it to the wire protocol.
+	var count int64
+	var truncated bool
+	enc := newRowEncoder(desc.Fields, dates, c.location)
+	for rows.Next() {
+		if truncated, err = c.rowLimitReached(count); err != nil {
+			return buf, err
+		} else if truncated {
 			break
-		default:
-			return fmt.Errorf("unexpected message type during parse: %#v", msg)
 		}
+		if buf, err = enc.appendRow(buf, rows); err != nil {
+			return buf, err
+		}
+		count++
+	}
+	c.rowsRead.Add(count)
+	if err := rows.Err(); err != nil {
+		return buf, fmt.Errorf("rows: %w", err)
+	}
+	if truncated {
+		buf = c.appendTruncated(buf)
 	}
+
+	// Mark command complete.
+	buf, _ = (&pgproto3.CommandComplete{CommandTag: []byte(stmt.Tag(count))}).Encode(buf)
+	return buf, nil
 }
 
 func (s *Server) execSetQuery(ctx context.Context, c *Conn, query string) error {
@@ -459,11 +980,27 @@ func (s *Server) execSetQuery(ctx context.Context, c *Conn, query string) error
 func newConn(conn net.Conn) *Conn {
 	return &Conn{
 		Conn:    conn,
+		started: time.Now(),
 		backend: pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn),
+		stmts:   make(map[string]*preparedStmt),
+		portals: make(map[string]*portal),
+		cursors: make(map[string]*cursor),
 	}
 }
 
 func (c *Conn) Close() (err error) {
+	// Open cursors and suspended portals hold the session connection.
+	c.closeCursors()
+	c.closePortals()
+	c.closeForeignConns()
+	for _, conn := range c.readConns {
+		conn.Close()
+	}
+	if c.conn != nil {
+		if e := c.conn.Close(); err == nil {
+			err = e
+		}
+	}
 	if c.db != nil {
 		if e := c.db.Close(); err == nil {
 			err = e
@@ -476,6 +1013,13 @@ func (c *Conn) Close() (err error) {
 	return err
 }
 
+// Returns the error response of a failed startup, the session is aborted.
+func startupError(err error) *pgproto3.ErrorResponse {
+	e := *pgerror.Convert(err)
+	e.Severity = pgerror.SeverityFatal
+	return e.Response()
+}
+
 func getParameter(m map[string]string, k string) string {
 	if m == nil {
 		return ""
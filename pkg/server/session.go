@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Executes session statements that are handled by the server rather than SQLite.
+// Returns false if the statement is not a session statement.
+func (s *Server) execSessionStmt(ctx context.Context, c *Conn, stmt parser.StmtClass) (bool, error) {
+	switch stmt.Command {
+	case parser.CmdBegin, parser.CmdStart:
+		return true, c.begin(ctx, stmt)
+	case parser.CmdSet:
+		// As in PostgreSQL, SET LOCAL has no effect outside of transaction blocks.
+		if stmt.Local && c.xact == nil {
+			return true, nil
+		}
+		if stmt.Name != "" {
+			c.saveParam(stmt.Name)
+		}
+		switch {
+		case stmt.Name == "":
+			// Multiple settings are ignored.
+		case stmt.Value == "":
+			c.resetParam(stmt.Name)
+		default:
+			if err := c.setParam(stmt.Name, stmt.Value); err != nil {
+				return true, err
+			}
+		}
+		if stmt.Name != "" && !stmt.Local {
+			c.keepParam(stmt.Name)
+		}
+		// SET TRANSACTION and SET SESSION CHARACTERISTICS may set the isolation level
+		// along with the access mode.
+		if stmt.Isolation != "" {
+			name := "transaction_isolation"
+			if strings.HasPrefix(stmt.Name, "default_") {
+				name = "default_" + name
+			}
+			c.saveParam(name)
+			if err := c.setParam(name, stmt.Isolation); err != nil {
+				return true, err
+			}
+			c.keepParam(name)
+		}
+		return true, c.refreshAccessMode(ctx)
+	case parser.CmdReset:
+		if stmt.Name == "" {
+			for name := range c.params {
+				c.saveParam(name)
+			}
+			c.resetParams()
+			if c.xact != nil {
+				for name := range c.xact.params {
+					c.keepParam(name)
+				}
+			}
+		} else {
+			c.saveParam(stmt.Name)
+			c.resetParam(stmt.Name)
+			c.keepParam(stmt.Name)
+		}
+		return true, c.refreshAccessMode(ctx)
+	case parser.CmdDeallocate:
+		if _, ok := c.stmts[stmt.Name]; !ok {
+			return true, pgerror.Newf(pgerror.InvalidSQLStatementName, "prepared statement %q does not exist", stmt.Name)
+		}
+		c.closeStmt(stmt.Name)
+	case parser.CmdDeallocateAll:
+		c.closeAllStmts()
+	case "DISCARD PLANS", "DISCARD SEQUENCES":
+		// No query plans or sequence values are cached by the server.
+	case parser.CmdDiscardTemp:
+		// Capture triggers would fail once their temporary table is dropped.
+		if err := c.stopCapture(ctx); err != nil {
+			return true, err
+		}
+		return true, sqlite.DropTempObjects(ctx, c.conn)
+	case parser.CmdDiscardAll:
+		s.notifier.unlisten(c, "")
+		// As pg_advisory_unlock_all, the next client of a pooled connection holds no locks.
+		s.advisory.release(c, sessionLevel, true)
+		return true, c.discardAll(ctx)
+	case parser.CmdListen, parser.CmdUnlisten, parser.CmdNotify:
+		return s.execNotifyStmt(c, stmt)
+	case parser.CmdPrepareTransaction, parser.CmdCommitPrepared, parser.CmdRollbackPrepared:
+		return true, s.execTwoPhaseStmt(ctx, c, stmt)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// Resets the session to its initial state, so the connection can be reused by
+// another client behind a connection pooler.
+func (c *Conn) discardAll(ctx context.Context) error {
+	c.closeCursors()
+	inTx, err := sqlite.InTransaction(c.conn)
+	if err != nil {
+		return err
+	} else if inTx {
+		if _, err := c.conn.ExecContext(ctx, "ROLLBACK"); err != nil {
+			return err
+		}
+	}
+
+	c.closePortals()
+	c.closeAllStmts()
+	c.resetParams()
+	c.xact = nil
+	c.notifyMu.Lock()
+	c.notifications = nil
+	c.notifyMu.Unlock()
+	if err := c.refreshAccessMode(ctx); err != nil {
+		return err
+	}
+	if err := c.stopCapture(ctx); err != nil {
+		return err
+	}
+	return sqlite.DropTempObjects(ctx, c.conn)
+}
+
+// Returns the transaction status of ReadyForQuery messages, 'T' in transaction blocks.
+// Batches of the extended protocol are implicit transactions, the session is idle in them.
+func (c *Conn) txStatus() byte {
+	if c.conn == nil || c.batch {
+		return 'I'
+	}
+	if inTx, err := sqlite.InTransaction(c.conn); err == nil && inTx {
+		return 'T'
+	}
+	return 'I'
+}
+
+// Runs fn in a savepoint of the session connection, its changes are rolled back if it
+// fails. Outside of transactions, the savepoint is a transaction of its own.
+func (c *Conn) atomically(ctx context.Context, fn func() error) (err error) {
+	if _, err := c.conn.ExecContext(ctx, "SAVEPOINT kqlite_atomic"); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			c.conn.ExecContext(ctx, "ROLLBACK TO kqlite_atomic")
+		}
+		if _, e := c.conn.ExecContext(ctx, "RELEASE kqlite_atomic"); err == nil {
+			err = e
+		}
+	}()
+	return fn()
+}
+
+// Starts a transaction block. SQLite transactions are deferred: they read the snapshot of
+// the database as of their first statement until they end, unaffected by the transactions
+// committed meanwhile, as REPEATABLE READ transactions of PostgreSQL do. The transaction
+// is read-only if BEGIN or the session default sets it so.
+//
+// Whatever their isolation level, transactions are serializable: SQLite has a single
+// writer, and deferred transactions writing after other transactions committed since
+// their snapshot fail with a serialization failure. SERIALIZABLE read-write transactions
+// take the write lock of the database as they start instead, they wait for the other
+// writers but never fail on their changes.
+func (c *Conn) begin(ctx context.Context, stmt parser.StmtClass) error {
+	if _, err := c.conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	c.xact = &transaction{}
+	if stmt.Name != "" {
+		c.params["transaction_read_only"] = stmt.Value
+	}
+	if stmt.Isolation != "" {
+		if err := c.setParam("transaction_isolation", stmt.Isolation); err != nil {
+			return err
+		}
+	}
+	return c.refreshAccessMode(ctx)
+}
+
+// Applies the access mode and the isolation level of the session: read-only transaction
+// blocks execute their statements with the query_only pragma, so SQLite rejects their
+// writes. Outside of transaction blocks, they are the defaults of the session.
+func (c *Conn) refreshAccessMode(ctx context.Context) error {
+	inTx, err := sqlite.InTransaction(c.conn)
+	if err != nil {
+		return err
+	}
+	if !inTx {
+		c.params["transaction_read_only"] = c.param("default_transaction_read_only")
+		c.params["transaction_isolation"] = c.param("default_transaction_isolation")
+	} else if err := c.refreshLockingMode(ctx); err != nil {
+		return err
+	}
+	queryOnly := inTx && isOn(c.param("transaction_read_only"))
+	if queryOnly == c.queryOnly {
+		return nil
+	}
+	if _, err := c.conn.ExecContext(ctx, fmt.Sprintf("PRAGMA query_only = %t", queryOnly)); err != nil {
+		return err
+	}
+	c.queryOnly = queryOnly
+	return nil
+}
+
+// Restarts the transaction block of the session to take the write lock of the database as
+// it starts if it's a SERIALIZABLE read-write transaction, or to release it otherwise.
+// Transaction blocks can only be restarted until their first query.
+func (c *Conn) refreshLockingMode(ctx context.Context) error {
+	immediate := c.param("transaction_isolation") == "serializable" && !isOn(c.param("transaction_read_only"))
+	if c.xact == nil || c.xact.queried || c.xact.immediate == immediate {
+		return nil
+	}
+	if _, err := c.conn.ExecContext(ctx, "ROLLBACK"); err != nil {
+		return err
+	}
+	begin := "BEGIN"
+	if immediate {
+		begin = "BEGIN IMMEDIATE"
+	}
+	if _, err := c.conn.ExecContext(ctx, begin); err != nil {
+		// The transaction block goes on as a deferred transaction.
+		c.xact.immediate = false
+		if _, e := c.conn.ExecContext(ctx, "BEGIN"); e != nil {
+			return e
+		}
+		return err
+	}
+	c.xact.immediate = immediate
+	if immediate {
+		c.holdWriteLock()
+	} else {
+		c.releaseWriteLock()
+	}
+	return nil
+}
+
+// Rejects the statements writing to the database in read-only transactions, and in
+// read-only sessions outside of transaction blocks. Once a statement is checked, the
+// transaction block can't be set read-write, as in PostgreSQL.
+func (c *Conn) checkReadOnly(stmt parser.StmtClass) error {
+	if c.xact != nil {
+		c.xact.queried = true
+	}
+	if stmt.ReadOnly || !isOn(c.param("transaction_read_only")) {
+		return nil
+	}
+	return pgerror.Newf(pgerror.ReadOnlySQLTransaction, "cannot execute %s in a read-only transaction", stmt.Command)
+}
@@ -0,0 +1,239 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Session scope", Ordered, func() {
+	var conn, other *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "session.db")
+		other = connect(ctx, "session.db")
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+		Expect(other.Close(ctx)).To(Succeed())
+	})
+
+	It("Scopes temporary tables to the connection", func() {
+		_, err := conn.Exec(ctx, `CREATE TEMP TABLE scratch (id INTEGER PRIMARY KEY, name TEXT)`)
+		Expect(err).NotTo(HaveOccurred())
+		for _, name := range []string{"a", "b", "c"} {
+			_, err = conn.Exec(ctx, `INSERT INTO scratch (name) VALUES ($1)`, name)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		var count string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM scratch`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal("3"))
+
+		_, err = other.Exec(ctx, `SELECT count(*) FROM scratch`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Keeps transactions on the same connection", func() {
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		tx, err := conn.Begin(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tx.Exec(ctx, `INSERT INTO items (id) VALUES (1)`)
+		Expect(err).NotTo(HaveOccurred())
+		// ReadyForQuery reports the transaction block, as clients such as lib/pq check.
+		Expect(conn.PgConn().TxStatus()).To(Equal(byte('T')))
+		Expect(tx.Rollback(ctx)).To(Succeed())
+		Expect(conn.PgConn().TxStatus()).To(Equal(byte('I')))
+
+		var count string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM items`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal("0"))
+	})
+
+	It("Resets the session with DISCARD ALL", func() {
+		pgConn := conn.PgConn()
+		_, err := pgConn.Prepare(ctx, "pooled", `SELECT 1`, nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = pgConn.Exec(ctx, `CREATE TEMP VIEW recent AS SELECT * FROM scratch; BEGIN`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = pgConn.Exec(ctx, `DISCARD ALL`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = pgConn.Exec(ctx, `SELECT * FROM scratch`).ReadAll()
+		Expect(err).To(HaveOccurred())
+		_, err = pgConn.Exec(ctx, `DEALLOCATE pooled`).ReadAll()
+		Expect(err).To(MatchError(ContainSubstring(`prepared statement "pooled" does not exist`)))
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("26000"))
+
+		// The transaction was rolled back, a new one can be started.
+		_, err = pgConn.Exec(ctx, `BEGIN; COMMIT`).ReadAll()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Reads a stable snapshot in transactions", func() {
+		reader, writer := connect(ctx, "snapshot.db"), connect(ctx, "snapshot.db")
+		defer reader.Close(ctx)
+		defer writer.Close(ctx)
+		_, err := writer.Exec(ctx, `PRAGMA journal_mode = WAL`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = writer.Exec(ctx, `CREATE TABLE events (id INTEGER PRIMARY KEY)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		count := func() string {
+			var n string
+			Expect(reader.QueryRow(ctx, `SELECT count(*) FROM events`).Scan(&n)).To(Succeed())
+			return n
+		}
+		_, err = reader.Exec(ctx, `START TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count()).To(Equal("0"))
+		_, err = writer.Exec(ctx, `INSERT INTO events (id) VALUES (1), (2)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count()).To(Equal("0"))
+		_, err = reader.Exec(ctx, `COMMIT`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count()).To(Equal("2"))
+	})
+
+	It("Rejects writes of read-only transactions", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `BEGIN READ ONLY`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO items (id) VALUES (1)`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("25006"))
+		var readOnly string
+		Expect(conn.QueryRow(ctx, `SHOW transaction_read_only`).Scan(&readOnly)).To(Succeed())
+		Expect(readOnly).To(Equal("on"))
+		_, err = conn.Exec(ctx, `ROLLBACK`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO items (id) VALUES (1)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Transactions of read-only sessions are read-only unless started READ WRITE.
+		_, err = conn.Exec(ctx, `SET default_transaction_read_only = on`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `DELETE FROM items`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("25006"))
+		_, err = conn.Exec(ctx, `BEGIN READ WRITE; DELETE FROM items; COMMIT`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `RESET default_transaction_read_only`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO items (id) VALUES (1)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Reports the isolation levels of transactions", func() {
+		show := func(name string) string {
+			var value string
+			Expect(conn.QueryRow(ctx, `SHOW `+name).Scan(&value)).To(Succeed())
+			return value
+		}
+		Expect(show("transaction_isolation")).To(Equal("read committed"))
+		_, err := conn.Exec(ctx, `BEGIN ISOLATION LEVEL SERIALIZABLE`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(show("transaction_isolation")).To(Equal("serializable"))
+		_, err = conn.Exec(ctx, `COMMIT`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(show("transaction_isolation")).To(Equal("read committed"))
+
+		_, err = conn.Exec(ctx, `SET SESSION CHARACTERISTICS AS TRANSACTION ISOLATION LEVEL REPEATABLE READ`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(show("default_transaction_isolation")).To(Equal("repeatable read"))
+		_, err = conn.Exec(ctx, `BEGIN; SET TRANSACTION ISOLATION LEVEL READ UNCOMMITTED`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(show("transaction_isolation")).To(Equal("read uncommitted"))
+
+		// Once the transaction queried the database, it keeps its isolation level.
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `SET TRANSACTION ISOLATION LEVEL SERIALIZABLE`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("25001"))
+		_, err = conn.Exec(ctx, `ROLLBACK`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(show("transaction_isolation")).To(Equal("repeatable read"))
+
+		_, err = conn.Exec(ctx, `SET default_transaction_isolation = 'snapshot'`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("22023"))
+		_, err = conn.Exec(ctx, `RESET default_transaction_isolation`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Serializes the transactions writing to databases", func() {
+		first, second := connect(ctx, "serializable.db"), connect(ctx, "serializable.db")
+		defer first.Close(ctx)
+		defer second.Close(ctx)
+		_, err := first.Exec(ctx, `PRAGMA journal_mode = WAL`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = first.Exec(ctx, `CREATE TABLE counters (id INTEGER PRIMARY KEY, n INTEGER)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = first.Exec(ctx, `INSERT INTO counters VALUES (1, 0)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Transactions writing after others committed since their snapshot fail.
+		var pgErr *pgconn.PgError
+		_, err = first.Exec(ctx, `BEGIN ISOLATION LEVEL REPEATABLE READ`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = first.Exec(ctx, `SELECT n FROM counters`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = second.Exec(ctx, `UPDATE counters SET n = n + 1`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = first.Exec(ctx, `UPDATE counters SET n = n + 1`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("40001"))
+		_, err = first.Exec(ctx, `ROLLBACK`)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Serializable transactions hold the write lock of the database from their start.
+		_, err = first.Exec(ctx, `BEGIN ISOLATION LEVEL SERIALIZABLE`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = second.Exec(ctx, `PRAGMA busy_timeout = 10`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = second.Exec(ctx, `UPDATE counters SET n = n + 1`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("55P03"))
+		_, err = first.Exec(ctx, `UPDATE counters SET n = n + 1; COMMIT`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var n string
+		Expect(second.QueryRow(ctx, `SELECT n FROM counters`).Scan(&n)).To(Succeed())
+		Expect(n).To(Equal("2"))
+	})
+
+	It("Sets the access mode of transactions before their first query", func() {
+		var pgErr *pgconn.PgError
+		_, err := conn.Exec(ctx, `BEGIN; SELECT 1; SET TRANSACTION READ ONLY`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `UPDATE items SET id = 2`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("25006"))
+		_, err = conn.Exec(ctx, `ROLLBACK`)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = conn.Exec(ctx, `BEGIN READ ONLY; SET TRANSACTION READ WRITE; UPDATE items SET id = 2; ROLLBACK`)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = conn.Exec(ctx, `BEGIN READ ONLY`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SELECT count(*) FROM items`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SET TRANSACTION READ WRITE`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("25001"))
+		_, err = conn.Exec(ctx, `ROLLBACK`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
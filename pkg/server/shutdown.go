@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Interval between checks of the sessions left while shutting down.
+const shutdownInterval = 50 * time.Millisecond
+
+// Shutdown stops accepting connections, ends sessions once they're idle outside of
+// transaction blocks and closes the server once no session is left. Queries and
+// transactions in flight complete, the sessions still connected when the context is
+// done are closed along with the server. Connections waiting to be accepted are left
+// to the process the listeners were passed to, if any.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	if err := s.closeListeners(); err != nil {
+		log.Printf("shutdown: close listeners: %s", err)
+	}
+
+	ticker := time.NewTicker(shutdownInterval)
+	defer ticker.Stop()
+	for n := s.endIdleSessions(); n > 0; n = s.endIdleSessions() {
+		select {
+		case <-ctx.Done():
+			log.Printf("shutdown: closing %d sessions", n)
+			return s.Close()
+		case <-ticker.C:
+		}
+	}
+	return s.Close()
+}
+
+// Terminates the idle sessions, as pg_terminate_backend does, and returns the number of
+// sessions left.
+func (s *Server) endIdleSessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		if c.idle() && !c.terminated.Load() {
+			c.terminated.Store(true)
+			c.SetReadDeadline(time.Now())
+		}
+	}
+	return len(s.conns)
+}
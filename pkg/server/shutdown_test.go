@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Shutdown", func() {
+	ctx := context.Background()
+
+	It("Locks the data directory", func() {
+		dataDir := GinkgoT().TempDir()
+		first := server.NewServer()
+		first.Addr = "127.0.0.1:0"
+		first.DataDir = dataDir
+		Expect(first.Open()).To(Succeed())
+
+		second := server.NewServer()
+		second.Addr = "127.0.0.1:0"
+		second.DataDir = dataDir
+		Expect(second.Open()).To(MatchError(ContainSubstring("is locked by process")))
+
+		// The next server waits for the lock.
+		second = server.NewServer()
+		second.Addr = "127.0.0.1:0"
+		second.DataDir = dataDir
+		second.DataDirLockTimeout = 10 * time.Second
+		// The hook runs before the wait, the first server is closed once it ran.
+		waiting := make(chan struct{})
+		second.BeforeLock = func() error {
+			close(waiting)
+			return nil
+		}
+		go func() {
+			defer GinkgoRecover()
+			Eventually(waiting).Should(BeClosed())
+			Expect(first.Close()).To(Succeed())
+		}()
+		Expect(second.Open()).To(Succeed())
+		Expect(second.Close()).To(Succeed())
+	})
+
+	It("Drains sessions and hands off the listeners", func() {
+		dataDir := GinkgoT().TempDir()
+		old := server.NewServer()
+		old.Addr = "127.0.0.1:0"
+		old.DataDir = dataDir
+		Expect(old.Open()).To(Succeed())
+		url := fmt.Sprintf("postgres://%s/drain.db?sslmode=disable", old.ListenerAddr())
+
+		idle, err := pgx.Connect(ctx, url)
+		Expect(err).NotTo(HaveOccurred())
+		defer idle.Close(ctx)
+		busy, err := pgx.Connect(ctx, url)
+		Expect(err).NotTo(HaveOccurred())
+		defer busy.Close(ctx)
+		_, err = busy.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY)`)
+		Expect(err).NotTo(HaveOccurred())
+		tx, err := busy.Begin(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tx.Exec(ctx, `INSERT INTO items VALUES (1)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		files, err := old.ListenerFiles()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		ln, err := net.FileListener(files[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files[0].Close()).To(Succeed())
+
+		shutdown := make(chan error, 1)
+		go func() { shutdown <- old.Shutdown(ctx) }()
+
+		// Idle sessions are ended, transactions in flight complete.
+		Eventually(func() error {
+			_, err := idle.Exec(ctx, `SELECT 1`)
+			return err
+		}).Should(HaveOccurred())
+		Consistently(shutdown, 200*time.Millisecond).ShouldNot(Receive())
+		Expect(tx.Commit(ctx)).To(Succeed())
+		Eventually(shutdown, 5*time.Second).Should(Receive(BeNil()))
+
+		// Connections to the address are served by the server of the passed listener.
+		next := server.NewServer()
+		next.Listener = ln
+		next.DataDir = dataDir
+		Expect(next.Open()).To(Succeed())
+		defer func() { Expect(next.Close()).To(Succeed()) }()
+
+		conn, err := pgx.Connect(ctx, url)
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close(ctx)
+		var n string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM items`).Scan(&n)).To(Succeed())
+		Expect(n).To(Equal("1"))
+	})
+})
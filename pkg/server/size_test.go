@@ -0,0 +1,86 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Size functions", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	// Expressions are sent as text.
+	query := func(sql string, args ...interface{}) string {
+		var value string
+		Expect(conn.QueryRow(ctx, sql, args...).Scan(&value)).To(Succeed(), sql)
+		return value
+	}
+
+	BeforeAll(func() {
+		conn = connect(ctx, "size.db")
+		for _, stmt := range []string{
+			`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)`,
+			`CREATE INDEX items_name ON items (name)`,
+			`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000)
+				INSERT INTO items (id, name) SELECT i, 'item ' || i FROM n`,
+		} {
+			_, err := conn.Exec(ctx, stmt)
+			Expect(err).NotTo(HaveOccurred(), stmt)
+		}
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Renders sizes with units", func() {
+		Expect(query(`SELECT pg_size_pretty(10239)`)).To(Equal("10239 bytes"))
+		Expect(query(`SELECT pg_size_pretty(10240)`)).To(Equal("10 kB"))
+		Expect(query(`SELECT pg_size_pretty(1536000)`)).To(Equal("1500 kB"))
+		Expect(query(`SELECT pg_size_pretty(10485760)`)).To(Equal("10 MB"))
+		Expect(query(`SELECT pg_size_pretty(-10485760)`)).To(Equal("-10 MB"))
+		Expect(query(`SELECT pg_size_pretty(5497558138880)`)).To(Equal("5120 GB"))
+		Expect(query(`SELECT pg_size_pretty(10995116277760)`)).To(Equal("10 TB"))
+	})
+
+	It("Reports database sizes", func() {
+		Expect(query(`SELECT pg_database_size('size.db') > 0`)).To(Equal("1"))
+
+		_, err := conn.Exec(ctx, `SELECT pg_database_size('missing.db')`)
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("3D000"))
+		Expect(pgErr.Message).To(ContainSubstring(`database "missing.db" does not exist`))
+	})
+
+	It("Reports relation sizes", func() {
+		if query(`SELECT sqlite_compileoption_used('ENABLE_DBSTAT_VTAB')`) != "1" {
+			Skip("SQLite is built without dbstat, build with CGO_CFLAGS=-DSQLITE_ENABLE_DBSTAT_VTAB")
+		}
+
+		table := query(`SELECT pg_relation_size('items')`)
+		Expect(table).NotTo(Equal("0"))
+		Expect(query(`SELECT pg_table_size('public.items')`)).To(Equal(table))
+		Expect(query(`SELECT pg_relation_size('items', 'fsm')`)).To(Equal("0"))
+
+		index := query(`SELECT pg_relation_size('items_name')`)
+		Expect(index).NotTo(Equal("0"))
+		Expect(query(`SELECT pg_indexes_size('items')`)).To(Equal(index))
+		Expect(query(`SELECT pg_total_relation_size('items') = pg_relation_size('items') + pg_relation_size('items_name')`)).To(Equal("1"))
+
+		// Relations are also named by OID.
+		var oid string
+		Expect(conn.QueryRow(ctx, `SELECT oid FROM kqlite.relations WHERE database = 'size.db' AND relname = 'items'`).Scan(&oid)).To(Succeed())
+		Expect(query(`SELECT pg_relation_size($1)`, oid)).To(Equal(table))
+
+		_, err := conn.Exec(ctx, `SELECT pg_relation_size('missing')`)
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("42P01"))
+	})
+})
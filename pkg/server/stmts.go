@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+)
+
+// Approximate memory footprint of a prepared statement in bytes.
+func (stmt *preparedStmt) size() int64 {
+	size := len(stmt.name) + len(stmt.query) + len(stmt.class.SQL) + 4*len(stmt.paramOIDs)
+	if stmt.desc != nil {
+		for _, field := range stmt.desc.Fields {
+			size += len(field.Name) + 20
+		}
+	}
+	for _, table := range stmt.class.Tables {
+		size += len(table)
+	}
+	return int64(size)
+}
+
+// Adds a prepared statement to the connection, the unnamed statement is replaced.
+// When the limit of named statements is reached the least recently used one is evicted.
+func (c *Conn) addStmt(stmt *preparedStmt) error {
+	if _, exists := c.stmts[stmt.name]; exists {
+		if stmt.name != "" {
+			return fmt.Errorf("prepared statement %q already exists", stmt.name)
+		}
+		c.closeStmt(stmt.name)
+	}
+
+	if stmt.name != "" && c.maxStmts > 0 && c.namedStmts() >= c.maxStmts {
+		c.evictStmt()
+	}
+
+	stmt.lastUsed = time.Now()
+	c.stmts[stmt.name] = stmt
+	c.stmtCount.Add(1)
+	c.stmtBytes.Add(stmt.size())
+	return nil
+}
+
+// Returns a prepared statement by name and marks it as used.
+func (c *Conn) getStmt(name string) (*preparedStmt, bool) {
+	stmt, ok := c.stmts[name]
+	if ok {
+		stmt.lastUsed = time.Now()
+	}
+	return stmt, ok
+}
+
+// Removes a prepared statement from the connection.
+func (c *Conn) closeStmt(name string) {
+	if stmt, ok := c.stmts[name]; ok {
+		stmt.closeHandle()
+		delete(c.stmts, name)
+		c.stmtCount.Add(-1)
+		c.stmtBytes.Add(-stmt.size())
+	}
+}
+
+// Removes all prepared statements from the connection.
+func (c *Conn) closeAllStmts() {
+	for name := range c.stmts {
+		c.closeStmt(name)
+	}
+}
+
+// Number of named prepared statements, the unnamed statement is not counted.
+func (c *Conn) namedStmts() int {
+	if _, ok := c.stmts[""]; ok {
+		return len(c.stmts) - 1
+	}
+	return len(c.stmts)
+}
+
+// Evicts the least recently used named prepared statement.
+func (c *Conn) evictStmt() {
+	var lru *preparedStmt
+	for name, stmt := range c.stmts {
+		if name != "" && (lru == nil || stmt.lastUsed.Before(lru.lastUsed)) {
+			lru = stmt
+		}
+	}
+	if lru != nil {
+		c.closeStmt(lru.name)
+		c.stmtEvictions.Add(1)
+	}
+}
+
+// Reports whether the query of the statement is a single SQLite statement, which can be
+// prepared once. Translated DDL statements may be several.
+func reusable(stmt parser.StmtClass) bool {
+	switch stmt.Command {
+	case parser.CmdSelect, parser.CmdInsert, parser.CmdUpdate, parser.CmdDelete:
+		return true
+	}
+	return false
+}
+
+// Returns the SQLite statement of a prepared statement, prepared on its first execution and
+// kept until the statement is closed, so SQLite parses its query once. Nil if the query is
+// executed as it is: queries of several statements, and closed statements of open portals.
+func (c *Conn) stmtHandle(ctx context.Context, stmt *preparedStmt) (*sql.Stmt, error) {
+	if stmt.handle != nil {
+		return stmt.handle, nil
+	}
+	if c.stmts[stmt.name] != stmt || stmt.remote != nil || !reusable(stmt.class) {
+		return nil, nil
+	}
+	handle, err := c.conn.PrepareContext(ctx, stmt.query)
+	if err != nil {
+		return nil, err
+	}
+	stmt.handle = handle
+	return handle, nil
+}
+
+// Closes the SQLite statement of a prepared statement, if it was prepared.
+func (stmt *preparedStmt) closeHandle() {
+	if stmt.handle != nil {
+		stmt.handle.Close()
+		stmt.handle = nil
+	}
+}
+
+// Executes a prepared statement through its SQLite statement.
+func (c *Conn) execPrepared(ctx context.Context, stmt *preparedStmt, args []interface{}) (sql.Result, error) {
+	handle, err := c.stmtHandle(ctx, stmt)
+	if err != nil {
+		return nil, err
+	} else if handle == nil {
+		return c.conn.ExecContext(ctx, stmt.query, args...)
+	}
+	return handle.ExecContext(ctx, args...)
+}
+
+// Queries a prepared statement through its SQLite statement.
+func (c *Conn) queryPrepared(ctx context.Context, stmt *preparedStmt, args []interface{}) (*sql.Rows, error) {
+	handle, err := c.stmtHandle(ctx, stmt)
+	if err != nil {
+		return nil, err
+	} else if handle == nil {
+		return c.conn.QueryContext(ctx, stmt.query, args...)
+	}
+	return handle.QueryContext(ctx, args...)
+}
@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Prepared statements limit", Ordered, func() {
+	var limited *server.Server
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		limited = openServer(func(s *server.Server) {
+			s.MaxPreparedStmts = 2
+		})
+		conn = connectTo(ctx, limited, "limited.db")
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Evicts the least recently used statement", func() {
+		for _, name := range []string{"one", "two"} {
+			_, err := conn.Prepare(ctx, name, fmt.Sprintf("SELECT '%s'", name))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		// Use the first statement so the second one is the least recently used.
+		var value string
+		Expect(conn.QueryRow(ctx, "one").Scan(&value)).To(Succeed())
+		Expect(value).To(Equal("one"))
+
+		_, err := conn.Prepare(ctx, "three", "SELECT 'three'")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(conn.QueryRow(ctx, "three").Scan(&value)).To(Succeed())
+		Expect(conn.QueryRow(ctx, "one").Scan(&value)).To(Succeed())
+		Expect(conn.QueryRow(ctx, "two").Scan(&value)).NotTo(Succeed())
+	})
+
+	It("Reports prepared statement statistics", func() {
+		var evicted int64
+		err := conn.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = 'prepared_statements_evicted'`).Scan(&evicted)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(evicted).To(BeNumerically(">=", 1))
+	})
+
+	It("Reports prepared statements of each session", func() {
+		config, err := pgx.ParseConfig(fmt.Sprintf("postgres://%s/limited.db?sslmode=disable", limited.ListenerAddr()))
+		Expect(err).NotTo(HaveOccurred())
+		config.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+		other, err := pgx.ConnectConfig(ctx, config)
+		Expect(err).NotTo(HaveOccurred())
+		defer other.Close(ctx)
+		_, err = other.Prepare(ctx, "only", "SELECT 'only'")
+		Expect(err).NotTo(HaveOccurred())
+
+		stats := func(pid uint32) (count, bytes, evicted int64) {
+			Expect(other.QueryRow(ctx, `SELECT prepared_statements, prepared_statements_bytes, prepared_statements_evicted
+				FROM pg_stat_activity WHERE pid = $1`, pid).Scan(&count, &bytes, &evicted)).To(Succeed())
+			return count, bytes, evicted
+		}
+		count, bytes, evicted := stats(other.PgConn().PID())
+		Expect(count).To(Equal(int64(1)))
+		Expect(bytes).To(BeNumerically(">", 0))
+		Expect(evicted).To(BeZero())
+
+		// Statements of the other session were evicted over the limit.
+		count, bytes, evicted = stats(conn.PgConn().PID())
+		Expect(count).To(BeNumerically("<=", limited.MaxPreparedStmts+1))
+		Expect(bytes).To(BeNumerically(">", 0))
+		Expect(evicted).To(BeNumerically(">=", 1))
+	})
+})
+
+var _ = Describe("Prepared statement reuse", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "reuse.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE counters (name TEXT PRIMARY KEY, value INTEGER NOT NULL)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Executes prepared statements repeatedly", func() {
+		_, err := conn.Prepare(ctx, "bump", `INSERT INTO counters (name, value) VALUES ($1, 1)
+			ON CONFLICT (name) DO UPDATE SET value = counters.value + 1`)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 50; i++ {
+			_, err := conn.Exec(ctx, "bump", fmt.Sprintf("counter %d", i%5))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		_, err = conn.Prepare(ctx, "get", `SELECT value FROM counters WHERE name = $1`)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 5; i++ {
+			var value int64
+			Expect(conn.QueryRow(ctx, "get", fmt.Sprintf("counter %d", i)).Scan(&value)).To(Succeed())
+			Expect(value).To(Equal(int64(10)))
+		}
+
+		// Statements see the schema changes made since they were first executed.
+		_, err = conn.Exec(ctx, `CREATE INDEX counters_value ON counters (value)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, "bump", "counter 0")
+		Expect(err).NotTo(HaveOccurred())
+		var value int64
+		Expect(conn.QueryRow(ctx, "get", "counter 0").Scan(&value)).To(Succeed())
+		Expect(value).To(Equal(int64(11)))
+	})
+
+	It("Prepares statements again once they're closed", func() {
+		Expect(conn.Deallocate(ctx, "get")).To(Succeed())
+		_, err := conn.Prepare(ctx, "get", `SELECT value + 100 FROM counters WHERE name = $1`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var value string
+		Expect(conn.QueryRow(ctx, "get", "counter 1").Scan(&value)).To(Succeed())
+		Expect(value).To(Equal("110"))
+	})
+})
@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var srv *server.Server
+
+func TestServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Server Suite")
+}
+
+var _ = BeforeSuite(func() {
+	srv = server.NewServer()
+	srv.Addr = "127.0.0.1:0"
+	srv.DataDir = GinkgoT().TempDir()
+	srv.PlanCacheSize = 1024
+	srv.FaultInjection = true
+	Expect(srv.Open()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	Expect(srv.Close()).To(Succeed())
+})
+
+// Connects to the test server database with the pgx driver.
+func connect(ctx context.Context, dbname string) *pgx.Conn {
+	return connectTo(ctx, srv, dbname)
+}
+
+// Connects to a database of another server with the pgx driver.
+func connectTo(ctx context.Context, s *server.Server, dbname string) *pgx.Conn {
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s/%s?sslmode=disable", s.ListenerAddr(), dbname))
+	Expect(err).NotTo(HaveOccurred())
+	return conn
+}
+
+// Opens a server other than the test server, on a random port with its own data directory.
+// configure sets the options of the server before it's opened, the server is closed
+// once the spec or container calling openServer ends.
+func openServer(configure func(s *server.Server)) *server.Server {
+	s := server.NewServer()
+	s.Addr = "127.0.0.1:0"
+	s.DataDir = GinkgoT().TempDir()
+	if configure != nil {
+		configure(s)
+	}
+	Expect(s.Open()).To(Succeed())
+	DeferCleanup(func() { Expect(s.Close()).To(Succeed()) })
+	return s
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/sysdb"
+)
+
+// Interval between refreshes of the system schema tables.
+const sysdbRefreshInterval = 5 * time.Second
+
+// Matches references to the system schema tables, pg_stat_activity and pg_locks are among them.
+var systemSchemaRegex = regexp.MustCompile(`(?i)\b(` + sqlite.SystemSchema + `\.|pg_stat_activity\b|pg_locks\b)`)
+
+// Header of all SQLite database files.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// Sequence of server identifiers in the process.
+var serverIDs atomic.Int64
+
+// Opens the system database and keeps it refreshed until the server is closed.
+func (s *Server) openSysDB() (err error) {
+	// Servers in the same process have separate system databases.
+	id := strconv.FormatInt(serverIDs.Add(1), 10)
+	if s.sysdb, err = sysdb.Open(s.ctx, id); err != nil {
+		return err
+	}
+
+	if err := s.sysdb.SetServerInfo(s.ctx, map[string]string{
+		"server_version": ServerVersion,
+		"data_dir":       s.DataDir,
+		"listen_addr":    s.ln.Addr().String(),
+		"unix_socket":    s.UnixSocketPath(),
+		"started_at":     time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		return err
+	}
+	if err := s.refreshSysDB(s.ctx); err != nil {
+		return err
+	}
+
+	s.g.Go(func() error {
+		ticker := time.NewTicker(sysdbRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return nil
+			case <-ticker.C:
+				if err := s.refreshSysDB(s.ctx); err != nil && s.ctx.Err() == nil {
+					log.Printf("system schema refresh: %s", err)
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// Refreshes the system schema tables with the current server state.
+func (s *Server) refreshSysDB(ctx context.Context) error {
+	if s.sysdb == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	connections := make(map[string]int)
+	var stmtCount, stmtBytes, stmtEvictions int64
+	var sessions []sysdb.Activity
+	for conn := range s.conns {
+		if conn.name != "" {
+			connections[conn.name]++
+		}
+		sessions = append(sessions, conn.activityRow())
+		stmtCount += conn.stmtCount.Load()
+		stmtBytes += conn.stmtBytes.Load()
+		stmtEvictions += conn.stmtEvictions.Load()
+	}
+	active := len(s.conns)
+	schemaVersions := maps.Clone(s.schemaVersions)
+	s.mu.Unlock()
+
+	databases, err := s.listDatabases(connections, schemaVersions)
+	if err != nil {
+		return err
+	}
+	if err := s.sysdb.SetDatabases(ctx, databases); err != nil {
+		return err
+	}
+	if err := s.sysdb.SetDatabaseUsage(ctx, s.databaseUsage(databases)); err != nil {
+		return err
+	}
+	if err := s.sysdb.SetApplicationUsage(ctx, s.accounts.applicationUsage()); err != nil {
+		return err
+	}
+
+	tables, indexes := s.usage.snapshot()
+	if err := s.sysdb.SetUsage(ctx, tables, indexes); err != nil {
+		return err
+	}
+	if err := s.sysdb.SetRelations(ctx, s.oids.snapshot()); err != nil {
+		return err
+	}
+	if err := s.sysdb.SetMatViews(ctx, s.oids.matViews()); err != nil {
+		return err
+	}
+	if err := s.sysdb.SetFunctions(ctx, s.functionsSnapshot()); err != nil {
+		return err
+	}
+	if err := s.sysdb.SetActivity(ctx, sessions); err != nil {
+		return err
+	}
+	if err := s.sysdb.SetLocks(ctx, s.locks()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	initScripts := slices.Clone(s.initApplied)
+	s.mu.Unlock()
+	if err := s.sysdb.SetInitScripts(ctx, initScripts); err != nil {
+		return err
+	}
+
+	return s.sysdb.SetStats(ctx, map[string]int64{
+		"connections_active": int64(active),
+		"connections_total":  s.connsTotal.Load(),
+		"queries_total":      s.queriesTotal.Load(),
+		// Prepared statements of active connections.
+		"prepared_statements":         stmtCount,
+		"prepared_statements_bytes":   stmtBytes,
+		"prepared_statements_evicted": stmtEvictions,
+		"group_commit_syncs":          s.groupCommitSyncs.Load(),
+		"group_commits":               s.groupCommits.Load(),
+		"statement_retries":           s.retries.Load(),
+		"statement_retries_failed":    s.retriesFailed.Load(),
+		"reads_active":                s.readsActive.Load(),
+		"reads_queued":                s.readsQueued.Load(),
+		"read_queue_timeouts":         s.readQueueTimeouts.Load(),
+		"maintenance_runs":            s.maintenanceRuns.Load(),
+		"maintenance_pauses":          s.maintenancePauses.Load(),
+		"quota_warnings":              s.quotaWarnings.Load(),
+		"quota_rejections":            s.quotaRejections.Load(),
+		"lock_wait_warnings":          s.lockWaitWarnings.Load(),
+		"rate_limited_statements":     s.rateLimited.Load(),
+		"unsupported_statements":      s.unsupported.Load(),
+		"plan_cache_entries":          int64(s.plans.len()),
+		"plan_cache_hits":             s.plans.hits.Load(),
+		"plan_cache_misses":           s.plans.misses.Load(),
+		"replication_bytes":           s.replicationBytes.Load(),
+		"replication_sent_bytes":      s.replicationSentBytes.Load(),
+	})
+}
+
+// Refreshes the system schema tables if the query refers to them, so they're up to date.
+func (s *Server) refreshSysDBFor(ctx context.Context, query string) {
+	if !systemSchemaRegex.MatchString(query) {
+		return
+	}
+	if err := s.refreshSysDB(ctx); err != nil {
+		log.Printf("system schema refresh: %s", err)
+	}
+}
+
+// Lists the SQLite databases in the data directory.
+func (s *Server) listDatabases(connections map[string]int, schemaVersions map[string]int64) ([]sysdb.Database, error) {
+	entries, err := os.ReadDir(s.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var databases []sysdb.Database
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasSuffix(name, "-wal") ||
+			strings.HasSuffix(name, "-shm") || strings.HasSuffix(name, "-journal") {
+			continue
+		}
+
+		path := filepath.Join(s.DataDir, name)
+		if !isDatabaseFile(path) {
+			continue
+		}
+
+		database := sysdb.Database{
+			Name:        name,
+			Path:        path,
+			JournalMode: s.fileJournalMode(name, path),
+			Connections: connections[name],
+			QuotaBytes:  s.databaseQuota(name),
+		}
+		if version, ok := schemaVersions[name]; ok {
+			database.SchemaVersion = &version
+		}
+		if info, err := os.Stat(path); err == nil {
+			database.SizeBytes = info.Size()
+		}
+		if info, err := os.Stat(path + "-wal"); err == nil {
+			database.WALSizeBytes = info.Size()
+		}
+		databases = append(databases, database)
+	}
+	return databases, nil
+}
+
+// Reports whether the file is an SQLite database, empty files are new databases.
+func isDatabaseFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteHeader))
+	n, err := io.ReadFull(f, header)
+	if n == 0 && err == io.EOF {
+		return true
+	}
+	return err == nil && bytes.Equal(header, sqliteHeader)
+}
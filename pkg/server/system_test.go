@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("System schema", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "system.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id INTEGER PRIMARY KEY)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Lists databases with their connections", func() {
+		var connections int
+		var size int64
+		err := conn.QueryRow(ctx, `SELECT connections, size_bytes FROM kqlite.databases WHERE name = 'system.db'`).
+			Scan(&connections, &size)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(connections).To(Equal(1))
+		Expect(size).To(BeNumerically(">", 0))
+	})
+
+	It("Tracks the schema version of databases", func() {
+		schemaVersion := func() int64 {
+			var version int64
+			Expect(conn.QueryRow(ctx, `SELECT schema_version FROM kqlite.databases WHERE name = 'system.db'`,
+				pgx.QueryExecModeSimpleProtocol).Scan(&version)).To(Succeed())
+			return version
+		}
+
+		before := schemaVersion()
+		Expect(before).To(BeNumerically(">", 0))
+		_, err := conn.Exec(ctx, `CREATE INDEX items_id ON items (id)`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(schemaVersion()).To(BeNumerically(">", before))
+
+		// Data statements leave the schema unchanged.
+		before = schemaVersion()
+		_, err = conn.Exec(ctx, `INSERT INTO items DEFAULT VALUES`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(schemaVersion()).To(Equal(before))
+
+		// Databases not opened since the server started have no known version.
+		path := filepath.Join(srv.DataDir, "unopened.db")
+		Expect(os.WriteFile(path, nil, 0644)).To(Succeed())
+		defer os.Remove(path)
+		var n string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM kqlite.databases WHERE name = 'unopened.db' AND schema_version IS NULL`).
+			Scan(&n)).To(Succeed())
+		Expect(n).To(Equal("1"))
+	})
+
+	It("Reports server information and stats", func() {
+		var version string
+		err := conn.QueryRow(ctx, `SELECT value FROM kqlite.server WHERE name = 'server_version'`).Scan(&version)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).NotTo(BeEmpty())
+
+		var total int64
+		err = conn.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = 'connections_total'`).Scan(&total)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(total).To(BeNumerically(">=", 1))
+	})
+
+	It("Keeps the system schema of each server", func() {
+		other := openServer(nil)
+		otherConn := connectTo(ctx, other, "other.db")
+		defer otherConn.Close(ctx)
+
+		// Servers in the same process list their own data directory and databases.
+		var dataDir string
+		Expect(otherConn.QueryRow(ctx, `SELECT value FROM kqlite.server WHERE name = 'data_dir'`).Scan(&dataDir)).To(Succeed())
+		Expect(dataDir).To(Equal(other.DataDir))
+		Expect(conn.QueryRow(ctx, `SELECT value FROM kqlite.server WHERE name = 'data_dir'`).Scan(&dataDir)).To(Succeed())
+		Expect(dataDir).To(Equal(srv.DataDir))
+
+		var n string
+		Expect(otherConn.QueryRow(ctx, `SELECT count(*) FROM kqlite.databases WHERE name = 'system.db'`).Scan(&n)).To(Succeed())
+		Expect(n).To(Equal("0"))
+	})
+
+	It("Denies changes to the system schema", func() {
+		_, err := conn.Exec(ctx, `DELETE FROM kqlite.databases`)
+		Expect(err).To(HaveOccurred())
+		_, err = conn.Exec(ctx, `CREATE TABLE kqlite.other (id INTEGER)`)
+		Expect(err).To(HaveOccurred())
+	})
+})
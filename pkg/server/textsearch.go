@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Text search indexes are FTS5 tables, their definitions are stored in a catalog
+// table of the database so searches are matched with them.
+const (
+	textSearchTable   = "kqlite_textsearch"
+	textSearchColumns = "name TEXT PRIMARY KEY, tablename TEXT NOT NULL, document TEXT NOT NULL, config TEXT NOT NULL"
+)
+
+// Returns whether a catalog table of kqlite exists in the database.
+func catalogExists(ctx context.Context, conn *sql.Conn, table string) (bool, error) {
+	var tables int
+	err := conn.QueryRowContext(ctx, `SELECT count(*) FROM main.sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&tables)
+	return tables > 0, err
+}
+
+// Returns the text search indexes of a database.
+func textSearchIndexes(ctx context.Context, conn *sql.Conn) ([]*parser.TextSearchIndex, error) {
+	if ok, err := catalogExists(ctx, conn, textSearchTable); err != nil || !ok {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(ctx, `SELECT name, tablename, document, config FROM main.`+textSearchTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var indexes []*parser.TextSearchIndex
+	for rows.Next() {
+		index := &parser.TextSearchIndex{}
+		if err := rows.Scan(&index.Name, &index.Table, &index.Document, &index.Config); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, rows.Err()
+}
+
+// Translates the creation of a text search index into the statements creating its FTS5 table.
+func createTextSearchIndex(ctx context.Context, c *Conn, index *parser.TextSearchIndex) (string, error) {
+	var fts5 bool
+	if err := c.conn.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return "", err
+	} else if !fts5 {
+		e := pgerror.New(pgerror.FeatureNotSupported, "text search indexes require SQLite with FTS5")
+		e.Hint = "Build kqlite with the sqlite_fts5 tag."
+		return "", e
+	}
+
+	// Documents of columns are those of tsvector columns, generated as their text.
+	if index.Column != "" {
+		var typ string
+		err := c.conn.QueryRowContext(ctx, `SELECT type FROM pragma_table_xinfo(?) WHERE name = ?`, index.Table, index.Column).Scan(&typ)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		} else if !strings.EqualFold(typ, "tsvector") {
+			e := pgerror.Newf(pgerror.FeatureNotSupported, "index of column %q is not supported", index.Column)
+			e.Hint = "GIN and GiST indexes are supported on tsvector columns and to_tsvector expressions only."
+			return "", e
+		}
+	}
+
+	indexes, err := textSearchIndexes(ctx, c.conn)
+	if err != nil {
+		return "", err
+	}
+	if slices.ContainsFunc(indexes, func(i *parser.TextSearchIndex) bool { return i.Name == index.Name }) {
+		if index.IfNotExists {
+			return "", nil
+		}
+		return "", pgerror.Newf(pgerror.DuplicateTable, "relation %q already exists", index.Name)
+	}
+
+	stmts, err := parser.TextSearchTable(index)
+	if err != nil {
+		return "", err
+	}
+	stmts = append([]string{`CREATE TABLE IF NOT EXISTS main.` + textSearchTable + ` (` + textSearchColumns + `)`}, stmts...)
+	stmts = append(stmts, `INSERT INTO main.`+textSearchTable+` (name, tablename, document, config) VALUES (`+
+		strings.Join([]string{sqlite.QuoteLiteral(index.Name), sqlite.QuoteLiteral(index.Table),
+			sqlite.QuoteLiteral(index.Document), sqlite.QuoteLiteral(index.Config)}, ", ")+`)`)
+	return strings.Join(stmts, "; "), nil
+}
+
+// Translates DROP INDEX and DROP TABLE statements dropping text search indexes,
+// the FTS5 tables of the indexes are dropped along with their definitions.
+func dropTextSearchIndexes(ctx context.Context, c *Conn, stmt parser.StmtClass) (string, error) {
+	indexes, err := textSearchIndexes(ctx, c.conn)
+	if err != nil || len(indexes) == 0 {
+		return stmt.SQL, err
+	}
+	names, missingOK, _, err := parser.DropObjects(stmt.SQL)
+	if err != nil {
+		return "", err
+	}
+
+	var stmts []string
+	var dropped []*parser.TextSearchIndex
+	if stmt.Command == "DROP TABLE" {
+		stmts = append(stmts, stmt.SQL)
+		for _, index := range indexes {
+			if slices.Contains(names, index.Table) {
+				dropped = append(dropped, index)
+			}
+		}
+	} else {
+		for _, name := range names {
+			if i := slices.IndexFunc(indexes, func(index *parser.TextSearchIndex) bool { return index.Name == name }); i >= 0 {
+				dropped = append(dropped, indexes[i])
+			} else if missingOK {
+				stmts = append(stmts, "DROP INDEX IF EXISTS "+sqlite.QuoteIdent(name))
+			} else {
+				stmts = append(stmts, "DROP INDEX "+sqlite.QuoteIdent(name))
+			}
+		}
+	}
+	if len(dropped) == 0 {
+		return stmt.SQL, nil
+	}
+	for _, index := range dropped {
+		stmts = append(stmts, parser.DropTextSearchTable(index)...)
+		stmts = append(stmts, `DELETE FROM main.`+textSearchTable+` WHERE name = `+sqlite.QuoteLiteral(index.Name))
+	}
+	return strings.Join(stmts, "; "), nil
+}
+
+// Translates the text search matches of a statement into lookups of text search indexes.
+func translateTextSearch(ctx context.Context, c *Conn, stmt parser.StmtClass) (string, error) {
+	if !strings.Contains(stmt.SQL, "@@") {
+		return stmt.SQL, nil
+	}
+	indexes, err := textSearchIndexes(ctx, c.conn)
+	if err != nil {
+		return "", err
+	}
+	return parser.TextSearchQuery(stmt.SQL, indexes)
+}
@@ -0,0 +1,82 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Full text search", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "textsearch.db")
+		var fts5 string
+		Expect(conn.QueryRow(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5)).To(Succeed())
+		if fts5 != "1" {
+			Skip("SQLite is built without FTS5, run with -tags sqlite_fts5")
+		}
+
+		for _, stmt := range []string{
+			`CREATE TABLE docs (id integer PRIMARY KEY, title text, body text,
+				tsv tsvector GENERATED ALWAYS AS (to_tsvector('english', coalesce(title, '') || ' ' || body)) STORED)`,
+			`INSERT INTO docs (title, body) VALUES ('Cats', 'The cats are running'), ('Dogs', 'A dog runs'), ('Birds', 'Birds fly')`,
+			`CREATE INDEX docs_tsv ON docs USING gin (tsv)`,
+			`CREATE INDEX docs_body ON docs USING gin (to_tsvector('simple', body))`,
+		} {
+			_, err := conn.Exec(ctx, stmt)
+			Expect(err).NotTo(HaveOccurred(), stmt)
+		}
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	ids := func(query string, args ...any) []int {
+		rows, err := conn.Query(ctx, query, args...)
+		Expect(err).NotTo(HaveOccurred())
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		Expect(err).NotTo(HaveOccurred())
+		return ids
+	}
+
+	It("Matches documents of tsvector columns", func() {
+		Expect(ids(`SELECT id FROM docs WHERE tsv @@ to_tsquery('english', 'run & !dog') ORDER BY id`)).To(Equal([]int{1}))
+		Expect(ids(`SELECT d.id FROM docs AS d WHERE d.tsv @@ websearch_to_tsquery($1) ORDER BY id`, "cat or bird")).To(Equal([]int{1, 3}))
+	})
+
+	It("Matches documents of to_tsvector expressions", func() {
+		Expect(ids(`SELECT id FROM docs WHERE to_tsvector('simple', body) @@ plainto_tsquery('simple', 'dog runs')`)).To(Equal([]int{2}))
+		// The simple configuration doesn't stem words.
+		Expect(ids(`SELECT id FROM docs WHERE to_tsvector('simple', body) @@ to_tsquery('simple', 'run')`)).To(BeEmpty())
+	})
+
+	It("Keeps text search indexes in sync with their tables", func() {
+		_, err := conn.Exec(ctx, `UPDATE docs SET body = 'Dogs are running' WHERE id = 3`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `DELETE FROM docs WHERE tsv @@ to_tsquery('cat')`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ids(`SELECT id FROM docs WHERE tsv @@ to_tsquery('dog') ORDER BY id`)).To(Equal([]int{2, 3}))
+	})
+
+	It("Drops text search indexes", func() {
+		_, err := conn.Exec(ctx, `DROP INDEX docs_body`)
+		Expect(err).NotTo(HaveOccurred())
+		var pgErr *pgconn.PgError
+		_, err = conn.Exec(ctx, `SELECT id FROM docs WHERE to_tsvector('simple', body) @@ to_tsquery('dog')`)
+		Expect(errors.As(err, &pgErr)).To(BeTrue())
+		Expect(pgErr.Code).To(Equal("0A000"))
+
+		_, err = conn.Exec(ctx, `DROP TABLE docs`)
+		Expect(err).NotTo(HaveOccurred())
+		var tables string
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM sqlite_master WHERE name LIKE 'kqlite_fts%'`).Scan(&tables)).To(Succeed())
+		Expect(tables).To(Equal("0"))
+	})
+})
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Setting enabling the tracing of a connection. Traced connections log the messages they
+// receive and send, and the statements they execute with their duration. Tracing is enabled
+// for all connections by Server.Trace, for a session by setting kqlite.trace, and for the
+// connections of a client by TraceConnections, which kqlite_trace() calls from other sessions.
+const traceParam = "kqlite.trace"
+
+// Logs a message of a traced connection, prefixed by its client address and application.
+func (c *Conn) tracef(format string, args ...interface{}) {
+	if c.trace.Load() {
+		log.Printf("[%s] "+format, append([]interface{}{c.logPrefix()}, args...)...)
+	}
+}
+
+// Returns the client address of the connection, followed by its application name if set.
+func (c *Conn) logPrefix() string {
+	if app := c.application(); app != "" {
+		return fmt.Sprintf("%s %s", c.RemoteAddr(), app)
+	}
+	return c.RemoteAddr().String()
+}
+
+// Logs a statement of a traced connection executed since start.
+func (c *Conn) traceStmt(sql string, start time.Time, err error) {
+	if err != nil {
+		c.tracef("[stmt] %q failed after %s: %s", sql, time.Since(start), err)
+	} else {
+		c.tracef("[stmt] %q in %s", sql, time.Since(start))
+	}
+}
+
+// Write sends messages to the client, traced by their type.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.trace.Load() {
+		c.tracef("[send] %s (%d bytes)", messageTypes(b), len(b))
+	}
+	return c.Conn.Write(b)
+}
+
+// Returns the type bytes of the encoded backend messages of a buffer.
+func messageTypes(b []byte) string {
+	var types []string
+	for len(b) >= 5 {
+		n := int(binary.BigEndian.Uint32(b[1:5]))
+		if n < 4 || 1+n > len(b) {
+			break
+		}
+		types = append(types, string(b[0]))
+		b = b[1+n:]
+	}
+	// Replies to SSL requests are a single byte.
+	if len(b) == 1 {
+		types = append(types, string(b))
+	}
+	return strings.Join(types, " ")
+}
+
+// TraceConnections enables or disables the tracing of the connections of a client address,
+// as reported by their RemoteAddr, returns the number of connections.
+func (s *Server) TraceConnections(addr string, on bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for c := range s.conns {
+		if c.RemoteAddr().String() == addr {
+			c.trace.Store(on)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,61 @@
+package server_test
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connection tracing", Ordered, func() {
+	var conn, admin *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "trace.db")
+		admin = connect(ctx, "trace.db")
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+		Expect(admin.Close(ctx)).To(Succeed())
+	})
+
+	It("Traces a session with SET kqlite.trace", func() {
+		var trace string
+		Expect(conn.QueryRow(ctx, `SHOW kqlite.trace`).Scan(&trace)).To(Succeed())
+		Expect(trace).To(Equal("off"))
+
+		_, err := conn.Exec(ctx, `SET kqlite.trace = on`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.QueryRow(ctx, `SELECT current_setting('kqlite.trace')`).Scan(&trace)).To(Succeed())
+		Expect(trace).To(Equal("on"))
+
+		_, err = conn.Exec(ctx, `RESET kqlite.trace`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.QueryRow(ctx, `SHOW kqlite.trace`).Scan(&trace)).To(Succeed())
+		Expect(trace).To(Equal("off"))
+	})
+
+	It("Traces the connections of a client from another session", func() {
+		addr := conn.PgConn().Conn().LocalAddr().String()
+
+		// Expressions are sent as text.
+		var n string
+		Expect(admin.QueryRow(ctx, `SELECT kqlite_trace($1, true)`, addr).Scan(&n)).To(Succeed())
+		Expect(n).To(Equal("1"))
+
+		var trace string
+		Expect(conn.QueryRow(ctx, `SHOW kqlite.trace`).Scan(&trace)).To(Succeed())
+		Expect(trace).To(Equal("on"))
+
+		Expect(admin.QueryRow(ctx, `SELECT kqlite_trace($1, false)`, addr).Scan(&n)).To(Succeed())
+		Expect(n).To(Equal("1"))
+		Expect(conn.QueryRow(ctx, `SHOW kqlite.trace`).Scan(&trace)).To(Succeed())
+		Expect(trace).To(Equal("off"))
+
+		Expect(admin.QueryRow(ctx, `SELECT kqlite_trace('127.0.0.1:1', true)`).Scan(&n)).To(Succeed())
+		Expect(n).To(Equal("0"))
+	})
+})
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Translates a CREATE TRIGGER statement into the SQLite triggers executing the statements
+// of its function. Triggers keep the statements of their function as of their creation.
+func createTrigger(ctx context.Context, c *Conn, stmt parser.StmtClass) (string, error) {
+	trigger, err := parser.CreateTrigger(stmt.SQL)
+	if err != nil {
+		return "", err
+	}
+	fn, err := lookupFunction(ctx, c, trigger.Function)
+	if err != nil {
+		return "", err
+	} else if fn == nil || fn.Kind != parser.TriggerKind {
+		e := pgerror.Newf(pgerror.UndefinedFunction, "function %s() does not exist", trigger.Function)
+		e.Hint = "Create the trigger function with CREATE FUNCTION ... RETURNS trigger LANGUAGE sql."
+		return "", e
+	}
+	// Calls of SQL functions are inlined as of the creation of the trigger.
+	body, err := parser.InlineFunctions(fn.Body, c.functions)
+	if err != nil {
+		return "", err
+	}
+	text, err := trigger.SQLite(body)
+	if err != nil {
+		return "", err
+	}
+
+	existing, err := sqliteTriggers(ctx, c, trigger.Table, trigger.SQLiteNames())
+	if err != nil {
+		return "", err
+	}
+	if len(existing) > 0 && !trigger.Replace {
+		return "", pgerror.Newf(pgerror.DuplicateObject, "trigger %q for relation %q already exists", trigger.Name, trigger.Table)
+	}
+	var stmts []string
+	for _, name := range existing {
+		stmts = append(stmts, "DROP TRIGGER "+sqlite.QuoteIdent(name))
+	}
+	return strings.Join(append(stmts, text), "; "), nil
+}
+
+// Translates a DROP TRIGGER statement into the statements dropping the SQLite triggers
+// the trigger was translated into.
+func dropTrigger(ctx context.Context, c *Conn, stmt parser.StmtClass) (string, error) {
+	name, table, candidates, missingOK, err := parser.DropTrigger(stmt.SQL)
+	if err != nil {
+		return "", err
+	}
+	existing, err := sqliteTriggers(ctx, c, table, candidates)
+	if err != nil {
+		return "", err
+	}
+	if len(existing) == 0 {
+		if missingOK {
+			return "DROP TRIGGER IF EXISTS " + sqlite.QuoteIdent(name), nil
+		}
+		return "", pgerror.Newf(pgerror.UndefinedObject, "trigger %q for table %q does not exist", name, table)
+	}
+	var stmts []string
+	for _, name := range existing {
+		stmts = append(stmts, "DROP TRIGGER "+sqlite.QuoteIdent(name))
+	}
+	return strings.Join(stmts, "; "), nil
+}
+
+// Returns the SQLite triggers of a table among names.
+func sqliteTriggers(ctx context.Context, c *Conn, table string, names []string) ([]string, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT name FROM main.sqlite_master WHERE type = 'trigger' AND tbl_name = ? COLLATE NOCASE`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var triggers []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		for _, candidate := range names {
+			if strings.EqualFold(name, candidate) {
+				triggers = append(triggers, name)
+			}
+		}
+	}
+	return triggers, rows.Err()
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sqlite"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Prepared transactions of two-phase commit. SQLite transactions can't outlive their
+// connection, so PREPARE TRANSACTION rolls back the transaction of the session and
+// stores its statements in catalog tables of the database, and COMMIT PREPARED executes
+// them again in a transaction of its own. Statements are recorded as SQLite executes
+// them, with their arguments, from the start of transaction blocks.
+const (
+	preparedXactsTable   = "kqlite_prepared_xacts"
+	preparedXactsColumns = "gid TEXT PRIMARY KEY, prepared TEXT NOT NULL, owner TEXT NOT NULL"
+
+	// Statements of prepared transactions, in order of seq. The value at position 0 is the
+	// statement text, and values at the next positions are its arguments.
+	preparedStmtsTable   = "kqlite_prepared_stmts"
+	preparedStmtsColumns = "gid TEXT NOT NULL, seq INTEGER NOT NULL, pos INTEGER NOT NULL, value, PRIMARY KEY (gid, seq, pos)"
+
+	// Maximum length of transaction identifiers, as in PostgreSQL.
+	maxGIDLength = 199
+)
+
+// Statements executed by the transaction block of a session.
+type transaction struct {
+	stmts []recordedStmt
+
+	// Command of a statement executed by the server rather than as a single SQLite
+	// statement, such transactions can't be prepared.
+	unrecorded string
+
+	// A query was executed, the transaction can no longer be set read-write nor change
+	// its isolation level.
+	queried bool
+
+	// The transaction took the write lock of the database as it started.
+	immediate bool
+
+	// Settings changed by SET, SET LOCAL and RESET in the transaction, restored as it ends.
+	params map[string]*savedParam
+}
+
+// Statement of a transaction as executed by SQLite.
+type recordedStmt struct {
+	query string
+	args  []interface{}
+}
+
+// Reports whether a statement controls the transaction block of the session.
+func transactionControl(stmt parser.StmtClass) bool {
+	switch stmt.Command {
+	case parser.CmdBegin, parser.CmdStart, parser.CmdCommit, parser.CmdRollback, "SAVEPOINT", "RELEASE":
+		return true
+	}
+	return false
+}
+
+// Tracks the transaction block of the session after a statement executed by SQLite on the
+// session connection, an empty query for statements executed by the server. Statements of
+// transaction blocks are recorded, read-only statements aside. Once the block ends, they are
+// forgotten and the session returns to its default access mode.
+func (c *Conn) trackStmt(ctx context.Context, stmt parser.StmtClass, query string, args []interface{}) error {
+	if stmt.ReadOnly && !transactionControl(stmt) {
+		return nil
+	}
+	inTx, err := sqlite.InTransaction(c.conn)
+	if err != nil {
+		return err
+	} else if !inTx {
+		// Portals and SET LOCAL settings of the transaction block end with it.
+		if xact := c.xact; xact != nil {
+			c.xact = nil
+			c.closePortals()
+			c.restoreParams(xact, stmt.Command == parser.CmdCommit)
+		}
+		c.releaseWriteLock()
+		return c.refreshAccessMode(ctx)
+	}
+	if c.xact == nil {
+		c.xact = &transaction{}
+	}
+	if !stmt.ReadOnly {
+		c.holdWriteLock()
+	}
+	if query == "" {
+		c.xact.unrecorded = stmt.Command
+	} else {
+		c.xact.stmts = append(c.xact.stmts, recordedStmt{query: query, args: append([]interface{}{}, args...)})
+	}
+	return nil
+}
+
+// Executes PREPARE TRANSACTION, COMMIT PREPARED and ROLLBACK PREPARED.
+func (s *Server) execTwoPhaseStmt(ctx context.Context, c *Conn, stmt parser.StmtClass) error {
+	inTx, err := sqlite.InTransaction(c.conn)
+	if err != nil {
+		return err
+	}
+	switch {
+	case stmt.Command == parser.CmdPrepareTransaction:
+		if !inTx {
+			return pgerror.New(pgerror.NoActiveSQLTransaction, "there is no transaction in progress")
+		}
+		return s.prepareTransaction(ctx, c, stmt.Name)
+	case inTx:
+		return pgerror.Newf(pgerror.ActiveSQLTransaction, "%s cannot run inside a transaction block", stmt.Command)
+	case stmt.Command == parser.CmdCommitPrepared:
+		// Changes of the committed transaction are published as those of other transactions.
+		if err := s.captureChanges(ctx, c); err != nil {
+			return err
+		}
+	}
+	return c.finishPrepared(ctx, stmt.Name, stmt.Command == parser.CmdCommitPrepared)
+}
+
+// Ends the transaction block of the session and stores its statements as a prepared transaction.
+// The transaction is rolled back when it can't be prepared, as PostgreSQL aborts it.
+func (s *Server) prepareTransaction(ctx context.Context, c *Conn, gid string) error {
+	xact := c.xact
+	c.xact = nil
+	c.closePortals()
+	// Settings are kept as on commit, the session goes on without the transaction.
+	c.restoreParams(xact, true)
+	if _, err := c.conn.ExecContext(ctx, "ROLLBACK"); err != nil {
+		return err
+	}
+	if err := c.refreshAccessMode(ctx); err != nil {
+		return err
+	}
+	if xact == nil {
+		xact = &transaction{}
+	}
+	if xact.unrecorded != "" {
+		return pgerror.Newf(pgerror.FeatureNotSupported, "cannot PREPARE a transaction that has executed %s", xact.unrecorded)
+	} else if len(gid) > maxGIDLength {
+		return pgerror.Newf(pgerror.InvalidParameterValue, "transaction identifier %q is too long", gid)
+	}
+
+	return c.atomically(ctx, func() error {
+		for _, create := range []string{
+			`CREATE TABLE IF NOT EXISTS main.` + preparedXactsTable + ` (` + preparedXactsColumns + `)`,
+			`CREATE TABLE IF NOT EXISTS main.` + preparedStmtsTable + ` (` + preparedStmtsColumns + `)`,
+		} {
+			if _, err := c.conn.ExecContext(ctx, create); err != nil {
+				return err
+			}
+		}
+		_, err := c.conn.ExecContext(ctx, `INSERT INTO main.`+preparedXactsTable+` (gid, prepared, owner)
+			VALUES (?, strftime('%Y-%m-%d %H:%M:%f+00', 'now'), current_user())`, gid)
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return pgerror.Newf(pgerror.DuplicateObject, "transaction identifier %q is already in use", gid)
+		} else if err != nil {
+			return err
+		}
+
+		insert := `INSERT INTO main.` + preparedStmtsTable + ` (gid, seq, pos, value) VALUES (?, ?, ?, ?)`
+		for seq, stmt := range xact.stmts {
+			if _, err := c.conn.ExecContext(ctx, insert, gid, seq, 0, stmt.query); err != nil {
+				return err
+			}
+			// Arguments are stored as SQLite binds them, so they are bound again with the same values.
+			for i, arg := range stmt.args {
+				if _, err := c.conn.ExecContext(ctx, insert, gid, seq, i+1, arg); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Commits a prepared transaction, executing its statements, or rolls it back, and
+// removes it from the database.
+func (c *Conn) finishPrepared(ctx context.Context, gid string, commit bool) error {
+	notFound := pgerror.Newf(pgerror.UndefinedObject, "prepared transaction with identifier %q does not exist", gid)
+	if ok, err := catalogExists(ctx, c.conn, preparedXactsTable); err != nil {
+		return err
+	} else if !ok {
+		return notFound
+	}
+
+	return c.atomically(ctx, func() error {
+		// Removing the transaction first locks the database, so it's committed only once.
+		result, err := c.conn.ExecContext(ctx, `DELETE FROM main.`+preparedXactsTable+` WHERE gid = ?`, gid)
+		if err != nil {
+			return err
+		} else if n, _ := result.RowsAffected(); n == 0 {
+			return notFound
+		}
+		stmts, err := preparedStmts(ctx, c, gid)
+		if err != nil {
+			return err
+		}
+		if _, err := c.conn.ExecContext(ctx, `DELETE FROM main.`+preparedStmtsTable+` WHERE gid = ?`, gid); err != nil {
+			return err
+		}
+		if !commit {
+			return nil
+		}
+		for _, stmt := range stmts {
+			if _, err := c.conn.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Returns the statements of a prepared transaction.
+func preparedStmts(ctx context.Context, c *Conn, gid string) ([]recordedStmt, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT seq, pos, value FROM main.`+preparedStmtsTable+` WHERE gid = ? ORDER BY seq, pos`, gid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stmts []recordedStmt
+	for rows.Next() {
+		var seq, pos int
+		var value interface{}
+		if err := rows.Scan(&seq, &pos, &value); err != nil {
+			return nil, err
+		}
+		if pos == 0 {
+			query, _ := value.(string)
+			stmts = append(stmts, recordedStmt{query: query})
+		} else {
+			stmts[len(stmts)-1].args = append(stmts[len(stmts)-1].args, value)
+		}
+	}
+	return stmts, rows.Err()
+}
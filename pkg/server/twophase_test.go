@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Two-phase commit", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "twophase.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE accounts (id INTEGER PRIMARY KEY, owner TEXT, balance INTEGER)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `INSERT INTO accounts VALUES (1, 'alice', 100)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	query := func(sql string) string {
+		var s string
+		Expect(conn.QueryRow(ctx, sql).Scan(&s)).To(Succeed())
+		return s
+	}
+	exec := func(c *pgx.Conn, sql string, args ...any) {
+		_, err := c.Exec(ctx, sql, args...)
+		Expect(err).NotTo(HaveOccurred())
+	}
+	code := func(c *pgx.Conn, sql string) string {
+		var pgErr *pgconn.PgError
+		_, err := c.Exec(ctx, sql)
+		Expect(errors.As(err, &pgErr)).To(BeTrue(), sql)
+		return pgErr.Code
+	}
+
+	It("Commits prepared transactions from other sessions", func() {
+		exec(conn, `BEGIN`)
+		exec(conn, `UPDATE accounts SET balance = balance - $1 WHERE id = $2`, 30, 1)
+		exec(conn, `INSERT INTO accounts VALUES ($1, $2, $3)`, 2, "bob", 30)
+		exec(conn, `SAVEPOINT extra`)
+		exec(conn, `INSERT INTO accounts VALUES (3, 'carol', 0)`)
+		exec(conn, `ROLLBACK TO SAVEPOINT extra`)
+		tag, err := conn.Exec(ctx, `PREPARE TRANSACTION 'transfer-1'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("PREPARE TRANSACTION"))
+
+		// Changes of prepared transactions aren't visible until committed.
+		Expect(query(`SELECT sum(balance) || '/' || count(*) FROM accounts`)).To(Equal("100/1"))
+		Expect(query(`SELECT gid FROM kqlite_prepared_xacts`)).To(Equal("transfer-1"))
+
+		other := connect(ctx, "twophase.db")
+		defer other.Close(ctx)
+		tag, err = other.Exec(ctx, `COMMIT PREPARED 'transfer-1'`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tag.String()).To(Equal("COMMIT PREPARED"))
+		Expect(query(`SELECT group_concat(owner || ':' || balance, ',') FROM accounts`)).To(Equal("alice:70,bob:30"))
+		Expect(query(`SELECT count(*) FROM kqlite_prepared_xacts`)).To(Equal("0"))
+		Expect(code(other, `COMMIT PREPARED 'transfer-1'`)).To(Equal("42704"))
+	})
+
+	It("Rolls back prepared transactions", func() {
+		exec(conn, `BEGIN`)
+		exec(conn, `DELETE FROM accounts`)
+		exec(conn, `PREPARE TRANSACTION 'cleanup'`)
+		exec(conn, `ROLLBACK PREPARED 'cleanup'`)
+		Expect(query(`SELECT count(*) FROM accounts`)).To(Equal("2"))
+		Expect(code(conn, `ROLLBACK PREPARED 'cleanup'`)).To(Equal("42704"))
+	})
+
+	It("Rejects prepared transaction statements out of place", func() {
+		Expect(code(conn, `PREPARE TRANSACTION 'none'`)).To(Equal("25P01"))
+
+		exec(conn, `BEGIN`)
+		exec(conn, `UPDATE accounts SET balance = 0`)
+		exec(conn, `PREPARE TRANSACTION 'reset'`)
+		exec(conn, `BEGIN`)
+		Expect(code(conn, `COMMIT PREPARED 'reset'`)).To(Equal("25001"))
+		exec(conn, `ROLLBACK`)
+
+		// Identifiers in use abort the transaction being prepared.
+		exec(conn, `BEGIN`)
+		exec(conn, `DELETE FROM accounts`)
+		Expect(code(conn, `PREPARE TRANSACTION 'reset'`)).To(Equal("42710"))
+		Expect(query(`SELECT count(*) FROM accounts`)).To(Equal("2"))
+		exec(conn, `ROLLBACK PREPARED 'reset'`)
+	})
+
+	It("Rejects preparing transactions with statements executed by the server", func() {
+		exec(conn, `BEGIN`)
+		exec(conn, `CREATE MATERIALIZED VIEW balances AS SELECT owner, balance FROM accounts`)
+		Expect(code(conn, `PREPARE TRANSACTION 'view'`)).To(Equal("0A000"))
+		Expect(query(`SELECT count(*) FROM sqlite_master WHERE name = 'balances'`)).To(Equal("0"))
+	})
+})
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Default PostgreSQL port, used in the socket name if the TCP port is unknown.
+const defaultPort = 5432
+
+// Returns the socket path in dir following the PostgreSQL naming convention,
+// ".s.PGSQL.<port>" with the port of the TCP listener, so clients find it given
+// the socket directory as host and the TCP port.
+func unixSocketPath(dir string, addr net.Addr) string {
+	port := defaultPort
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		port = tcpAddr.Port
+	}
+	return filepath.Join(dir, fmt.Sprintf(".s.PGSQL.%d", port))
+}
+
+// Listens on a Unix socket, a stale socket file left behind by an unclean
+// shutdown is removed, one that is still accepting connections is not.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,46 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/server"
+)
+
+var _ = Describe("Unix socket listener", func() {
+	ctx := context.Background()
+
+	It("Accepts connections on the PostgreSQL socket path", func() {
+		socketDir, err := os.MkdirTemp("", "kqlite")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(socketDir)
+
+		local := server.NewServer()
+		local.Addr = "127.0.0.1:0"
+		local.UnixSocketDir = socketDir
+		local.DataDir = GinkgoT().TempDir()
+		Expect(local.Open()).To(Succeed())
+
+		port := local.ListenerAddr().(*net.TCPAddr).Port
+		path := filepath.Join(socketDir, fmt.Sprintf(".s.PGSQL.%d", port))
+		Expect(local.UnixSocketPath()).To(Equal(path))
+
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("host=%s port=%d dbname=local.db", socketDir, port))
+		Expect(err).NotTo(HaveOccurred())
+		var one string
+		Expect(conn.QueryRow(ctx, `SELECT 'one'`).Scan(&one)).To(Succeed())
+		Expect(one).To(Equal("one"))
+		Expect(conn.Close(ctx)).To(Succeed())
+
+		Expect(local.Close()).To(Succeed())
+		_, err = os.Stat(path)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+)
+
+// Returns the error of a statement PostgreSQL parses but SQLite fails to, a feature of the
+// statement isn't supported. The statement is the context of the error, as PostgreSQL
+// reports the statements of functions. Other errors are returned as is.
+func unsupportedStmt(stmt parser.StmtClass, err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || pgerror.Convert(err).Code != pgerror.SyntaxError {
+		return err
+	}
+	e := pgerror.Newf(pgerror.FeatureNotSupported, "unsupported %s statement: %s", stmt.Command, sqliteErr.Error())
+	e.Where = fmt.Sprintf(`SQL statement "%s"`, stmt.SQL)
+	return e
+}
+
+// Returns the error response of err, counting the statements failing on unsupported features.
+func (s *Server) errorResponse(err error) *pgproto3.ErrorResponse {
+	response := pgerror.Response(err)
+	if response.Code == pgerror.FeatureNotSupported {
+		s.unsupported.Add(1)
+	}
+	return response
+}
@@ -0,0 +1,49 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unsupported statements", func() {
+	ctx := context.Background()
+
+	It("Fail with feature_not_supported and the statement", func() {
+		conn := connect(ctx, "unsupported.db")
+		defer conn.Close(ctx)
+		_, err := conn.Exec(ctx, `CREATE TABLE items (id int PRIMARY KEY, tags text)`)
+		Expect(err).NotTo(HaveOccurred())
+
+		unsupported := func() int64 {
+			var value int64
+			Expect(conn.QueryRow(ctx, `SELECT value FROM kqlite.stats WHERE name = 'unsupported_statements'`,
+				pgx.QueryExecModeSimpleProtocol).Scan(&value)).To(Succeed())
+			return value
+		}
+		before := unsupported()
+
+		const sql = `SELECT i.id, t.n FROM items i, LATERAL (SELECT i.id + 1 AS n) t`
+		for _, mode := range []pgx.QueryExecMode{pgx.QueryExecModeSimpleProtocol, pgx.QueryExecModeDescribeExec} {
+			_, err = conn.Exec(ctx, sql, mode)
+			var pgErr *pgconn.PgError
+			Expect(errors.As(err, &pgErr)).To(BeTrue(), fmt.Sprint(err))
+			Expect(pgErr.Code).To(Equal("0A000"))
+			Expect(pgErr.Message).To(HavePrefix("unsupported SELECT statement: "))
+			Expect(pgErr.Where).To(Equal(`SQL statement "` + sql + `"`))
+		}
+
+		// SQLite statements PostgreSQL can't parse still fail with syntax errors.
+		_, err = conn.Exec(ctx, `SELECT FROM FROM`, pgx.QueryExecModeSimpleProtocol)
+		var pgErr *pgconn.PgError
+		Expect(errors.As(err, &pgErr)).To(BeTrue(), fmt.Sprint(err))
+		Expect(pgErr.Code).To(Equal("42601"))
+
+		Expect(unsupported()).To(Equal(before + 2))
+	})
+})
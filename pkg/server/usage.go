@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kqlite/kqlite/pkg/parser"
+	"github.com/kqlite/kqlite/pkg/sysdb"
+)
+
+// Executions of a statement after which its query plan is sampled again.
+const planSampleInterval = 100
+
+// Table access in a query plan, by index or full scan.
+type planScan struct {
+	table string
+	index string // Index name, empty for full scans and rowid lookups.
+	seq   bool   // Full table scan.
+}
+
+type sampledPlan struct {
+	scans      []planScan
+	executions int64
+}
+
+// Database object identifier.
+type objectKey struct {
+	database string
+	name     string
+}
+
+type tableUsage struct {
+	seqScan int64
+	idxScan int64
+}
+
+type indexUsage struct {
+	table   string
+	idxScan int64
+}
+
+// usageTracker counts table and index scans of executed statements. Plans are
+// sampled per statement fingerprint with EXPLAIN QUERY PLAN and attributed to
+// every execution of the statement.
+type usageTracker struct {
+	mu      sync.Mutex
+	plans   map[objectKey]*sampledPlan // By database and statement fingerprint.
+	tables  map[objectKey]*tableUsage
+	indexes map[objectKey]*indexUsage
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{
+		plans:   make(map[objectKey]*sampledPlan),
+		tables:  make(map[objectKey]*tableUsage),
+		indexes: make(map[objectKey]*indexUsage),
+	}
+}
+
+// Returns whether scans of the statement are tracked.
+func trackUsage(stmt parser.StmtClass) bool {
+	switch stmt.Command {
+	case parser.CmdSelect, parser.CmdUpdate, parser.CmdDelete:
+		return len(stmt.Tables) != 0
+	}
+	return false
+}
+
+// Returns whether the statement may change query plans.
+func changesPlans(stmt parser.StmtClass) bool {
+	for _, prefix := range []string{"CREATE ", "DROP ", "ALTER ", "ANALYZE"} {
+		if strings.HasPrefix(stmt.Command, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Records the execution of a statement on the connection, the query plan is
+// sampled on first execution and every planSampleInterval executions.
+func (t *usageTracker) record(ctx context.Context, c *Conn, stmt parser.StmtClass, fingerprint, query string, args []interface{}) {
+	if changesPlans(stmt) {
+		t.invalidate(c.name)
+		return
+	}
+	if fingerprint == "" || !trackUsage(stmt) {
+		return
+	}
+
+	key := objectKey{database: c.name, name: fingerprint}
+	t.mu.Lock()
+	plan, ok := t.plans[key]
+	sample := !ok || plan.executions%planSampleInterval == 0
+	t.mu.Unlock()
+
+	if sample {
+		scans, err := samplePlan(ctx, c, stmt, query, args)
+		if err != nil {
+			log.Printf("sample query plan: %s", err)
+			return
+		}
+		indexes, err := listIndexes(ctx, c)
+		if err != nil {
+			log.Printf("list indexes: %s", err)
+			return
+		}
+
+		t.mu.Lock()
+		t.setIndexes(c.name, indexes)
+		if plan = t.plans[key]; plan == nil {
+			plan = &sampledPlan{}
+			t.plans[key] = plan
+		}
+		plan.scans = scans
+		t.mu.Unlock()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	plan.executions++
+	for _, scan := range plan.scans {
+		table := t.tables[objectKey{database: c.name, name: scan.table}]
+		if table == nil {
+			table = &tableUsage{}
+			t.tables[objectKey{database: c.name, name: scan.table}] = table
+		}
+		if scan.seq {
+			table.seqScan++
+			continue
+		}
+		table.idxScan++
+		if index := t.indexes[objectKey{database: c.name, name: scan.index}]; index != nil {
+			index.idxScan++
+		}
+	}
+}
+
+// Drops the sampled plans of a database, so they are sampled again.
+func (t *usageTracker) invalidate(database string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.plans {
+		if key.database == database {
+			delete(t.plans, key)
+		}
+	}
+}
+
+// Updates the indexes of a database, keeping the counters of existing ones.
+func (t *usageTracker) setIndexes(database string, indexes map[string]string) {
+	for key := range t.indexes {
+		if _, ok := indexes[key.name]; key.database == database && !ok {
+			delete(t.indexes, key)
+		}
+	}
+	for name, table := range indexes {
+		key := objectKey{database: database, name: name}
+		if _, ok := t.indexes[key]; !ok {
+			t.indexes[key] = &indexUsage{table: table}
+		}
+	}
+}
+
+// Returns a snapshot of the usage counters, ordered by database and name.
+func (t *usageTracker) snapshot() ([]sysdb.TableUsage, []sysdb.IndexUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tables := make([]sysdb.TableUsage, 0, len(t.tables))
+	for key, usage := range t.tables {
+		tables = append(tables, sysdb.TableUsage{Database: key.database, Table: key.name, SeqScan: usage.seqScan, IdxScan: usage.idxScan})
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Database < tables[j].Database || (tables[i].Database == tables[j].Database && tables[i].Table < tables[j].Table)
+	})
+
+	indexes := make([]sysdb.IndexUsage, 0, len(t.indexes))
+	for key, usage := range t.indexes {
+		indexes = append(indexes, sysdb.IndexUsage{Database: key.database, Table: usage.table, Index: key.name, IdxScan: usage.idxScan})
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].Database < indexes[j].Database || (indexes[i].Database == indexes[j].Database && indexes[i].Index < indexes[j].Index)
+	})
+	return tables, indexes
+}
+
+// Query plan scan details, e.g. "SEARCH e USING COVERING INDEX emp_dep (dep=?)".
+var planScanRegex = regexp.MustCompile(`^(SCAN|SEARCH) (\S+)(?: USING (AUTOMATIC )?(?:PARTIAL )?(?:COVERING )?INDEX (\S+))?`)
+
+// Returns the table scans in the query plan of the statement.
+func samplePlan(ctx context.Context, c *Conn, stmt parser.StmtClass, query string, args []interface{}) ([]planScan, error) {
+	tables, err := listTables(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.conn.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scans []planScan
+	for rows.Next() {
+		var id, parent, notused int64
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			return nil, err
+		}
+
+		match := planScanRegex.FindStringSubmatch(detail)
+		if match == nil {
+			continue
+		}
+		scan := planScan{table: match[2], index: match[4]}
+		switch {
+		case match[3] != "":
+			// Automatic indexes are built by scanning the table.
+			scan.seq, scan.index = true, ""
+		case match[1] == "SCAN" && scan.index == "":
+			scan.seq = true
+		}
+
+		// Plans refer to tables by alias if one is given, which can be
+		// resolved if the statement has a single table.
+		if !tables[scan.table] {
+			if len(stmt.Tables) != 1 || strings.HasPrefix(scan.table, "(") {
+				continue
+			}
+			scan.table = stmt.Tables[0]
+		}
+		scans = append(scans, scan)
+	}
+	return scans, rows.Err()
+}
+
+// Returns the names of the tables in the database.
+func listTables(ctx context.Context, c *Conn) (map[string]bool, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables[name] = true
+	}
+	return tables, rows.Err()
+}
+
+// Returns the tables of the indexes in the database by index name.
+func listIndexes(ctx context.Context, c *Conn) (map[string]string, error) {
+	rows, err := c.conn.QueryContext(ctx, `SELECT name, tbl_name FROM sqlite_master WHERE type = 'index'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make(map[string]string)
+	for rows.Next() {
+		var name, table string
+		if err := rows.Scan(&name, &table); err != nil {
+			return nil, err
+		}
+		indexes[name] = table
+	}
+	return indexes, rows.Err()
+}
@@ -0,0 +1,58 @@
+package server_test
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Usage statistics", Ordered, func() {
+	var conn *pgx.Conn
+	ctx := context.Background()
+
+	BeforeAll(func() {
+		conn = connect(ctx, "usage.db")
+		_, err := conn.Exec(ctx, `CREATE TABLE employees (id INTEGER PRIMARY KEY, name TEXT, dep_id INTEGER)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `CREATE INDEX employees_dep ON employees (dep_id)`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `CREATE INDEX employees_name ON employees (name)`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		Expect(conn.Close(ctx)).To(Succeed())
+	})
+
+	It("Counts table and index scans", func() {
+		for i := 0; i < 3; i++ {
+			_, err := conn.Exec(ctx, `SELECT id FROM employees e WHERE e.dep_id = $1`, i)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := conn.Exec(ctx, `SELECT count(*) FROM employees WHERE name LIKE '%a'`)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = conn.Exec(ctx, `SELECT * FROM employees WHERE id + 1 = 2`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var seqScan, idxScan int64
+		err = conn.QueryRow(ctx, `SELECT seq_scan, idx_scan FROM kqlite.stat_user_tables
+			WHERE database = 'usage.db' AND relname = 'employees'`).Scan(&seqScan, &idxScan)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(seqScan).To(Equal(int64(1)))
+		Expect(idxScan).To(Equal(int64(4)))
+
+		scans := map[string]int64{}
+		rows, err := conn.Query(ctx, `SELECT indexrelname, idx_scan FROM kqlite.stat_user_indexes WHERE database = 'usage.db'`)
+		Expect(err).NotTo(HaveOccurred())
+		for rows.Next() {
+			var name string
+			var scan int64
+			Expect(rows.Scan(&name, &scan)).To(Succeed())
+			scans[name] = scan
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(scans).To(Equal(map[string]int64{"employees_dep": 3, "employees_name": 1}))
+	})
+})
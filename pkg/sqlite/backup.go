@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// BackupOptions control the pacing of an online backup.
+type BackupOptions struct {
+	PagesPerStep int           // Pages copied per step, all pages in a single step if not positive.
+	Pause        time.Duration // Pause between steps so writers are not blocked by the backup.
+}
+
+// Backup copies the database at src to a new database file at dst with the
+// SQLite online backup API. Pages are copied in paced steps on a dedicated
+// connection, the source is only locked while a step is running.
+// The destination file is removed if the backup fails.
+func Backup(ctx context.Context, src, dst string, opts BackupOptions) (err error) {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("backup file already exists: %s", dst)
+	}
+
+	srcDB, err := sql.Open(DriverName, src)
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	dstDB, err := sql.Open(DriverName, dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dstDB.Close()
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	return dstConn.Raw(func(dstDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			dstSQLite, ok := dstDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", dstDriverConn)
+			}
+			srcSQLite, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", srcDriverConn)
+			}
+			return backupPaced(ctx, dstSQLite, srcSQLite, opts)
+		})
+	})
+}
+
+// Runs backup steps until all pages are copied or the context is cancelled.
+func backupPaced(ctx context.Context, dst, src *sqlite3.SQLiteConn, opts BackupOptions) error {
+	backup, err := dst.Backup("main", src, "main")
+	if err != nil {
+		return fmt.Errorf("backup init: %w", err)
+	}
+	defer backup.Close()
+
+	pages := opts.PagesPerStep
+	if pages <= 0 {
+		pages = -1
+	}
+
+	for {
+		// Busy and locked sources are retried on the next step.
+		done, err := backup.Step(pages)
+		if err != nil {
+			return fmt.Errorf("backup step: %w", err)
+		} else if done {
+			return backup.Finish()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.Pause):
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+var _ = Describe("Backup", func() {
+	ctx := context.Background()
+
+	It("Backup a database while it is written to", func() {
+		dir := GinkgoT().TempDir()
+		src, dst := filepath.Join(dir, "src.db"), filepath.Join(dir, "dst.db")
+
+		db, err := sql.Open(sqlite.DriverName, src)
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+		_, err = db.ExecContext(ctx, `PRAGMA journal_mode = WAL;
+			CREATE TABLE items (id INTEGER PRIMARY KEY, data TEXT);
+			WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000)
+			INSERT INTO items (data) SELECT printf('%0100d', i) FROM n;`)
+		Expect(err).NotTo(HaveOccurred())
+
+		writes := make(chan error, 1)
+		go func() {
+			defer close(writes)
+			for i := 0; i < 50; i++ {
+				if _, err := db.ExecContext(ctx, `INSERT INTO items (data) VALUES ('more')`); err != nil {
+					writes <- err
+					return
+				}
+			}
+		}()
+
+		err = sqlite.Backup(ctx, src, dst, sqlite.BackupOptions{PagesPerStep: 4, Pause: time.Millisecond})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(<-writes).NotTo(HaveOccurred())
+
+		backup, err := sql.Open(sqlite.DriverName, dst)
+		Expect(err).NotTo(HaveOccurred())
+		defer backup.Close()
+
+		var count int
+		Expect(backup.QueryRowContext(ctx, `SELECT count(*) FROM items`).Scan(&count)).To(Succeed())
+		Expect(count).To(BeNumerically(">=", 1000))
+		var check string
+		Expect(backup.QueryRowContext(ctx, `PRAGMA quick_check`).Scan(&check)).To(Succeed())
+		Expect(check).To(Equal("ok"))
+
+		Expect(sqlite.Backup(ctx, src, dst, sqlite.BackupOptions{})).To(MatchError(ContainSubstring("already exists")))
+	})
+})
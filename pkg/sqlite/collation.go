@@ -0,0 +1,64 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// Locales of the collations registered on connections, named by their BCP 47 tag.
+var collationLocales = collate.Supported()
+
+// Collation returns the name of the collation registered for a locale, a BCP 47
+// language tag. Locales without collation of their own sort as the closest locale
+// with one, e.g. de-DE as de, or as the root locale, und, like the languages whose
+// alphabet it orders. The second result is false if the locale is not a known one.
+func Collation(locale string) (string, bool) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", false
+	}
+	// Parents of locales with a script or region other than the default one are the root,
+	// so their language is tried before.
+	var candidates []language.Tag
+	for t := tag; !t.IsRoot(); t = t.Parent() {
+		candidates = append(candidates, t)
+	}
+	if base, confidence := tag.Base(); confidence == language.Exact {
+		candidates = append(candidates, language.Make(base.String()))
+	}
+	candidates = append(candidates, language.Und)
+	for _, t := range candidates {
+		for _, supported := range collationLocales {
+			if supported == t {
+				return supported.String(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// Registers the collations of the supported locales. Strings equal for a locale are
+// compared as bytes, as with deterministic collations of PostgreSQL, so only equal
+// strings are equal. Collators are created when first used.
+func registerCollations(conn *sqlite3.SQLiteConn) error {
+	for _, locale := range collationLocales {
+		var collator *collate.Collator
+		err := conn.RegisterCollation(locale.String(), func(a, b string) int {
+			if collator == nil {
+				collator = collate.New(locale)
+			}
+			if c := collator.CompareString(a, b); c != 0 {
+				return c
+			}
+			return strings.Compare(a, b)
+		})
+		if err != nil {
+			return fmt.Errorf("cannot register collation %s", locale)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package sqlite_test
+
+import (
+	"database/sql"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+var _ = Describe("Collations", func() {
+
+	DescribeTable("Return the collations of locales",
+		func(locale, expected string) {
+			collation, ok := sqlite.Collation(locale)
+			Expect(ok).To(BeTrue())
+			Expect(collation).To(Equal(expected))
+		},
+		Entry("Locales with a collation", "en-US", "en-US"),
+		Entry("Regions of languages", "de-DE", "de"),
+		Entry("Scripts of languages", "zh-Hans-CN", "zh"),
+		Entry("Languages sorted as the root locale", "pt-BR", "und"),
+	)
+
+	It("Reject unknown locales", func() {
+		_, ok := sqlite.Collation("xx-YY")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("Sort strings as the locale does", func() {
+		db, err := sql.Open(sqlite.DriverName, ":memory:")
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		rows, err := db.Query(`SELECT column1 FROM (VALUES ('Zürich'), ('Örebro'), ('zürich'), ('Aachen')) ORDER BY column1 COLLATE "de"`)
+		Expect(err).NotTo(HaveOccurred())
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			Expect(rows.Scan(&name)).To(Succeed())
+			names = append(names, name)
+		}
+		Expect(rows.Err()).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"Aachen", "Örebro", "zürich", "Zürich"}))
+	})
+})
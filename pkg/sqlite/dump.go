@@ -0,0 +1,124 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dump writes the schema and contents of the database as a SQL script.
+// The script is executed in a single transaction when loaded back.
+func Dump(ctx context.Context, db *sql.DB, w io.Writer) error {
+	// Read everything from a single snapshot.
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Tables go first so indexes, views and triggers can refer to them.
+	rows, err := tx.QueryContext(ctx, `SELECT type, name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY CASE type WHEN 'table' THEN 0 WHEN 'index' THEN 1 WHEN 'view' THEN 2 ELSE 3 END, rowid`)
+	if err != nil {
+		return err
+	}
+	type schemaEntry struct{ kind, name, sql string }
+	var entries []schemaEntry
+	for rows.Next() {
+		var entry schemaEntry
+		if err := rows.Scan(&entry.kind, &entry.name, &entry.sql); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintln(w, "BEGIN TRANSACTION;"); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := fmt.Fprintf(w, "%s;\n", entry.sql); err != nil {
+			return err
+		}
+		if entry.kind != "table" || strings.HasPrefix(strings.ToUpper(entry.sql), "CREATE VIRTUAL TABLE") {
+			continue
+		}
+		if err := dumpTable(ctx, tx, w, entry.name); err != nil {
+			return fmt.Errorf("dump table %s: %w", entry.name, err)
+		}
+	}
+	_, err = fmt.Fprintln(w, "COMMIT;")
+	return err
+}
+
+// Writes the table rows as INSERT statements.
+func dumpTable(ctx context.Context, tx *sql.Tx, w io.Writer, table string) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s", QuoteIdent(table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(cols))
+	refs := make([]interface{}, len(cols))
+	for i := range refs {
+		refs[i] = &values[i]
+	}
+
+	literals := make([]string, len(cols))
+	for rows.Next() {
+		if err := rows.Scan(refs...); err != nil {
+			return err
+		}
+		for i, value := range values {
+			literals[i] = QuoteLiteral(value)
+		}
+		if _, err := fmt.Fprintf(w, "INSERT INTO %s VALUES(%s);\n", QuoteIdent(table), strings.Join(literals, ",")); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// QuoteIdent quotes an SQL identifier.
+func QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteLiteral formats a value scanned from SQLite as an SQL literal.
+func QuoteLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999999-07:00") + "'"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(fmt.Sprint(value), "'", "''") + "'"
+}
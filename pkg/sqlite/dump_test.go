@@ -0,0 +1,53 @@
+package sqlite_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Opens a private in-memory database, limited to a single connection so it isn't lost.
+func openMemoryDB() *sql.DB {
+	db, err := sql.Open(sqlite.DriverName, ":memory:")
+	Expect(err).NotTo(HaveOccurred())
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+var _ = Describe("Dump", func() {
+	ctx := context.Background()
+
+	It("Dump and load back a database", func() {
+		db := openMemoryDB()
+		defer db.Close()
+		_, err := db.ExecContext(ctx, `CREATE TABLE cars (id INTEGER PRIMARY KEY, brand TEXT, data BLOB, price REAL);
+			CREATE INDEX cars_brand ON cars (brand);
+			INSERT INTO cars VALUES (1, 'it''s', X'00ff', 1.5), (2, NULL, NULL, NULL);`)
+		Expect(err).NotTo(HaveOccurred())
+
+		var buf bytes.Buffer
+		Expect(sqlite.Dump(ctx, db, &buf)).To(Succeed())
+		script := buf.String()
+		Expect(script).To(HavePrefix("BEGIN TRANSACTION;\nCREATE TABLE cars"))
+		Expect(script).To(ContainSubstring(`INSERT INTO "cars" VALUES(1,'it''s',X'00ff',1.5);`))
+		Expect(script).To(ContainSubstring(`INSERT INTO "cars" VALUES(2,NULL,NULL,NULL);`))
+		Expect(script).To(ContainSubstring("CREATE INDEX cars_brand ON cars (brand);"))
+		Expect(script).To(HaveSuffix("COMMIT;\n"))
+
+		restored := openMemoryDB()
+		defer restored.Close()
+		_, err = restored.ExecContext(ctx, script)
+		Expect(err).NotTo(HaveOccurred())
+
+		var brand string
+		var data []byte
+		Expect(restored.QueryRowContext(ctx, `SELECT brand, data FROM cars WHERE id = 1`).Scan(&brand, &data)).To(Succeed())
+		Expect(brand).To(Equal("it's"))
+		Expect(data).To(Equal([]byte{0x00, 0xff}))
+	})
+})
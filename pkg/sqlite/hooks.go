@@ -0,0 +1,68 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Operations of row changes reported by RegisterChangeHooks.
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// RegisterChangeHooks registers SQLite update, commit and rollback hooks on a connection:
+// changed is called with each row inserted, updated or deleted in the tables of the main
+// database, committed and rolledBack when the transaction of the changes ends. Changes of
+// WITHOUT ROWID tables aren't reported, and savepoints rolled back don't undo the changes
+// reported. The hooks are called as SQLite executes statements, they must not use the
+// connection.
+func RegisterChangeHooks(conn *sql.Conn, changed func(op, table string, rowid int64), committed, rolledBack func()) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		sc.RegisterUpdateHook(func(op int, database, table string, rowid int64) {
+			if database != "main" {
+				return
+			}
+			switch op {
+			case sqlite3.SQLITE_INSERT:
+				changed(OpInsert, table, rowid)
+			case sqlite3.SQLITE_UPDATE:
+				changed(OpUpdate, table, rowid)
+			case sqlite3.SQLITE_DELETE:
+				changed(OpDelete, table, rowid)
+			}
+		})
+		sc.RegisterCommitHook(func() int {
+			committed()
+			return 0
+		})
+		sc.RegisterRollbackHook(rolledBack)
+		return nil
+	})
+}
+
+// RegisterNotifyFunc registers the pg_notify(channel, payload) function sending a
+// notification to the sessions listening to the channel.
+func RegisterNotifyFunc(conn *sql.Conn, notify func(channel, payload string) error) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		// Expressions are sent as text, void renders as an empty string.
+		fn := func(channel, payload string) (string, error) {
+			return "", notify(channel, payload)
+		}
+		if err := sc.RegisterFunc("pg_notify", fn, false); err != nil {
+			return fmt.Errorf("cannot register pg_notify() function")
+		}
+		return nil
+	})
+}
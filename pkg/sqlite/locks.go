@@ -0,0 +1,134 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// AdvisoryKey is the key of an advisory lock, a bigint or a pair of integers. Keys of pairs
+// don't conflict with bigint keys, as in PostgreSQL.
+type AdvisoryKey struct {
+	ID   int64
+	Pair bool
+}
+
+// AdvisoryLocker acquires and releases the advisory locks of a session.
+type AdvisoryLocker interface {
+	// Lock acquires a lock, held until the end of the transaction if xact is set, until it's
+	// unlocked otherwise. Without wait, returns false if the lock is held by other sessions.
+	Lock(key AdvisoryKey, shared, xact, wait bool) (bool, error)
+	// Unlock releases a lock held until unlocked, returns false if it isn't held.
+	Unlock(key AdvisoryKey, shared bool) bool
+	// UnlockAll releases the locks held until unlocked.
+	UnlockAll()
+}
+
+// RegisterAdvisoryLockFuncs registers the advisory lock functions of PostgreSQL, e.g.
+// pg_advisory_lock(key) and pg_try_advisory_xact_lock(key1, key2), acquiring and releasing
+// the locks of locker.
+func RegisterAdvisoryLockFuncs(conn *sql.Conn, locker AdvisoryLocker) error {
+	type lockFunc struct {
+		name               string
+		shared, xact, wait bool
+	}
+	var locks []lockFunc
+	for _, xact := range []bool{false, true} {
+		for _, shared := range []bool{false, true} {
+			for _, wait := range []bool{true, false} {
+				name := "pg_advisory_lock"
+				if xact {
+					name = "pg_advisory_xact_lock"
+				}
+				if !wait {
+					name = "pg_try" + name[2:]
+				}
+				if shared {
+					name += "_shared"
+				}
+				locks = append(locks, lockFunc{name, shared, xact, wait})
+			}
+		}
+	}
+
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		for _, f := range locks {
+			impl := func(args ...interface{}) (string, error) {
+				key, err := advisoryKey(args)
+				if err != nil {
+					return "", err
+				}
+				locked, err := locker.Lock(key, f.shared, f.xact, f.wait)
+				if err != nil || f.wait {
+					return "", err
+				}
+				return boolText(locked), nil
+			}
+			if err := sc.RegisterFunc(f.name, impl, false); err != nil {
+				return fmt.Errorf("cannot register %s() function", f.name)
+			}
+		}
+		for _, shared := range []bool{false, true} {
+			name := "pg_advisory_unlock"
+			if shared {
+				name += "_shared"
+			}
+			impl := func(args ...interface{}) (string, error) {
+				key, err := advisoryKey(args)
+				if err != nil {
+					return "", err
+				}
+				return boolText(locker.Unlock(key, shared)), nil
+			}
+			if err := sc.RegisterFunc(name, impl, false); err != nil {
+				return fmt.Errorf("cannot register %s() function", name)
+			}
+		}
+		unlockAll := func() string {
+			locker.UnlockAll()
+			return ""
+		}
+		if err := sc.RegisterFunc("pg_advisory_unlock_all", unlockAll, false); err != nil {
+			return fmt.Errorf("cannot register pg_advisory_unlock_all() function")
+		}
+		return nil
+	})
+}
+
+// Returns the key of the arguments of an advisory lock function, a bigint or a pair of
+// integers. Parameters are bound as text unless their type is given.
+func advisoryKey(args []interface{}) (AdvisoryKey, error) {
+	var ids []int64
+	for _, arg := range args {
+		bits := 64
+		if len(args) == 2 {
+			bits = 32
+		}
+		id, err := strconv.ParseInt(fmt.Sprint(arg), 10, bits)
+		if err != nil {
+			return AdvisoryKey{}, fmt.Errorf("invalid advisory lock key %v", arg)
+		}
+		ids = append(ids, id)
+	}
+	switch len(ids) {
+	case 1:
+		return AdvisoryKey{ID: ids[0]}, nil
+	case 2:
+		return AdvisoryKey{ID: ids[0]<<32 | int64(uint32(ids[1])), Pair: true}, nil
+	}
+	return AdvisoryKey{}, fmt.Errorf("advisory lock functions take a bigint key or two integer keys")
+}
+
+// Expressions are sent as text, booleans are rendered as PostgreSQL renders them.
+func boolText(b bool) string {
+	if b {
+		return "t"
+	}
+	return "f"
+}
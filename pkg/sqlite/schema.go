@@ -0,0 +1,57 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// AttachSchema attaches the database file of a schema to the connection under the schema name.
+func AttachSchema(ctx context.Context, conn Queryer, name, path string) error {
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS ?`, path, name); err != nil {
+		return fmt.Errorf("cannot attach schema %q: %w", name, err)
+	}
+	return nil
+}
+
+// DetachSchema detaches the database file of a schema from the connection.
+func DetachSchema(ctx context.Context, conn Queryer, name string) error {
+	if _, err := conn.ExecContext(ctx, `DETACH DATABASE ?`, name); err != nil {
+		return fmt.Errorf("cannot detach schema %q: %w", name, err)
+	}
+	return nil
+}
+
+// RegisterSchemaFuncs registers the functions reporting the schemas of the search path of the
+// session connection, searchPath returns the existing schemas of the path in order.
+func RegisterSchemaFuncs(conn *sql.Conn, searchPath func() []string) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		// The current schema is NULL when none of the path exists, as in PostgreSQL.
+		if err := sc.RegisterFunc("current_schema", func() interface{} {
+			if path := searchPath(); len(path) > 0 {
+				return path[0]
+			}
+			return nil
+		}, false); err != nil {
+			return fmt.Errorf("cannot register current_schema() function")
+		}
+		// Arrays are sent as text, implicit schemas are searched before the path.
+		if err := sc.RegisterFunc("current_schemas", func(implicit bool) string {
+			path := searchPath()
+			if implicit {
+				path = append([]string{"pg_catalog"}, path...)
+			}
+			return "{" + strings.Join(path, ",") + "}"
+		}, false); err != nil {
+			return fmt.Errorf("cannot register current_schemas() function")
+		}
+		return nil
+	})
+}
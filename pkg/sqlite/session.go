@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Queryer executes statements, implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type Queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// InTransaction reports whether a transaction is open on the connection.
+func InTransaction(conn *sql.Conn) (bool, error) {
+	var inTx bool
+	err := conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		inTx = !sc.AutoCommit()
+		return nil
+	})
+	return inTx, err
+}
+
+// IsBusy reports whether the error is SQLITE_BUSY or SQLITE_LOCKED, the statement
+// failed on a lock held by another connection and may succeed if executed again.
+func IsBusy(err error) bool {
+	var serr sqlite3.Error
+	return errors.As(err, &serr) && (serr.Code == sqlite3.ErrBusy || serr.Code == sqlite3.ErrLocked)
+}
+
+// DropTempObjects drops all tables and views in the temp schema of the connection,
+// indexes and triggers are dropped along with their tables.
+func DropTempObjects(ctx context.Context, db Queryer) error {
+	rows, err := db.QueryContext(ctx, `SELECT type, name FROM temp.sqlite_master
+		WHERE type IN ('view', 'table') ORDER BY type = 'table'`)
+	if err != nil {
+		return err
+	}
+	var drops []string
+	for rows.Next() {
+		var kind, name string
+		if err := rows.Scan(&kind, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		drops = append(drops, fmt.Sprintf("DROP %s temp.%s", kind, QuoteIdent(name)))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, drop := range drops {
+		if _, err := db.ExecContext(ctx, drop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
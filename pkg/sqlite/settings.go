@@ -0,0 +1,164 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// RegisterSettingFuncs registers the functions reading settings of the session connection,
+// setting returns the current value of a setting.
+func RegisterSettingFuncs(conn *sql.Conn, setting func(name string) string) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		// SHOW statements are rewritten into show() calls.
+		if err := sc.RegisterFunc("show", setting, false); err != nil {
+			return fmt.Errorf("cannot register show() function")
+		}
+		if err := sc.RegisterFunc("current_setting", setting, false); err != nil {
+			return fmt.Errorf("cannot register current_setting() function")
+		}
+		// Sessions are always served by the writable primary. Expressions are sent
+		// as text, so the boolean is rendered as PostgreSQL renders it.
+		if err := sc.RegisterFunc("pg_is_in_recovery", func() string { return "f" }, true); err != nil {
+			return fmt.Errorf("cannot register pg_is_in_recovery() function")
+		}
+		return nil
+	})
+}
+
+// SessionInfo describes the session of a connection, as the session information functions report it.
+type SessionInfo struct {
+	Database   string
+	User       string
+	Version    string // PostgreSQL version string of the server.
+	PID        int32
+	ClientAddr string // Empty for unix socket clients.
+	ClientPort int
+	ServerAddr string // Empty for unix socket clients.
+	ServerPort int
+}
+
+// RegisterSessionFuncs registers the session information functions of the session connection,
+// they replace the functions of connections without a session.
+func RegisterSessionFuncs(conn *sql.Conn, info SessionInfo) error {
+	if info.User == "" {
+		info.User = currentUser()
+	}
+	// Addresses and ports are NULL for unix socket clients.
+	addr := func(addr string, port int) (func() interface{}, func() interface{}) {
+		if addr == "" {
+			return func() interface{} { return nil }, func() interface{} { return nil }
+		}
+		return func() interface{} { return addr }, func() interface{} { return int64(port) }
+	}
+	clientAddr, clientPort := addr(info.ClientAddr, info.ClientPort)
+	serverAddr, serverPort := addr(info.ServerAddr, info.ServerPort)
+
+	funcs := []struct {
+		name string
+		impl interface{}
+	}{
+		{"current_database", func() string { return info.Database }},
+		{"current_catalog", func() string { return info.Database }},
+		{"current_user", func() string { return info.User }},
+		{"session_user", func() string { return info.User }},
+		{"user", func() string { return info.User }},
+		{"version", func() string { return info.Version }},
+		{"pg_backend_pid", func() int64 { return int64(info.PID) }},
+		{"inet_client_addr", clientAddr},
+		{"inet_client_port", clientPort},
+		{"inet_server_addr", serverAddr},
+		{"inet_server_port", serverPort},
+	}
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		for _, f := range funcs {
+			if err := sc.RegisterFunc(f.name, f.impl, true); err != nil {
+				return fmt.Errorf("cannot register %s() function", f.name)
+			}
+		}
+		return nil
+	})
+}
+
+// RegisterTraceFunc registers the kqlite_trace(addr, on) function enabling or disabling the
+// tracing of the connections of a client address, trace returns the number of connections.
+func RegisterTraceFunc(conn *sql.Conn, trace func(addr string, on bool) int) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		if err := sc.RegisterFunc("kqlite_trace", trace, false); err != nil {
+			return fmt.Errorf("cannot register kqlite_trace() function")
+		}
+		return nil
+	})
+}
+
+// RegisterFaultFunc registers the kqlite_replication_fault(fault, n) function injecting
+// a fault in the streaming of replication slots. Parameters are bound as text, so n is
+// parsed from its text as well.
+func RegisterFaultFunc(conn *sql.Conn, inject func(fault string, n int64) (int64, error)) error {
+	fn := func(fault string, arg interface{}) (int64, error) {
+		n, err := strconv.ParseInt(fmt.Sprint(arg), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %v of replication fault %q", arg, fault)
+		}
+		return inject(fault, n)
+	}
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		if err := sc.RegisterFunc("kqlite_replication_fault", fn, false); err != nil {
+			return fmt.Errorf("cannot register kqlite_replication_fault() function")
+		}
+		return nil
+	})
+}
+
+// RegisterBackendFuncs registers the pg_cancel_backend(pid) and pg_terminate_backend(pid)
+// functions canceling the current query of the session of a process ID and terminating it,
+// cancel and terminate return false if there's no such session.
+func RegisterBackendFuncs(conn *sql.Conn, cancel, terminate func(pid int64) bool) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		if err := sc.RegisterFunc("pg_cancel_backend", backendFunc(cancel), false); err != nil {
+			return fmt.Errorf("cannot register pg_cancel_backend() function")
+		}
+		if err := sc.RegisterFunc("pg_terminate_backend", backendFunc(terminate), false); err != nil {
+			return fmt.Errorf("cannot register pg_terminate_backend() function")
+		}
+		return nil
+	})
+}
+
+// Returns the SQL function calling fn with the process ID of its argument. Parameters are
+// bound as text unless their type is given. Expressions are sent as text, so the boolean
+// is rendered as PostgreSQL renders it.
+func backendFunc(fn func(pid int64) bool) func(arg interface{}) (string, error) {
+	return func(arg interface{}) (string, error) {
+		pid, err := strconv.ParseInt(fmt.Sprint(arg), 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid process ID %v", arg)
+		}
+		if fn(pid) {
+			return "t", nil
+		}
+		return "f", nil
+	}
+}
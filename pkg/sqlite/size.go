@@ -0,0 +1,176 @@
+package sqlite
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// RegisterSizeFuncs registers the functions reporting the disk usage of databases and
+// relations. databaseSize returns the size of a database by name, relationName returns
+// the name of a relation of the session database by OID, false if there's none.
+// Relation sizes are the sizes of the pages of their B-trees reported by the dbstat
+// virtual table, which SQLite must be built with.
+func RegisterSizeFuncs(conn *sql.Conn, databaseSize func(name string) (int64, error), relationName func(oid int64) (string, bool)) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+
+		// Relations are named by their OID or name, the size of unknown OIDs is NULL.
+		sizeFunc := func(size func(name, kind string) (int64, error)) func(rel interface{}) (interface{}, error) {
+			return func(rel interface{}) (interface{}, error) {
+				name, kind, ok, err := lookupRelation(sc, rel, relationName)
+				if err != nil || !ok {
+					return nil, err
+				}
+				return size(name, kind)
+			}
+		}
+		relationSize := func(name, kind string) (int64, error) {
+			return pageBytes(sc, `WHERE name = ?`, name)
+		}
+		indexesSize := func(name, kind string) (int64, error) {
+			return pageBytes(sc, `WHERE name IN (SELECT name FROM main.sqlite_master WHERE type = 'index' AND tbl_name = ?)`, name)
+		}
+		totalSize := func(name, kind string) (int64, error) {
+			if kind != "table" {
+				return relationSize(name, kind)
+			}
+			return pageBytes(sc, `WHERE name IN (SELECT name FROM main.sqlite_master WHERE tbl_name = ?)`, name)
+		}
+
+		// The relation size is the size of its main fork, other forks are empty.
+		if err := sc.RegisterFunc("pg_relation_size", func(rel interface{}, fork ...string) (interface{}, error) {
+			if len(fork) > 0 && fork[0] != "main" {
+				return int64(0), nil
+			}
+			return sizeFunc(relationSize)(rel)
+		}, false); err != nil {
+			return fmt.Errorf("cannot register pg_relation_size() function")
+		}
+		if err := sc.RegisterFunc("pg_table_size", sizeFunc(relationSize), false); err != nil {
+			return fmt.Errorf("cannot register pg_table_size() function")
+		}
+		if err := sc.RegisterFunc("pg_indexes_size", sizeFunc(indexesSize), false); err != nil {
+			return fmt.Errorf("cannot register pg_indexes_size() function")
+		}
+		if err := sc.RegisterFunc("pg_total_relation_size", sizeFunc(totalSize), false); err != nil {
+			return fmt.Errorf("cannot register pg_total_relation_size() function")
+		}
+		if err := sc.RegisterFunc("pg_database_size", databaseSize, false); err != nil {
+			return fmt.Errorf("cannot register pg_database_size() function")
+		}
+		if err := sc.RegisterFunc("pg_size_pretty", sizePretty, true); err != nil {
+			return fmt.Errorf("cannot register pg_size_pretty() function")
+		}
+		return nil
+	})
+}
+
+// Returns the name and type of a relation of the main database, given by OID or name.
+// Names may be qualified by the public schema and quoted, as regclass values are.
+func lookupRelation(sc *sqlite3.SQLiteConn, rel interface{}, relationName func(oid int64) (string, bool)) (name, kind string, ok bool, err error) {
+	switch rel := rel.(type) {
+	case nil:
+		return "", "", false, nil
+	case int64:
+		if name, ok = relationName(rel); !ok {
+			return "", "", false, nil
+		}
+	default:
+		name = fmt.Sprint(rel)
+		// Parameters are bound as text.
+		if oid, err := strconv.ParseInt(name, 10, 64); err == nil {
+			return lookupRelation(sc, oid, relationName)
+		}
+		// The public schema is rewritten into the main database.
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "public."), "main.")
+		if unquoted, err := strconv.Unquote(name); err == nil && strings.HasPrefix(name, `"`) {
+			name = unquoted
+		}
+	}
+
+	rows, err := sc.Query(`SELECT name, type FROM main.sqlite_master WHERE name = ? COLLATE NOCASE AND type IN ('table', 'index', 'view')
+		ORDER BY name = ? DESC LIMIT 1`, []driver.Value{name, name})
+	if err != nil {
+		return "", "", false, err
+	}
+	defer rows.Close()
+	values := make([]driver.Value, 2)
+	if err := rows.Next(values); err == io.EOF {
+		return "", "", false, fmt.Errorf("relation %q does not exist", name)
+	} else if err != nil {
+		return "", "", false, err
+	}
+	return fmt.Sprint(values[0]), fmt.Sprint(values[1]), true, nil
+}
+
+// Returns the size of the pages of the B-trees of the main database matched by where.
+func pageBytes(sc *sqlite3.SQLiteConn, where string, args ...driver.Value) (int64, error) {
+	rows, err := sc.Query(`SELECT coalesce(sum(pgsize), 0) FROM dbstat('main') `+where, args)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table: dbstat") {
+			return 0, fmt.Errorf("relation sizes require SQLite built with SQLITE_ENABLE_DBSTAT_VTAB")
+		}
+		return 0, err
+	}
+	defer rows.Close()
+	values := make([]driver.Value, 1)
+	if err := rows.Next(values); err != nil {
+		return 0, err
+	}
+	size, _ := values[0].(int64)
+	return size, nil
+}
+
+// Renders a size in bytes with a unit, as pg_size_pretty does: units are used from 10240
+// of the next smaller one, and rounded half away from zero.
+func sizePretty(arg interface{}) (string, error) {
+	var size int64
+	switch arg := arg.(type) {
+	case int64:
+		size = arg
+	case float64:
+		size = int64(arg)
+	default:
+		var err error
+		if size, err = strconv.ParseInt(fmt.Sprint(arg), 10, 64); err != nil {
+			return "", fmt.Errorf("invalid size %v", arg)
+		}
+	}
+
+	const limit = 10 * 1024
+	abs := func(n int64) int64 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	if abs(size) < limit {
+		return fmt.Sprintf("%d bytes", size), nil
+	}
+	// An extra bit is kept for rounding.
+	size >>= 9
+	for _, unit := range []string{"kB", "MB", "GB"} {
+		if abs(size) < 2*limit-1 {
+			return fmt.Sprintf("%d %s", halfRounded(size), unit), nil
+		}
+		size >>= 10
+	}
+	return fmt.Sprintf("%d TB", halfRounded(size)), nil
+}
+
+// Halves a size with its extra rounding bit.
+func halfRounded(n int64) int64 {
+	if n < 0 {
+		return (n - 1) / 2
+	}
+	return (n + 1) / 2
+}
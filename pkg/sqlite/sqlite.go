@@ -37,6 +37,14 @@ func init() {
 			if err := conn.RegisterFunc("version", version, true); err != nil {
 				return fmt.Errorf("cannot register version() function")
 			}
+			// Text search queries are converted to FTS5 queries when matched, so parameters can be converted too.
+			if err := conn.RegisterFunc("kqlite_tsquery", TextSearchQuery, true); err != nil {
+				return fmt.Errorf("cannot register kqlite_tsquery() function")
+			}
+			if err := registerCollations(conn); err != nil {
+				return err
+			}
+			conn.RegisterAuthorizer(systemSchemaAuthorizer)
 			return nil
 		},
 	})
@@ -0,0 +1,47 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SystemSchema is the name of the schema exposing kqlite system information,
+// attached to every client connection.
+const SystemSchema = "kqlite"
+
+// SystemDSN returns the data source name of the shared in-memory system database
+// identified by id, each server has its own.
+func SystemDSN(id string) string {
+	return fmt.Sprintf("file:kqlite-%s?mode=memory&cache=shared", url.PathEscape(id))
+}
+
+// AttachSystemSchema attaches the system database to the connection, uncommitted
+// reads avoid shared-cache table locks while the server refreshes it.
+func AttachSystemSchema(ctx context.Context, conn Queryer, dsn string) error {
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS `+SystemSchema, dsn); err != nil {
+		return fmt.Errorf("cannot attach %s schema: %w", SystemSchema, err)
+	}
+	_, err := conn.ExecContext(ctx, `PRAGMA read_uncommitted = 1`)
+	return err
+}
+
+// Authorizer denying clients any changes to the system schema.
+func systemSchemaAuthorizer(op int, arg1, arg2, dbname string) int {
+	switch op {
+	case sqlite3.SQLITE_INSERT, sqlite3.SQLITE_UPDATE, sqlite3.SQLITE_DELETE,
+		sqlite3.SQLITE_CREATE_INDEX, sqlite3.SQLITE_CREATE_TABLE, sqlite3.SQLITE_CREATE_TRIGGER,
+		sqlite3.SQLITE_CREATE_VIEW, sqlite3.SQLITE_DROP_INDEX, sqlite3.SQLITE_DROP_TABLE,
+		sqlite3.SQLITE_DROP_TRIGGER, sqlite3.SQLITE_DROP_VIEW:
+		if dbname == SystemSchema {
+			return sqlite3.SQLITE_DENY
+		}
+	case sqlite3.SQLITE_ALTER_TABLE, sqlite3.SQLITE_DETACH:
+		if arg1 == SystemSchema {
+			return sqlite3.SQLITE_DENY
+		}
+	}
+	return sqlite3.SQLITE_OK
+}
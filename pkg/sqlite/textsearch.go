@@ -0,0 +1,184 @@
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TextSearchQuery converts a PostgreSQL text search query into the FTS5 query matching
+// the same documents. kind is the function parsing the query in PostgreSQL: to_tsquery,
+// plainto_tsquery, phraseto_tsquery or websearch_to_tsquery. Terms are quoted so FTS5
+// doesn't take them for operators, stemming is left to the tokenizer of the FTS5 table.
+// FTS5 has no unary NOT, so negated terms must follow the terms they exclude from.
+func TextSearchQuery(kind, query string) (string, error) {
+	switch kind {
+	case "to_tsquery":
+		return tsQuery(query)
+	case "plainto_tsquery":
+		return strings.Join(ftsTerms(words(query)), " AND "), nil
+	case "phraseto_tsquery":
+		return ftsString(strings.Join(words(query), " ")), nil
+	case "websearch_to_tsquery":
+		return websearchQuery(query)
+	}
+	return "", fmt.Errorf("unknown text search query function %s", kind)
+}
+
+// Returns the words of a text, separated by characters other than letters and digits.
+func words(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// Quotes a string of an FTS5 query.
+func ftsString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// Quotes the terms of an FTS5 query.
+func ftsTerms(terms []string) []string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = ftsString(term)
+	}
+	return quoted
+}
+
+// Converts the tsquery syntax of to_tsquery: lexemes, possibly quoted and with a :* prefix
+// marker, combined with &, |, !, <-> and parentheses. Weights of lexemes are ignored.
+func tsQuery(query string) (string, error) {
+	var b strings.Builder
+	negatable := false
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '&':
+			i++
+			for i < len(query) && query[i] == ' ' {
+				i++
+			}
+			if i < len(query) && query[i] == '!' {
+				if !negatable {
+					return "", fmt.Errorf("syntax error in tsquery: %q", query)
+				}
+				b.WriteString(" NOT ")
+				i++
+			} else {
+				b.WriteString(" AND ")
+			}
+			negatable = false
+		case c == '|':
+			b.WriteString(" OR ")
+			negatable = false
+			i++
+		case c == '!':
+			return "", fmt.Errorf("negated terms of text search queries must follow a term with &: %q", query)
+		case c == '(' || c == ')':
+			b.WriteByte(c)
+			negatable = c == ')'
+			i++
+		case strings.HasPrefix(query[i:], "<->"):
+			b.WriteString(" + ")
+			negatable = false
+			i += 3
+		case c == '<':
+			return "", fmt.Errorf("distance operators other than <-> are not supported: %q", query)
+		default:
+			var lexeme string
+			if c == '\'' {
+				// Quoted lexemes double their quotes.
+				i++
+				for i < len(query) {
+					if query[i] == '\'' {
+						if i+1 < len(query) && query[i+1] == '\'' {
+							lexeme += "'"
+							i += 2
+							continue
+						}
+						break
+					}
+					lexeme += string(query[i])
+					i++
+				}
+				i++
+			} else {
+				start := i
+				for i < len(query) && !strings.ContainsRune(" \t\n&|!()<:'", rune(query[i])) {
+					i++
+				}
+				lexeme = query[start:i]
+			}
+			b.WriteString(ftsString(lexeme))
+			if i < len(query) && query[i] == ':' {
+				start := i + 1
+				for i++; i < len(query) && strings.ContainsRune("*ABCDabcd", rune(query[i])); i++ {
+				}
+				if strings.Contains(query[start:i], "*") {
+					b.WriteString(" *")
+				}
+			}
+			negatable = true
+		}
+	}
+	return b.String(), nil
+}
+
+// Converts the web search syntax of websearch_to_tsquery: words and quoted phrases,
+// or between alternatives and - before excluded words or phrases.
+func websearchQuery(query string) (string, error) {
+	var b strings.Builder
+	op := ""
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+			continue
+		case c == '-':
+			if b.Len() == 0 {
+				return "", fmt.Errorf("excluded terms of text search queries must follow another term: %q", query)
+			}
+			op = " NOT "
+			i++
+			continue
+		}
+
+		var term string
+		if c == '"' {
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			term = ftsString(strings.Join(words(query[i+1:i+1+end]), " "))
+			i += end + 2
+		} else {
+			end := strings.IndexAny(query[i:], " \t\n")
+			if end < 0 {
+				end = len(query) - i
+			}
+			word := query[i : i+end]
+			i += end
+			if strings.EqualFold(word, "or") {
+				if b.Len() > 0 {
+					op = " OR "
+				}
+				continue
+			}
+			terms := words(word)
+			if len(terms) == 0 {
+				continue
+			}
+			term = strings.Join(ftsTerms(terms), " AND ")
+		}
+		if b.Len() > 0 {
+			if op == "" {
+				op = " AND "
+			}
+			b.WriteString(op)
+		}
+		b.WriteString(term)
+		op = ""
+	}
+	return b.String(), nil
+}
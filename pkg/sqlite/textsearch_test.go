@@ -0,0 +1,31 @@
+package sqlite_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+var _ = Describe("Text search queries", func() {
+
+	DescribeTable("Convert text search queries into FTS5 queries",
+		func(kind, query, expected string) {
+			fts, err := sqlite.TextSearchQuery(kind, query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fts).To(Equal(expected))
+		},
+		Entry("Operators", "to_tsquery", "fat & (rat | cat) & !dog", `"fat" AND ("rat" OR "cat") NOT "dog"`),
+		Entry("Prefixes and phrases", "to_tsquery", "'supern':*A <-> nova", `"supern" * + "nova"`),
+		Entry("Plain text", "plainto_tsquery", "The fat-rats", `"The" AND "fat" AND "rats"`),
+		Entry("Phrases", "phraseto_tsquery", "fat rats", `"fat rats"`),
+		Entry("Web searches", "websearch_to_tsquery", `"sad cat" or fat -rat`, `"sad cat" OR "fat" NOT "rat"`),
+	)
+
+	It("Reject queries without FTS5 equivalent", func() {
+		_, err := sqlite.TextSearchQuery("to_tsquery", "!dog")
+		Expect(err).To(HaveOccurred())
+		_, err = sqlite.TextSearchQuery("to_tsquery", "fat <2> rat")
+		Expect(err).To(HaveOccurred())
+	})
+})
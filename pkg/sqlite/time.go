@@ -0,0 +1,38 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// FormatTimestamp renders a timestamp in the text format of PostgreSQL timestamps
+// with time zone, in the time zone loc, e.g. "2024-03-31 04:30:00.25+02".
+func FormatTimestamp(t time.Time, loc *time.Location) string {
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	if _, offset := t.Zone(); offset%3600 != 0 {
+		return t.Format("2006-01-02 15:04:05.999999-07:00")
+	}
+	return t.Format("2006-01-02 15:04:05.999999-07")
+}
+
+// RegisterTimeFuncs registers the date/time functions of the session connection,
+// they render times in the session time zone returned by loc.
+func RegisterTimeFuncs(conn *sql.Conn, loc func() *time.Location) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		sc, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		now := func() string { return FormatTimestamp(time.Now(), loc()) }
+		if err := sc.RegisterFunc("now", now, false); err != nil {
+			return fmt.Errorf("cannot register now() function")
+		}
+		return nil
+	})
+}
@@ -2,9 +2,9 @@ package sqlite
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/jackc/pgtype"
 )
@@ -69,7 +69,7 @@ func joinElemNames(elems []string) string {
 // Lookup columns type from SQLite by checking the provided list of tables if provided,
 // otherwise check all tables.
 // Will return the corresponding PostgreSQL type compatible with the wire protocol.
-func LookupTypeInfo(ctx context.Context, db *sql.DB, columns, tables []string) ([]uint32, error) {
+func LookupTypeInfo(ctx context.Context, db Queryer, columns, tables []string) ([]uint32, error) {
 	var columnTypes []uint32
 	if len(columns) == 0 || db == nil {
 		return columnTypes, nil
@@ -95,12 +95,20 @@ func LookupTypeInfo(ctx context.Context, db *sql.DB, columns, tables []string) (
 		return columnTypes, err
 	}
 
+	found := make(map[string]uint32)
 	for rows.Next() {
 		var colName, colType string
 		if err := rows.Scan(&colName, &colType); err != nil {
 			return columnTypes, nil
 		}
 		if pgColtype, exists := Typemap()[colType]; exists {
+			found[strings.ToLower(colName)] = pgColtype
+		}
+	}
+
+	// Keep the order of the requested columns.
+	for _, column := range columns {
+		if pgColtype, exists := found[strings.ToLower(column)]; exists {
 			columnTypes = append(columnTypes, pgColtype)
 		} else {
 			// Set TextOID as default if can't lookup type
@@ -0,0 +1,506 @@
+// Package sysdb maintains the kqlite system schema, a shared in-memory SQLite
+// database attached to every client connection of a server as the "kqlite" schema.
+// Its tables are refreshed by the server and read-only for clients.
+package sysdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // sql driver
+
+	"github.com/kqlite/kqlite/pkg/sqlite"
+)
+
+// Table definitions of the system schema, the column contracts are stable
+// so tooling can script against them.
+const schema = `
+CREATE TABLE IF NOT EXISTS server (
+	name  TEXT PRIMARY KEY,
+	value TEXT
+);
+CREATE TABLE IF NOT EXISTS databases (
+	name           TEXT PRIMARY KEY,
+	path           TEXT NOT NULL,
+	size_bytes     INTEGER NOT NULL,
+	wal_size_bytes INTEGER NOT NULL,
+	journal_mode   TEXT NOT NULL,
+	connections    INTEGER NOT NULL,
+	schema_version INTEGER,
+	quota_bytes    INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS stats (
+	name  TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS stat_user_tables (
+	database TEXT NOT NULL,
+	relname  TEXT NOT NULL,
+	seq_scan INTEGER NOT NULL,
+	idx_scan INTEGER NOT NULL,
+	PRIMARY KEY (database, relname)
+);
+CREATE TABLE IF NOT EXISTS stat_databases (
+	database     TEXT PRIMARY KEY,
+	statements   INTEGER NOT NULL,
+	rows_read    INTEGER NOT NULL,
+	rows_written INTEGER NOT NULL,
+	exec_time    REAL NOT NULL,
+	rate_limited INTEGER NOT NULL,
+	rate_limit   REAL NOT NULL,
+	size_bytes   INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS stat_applications (
+	application_name TEXT PRIMARY KEY,
+	statements       INTEGER NOT NULL,
+	rows_read        INTEGER NOT NULL,
+	rows_written     INTEGER NOT NULL,
+	exec_time        REAL NOT NULL
+);
+CREATE TABLE IF NOT EXISTS stat_user_indexes (
+	database     TEXT NOT NULL,
+	relname      TEXT NOT NULL,
+	indexrelname TEXT NOT NULL,
+	idx_scan     INTEGER NOT NULL,
+	PRIMARY KEY (database, indexrelname)
+);
+CREATE TABLE IF NOT EXISTS relations (
+	oid      INTEGER PRIMARY KEY,
+	database TEXT NOT NULL,
+	nspname  TEXT NOT NULL,
+	relname  TEXT NOT NULL,
+	relkind  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS matviews (
+	database    TEXT NOT NULL,
+	schemaname  TEXT NOT NULL,
+	matviewname TEXT NOT NULL,
+	ispopulated INTEGER NOT NULL,
+	definition  TEXT NOT NULL,
+	PRIMARY KEY (database, schemaname, matviewname)
+);
+CREATE TABLE IF NOT EXISTS functions (
+	database TEXT NOT NULL,
+	name     TEXT NOT NULL,
+	kind     TEXT NOT NULL,
+	params   TEXT NOT NULL,
+	body     TEXT NOT NULL,
+	PRIMARY KEY (database, name)
+);
+CREATE TABLE IF NOT EXISTS stat_activity (
+	pid              INTEGER PRIMARY KEY,
+	datname          TEXT,
+	usename          TEXT,
+	application_name TEXT NOT NULL,
+	client_addr      TEXT,
+	client_port      INTEGER NOT NULL,
+	backend_start    TEXT NOT NULL,
+	xact_start       TEXT,
+	query_start      TEXT,
+	state_change     TEXT,
+	state            TEXT NOT NULL,
+	query            TEXT NOT NULL,
+
+	prepared_statements         INTEGER NOT NULL,
+	prepared_statements_bytes   INTEGER NOT NULL,
+	prepared_statements_evicted INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS locks (
+	locktype  TEXT NOT NULL,
+	datname   TEXT NOT NULL,
+	classid   INTEGER,
+	objid     INTEGER,
+	objsubid  INTEGER,
+	pid       INTEGER NOT NULL,
+	mode      TEXT NOT NULL,
+	granted   INTEGER NOT NULL,
+	waitstart TEXT
+);
+CREATE TABLE IF NOT EXISTS init_scripts (
+	database   TEXT NOT NULL,
+	script     TEXT NOT NULL,
+	checksum   TEXT NOT NULL,
+	applied_at TEXT NOT NULL
+);
+`
+
+// Database describes a database in the data directory.
+type Database struct {
+	Name         string
+	Path         string
+	SizeBytes    int64
+	WALSizeBytes int64
+	JournalMode  string
+	Connections  int
+
+	// SQLite schema version, incremented by each schema change and persisted in the
+	// database file. Nil if the database wasn't opened since the server started.
+	SchemaVersion *int64
+
+	// Limit of the size of the database file and its WAL, 0 for no limit.
+	QuotaBytes int64
+}
+
+// TableUsage counts the scans of a table, as pg_stat_user_tables does.
+type TableUsage struct {
+	Database string
+	Table    string
+	SeqScan  int64
+	IdxScan  int64
+}
+
+// IndexUsage counts the scans of an index, as pg_stat_user_indexes does.
+type IndexUsage struct {
+	Database string
+	Table    string
+	Index    string
+	IdxScan  int64
+}
+
+// DatabaseUsage is the resource usage of a database since the server started.
+type DatabaseUsage struct {
+	Database    string
+	Statements  int64
+	RowsRead    int64 // Rows returned by queries.
+	RowsWritten int64 // Rows inserted, updated and deleted.
+	ExecTime    time.Duration
+	RateLimited int64   // Statements rejected over the rate limit.
+	RateLimit   float64 // Statements per second, 0 for no limit.
+	SizeBytes   int64   // Size of the database file and its WAL.
+}
+
+// ApplicationUsage is the resource usage of the sessions of an application since the
+// server started, by their application_name setting.
+type ApplicationUsage struct {
+	ApplicationName string
+	Statements      int64
+	RowsRead        int64 // Rows returned by queries.
+	RowsWritten     int64 // Rows inserted, updated and deleted.
+	ExecTime        time.Duration
+}
+
+// Relation is a table, view or index of a database with its OID, as in pg_class.
+type Relation struct {
+	OID      uint32
+	Database string
+	Schema   string
+	Name     string
+	Kind     string // "r" for tables, "v" for views, "m" for materialized views and "i" for indexes.
+}
+
+// MatView is a materialized view of a database, as in pg_matviews.
+type MatView struct {
+	Database   string
+	Schema     string
+	Name       string
+	Populated  bool
+	Definition string
+}
+
+// Function is a SQL function or procedure of a database.
+type Function struct {
+	Database string
+	Name     string
+	Kind     string // "function", "procedure" or "trigger".
+	Params   string // Parameters as a JSON array.
+	Body     string
+}
+
+// Activity is a client session, as in pg_stat_activity.
+type Activity struct {
+	PID             int32
+	Database        string
+	User            string
+	ApplicationName string
+	ClientAddr      string // Empty for unix socket clients.
+	ClientPort      int    // -1 for unix socket clients.
+
+	// Start times, zero if the session has not started any transaction or query.
+	BackendStart time.Time
+	XactStart    time.Time
+	QueryStart   time.Time
+	StateChange  time.Time
+
+	State string // "active", "idle" or "idle in transaction".
+	Query string // Current query, or the last one of idle sessions.
+
+	// Prepared statements of the session: their number, approximate memory and the
+	// number of statements evicted over the limit of the server.
+	PreparedStmts        int64
+	PreparedStmtsBytes   int64
+	PreparedStmtsEvicted int64
+}
+
+// Lock is a lock held or awaited by a session, as in pg_locks.
+type Lock struct {
+	Type     string // "database" for the write lock of a database, "advisory" for advisory locks.
+	Database string
+
+	// Key of advisory locks, as PostgreSQL splits it: the high and low 32 bits of bigint
+	// keys with ObjSubID 1, the pair of integer keys with ObjSubID 2.
+	ClassID  uint32
+	ObjID    uint32
+	ObjSubID int
+
+	PID       int32
+	Mode      string    // "ExclusiveLock" or "ShareLock".
+	Granted   bool      // The session holds the lock, it waits for it otherwise.
+	WaitStart time.Time // Zero for granted locks.
+}
+
+// InitScript is an init script applied to a database when its file was created.
+type InitScript struct {
+	Database  string
+	Script    string // Name of the script without the .sql extension.
+	Checksum  string // SHA-256 of the SQL, hex encoded.
+	AppliedAt time.Time
+}
+
+// SysDB is the connection to the system database, it keeps the in-memory
+// database alive while open.
+type SysDB struct {
+	db  *sql.DB
+	dsn string
+}
+
+// Open creates the system database identified by id and its tables.
+func Open(ctx context.Context, id string) (*SysDB, error) {
+	dsn := sqlite.SystemDSN(id)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection that is never closed holds the in-memory database.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create %s schema: %w", sqlite.SystemSchema, err)
+	}
+	return &SysDB{db: db, dsn: dsn}, nil
+}
+
+// DSN returns the data source name to attach the system database with.
+func (s *SysDB) DSN() string {
+	return s.dsn
+}
+
+// Close releases the system database, its contents are lost.
+func (s *SysDB) Close() error {
+	return s.db.Close()
+}
+
+// SetServerInfo sets the server information entries.
+func (s *SysDB) SetServerInfo(ctx context.Context, info map[string]string) error {
+	return s.replace(ctx, "server", func(tx *sql.Tx) error {
+		for name, value := range info {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO server (name, value) VALUES (?, ?)`, name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetDatabases replaces the list of databases.
+func (s *SysDB) SetDatabases(ctx context.Context, databases []Database) error {
+	return s.replace(ctx, "databases", func(tx *sql.Tx) error {
+		for _, d := range databases {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO databases (name, path, size_bytes, wal_size_bytes, journal_mode, connections, schema_version, quota_bytes)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, d.Name, d.Path, d.SizeBytes, d.WALSizeBytes, d.JournalMode, d.Connections, d.SchemaVersion, d.QuotaBytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetStats replaces the server statistics counters.
+func (s *SysDB) SetStats(ctx context.Context, stats map[string]int64) error {
+	return s.replace(ctx, "stats", func(tx *sql.Tx) error {
+		for name, value := range stats {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO stats (name, value) VALUES (?, ?)`, name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetUsage replaces the table and index usage statistics.
+func (s *SysDB) SetUsage(ctx context.Context, tables []TableUsage, indexes []IndexUsage) error {
+	if err := s.replace(ctx, "stat_user_tables", func(tx *sql.Tx) error {
+		for _, t := range tables {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO stat_user_tables (database, relname, seq_scan, idx_scan)
+				VALUES (?, ?, ?, ?)`, t.Database, t.Table, t.SeqScan, t.IdxScan); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return s.replace(ctx, "stat_user_indexes", func(tx *sql.Tx) error {
+		for _, i := range indexes {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO stat_user_indexes (database, relname, indexrelname, idx_scan)
+				VALUES (?, ?, ?, ?)`, i.Database, i.Table, i.Index, i.IdxScan); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetDatabaseUsage replaces the resource usage of databases.
+func (s *SysDB) SetDatabaseUsage(ctx context.Context, usage []DatabaseUsage) error {
+	return s.replace(ctx, "stat_databases", func(tx *sql.Tx) error {
+		for _, u := range usage {
+			// Execution times are in milliseconds, as in pg_stat_statements.
+			if _, err := tx.ExecContext(ctx, `INSERT INTO stat_databases (database, statements, rows_read, rows_written, exec_time, rate_limited, rate_limit, size_bytes)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, u.Database, u.Statements, u.RowsRead, u.RowsWritten, float64(u.ExecTime)/float64(time.Millisecond),
+				u.RateLimited, u.RateLimit, u.SizeBytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetApplicationUsage replaces the resource usage of applications.
+func (s *SysDB) SetApplicationUsage(ctx context.Context, usage []ApplicationUsage) error {
+	return s.replace(ctx, "stat_applications", func(tx *sql.Tx) error {
+		for _, u := range usage {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO stat_applications (application_name, statements, rows_read, rows_written, exec_time)
+				VALUES (?, ?, ?, ?, ?)`, u.ApplicationName, u.Statements, u.RowsRead, u.RowsWritten,
+				float64(u.ExecTime)/float64(time.Millisecond)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetRelations replaces the relations and their OIDs.
+func (s *SysDB) SetRelations(ctx context.Context, relations []Relation) error {
+	return s.replace(ctx, "relations", func(tx *sql.Tx) error {
+		for _, r := range relations {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO relations (oid, database, nspname, relname, relkind)
+				VALUES (?, ?, ?, ?, ?)`, r.OID, r.Database, r.Schema, r.Name, r.Kind); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetMatViews replaces the materialized views.
+func (s *SysDB) SetMatViews(ctx context.Context, views []MatView) error {
+	return s.replace(ctx, "matviews", func(tx *sql.Tx) error {
+		for _, v := range views {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO matviews (database, schemaname, matviewname, ispopulated, definition)
+				VALUES (?, ?, ?, ?, ?)`, v.Database, v.Schema, v.Name, v.Populated, v.Definition); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetFunctions replaces the functions.
+func (s *SysDB) SetFunctions(ctx context.Context, functions []Function) error {
+	return s.replace(ctx, "functions", func(tx *sql.Tx) error {
+		for _, f := range functions {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO functions (database, name, kind, params, body)
+				VALUES (?, ?, ?, ?, ?)`, f.Database, f.Name, f.Kind, f.Params, f.Body); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetActivity replaces the client sessions.
+func (s *SysDB) SetActivity(ctx context.Context, sessions []Activity) error {
+	return s.replace(ctx, "stat_activity", func(tx *sql.Tx) error {
+		for _, a := range sessions {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO stat_activity (pid, datname, usename, application_name, client_addr, client_port,
+				backend_start, xact_start, query_start, state_change, state, query,
+				prepared_statements, prepared_statements_bytes, prepared_statements_evicted)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, a.PID, nullString(a.Database), nullString(a.User), a.ApplicationName,
+				nullString(a.ClientAddr), a.ClientPort, timestamp(a.BackendStart), timestamp(a.XactStart), timestamp(a.QueryStart),
+				timestamp(a.StateChange), a.State, a.Query, a.PreparedStmts, a.PreparedStmtsBytes, a.PreparedStmtsEvicted); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetLocks replaces the locks held and awaited by sessions.
+func (s *SysDB) SetLocks(ctx context.Context, locks []Lock) error {
+	return s.replace(ctx, "locks", func(tx *sql.Tx) error {
+		for _, l := range locks {
+			// Only advisory locks have keys.
+			var classID, objID, objSubID sql.NullInt64
+			if l.Type == "advisory" {
+				classID = sql.NullInt64{Int64: int64(l.ClassID), Valid: true}
+				objID = sql.NullInt64{Int64: int64(l.ObjID), Valid: true}
+				objSubID = sql.NullInt64{Int64: int64(l.ObjSubID), Valid: true}
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO locks (locktype, datname, classid, objid, objsubid, pid, mode, granted, waitstart)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, l.Type, l.Database, classID, objID, objSubID, l.PID, l.Mode,
+				l.Granted, timestamp(l.WaitStart)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetInitScripts replaces the init scripts applied to databases.
+func (s *SysDB) SetInitScripts(ctx context.Context, scripts []InitScript) error {
+	return s.replace(ctx, "init_scripts", func(tx *sql.Tx) error {
+		for _, i := range scripts {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO init_scripts (database, script, checksum, applied_at)
+				VALUES (?, ?, ?, ?)`, i.Database, i.Script, i.Checksum, timestamp(i.AppliedAt)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Returns NULL for empty strings.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Returns a time formatted as PostgreSQL formats timestamptz values, NULL for zero times.
+func timestamp(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format("2006-01-02 15:04:05.999999Z07:00"), Valid: true}
+}
+
+// Replaces all rows of a table in a single transaction.
+func (s *SysDB) replace(ctx context.Context, table string, fill func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+		return err
+	}
+	if err := fill(tx); err != nil {
+		return fmt.Errorf("%s.%s: %w", sqlite.SystemSchema, table, err)
+	}
+	return tx.Commit()
+}
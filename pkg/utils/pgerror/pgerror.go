@@ -0,0 +1,282 @@
+// Package pgerror translates errors into PostgreSQL error responses, with the
+// SQLSTATE codes and fields clients and ORMs rely on to handle them.
+package pgerror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgproto3/v2"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Severities of error and notice responses.
+const (
+	SeverityError   = "ERROR"   // Aborts the current statement.
+	SeverityFatal   = "FATAL"   // Aborts the session.
+	SeverityWarning = "WARNING" // Reported in a notice, the statement completes.
+)
+
+// SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html.
+const (
+	Warning                      = "01000"
+	ConnectionRejected           = "08004"
+	ConnectionFailure            = "08006"
+	ProtocolViolation            = "08P01"
+	FeatureNotSupported          = "0A000"
+	InvalidParameterValue        = "22023"
+	IntegrityConstraintViolation = "23000"
+	NotNullViolation             = "23502"
+	ForeignKeyViolation          = "23503"
+	UniqueViolation              = "23505"
+	CheckViolation               = "23514"
+	BadCopyFileFormat            = "22P04"
+	ActiveSQLTransaction         = "25001"
+	ReadOnlySQLTransaction       = "25006"
+	NoActiveSQLTransaction       = "25P01"
+	InvalidSQLStatementName      = "26000"
+	SerializationFailure         = "40001"
+	DeadlockDetected             = "40P01"
+	InvalidAuthorization         = "28000"
+	InvalidCatalogName           = "3D000"
+	InvalidSchemaName            = "3F000"
+	SyntaxError                  = "42601"
+	InsufficientPrivilege        = "42501"
+	UndefinedColumn              = "42703"
+	UndefinedFunction            = "42883"
+	UndefinedTable               = "42P01"
+	UndefinedObject              = "42704"
+	UndefinedParameter           = "42P02"
+	DuplicateObject              = "42710"
+	DuplicateFunction            = "42723"
+	DuplicateTable               = "42P07"
+	DuplicateSchema              = "42P06"
+	DuplicateDatabase            = "42P04"
+	ReservedName                 = "42939"
+	DatatypeMismatch             = "42804"
+	WrongObjectType              = "42809"
+	InvalidFunctionDefinition    = "42P13"
+	DependentObjectsStillExist   = "2BP01"
+	InsufficientResources        = "53000"
+	DiskFull                     = "53100"
+	OutOfMemory                  = "53200"
+	TooManyConnections           = "53300"
+	ProgramLimitExceeded         = "54000"
+	ObjectNotInPrerequisiteState = "55000"
+	ObjectInUse                  = "55006"
+	CantChangeRuntimeParam       = "55P02"
+	LockNotAvailable             = "55P03"
+	QueryCanceled                = "57014"
+	AdminShutdown                = "57P01"
+	IOError                      = "58030"
+	UndefinedFile                = "58P01"
+	InternalError                = "XX000"
+	DataCorrupted                = "XX001"
+	FDWInvalidOptionName         = "HV00D"
+	FDWUnableToEstablishConn     = "HV00N"
+)
+
+// Error is an error reported to clients with its SQLSTATE code.
+type Error struct {
+	Severity       string
+	Code           string
+	Message        string
+	Detail         string
+	Hint           string
+	Position       int32  // Character position in the query, starting at 1, 0 if unknown.
+	Where          string // Context of the error, e.g. the line of COPY data.
+	TableName      string
+	ColumnName     string
+	ConstraintName string
+}
+
+// New returns an error of the SQLSTATE code with the ERROR severity.
+func New(code, message string) *Error {
+	return &Error{Severity: SeverityError, Code: code, Message: message}
+}
+
+// Newf returns an error of the SQLSTATE code with a formatted message.
+func Newf(code, format string, args ...interface{}) *Error {
+	return New(code, fmt.Sprintf(format, args...))
+}
+
+// Fatal returns an error of the SQLSTATE code with the FATAL severity.
+func Fatal(code, message string) *Error {
+	return &Error{Severity: SeverityFatal, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Response returns the error response message of the error.
+func (e *Error) Response() *pgproto3.ErrorResponse {
+	return &pgproto3.ErrorResponse{
+		Severity:            e.Severity,
+		SeverityUnlocalized: e.Severity,
+		Code:                e.Code,
+		Message:             e.Message,
+		Detail:              e.Detail,
+		Hint:                e.Hint,
+		Position:            e.Position,
+		Where:               e.Where,
+		TableName:           e.TableName,
+		ColumnName:          e.ColumnName,
+		ConstraintName:      e.ConstraintName,
+	}
+}
+
+// Notice returns the notice response message of the error, for warnings.
+func (e *Error) Notice() *pgproto3.NoticeResponse {
+	return (*pgproto3.NoticeResponse)(e.Response())
+}
+
+// Convert returns the PostgreSQL error of err. Errors of this package are returned as is,
+// SQLite errors are translated from their result codes, canceled statements are canceled
+// queries, any other error is an internal error.
+// The message is the one of err, including the context it was wrapped with.
+func Convert(err error) *Error {
+	var pgErr *Error
+	if errors.As(err, &pgErr) {
+		if pgErr.Message == err.Error() {
+			return pgErr
+		}
+		converted := *pgErr
+		converted.Message = err.Error()
+		return &converted
+	}
+
+	// Statements are canceled by their context.
+	if errors.Is(err, context.Canceled) {
+		return New(QueryCanceled, "canceling statement due to user request")
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		converted := fromSQLite(sqliteErr)
+		converted.Message = err.Error()
+		return converted
+	}
+	return New(InternalError, err.Error())
+}
+
+// Response returns the error response message of err.
+func Response(err error) *pgproto3.ErrorResponse {
+	return Convert(err).Response()
+}
+
+// SQLSTATE codes of SQLite primary result codes.
+var sqliteCodes = map[sqlite3.ErrNo]string{
+	sqlite3.ErrPerm:       InsufficientPrivilege,
+	sqlite3.ErrAuth:       InsufficientPrivilege,
+	sqlite3.ErrBusy:       LockNotAvailable,
+	sqlite3.ErrLocked:     LockNotAvailable,
+	sqlite3.ErrNomem:      OutOfMemory,
+	sqlite3.ErrReadonly:   ReadOnlySQLTransaction,
+	sqlite3.ErrInterrupt:  QueryCanceled,
+	sqlite3.ErrIoErr:      IOError,
+	sqlite3.ErrCorrupt:    DataCorrupted,
+	sqlite3.ErrNotADB:     DataCorrupted,
+	sqlite3.ErrFull:       DiskFull,
+	sqlite3.ErrCantOpen:   UndefinedFile,
+	sqlite3.ErrTooBig:     ProgramLimitExceeded,
+	sqlite3.ErrConstraint: IntegrityConstraintViolation,
+	sqlite3.ErrMismatch:   DatatypeMismatch,
+	sqlite3.ErrRange:      ProtocolViolation,
+}
+
+// SQLSTATE codes of SQLite extended result codes of constraint violations.
+var sqliteConstraintCodes = map[sqlite3.ErrNoExtended]string{
+	sqlite3.ErrConstraintUnique:     UniqueViolation,
+	sqlite3.ErrConstraintPrimaryKey: UniqueViolation,
+	sqlite3.ErrConstraintRowID:      UniqueViolation,
+	sqlite3.ErrConstraintForeignKey: ForeignKeyViolation,
+	sqlite3.ErrConstraintNotNull:    NotNullViolation,
+	sqlite3.ErrConstraintCheck:      CheckViolation,
+}
+
+// SQLSTATE codes of generic SQLite errors, by message.
+var sqliteMessageCodes = []struct {
+	regex *regexp.Regexp
+	code  string
+}{
+	{regexp.MustCompile(`^no such table`), UndefinedTable},
+	{regexp.MustCompile(`^relation ".+" does not exist$`), UndefinedTable},
+	{regexp.MustCompile(`^database ".+" does not exist$`), InvalidCatalogName},
+	{regexp.MustCompile(`^no such column`), UndefinedColumn},
+	{regexp.MustCompile(`^no such function`), UndefinedFunction},
+	{regexp.MustCompile(`^(table|index|view) .+ already exists$`), DuplicateTable},
+	{regexp.MustCompile(`(syntax error|^incomplete input)$`), SyntaxError},
+	{regexp.MustCompile(`^deadlock detected$`), DeadlockDetected},
+}
+
+// Matches the columns of failed constraints in SQLite messages,
+// e.g. "UNIQUE constraint failed: items.name, items.kind".
+var constraintColumnsRegex = regexp.MustCompile(`constraint failed: ((?:\w+\.\w+(?:, )?)+)$`)
+
+// Matches the name of failed CHECK constraints, e.g. "CHECK constraint failed: positive_price".
+var checkConstraintRegex = regexp.MustCompile(`CHECK constraint failed: (.+)$`)
+
+// Translates a SQLite error to a PostgreSQL error.
+func fromSQLite(err sqlite3.Error) *Error {
+	e := New(InternalError, err.Error())
+	if code, ok := sqliteCodes[err.Code]; ok {
+		e.Code = code
+	}
+
+	switch err.Code {
+	case sqlite3.ErrBusy:
+		// Transactions writing after other transactions committed since their snapshot.
+		if err.ExtendedCode == sqlite3.ErrBusySnapshot {
+			e.Code = SerializationFailure
+		}
+	case sqlite3.ErrConstraint:
+		if code, ok := sqliteConstraintCodes[err.ExtendedCode]; ok {
+			e.Code = code
+		}
+		setConstraint(e, err)
+	case sqlite3.ErrError:
+		for _, m := range sqliteMessageCodes {
+			if m.regex.MatchString(err.Error()) {
+				e.Code = m.code
+				break
+			}
+		}
+	}
+	return e
+}
+
+// Sets the table, column and constraint names of a constraint violation from the
+// SQLite message. SQLite doesn't report the names of constraints other than CHECK
+// ones, they're named as PostgreSQL names them by default.
+func setConstraint(e *Error, err sqlite3.Error) {
+	msg := err.Error()
+	if m := checkConstraintRegex.FindStringSubmatch(msg); m != nil {
+		e.ConstraintName = m[1]
+		return
+	}
+	m := constraintColumnsRegex.FindStringSubmatch(msg)
+	if m == nil {
+		return
+	}
+
+	var columns []string
+	for _, ref := range strings.Split(m[1], ", ") {
+		table, column, _ := strings.Cut(ref, ".")
+		e.TableName = table
+		columns = append(columns, column)
+	}
+	if len(columns) == 1 {
+		e.ColumnName = columns[0]
+	}
+
+	switch err.ExtendedCode {
+	case sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintRowID:
+		e.ConstraintName = e.TableName + "_pkey"
+	case sqlite3.ErrConstraintUnique:
+		e.ConstraintName = e.TableName + "_" + strings.Join(columns, "_") + "_key"
+	}
+}
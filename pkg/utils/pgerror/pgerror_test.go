@@ -0,0 +1,107 @@
+package pgerror_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite/pkg/utils/pgerror"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ = Describe("Convert", func() {
+	var db *sql.DB
+
+	BeforeEach(func() {
+		var err error
+		db, err = sql.Open("sqlite3", ":memory:?_foreign_keys=1")
+		Expect(err).NotTo(HaveOccurred())
+		db.SetMaxOpenConns(1)
+		_, err = db.Exec(`
+			CREATE TABLE kinds (id INTEGER PRIMARY KEY);
+			CREATE TABLE items (
+				id INTEGER PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				kind INTEGER REFERENCES kinds (id),
+				price REAL CONSTRAINT positive_price CHECK (price > 0)
+			);
+			INSERT INTO items (id, name) VALUES (1, 'first');`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).To(Succeed())
+	})
+
+	convert := func(query string) *pgerror.Error {
+		_, err := db.Exec(query)
+		Expect(err).To(HaveOccurred())
+		return pgerror.Convert(err)
+	}
+
+	It("Translates constraint violations", func() {
+		e := convert(`INSERT INTO items (id, name) VALUES (2, 'first')`)
+		Expect(e.Code).To(Equal(pgerror.UniqueViolation))
+		Expect(e.Severity).To(Equal(pgerror.SeverityError))
+		Expect(e.TableName).To(Equal("items"))
+		Expect(e.ColumnName).To(Equal("name"))
+		Expect(e.ConstraintName).To(Equal("items_name_key"))
+
+		e = convert(`INSERT INTO items (id, name) VALUES (1, 'second')`)
+		Expect(e.Code).To(Equal(pgerror.UniqueViolation))
+		Expect(e.ConstraintName).To(Equal("items_pkey"))
+
+		e = convert(`INSERT INTO items (id) VALUES (2)`)
+		Expect(e.Code).To(Equal(pgerror.NotNullViolation))
+		Expect(e.ColumnName).To(Equal("name"))
+
+		e = convert(`INSERT INTO items (id, name, kind) VALUES (2, 'second', 1)`)
+		Expect(e.Code).To(Equal(pgerror.ForeignKeyViolation))
+
+		e = convert(`INSERT INTO items (id, name, price) VALUES (2, 'second', -1)`)
+		Expect(e.Code).To(Equal(pgerror.CheckViolation))
+		Expect(e.ConstraintName).To(Equal("positive_price"))
+	})
+
+	It("Translates generic errors from their messages", func() {
+		Expect(convert(`SELECT * FROM missing`).Code).To(Equal(pgerror.UndefinedTable))
+		Expect(convert(`SELECT missing FROM items`).Code).To(Equal(pgerror.UndefinedColumn))
+		Expect(convert(`SELECT missing()`).Code).To(Equal(pgerror.UndefinedFunction))
+		Expect(convert(`CREATE TABLE items (id INTEGER)`).Code).To(Equal(pgerror.DuplicateTable))
+		Expect(convert(`SELEC 1`).Code).To(Equal(pgerror.SyntaxError))
+	})
+
+	It("Translates read-only databases", func() {
+		_, err := db.Exec(`PRAGMA query_only = 1`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(convert(`DELETE FROM items`).Code).To(Equal(pgerror.ReadOnlySQLTransaction))
+	})
+
+	It("Keeps the message of wrapped errors", func() {
+		_, err := db.Exec(`SELECT * FROM missing`)
+		e := pgerror.Convert(fmt.Errorf("rows: %w", err))
+		Expect(e.Code).To(Equal(pgerror.UndefinedTable))
+		Expect(e.Message).To(Equal("rows: no such table: missing"))
+
+		e = pgerror.Convert(fmt.Errorf("startup: %w", pgerror.Fatal(pgerror.InvalidCatalogName, "invalid database name")))
+		Expect(e.Code).To(Equal(pgerror.InvalidCatalogName))
+		Expect(e.Severity).To(Equal(pgerror.SeverityFatal))
+		Expect(e.Message).To(Equal("startup: invalid database name"))
+	})
+
+	It("Reports canceled statements", func() {
+		e := pgerror.Convert(fmt.Errorf("rows: %w", context.Canceled))
+		Expect(e.Code).To(Equal(pgerror.QueryCanceled))
+		Expect(e.Message).To(Equal("canceling statement due to user request"))
+	})
+
+	It("Reports other errors as internal errors", func() {
+		e := pgerror.Convert(errors.New("unexpected"))
+		Expect(e.Code).To(Equal(pgerror.InternalError))
+		Expect(e.Response().Message).To(Equal("unexpected"))
+	})
+})
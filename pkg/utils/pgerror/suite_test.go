@@ -0,0 +1,13 @@
+package pgerror_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPgError(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PgError Suite")
+}
@@ -0,0 +1,13 @@
+package kqlite_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestKqlite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Kqlite Suite")
+}
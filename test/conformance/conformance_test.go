@@ -0,0 +1,98 @@
+//go:build conformance
+
+package conformance_test
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite"
+)
+
+// An ORM smoke test, the testdata directory of the ORM is mounted on /work
+// and DATABASE_URL points to an empty database.
+type orm struct {
+	name    string
+	image   string
+	command string
+}
+
+var orms = []orm{
+	{"Django", "python:3.12-slim", "pip install -q django psycopg2-binary && python smoke.py"},
+	{"SQLAlchemy", "python:3.12-slim", "pip install -q sqlalchemy psycopg2-binary && python smoke.py"},
+	{"ActiveRecord", "ruby:3.3", "gem install -q --no-document activerecord pg && ruby smoke.rb"},
+	{"Prisma", "node:20-slim", "apt-get update -qq && apt-get install -qq -y openssl >/dev/null && " +
+		"npm install -s --no-save prisma @prisma/client && npx prisma db push --skip-generate && npx prisma generate && node smoke.js"},
+	{"GORM", "golang:1.22", "go mod tidy && go run ."},
+	{"Ecto", "elixir:1.16", "mix local.hex --force >/dev/null && mix local.rebar --force >/dev/null && elixir smoke.exs"},
+}
+
+var srv *kqlite.Server
+
+var _ = BeforeSuite(func() {
+	if _, err := exec.LookPath("docker"); err != nil {
+		Skip("docker is required to run ORM conformance tests")
+	}
+	var err error
+	// Containers share the host network, so they reach the server on the loopback interface.
+	srv, err = kqlite.New(kqlite.Options{})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(srv.Start()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	if srv != nil {
+		Expect(srv.Stop()).To(Succeed())
+	}
+})
+
+// Returns the connection URL of a database of the suite server.
+func connString(name string) string {
+	url, err := srv.ConnString(name)
+	Expect(err).NotTo(HaveOccurred())
+	return url
+}
+
+// Prints the pass/fail matrix of the ORMs.
+var _ = ReportAfterSuite("ORM matrix", func(report Report) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n%-14s %s\n", "ORM", "RESULT")
+	for _, spec := range report.SpecReports {
+		if spec.LeafNodeType == types.NodeTypeIt {
+			fmt.Fprintf(&b, "%-14s %s\n", spec.LeafNodeText, spec.State)
+		}
+	}
+	fmt.Print(b.String())
+})
+
+var _ = Describe("ORM conformance", func() {
+	entries := make([]TableEntry, 0, len(orms))
+	for _, o := range orms {
+		entries = append(entries, Entry(o.name, o))
+	}
+
+	DescribeTable("Runs migrations and CRUD smoke tests",
+		func(o orm) {
+			dir, err := filepath.Abs(filepath.Join("testdata", strings.ToLower(o.name)))
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			defer cancel()
+			cmd := exec.CommandContext(ctx, "docker", "run", "--rm", "--network", "host",
+				"-v", dir+":/work", "-w", "/work",
+				"-e", "DATABASE_URL="+connString(strings.ToLower(o.name)+".db"),
+				o.image, "sh", "-c", o.command)
+			out, err := cmd.CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), "%s smoke test failed:\n%s", o.name, out)
+		},
+		entries,
+	)
+})
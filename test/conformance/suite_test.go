@@ -0,0 +1,20 @@
+//go:build conformance
+
+// Package conformance_test runs the migrations and CRUD smoke tests of popular
+// ORMs against a kqlite server. Each ORM runs in a container of its language
+// image, so the suite needs docker and network access to fetch packages:
+//
+//	go test -tags conformance ./test/conformance/ -ginkgo.v
+package conformance_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestConformance(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Conformance Suite")
+}
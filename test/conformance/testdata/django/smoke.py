@@ -0,0 +1,50 @@
+"""Django migrations and CRUD smoke test."""
+import os
+from urllib.parse import urlparse
+
+import django
+from django.conf import settings
+
+url = urlparse(os.environ["DATABASE_URL"])
+settings.configure(
+    DATABASES={
+        "default": {
+            "ENGINE": "django.db.backends.postgresql",
+            "NAME": url.path.lstrip("/"),
+            "HOST": url.hostname,
+            "PORT": url.port,
+            "USER": url.username or "kqlite",
+            "OPTIONS": {"sslmode": "disable"},
+        }
+    },
+    INSTALLED_APPS=["django.contrib.contenttypes", "django.contrib.auth"],
+    USE_TZ=True,
+)
+django.setup()
+
+from django.core.management import call_command
+from django.db import connection, models
+
+
+class Item(models.Model):
+    name = models.CharField(max_length=100, unique=True)
+    quantity = models.IntegerField(default=0)
+    created = models.DateTimeField(auto_now_add=True)
+
+    class Meta:
+        app_label = "smoke"
+
+
+# Migrations of the contrib apps, then the schema editor for the smoke model.
+call_command("migrate", verbosity=0)
+with connection.schema_editor() as editor:
+    editor.create_model(Item)
+
+item = Item.objects.create(name="apple", quantity=3)
+assert Item.objects.get(pk=item.pk).quantity == 3
+Item.objects.filter(pk=item.pk).update(quantity=5)
+assert Item.objects.get(name="apple").quantity == 5
+assert Item.objects.count() == 1
+item.delete()
+assert not Item.objects.exists()
+print("django: ok")
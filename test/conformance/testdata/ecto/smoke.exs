@@ -0,0 +1,51 @@
+# Ecto migration and CRUD smoke test.
+Mix.install([{:ecto_sql, "~> 3.11"}, {:postgrex, ">= 0.0.0"}])
+
+# Ecto doesn't take libpq options in URLs, kqlite connections aren't encrypted anyway.
+url = System.fetch_env!("DATABASE_URL") |> String.replace(~r/\?.*$/, "")
+Application.put_env(:smoke, Smoke.Repo, url: url, pool_size: 1)
+
+defmodule Smoke.Repo do
+  use Ecto.Repo, otp_app: :smoke, adapter: Ecto.Adapters.Postgres
+end
+
+defmodule Smoke.Migration do
+  use Ecto.Migration
+
+  def change do
+    create table(:items) do
+      add :name, :string, null: false
+      add :quantity, :integer, default: 0
+      timestamps()
+    end
+
+    create unique_index(:items, [:name])
+  end
+end
+
+defmodule Smoke.Item do
+  use Ecto.Schema
+
+  schema "items" do
+    field :name, :string
+    field :quantity, :integer, default: 0
+    timestamps()
+  end
+end
+
+{:ok, _} = Smoke.Repo.start_link()
+Ecto.Migrator.run(Smoke.Repo, [{1, Smoke.Migration}], :up, all: true)
+
+alias Smoke.{Item, Repo}
+import Ecto.Query
+
+item = Repo.insert!(%Item{name: "apple", quantity: 3})
+3 = Repo.get!(Item, item.id).quantity
+item |> Ecto.Changeset.change(quantity: 5) |> Repo.update!()
+5 = Repo.get_by!(Item, name: "apple").quantity
+1 = Repo.aggregate(Item, :count)
+Repo.delete!(Repo.get!(Item, item.id))
+0 = Repo.aggregate(from(i in Item), :count)
+
+Ecto.Migrator.run(Smoke.Repo, [{1, Smoke.Migration}], :down, all: true)
+IO.puts("ecto: ok")
@@ -0,0 +1,52 @@
+// GORM auto migration and CRUD smoke test.
+package main
+
+import (
+	"log"
+	"os"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type Item struct {
+	ID       uint
+	Name     string `gorm:"uniqueIndex"`
+	Quantity int
+}
+
+func main() {
+	db, err := gorm.Open(postgres.Open(os.Getenv("DATABASE_URL")), &gorm.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Item{}); err != nil {
+		log.Fatal(err)
+	}
+
+	item := Item{Name: "apple", Quantity: 3}
+	if err := db.Create(&item).Error; err != nil {
+		log.Fatal(err)
+	}
+	var found Item
+	if err := db.First(&found, item.ID).Error; err != nil || found.Quantity != 3 {
+		log.Fatalf("read: %v %+v", err, found)
+	}
+	if err := db.Model(&found).Update("quantity", 5).Error; err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Where("name = ?", "apple").First(&found).Error; err != nil || found.Quantity != 5 {
+		log.Fatalf("update: %v %+v", err, found)
+	}
+	if err := db.Delete(&found).Error; err != nil {
+		log.Fatal(err)
+	}
+	var count int64
+	if err := db.Model(&Item{}).Count(&count).Error; err != nil || count != 0 {
+		log.Fatalf("delete: %v %d", err, count)
+	}
+	if err := db.Migrator().DropTable(&Item{}); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("gorm: ok")
+}
@@ -0,0 +1,23 @@
+// Prisma CRUD smoke test, the schema is pushed by `prisma db push`.
+const assert = require("assert");
+const { PrismaClient } = require("@prisma/client");
+
+const prisma = new PrismaClient();
+
+async function main() {
+  const item = await prisma.item.create({ data: { name: "apple", quantity: 3 } });
+  assert.strictEqual((await prisma.item.findUnique({ where: { id: item.id } })).quantity, 3);
+  await prisma.item.update({ where: { name: "apple" }, data: { quantity: 5 } });
+  assert.strictEqual((await prisma.item.findFirst({ where: { name: "apple" } })).quantity, 5);
+  assert.strictEqual(await prisma.item.count(), 1);
+  await prisma.item.delete({ where: { id: item.id } });
+  assert.strictEqual(await prisma.item.count(), 0);
+  console.log("prisma: ok");
+}
+
+main()
+  .catch((err) => {
+    console.error(err);
+    process.exitCode = 1;
+  })
+  .finally(() => prisma.$disconnect());
@@ -0,0 +1,30 @@
+# ActiveRecord migration and CRUD smoke test.
+require "active_record"
+
+ActiveRecord::Base.establish_connection(ENV.fetch("DATABASE_URL"))
+
+class CreateItems < ActiveRecord::Migration[7.1]
+  def change
+    create_table :items do |t|
+      t.string :name, null: false, index: { unique: true }
+      t.integer :quantity, default: 0
+      t.timestamps
+    end
+  end
+end
+
+class Item < ActiveRecord::Base
+end
+
+CreateItems.migrate(:up)
+
+item = Item.create!(name: "apple", quantity: 3)
+raise "create" unless Item.find(item.id).quantity == 3
+item.update!(quantity: 5)
+raise "update" unless Item.find_by(name: "apple").quantity == 5
+raise "count" unless Item.count == 1
+item.destroy!
+raise "destroy" if Item.exists?
+
+CreateItems.migrate(:down)
+puts "activerecord: ok"
@@ -0,0 +1,38 @@
+"""SQLAlchemy schema creation and CRUD smoke test."""
+import os
+
+from sqlalchemy import Integer, String, create_engine, select
+from sqlalchemy.orm import DeclarativeBase, Mapped, Session, mapped_column
+
+
+class Base(DeclarativeBase):
+    pass
+
+
+class Item(Base):
+    __tablename__ = "items"
+    id: Mapped[int] = mapped_column(Integer, primary_key=True)
+    name: Mapped[str] = mapped_column(String(100), unique=True)
+    quantity: Mapped[int] = mapped_column(Integer, default=0)
+
+
+url = os.environ["DATABASE_URL"].replace("postgres://", "postgresql+psycopg2://", 1)
+engine = create_engine(url)
+Base.metadata.create_all(engine)
+
+with Session(engine) as session:
+    session.add(Item(name="apple", quantity=3))
+    session.commit()
+
+    item = session.scalars(select(Item).where(Item.name == "apple")).one()
+    assert item.quantity == 3
+    item.quantity = 5
+    session.commit()
+    assert session.get(Item, item.id).quantity == 5
+
+    session.delete(item)
+    session.commit()
+    assert session.scalars(select(Item)).first() is None
+
+Base.metadata.drop_all(engine)
+print("sqlalchemy: ok")
@@ -0,0 +1,60 @@
+//go:build protocol
+
+package protocol_test
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// A client smoke test run in a container, the testdata directory of the client is
+// mounted on /work and DATABASE_URL points to an empty database.
+type client struct {
+	name    string
+	image   string
+	command string
+}
+
+var clients = []client{
+	{"psql", "postgres:16-alpine", `psql "$DATABASE_URL" -X -v ON_ERROR_STOP=1 -f smoke.sql`},
+	{"JDBC", "eclipse-temurin:21-jdk", "curl -fsSLo /tmp/pgjdbc.jar " +
+		"https://repo1.maven.org/maven2/org/postgresql/postgresql/42.7.3/postgresql-42.7.3.jar && " +
+		"java -cp /tmp/pgjdbc.jar Smoke.java"},
+}
+
+var _ = Describe("Clients", func() {
+	BeforeEach(func() {
+		if _, err := exec.LookPath("docker"); err != nil {
+			Skip("docker is required to run client smoke tests")
+		}
+	})
+
+	entries := make([]TableEntry, 0, len(clients))
+	for _, c := range clients {
+		entries = append(entries, Entry(c.name, c))
+	}
+
+	DescribeTable("Run smoke tests",
+		func(c client) {
+			dir, err := filepath.Abs(filepath.Join("testdata", strings.ToLower(c.name)))
+			Expect(err).NotTo(HaveOccurred())
+
+			// Containers share the host network, so they reach the server on the loopback interface.
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			defer cancel()
+			cmd := exec.CommandContext(ctx, "docker", "run", "--rm", "--network", "host",
+				"-v", dir+":/work", "-w", "/work",
+				"-e", "DATABASE_URL="+connString(strings.ToLower(c.name)+".db"),
+				c.image, "sh", "-c", c.command)
+			out, err := cmd.CombinedOutput()
+			Expect(err).NotTo(HaveOccurred(), "%s smoke test failed:\n%s", c.name, out)
+		},
+		entries,
+	)
+})
@@ -0,0 +1,155 @@
+//go:build protocol
+
+package protocol_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// A Go driver of the database/sql package.
+type driver struct {
+	name       string
+	driverName string
+}
+
+var drivers = []driver{
+	{"pgx", "pgx"},
+	{"lib/pq", "postgres"},
+}
+
+// Returns the SQLSTATE code of the error of a driver, empty if it's not an error response.
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	return ""
+}
+
+var _ = Describe("Go drivers", func() {
+	ctx := context.Background()
+
+	for _, d := range drivers {
+		Describe(d.name, Ordered, func() {
+			var db *sql.DB
+			dbname := strings.ReplaceAll(d.name, "/", "") + ".db"
+
+			BeforeAll(func() {
+				var err error
+				db, err = sql.Open(d.driverName, connString(dbname))
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterAll(func() {
+				Expect(db.Close()).To(Succeed())
+			})
+
+			It("Completes the startup handshake", func() {
+				Expect(db.PingContext(ctx)).To(Succeed())
+
+				var database, version string
+				Expect(db.QueryRowContext(ctx, `SELECT current_database(), version()`).Scan(&database, &version)).To(Succeed())
+				Expect(database).To(Equal(dbname))
+				Expect(version).To(HavePrefix("PostgreSQL "))
+			})
+
+			It("Executes statements with the extended protocol", func() {
+				_, err := db.ExecContext(ctx, `CREATE TABLE items (id integer PRIMARY KEY, name text NOT NULL UNIQUE, price real)`)
+				Expect(err).NotTo(HaveOccurred())
+
+				for _, item := range []struct {
+					id    int
+					name  string
+					price interface{}
+				}{{1, "one", 1.5}, {2, "two", nil}, {3, "three", 3.25}} {
+					result, err := db.ExecContext(ctx, `INSERT INTO items (id, name, price) VALUES ($1, $2, $3)`, item.id, item.name, item.price)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(result.RowsAffected()).To(Equal(int64(1)))
+				}
+
+				// Prepared statements are executed more than once.
+				stmt, err := db.PrepareContext(ctx, `SELECT name, price FROM items WHERE id = $1`)
+				Expect(err).NotTo(HaveOccurred())
+				defer stmt.Close()
+				var name string
+				var price sql.NullFloat64
+				Expect(stmt.QueryRowContext(ctx, 1).Scan(&name, &price)).To(Succeed())
+				Expect(name).To(Equal("one"))
+				Expect(price).To(Equal(sql.NullFloat64{Float64: 1.5, Valid: true}))
+				Expect(stmt.QueryRowContext(ctx, 2).Scan(&name, &price)).To(Succeed())
+				Expect(name).To(Equal("two"))
+				Expect(price.Valid).To(BeFalse())
+
+				rows, err := db.QueryContext(ctx, `SELECT name FROM items WHERE name <> $1 ORDER BY id`, "two")
+				Expect(err).NotTo(HaveOccurred())
+				var names []string
+				for rows.Next() {
+					Expect(rows.Scan(&name)).To(Succeed())
+					names = append(names, name)
+				}
+				Expect(rows.Err()).NotTo(HaveOccurred())
+				Expect(names).To(Equal([]string{"one", "three"}))
+			})
+
+			It("Reports errors with their SQLSTATE", func() {
+				_, err := db.ExecContext(ctx, `SELEC 1`)
+				Expect(sqlState(err)).To(Equal("42601"), "%v", err)
+				_, err = db.ExecContext(ctx, `SELECT * FROM missing WHERE id = $1`, 1)
+				Expect(sqlState(err)).To(Equal("42P01"), "%v", err)
+				_, err = db.ExecContext(ctx, `INSERT INTO items (id, name) VALUES ($1, $2)`, 4, "one")
+				Expect(sqlState(err)).To(Equal("23505"), "%v", err)
+
+				// The session is usable after errors.
+				var one int
+				Expect(db.QueryRowContext(ctx, `SELECT 1`).Scan(&one)).To(Succeed())
+				Expect(one).To(Equal(1))
+			})
+
+			It("Commits and rolls back transactions", func() {
+				count := func() int {
+					var n int
+					Expect(db.QueryRowContext(ctx, `SELECT count(*) FROM items`).Scan(&n)).To(Succeed())
+					return n
+				}
+				before := count()
+
+				tx, err := db.BeginTx(ctx, nil)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = tx.ExecContext(ctx, `INSERT INTO items (id, name) VALUES ($1, $2)`, 10, "rolled back")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(tx.Rollback()).To(Succeed())
+				Expect(count()).To(Equal(before))
+
+				tx, err = db.BeginTx(ctx, nil)
+				Expect(err).NotTo(HaveOccurred())
+				_, err = tx.ExecContext(ctx, `INSERT INTO items (id, name) VALUES ($1, $2)`, 11, "committed")
+				Expect(err).NotTo(HaveOccurred())
+				var name string
+				Expect(tx.QueryRowContext(ctx, `SELECT name FROM items WHERE id = $1`, 11).Scan(&name)).To(Succeed())
+				Expect(name).To(Equal("committed"))
+				Expect(tx.Commit()).To(Succeed())
+				Expect(count()).To(Equal(before + 1))
+
+				tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+				Expect(err).NotTo(HaveOccurred())
+				_, err = tx.ExecContext(ctx, `DELETE FROM items`)
+				Expect(sqlState(err)).To(Equal("25006"), "%v", err)
+				Expect(tx.Rollback()).To(Succeed())
+				Expect(count()).To(Equal(before + 1))
+			})
+		})
+	}
+})
@@ -0,0 +1,46 @@
+//go:build protocol
+
+// Package protocol_test checks the conformance of the kqlite server to the PostgreSQL
+// wire protocol with real client drivers: the startup handshake, the extended query
+// protocol, error responses and transactions. Go drivers run in process, psql and JDBC
+// run in containers of their images and are skipped without docker:
+//
+//	go test -tags protocol ./test/protocol/ -ginkgo.v
+package protocol_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kqlite/kqlite"
+)
+
+func TestProtocol(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Protocol Suite")
+}
+
+var srv *kqlite.Server
+
+var _ = BeforeSuite(func() {
+	var err error
+	// The server listens on a random port of the loopback interface.
+	srv, err = kqlite.New(kqlite.Options{})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(srv.Start()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	if srv != nil {
+		Expect(srv.Stop()).To(Succeed())
+	}
+})
+
+// Returns the connection URL of a database of the suite server.
+func connString(name string) string {
+	url, err := srv.ConnString(name)
+	Expect(err).NotTo(HaveOccurred())
+	return url
+}
@@ -0,0 +1,69 @@
+// JDBC smoke test: prepared statements, errors and transactions.
+import java.sql.Connection;
+import java.sql.DriverManager;
+import java.sql.PreparedStatement;
+import java.sql.ResultSet;
+import java.sql.SQLException;
+import java.sql.Statement;
+
+public class Smoke {
+    static void check(boolean ok, String message) {
+        if (!ok) {
+            throw new AssertionError(message);
+        }
+    }
+
+    static int count(Connection conn) throws SQLException {
+        try (Statement stmt = conn.createStatement(); ResultSet rs = stmt.executeQuery("SELECT count(*) FROM items")) {
+            rs.next();
+            return rs.getInt(1);
+        }
+    }
+
+    public static void main(String[] args) throws Exception {
+        String url = "jdbc:" + System.getenv("DATABASE_URL").replaceFirst("^postgres://", "postgresql://");
+        try (Connection conn = DriverManager.getConnection(url, "kqlite", "")) {
+            try (Statement stmt = conn.createStatement()) {
+                stmt.execute("CREATE TABLE items (id integer PRIMARY KEY, name text NOT NULL UNIQUE)");
+            }
+
+            // Statements are prepared on the server once executed a few times.
+            try (PreparedStatement insert = conn.prepareStatement("INSERT INTO items (id, name) VALUES (?, ?)")) {
+                for (int i = 1; i <= 10; i++) {
+                    insert.setInt(1, i);
+                    insert.setString(2, "item " + i);
+                    check(insert.executeUpdate() == 1, "insert " + i);
+                }
+            }
+            try (PreparedStatement select = conn.prepareStatement("SELECT name FROM items WHERE id = ?")) {
+                for (int i = 1; i <= 10; i++) {
+                    select.setInt(1, i);
+                    try (ResultSet rs = select.executeQuery()) {
+                        check(rs.next() && rs.getString(1).equals("item " + i), "select " + i);
+                    }
+                }
+            }
+
+            try (Statement stmt = conn.createStatement()) {
+                stmt.executeQuery("SELECT * FROM missing");
+                check(false, "query of a missing table succeeded");
+            } catch (SQLException e) {
+                check("42P01".equals(e.getSQLState()), "unexpected SQLSTATE " + e.getSQLState());
+            }
+
+            conn.setAutoCommit(false);
+            try (Statement stmt = conn.createStatement()) {
+                stmt.executeUpdate("INSERT INTO items (id, name) VALUES (11, 'rolled back')");
+            }
+            conn.rollback();
+            check(count(conn) == 10, "rolled back insert is visible");
+            try (Statement stmt = conn.createStatement()) {
+                stmt.executeUpdate("INSERT INTO items (id, name) VALUES (12, 'committed')");
+            }
+            conn.commit();
+            conn.setAutoCommit(true);
+            check(count(conn) == 11, "committed insert is not visible");
+        }
+        System.out.println("JDBC smoke test passed");
+    }
+}
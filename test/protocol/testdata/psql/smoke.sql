@@ -0,0 +1,35 @@
+-- psql smoke test: simple queries, errors and transactions.
+CREATE TABLE items (id integer PRIMARY KEY, name text NOT NULL UNIQUE);
+INSERT INTO items (id, name) VALUES (1, 'one'), (2, 'two');
+
+SELECT count(*) = 2 AS ok FROM items \gset
+\if :ok
+\else
+  \echo 'unexpected number of items'
+  SELECT * FROM unexpected_item_count;
+\endif
+
+-- Errors are reported with their SQLSTATE, the session goes on.
+\set ON_ERROR_STOP off
+INSERT INTO items (id, name) VALUES (3, 'one');
+\set ON_ERROR_STOP on
+SELECT :'LAST_ERROR_SQLSTATE' = '23505' AS ok \gset
+\if :ok
+\else
+  \echo 'unexpected error' :LAST_ERROR_SQLSTATE
+  SELECT * FROM unexpected_error;
+\endif
+
+BEGIN;
+INSERT INTO items (id, name) VALUES (3, 'three');
+ROLLBACK;
+BEGIN;
+INSERT INTO items (id, name) VALUES (4, 'four');
+COMMIT;
+
+SELECT string_agg(name, ',' ORDER BY id) = 'one,two,four' AS ok FROM items \gset
+\if :ok
+\else
+  \echo 'unexpected items after transactions'
+  SELECT * FROM unexpected_items;
+\endif